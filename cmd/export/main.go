@@ -0,0 +1,73 @@
+// Command export writes the cached artifacts under the given key prefixes, every artifact if there are none,
+// to a portable tar.gz bundle. It reads the same environment as the server.
+//
+//	export -o bundle.tar.gz providers/registry.terraform.io/hashicorp
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/bundle"
+	"cachetf/internal/config"
+	"cachetf/internal/storage"
+	"cachetf/pkg/logger"
+)
+
+func main() {
+	output := flag.String("o", "-", "file the bundle is written to, - for stdout")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+
+	// Initialize logger, logs never go to stdout where the bundle may be written
+	logger.InitLogger(cfg.LogLevel)
+	logrus.SetOutput(os.Stderr)
+	// The bundle is written to stdout, so the logs go to stderr
+	if err := logger.SetBackend(cfg.LogBackend, os.Stderr); err != nil {
+		logrus.Fatalf("Failed to select logging backend: %v", err)
+	}
+
+	// Initialize storage, tiered storage keeps every file in S3
+	storageType := cfg.StorageType
+	if storageType == config.StorageTypeTiered {
+		storageType = config.StorageTypeS3
+	}
+	// Files staged by the server are uploaded by the server, the export only reads the bucket
+	cfg.S3.WriteBehindDir = ""
+	store, err := storage.New(string(storageType), cfg.StorageOptions())
+	if err != nil {
+		logrus.Fatalf("Failed to initialize %s storage: %v", storageType, err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			logrus.Fatalf("Failed to create bundle: %v", err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if _, err := bundle.NewExporter(store, logrus.StandardLogger()).Export(ctx, w, flag.Args()); err != nil {
+		if *output != "-" {
+			os.Remove(*output)
+		}
+		logrus.Fatalf("Export failed: %v", err)
+	}
+}
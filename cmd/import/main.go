@@ -0,0 +1,79 @@
+// Command import writes the artifacts of a tar.gz bundle created by export to the configured storage,
+// after checking every file against the checksums of the bundle manifest. It reads the same environment
+// as the server.
+//
+//	import bundle.tar.gz
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/bundle"
+	"cachetf/internal/config"
+	"cachetf/internal/storage"
+	"cachetf/pkg/logger"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s <bundle.tar.gz | ->\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+
+	// Initialize logger
+	logger.InitLogger(cfg.LogLevel)
+	if err := logger.SetBackend(cfg.LogBackend, os.Stdout); err != nil {
+		logrus.Fatalf("Failed to select logging backend: %v", err)
+	}
+
+	// Initialize storage, tiered storage keeps every file in S3
+	storageType := cfg.StorageType
+	if storageType == config.StorageTypeTiered {
+		storageType = config.StorageTypeS3
+	}
+	// The command exits once done, so its writes can't be uploaded in the background
+	cfg.S3.WriteBehindDir = ""
+	store, err := storage.New(string(storageType), cfg.StorageOptions())
+	if err != nil {
+		logrus.Fatalf("Failed to initialize %s storage: %v", storageType, err)
+	}
+
+	var r io.Reader = os.Stdin
+	if path := flag.Arg(0); path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			logrus.Fatalf("Failed to open bundle: %v", err)
+		}
+		defer file.Close()
+		r = file
+	}
+
+	result, err := bundle.NewImporter(store, logrus.StandardLogger()).Import(ctx, r)
+	if err != nil {
+		logrus.Fatalf("Import failed: %v", err)
+	}
+	fmt.Printf("Imported %d of %d files, %d already cached\n", result.Imported, result.Files, result.Skipped)
+}
@@ -0,0 +1,65 @@
+// Command migrate moves the artifacts cached before keys had a scheme segment under providers/.
+// It reads the same environment as the server, which should be stopped while it runs.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/config"
+	"cachetf/internal/layout"
+	"cachetf/internal/metadata"
+	"cachetf/internal/provenance"
+	"cachetf/internal/storage"
+	"cachetf/pkg/logger"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "only log the keys that would be moved")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+
+	// Initialize logger
+	logger.InitLogger(cfg.LogLevel)
+	if err := logger.SetBackend(cfg.LogBackend, os.Stdout); err != nil {
+		logrus.Fatalf("Failed to select logging backend: %v", err)
+	}
+
+	// Initialize storage, tiered storage keeps every file in S3
+	storageType := cfg.StorageType
+	if storageType == config.StorageTypeTiered {
+		storageType = config.StorageTypeS3
+	}
+	// The command exits once done, so its writes can't be uploaded in the background
+	cfg.S3.WriteBehindDir = ""
+	store, err := storage.New(string(storageType), cfg.StorageOptions())
+	if err != nil {
+		logrus.Fatalf("Failed to initialize %s storage: %v", storageType, err)
+	}
+
+	origins := provenance.NewStore(metadata.NewStore(store, logrus.StandardLogger()))
+	migrator := layout.NewMigrator(store, origins, logrus.StandardLogger())
+	migrator.DryRun = *dryRun
+
+	result, err := migrator.Migrate(ctx)
+	if err != nil {
+		logrus.Fatalf("Migration failed: %v", err)
+	}
+	if result.Failed > 0 {
+		logrus.Fatalf("%d files couldn't be moved, run the migration again", result.Failed)
+	}
+}
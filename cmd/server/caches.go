@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/config"
+	"cachetf/internal/eviction"
+	"cachetf/internal/metadata"
+	"cachetf/internal/pins"
+	"cachetf/internal/provenance"
+	routes "cachetf/internal/routes"
+	"cachetf/internal/storage"
+	"cachetf/internal/transparency"
+)
+
+// setupCache serves an additional cache under its URI prefix and starts its background jobs.
+// Authentication, upstream requests and verification are shared with the primary cache,
+// while artifacts, metadata, pins and retention are the cache's own. The index requests are persisted until
+// indexHits is done.
+func setupCache(ctx context.Context, router *gin.Engine, cfg *config.Config, cacheCfg config.CacheConfig, primary *routes.Config, indexHits *sync.WaitGroup) {
+	logger := logrus.WithField("cache", cacheCfg.Name)
+
+	// Additional caches only support local, S3 and tiered storage
+	store, err := newStorage(ctx, cacheCfg.StorageType, cacheCfg.StorageOptions(), cfg.StorageStartupTimeout, cfg.StorageSelfTest)
+	if err != nil {
+		logger.Fatalf("Failed to initialize storage: %v", err)
+	}
+	// Only caches in a bucket redirect to presigned URLs, the others serve the binaries themselves
+	registryOpts := primary.Registry
+	registryOpts.Presigner = nil
+	if presigner, ok := store.(storage.Presigner); ok && cfg.PresignedRedirectTTL > 0 {
+		registryOpts.Presigner = presigner
+	}
+	guarded, _ := store.(storage.DiskGuarded)
+	backend := store
+	if primary.Readiness != nil {
+		primary.Readiness.Add(cacheCfg.Name, backend)
+	}
+
+	store = storage.NewMetricsWrapper(store, string(cacheCfg.StorageType))
+	store = newMemoryCache(store, cfg.MemoryCache)
+
+	// Track file accesses so the least recently used files can be evicted
+	var tracker *eviction.AccessTracker
+	if cacheCfg.Eviction.MaxSizeBytes > 0 {
+		tracker = eviction.NewAccessTracker(store)
+		store = tracker
+	}
+
+	meta := metadata.NewStore(store, logrus.StandardLogger())
+
+	// Pins are static, the pinning API only manages the primary cache
+	staticPins, _ := pins.ParsePins(cacheCfg.Pins)
+	pinSet := pins.NewSet(staticPins)
+	pinSet.UseKeys(primary.Keys)
+
+	var transparencyLog *transparency.Log
+	if cfg.TransparencyLog {
+		transparencyLog = transparency.NewLog(meta, logrus.StandardLogger())
+		if err := transparencyLog.Load(ctx); err != nil {
+			logger.Fatalf("Failed to load transparency log: %v", err)
+		}
+	}
+
+	cacheRoutes := &routes.Config{
+		URIPrefix:       cacheCfg.URIPrefix,
+		DefaultRegistry: primary.DefaultRegistry,
+		RegistryAliases: primary.RegistryAliases,
+		Storage:         store,
+		Registry:        registryOpts,
+		Auth:            primary.Auth,
+		Transport:       primary.Transport,
+		Provenance:      provenance.NewStore(meta),
+		Pins:            pinSet,
+		Transparency:    transparencyLog,
+		Middlewares:     primary.Middlewares,
+		Keys:            primary.Keys,
+	}
+	routes.SetupCacheRoutes(router, cacheRoutes)
+	preloadIndexes(ctx, cacheRoutes.RegistryHandler(), cfg.MemoryCache, indexHits)
+	runScrubber(ctx, cacheRoutes.RegistryHandler(), backend, cfg.Scrub)
+	runGC(ctx, cacheRoutes.RegistryHandler(), backend, cfg.MetadataGCInterval)
+
+	// Evict expired provider binaries in the background
+	if cacheCfg.Expiration.TTL > 0 {
+		janitor := eviction.NewJanitor(store, cacheCfg.Expiration.TTL, cacheCfg.Expiration.Interval, logrus.StandardLogger())
+		janitor.UseKeys(primary.Keys)
+		janitor.Protect(pinSet)
+		go janitor.Run(ctx)
+	}
+
+	// Keep the cache under its size limit
+	if tracker != nil {
+		policy, err := eviction.NewPolicy(cacheCfg.Eviction.Policy, eviction.PolicyOptions{TTL: cacheCfg.Expiration.TTL})
+		if err != nil {
+			logger.Fatalf("Failed to initialize cache eviction: %v", err)
+		}
+		evictor := eviction.NewEvictor(tracker, policy, cacheCfg.Eviction.MaxSizeBytes, cacheCfg.Eviction.Interval, logrus.StandardLogger())
+		evictor.Protect(pinSet)
+		if guarded != nil && cacheCfg.Eviction.DiskReserve > 0 {
+			guarded.UseSpaceReclaimer(evictor)
+		}
+		go evictor.Run(ctx)
+	}
+
+	logger.WithFields(logrus.Fields{
+		"uriPrefix": cacheCfg.URIPrefix,
+		"storage":   cacheCfg.StorageType,
+	}).Info("Serving additional cache")
+}
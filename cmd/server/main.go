@@ -2,10 +2,16 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"os"
 	"os/signal"
+	"slices"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -13,13 +19,46 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 
+	"cachetf/internal/alert"
+	"cachetf/internal/auth"
+	"cachetf/internal/catalog"
 	"cachetf/internal/config"
+	"cachetf/internal/cron"
+	"cachetf/internal/devregistry"
+	"cachetf/internal/eviction"
+	"cachetf/internal/handler"
+	"cachetf/internal/layout"
+	"cachetf/internal/limits"
+	"cachetf/internal/metadata"
+	"cachetf/internal/middleware"
+	"cachetf/internal/mirror"
+	"cachetf/internal/pins"
+	"cachetf/internal/prewarm"
+	"cachetf/internal/provenance"
+	"cachetf/internal/replay"
+	"cachetf/internal/replication"
 	routes "cachetf/internal/routes"
+	"cachetf/internal/schema"
+	"cachetf/internal/snapshot"
 	"cachetf/internal/storage"
+	"cachetf/internal/telemetry"
+	"cachetf/internal/transparency"
+	"cachetf/internal/upstream"
+	"cachetf/internal/verify"
 	"cachetf/pkg/logger"
+	"cachetf/pkg/validate"
 )
 
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
 func main() {
+	devUpstream := flag.Bool("dev-upstream", false, "serve upstream requests from an embedded fake registry, for local development")
+	recordUpstream := flag.String("record-upstream", "", "record the upstream metadata responses to fixtures in this directory")
+	replayUpstream := flag.String("replay-upstream", "", "serve upstream requests from the fixtures recorded in this directory")
+	checkMigrations := flag.Bool("check-migrations", false, "print the pending schema migrations and exit, with status 1 if any are pending")
+	flag.Parse()
+
 	// Create context that listens for the interrupt signal
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
@@ -32,51 +71,429 @@ func main() {
 
 	// Initialize logger
 	logger.InitLogger(cfg.LogLevel)
+	if err := logger.SetBackend(cfg.LogBackend, os.Stdout); err != nil {
+		logrus.Fatalf("Failed to select logging backend: %v", err)
+	}
 
-	// Create router
+	// Validated with the configuration
+	validate.Configure(cfg.ValidationRules())
+
+	// Create router, the mode must be set before the engine is created
+	if cfg.HTTP.GinMode != "" {
+		gin.SetMode(cfg.HTTP.GinMode)
+	}
 	r := gin.New()
+	if cfg.HTTP.MaxMultipartMemory > 0 {
+		r.MaxMultipartMemory = cfg.HTTP.MaxMultipartMemory
+	}
 	r.Use(gin.Recovery())
+	// Response bodies are throttled when RESPONSE_BANDWIDTH_LIMIT is set or the limit is adjusted at runtime
+	bandwidthLimiter := middleware.NewBandwidthLimiter(cfg.HTTP.ResponseBandwidth)
+	r.Use(bandwidthLimiter.Middleware())
 
 	// Initialize storage
-	var store storage.Storage
-	if cfg.StorageType == "s3" {
-		s3Config := &storage.S3Config{
-			Bucket: cfg.S3.Bucket,
-			Region: cfg.S3.Region,
+	store, err := newStorage(ctx, cfg.StorageType, cfg.StorageOptions(), cfg.StorageStartupTimeout, cfg.StorageSelfTest)
+	if err != nil {
+		logrus.Fatalf("Failed to initialize storage: %v", err)
+	}
+
+	// Downloads of cached binaries are redirected to the bucket, the configuration was validated already
+	var presigner storage.Presigner
+	if cfg.PresignedRedirectTTL > 0 {
+		presigner = store.(storage.Presigner)
+	}
+	// Local storage keeping a disk space reserve evicts files to make room, if the cache has a size limit
+	guarded, _ := store.(storage.DiskGuarded)
+	// The scrubber reads the binaries from the backend, bypassing the caches in front of it
+	backend := store
+
+	// Wrap storage with metrics
+	store = storage.NewMetricsWrapper(store, string(cfg.StorageType))
+	// Large files are served from a local copy, small ones from memory
+	if cfg.HotCache.Enabled() {
+		store, err = storage.NewHotCache(store, storage.HotCacheOptions{
+			Dir:            cfg.HotCache.Dir,
+			MaxBytes:       cfg.HotCache.MaxBytes,
+			MinObjectBytes: cfg.HotCache.MinObjectBytes,
+			TTL:            cfg.HotCache.TTL,
+		}, logrus.StandardLogger())
+		if err != nil {
+			logrus.Fatalf("Failed to initialize hot cache: %v", err)
 		}
-		store, err = storage.NewS3Storage(s3Config, logrus.StandardLogger())
+	}
+	store = newMemoryCache(store, cfg.MemoryCache)
+
+	// Keep the catalog of the cached files, listing and measuring the cache read it instead of the storage
+	var cat *catalog.Catalog
+	var catalogStore *catalog.Storage
+	if cfg.Catalog.Enabled() {
+		var created bool
+		cat, created, err = catalog.Open(cfg.Catalog.Path, logrus.StandardLogger())
 		if err != nil {
-			logrus.Fatalf("Failed to initialize S3 storage: %v", err)
+			logrus.Fatalf("Failed to open catalog: %v", err)
 		}
-	} else {
-		// Default to local filesystem storage
-		store = storage.NewLocalStorage(cfg.CacheDir, logrus.StandardLogger())
+		catalogStore = catalog.NewStorage(store, cat, !created, logrus.StandardLogger())
+		store = catalogStore
 	}
 
-	// Wrap storage with metrics
-	store = storage.NewMetricsWrapper(store)
+	// Upgrade the schema of the metadata documents and the catalog before they're used. The documents are migrated
+	// in the backend, the caches in front of it would hide the changes made by other instances.
+	schemaTargets := newSchemaTargets(metadata.NewStore(backend, logrus.StandardLogger()), cat)
+	if *checkMigrations {
+		os.Exit(checkSchemas(ctx, os.Stdout, schemaTargets))
+	}
+	for _, target := range schemaTargets {
+		if _, err := schema.Migrate(ctx, target, logrus.StandardLogger()); err != nil {
+			logrus.Fatalf("Failed to migrate schema: %v", err)
+		}
+	}
+
+	// Track file accesses so the least recently used files can be evicted, the catalog records them already
+	var tracker eviction.AccessRecorder
+	if cfg.Eviction.MaxSizeBytes > 0 {
+		if catalogStore != nil {
+			tracker = catalogStore
+		} else {
+			accessTracker := eviction.NewAccessTracker(store)
+			tracker = accessTracker
+			store = accessTracker
+		}
+	}
+
+	// Build the service discovery document
+	var discovery map[string]string
+	if cfg.Discovery.Enabled {
+		discovery = map[string]string{}
+		if cfg.Discovery.ProvidersV1 != "" {
+			discovery["providers.v1"] = cfg.Discovery.ProvidersV1
+		}
+		if cfg.Discovery.ModulesV1 != "" {
+			discovery["modules.v1"] = cfg.Discovery.ModulesV1
+		}
+	}
+
+	// Initialize the transport for upstream requests
+	transport := upstream.NewTransport(upstream.Options{
+		InsecureSkipVerify:   cfg.Upstream.InsecureSkipVerify,
+		AllowedHosts:         cfg.Upstream.AllowedHosts,
+		AllowPrivateNetworks: cfg.Upstream.AllowPrivateNetworks,
+	}, logrus.StandardLogger())
+	var upstreamTransport http.RoundTripper = transport
+	if *devUpstream {
+		devRegistry, err := devregistry.New(logrus.StandardLogger())
+		if err != nil {
+			logrus.Fatalf("Failed to initialize development registry: %v", err)
+		}
+		upstreamTransport = devRegistry.Transport()
+		logrus.Warn("Development upstream enabled, every upstream request is answered by the embedded fake registry")
+	}
+	if *replayUpstream != "" {
+		if *devUpstream || *recordUpstream != "" {
+			logrus.Fatal("--replay-upstream can't be combined with --dev-upstream or --record-upstream")
+		}
+		replayer, err := replay.NewReplayer(*replayUpstream, logrus.StandardLogger())
+		if err != nil {
+			logrus.Fatalf("Failed to initialize upstream replay: %v", err)
+		}
+		upstreamTransport = replayer
+		logrus.WithField("dir", *replayUpstream).Warn("Upstream replay enabled, upstream requests are answered from the recorded fixtures")
+	}
+	if *recordUpstream != "" {
+		upstreamTransport = replay.NewRecorder(*recordUpstream, upstreamTransport, logrus.StandardLogger())
+		logrus.WithField("dir", *recordUpstream).Info("Recording upstream metadata responses")
+	}
+	// Upstream requests in flight are limited, the limit can be adjusted through the admin API
+	fetchLimiter := upstream.NewFetchLimiter(upstreamTransport, cfg.Upstream.MaxConcurrentFetches)
+	upstreamTransport = fetchLimiter
+
+	// Layout of the cache keys, the configuration was validated already
+	keys, _ := layout.NewStrategy(cfg.KeyLayout, cfg.KeyTenant)
+	if tenant, ok := keys.(*layout.TenantStrategy); ok {
+		logrus.WithField("tenant", tenant.Tenant()).Info("Cache keys are nested under the tenant")
+	}
+
+	// Registries reached at another URL or with a token, the configuration was validated already
+	registryOpts := handler.RegistryOptions{HostPolicy: transport, Keys: keys}
+	registryOpts.Registries, _ = cfg.Upstream.NewRegistries()
+	for _, registry := range cfg.Upstream.Registries {
+		logrus.WithFields(logrus.Fields{
+			"host":          registry.Host,
+			"url":           registry.URL,
+			"authenticated": registry.Token != "",
+			"forward_auth":  registry.ForwardAuth,
+		}).Info("Upstream registry configured")
+	}
+
+	for alias, registry := range cfg.RegistryAliases {
+		logrus.WithFields(logrus.Fields{
+			"alias":    alias,
+			"registry": registry,
+		}).Info("Registry alias configured")
+	}
+
+	// Initialize signature verification
+	if cfg.Verification.Enabled {
+		registryOpts.Verifier, err = verify.NewGPGVerifier(cfg.Verification.KeyringFile, cfg.Verification.Required)
+		if err != nil {
+			logrus.Fatalf("Failed to initialize GPG verification: %v", err)
+		}
+		logrus.WithField("required", cfg.Verification.Required).Info("GPG signature verification enabled")
+	}
+	if cfg.Verification.OnServe {
+		registryOpts.VerifyOnServe = true
+		logrus.Info("Cached provider binaries are verified while being served")
+	}
+	if cfg.OfflineMode {
+		registryOpts.Offline = true
+		logrus.Info("Offline mode enabled, providers are served exclusively from the cache")
+	}
+	registryOpts.IndexCacheSize = cfg.IndexCacheSize
+	registryOpts.IndexCacheTTL = cfg.IndexCacheTTL
+	registryOpts.RenderCacheSize = cfg.RenderCacheSize
+	registryOpts.CountIndexHits = cfg.MemoryCache.PreloadIndexes > 0
+	if presigner != nil {
+		registryOpts.Presigner = presigner
+		registryOpts.PresignTTL = cfg.PresignedRedirectTTL
+		logrus.WithField("ttl", cfg.PresignedRedirectTTL).Info("Cached provider binaries are downloaded from presigned S3 URLs")
+	}
+	if cfg.StaleIfErrorMaxAge > 0 {
+		registryOpts.StaleIfError = cfg.StaleIfErrorMaxAge
+		logrus.WithField("maxAge", cfg.StaleIfErrorMaxAge).Info("Stale provider indexes are served during upstream outages")
+	}
+
+	// Metadata documents are kept next to the cached artifacts
+	meta := metadata.NewStore(store, logrus.StandardLogger())
+
+	// Pinned providers are never evicted or deleted, the configuration was validated already
+	staticPins, _ := pins.ParsePins(cfg.Pins)
+	pinSet := pins.NewSet(staticPins)
+	pinSet.UseKeys(keys)
+	if err := pinSet.Load(ctx, meta); err != nil {
+		logrus.Fatalf("Failed to load pins: %v", err)
+	}
+
+	// Every verified checksum is recorded, a broken chain means the log was tampered with
+	var transparencyLog *transparency.Log
+	if cfg.TransparencyLog {
+		transparencyLog = transparency.NewLog(meta, logrus.StandardLogger())
+		if err := transparencyLog.Load(ctx); err != nil {
+			logrus.Fatalf("Failed to load transparency log: %v", err)
+		}
+	}
+
+	// Upstream checksum changes are refused and reported until an admin approves them
+	if cfg.AlertWebhookURL != "" {
+		registryOpts.Alerts = alert.NewWebhook(cfg.AlertWebhookURL, logrus.StandardLogger())
+	}
+
+	// Initialize authentication
+	var authenticator *auth.Authenticator
+	if cfg.Auth.Enabled() {
+		// The configuration was validated already
+		keys, _ := auth.ParseAPIKeys(cfg.Auth.APIKeys)
+		anonymousScopes, _ := auth.ParseScopes(strings.Split(cfg.Auth.AnonymousScopes, ","))
+		authenticator = auth.NewAuthenticator(keys, anonymousScopes)
+
+		// Keys created through the admin API are kept in the metadata store. They're read from the backend, the
+		// caches in front of it would hide the changes made by other instances.
+		if err := authenticator.LoadKeys(ctx, metadata.NewStore(backend, logrus.StandardLogger())); err != nil {
+			logrus.Fatalf("Failed to load API keys: %v", err)
+		}
+		if cfg.Auth.KeysReloadInterval > 0 {
+			go authenticator.RunKeyReload(ctx, cfg.Auth.KeysReloadInterval, logrus.StandardLogger())
+		}
+		logrus.WithFields(logrus.Fields{
+			"keys":        len(keys),
+			"managedKeys": len(authenticator.ManagedKeys()),
+		}).Info("Authentication enabled")
+
+		if cfg.Auth.TokenSecret != "" {
+			authenticator.UseTokens(auth.NewTokenIssuer([]byte(cfg.Auth.TokenSecret), cfg.Auth.TokenTTL, cfg.Auth.TokenMaxTTL))
+			logrus.WithField("maxTTL", cfg.Auth.TokenMaxTTL).Info("Token issuance enabled")
+		}
+	}
+
+	// Middlewares of the route groups, the configuration was validated already
+	hooks, _ := middleware.ParseHooks(cfg.HTTP.Middlewares)
+	hookOpts := middleware.HookOptions{Auth: authenticator}
+	if cfg.HTTP.RateLimitRPS > 0 {
+		hookOpts.RateLimiter = middleware.NewRateLimiter(cfg.HTTP.RateLimitRPS, cfg.HTTP.RateLimitBurst)
+	}
+	routeMiddlewares, err := middleware.BuildHooks(hooks, hookOpts)
+	if err != nil {
+		logrus.Fatalf("Failed to configure route middlewares: %v", err)
+	}
+
+	// Limits adjusted through the admin API outlive restarts, only the enforced limits can be adjusted
+	var enforcedRateLimiter *middleware.RateLimiter
+	for _, names := range hooks {
+		if slices.Contains(names, middleware.NameRateLimit) {
+			enforcedRateLimiter = hookOpts.RateLimiter
+		}
+	}
+	limitsManager := limits.NewManager(limits.Limiters{
+		RateLimiter: enforcedRateLimiter,
+		Fetches:     fetchLimiter,
+		Bandwidth:   bandwidthLimiter,
+	}, logrus.StandardLogger())
+	if err := limitsManager.Load(ctx, meta); err != nil {
+		logrus.Fatalf("Failed to load limits: %v", err)
+	}
+
+	// Only serve the module registry when enabled
+	modulesURIPrefix := ""
+	if cfg.Modules.Enabled {
+		modulesURIPrefix = cfg.Modules.URIPrefix
+	}
+
+	// Providers declared in the prewarm list are cached once the server runs
+	var prewarmList []prewarm.Provider
+	if cfg.Prewarm.File != "" {
+		prewarmList, err = prewarm.ParseFile(cfg.Prewarm.File)
+		if err != nil {
+			logrus.Fatalf("Failed to load prewarm list: %v", err)
+		}
+	}
+
+	// Pinned providers are mirrored on a schedule
+	var mirrorSchedule *cron.Schedule
+	var mirrorPlatforms []handler.Platform
+	if cfg.Mirror.RefreshCron != "" {
+		// Validated with the configuration
+		mirrorSchedule, _ = cron.Parse(cfg.Mirror.RefreshCron)
+		for _, value := range cfg.Mirror.Platforms {
+			platform, err := handler.ParsePlatform(value)
+			if err != nil {
+				logrus.Fatalf("Invalid MIRROR_REFRESH_PLATFORMS: %v", err)
+			}
+			mirrorPlatforms = append(mirrorPlatforms, platform)
+		}
+	}
 
 	// Setup routes
-	routes.SetupRoutes(r, &routes.Config{
-		URIPrefix: cfg.URIPrefix,
-		Storage:   store,
-	})
+	buildInfo := routes.ReadBuildInfo(version)
+	// The backends of the caches are checked by /readyz
+	readiness := routes.NewReadiness(logrus.StandardLogger())
+	readiness.Add("", backend)
+	routesConfig := &routes.Config{
+		URIPrefix:        cfg.URIPrefix,
+		DefaultRegistry:  cfg.DefaultRegistry,
+		RegistryAliases:  cfg.RegistryAliases,
+		Storage:          store,
+		ServiceDiscovery: discovery,
+		ModulesURIPrefix: modulesURIPrefix,
+		ModulesUpstream:  cfg.Modules.Upstream,
+		Registry:         registryOpts,
+		Auth:             authenticator,
+		Transport:        upstreamTransport,
+		Provenance:       provenance.NewStore(meta),
+		Limits:           limitsManager,
+		Snapshots:        snapshot.NewSnapshotter(backend, cat, logrus.StandardLogger()),
+		Pins:             pinSet,
+		Transparency:     transparencyLog,
+		Middlewares:      routeMiddlewares,
+		Keys:             keys,
+		BuildInfo:        buildInfo,
+		Readiness:        readiness,
+	}
+	routes.SetupRoutes(r, routesConfig)
 
-	// Create metrics server
-	metricsMux := http.NewServeMux()
-	metricsMux.Handle("/metrics", promhttp.Handler())
+	// The most requested provider indexes are in memory before the first requests, jobs in indexHits persist
+	// the requests until shutdown
+	var indexHits sync.WaitGroup
+	preloadIndexes(ctx, routesConfig.RegistryHandler(), cfg.MemoryCache, &indexHits)
 
-	metricsSrv := &http.Server{
-		Addr:    fmt.Sprintf(":%d", cfg.MetricsPort),
-		Handler: metricsMux,
+	// Detect cached binaries corrupted at rest
+	runScrubber(ctx, routesConfig.RegistryHandler(), backend, cfg.Scrub)
+	// Keep the metadata in sync with the cached artifacts
+	runGC(ctx, routesConfig.RegistryHandler(), backend, cfg.MetadataGCInterval)
+
+	// Build the catalog if it's new, and keep it in sync with the storage
+	if catalogStore != nil {
+		go catalogStore.Run(ctx, cfg.Catalog.SaveInterval, cfg.Catalog.RebuildInterval)
 	}
 
-	// Initialize main server
-	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%d", cfg.ServerPort),
-		Handler: r,
+	// Evict expired provider binaries in the background
+	if cfg.Expiration.TTL > 0 {
+		janitor := eviction.NewJanitor(store, cfg.Expiration.TTL, cfg.Expiration.Interval, logrus.StandardLogger())
+		janitor.UseKeys(keys)
+		janitor.Protect(pinSet)
+		go janitor.Run(ctx)
 	}
 
+	// Keep the cache under its size limit
+	if tracker != nil {
+		policy, err := eviction.NewPolicy(cfg.Eviction.Policy, eviction.PolicyOptions{TTL: cfg.Expiration.TTL})
+		if err != nil {
+			logrus.Fatalf("Failed to initialize cache eviction: %v", err)
+		}
+		evictor := eviction.NewEvictor(tracker, policy, cfg.Eviction.MaxSizeBytes, cfg.Eviction.Interval, logrus.StandardLogger())
+		evictor.Protect(pinSet)
+		if guarded != nil && cfg.Eviction.DiskReserve > 0 {
+			guarded.UseSpaceReclaimer(evictor)
+		}
+		go evictor.Run(ctx)
+	} else {
+		// Without a size limit, measure the cache size on its own for cache_size_bytes
+		sizer := eviction.NewSizer(store, cfg.Eviction.SizeScanInterval, logrus.StandardLogger())
+		go sizer.Run(ctx)
+	}
+
+	// Keep the providers of the prewarm list cached
+	if len(prewarmList) > 0 {
+		scheduler := prewarm.NewScheduler(routesConfig.RegistryHandler(), prewarmList, cfg.Prewarm.Interval, logrus.StandardLogger())
+		go scheduler.Run(ctx)
+	}
+
+	// Cache the new releases of pinned providers
+	if mirrorSchedule != nil {
+		refresher := mirror.NewRefresher(routesConfig.RegistryHandler(), pinSet, mirrorSchedule, mirrorPlatforms, logrus.StandardLogger())
+		go refresher.Run(ctx)
+	}
+
+	// Pull the artifacts missing locally from another instance
+	if cfg.Sync.Enabled() {
+		puller := replication.NewPuller(store, replication.Options{
+			Source:   cfg.Sync.Source,
+			Token:    cfg.Sync.Token,
+			Prefixes: cfg.Sync.Prefixes,
+			Interval: cfg.Sync.Interval,
+		}, logrus.StandardLogger())
+		go puller.Run(ctx)
+	}
+
+	// Report anonymous usage statistics, only when opted in
+	if cfg.Telemetry.Enabled {
+		reporter := telemetry.NewReporter(store, telemetry.Options{
+			Endpoint:    cfg.Telemetry.Endpoint,
+			Interval:    cfg.Telemetry.Interval,
+			Version:     version,
+			StorageType: string(cfg.StorageType),
+		}, logrus.StandardLogger())
+		go reporter.Run(ctx)
+	}
+
+	// Serve the additional caches next to the primary one
+	for _, cacheCfg := range cfg.Caches {
+		setupCache(ctx, r, cfg, cacheCfg, routesConfig, &indexHits)
+	}
+
+	if cfg.HTTP.DebugRoutes {
+		for _, route := range r.Routes() {
+			logrus.WithFields(logrus.Fields{
+				"method":  route.Method,
+				"path":    route.Path,
+				"handler": route.Handler,
+			}).Info("Registered route")
+		}
+	}
+
+	// Create metrics server, also answering the health and version checks
+	metricsSrv := newHTTPServer(cfg.MetricsPort, routes.NewManagementHandler(promhttp.Handler(), buildInfo, readiness), cfg.HTTP)
+
+	// Initialize main server
+	srv := newHTTPServer(cfg.ServerPort, routes.NormalizePath(r), cfg.HTTP)
+
 	// Start metrics server in a goroutine
 	go func() {
 		logrus.Infof("Metrics server is running on %s", metricsSrv.Addr)
@@ -130,5 +547,127 @@ func main() {
 		<-shutdownComplete
 	}
 
+	// The index requests counted since the last save are persisted on shutdown
+	indexHits.Wait()
+
+	// The access times recorded since the last save are persisted on shutdown
+	if cat != nil {
+		if err := cat.Close(); err != nil {
+			logrus.WithError(err).Error("Failed to save the catalog")
+		}
+	}
+
 	logrus.Info("Server exiting")
 }
+
+// newSchemaTargets returns the persisted state whose schema is versioned, the catalog is nil if it isn't enabled
+func newSchemaTargets(meta *metadata.Store, cat *catalog.Catalog) []schema.Target {
+	targets := []schema.Target{{Name: "metadata", State: meta, Migrations: meta.Migrations()}}
+	if cat != nil {
+		targets = append(targets, schema.Target{Name: "catalog", State: cat, Migrations: cat.Migrations()})
+	}
+	return targets
+}
+
+// checkSchemas prints the schema version and the pending migrations of the targets to w. It returns the exit status
+// of --check-migrations: 0 if every schema is up to date, 1 if migrations are pending or a schema can't be checked.
+func checkSchemas(ctx context.Context, w io.Writer, targets []schema.Target) int {
+	exitCode := 0
+	for _, target := range targets {
+		status, err := schema.Check(ctx, target)
+		if err != nil {
+			fmt.Fprintf(w, "%s: %v\n", target.Name, err)
+			exitCode = 1
+			continue
+		}
+		if status.UpToDate() {
+			fmt.Fprintf(w, "%s: version %d, up to date\n", status.Name, status.Current)
+			continue
+		}
+		fmt.Fprintf(w, "%s: version %d, %d pending\n", status.Name, status.Current, len(status.Pending))
+		for _, migration := range status.Pending {
+			fmt.Fprintf(w, "  %d\t%s\n", migration.Version, migration.Description)
+		}
+		exitCode = 1
+	}
+	return exitCode
+}
+
+// newHTTPServer creates a server listening on port with the configured timeouts
+func newHTTPServer(port int, handler http.Handler, cfg config.HTTPConfig) *http.Server {
+	return &http.Server{
+		Addr:              fmt.Sprintf(":%d", port),
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+}
+
+// preloadIndexes reads the most requested provider indexes of a cache into the memory cache, and persists the
+// index requests every interval until ctx is cancelled, if enabled. wg is done once they're persisted a last time.
+func preloadIndexes(ctx context.Context, registryHandler *handler.RegistryHandler, memoryCache config.MemoryCacheConfig, wg *sync.WaitGroup) {
+	if memoryCache.PreloadIndexes <= 0 {
+		return
+	}
+	if _, err := registryHandler.PreloadIndexes(ctx, memoryCache.PreloadIndexes); err != nil {
+		logrus.WithError(err).Warn("Failed to preload provider indexes")
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		registryHandler.RunIndexHits(ctx, memoryCache.PreloadSaveInterval)
+	}()
+}
+
+// runScrubber checks the cached binaries of a cache against their recorded checksums every interval until ctx
+// is cancelled, if enabled
+func runScrubber(ctx context.Context, registryHandler *handler.RegistryHandler, backend storage.Storage, scrub config.ScrubConfig) {
+	if scrub.Interval <= 0 {
+		return
+	}
+	go registryHandler.RunScrubber(ctx, scrub.Interval, handler.ScrubOptions{
+		Source: backend,
+		Delete: scrub.Action == config.ScrubDelete,
+	})
+}
+
+// runGC collects the orphaned metadata of a cache every interval until ctx is cancelled, if the interval is positive
+func runGC(ctx context.Context, registryHandler *handler.RegistryHandler, backend storage.Storage, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go registryHandler.RunGC(ctx, interval, handler.GCOptions{Source: backend})
+}
+
+// newMemoryCache keeps the small files of a cache in memory, if the memory cache is enabled
+func newMemoryCache(store storage.Storage, memoryCache config.MemoryCacheConfig) storage.Storage {
+	if !memoryCache.Enabled() {
+		return store
+	}
+	return storage.NewMemoryCache(store, storage.MemoryCacheOptions{
+		MaxBytes:       memoryCache.MaxBytes,
+		MaxObjectBytes: memoryCache.MaxObjectBytes,
+		TTL:            memoryCache.TTL,
+	})
+}
+
+// newStorage initializes the storage backend of a cache through the backends registered with storage.Register,
+// waiting up to timeout for it to become available. With selfTest, a canary object is written, read and deleted.
+func newStorage(ctx context.Context, storageType config.StorageType, opts storage.Options, timeout time.Duration, selfTest bool) (storage.Storage, error) {
+	// Metadata documents are replaced in place and may be shared with other instances, they're
+	// always read from S3 with tiered storage
+	opts.Uncached = []string{metadata.KeyPrefix}
+	store, err := storage.Open(ctx, string(storageType), opts, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize %s storage: %w", storageType, err)
+	}
+	if selfTest {
+		if err := storage.SelfTest(ctx, store); err != nil {
+			return nil, fmt.Errorf("%s storage failed the self-test: %w", storageType, err)
+		}
+		logrus.WithField("storage", storageType).Info("Storage passed the self-test")
+	}
+	return store, nil
+}
@@ -37,7 +37,7 @@ func TestMetricsServer(t *testing.T) {
 
 	// Create a test storage and wrap it with metrics
 	localStore := storage.NewLocalStorage(cfg.CacheDir, logrus.StandardLogger())
-	metricsWrapper := storage.NewMetricsWrapper(localStore)
+	metricsWrapper := storage.NewMetricsWrapper(localStore, string(cfg.StorageType))
 
 	// Create a test context with timeout
 	_, cancel := context.WithTimeout(context.Background(), 10*time.Second)
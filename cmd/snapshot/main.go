@@ -0,0 +1,105 @@
+// Command snapshot takes, lists and restores snapshots of the metadata documents and the catalog, kept in the
+// storage under metadata/snapshots/. It reads the same environment as the server.
+//
+//	snapshot            take a snapshot, while the server is stopped, see POST /admin/snapshots otherwise
+//	snapshot -list
+//	snapshot -restore 20240102T030405.006Z
+//
+// Snapshots must be restored while every server sharing the storage is stopped, they'd keep the state they loaded
+// and overwrite the restored documents.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/catalog"
+	"cachetf/internal/config"
+	"cachetf/internal/snapshot"
+	"cachetf/internal/storage"
+	"cachetf/pkg/logger"
+)
+
+func main() {
+	list := flag.Bool("list", false, "list the snapshots, the latest first")
+	restore := flag.String("restore", "", "restore the snapshot of this name")
+	flag.Parse()
+	if flag.NArg() != 0 || (*list && *restore != "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+
+	// Initialize logger, the listing is printed to stdout so the logs go to stderr
+	logger.InitLogger(cfg.LogLevel)
+	logrus.SetOutput(os.Stderr)
+	if err := logger.SetBackend(cfg.LogBackend, os.Stderr); err != nil {
+		logrus.Fatalf("Failed to select logging backend: %v", err)
+	}
+
+	// Initialize storage, tiered storage keeps every file in S3
+	storageType := cfg.StorageType
+	if storageType == config.StorageTypeTiered {
+		storageType = config.StorageTypeS3
+	}
+	// Files staged by the server are uploaded by the server
+	cfg.S3.WriteBehindDir = ""
+	store, err := storage.New(string(storageType), cfg.StorageOptions())
+	if err != nil {
+		logrus.Fatalf("Failed to initialize %s storage: %v", storageType, err)
+	}
+
+	switch {
+	case *list:
+		snapshots, err := snapshot.NewSnapshotter(store, nil, logrus.StandardLogger()).List(ctx)
+		if err != nil {
+			logrus.Fatalf("Failed to list snapshots: %v", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, info := range snapshots {
+			fmt.Fprintf(w, "%s\t%d\t%s\n", info.Name, info.Size, info.Key)
+		}
+		w.Flush()
+
+	case *restore != "":
+		opts := snapshot.RestoreOptions{CatalogPath: cfg.Catalog.Path}
+		if _, err := snapshot.NewSnapshotter(store, nil, logrus.StandardLogger()).Restore(ctx, *restore, opts); err != nil {
+			logrus.Fatalf("Restore failed: %v", err)
+		}
+
+	default:
+		// The catalog is locked by a running server, which takes snapshots through the admin API instead
+		var cat *catalog.Catalog
+		if cfg.Catalog.Enabled() {
+			if cat, _, err = catalog.Open(cfg.Catalog.Path, logrus.StandardLogger()); err != nil {
+				logrus.Fatalf("Failed to open catalog, snapshots of running servers are taken with POST /admin/snapshots: %v", err)
+			}
+		}
+		manifest, err := snapshot.NewSnapshotter(store, cat, logrus.StandardLogger()).Create(ctx, "")
+		if cat != nil {
+			if closeErr := cat.Close(); closeErr != nil {
+				logrus.WithError(closeErr).Warn("Failed to close catalog")
+			}
+		}
+		if err != nil {
+			logrus.Fatalf("Snapshot failed: %v", err)
+		}
+		fmt.Println(manifest.Name)
+	}
+}
@@ -0,0 +1,194 @@
+// Command verify checks every cached binary of a provider against the signed SHA256SUMS published upstream and
+// reports the binaries that drifted, e.g. were changed in the storage, or that upstream doesn't publish anymore.
+// Offline, the binaries are compared with the checksums recorded when they were cached. It reads the same
+// environment as the server and exits with status 1 if a binary drifted or couldn't be checked.
+//
+//	verify -provider hashicorp/aws
+//	verify -provider registry.terraform.io/hashicorp/aws -json
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/config"
+	"cachetf/internal/handler"
+	"cachetf/internal/layout"
+	"cachetf/internal/metadata"
+	"cachetf/internal/provenance"
+	"cachetf/internal/storage"
+	"cachetf/internal/transparency"
+	"cachetf/internal/upstream"
+	"cachetf/internal/verify"
+	"cachetf/pkg/logger"
+	"cachetf/pkg/validate"
+)
+
+// defaultRegistry is the registry of the providers given without one when DEFAULT_REGISTRY isn't set
+const defaultRegistry = "registry.terraform.io"
+
+func main() {
+	providerFlag := flag.String("provider", "", "provider to verify, [registry/]namespace/name")
+	offline := flag.Bool("offline", false, "compare with the recorded checksums instead of contacting upstream")
+	jsonOutput := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+	if *providerFlag == "" || flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+	validate.Configure(cfg.ValidationRules())
+
+	// Initialize logger, the report is printed to stdout so the logs go to stderr
+	logger.InitLogger(cfg.LogLevel)
+	logrus.SetOutput(os.Stderr)
+	if err := logger.SetBackend(cfg.LogBackend, os.Stderr); err != nil {
+		logrus.Fatalf("Failed to select logging backend: %v", err)
+	}
+
+	registry, namespace, provider, err := parseProvider(*providerFlag, cfg.DefaultRegistry)
+	if err != nil {
+		logrus.Fatalf("Invalid -provider: %v", err)
+	}
+	// Aliases are served from the cached artifacts of their registry
+	if resolved, ok := cfg.RegistryAliases[registry]; ok {
+		registry = resolved
+	}
+
+	// Initialize storage, tiered storage keeps every file in S3
+	storageType := cfg.StorageType
+	if storageType == config.StorageTypeTiered {
+		storageType = config.StorageTypeS3
+	}
+	// Files staged by the server are uploaded by the server, the verification only reads the bucket
+	cfg.S3.WriteBehindDir = ""
+	store, err := storage.New(string(storageType), cfg.StorageOptions())
+	if err != nil {
+		logrus.Fatalf("Failed to initialize %s storage: %v", storageType, err)
+	}
+	meta := metadata.NewStore(store, logrus.StandardLogger())
+
+	// The same upstream hosts as the server are allowed
+	transport := upstream.NewTransport(upstream.Options{
+		InsecureSkipVerify:   cfg.Upstream.InsecureSkipVerify,
+		AllowedHosts:         cfg.Upstream.AllowedHosts,
+		AllowPrivateNetworks: cfg.Upstream.AllowPrivateNetworks,
+	}, logrus.StandardLogger())
+
+	// Layout of the cache keys and upstream registries, the configuration was validated already
+	keys, _ := layout.NewStrategy(cfg.KeyLayout, cfg.KeyTenant)
+	registryOpts := handler.RegistryOptions{
+		Transport:  transport,
+		HostPolicy: transport,
+		Keys:       keys,
+		Provenance: provenance.NewStore(meta),
+	}
+	registryOpts.Registries, _ = cfg.Upstream.NewRegistries()
+
+	// The trusted keyring is used when configured, the signature is always required
+	if cfg.Verification.Enabled {
+		registryOpts.Verifier, err = verify.NewGPGVerifier(cfg.Verification.KeyringFile, true)
+		if err != nil {
+			logrus.Fatalf("Failed to initialize GPG verification: %v", err)
+		}
+	}
+
+	// Offline, the checksums recorded in the transparency log are used for binaries without an origin record
+	if cfg.TransparencyLog {
+		registryOpts.Transparency = transparency.NewLog(meta, logrus.StandardLogger())
+		if err := registryOpts.Transparency.Load(ctx); err != nil {
+			logrus.Fatalf("Failed to load transparency log: %v", err)
+		}
+	}
+
+	registryHandler := handler.NewRegistryHandlerWithOptions(logrus.StandardLogger(), store, registryOpts)
+	online := !*offline && !cfg.OfflineMode
+	report, err := registryHandler.VerifyConsistency(ctx, registry, namespace, provider, online)
+	if err != nil {
+		logrus.Fatalf("Verification failed: %v", err)
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			logrus.Fatalf("Failed to write report: %v", err)
+		}
+	} else {
+		printReport(report)
+	}
+	if !report.Consistent() {
+		os.Exit(1)
+	}
+}
+
+// parseProvider splits a [registry/]namespace/name provider address, the registry defaults to DEFAULT_REGISTRY
+func parseProvider(address, defaultRegistryHost string) (registry, namespace, provider string, err error) {
+	parts := strings.Split(address, "/")
+	switch len(parts) {
+	case 2:
+		registry = defaultRegistryHost
+		if registry == "" {
+			registry = defaultRegistry
+		}
+		namespace, provider = parts[0], parts[1]
+	case 3:
+		registry, namespace, provider = parts[0], parts[1], parts[2]
+	default:
+		return "", "", "", fmt.Errorf("%q isn't a [registry/]namespace/name address", address)
+	}
+
+	registry = strings.ToLower(registry)
+	if !validate.Registry(registry) || !validate.Namespace(namespace) || !validate.Provider(provider) {
+		return "", "", "", fmt.Errorf("%q isn't a valid provider address", address)
+	}
+	return registry, namespace, provider, nil
+}
+
+// printReport writes a line per cached binary and the number of binaries by status
+func printReport(report *handler.ConsistencyReport) {
+	source := "signed upstream SHA256SUMS"
+	if !report.Online {
+		source = "recorded checksums"
+	}
+	fmt.Printf("%s/%s/%s, compared with the %s\n", report.Registry, report.Namespace, report.Provider, source)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, artifact := range report.Artifacts {
+		fmt.Fprintf(w, "%s\t%s\t%s_%s\t%s\t%s\n", artifact.Status, artifact.Version, artifact.OS, artifact.Arch, artifact.SHA256, artifact.Detail)
+	}
+	w.Flush()
+
+	statuses := make([]string, 0, len(report.Counts))
+	for status := range report.Counts {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	summary := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		summary = append(summary, fmt.Sprintf("%d %s", report.Counts[status], status))
+	}
+	if len(summary) == 0 {
+		summary = append(summary, "no cached binaries")
+	}
+	fmt.Println(strings.Join(summary, ", "))
+}
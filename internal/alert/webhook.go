@@ -0,0 +1,80 @@
+// Package alert sends operational alerts to an HTTP webhook
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// sendTimeout bounds the delivery of an alert
+const sendTimeout = 10 * time.Second
+
+// Event is an alert delivered as the JSON body of a webhook request
+type Event struct {
+	// Type identifies the kind of alert, e.g. checksum_changed
+	Type    string         `json:"type"`
+	Message string         `json:"message"`
+	Time    time.Time      `json:"time"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// Webhook posts alerts to a URL
+type Webhook struct {
+	url    string
+	client *http.Client
+	logger *logrus.Logger
+}
+
+// NewWebhook creates a new Webhook posting to url
+func NewWebhook(url string, logger *logrus.Logger) *Webhook {
+	return &Webhook{
+		url:    url,
+		client: &http.Client{Timeout: sendTimeout},
+		logger: logger,
+	}
+}
+
+// Send posts the event and waits for the webhook to accept it
+func (w *Webhook) Send(ctx context.Context, event Event) error {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode alert: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create alert request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send alert: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("alert webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Notify sends the event in the background, failures are logged
+func (w *Webhook) Notify(event Event) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		defer cancel()
+		if err := w.Send(ctx, event); err != nil {
+			w.logger.WithError(err).WithField("type", event.Type).Error("Failed to deliver alert")
+		}
+	}()
+}
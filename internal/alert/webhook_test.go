@@ -0,0 +1,60 @@
+package alert
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhook_Send(t *testing.T) {
+	received := make(chan Event, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var event Event
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		received <- event
+	}))
+	defer server.Close()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	webhook := NewWebhook(server.URL, logger)
+
+	err := webhook.Send(t.Context(), Event{
+		Type:    "checksum_changed",
+		Message: "checksum changed",
+		Details: map[string]any{"sha256": "aaaa"},
+	})
+	require.NoError(t, err)
+
+	event := <-received
+	assert.Equal(t, "checksum_changed", event.Type)
+	assert.Equal(t, "aaaa", event.Details["sha256"])
+	assert.WithinDuration(t, time.Now(), event.Time, time.Minute)
+}
+
+func TestWebhook_Notify(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	logger, hook := test.NewNullLogger()
+	webhook := NewWebhook(server.URL, logger)
+
+	// Delivery failures are logged
+	webhook.Notify(Event{Type: "checksum_changed"})
+	require.Eventually(t, func() bool {
+		return hook.LastEntry() != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, logrus.ErrorLevel, hook.LastEntry().Level)
+	assert.ErrorContains(t, hook.LastEntry().Data[logrus.ErrorKey].(error), "status 503")
+}
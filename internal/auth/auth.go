@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"cachetf/internal/metadata"
+)
+
+// Scope is a permission that can be granted to a principal
+type Scope string
+
+const (
+	// ScopeRead allows downloading cached artifacts and metadata
+	ScopeRead Scope = "read"
+	// ScopePrefetch allows asking the cache to fetch artifacts ahead of time
+	ScopePrefetch Scope = "prefetch"
+	// ScopePurge allows deleting cached artifacts
+	ScopePurge Scope = "purge"
+	// ScopeAdmin allows everything, including the admin API
+	ScopeAdmin Scope = "admin"
+)
+
+// ParseScope parses a scope name
+func ParseScope(s string) (Scope, error) {
+	switch scope := Scope(strings.ToLower(strings.TrimSpace(s))); scope {
+	case ScopeRead, ScopePrefetch, ScopePurge, ScopeAdmin:
+		return scope, nil
+	default:
+		return "", fmt.Errorf("unknown scope %q", s)
+	}
+}
+
+// Principal is an authenticated caller
+type Principal struct {
+	Name   string
+	Scopes []Scope
+	// Prefix restricts the principal to request paths below it, if set
+	Prefix string
+	// ExpiresAt is the expiry of the credential the principal was authenticated with, zero if it doesn't expire
+	ExpiresAt time.Time
+}
+
+// Anonymous is the principal name used for unauthenticated callers
+const Anonymous = "anonymous"
+
+// HasScope returns true if the principal was granted the scope. Admin implies every scope.
+func (p *Principal) HasScope(scope Scope) bool {
+	for _, s := range p.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows returns true if the principal may access the request path
+func (p *Principal) Allows(requestPath string) bool {
+	if p.Prefix == "" {
+		return true
+	}
+	return requestPath == p.Prefix || strings.HasPrefix(requestPath, p.Prefix+"/")
+}
+
+// APIKey is a statically configured credential
+type APIKey struct {
+	Name   string
+	Key    string
+	Scopes []Scope
+}
+
+// ParseAPIKeys parses API keys in the format name:key:scope|scope,name:key:scope. Errors report the position of
+// the invalid entry rather than its content, which may hold the secret and ends up in the logs.
+func ParseAPIKeys(spec string) ([]APIKey, error) {
+	var keys []APIKey
+	for i, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid API key #%d: expected name:key:scopes", i+1)
+		}
+
+		scopes, err := ParseScopes(strings.Split(parts[2], "|"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid API key #%d (%s): %w", i+1, parts[0], err)
+		}
+
+		keys = append(keys, APIKey{
+			Name:   parts[0],
+			Key:    parts[1],
+			Scopes: scopes,
+		})
+	}
+	return keys, nil
+}
+
+// ParseScopes parses a list of scope names, ignoring empty entries
+func ParseScopes(names []string) ([]Scope, error) {
+	var scopes []Scope
+	for _, name := range names {
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+		scope, err := ParseScope(name)
+		if err != nil {
+			return nil, err
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes, nil
+}
+
+// Authenticator resolves credentials to principals
+type Authenticator struct {
+	mu sync.RWMutex
+	// keys maps the SHA-256 of a key to its principal, so secrets aren't kept around in plain text
+	keys map[string]*Principal
+	// anonymous holds the scopes granted to unauthenticated callers
+	anonymous []Scope
+	// tokens verifies issued tokens, nil if token issuance is disabled
+	tokens *TokenIssuer
+	// managed holds the keys created through the admin API, persisted in store
+	managed []ManagedKey
+	// generation is the generation of the persisted keys managed was loaded from
+	generation int64
+	store      *metadata.Store
+	// writeMu serializes changes to the managed keys
+	writeMu sync.Mutex
+}
+
+// NewAuthenticator creates a new Authenticator for the given keys.
+// anonymousScopes are granted to callers that don't present any credentials.
+func NewAuthenticator(keys []APIKey, anonymousScopes []Scope) *Authenticator {
+	a := &Authenticator{
+		keys:      make(map[string]*Principal, len(keys)),
+		anonymous: anonymousScopes,
+	}
+	for _, key := range keys {
+		a.keys[hashKey(key.Key)] = &Principal{
+			Name:   key.Name,
+			Scopes: key.Scopes,
+		}
+	}
+	return a
+}
+
+// UseTokens makes the authenticator accept tokens minted by issuer
+func (a *Authenticator) UseTokens(issuer *TokenIssuer) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.tokens = issuer
+}
+
+// Tokens returns the token issuer, or nil if token issuance is disabled
+func (a *Authenticator) Tokens() *TokenIssuer {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.tokens
+}
+
+// hashKey returns the hex-encoded SHA-256 of a key
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Anonymous returns the principal used for callers without credentials
+func (a *Authenticator) Anonymous() *Principal {
+	return &Principal{
+		Name:   Anonymous,
+		Scopes: a.anonymous,
+	}
+}
+
+// Authenticate returns the principal for a key or issued token, if it is valid
+func (a *Authenticator) Authenticate(key string) (*Principal, bool) {
+	if strings.HasPrefix(key, TokenPrefix) {
+		if tokens := a.Tokens(); tokens != nil {
+			principal, err := tokens.Verify(key)
+			return principal, err == nil
+		}
+		return nil, false
+	}
+
+	hash := hashKey(key)
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	for candidate, principal := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(hash)) == 1 {
+			return principal, true
+		}
+	}
+	for _, key := range a.managed {
+		if key.Active() && subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hash)) == 1 {
+			return &Principal{
+				Name:   key.Name,
+				Scopes: key.Scopes,
+			}, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAPIKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    []APIKey
+		wantErr string
+	}{
+		{
+			name: "empty",
+			spec: "",
+			want: nil,
+		},
+		{
+			name: "multiple keys",
+			spec: "ci:secret1:read|prefetch, ops:secret2:admin",
+			want: []APIKey{
+				{Name: "ci", Key: "secret1", Scopes: []Scope{ScopeRead, ScopePrefetch}},
+				{Name: "ops", Key: "secret2", Scopes: []Scope{ScopeAdmin}},
+			},
+		},
+		{
+			name:    "missing scopes",
+			spec:    "ci:secret1",
+			wantErr: "expected name:key:scopes",
+		},
+		{
+			name:    "secret without separator",
+			spec:    "ci:secret1:read,s3cr3t",
+			wantErr: "invalid API key #2: expected name:key:scopes",
+		},
+		{
+			name:    "unknown scope",
+			spec:    "ci:secret1:write",
+			wantErr: "unknown scope",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := ParseAPIKeys(tt.spec)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				assert.NotContains(t, err.Error(), "s3cr3t")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, keys)
+		})
+	}
+}
+
+func TestPrincipal_HasScope(t *testing.T) {
+	ci := &Principal{Name: "ci", Scopes: []Scope{ScopeRead, ScopePrefetch}}
+	assert.True(t, ci.HasScope(ScopeRead))
+	assert.True(t, ci.HasScope(ScopePrefetch))
+	assert.False(t, ci.HasScope(ScopePurge))
+	assert.False(t, ci.HasScope(ScopeAdmin))
+
+	// Admin implies every scope
+	admin := &Principal{Name: "ops", Scopes: []Scope{ScopeAdmin}}
+	assert.True(t, admin.HasScope(ScopePurge))
+	assert.True(t, admin.HasScope(ScopeRead))
+}
+
+func TestAuthenticator(t *testing.T) {
+	a := NewAuthenticator([]APIKey{
+		{Name: "ci", Key: "secret1", Scopes: []Scope{ScopePrefetch}},
+	}, []Scope{ScopeRead})
+
+	principal, ok := a.Authenticate("secret1")
+	require.True(t, ok)
+	assert.Equal(t, "ci", principal.Name)
+
+	_, ok = a.Authenticate("wrong")
+	assert.False(t, ok)
+
+	anonymous := a.Anonymous()
+	assert.Equal(t, Anonymous, anonymous.Name)
+	assert.True(t, anonymous.HasScope(ScopeRead))
+	assert.False(t, anonymous.HasScope(ScopePurge))
+}
@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/metadata"
+)
+
+// ManagedKeyPrefix marks API keys created through the admin API
+const ManagedKeyPrefix = "cfk_"
+
+// keysDocument is the metadata document managed keys are persisted in
+const keysDocument = "auth/keys.json"
+
+// ErrKeyNotFound is returned when a managed key doesn't exist
+var ErrKeyNotFound = errors.New("key not found")
+
+// ManagedKey is an API key created at runtime. Only the hash of its secret is kept.
+type ManagedKey struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Hash      string     `json:"hash"`
+	Scopes    []Scope    `json:"scopes"`
+	CreatedAt time.Time  `json:"createdAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+}
+
+// Active returns true if the key hasn't been revoked
+func (k *ManagedKey) Active() bool {
+	return k.RevokedAt == nil
+}
+
+// keysFile is the persisted form of the managed keys
+type keysFile struct {
+	// Generation is incremented by every change, instances sharing the store reload the keys when it changes
+	Generation int64        `json:"generation"`
+	Keys       []ManagedKey `json:"keys"`
+}
+
+// LoadKeys loads the managed keys from the metadata store and persists later changes to it
+func (a *Authenticator) LoadKeys(ctx context.Context, store *metadata.Store) error {
+	file, err := loadKeysFile(ctx, store)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.store = store
+	a.managed = file.Keys
+	a.generation = file.Generation
+	return nil
+}
+
+// ReloadKeys loads the managed keys again if another instance changed them, so keys created or revoked there take
+// effect here as well. It returns true if the keys changed.
+func (a *Authenticator) ReloadKeys(ctx context.Context) (bool, error) {
+	a.writeMu.Lock()
+	defer a.writeMu.Unlock()
+
+	a.mu.RLock()
+	store, generation := a.store, a.generation
+	a.mu.RUnlock()
+	if store == nil {
+		return false, errors.New("key management is not enabled")
+	}
+
+	file, err := loadKeysFile(ctx, store)
+	if err != nil {
+		return false, err
+	}
+	if file.Generation == generation {
+		return false, nil
+	}
+
+	a.mu.Lock()
+	a.managed = file.Keys
+	a.generation = file.Generation
+	a.mu.Unlock()
+	return true, nil
+}
+
+// RunKeyReload reloads the managed keys every interval until ctx is cancelled
+func (a *Authenticator) RunKeyReload(ctx context.Context, interval time.Duration, logger *logrus.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		changed, err := a.ReloadKeys(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.WithError(err).Warn("Failed to reload API keys")
+			}
+			continue
+		}
+		if changed {
+			logger.WithField("managedKeys", len(a.ManagedKeys())).Info("Reloaded API keys changed by another instance")
+		}
+	}
+}
+
+// loadKeysFile reads the persisted managed keys, a missing document holds no keys
+func loadKeysFile(ctx context.Context, store *metadata.Store) (keysFile, error) {
+	var file keysFile
+	if err := store.Load(ctx, keysDocument, &file); err != nil && !errors.Is(err, metadata.ErrNotFound) {
+		return keysFile{}, fmt.Errorf("failed to load API keys: %w", err)
+	}
+	return file, nil
+}
+
+// ManagedKeys returns the managed keys, including revoked ones
+func (a *Authenticator) ManagedKeys() []ManagedKey {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return append([]ManagedKey(nil), a.managed...)
+}
+
+// CreateKey creates a managed key and returns it together with its secret,
+// which can't be retrieved later
+func (a *Authenticator) CreateKey(ctx context.Context, name string, scopes []Scope) (*ManagedKey, string, error) {
+	if name == "" {
+		return nil, "", errors.New("name is required")
+	}
+	if len(scopes) == 0 {
+		return nil, "", errors.New("at least one scope is required")
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, "", err
+	}
+	secret = ManagedKeyPrefix + secret
+
+	key := ManagedKey{
+		ID:        id,
+		Name:      name,
+		Hash:      hashKey(secret),
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+
+	err = a.updateKeys(ctx, func(keys []ManagedKey) ([]ManagedKey, error) {
+		return append(keys, key), nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return &key, secret, nil
+}
+
+// RevokeKey revokes a managed key. Revoked keys are kept so the history stays visible.
+func (a *Authenticator) RevokeKey(ctx context.Context, id string) (*ManagedKey, error) {
+	var revoked ManagedKey
+	err := a.updateKeys(ctx, func(keys []ManagedKey) ([]ManagedKey, error) {
+		for i := range keys {
+			if keys[i].ID != id {
+				continue
+			}
+			if keys[i].Active() {
+				now := time.Now().UTC().Truncate(time.Second)
+				keys[i].RevokedAt = &now
+			}
+			revoked = keys[i]
+			return keys, nil
+		}
+		return nil, ErrKeyNotFound
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &revoked, nil
+}
+
+// updateKeys applies update to the stored managed keys, persists the result and swaps it in. The keys are read
+// from the store rather than memory, so changes made by other instances since the last reload aren't lost.
+func (a *Authenticator) updateKeys(ctx context.Context, update func([]ManagedKey) ([]ManagedKey, error)) error {
+	a.writeMu.Lock()
+	defer a.writeMu.Unlock()
+
+	a.mu.RLock()
+	store := a.store
+	a.mu.RUnlock()
+
+	if store == nil {
+		return errors.New("key management is not enabled")
+	}
+
+	file, err := loadKeysFile(ctx, store)
+	if err != nil {
+		return err
+	}
+	keys, err := update(file.Keys)
+	if err != nil {
+		return err
+	}
+
+	// Persist before swapping, so the in-memory keys never get ahead of the stored ones
+	file = keysFile{Generation: file.Generation + 1, Keys: keys}
+	if err := store.Save(ctx, keysDocument, file); err != nil {
+		return fmt.Errorf("failed to persist API keys: %w", err)
+	}
+
+	a.mu.Lock()
+	a.managed = keys
+	a.generation = file.Generation
+	a.mu.Unlock()
+	return nil
+}
+
+// randomHex returns n random bytes, hex-encoded
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/metadata"
+	"cachetf/internal/storage"
+)
+
+func newTestMetadataStore(t *testing.T) *metadata.Store {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return metadata.NewStore(storage.NewLocalStorage(t.TempDir(), logger), logger)
+}
+
+func TestAuthenticator_ManagedKeys(t *testing.T) {
+	ctx := context.Background()
+	store := newTestMetadataStore(t)
+
+	a := NewAuthenticator(nil, nil)
+
+	// Keys can't be managed without a store
+	_, _, err := a.CreateKey(ctx, "ci", []Scope{ScopeRead})
+	assert.ErrorContains(t, err, "not enabled")
+
+	require.NoError(t, a.LoadKeys(ctx, store))
+	assert.Empty(t, a.ManagedKeys())
+
+	// Rotate: both the old and the new key are valid until the old one is revoked
+	oldKey, oldSecret, err := a.CreateKey(ctx, "ci", []Scope{ScopeRead})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(oldSecret, ManagedKeyPrefix))
+	assert.NotContains(t, oldKey.Hash, oldSecret)
+
+	newKey, newSecret, err := a.CreateKey(ctx, "ci", []Scope{ScopeRead, ScopePrefetch})
+	require.NoError(t, err)
+	assert.NotEqual(t, oldKey.ID, newKey.ID)
+
+	for _, secret := range []string{oldSecret, newSecret} {
+		principal, ok := a.Authenticate(secret)
+		require.True(t, ok)
+		assert.Equal(t, "ci", principal.Name)
+	}
+
+	revoked, err := a.RevokeKey(ctx, oldKey.ID)
+	require.NoError(t, err)
+	assert.False(t, revoked.Active())
+
+	_, ok := a.Authenticate(oldSecret)
+	assert.False(t, ok, "Revoked keys should be rejected")
+	_, ok = a.Authenticate(newSecret)
+	assert.True(t, ok)
+
+	_, err = a.RevokeKey(ctx, "missing")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	// Keys survive a restart
+	restarted := NewAuthenticator(nil, nil)
+	require.NoError(t, restarted.LoadKeys(ctx, store))
+	assert.Len(t, restarted.ManagedKeys(), 2)
+	_, ok = restarted.Authenticate(newSecret)
+	assert.True(t, ok)
+	_, ok = restarted.Authenticate(oldSecret)
+	assert.False(t, ok)
+}
+
+func TestAuthenticator_CreateKeyValidation(t *testing.T) {
+	ctx := context.Background()
+	a := NewAuthenticator(nil, nil)
+	require.NoError(t, a.LoadKeys(ctx, newTestMetadataStore(t)))
+
+	_, _, err := a.CreateKey(ctx, "", []Scope{ScopeRead})
+	assert.ErrorContains(t, err, "name is required")
+
+	_, _, err = a.CreateKey(ctx, "ci", nil)
+	assert.ErrorContains(t, err, "at least one scope")
+}
+
+func TestAuthenticator_ReloadKeys(t *testing.T) {
+	ctx := context.Background()
+	store := newTestMetadataStore(t)
+
+	// Two instances sharing the store
+	first := NewAuthenticator(nil, nil)
+	require.NoError(t, first.LoadKeys(ctx, store))
+	second := NewAuthenticator(nil, nil)
+	require.NoError(t, second.LoadKeys(ctx, store))
+
+	key, secret, err := first.CreateKey(ctx, "ci", []Scope{ScopeRead})
+	require.NoError(t, err)
+	_, ok := second.Authenticate(secret)
+	assert.False(t, ok, "The key isn't known before the reload")
+
+	changed, err := second.ReloadKeys(ctx)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	_, ok = second.Authenticate(secret)
+	assert.True(t, ok)
+
+	changed, err = second.ReloadKeys(ctx)
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	// Revocations on one instance take effect on the other after the reload
+	_, err = first.RevokeKey(ctx, key.ID)
+	require.NoError(t, err)
+	changed, err = second.ReloadKeys(ctx)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	_, ok = second.Authenticate(secret)
+	assert.False(t, ok)
+
+	// Changes build on the stored keys, not on the keys the instance last loaded
+	_, _, err = first.CreateKey(ctx, "ops", []Scope{ScopeAdmin})
+	require.NoError(t, err)
+	_, _, err = second.CreateKey(ctx, "release", []Scope{ScopePrefetch})
+	require.NoError(t, err)
+	names := []string{}
+	for _, k := range second.ManagedKeys() {
+		names = append(names, k.Name)
+	}
+	assert.Equal(t, []string{"ci", "ops", "release"}, names)
+}
@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// TokenPrefix marks credentials that are issued tokens rather than API keys
+const TokenPrefix = "cft_"
+
+var (
+	// ErrInvalidToken is returned for tokens that are malformed or weren't signed by the issuer
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrTokenExpired is returned for tokens past their expiry
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// tokenClaims is the signed payload of an issued token
+type tokenClaims struct {
+	Subject   string  `json:"sub"`
+	Scopes    []Scope `json:"scp"`
+	Prefix    string  `json:"pfx,omitempty"`
+	ExpiresAt int64   `json:"exp"`
+}
+
+// TokenRequest describes a token to issue
+type TokenRequest struct {
+	// Scopes granted to the token, which must be held by the issuing principal
+	Scopes []Scope
+	// Prefix restricts the token to request paths below it, e.g. /providers/registry.terraform.io/hashicorp/aws
+	Prefix string
+	// TTL is the token lifetime, the issuer's default TTL if zero
+	TTL time.Duration
+}
+
+// IssuedToken is a minted token together with its effective restrictions
+type IssuedToken struct {
+	Token     string    `json:"token"`
+	Scopes    []Scope   `json:"scopes"`
+	Prefix    string    `json:"prefix,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// TokenIssuer mints and verifies short-lived HMAC-signed tokens
+type TokenIssuer struct {
+	secret     []byte
+	defaultTTL time.Duration
+	maxTTL     time.Duration
+	// now is replaceable for tests
+	now func() time.Time
+}
+
+// NewTokenIssuer creates a new TokenIssuer signing tokens with secret
+func NewTokenIssuer(secret []byte, defaultTTL, maxTTL time.Duration) *TokenIssuer {
+	return &TokenIssuer{
+		secret:     secret,
+		defaultTTL: defaultTTL,
+		maxTTL:     maxTTL,
+		now:        time.Now,
+	}
+}
+
+// Issue mints a token on behalf of principal. The token can't be granted scopes the
+// principal doesn't have, nor outlive the issuer's maximum TTL or the credential of the principal,
+// so a token can't be renewed by issuing another one with it.
+func (t *TokenIssuer) Issue(principal *Principal, req TokenRequest) (*IssuedToken, error) {
+	if len(req.Scopes) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+	for _, scope := range req.Scopes {
+		if !principal.HasScope(scope) {
+			return nil, fmt.Errorf("scope %q is not granted to %s", scope, principal.Name)
+		}
+	}
+
+	ttl := req.TTL
+	if ttl == 0 {
+		ttl = t.defaultTTL
+	}
+	if ttl < 0 || ttl > t.maxTTL {
+		return nil, fmt.Errorf("ttl must be between 0 and %s", t.maxTTL)
+	}
+
+	// A token can only narrow the prefix of the principal that issues it
+	prefix, err := normalizePrefix(req.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	if prefix == "" {
+		prefix = principal.Prefix
+	} else if !principal.Allows(prefix) {
+		return nil, fmt.Errorf("prefix %q is outside of %q", req.Prefix, principal.Prefix)
+	}
+
+	expiresAt := t.now().Add(ttl).Truncate(time.Second)
+	if !principal.ExpiresAt.IsZero() && principal.ExpiresAt.Before(expiresAt) {
+		expiresAt = principal.ExpiresAt
+	}
+	claims := tokenClaims{
+		Subject:   principal.Name,
+		Scopes:    req.Scopes,
+		Prefix:    prefix,
+		ExpiresAt: expiresAt.Unix(),
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode token: %w", err)
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return &IssuedToken{
+		Token:     TokenPrefix + encoded + "." + t.sign(encoded),
+		Scopes:    req.Scopes,
+		Prefix:    prefix,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// Verify checks the signature and expiry of a token and returns its principal
+func (t *TokenIssuer) Verify(token string) (*Principal, error) {
+	encoded, signature, ok := strings.Cut(strings.TrimPrefix(token, TokenPrefix), ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(t.sign(encoded))) {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var claims tokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	expiresAt := time.Unix(claims.ExpiresAt, 0)
+	if !t.now().Before(expiresAt) {
+		return nil, ErrTokenExpired
+	}
+
+	return &Principal{
+		Name:      "token:" + claims.Subject,
+		Scopes:    claims.Scopes,
+		Prefix:    claims.Prefix,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// sign returns the base64-encoded HMAC-SHA256 of the encoded payload
+func (t *TokenIssuer) sign(encoded string) string {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// normalizePrefix cleans a path prefix, returning an empty string for no restriction
+func normalizePrefix(prefix string) (string, error) {
+	if prefix == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(prefix, "/") {
+		return "", fmt.Errorf("prefix %q must be an absolute path", prefix)
+	}
+	cleaned := path.Clean(prefix)
+	if cleaned == "/" {
+		return "", nil
+	}
+	return cleaned, nil
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestIssuer() *TokenIssuer {
+	return NewTokenIssuer([]byte("0123456789abcdef0123456789abcdef"), 15*time.Minute, time.Hour)
+}
+
+func TestTokenIssuer_IssueAndVerify(t *testing.T) {
+	issuer := newTestIssuer()
+	ci := &Principal{Name: "ci", Scopes: []Scope{ScopeRead, ScopePrefetch}}
+
+	issued, err := issuer.Issue(ci, TokenRequest{
+		Scopes: []Scope{ScopeRead},
+		Prefix: "/providers/registry.terraform.io/hashicorp/aws/",
+	})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(issued.Token, TokenPrefix))
+	assert.Equal(t, "/providers/registry.terraform.io/hashicorp/aws", issued.Prefix)
+	assert.WithinDuration(t, time.Now().Add(15*time.Minute), issued.ExpiresAt, 2*time.Second)
+
+	principal, err := issuer.Verify(issued.Token)
+	require.NoError(t, err)
+	assert.Equal(t, "token:ci", principal.Name)
+	assert.True(t, principal.HasScope(ScopeRead))
+	assert.False(t, principal.HasScope(ScopePrefetch))
+	assert.True(t, principal.Allows("/providers/registry.terraform.io/hashicorp/aws/index.json"))
+	assert.False(t, principal.Allows("/providers/registry.terraform.io/hashicorp/awscc/index.json"))
+
+	// Tokens signed with another secret are rejected
+	other := NewTokenIssuer([]byte("another-secret-another-secret-xx"), time.Minute, time.Hour)
+	_, err = other.Verify(issued.Token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// Tampered tokens are rejected
+	_, err = issuer.Verify(issued.Token + "x")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestTokenIssuer_Expiry(t *testing.T) {
+	issuer := newTestIssuer()
+	now := time.Now()
+	issuer.now = func() time.Time { return now }
+
+	issued, err := issuer.Issue(&Principal{Name: "ci", Scopes: []Scope{ScopeRead}}, TokenRequest{
+		Scopes: []Scope{ScopeRead},
+		TTL:    time.Minute,
+	})
+	require.NoError(t, err)
+
+	_, err = issuer.Verify(issued.Token)
+	require.NoError(t, err)
+
+	issuer.now = func() time.Time { return now.Add(2 * time.Minute) }
+	_, err = issuer.Verify(issued.Token)
+	assert.ErrorIs(t, err, ErrTokenExpired)
+}
+
+func TestTokenIssuer_DerivedTokensDontOutliveTheirParent(t *testing.T) {
+	issuer := newTestIssuer()
+	now := time.Now()
+	issuer.now = func() time.Time { return now }
+
+	parent, err := issuer.Issue(&Principal{Name: "ci", Scopes: []Scope{ScopeRead}}, TokenRequest{
+		Scopes: []Scope{ScopeRead},
+		TTL:    time.Minute,
+	})
+	require.NoError(t, err)
+	principal, err := issuer.Verify(parent.Token)
+	require.NoError(t, err)
+	assert.Equal(t, parent.ExpiresAt, principal.ExpiresAt)
+
+	// A token issued with the token expires with it, whatever its TTL
+	issuer.now = func() time.Time { return now.Add(30 * time.Second) }
+	child, err := issuer.Issue(principal, TokenRequest{Scopes: []Scope{ScopeRead}, TTL: time.Hour})
+	require.NoError(t, err)
+	assert.Equal(t, parent.ExpiresAt, child.ExpiresAt)
+
+	issuer.now = func() time.Time { return now.Add(2 * time.Minute) }
+	_, err = issuer.Verify(child.Token)
+	assert.ErrorIs(t, err, ErrTokenExpired)
+}
+
+func TestTokenIssuer_Restrictions(t *testing.T) {
+	issuer := newTestIssuer()
+	ci := &Principal{Name: "ci", Scopes: []Scope{ScopeRead}, Prefix: "/providers/registry.terraform.io/hashicorp"}
+
+	tests := []struct {
+		name    string
+		req     TokenRequest
+		wantErr string
+	}{
+		{"no scopes", TokenRequest{}, "at least one scope"},
+		{"scope not held", TokenRequest{Scopes: []Scope{ScopePurge}}, "not granted"},
+		{"ttl too long", TokenRequest{Scopes: []Scope{ScopeRead}, TTL: 2 * time.Hour}, "ttl must be"},
+		{"relative prefix", TokenRequest{Scopes: []Scope{ScopeRead}, Prefix: "providers"}, "absolute path"},
+		{"wider prefix", TokenRequest{Scopes: []Scope{ScopeRead}, Prefix: "/providers"}, "is outside of"},
+		{"narrower prefix", TokenRequest{Scopes: []Scope{ScopeRead}, Prefix: "/providers/registry.terraform.io/hashicorp/aws"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := issuer.Issue(ci, tt.req)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+
+	// Tokens inherit the issuing principal's prefix
+	issued, err := issuer.Issue(ci, TokenRequest{Scopes: []Scope{ScopeRead}})
+	require.NoError(t, err)
+	assert.Equal(t, ci.Prefix, issued.Prefix)
+}
+
+func TestAuthenticator_Tokens(t *testing.T) {
+	a := NewAuthenticator([]APIKey{{Name: "ci", Key: "secret1", Scopes: []Scope{ScopeRead}}}, nil)
+
+	issuer := newTestIssuer()
+	issued, err := issuer.Issue(&Principal{Name: "ci", Scopes: []Scope{ScopeRead}}, TokenRequest{Scopes: []Scope{ScopeRead}})
+	require.NoError(t, err)
+
+	// Tokens aren't accepted until issuance is enabled
+	_, ok := a.Authenticate(issued.Token)
+	assert.False(t, ok)
+
+	a.UseTokens(issuer)
+	principal, ok := a.Authenticate(issued.Token)
+	require.True(t, ok)
+	assert.Equal(t, "token:ci", principal.Name)
+}
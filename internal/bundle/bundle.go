@@ -0,0 +1,209 @@
+// Package bundle moves cached artifacts between caches as portable tar.gz bundles, e.g. into an air-gapped
+// enclave. A bundle holds the artifacts under their storage keys, the metadata documents describing them, and
+// a manifest listing every file with its checksum as its last entry.
+package bundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/layout"
+	"cachetf/internal/metadata"
+	"cachetf/internal/storage"
+)
+
+// ManifestName is the name of the manifest entry of a bundle
+const ManifestName = "manifest.json"
+
+// FormatVersion is the version of the bundle format written by Export
+const FormatVersion = 1
+
+// metadataPrefixes are the metadata documents named after the artifact keys they describe: origin records
+// and persisted provider indexes. They are exported along with the artifacts.
+var metadataPrefixes = []string{"origins/", "indexes/"}
+
+// ErrInvalidPrefix is returned for prefixes outside of the cached artifacts
+var ErrInvalidPrefix = errors.New("invalid bundle prefix")
+
+// Manifest describes the content of a bundle
+type Manifest struct {
+	// Version is the bundle format version
+	Version int `json:"version"`
+	// CreatedAt is when the bundle was exported
+	CreatedAt time.Time `json:"createdAt"`
+	// Prefixes are the exported key prefixes
+	Prefixes []string `json:"prefixes"`
+	// Files lists the entries of the bundle, artifacts and metadata documents
+	Files []File `json:"files"`
+}
+
+// File is an entry of a bundle
+type File struct {
+	// Key is the storage key of the file, and its name in the bundle
+	Key    string `json:"key"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// ValidatePrefix checks that a prefix selects cached artifacts, i.e. starts with a scheme
+func ValidatePrefix(prefix string) error {
+	if _, ok := layout.SchemeOf(prefix); !ok {
+		if _, ok := layout.ParseScheme(prefix); !ok {
+			return fmt.Errorf("%w %q: must start with a scheme such as providers/", ErrInvalidPrefix, prefix)
+		}
+	}
+	if clean := path.Clean(prefix); clean != strings.TrimSuffix(prefix, "/") || strings.Contains(prefix, "//") {
+		return fmt.Errorf("%w %q: must be a clean path", ErrInvalidPrefix, prefix)
+	}
+	return nil
+}
+
+// Exporter writes bundles of the cached artifacts
+type Exporter struct {
+	storage storage.Storage
+	logger  *logrus.Logger
+}
+
+// NewExporter creates a new Exporter
+func NewExporter(storage storage.Storage, logger *logrus.Logger) *Exporter {
+	return &Exporter{
+		storage: storage,
+		logger:  logger,
+	}
+}
+
+// Export writes a bundle of the artifacts under the prefixes to w, every artifact if there are none.
+// The prefixes must be valid, see ValidatePrefix.
+func (e *Exporter) Export(ctx context.Context, w io.Writer, prefixes []string) (*Manifest, error) {
+	if len(prefixes) == 0 {
+		for _, scheme := range layout.Schemes() {
+			prefixes = append(prefixes, scheme.Prefix())
+		}
+	}
+	for _, prefix := range prefixes {
+		if err := ValidatePrefix(prefix); err != nil {
+			return nil, err
+		}
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	manifest := &Manifest{
+		Version:   FormatVersion,
+		CreatedAt: time.Now().UTC(),
+		Prefixes:  prefixes,
+	}
+
+	// Overlapping prefixes would export files twice
+	exported := make(map[string]bool)
+	add := func(obj storage.ObjectInfo) error {
+		if exported[obj.Key] {
+			return nil
+		}
+		exported[obj.Key] = true
+
+		file, err := e.writeFile(ctx, tw, obj)
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, *file)
+		return nil
+	}
+
+	for _, prefix := range prefixes {
+		if err := storage.Walk(ctx, e.storage, prefix, add); err != nil {
+			return nil, fmt.Errorf("failed to export %s: %w", prefix, err)
+		}
+		for _, documents := range metadataPrefixes {
+			if err := storage.Walk(ctx, e.storage, metadata.KeyPrefix+documents+prefix, add); err != nil {
+				return nil, fmt.Errorf("failed to export the metadata of %s: %w", prefix, err)
+			}
+		}
+	}
+
+	if err := writeManifest(tw, manifest); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write bundle: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write bundle: %w", err)
+	}
+
+	e.logger.WithFields(logrus.Fields{
+		"prefixes": prefixes,
+		"files":    len(manifest.Files),
+	}).Info("Exported cache bundle")
+	return manifest, nil
+}
+
+// writeFile copies a stored file into the bundle, returning its manifest entry
+func (e *Exporter) writeFile(ctx context.Context, tw *tar.Writer, obj storage.ObjectInfo) (*File, error) {
+	reader, err := e.storage.Get(ctx, obj.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", obj.Key, err)
+	}
+	defer reader.Close()
+
+	header := &tar.Header{
+		Name:    obj.Key,
+		Mode:    0644,
+		Size:    obj.Size,
+		ModTime: obj.LastModified,
+		Format:  tar.FormatPAX,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", obj.Key, err)
+	}
+
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(tw, hash), reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", obj.Key, err)
+	}
+	if written != obj.Size {
+		return nil, fmt.Errorf("failed to write %s: changed while being exported", obj.Key)
+	}
+
+	return &File{
+		Key:    obj.Key,
+		Size:   written,
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+// writeManifest writes the manifest as the last entry of the bundle
+func writeManifest(tw *tar.Writer, manifest *Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	header := &tar.Header{
+		Name:    ManifestName,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: manifest.CreatedAt,
+		Format:  tar.FormatPAX,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/storage"
+)
+
+func newTestStorage(t *testing.T, files map[string]string) storage.Storage {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	store := storage.NewLocalStorage(t.TempDir(), logger)
+	for key, content := range files {
+		require.NoError(t, store.Put(t.Context(), key, strings.NewReader(content)))
+	}
+	return store
+}
+
+// readBundle returns the entries of a bundle in order, with their content
+func readBundle(t *testing.T, data []byte) ([]string, map[string]string) {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	tr := tar.NewReader(gz)
+
+	var names []string
+	contents := make(map[string]string)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return names, contents
+		}
+		require.NoError(t, err)
+		content, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		names = append(names, header.Name)
+		contents[header.Name] = string(content)
+	}
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestExport(t *testing.T) {
+	binary := "providers/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip"
+	store := newTestStorage(t, map[string]string{
+		binary: "aws binary",
+		"providers/registry.terraform.io/hashicorp/random/3.7.2/terraform-provider-random_3.7.2_linux_amd64.zip": "random binary",
+		"modules/hashicorp/consul/aws/0.1.0/archive.tar.gz":                                                      "module",
+		"metadata/origins/" + binary + ".json":                                                                   `{"key": "origin"}`,
+		"metadata/indexes/providers/registry.terraform.io/hashicorp/aws.json":                                    `{"response": {}}`,
+		"metadata/auth/keys.json":                                                                                `{"keys": []}`,
+		"metadata/pins.json":                                                                                     `{"pins": []}`,
+	})
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	var buf bytes.Buffer
+	manifest, err := NewExporter(store, logger).Export(t.Context(), &buf, []string{
+		"providers/registry.terraform.io/hashicorp/aws",
+		// Overlapping prefixes don't export files twice
+		"providers/registry.terraform.io/hashicorp/aws/5.0.0/",
+	})
+	require.NoError(t, err)
+
+	names, contents := readBundle(t, buf.Bytes())
+	assert.Equal(t, []string{
+		binary,
+		"metadata/origins/" + binary + ".json",
+		"metadata/indexes/providers/registry.terraform.io/hashicorp/aws.json",
+		ManifestName,
+	}, names)
+	assert.Equal(t, "aws binary", contents[binary])
+
+	// The manifest is the last entry and lists every other entry with its checksum
+	var bundled Manifest
+	require.NoError(t, json.Unmarshal([]byte(contents[ManifestName]), &bundled))
+	assert.Equal(t, FormatVersion, bundled.Version)
+	assert.Equal(t, manifest.Files, bundled.Files)
+	require.Len(t, bundled.Files, 3)
+	assert.Equal(t, File{Key: binary, Size: 10, SHA256: sha256Hex("aws binary")}, bundled.Files[0])
+}
+
+func TestExport_Everything(t *testing.T) {
+	store := newTestStorage(t, map[string]string{
+		"providers/registry.terraform.io/hashicorp/random/3.7.2/terraform-provider-random_3.7.2_linux_amd64.zip": "random binary",
+		"modules/hashicorp/consul/aws/0.1.0/archive.tar.gz":                                                      "module",
+		"metadata/auth/keys.json": `{"keys": []}`,
+	})
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	var buf bytes.Buffer
+	manifest, err := NewExporter(store, logger).Export(t.Context(), &buf, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"providers/", "modules/", "cli/"}, manifest.Prefixes)
+
+	// Metadata not describing artifacts, such as API keys, is never exported
+	names, _ := readBundle(t, buf.Bytes())
+	assert.Equal(t, []string{
+		"providers/registry.terraform.io/hashicorp/random/3.7.2/terraform-provider-random_3.7.2_linux_amd64.zip",
+		"modules/hashicorp/consul/aws/0.1.0/archive.tar.gz",
+		ManifestName,
+	}, names)
+}
+
+func TestValidatePrefix(t *testing.T) {
+	for _, prefix := range []string{"providers", "providers/", "providers/registry.terraform.io/hashicorp", "modules/hashicorp/"} {
+		assert.NoError(t, ValidatePrefix(prefix), prefix)
+	}
+	for _, prefix := range []string{"", "metadata/", "registry.terraform.io/hashicorp", "providers/../metadata", "providers//aws", "/providers"} {
+		assert.ErrorIs(t, ValidatePrefix(prefix), ErrInvalidPrefix, prefix)
+	}
+}
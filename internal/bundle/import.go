@@ -0,0 +1,255 @@
+package bundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/errclass"
+	"cachetf/internal/metadata"
+	"cachetf/internal/storage"
+)
+
+// ErrInvalidBundle is returned for bundles that are malformed or don't match their manifest
+var ErrInvalidBundle = errors.New("invalid bundle")
+
+// ImportResult summarizes an import
+type ImportResult struct {
+	// Files is the number of files in the bundle
+	Files int `json:"files"`
+	// Imported is the number of files written to the storage
+	Imported int `json:"imported"`
+	// Skipped is the number of artifacts that were cached already
+	Skipped int `json:"skipped"`
+}
+
+// Importer writes the content of bundles to the cache storage
+type Importer struct {
+	storage storage.Storage
+	logger  *logrus.Logger
+}
+
+// NewImporter creates a new Importer
+func NewImporter(storage storage.Storage, logger *logrus.Logger) *Importer {
+	return &Importer{
+		storage: storage,
+		logger:  logger,
+	}
+}
+
+// stagedFile is a bundle entry extracted to a temporary file
+type stagedFile struct {
+	File
+	path string
+}
+
+// Import reads a bundle from r and writes its files to the storage. As the manifest is the last entry, the
+// files are staged in a temporary directory first, and nothing is written unless every file matches the
+// manifest checksums. Artifacts that are cached already are kept, metadata documents are replaced.
+func (i *Importer) Import(ctx context.Context, r io.Reader) (*ImportResult, error) {
+	dir, err := os.MkdirTemp("", "cachetf-import-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	manifest, staged, err := stage(ctx, r, dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := verify(manifest, staged); err != nil {
+		return nil, err
+	}
+
+	result := &ImportResult{Files: len(manifest.Files)}
+	for _, file := range manifest.Files {
+		written, err := i.writeFile(ctx, staged[file.Key])
+		if err != nil {
+			return nil, err
+		}
+		if written {
+			result.Imported++
+		} else {
+			result.Skipped++
+		}
+	}
+
+	i.logger.WithFields(logrus.Fields{
+		"prefixes": manifest.Prefixes,
+		"files":    result.Files,
+		"imported": result.Imported,
+		"skipped":  result.Skipped,
+	}).Info("Imported cache bundle")
+	return result, nil
+}
+
+// stage extracts the entries of a bundle to dir, returning its manifest and the staged files by key
+func stage(ctx context.Context, r io.Reader, dir string) (*Manifest, map[string]*stagedFile, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidBundle, err)
+	}
+	tr := tar.NewReader(gz)
+
+	var manifest *Manifest
+	staged := make(map[string]*stagedFile)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %w", ErrInvalidBundle, err)
+		}
+		if manifest != nil {
+			return nil, nil, fmt.Errorf("%w: %s follows the manifest", ErrInvalidBundle, header.Name)
+		}
+		if header.Typeflag != tar.TypeReg {
+			return nil, nil, fmt.Errorf("%w: %s is not a regular file", ErrInvalidBundle, header.Name)
+		}
+
+		if header.Name == ManifestName {
+			manifest = &Manifest{}
+			if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+				return nil, nil, fmt.Errorf("%w: failed to decode manifest: %w", ErrInvalidBundle, err)
+			}
+			continue
+		}
+
+		if err := validateKey(header.Name); err != nil {
+			return nil, nil, err
+		}
+		if staged[header.Name] != nil {
+			return nil, nil, fmt.Errorf("%w: duplicate entry %s", ErrInvalidBundle, header.Name)
+		}
+		file, err := stageFile(tr, header.Name, filepath.Join(dir, strconv.Itoa(len(staged))))
+		if err != nil {
+			return nil, nil, err
+		}
+		staged[header.Name] = file
+	}
+
+	if manifest == nil {
+		return nil, nil, fmt.Errorf("%w: missing %s", ErrInvalidBundle, ManifestName)
+	}
+	return manifest, staged, nil
+}
+
+// stageFile copies the current entry of the bundle to path, computing its checksum
+func stageFile(tr *tar.Reader, key, path string) (*stagedFile, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stage %s: %w", key, err)
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, hash), tr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read %s: %w", ErrInvalidBundle, key, err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("failed to stage %s: %w", key, err)
+	}
+
+	return &stagedFile{
+		File: File{
+			Key:    key,
+			Size:   size,
+			SHA256: hex.EncodeToString(hash.Sum(nil)),
+		},
+		path: path,
+	}, nil
+}
+
+// verify checks that the staged files are exactly the files of the manifest, with the same checksums
+func verify(manifest *Manifest, staged map[string]*stagedFile) error {
+	if manifest.Version < 1 || manifest.Version > FormatVersion {
+		return fmt.Errorf("%w: unsupported format version %d", ErrInvalidBundle, manifest.Version)
+	}
+
+	listed := make(map[string]bool, len(manifest.Files))
+	for _, file := range manifest.Files {
+		if listed[file.Key] {
+			return fmt.Errorf("%w: %s is listed twice in the manifest", ErrInvalidBundle, file.Key)
+		}
+		listed[file.Key] = true
+
+		entry, ok := staged[file.Key]
+		if !ok {
+			return fmt.Errorf("%w: %s is missing", ErrInvalidBundle, file.Key)
+		}
+		if entry.Size != file.Size || entry.SHA256 != strings.ToLower(file.SHA256) {
+			return fmt.Errorf("%w: %w for %s", ErrInvalidBundle, errclass.ErrChecksum, file.Key)
+		}
+	}
+	for key := range staged {
+		if !listed[key] {
+			return fmt.Errorf("%w: %s is not listed in the manifest", ErrInvalidBundle, key)
+		}
+	}
+	return nil
+}
+
+// validateKey checks that a bundle entry is a cached artifact or the metadata document of one
+func validateKey(key string) error {
+	prefix := key
+	if rest, ok := strings.CutPrefix(key, metadata.KeyPrefix); ok {
+		prefix = ""
+		for _, documents := range metadataPrefixes {
+			if artifact, ok := strings.CutPrefix(rest, documents); ok {
+				prefix = artifact
+				break
+			}
+		}
+	}
+	if prefix == "" || strings.HasSuffix(key, "/") || ValidatePrefix(prefix) != nil {
+		return fmt.Errorf("%w: unexpected entry %s", ErrInvalidBundle, key)
+	}
+	return nil
+}
+
+// writeFile writes a staged file to the storage, returning false for artifacts that were cached already
+func (i *Importer) writeFile(ctx context.Context, file *stagedFile) (bool, error) {
+	if strings.HasPrefix(file.Key, metadata.KeyPrefix) {
+		// Storage backends don't all overwrite existing keys, so remove the previous version first
+		if err := i.storage.Delete(ctx, file.Key); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return false, fmt.Errorf("failed to replace %s: %w", file.Key, err)
+		}
+	} else {
+		exists, err := i.storage.Exists(ctx, file.Key)
+		if err != nil {
+			return false, fmt.Errorf("failed to check %s: %w", file.Key, err)
+		}
+		if exists {
+			return false, nil
+		}
+	}
+
+	f, err := os.Open(file.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read staged %s: %w", file.Key, err)
+	}
+	defer f.Close()
+
+	if err := i.storage.Put(ctx, file.Key, f); err != nil {
+		return false, fmt.Errorf("failed to write %s: %w", file.Key, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,196 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"slices"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/storage"
+)
+
+// entry is a file of a handcrafted bundle
+type entry struct {
+	name    string
+	content string
+}
+
+// writeBundle builds a bundle from entries, with a manifest listing them unless manifest is given
+func writeBundle(t *testing.T, entries []entry, manifest *Manifest) []byte {
+	t.Helper()
+	if manifest == nil {
+		manifest = &Manifest{Version: FormatVersion}
+		for _, e := range entries {
+			manifest.Files = append(manifest.Files, File{Key: e.name, Size: int64(len(e.content)), SHA256: sha256Hex(e.content)})
+		}
+	}
+	data, err := json.Marshal(manifest)
+	require.NoError(t, err)
+	entries = append(slices.Clip(entries), entry{name: ManifestName, content: string(data)})
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.content))}))
+		_, err := tw.Write([]byte(e.content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func readKey(t *testing.T, store storage.Storage, key string) string {
+	t.Helper()
+	r, err := store.Get(t.Context(), key)
+	require.NoError(t, err)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestImport_RoundTrip(t *testing.T) {
+	binary := "providers/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip"
+	index := "metadata/indexes/providers/registry.terraform.io/hashicorp/aws.json"
+	source := newTestStorage(t, map[string]string{
+		binary:                                 "aws binary",
+		"metadata/origins/" + binary + ".json": `{"key": "origin"}`,
+		index:                                  `{"response": {"new": true}}`,
+	})
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	var buf bytes.Buffer
+	_, err := NewExporter(source, logger).Export(t.Context(), &buf, nil)
+	require.NoError(t, err)
+
+	// Metadata documents are replaced, unlike artifacts
+	target := newTestStorage(t, map[string]string{index: `{"response": {"old": true}}`})
+	result, err := NewImporter(target, logger).Import(t.Context(), &buf)
+	require.NoError(t, err)
+	assert.Equal(t, &ImportResult{Files: 3, Imported: 3}, result)
+
+	assert.Equal(t, "aws binary", readKey(t, target, binary))
+	assert.Equal(t, `{"key": "origin"}`, readKey(t, target, "metadata/origins/"+binary+".json"))
+	assert.Equal(t, `{"response": {"new": true}}`, readKey(t, target, index))
+}
+
+func TestImport_SkipsCachedArtifacts(t *testing.T) {
+	binary := "providers/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip"
+	module := "modules/hashicorp/consul/aws/0.1.0/archive.tar.gz"
+	target := newTestStorage(t, map[string]string{binary: "aws binary"})
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	data := writeBundle(t, []entry{{binary, "aws binary"}, {module, "module"}}, nil)
+	result, err := NewImporter(target, logger).Import(t.Context(), bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, &ImportResult{Files: 2, Imported: 1, Skipped: 1}, result)
+	assert.Equal(t, "module", readKey(t, target, module))
+}
+
+func TestImport_InvalidBundles(t *testing.T) {
+	binary := "providers/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip"
+	module := "modules/hashicorp/consul/aws/0.1.0/archive.tar.gz"
+	valid := []entry{{binary, "aws binary"}, {module, "module"}}
+
+	tests := []struct {
+		name    string
+		bundle  []byte
+		message string
+	}{
+		{
+			name:    "not gzip",
+			bundle:  []byte("not a bundle"),
+			message: "invalid bundle",
+		},
+		{
+			name: "checksum mismatch",
+			bundle: writeBundle(t, []entry{{binary, "tampered!!"}, {module, "module"}}, &Manifest{
+				Version: FormatVersion,
+				Files: []File{
+					{Key: binary, Size: 10, SHA256: sha256Hex("aws binary")},
+					{Key: module, Size: 6, SHA256: sha256Hex("module")},
+				},
+			}),
+			message: "checksum mismatch for " + binary,
+		},
+		{
+			name: "missing file",
+			bundle: writeBundle(t, valid[:1], &Manifest{
+				Version: FormatVersion,
+				Files: []File{
+					{Key: binary, Size: 10, SHA256: sha256Hex("aws binary")},
+					{Key: module, Size: 6, SHA256: sha256Hex("module")},
+				},
+			}),
+			message: module + " is missing",
+		},
+		{
+			name: "unlisted file",
+			bundle: writeBundle(t, valid, &Manifest{
+				Version: FormatVersion,
+				Files:   []File{{Key: binary, Size: 10, SHA256: sha256Hex("aws binary")}},
+			}),
+			message: module + " is not listed in the manifest",
+		},
+		{
+			name:    "unsupported version",
+			bundle:  writeBundle(t, nil, &Manifest{Version: FormatVersion + 1}),
+			message: "unsupported format version",
+		},
+		{
+			name:    "metadata not describing artifacts",
+			bundle:  writeBundle(t, []entry{{"metadata/auth/keys.json", `{"keys": []}`}}, nil),
+			message: "unexpected entry metadata/auth/keys.json",
+		},
+		{
+			name:    "path traversal",
+			bundle:  writeBundle(t, []entry{{"providers/../../etc/passwd", "root"}}, nil),
+			message: "unexpected entry",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := newTestStorage(t, nil)
+			logger := logrus.New()
+			logger.SetOutput(io.Discard)
+
+			_, err := NewImporter(target, logger).Import(t.Context(), bytes.NewReader(tt.bundle))
+			require.ErrorIs(t, err, ErrInvalidBundle)
+			assert.Contains(t, err.Error(), tt.message)
+
+			// Nothing is written unless the whole bundle is valid
+			exists, err := target.Exists(t.Context(), binary)
+			require.NoError(t, err)
+			assert.False(t, exists)
+		})
+	}
+}
+
+func TestImport_MissingManifest(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "modules/a/b/c/1.0.0/archive.tar.gz", Mode: 0644, Size: 1}))
+	_, err := tw.Write([]byte("x"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	_, err = NewImporter(newTestStorage(t, nil), logger).Import(t.Context(), &buf)
+	require.ErrorIs(t, err, ErrInvalidBundle)
+	assert.Contains(t, err.Error(), "missing manifest.json")
+}
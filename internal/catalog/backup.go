@@ -0,0 +1,94 @@
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+)
+
+// WriteTo saves the access times and writes a consistent copy of the database to w, while the catalog is in use
+func (c *Catalog) WriteTo(w io.Writer) (int64, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.db == nil {
+		return 0, errors.New("catalog is closed")
+	}
+	if err := c.save(); err != nil {
+		return 0, err
+	}
+
+	var n int64
+	err := c.db.View(func(tx *bolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
+		return err
+	})
+	return n, err
+}
+
+// Restore replaces the database at path with a copy written by WriteTo. The catalog must not be open, Restore
+// gives up like Open if another process holds it.
+func Restore(path string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create catalog directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".restore-*")
+	if err != nil {
+		return fmt.Errorf("failed to restore catalog: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to restore catalog: %w", err)
+	}
+
+	// The copy must be a catalog database
+	db, err := bolt.Open(tmp.Name(), 0644, &bolt.Options{Timeout: openTimeout})
+	if err == nil {
+		err = db.View(func(tx *bolt.Tx) error {
+			if tx.Bucket(filesBucket) == nil {
+				return errors.New("no files bucket")
+			}
+			return nil
+		})
+		if closeErr := db.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("invalid catalog copy: %w", err)
+	}
+
+	// The database being replaced is locked while a server uses it, it may be corrupt otherwise
+	if err := moveLog(path); err != nil {
+		return fmt.Errorf("failed to restore catalog: %w", err)
+	}
+	if _, err := os.Stat(path); err == nil {
+		current, err := bolt.Open(path, 0644, &bolt.Options{Timeout: openTimeout})
+		if errors.Is(err, berrors.ErrTimeout) {
+			return fmt.Errorf("catalog %s is in use: %w", path, err)
+		}
+		if err == nil {
+			defer current.Close()
+		}
+	}
+	// A log of an earlier version would be imported over the restored database
+	if err := os.Remove(path + logSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to restore catalog: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to restore catalog: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package catalog
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/storage"
+)
+
+func TestCatalog_WriteToAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	accessed := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	c, _, err := Open(filepath.Join(dir, "catalog.db"), newTestLogger())
+	require.NoError(t, err)
+	c.Put(Entry{Key: "a", Size: 1})
+	c.Put(Entry{Key: "b", Size: 2})
+	c.Touch("a", accessed)
+
+	// The copy is taken while the catalog is open, with the access times
+	var copied bytes.Buffer
+	_, err = c.WriteTo(&copied)
+	require.NoError(t, err)
+	c.Delete("b")
+
+	// A catalog in use isn't replaced
+	path := filepath.Join(dir, "catalog.db")
+	defer func(timeout time.Duration) { openTimeout = timeout }(openTimeout)
+	openTimeout = 100 * time.Millisecond
+	assert.ErrorContains(t, Restore(path, bytes.NewReader(copied.Bytes())), "in use")
+	require.NoError(t, c.Close())
+
+	require.NoError(t, Restore(path, bytes.NewReader(copied.Bytes())))
+	c, created, err := Open(path, newTestLogger())
+	require.NoError(t, err)
+	defer c.Close()
+	assert.False(t, created)
+	assert.Equal(t, []string{"a", "b"}, keys(c.List("", storage.ListOptions{})))
+	entry, _ := c.Get("a")
+	assert.Equal(t, accessed, entry.LastAccess)
+}
+
+func TestRestore_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "catalog.db")
+	require.NoError(t, os.WriteFile(path, []byte("corrupt"), 0644))
+
+	assert.ErrorContains(t, Restore(path, strings.NewReader("not a database")), "invalid catalog copy")
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "corrupt", string(data))
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "the copy is removed")
+}
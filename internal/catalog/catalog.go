@@ -0,0 +1,431 @@
+// Package catalog keeps an embedded database of the cached files: their keys, sizes, checksums and access times.
+// Listing the cache, measuring it and selecting the files to evict read the catalog instead of walking the
+// cache directory or paginating the bucket.
+package catalog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+
+	"cachetf/internal/storage"
+)
+
+// Entry describes a cached file
+type Entry struct {
+	Key string `json:"key"`
+	// Size is the size of the file in bytes
+	Size int64 `json:"size"`
+	// Modified is when the file was written
+	Modified time.Time `json:"modified"`
+	// SHA256 is the checksum of the file, empty if it wasn't written through the catalog
+	SHA256 string `json:"sha256,omitempty"`
+	// LastAccess is when the file was last read or written through the catalog, zero if never
+	LastAccess time.Time `json:"lastAccess,omitempty"`
+	// Accesses is the number of reads and writes through the catalog
+	Accesses int64 `json:"accesses,omitempty"`
+}
+
+// filesBucket is the bucket of the database holding the entries, by key
+var filesBucket = []byte("files")
+
+// openTimeout is how long Open waits for another process to release the database file, replaceable for tests
+var openTimeout = 5 * time.Second
+
+// Catalog is an embedded database of the cached files. It's held in memory and persisted to a bbolt database file,
+// every change is written to the file before the change returns. Access times are only persisted by Save.
+type Catalog struct {
+	path   string
+	logger *logrus.Logger
+	db     *bolt.DB
+
+	mu      sync.RWMutex
+	entries map[string]*Entry
+	// keys are the keys of the entries, sorted
+	keys []string
+	// touched are the keys of the entries whose access times changed since the last save
+	touched map[string]struct{}
+	// writeMu serializes the changes, which are written to the database outside of mu in the order they're applied
+	writeMu sync.Mutex
+}
+
+// Open opens the catalog persisted at path, creating it if it doesn't exist. It returns true if the catalog was
+// created, its entries are then missing until it's rebuilt.
+func Open(path string, logger *logrus.Logger) (*Catalog, bool, error) {
+	c := &Catalog{
+		path:    path,
+		logger:  logger,
+		entries: make(map[string]*Entry),
+		touched: make(map[string]struct{}),
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, false, fmt.Errorf("failed to create catalog directory: %w", err)
+	}
+	// Catalogs of earlier versions are a log of JSON records, they're moved aside and imported
+	if err := moveLog(path); err != nil {
+		return nil, false, fmt.Errorf("failed to import catalog: %w", err)
+	}
+
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open catalog %s: %w", path, err)
+	}
+	c.db = db
+
+	created := false
+	err = db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(filesBucket) == nil {
+			created = true
+		}
+		_, err := tx.CreateBucketIfNotExists(filesBucket)
+		return err
+	})
+	if err == nil {
+		err = c.load()
+	}
+	if err == nil {
+		var imported bool
+		if imported, err = c.importLog(); imported {
+			created = false
+		}
+	}
+	if err != nil {
+		db.Close()
+		return nil, false, fmt.Errorf("failed to load catalog: %w", err)
+	}
+
+	logger.WithFields(logrus.Fields{
+		"path":    path,
+		"entries": len(c.entries),
+		"created": created,
+	}).Info("Opened the catalog of cached files")
+	return c, created, nil
+}
+
+// load reads the entries of the database
+func (c *Catalog) load() error {
+	return c.db.View(func(tx *bolt.Tx) error {
+		// Keys are iterated in order, the sorted keys are built as they're read
+		return tx.Bucket(filesBucket).ForEach(func(k, v []byte) error {
+			var entry Entry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return fmt.Errorf("invalid entry %q: %w", k, err)
+			}
+			c.entries[entry.Key] = &entry
+			c.keys = append(c.keys, entry.Key)
+			return nil
+		})
+	})
+}
+
+// update writes changes to the files bucket in a transaction, the caller holds writeMu
+func (c *Catalog) update(fn func(files *bolt.Bucket) error) error {
+	if c.db == nil {
+		return errors.New("catalog is closed")
+	}
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return fn(tx.Bucket(filesBucket))
+	})
+}
+
+// putEntries writes entries to the files bucket
+func putEntries(files *bolt.Bucket, entries ...*Entry) error {
+	for _, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if err := files.Put([]byte(entry.Key), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertKey adds a key to the sorted keys if it's missing
+func (c *Catalog) insertKey(key string) {
+	i := sort.SearchStrings(c.keys, key)
+	if i < len(c.keys) && c.keys[i] == key {
+		return
+	}
+	c.keys = append(c.keys, "")
+	copy(c.keys[i+1:], c.keys[i:])
+	c.keys[i] = key
+}
+
+// removeKey removes a key from the sorted keys
+func (c *Catalog) removeKey(key string) {
+	i := sort.SearchStrings(c.keys, key)
+	if i < len(c.keys) && c.keys[i] == key {
+		c.keys = append(c.keys[:i], c.keys[i+1:]...)
+	}
+}
+
+// Put adds or replaces the entry of a file
+func (c *Catalog) Put(entry Entry) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.mu.Lock()
+	c.entries[entry.Key] = &entry
+	c.insertKey(entry.Key)
+	delete(c.touched, entry.Key)
+	c.mu.Unlock()
+
+	if err := c.update(func(files *bolt.Bucket) error { return putEntries(files, &entry) }); err != nil {
+		c.logger.WithError(err).WithField("key", entry.Key).Error("Failed to persist catalog change")
+	}
+}
+
+// Touch records an access to a file, files missing from the catalog are ignored
+func (c *Catalog) Touch(key string, at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	entry.LastAccess = at
+	entry.Accesses++
+	c.touched[key] = struct{}{}
+}
+
+// Delete removes the entry of a file
+func (c *Catalog) Delete(key string) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.mu.Lock()
+	_, ok := c.entries[key]
+	delete(c.entries, key)
+	delete(c.touched, key)
+	c.removeKey(key)
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	if err := c.update(func(files *bolt.Bucket) error { return files.Delete([]byte(key)) }); err != nil {
+		c.logger.WithError(err).WithField("key", key).Error("Failed to persist catalog change")
+	}
+}
+
+// DeletePrefix removes the entries of the files whose key starts with prefix
+func (c *Catalog) DeletePrefix(prefix string) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.mu.Lock()
+	start := sort.SearchStrings(c.keys, prefix)
+	end := start
+	for end < len(c.keys) && strings.HasPrefix(c.keys[end], prefix) {
+		delete(c.entries, c.keys[end])
+		delete(c.touched, c.keys[end])
+		end++
+	}
+	deleted := slices.Clone(c.keys[start:end])
+	c.keys = append(c.keys[:start], c.keys[end:]...)
+	c.mu.Unlock()
+	if len(deleted) == 0 {
+		return
+	}
+
+	err := c.update(func(files *bolt.Bucket) error {
+		for _, key := range deleted {
+			if err := files.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.logger.WithError(err).WithField("prefix", prefix).Error("Failed to persist catalog change")
+	}
+}
+
+// Get returns the entry of a file
+func (c *Catalog) Get(key string) (Entry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return Entry{}, false
+	}
+	return *entry, true
+}
+
+// List returns a page of the files whose key starts with prefix, ordered by key like storage.Storage.List
+func (c *Catalog) List(prefix string, opts storage.ListOptions) *storage.ListResult {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	i := sort.SearchStrings(c.keys, prefix)
+	if opts.StartAfter > prefix {
+		i = sort.Search(len(c.keys), func(i int) bool { return c.keys[i] > opts.StartAfter })
+	}
+
+	result := &storage.ListResult{}
+	for ; i < len(c.keys) && strings.HasPrefix(c.keys[i], prefix); i++ {
+		if opts.MaxKeys > 0 && len(result.Objects) == opts.MaxKeys {
+			result.IsTruncated = true
+			result.NextStartAfter = result.Objects[len(result.Objects)-1].Key
+			break
+		}
+		entry := c.entries[c.keys[i]]
+		result.Objects = append(result.Objects, storage.ObjectInfo{
+			Key:          entry.Key,
+			Size:         entry.Size,
+			LastModified: entry.Modified,
+		})
+	}
+	return result
+}
+
+// Len returns the number of files in the catalog
+func (c *Catalog) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.entries)
+}
+
+// Stats returns the number of files in the catalog and their total size
+func (c *Catalog) Stats() (files int, size int64) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, entry := range c.entries {
+		size += entry.Size
+	}
+	return len(c.entries), size
+}
+
+// Replace reconciles the catalog with a listing of the storage taken since start: listed files are added or
+// updated, keeping the access times and checksum of unchanged ones, and entries of files written before start that
+// weren't listed are removed. Files written while the storage was listed are kept.
+func (c *Catalog) Replace(listed []storage.ObjectInfo, start time.Time) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.mu.Lock()
+	entries := make(map[string]*Entry, len(listed))
+	for _, obj := range listed {
+		entry := &Entry{Key: obj.Key, Size: obj.Size, Modified: obj.LastModified}
+		if previous, ok := c.entries[obj.Key]; ok {
+			entry.LastAccess = previous.LastAccess
+			entry.Accesses = previous.Accesses
+			if previous.Size == obj.Size {
+				entry.SHA256 = previous.SHA256
+			}
+		}
+		entries[obj.Key] = entry
+	}
+	for key, entry := range c.entries {
+		if _, ok := entries[key]; !ok && !entry.Modified.Before(start) {
+			entries[key] = entry
+		}
+	}
+
+	c.entries = entries
+	c.keys = make([]string, 0, len(entries))
+	for key := range entries {
+		c.keys = append(c.keys, key)
+	}
+	sort.Strings(c.keys)
+	// Every entry is written, with its access times
+	clear(c.touched)
+	snapshot := c.snapshot(c.keys)
+	c.mu.Unlock()
+
+	return c.rewrite(snapshot)
+}
+
+// snapshot copies the entries of keys, the caller holds the lock
+func (c *Catalog) snapshot(keys []string) []*Entry {
+	entries := make([]*Entry, 0, len(keys))
+	for _, key := range keys {
+		if entry, ok := c.entries[key]; ok {
+			copied := *entry
+			entries = append(entries, &copied)
+		}
+	}
+	return entries
+}
+
+// rewrite replaces the entries of the database with entries in a single transaction, the caller holds writeMu
+func (c *Catalog) rewrite(entries []*Entry) error {
+	if c.db == nil {
+		return errors.New("catalog is closed")
+	}
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(filesBucket); err != nil {
+			return err
+		}
+		files, err := tx.CreateBucket(filesBucket)
+		if err != nil {
+			return err
+		}
+		return putEntries(files, entries...)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to rewrite catalog: %w", err)
+	}
+	return nil
+}
+
+// Save persists the access times recorded since the last save
+func (c *Catalog) Save() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.save()
+}
+
+// save persists the access times, the caller holds writeMu
+func (c *Catalog) save() error {
+	c.mu.Lock()
+	keys := make([]string, 0, len(c.touched))
+	for key := range c.touched {
+		keys = append(keys, key)
+	}
+	snapshot := c.snapshot(keys)
+	clear(c.touched)
+	c.mu.Unlock()
+	if len(snapshot) == 0 {
+		return nil
+	}
+
+	if err := c.update(func(files *bolt.Bucket) error { return putEntries(files, snapshot...) }); err != nil {
+		// The access times are saved again next time
+		c.mu.Lock()
+		for _, entry := range snapshot {
+			c.touched[entry.Key] = struct{}{}
+		}
+		c.mu.Unlock()
+		return fmt.Errorf("failed to save access times: %w", err)
+	}
+	return nil
+}
+
+// Close saves the access times and closes the database file
+func (c *Catalog) Close() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.db == nil {
+		return nil
+	}
+	err := c.save()
+	if closeErr := c.db.Close(); err == nil {
+		err = closeErr
+	}
+	c.db = nil
+	return err
+}
@@ -0,0 +1,194 @@
+package catalog
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/storage"
+)
+
+func newTestLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func keys(result *storage.ListResult) []string {
+	var keys []string
+	for _, obj := range result.Objects {
+		keys = append(keys, obj.Key)
+	}
+	return keys
+}
+
+func TestCatalog_PersistsChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "catalog", "catalog.db")
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	c, created, err := Open(path, newTestLogger())
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	c.Put(Entry{Key: "providers/a.zip", Size: 10, Modified: modified, SHA256: "aa"})
+	c.Put(Entry{Key: "providers/b.zip", Size: 20, Modified: modified})
+	c.Put(Entry{Key: "modules/c.tar.gz", Size: 30, Modified: modified})
+	c.Delete("providers/b.zip")
+	c.Touch("providers/a.zip", modified.Add(time.Hour))
+	require.NoError(t, c.Close())
+
+	c, created, err = Open(path, newTestLogger())
+	require.NoError(t, err)
+	assert.False(t, created)
+	defer c.Close()
+
+	assert.Equal(t, 2, c.Len())
+	entry, ok := c.Get("providers/a.zip")
+	require.True(t, ok)
+	assert.Equal(t, Entry{
+		Key:        "providers/a.zip",
+		Size:       10,
+		Modified:   modified,
+		SHA256:     "aa",
+		LastAccess: modified.Add(time.Hour),
+		Accesses:   1,
+	}, entry)
+	_, ok = c.Get("providers/b.zip")
+	assert.False(t, ok)
+
+	files, size := c.Stats()
+	assert.Equal(t, 2, files)
+	assert.Equal(t, int64(40), size)
+}
+
+func TestCatalog_ImportsLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "catalog.db")
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// Catalogs of earlier versions are a log of changes, the last record was cut short by a crash
+	log := `{"put":{"key":"a","size":1,"modified":"2024-01-02T03:04:05Z","lastAccess":"2024-01-02T04:04:05Z","accesses":2}}
+{"put":{"key":"b/1","size":2,"modified":"2024-01-02T03:04:05Z"}}
+{"put":{"key":"b/2","size":3,"modified":"2024-01-02T03:04:05Z"}}
+{"put":{"key":"c","size":4,"modified":"2024-01-02T03:04:05Z"}}
+{"deletePrefix":"b/"}
+{"delete":"c"}
+{"put":{"key":"d","si`
+	require.NoError(t, os.WriteFile(path, []byte(log), 0644))
+
+	c, created, err := Open(path, newTestLogger())
+	require.NoError(t, err)
+	assert.False(t, created)
+	entry, ok := c.Get("a")
+	require.True(t, ok)
+	assert.Equal(t, Entry{Key: "a", Size: 1, Modified: modified, LastAccess: modified.Add(time.Hour), Accesses: 2}, entry)
+	assert.Equal(t, []string{"a"}, keys(c.List("", storage.ListOptions{})))
+	require.NoError(t, c.Close())
+	assert.NoFileExists(t, path+logSuffix)
+
+	// The catalog was converted
+	c, created, err = Open(path, newTestLogger())
+	require.NoError(t, err)
+	defer c.Close()
+	assert.False(t, created)
+	assert.Equal(t, []string{"a"}, keys(c.List("", storage.ListOptions{})))
+}
+
+func TestCatalog_List(t *testing.T) {
+	c, _, err := Open(filepath.Join(t.TempDir(), "catalog.db"), newTestLogger())
+	require.NoError(t, err)
+	defer c.Close()
+
+	for _, key := range []string{"b/2", "a/1", "b/1", "b/3", "c/1"} {
+		c.Put(Entry{Key: key, Size: 1})
+	}
+
+	assert.Equal(t, []string{"a/1", "b/1", "b/2", "b/3", "c/1"}, keys(c.List("", storage.ListOptions{})))
+
+	page := c.List("b/", storage.ListOptions{MaxKeys: 2})
+	assert.Equal(t, []string{"b/1", "b/2"}, keys(page))
+	assert.True(t, page.IsTruncated)
+	assert.Equal(t, "b/2", page.NextStartAfter)
+
+	page = c.List("b/", storage.ListOptions{MaxKeys: 2, StartAfter: page.NextStartAfter})
+	assert.Equal(t, []string{"b/3"}, keys(page))
+	assert.False(t, page.IsTruncated)
+
+	assert.Empty(t, c.List("d/", storage.ListOptions{}).Objects)
+}
+
+func TestCatalog_DeletePrefix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "catalog.db")
+	c, _, err := Open(path, newTestLogger())
+	require.NoError(t, err)
+
+	for _, key := range []string{"a/1", "b/1", "b/2", "c/1"} {
+		c.Put(Entry{Key: key})
+	}
+	c.DeletePrefix("b/")
+	assert.Equal(t, []string{"a/1", "c/1"}, keys(c.List("", storage.ListOptions{})))
+	require.NoError(t, c.Close())
+
+	c, _, err = Open(path, newTestLogger())
+	require.NoError(t, err)
+	defer c.Close()
+	assert.Equal(t, []string{"a/1", "c/1"}, keys(c.List("", storage.ListOptions{})))
+}
+
+func TestCatalog_ClosedIgnoresChanges(t *testing.T) {
+	c, _, err := Open(filepath.Join(t.TempDir(), "catalog.db"), newTestLogger())
+	require.NoError(t, err)
+	require.NoError(t, c.Close())
+
+	// Late writes while shutting down aren't persisted
+	c.Put(Entry{Key: "a", Size: 1})
+	c.Delete("a")
+	assert.Error(t, c.Replace(nil, time.Now()))
+	assert.NoError(t, c.Close())
+}
+
+func TestCatalog_Replace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "catalog.db")
+	c, _, err := Open(path, newTestLogger())
+	require.NoError(t, err)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	accessed := start.Add(-time.Hour)
+	c.Put(Entry{Key: "kept", Size: 1, Modified: start.Add(-2 * time.Hour), SHA256: "aa", LastAccess: accessed, Accesses: 3})
+	c.Put(Entry{Key: "rewritten", Size: 1, Modified: start.Add(-2 * time.Hour), SHA256: "bb"})
+	c.Put(Entry{Key: "deleted", Size: 1, Modified: start.Add(-2 * time.Hour)})
+	c.Put(Entry{Key: "written", Size: 1, Modified: start.Add(time.Minute)})
+
+	require.NoError(t, c.Replace([]storage.ObjectInfo{
+		{Key: "kept", Size: 1, LastModified: start.Add(-2 * time.Hour)},
+		{Key: "rewritten", Size: 2, LastModified: start.Add(-30 * time.Minute)},
+		{Key: "new", Size: 4, LastModified: start.Add(-30 * time.Minute)},
+	}, start))
+
+	assert.Equal(t, []string{"kept", "new", "rewritten", "written"}, keys(c.List("", storage.ListOptions{})))
+
+	// Unchanged files keep their checksum and accesses
+	entry, _ := c.Get("kept")
+	assert.Equal(t, "aa", entry.SHA256)
+	assert.Equal(t, accessed, entry.LastAccess)
+	assert.Equal(t, int64(3), entry.Accesses)
+
+	// The checksum of a file whose size changed is unknown
+	entry, _ = c.Get("rewritten")
+	assert.Equal(t, int64(2), entry.Size)
+	assert.Empty(t, entry.SHA256)
+
+	// The reconciled entries are persisted
+	require.NoError(t, c.Close())
+	c, _, err = Open(path, newTestLogger())
+	require.NoError(t, err)
+	defer c.Close()
+	assert.Equal(t, []string{"kept", "new", "rewritten", "written"}, keys(c.List("", storage.ListOptions{})))
+	entry, _ = c.Get("kept")
+	assert.Equal(t, int64(3), entry.Accesses)
+}
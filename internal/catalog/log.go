@@ -0,0 +1,99 @@
+package catalog
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// logSuffix is appended to the path of a catalog of an earlier version, a log of JSON records, while it's imported
+const logSuffix = ".log"
+
+// record is a line of the log of a catalog of an earlier version, one change to the catalog
+type record struct {
+	Put          *Entry  `json:"put,omitempty"`
+	Delete       string  `json:"delete,omitempty"`
+	DeletePrefix *string `json:"deletePrefix,omitempty"`
+}
+
+// moveLog moves the catalog at path aside if it's a log of JSON records. A log moved aside earlier wasn't imported
+// completely and is kept.
+func moveLog(path string) error {
+	if _, err := os.Stat(path + logSuffix); err == nil {
+		return nil
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	first := make([]byte, 1)
+	_, err = io.ReadFull(f, first)
+	f.Close()
+	// Logs start with a JSON object and are empty before the first change, database files start with a page ID
+	if errors.Is(err, io.EOF) || (err == nil && first[0] == '{') {
+		return os.Rename(path, path+logSuffix)
+	}
+	return err
+}
+
+// importLog replaces the entries of the catalog with the ones of the log moved aside by moveLog, if any, and removes
+// the log. It returns true if a log was imported.
+func (c *Catalog) importLog() (bool, error) {
+	path := c.path + logSuffix
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	entries := make(map[string]*Entry)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var r record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			// The last record may have been cut short by a crash
+			c.logger.WithError(err).Warn("Skipping unreadable catalog record")
+			continue
+		}
+		switch {
+		case r.Put != nil:
+			entries[r.Put.Key] = r.Put
+		case r.Delete != "":
+			delete(entries, r.Delete)
+		case r.DeletePrefix != nil:
+			for key := range entries {
+				if strings.HasPrefix(key, *r.DeletePrefix) {
+					delete(entries, key)
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	c.entries = entries
+	c.keys = make([]string, 0, len(entries))
+	for key := range entries {
+		c.keys = append(c.keys, key)
+	}
+	sort.Strings(c.keys)
+	if err := c.rewrite(c.snapshot(c.keys)); err != nil {
+		return false, err
+	}
+	if err := os.Remove(path); err != nil {
+		return false, err
+	}
+	c.logger.WithField("entries", len(entries)).Info("Imported the catalog of an earlier version")
+	return true, nil
+}
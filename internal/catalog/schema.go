@@ -0,0 +1,75 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	bolt "go.etcd.io/bbolt"
+
+	"cachetf/internal/schema"
+)
+
+// LatestSchemaVersion is the version of the last migration of the database, the newest schema this server
+// understands
+const LatestSchemaVersion = 1
+
+var (
+	// metaBucket is the bucket of the database holding its schema version
+	metaBucket = []byte("meta")
+	// schemaVersionKey is the key of the schema version in metaBucket
+	schemaVersionKey = []byte("schemaVersion")
+)
+
+// SchemaVersion returns the schema version of the database, 0 if none was recorded
+func (c *Catalog) SchemaVersion(ctx context.Context) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.db == nil {
+		return 0, errors.New("catalog is closed")
+	}
+
+	version := 0
+	err := c.db.View(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(metaBucket)
+		if meta == nil {
+			return nil
+		}
+		value := meta.Get(schemaVersionKey)
+		if value == nil {
+			return nil
+		}
+		var err error
+		if version, err = strconv.Atoi(string(value)); err != nil {
+			return fmt.Errorf("invalid schema version %q: %w", value, err)
+		}
+		return nil
+	})
+	return version, err
+}
+
+// SetSchemaVersion records the schema version of the database
+func (c *Catalog) SetSchemaVersion(ctx context.Context, version int) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.db == nil {
+		return errors.New("catalog is closed")
+	}
+
+	return c.db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+		return meta.Put(schemaVersionKey, []byte(strconv.Itoa(version)))
+	})
+}
+
+// Migrations returns the migrations of the database. A migration changing the entries updates the database in a
+// transaction and reloads them, the catalog isn't used by the server until the migrations are applied.
+func (c *Catalog) Migrations() []schema.Migration {
+	return []schema.Migration{
+		{Version: 1, Description: "JSON entries by key in the files bucket"},
+	}
+}
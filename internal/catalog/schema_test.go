@@ -0,0 +1,41 @@
+package catalog
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/schema"
+	"cachetf/internal/storage"
+)
+
+func TestCatalog_SchemaVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "catalog.db")
+	ctx := t.Context()
+	c, _, err := Open(path, newTestLogger())
+	require.NoError(t, err)
+	c.Put(Entry{Key: "a", Size: 1})
+
+	version, err := c.SchemaVersion(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, version)
+
+	target := schema.Target{Name: "catalog", State: c, Migrations: c.Migrations()}
+	_, err = schema.Migrate(ctx, target, newTestLogger())
+	require.NoError(t, err)
+
+	// The version survives a rebuild and a restart
+	require.NoError(t, c.Replace([]storage.ObjectInfo{{Key: "a", Size: 1}}, time.Now()))
+	require.NoError(t, c.Close())
+	c, _, err = Open(path, newTestLogger())
+	require.NoError(t, err)
+	defer c.Close()
+	version, err = c.SchemaVersion(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, LatestSchemaVersion, version)
+	assert.Equal(t, LatestSchemaVersion, target.Latest())
+	assert.Equal(t, []string{"a"}, keys(c.List("", storage.ListOptions{})))
+}
@@ -0,0 +1,247 @@
+package catalog
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/eviction"
+	"cachetf/internal/metadata"
+	"cachetf/internal/metrics"
+	"cachetf/internal/storage"
+)
+
+// Storage wraps a storage backend and keeps the catalog of its files. Once the catalog is ready, the listings are
+// served from it, except those of the metadata documents, which other instances sharing the storage may write and
+// are always listed from the backend. The catalog records the accesses of the files for the Evictor, and keeps
+// them across restarts.
+type Storage struct {
+	storage.Storage
+	catalog *Catalog
+	logger  *logrus.Logger
+	// ready is set once the catalog holds every file of the backend
+	ready atomic.Bool
+	// now is replaceable for tests
+	now func() time.Time
+}
+
+// NewStorage wraps s with the catalog. A catalog that was just created isn't ready until Rebuild lists the backend.
+func NewStorage(s storage.Storage, catalog *Catalog, ready bool, logger *logrus.Logger) *Storage {
+	cs := &Storage{
+		Storage: s,
+		catalog: catalog,
+		logger:  logger,
+		now:     time.Now,
+	}
+	cs.ready.Store(ready)
+	cs.updateMetrics()
+	return cs
+}
+
+// Catalog returns the catalog of the files
+func (s *Storage) Catalog() *Catalog {
+	return s.catalog
+}
+
+// indexed returns true if the file of key is kept in the catalog, metadata documents aren't
+func indexed(key string) bool {
+	return !strings.HasPrefix(key, metadata.KeyPrefix)
+}
+
+// Get retrieves a file and records the access
+func (s *Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	r, err := s.Storage.Get(ctx, key)
+	if err == nil && indexed(key) {
+		s.catalog.Touch(key, s.now())
+	}
+	return r, err
+}
+
+// hashingReader counts and hashes the bytes read through it
+type hashingReader struct {
+	r      io.Reader
+	hasher hash.Hash
+	n      int64
+}
+
+func (r *hashingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.hasher.Write(p[:n])
+	r.n += int64(n)
+	return n, err
+}
+
+// Put stores a file and adds it to the catalog with its size and checksum
+func (s *Storage) Put(ctx context.Context, key string, r io.Reader) error {
+	if !indexed(key) {
+		return s.Storage.Put(ctx, key, r)
+	}
+
+	hr := &hashingReader{r: r, hasher: sha256.New()}
+	if err := s.Storage.Put(ctx, key, hr); err != nil {
+		return err
+	}
+
+	now := s.now()
+	previous, found := s.catalog.Get(key)
+	entry := Entry{
+		Key:        key,
+		Size:       hr.n,
+		Modified:   now,
+		SHA256:     hex.EncodeToString(hr.hasher.Sum(nil)),
+		LastAccess: now,
+		Accesses:   previous.Accesses + 1,
+	}
+	if hr.n == 0 {
+		// Backends keeping existing files don't read the new content, the stored file is described instead
+		obj, ok, err := s.stat(ctx, key)
+		if err != nil {
+			s.logger.WithError(err).WithField("key", key).Warn("Failed to describe stored file for the catalog")
+		}
+		switch {
+		case ok:
+			entry.Size = obj.Size
+			entry.Modified = obj.LastModified
+			entry.SHA256 = ""
+			if found && previous.Size == obj.Size {
+				entry.SHA256 = previous.SHA256
+			}
+		case found:
+			entry = previous
+		}
+	}
+	s.catalog.Put(entry)
+	s.updateMetrics()
+	return nil
+}
+
+// stat lists the stored file of key from the backend
+func (s *Storage) stat(ctx context.Context, key string) (storage.ObjectInfo, bool, error) {
+	page, err := s.Storage.List(ctx, key, storage.ListOptions{MaxKeys: 1})
+	if err != nil {
+		return storage.ObjectInfo{}, false, err
+	}
+	if len(page.Objects) == 0 || page.Objects[0].Key != key {
+		return storage.ObjectInfo{}, false, nil
+	}
+	return page.Objects[0], true, nil
+}
+
+// listsMetadata returns true if the listing of prefix may contain metadata documents. The empty prefix lists the
+// cached files only.
+func listsMetadata(prefix string) bool {
+	return prefix != "" && (strings.HasPrefix(prefix, metadata.KeyPrefix) || strings.HasPrefix(metadata.KeyPrefix, prefix))
+}
+
+// List returns a page of the files from the catalog once it's ready, from the backend otherwise
+func (s *Storage) List(ctx context.Context, prefix string, opts storage.ListOptions) (*storage.ListResult, error) {
+	if !s.ready.Load() || listsMetadata(prefix) {
+		return s.Storage.List(ctx, prefix, opts)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.catalog.List(prefix, opts), nil
+}
+
+// Delete deletes a file and removes it from the catalog
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	err := s.Storage.Delete(ctx, key)
+	if indexed(key) {
+		s.catalog.Delete(key)
+		s.updateMetrics()
+	}
+	return err
+}
+
+// DeleteByPrefix deletes the files with the given prefix and removes them from the catalog
+func (s *Storage) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
+	count, err := s.Storage.DeleteByPrefix(ctx, prefix)
+	s.catalog.DeletePrefix(prefix)
+	s.updateMetrics()
+	return count, err
+}
+
+// Access returns the accesses of key recorded by the catalog, for the Evictor
+func (s *Storage) Access(key string) (eviction.Access, bool) {
+	entry, ok := s.catalog.Get(key)
+	if !ok || entry.LastAccess.IsZero() {
+		return eviction.Access{}, false
+	}
+	return eviction.Access{Last: entry.LastAccess, Count: entry.Accesses}, true
+}
+
+// Rebuild lists the backend and reconciles the catalog with it, catching up with the files written or deleted by
+// other instances sharing the storage. The listings are served from the catalog once it's rebuilt.
+func (s *Storage) Rebuild(ctx context.Context) error {
+	start := s.now()
+	var listed []storage.ObjectInfo
+	err := storage.Walk(ctx, s.Storage, "", func(obj storage.ObjectInfo) error {
+		if indexed(obj.Key) {
+			listed = append(listed, obj)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.catalog.Replace(listed, start); err != nil {
+		return err
+	}
+	s.ready.Store(true)
+	s.updateMetrics()
+
+	files, size := s.catalog.Stats()
+	s.logger.WithFields(logrus.Fields{
+		"files":    files,
+		"size":     size,
+		"duration": s.now().Sub(start),
+	}).Info("Rebuilt the catalog of cached files")
+	return nil
+}
+
+// Run rebuilds the catalog if it isn't ready, then saves the access times every saveInterval and rebuilds the
+// catalog every rebuildInterval, if positive, until ctx is cancelled
+func (s *Storage) Run(ctx context.Context, saveInterval, rebuildInterval time.Duration) {
+	if !s.ready.Load() {
+		if err := s.Rebuild(ctx); err != nil && ctx.Err() == nil {
+			s.logger.WithError(err).Error("Failed to build the catalog, listing the storage instead")
+		}
+	}
+
+	save := time.NewTicker(saveInterval)
+	defer save.Stop()
+	var rebuild <-chan time.Time
+	if rebuildInterval > 0 {
+		ticker := time.NewTicker(rebuildInterval)
+		defer ticker.Stop()
+		rebuild = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-save.C:
+			if err := s.catalog.Save(); err != nil {
+				s.logger.WithError(err).Error("Failed to save the catalog")
+			}
+		case <-rebuild:
+			if err := s.Rebuild(ctx); err != nil && ctx.Err() == nil {
+				s.logger.WithError(err).Error("Failed to rebuild the catalog")
+			}
+		}
+	}
+}
+
+// updateMetrics reports the number of files in the catalog
+func (s *Storage) updateMetrics() {
+	metrics.CatalogFiles.Set(float64(s.catalog.Len()))
+}
@@ -0,0 +1,156 @@
+package catalog
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/storage"
+)
+
+func newTestStorage(t *testing.T) (*Storage, storage.Storage, string) {
+	logger := newTestLogger()
+	dir := t.TempDir()
+	backend := storage.NewLocalStorage(filepath.Join(dir, "cache"), logger)
+	c, _, err := Open(filepath.Join(dir, "catalog.db"), logger)
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+	// The backend is empty, the new catalog is complete
+	return NewStorage(backend, c, true, logger), backend, filepath.Join(dir, "cache")
+}
+
+func TestStorage_Put(t *testing.T) {
+	s, _, _ := newTestStorage(t)
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	require.NoError(t, s.Put(t.Context(), "providers/a.zip", strings.NewReader("content")))
+
+	sum := sha256.Sum256([]byte("content"))
+	entry, ok := s.Catalog().Get("providers/a.zip")
+	require.True(t, ok)
+	assert.Equal(t, Entry{
+		Key:        "providers/a.zip",
+		Size:       7,
+		Modified:   now,
+		SHA256:     hex.EncodeToString(sum[:]),
+		LastAccess: now,
+		Accesses:   1,
+	}, entry)
+
+	// The local storage keeps the existing file without reading the new content, the entry is kept
+	require.NoError(t, s.Put(t.Context(), "providers/a.zip", strings.NewReader("other content")))
+	entry, ok = s.Catalog().Get("providers/a.zip")
+	require.True(t, ok)
+	assert.Equal(t, int64(7), entry.Size)
+	assert.Equal(t, hex.EncodeToString(sum[:]), entry.SHA256)
+
+	// Metadata documents aren't cached files
+	require.NoError(t, s.Put(t.Context(), "metadata/origins/a.json", strings.NewReader("{}")))
+	_, ok = s.Catalog().Get("metadata/origins/a.json")
+	assert.False(t, ok)
+}
+
+func TestStorage_PutDescribesExistingFile(t *testing.T) {
+	s, backend, _ := newTestStorage(t)
+
+	// A file written before the catalog, e.g. by another instance
+	require.NoError(t, backend.Put(t.Context(), "providers/a.zip", strings.NewReader("content")))
+	require.NoError(t, s.Put(t.Context(), "providers/a.zip", strings.NewReader("content")))
+
+	entry, ok := s.Catalog().Get("providers/a.zip")
+	require.True(t, ok)
+	assert.Equal(t, int64(7), entry.Size)
+	assert.Empty(t, entry.SHA256)
+}
+
+func TestStorage_List(t *testing.T) {
+	s, backend, dir := newTestStorage(t)
+	require.NoError(t, s.Put(t.Context(), "providers/a.zip", strings.NewReader("a")))
+	require.NoError(t, s.Put(t.Context(), "metadata/origins/a.json", strings.NewReader("{}")))
+
+	// A file written behind the catalog's back is only listed by the backend
+	require.NoError(t, backend.Put(t.Context(), "providers/b.zip", strings.NewReader("b")))
+
+	page, err := s.List(t.Context(), "", storage.ListOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"providers/a.zip"}, keys(page))
+
+	// Metadata documents are always listed from the backend
+	page, err = s.List(t.Context(), "metadata/", storage.ListOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"metadata/origins/a.json"}, keys(page))
+
+	// Deleting a file removes it from the catalog
+	require.NoError(t, s.Delete(t.Context(), "providers/a.zip"))
+	page, err = s.List(t.Context(), "providers/", storage.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, page.Objects)
+
+	_, err = os.Stat(filepath.Join(dir, "providers/a.zip"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestStorage_Access(t *testing.T) {
+	s, _, _ := newTestStorage(t)
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	_, ok := s.Access("providers/a.zip")
+	assert.False(t, ok)
+
+	require.NoError(t, s.Put(t.Context(), "providers/a.zip", strings.NewReader("a")))
+	now = now.Add(time.Hour)
+	r, err := s.Get(t.Context(), "providers/a.zip")
+	require.NoError(t, err)
+	r.Close()
+
+	access, ok := s.Access("providers/a.zip")
+	require.True(t, ok)
+	assert.Equal(t, now, access.Last)
+	assert.Equal(t, int64(2), access.Count)
+}
+
+func TestStorage_Rebuild(t *testing.T) {
+	logger := newTestLogger()
+	dir := t.TempDir()
+	backend := storage.NewLocalStorage(filepath.Join(dir, "cache"), logger)
+	require.NoError(t, backend.Put(t.Context(), "providers/a.zip", strings.NewReader("a")))
+	require.NoError(t, backend.Put(t.Context(), "metadata/origins/a.json", strings.NewReader("{}")))
+
+	c, created, err := Open(filepath.Join(dir, "catalog.db"), logger)
+	require.NoError(t, err)
+	defer c.Close()
+	s := NewStorage(backend, c, !created, logger)
+
+	// A new catalog lists the backend until it's built
+	require.NoError(t, backend.Put(t.Context(), "providers/b.zip", strings.NewReader("bb")))
+	page, err := s.List(t.Context(), "providers/", storage.ListOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"providers/a.zip", "providers/b.zip"}, keys(page))
+
+	require.NoError(t, s.Rebuild(t.Context()))
+	assert.Equal(t, 2, c.Len())
+	files, size := c.Stats()
+	assert.Equal(t, 2, files)
+	assert.Equal(t, int64(3), size)
+
+	page, err = s.List(t.Context(), "", storage.ListOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"providers/a.zip", "providers/b.zip"}, keys(page))
+
+	// Reopened, the catalog is ready without listing the backend
+	require.NoError(t, c.Close())
+	c, created, err = Open(filepath.Join(dir, "catalog.db"), logger)
+	require.NoError(t, err)
+	assert.False(t, created)
+	defer c.Close()
+	assert.Equal(t, 2, c.Len())
+}
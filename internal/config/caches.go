@@ -0,0 +1,239 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"cachetf/internal/pins"
+	"cachetf/internal/storage"
+)
+
+// cacheNamePattern restricts cache names to what can be part of an environment variable name
+var cacheNamePattern = regexp.MustCompile(`^[a-z][a-z0-9]*$`)
+
+// CacheConfig holds the settings of an additional cache, served by the same process under its own URI prefix,
+// e.g. a dev mirror next to the prod one. The settings are read from CACHE_<NAME>_* variables, and the ones
+// that aren't storage locations default to the settings of the primary cache.
+type CacheConfig struct {
+	// Name identifies the cache in the CACHES list
+	Name        string
+	URIPrefix   string      `env:"CACHE_<NAME>_URI_PREFIX"`
+	StorageType StorageType `env:"CACHE_<NAME>_STORAGE_TYPE"`
+	CacheDir    string      `env:"CACHE_<NAME>_DIR"`
+	Dedup       bool        `env:"CACHE_<NAME>_DEDUP"`
+	// S3 is read from CACHE_<NAME>_S3_BUCKET, CACHE_<NAME>_S3_REGION, CACHE_<NAME>_S3_KEY_PREFIX,
+	// CACHE_<NAME>_S3_ENDPOINT, CACHE_<NAME>_S3_USE_PATH_STYLE, CACHE_<NAME>_S3_DISABLE_SSL and
+	// CACHE_<NAME>_S3_ROLE_ARN, CACHE_<NAME>_S3_ROLE_EXTERNAL_ID, CACHE_<NAME>_S3_ROLE_SESSION_NAME,
+	// CACHE_<NAME>_S3_SSE, CACHE_<NAME>_S3_SSE_KMS_KEY_ID, CACHE_<NAME>_S3_REQUESTER_PAYS and
+	// CACHE_<NAME>_S3_STORAGE_CLASS
+	S3 S3Config
+	// Expiration is read from CACHE_<NAME>_TTL, the interval is the one of the primary cache
+	Expiration ExpirationConfig
+	// Eviction is read from CACHE_<NAME>_MAX_SIZE_BYTES, CACHE_<NAME>_EVICTION_POLICY and
+	// CACHE_<NAME>_DISK_RESERVE, the interval is the one of the primary cache
+	Eviction EvictionConfig
+	Pins     string `env:"CACHE_<NAME>_PINS"`
+}
+
+// envPrefix returns the prefix of the environment variables of the cache
+func (c *CacheConfig) envPrefix() string {
+	return "CACHE_" + strings.ToUpper(c.Name) + "_"
+}
+
+// StorageOptions returns the options the backend of the cache is created with
+func (c *CacheConfig) StorageOptions() storage.Options {
+	opts := storage.Options{CacheDir: c.CacheDir, Dedup: c.Dedup, DiskReserve: c.Eviction.DiskReserve}
+	if c.StorageType == StorageTypeS3 || c.StorageType == StorageTypeTiered {
+		opts.Config = c.S3.StorageConfig()
+	}
+	return opts
+}
+
+// Validate checks if the cache configuration is valid on its own
+func (c *CacheConfig) Validate() error {
+	if !cacheNamePattern.MatchString(c.Name) {
+		return fmt.Errorf("invalid cache name %q in CACHES: must be lowercase letters and digits", c.Name)
+	}
+	prefix := c.envPrefix()
+	var errs Errors
+
+	if !strings.HasPrefix(c.URIPrefix, "/") || strings.Trim(c.URIPrefix, "/") == "" {
+		errs.add(fmt.Errorf("%sURI_PREFIX is required and must be a path below /", prefix))
+	}
+
+	switch c.StorageType {
+	case StorageTypeLocal:
+		if c.CacheDir == "" {
+			errs.add(fmt.Errorf("%sDIR is required when using local storage", prefix))
+		}
+	case StorageTypeS3:
+		if err := c.S3.Validate(); err != nil {
+			errs.add(fmt.Errorf("invalid %sS3_* configuration: %w", prefix, err))
+		}
+	case StorageTypeTiered:
+		if c.CacheDir == "" {
+			errs.add(fmt.Errorf("%sDIR is required when using tiered storage", prefix))
+		}
+		if err := c.S3.Validate(); err != nil {
+			errs.add(fmt.Errorf("invalid %sS3_* configuration: %w", prefix, err))
+		}
+	default:
+		errs.add(fmt.Errorf("invalid %sSTORAGE_TYPE: must be 'local', 's3' or 'tiered'", prefix))
+	}
+
+	if err := validateRetention(c.Expiration, c.Eviction, c.StorageType); err != nil {
+		errs.add(fmt.Errorf("invalid retention of cache %s: %w", c.Name, err))
+	}
+
+	if _, err := pins.ParsePins(c.Pins); err != nil {
+		errs.add(fmt.Errorf("invalid %sPINS: %w", prefix, err))
+	}
+	return errs.err()
+}
+
+// validateCaches checks that the additional caches share neither routes nor storage with each other
+// or with the primary cache
+func (c *Config) validateCaches() error {
+	type claim struct {
+		owner string
+		value string
+	}
+
+	routes := []claim{{"URI_PREFIX", routePrefix(c.URIPrefix)}}
+	if c.Modules.Enabled {
+		routes = append(routes, claim{"MODULES_URI_PREFIX", routePrefix(c.Modules.URIPrefix)})
+	}
+	var locations []claim
+	for _, location := range storageLocations(c.StorageType, c.CacheDir, c.S3) {
+		locations = append(locations, claim{"the primary cache", location})
+	}
+	names := make(map[string]bool, len(c.Caches))
+	var errs Errors
+
+	for _, cache := range c.Caches {
+		if err := cache.Validate(); err != nil {
+			errs.add(err)
+			continue
+		}
+		if names[cache.Name] {
+			errs.add(fmt.Errorf("duplicate cache %q in CACHES", cache.Name))
+			continue
+		}
+		names[cache.Name] = true
+
+		route := claim{cache.envPrefix() + "URI_PREFIX", routePrefix(cache.URIPrefix)}
+		for _, other := range routes {
+			if overlaps(route.value, other.value) {
+				errs.add(fmt.Errorf("%s overlaps %s", route.owner, other.owner))
+			}
+		}
+		routes = append(routes, route)
+
+		var claimed []claim
+		for _, value := range storageLocations(cache.StorageType, cache.CacheDir, cache.S3) {
+			location := claim{"cache " + cache.Name, value}
+			for _, other := range locations {
+				if overlaps(location.value, other.value) {
+					errs.add(fmt.Errorf("the storage of %s overlaps the storage of %s", location.owner, other.owner))
+				}
+			}
+			claimed = append(claimed, location)
+		}
+		locations = append(locations, claimed...)
+	}
+	return errs.err()
+}
+
+// routePrefix normalizes a URI prefix for comparisons
+func routePrefix(prefix string) string {
+	if prefix = strings.Trim(prefix, "/"); prefix == "" {
+		return "/"
+	}
+	return "/" + prefix + "/"
+}
+
+// storageLocations returns normalized URLs of the storage of a cache for comparisons, tiered storage
+// occupying both a directory and a bucket
+func storageLocations(storageType StorageType, cacheDir string, s3 S3Config) []string {
+	// Buckets of S3-compatible services are told apart by their endpoint
+	bucket := "s3://" + s3.Bucket + "/"
+	if s3.Endpoint != "" {
+		bucket = "s3://" + s3.Bucket + "@" + strings.TrimSuffix(s3.Endpoint, "/") + "/"
+	}
+	if prefix := strings.Trim(s3.KeyPrefix, "/"); prefix != "" {
+		bucket += prefix + "/"
+	}
+	dir, err := filepath.Abs(cacheDir)
+	if err != nil {
+		dir = filepath.Clean(cacheDir)
+	}
+	local := "file://" + strings.TrimSuffix(filepath.ToSlash(dir), "/") + "/"
+
+	switch storageType {
+	case StorageTypeS3:
+		return []string{bucket}
+	case StorageTypeAzure, StorageTypeB2, StorageTypeOCI, StorageTypeSFTP, StorageTypeWebDAV:
+		// Additional caches can't use Azure, B2, OCI, SFTP or WebDAV, so the storage of the primary cache can't be shared
+		return nil
+	case StorageTypeTiered:
+		return []string{local, bucket}
+	default:
+		return []string{local}
+	}
+}
+
+// overlaps returns true if one of two normalized prefixes contains the other
+func overlaps(a, b string) bool {
+	return strings.HasPrefix(a, b) || strings.HasPrefix(b, a)
+}
+
+// loadCaches loads the additional caches listed in CACHES, defaulting to the settings of the primary cache
+func loadCaches(env *envParser, primary *Config) []CacheConfig {
+	var caches []CacheConfig
+	for _, name := range splitList(getEnv("CACHES", "")) {
+		cache := CacheConfig{Name: name}
+		prefix := cache.envPrefix()
+		get := func(key, defaultValue string) string {
+			return getEnv(prefix+key, defaultValue)
+		}
+
+		cache.URIPrefix = get("URI_PREFIX", "")
+		cache.StorageType = StorageType(get("STORAGE_TYPE", string(primary.StorageType)))
+		cache.CacheDir = get("DIR", "")
+		cache.Dedup = env.bool(prefix+"DEDUP", strconv.FormatBool(primary.CacheDedup))
+		cache.S3 = S3Config{
+			Bucket:       get("S3_BUCKET", primary.S3.Bucket),
+			Region:       get("S3_REGION", primary.S3.Region),
+			KeyPrefix:    get("S3_KEY_PREFIX", ""),
+			Endpoint:     get("S3_ENDPOINT", primary.S3.Endpoint),
+			UsePathStyle: env.bool(prefix+"S3_USE_PATH_STYLE", strconv.FormatBool(primary.S3.UsePathStyle)),
+			DisableSSL:   env.bool(prefix+"S3_DISABLE_SSL", strconv.FormatBool(primary.S3.DisableSSL)),
+			// A cache may use its own role, e.g. for a bucket in another account
+			RoleARN:         get("S3_ROLE_ARN", primary.S3.RoleARN),
+			ExternalID:      get("S3_ROLE_EXTERNAL_ID", primary.S3.ExternalID),
+			RoleSessionName: get("S3_ROLE_SESSION_NAME", primary.S3.RoleSessionName),
+			// The encryption policy applies to every cache unless overridden
+			ServerSideEncryption: get("S3_SSE", primary.S3.ServerSideEncryption),
+			SSEKMSKeyID:          get("S3_SSE_KMS_KEY_ID", primary.S3.SSEKMSKeyID),
+			RequesterPays:        env.bool(prefix+"S3_REQUESTER_PAYS", strconv.FormatBool(primary.S3.RequesterPays)),
+			StorageClass:         get("S3_STORAGE_CLASS", primary.S3.StorageClass),
+		}
+		cache.Expiration = ExpirationConfig{
+			TTL:      env.duration(prefix+"TTL", primary.Expiration.TTL.String()),
+			Interval: primary.Expiration.Interval,
+		}
+		cache.Eviction = EvictionConfig{
+			MaxSizeBytes: env.size(prefix+"MAX_SIZE_BYTES", strconv.FormatInt(primary.Eviction.MaxSizeBytes, 10)),
+			Interval:     primary.Eviction.Interval,
+			Policy:       strings.ToLower(get("EVICTION_POLICY", primary.Eviction.Policy)),
+			DiskReserve:  env.size(prefix+"DISK_RESERVE", strconv.FormatInt(primary.Eviction.DiskReserve, 10)),
+		}
+		cache.Pins = get("PINS", "")
+
+		caches = append(caches, cache)
+	}
+	return caches
+}
@@ -0,0 +1,201 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfig_Caches(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	t.Setenv("METRICS_PORT", "9100")
+	t.Setenv("STORAGE_TYPE", "s3")
+	t.Setenv("S3_BUCKET", "mirrors")
+	t.Setenv("S3_KEY_PREFIX", "prod/")
+	t.Setenv("CACHE_TTL", "720h")
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Empty(t, cfg.Caches)
+
+	t.Setenv("CACHES", "dev, scratch")
+	t.Setenv("CACHE_DEV_URI_PREFIX", "/dev/providers")
+	t.Setenv("CACHE_DEV_S3_KEY_PREFIX", "dev/")
+	t.Setenv("CACHE_DEV_TTL", "24h")
+	t.Setenv("CACHE_SCRATCH_URI_PREFIX", "/scratch")
+	t.Setenv("CACHE_SCRATCH_STORAGE_TYPE", "local")
+	t.Setenv("CACHE_SCRATCH_DIR", t.TempDir())
+	t.Setenv("CACHE_SCRATCH_MAX_SIZE_BYTES", "1073741824")
+	t.Setenv("CACHE_SCRATCH_PINS", "registry.terraform.io/hashicorp/aws")
+	t.Setenv("CACHE_SCRATCH_DEDUP", "true")
+	t.Setenv("CACHE_SCRATCH_DISK_RESERVE", "1GiB")
+
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	require.Len(t, cfg.Caches, 2)
+
+	// Settings other than the storage location default to the primary cache
+	dev := cfg.Caches[0]
+	assert.Equal(t, "dev", dev.Name)
+	assert.Equal(t, "/dev/providers", dev.URIPrefix)
+	assert.Equal(t, StorageTypeS3, dev.StorageType)
+	assert.Equal(t, S3Config{Bucket: "mirrors", Region: "eu-central-1", KeyPrefix: "dev/", RoleSessionName: "cachetf"}, dev.S3)
+	assert.Equal(t, 24*time.Hour, dev.Expiration.TTL)
+	assert.Equal(t, time.Hour, dev.Expiration.Interval)
+
+	scratch := cfg.Caches[1]
+	assert.Equal(t, StorageTypeLocal, scratch.StorageType)
+	assert.Equal(t, 720*time.Hour, scratch.Expiration.TTL)
+	assert.Equal(t, int64(1073741824), scratch.Eviction.MaxSizeBytes)
+	assert.Equal(t, "lru", scratch.Eviction.Policy)
+	assert.Equal(t, "registry.terraform.io/hashicorp/aws", scratch.Pins)
+	assert.True(t, scratch.StorageOptions().Dedup)
+	assert.Equal(t, int64(1<<30), scratch.StorageOptions().DiskReserve)
+	assert.Zero(t, dev.Eviction.DiskReserve)
+	assert.False(t, dev.Dedup)
+
+	t.Setenv("CACHE_DEV_TTL", "a day")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "invalid CACHE_DEV_TTL")
+}
+
+func TestConfig_ValidateCaches(t *testing.T) {
+	primary := func(caches ...CacheConfig) *Config {
+		return &Config{
+			ServerPort:  8080,
+			URIPrefix:   "/providers",
+			StorageType: StorageTypeS3,
+			S3:          S3Config{Bucket: "mirrors", Region: "eu-central-1", KeyPrefix: "prod"},
+			Modules:     ModulesConfig{Enabled: true, URIPrefix: "/modules", Upstream: "registry.terraform.io"},
+			Eviction:    EvictionConfig{Policy: "lru"},
+			Caches:      caches,
+		}
+	}
+	dev := func(modify func(*CacheConfig)) CacheConfig {
+		cache := CacheConfig{
+			Name:        "dev",
+			URIPrefix:   "/dev",
+			StorageType: StorageTypeS3,
+			S3:          S3Config{Bucket: "mirrors", Region: "eu-central-1", KeyPrefix: "dev"},
+		}
+		if modify != nil {
+			modify(&cache)
+		}
+		return cache
+	}
+
+	tests := []struct {
+		name    string
+		caches  []CacheConfig
+		wantErr string
+	}{
+		{
+			name:   "valid",
+			caches: []CacheConfig{dev(nil)},
+		},
+		{
+			name:   "other bucket",
+			caches: []CacheConfig{dev(func(c *CacheConfig) { c.S3 = S3Config{Bucket: "dev", Region: "us-east-1"} })},
+		},
+		{
+			name:   "same bucket name on another endpoint",
+			caches: []CacheConfig{dev(func(c *CacheConfig) { c.S3.KeyPrefix = "prod"; c.S3.Endpoint = "http://minio.internal:9000" })},
+		},
+		{
+			name:    "invalid name",
+			caches:  []CacheConfig{dev(func(c *CacheConfig) { c.Name = "dev-1" })},
+			wantErr: "invalid cache name",
+		},
+		{
+			name:    "duplicate name",
+			caches:  []CacheConfig{dev(nil), dev(func(c *CacheConfig) { c.URIPrefix = "/test"; c.S3.KeyPrefix = "test" })},
+			wantErr: "duplicate cache",
+		},
+		{
+			name:    "missing URI prefix",
+			caches:  []CacheConfig{dev(func(c *CacheConfig) { c.URIPrefix = "" })},
+			wantErr: "CACHE_DEV_URI_PREFIX is required",
+		},
+		{
+			name:    "route of the primary cache",
+			caches:  []CacheConfig{dev(func(c *CacheConfig) { c.URIPrefix = "/providers/dev" })},
+			wantErr: "CACHE_DEV_URI_PREFIX overlaps URI_PREFIX",
+		},
+		{
+			name:    "route of the module registry",
+			caches:  []CacheConfig{dev(func(c *CacheConfig) { c.URIPrefix = "/modules/" })},
+			wantErr: "overlaps MODULES_URI_PREFIX",
+		},
+		{
+			name:    "storage of the primary cache",
+			caches:  []CacheConfig{dev(func(c *CacheConfig) { c.S3.KeyPrefix = "prod/dev" })},
+			wantErr: "overlaps the storage of the primary cache",
+		},
+		{
+			name: "storage of another cache",
+			caches: []CacheConfig{
+				dev(func(c *CacheConfig) { c.StorageType = StorageTypeLocal; c.CacheDir = "/var/cache/dev" }),
+				dev(func(c *CacheConfig) {
+					c.Name = "test"
+					c.URIPrefix = "/test"
+					c.StorageType = StorageTypeLocal
+					c.CacheDir = "/var/cache/dev/../dev/test"
+				}),
+			},
+			wantErr: "the storage of cache test overlaps the storage of cache dev",
+		},
+		{
+			name:   "tiered",
+			caches: []CacheConfig{dev(func(c *CacheConfig) { c.StorageType = StorageTypeTiered; c.CacheDir = "/var/cache/dev" })},
+		},
+		{
+			name:    "tiered without directory",
+			caches:  []CacheConfig{dev(func(c *CacheConfig) { c.StorageType = StorageTypeTiered })},
+			wantErr: "CACHE_DEV_DIR is required when using tiered storage",
+		},
+		{
+			name: "tiered directory of another cache",
+			caches: []CacheConfig{
+				dev(func(c *CacheConfig) { c.StorageType = StorageTypeLocal; c.CacheDir = "/var/cache/dev" }),
+				dev(func(c *CacheConfig) {
+					c.Name = "test"
+					c.URIPrefix = "/test"
+					c.StorageType = StorageTypeTiered
+					c.CacheDir = "/var/cache/dev/test"
+					c.S3.KeyPrefix = "test"
+				}),
+			},
+			wantErr: "the storage of cache test overlaps the storage of cache dev",
+		},
+		{
+			name:    "missing directory",
+			caches:  []CacheConfig{dev(func(c *CacheConfig) { c.StorageType = StorageTypeLocal })},
+			wantErr: "CACHE_DEV_DIR is required",
+		},
+		{
+			name: "size limit on S3",
+			caches: []CacheConfig{dev(func(c *CacheConfig) {
+				c.Eviction = EvictionConfig{MaxSizeBytes: 1024, Interval: time.Minute, Policy: "lru"}
+			})},
+			wantErr: "only supported with local storage",
+		},
+		{
+			name:    "invalid pins",
+			caches:  []CacheConfig{dev(func(c *CacheConfig) { c.Pins = "hashicorp" })},
+			wantErr: "invalid CACHE_DEV_PINS",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := primary(tt.caches...).Validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -2,10 +2,27 @@ package config
 
 import (
 	"fmt"
+	"maps"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
+
+	"cachetf/internal/auth"
+	"cachetf/internal/bundle"
+	"cachetf/internal/cron"
+	"cachetf/internal/layout"
+	"cachetf/internal/middleware"
+	"cachetf/internal/pins"
+	"cachetf/internal/storage"
+	"cachetf/pkg/logger"
+	"cachetf/pkg/validate"
 )
 
 // StorageType defines the type of storage to use
@@ -14,51 +31,1026 @@ type StorageType string
 const (
 	StorageTypeLocal StorageType = "local"
 	StorageTypeS3    StorageType = "s3"
+	// StorageTypeTiered keeps a local copy of the files of an S3 bucket in the cache directory
+	StorageTypeTiered StorageType = "tiered"
+	// StorageTypeAzure stores the files in an Azure Blob Storage container
+	StorageTypeAzure StorageType = "azure"
+	// StorageTypeB2 stores the files in a Backblaze B2 bucket, with the native B2 API or the S3-compatible API of B2
+	StorageTypeB2 StorageType = "b2"
+	// StorageTypeOCI stores the files in an OCI Object Storage bucket
+	StorageTypeOCI StorageType = "oci"
+	// StorageTypeSFTP stores the files on an SFTP server
+	StorageTypeSFTP StorageType = "sftp"
+	// StorageTypeWebDAV stores the files on a WebDAV server, e.g. an Artifactory generic repository
+	StorageTypeWebDAV StorageType = "webdav"
 )
 
 // S3Config holds S3 storage configuration
 type S3Config struct {
 	Bucket string `env:"S3_BUCKET"`
 	Region string `env:"S3_REGION" envDefault:"eu-central-1"`
+	// KeyPrefix is prepended to the object keys, so the cache can share a bucket, e.g. cachetf/prod/
+	KeyPrefix string `env:"S3_KEY_PREFIX"`
+	// Endpoint targets an S3-compatible service such as MinIO or Ceph RGW, AWS if empty
+	Endpoint string `env:"S3_ENDPOINT"`
+	// UsePathStyle addresses buckets in the URL path instead of the host name
+	UsePathStyle bool `env:"S3_USE_PATH_STYLE" envDefault:"false"`
+	// DisableSSL connects to an endpoint given without scheme over plain HTTP
+	DisableSSL bool `env:"S3_DISABLE_SSL" envDefault:"false"`
+	// RoleARN is assumed with STS to access the bucket, e.g. a bucket in another account
+	RoleARN string `env:"S3_ROLE_ARN"`
+	// ExternalID is passed to STS when assuming the role
+	ExternalID string `env:"S3_ROLE_EXTERNAL_ID"`
+	// RoleSessionName identifies the sessions of the assumed role in CloudTrail
+	RoleSessionName string `env:"S3_ROLE_SESSION_NAME" envDefault:"cachetf"`
+	// ServerSideEncryption is requested for uploads, AES256 or aws:kms, the bucket default if empty
+	ServerSideEncryption string `env:"S3_SSE"`
+	// SSEKMSKeyID is the KMS key ID or ARN of aws:kms encryption, the AWS managed key if empty
+	SSEKMSKeyID string `env:"S3_SSE_KMS_KEY_ID"`
+	// RequesterPays acknowledges the request charges of a requester pays bucket
+	RequesterPays bool `env:"S3_REQUESTER_PAYS" envDefault:"false"`
+	// StorageClass of uploaded objects, e.g. INTELLIGENT_TIERING, STANDARD if empty
+	StorageClass string `env:"S3_STORAGE_CLASS"`
+	// WriteBehindDir stages the writes on the local disk and uploads them in the background, writes are
+	// synchronous if empty
+	WriteBehindDir string `env:"S3_WRITE_BEHIND_DIR"`
+	// WriteBehindMaxPending bounds the staged files not uploaded yet, writes are synchronous while it's reached
+	WriteBehindMaxPending int `env:"S3_WRITE_BEHIND_MAX_PENDING" envDefault:"1000"`
+}
+
+// maxPresignedRedirectTTL is the longest validity of SigV4 presigned URLs
+const maxPresignedRedirectTTL = 7 * 24 * time.Hour
+
+var (
+	// roleARNPattern matches IAM role ARNs of every AWS partition
+	roleARNPattern = regexp.MustCompile(`^arn:aws[a-z-]*:iam::\d{12}:role/[\w+=,.@/-]+$`)
+	// roleSessionNamePattern matches the session names accepted by STS
+	roleSessionNamePattern = regexp.MustCompile(`^[\w+=,.@-]{2,64}$`)
+)
+
+// StorageConfig returns the configuration of the S3 storage backend
+func (c *S3Config) StorageConfig() *storage.S3Config {
+	cfg := &storage.S3Config{
+		Bucket:          c.Bucket,
+		Region:          c.Region,
+		KeyPrefix:       c.KeyPrefix,
+		Endpoint:        c.Endpoint,
+		UsePathStyle:    c.UsePathStyle,
+		DisableSSL:      c.DisableSSL,
+		RoleARN:         c.RoleARN,
+		ExternalID:      c.ExternalID,
+		RoleSessionName: c.RoleSessionName,
+
+		ServerSideEncryption: c.ServerSideEncryption,
+		SSEKMSKeyID:          c.SSEKMSKeyID,
+		RequesterPays:        c.RequesterPays,
+		StorageClass:         c.StorageClass,
+	}
+	if c.WriteBehindDir != "" {
+		cfg.WriteBehind = &storage.WriteBehindConfig{
+			Dir:        c.WriteBehindDir,
+			MaxPending: c.WriteBehindMaxPending,
+		}
+	}
+	return cfg
 }
 
 // Validate checks if the S3 configuration is valid
 func (c *S3Config) Validate() error {
+	var errs Errors
 	if c.Bucket == "" {
-		return fmt.Errorf("S3_BUCKET is required when using S3 storage")
+		errs.add(fmt.Errorf("S3_BUCKET is required when using S3 storage"))
 	}
 	if c.Region == "" {
-		return fmt.Errorf("S3_REGION is required when using S3 storage")
+		errs.add(fmt.Errorf("S3_REGION is required when using S3 storage"))
+	}
+	if prefix := strings.Trim(c.KeyPrefix, "/"); prefix != "" {
+		for _, segment := range strings.Split(prefix, "/") {
+			if segment == "" || segment == "." || segment == ".." {
+				errs.add(fmt.Errorf("invalid S3_KEY_PREFIX: empty, . and .. segments aren't allowed"))
+				break
+			}
+		}
+	}
+	if c.Endpoint != "" {
+		if _, err := storage.EndpointURL(c.Endpoint, c.DisableSSL); err != nil {
+			errs.add(fmt.Errorf("invalid S3_ENDPOINT: %w", err))
+		}
+	} else if c.DisableSSL {
+		errs.add(fmt.Errorf("S3_DISABLE_SSL requires S3_ENDPOINT, AWS endpoints always use SSL"))
+	}
+	if c.RoleARN != "" {
+		if !roleARNPattern.MatchString(c.RoleARN) {
+			errs.add(fmt.Errorf("invalid S3_ROLE_ARN: must be an IAM role ARN such as arn:aws:iam::123456789012:role/cachetf"))
+		}
+		if !roleSessionNamePattern.MatchString(c.RoleSessionName) {
+			errs.add(fmt.Errorf("invalid S3_ROLE_SESSION_NAME: must be 2 to 64 letters, digits and +=,.@_- characters"))
+		}
+	} else if c.ExternalID != "" {
+		errs.add(fmt.Errorf("S3_ROLE_EXTERNAL_ID requires S3_ROLE_ARN"))
+	}
+	if err := storage.ValidateServerSideEncryption(c.ServerSideEncryption, c.SSEKMSKeyID); err != nil {
+		errs.add(fmt.Errorf("invalid S3_SSE or S3_SSE_KMS_KEY_ID: %w", err))
+	}
+	if err := storage.ValidateStorageClass(c.StorageClass); err != nil {
+		errs.add(fmt.Errorf("invalid S3_STORAGE_CLASS: %w", err))
+	}
+	if c.WriteBehindDir != "" && c.WriteBehindMaxPending <= 0 {
+		errs.add(fmt.Errorf("S3_WRITE_BEHIND_MAX_PENDING must be positive"))
+	}
+	return errs.err()
+}
+
+// AzureConfig holds Azure Blob Storage configuration. The storage account is accessed with a connection string,
+// or with an account name and a SAS token.
+type AzureConfig struct {
+	// ConnectionString of the storage account, with an AccountKey or a SharedAccessSignature
+	ConnectionString string `env:"AZURE_STORAGE_CONNECTION_STRING"`
+	// AccountName is the storage account accessed with SASToken
+	AccountName string `env:"AZURE_STORAGE_ACCOUNT"`
+	// SASToken grants read, write, delete and list access to the container
+	SASToken  string `env:"AZURE_STORAGE_SAS_TOKEN"`
+	Container string `env:"AZURE_STORAGE_CONTAINER"`
+	// KeyPrefix is prepended to the blob names, so the cache can share a container, e.g. cachetf/prod/
+	KeyPrefix string `env:"AZURE_STORAGE_KEY_PREFIX"`
+	// Endpoint is the URL of the blob service accessed with SASToken, e.g. of a private endpoint
+	Endpoint string `env:"AZURE_STORAGE_ENDPOINT"`
+}
+
+// StorageConfig returns the configuration of the Azure storage backend
+func (c *AzureConfig) StorageConfig() *storage.AzureConfig {
+	return &storage.AzureConfig{
+		ConnectionString: c.ConnectionString,
+		AccountName:      c.AccountName,
+		SASToken:         c.SASToken,
+		Container:        c.Container,
+		KeyPrefix:        c.KeyPrefix,
+		Endpoint:         c.Endpoint,
+	}
+}
+
+// Validate checks if the Azure configuration is valid
+func (c *AzureConfig) Validate() error {
+	if err := storage.ValidateAzureConfig(c.StorageConfig()); err != nil {
+		return fmt.Errorf("invalid AZURE_STORAGE_* configuration: %w", err)
+	}
+	return nil
+}
+
+// B2Config holds Backblaze B2 configuration, the bucket is accessed with an application key
+type B2Config struct {
+	// API is native for the native B2 API, or s3 for the S3-compatible API of B2
+	API            string `env:"B2_API"`
+	KeyID          string `env:"B2_KEY_ID"`
+	ApplicationKey string `env:"B2_APPLICATION_KEY"`
+	Bucket         string `env:"B2_BUCKET"`
+	// Region of the bucket for the S3-compatible API, shown in its S3 endpoint, e.g. us-west-004
+	Region string `env:"B2_REGION"`
+	// KeyPrefix is prepended to the file names, so the cache can share a bucket, e.g. cachetf/prod/
+	KeyPrefix string `env:"B2_KEY_PREFIX"`
+	// Endpoint is the URL of the S3-compatible API, derived from the region if empty
+	Endpoint string `env:"B2_ENDPOINT"`
+}
+
+// StorageConfig returns the configuration of the B2 storage backend
+func (c *B2Config) StorageConfig() *storage.B2Config {
+	return &storage.B2Config{
+		API:            c.API,
+		KeyID:          c.KeyID,
+		ApplicationKey: c.ApplicationKey,
+		Bucket:         c.Bucket,
+		Region:         c.Region,
+		KeyPrefix:      c.KeyPrefix,
+		Endpoint:       c.Endpoint,
+	}
+}
+
+// Validate checks if the B2 configuration is valid
+func (c *B2Config) Validate() error {
+	if err := storage.ValidateB2Config(c.StorageConfig()); err != nil {
+		return fmt.Errorf("invalid B2_* configuration: %w", err)
 	}
 	return nil
 }
 
+// OCIConfig holds OCI Object Storage configuration. The bucket is accessed with the API key of a user, or as
+// the compute instance with instance principals.
+type OCIConfig struct {
+	// Auth is api_key or instance_principal
+	Auth   string `env:"OCI_AUTH" envDefault:"api_key"`
+	Region string `env:"OCI_REGION"`
+	// Namespace of the tenancy, looked up if empty
+	Namespace string `env:"OCI_NAMESPACE"`
+	Bucket    string `env:"OCI_BUCKET"`
+	// KeyPrefix is prepended to the object names, so the cache can share a bucket, e.g. cachetf/prod/
+	KeyPrefix      string `env:"OCI_KEY_PREFIX"`
+	TenancyOCID    string `env:"OCI_TENANCY_OCID"`
+	UserOCID       string `env:"OCI_USER_OCID"`
+	Fingerprint    string `env:"OCI_FINGERPRINT"`
+	PrivateKeyFile string `env:"OCI_PRIVATE_KEY_FILE"`
+	// Endpoint is the URL of the Object Storage API, e.g. of a dedicated region
+	Endpoint string `env:"OCI_ENDPOINT"`
+}
+
+// StorageConfig returns the configuration of the OCI storage backend
+func (c *OCIConfig) StorageConfig() *storage.OCIConfig {
+	return &storage.OCIConfig{
+		Auth:           c.Auth,
+		Region:         c.Region,
+		Namespace:      c.Namespace,
+		Bucket:         c.Bucket,
+		KeyPrefix:      c.KeyPrefix,
+		TenancyOCID:    c.TenancyOCID,
+		UserOCID:       c.UserOCID,
+		Fingerprint:    c.Fingerprint,
+		PrivateKeyFile: c.PrivateKeyFile,
+		Endpoint:       c.Endpoint,
+	}
+}
+
+// Validate checks if the OCI configuration is valid
+func (c *OCIConfig) Validate() error {
+	if err := storage.ValidateOCIConfig(c.StorageConfig()); err != nil {
+		return fmt.Errorf("invalid OCI_* configuration: %w", err)
+	}
+	return nil
+}
+
+// SFTPConfig holds SFTP storage configuration. The server is accessed with the private key of a user and
+// verified with its known host keys.
+type SFTPConfig struct {
+	// Host is host or host:port, port 22 if not set
+	Host                 string `env:"SFTP_HOST"`
+	User                 string `env:"SFTP_USER"`
+	PrivateKeyFile       string `env:"SFTP_PRIVATE_KEY_FILE"`
+	PrivateKeyPassphrase string `env:"SFTP_PRIVATE_KEY_PASSPHRASE"`
+	KnownHostsFile       string `env:"SFTP_KNOWN_HOSTS_FILE"`
+	// BasePath is the directory the files are stored in, relative to the home directory unless absolute
+	BasePath string `env:"SFTP_BASE_PATH"`
+}
+
+// StorageConfig returns the configuration of the SFTP storage backend
+func (c *SFTPConfig) StorageConfig() *storage.SFTPConfig {
+	return &storage.SFTPConfig{
+		Host:                 c.Host,
+		User:                 c.User,
+		PrivateKeyFile:       c.PrivateKeyFile,
+		PrivateKeyPassphrase: c.PrivateKeyPassphrase,
+		KnownHostsFile:       c.KnownHostsFile,
+		BasePath:             c.BasePath,
+	}
+}
+
+// Validate checks if the SFTP configuration is valid
+func (c *SFTPConfig) Validate() error {
+	if err := storage.ValidateSFTPConfig(c.StorageConfig()); err != nil {
+		return fmt.Errorf("invalid SFTP_* configuration: %w", err)
+	}
+	return nil
+}
+
+// WebDAVConfig holds WebDAV storage configuration. The server is accessed with a username and password, or a
+// bearer token.
+type WebDAVConfig struct {
+	// URL is the collection the files are stored in
+	URL      string `env:"WEBDAV_URL"`
+	Username string `env:"WEBDAV_USERNAME"`
+	Password string `env:"WEBDAV_PASSWORD"`
+	Token    string `env:"WEBDAV_TOKEN"`
+}
+
+// StorageConfig returns the configuration of the WebDAV storage backend
+func (c *WebDAVConfig) StorageConfig() *storage.WebDAVConfig {
+	return &storage.WebDAVConfig{
+		URL:      c.URL,
+		Username: c.Username,
+		Password: c.Password,
+		Token:    c.Token,
+	}
+}
+
+// Validate checks if the WebDAV configuration is valid
+func (c *WebDAVConfig) Validate() error {
+	if err := storage.ValidateWebDAVConfig(c.StorageConfig()); err != nil {
+		return fmt.Errorf("invalid WEBDAV_* configuration: %w", err)
+	}
+	return nil
+}
+
+// StorageOptions returns the options the backend of STORAGE_TYPE is created with, the configuration of the
+// built-in backends. Backends registered outside of the storage package get none.
+func (c *Config) StorageOptions() storage.Options {
+	opts := storage.Options{CacheDir: c.CacheDir, Dedup: c.CacheDedup, DiskReserve: c.Eviction.DiskReserve}
+	switch c.StorageType {
+	case StorageTypeS3, StorageTypeTiered:
+		opts.Config = c.S3.StorageConfig()
+	case StorageTypeAzure:
+		opts.Config = c.Azure.StorageConfig()
+	case StorageTypeB2:
+		opts.Config = c.B2.StorageConfig()
+	case StorageTypeOCI:
+		opts.Config = c.OCI.StorageConfig()
+	case StorageTypeSFTP:
+		opts.Config = c.SFTP.StorageConfig()
+	case StorageTypeWebDAV:
+		opts.Config = c.WebDAV.StorageConfig()
+	}
+	return opts
+}
+
+// DiscoveryConfig holds the service discovery (/.well-known/terraform.json) configuration
+type DiscoveryConfig struct {
+	Enabled     bool   `env:"DISCOVERY_ENABLED" envDefault:"true"`
+	ProvidersV1 string `env:"DISCOVERY_PROVIDERS_V1"`
+	ModulesV1   string `env:"DISCOVERY_MODULES_V1"`
+}
+
+// ModulesConfig holds the module registry cache configuration
+type ModulesConfig struct {
+	Enabled   bool   `env:"MODULES_ENABLED" envDefault:"true"`
+	URIPrefix string `env:"MODULES_URI_PREFIX" envDefault:"/modules"`
+	Upstream  string `env:"MODULES_UPSTREAM" envDefault:"registry.terraform.io"`
+}
+
+// VerificationConfig holds the GPG signature verification configuration
+type VerificationConfig struct {
+	// Enabled verifies the upstream SHA256SUMS signature before caching provider binaries
+	Enabled bool `env:"GPG_VERIFY" envDefault:"false"`
+	// Required refuses to cache provider binaries whose signature can't be verified
+	Required bool `env:"GPG_VERIFY_REQUIRED" envDefault:"false"`
+	// KeyringFile is an ASCII-armored keyring trusted in addition to the upstream signing keys
+	KeyringFile string `env:"GPG_KEYRING_FILE"`
+	// OnServe recomputes the checksum of cached provider binaries while they are served
+	OnServe bool `env:"VERIFY_ON_SERVE" envDefault:"false"`
+}
+
+// ExpirationConfig holds the cache expiration settings
+type ExpirationConfig struct {
+	// TTL is the age after which cached provider binaries are evicted, expiration is disabled if zero
+	TTL time.Duration `env:"CACHE_TTL"`
+	// Interval is the time between expiration sweeps
+	Interval time.Duration `env:"CACHE_EXPIRATION_INTERVAL" envDefault:"1h"`
+}
+
+// EvictionConfig holds the size-bounded cache eviction settings
+type EvictionConfig struct {
+	// MaxSizeBytes is the size above which the least recently used files are evicted, eviction is disabled if zero
+	MaxSizeBytes int64 `env:"CACHE_MAX_SIZE_BYTES"`
+	// Interval is the time between cache size checks
+	Interval time.Duration `env:"CACHE_EVICTION_INTERVAL" envDefault:"1m"`
+	// Policy selects the files to evict: lru, lfu, fifo or ttl
+	Policy string `env:"CACHE_EVICTION_POLICY" envDefault:"lru"`
+	// SizeScanInterval is the time between measurements of the cache size without a size limit, the size is only
+	// measured on startup if zero
+	SizeScanInterval time.Duration `env:"CACHE_SIZE_SCAN_INTERVAL" envDefault:"1h"`
+	// DiskReserve is the free disk space local storage keeps, evicting files or not caching them if a write would
+	// leave less, disabled if zero
+	DiskReserve int64 `env:"CACHE_DISK_RESERVE"`
+}
+
+// PrewarmConfig holds the settings of the prewarm list
+type PrewarmConfig struct {
+	// File is a JSON list of providers cached on startup and kept refreshed, disabled if empty
+	File string `env:"PREWARM_FILE"`
+	// Interval is the time between refreshes of the prewarm list
+	Interval time.Duration `env:"PREWARM_INTERVAL" envDefault:"6h"`
+}
+
+// MirrorConfig holds the settings of the scheduled mirror refresh
+type MirrorConfig struct {
+	// RefreshCron is the cron expression of the refreshes caching new releases of pinned providers, disabled if empty
+	RefreshCron string `env:"MIRROR_REFRESH_CRON"`
+	// Platforms lists the platforms to mirror in the os_arch format, every published platform if empty
+	Platforms []string `env:"MIRROR_REFRESH_PLATFORMS"`
+}
+
+// SyncConfig holds the settings of the pull replication from another instance
+type SyncConfig struct {
+	// Source is the base URL of the instance artifacts are pulled from, disabled if empty
+	Source string `env:"SYNC_SOURCE"`
+	// Token is the API key sent to the source, it needs the admin scope
+	Token string `env:"SYNC_TOKEN"`
+	// Interval is the time between syncs
+	Interval time.Duration `env:"SYNC_INTERVAL" envDefault:"15m"`
+	// Prefixes restrict the pulled artifacts to these key prefixes, every artifact if empty
+	Prefixes []string `env:"SYNC_PREFIXES"`
+}
+
+// Enabled returns true if a source is configured
+func (c *SyncConfig) Enabled() bool {
+	return c.Source != ""
+}
+
+// Validate checks if the sync configuration is valid
+func (c *SyncConfig) Validate() error {
+	var errs Errors
+	u, err := url.Parse(c.Source)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs.add(fmt.Errorf("invalid SYNC_SOURCE: must be an http or https URL"))
+	}
+	if c.Interval <= 0 {
+		errs.add(fmt.Errorf("SYNC_INTERVAL must be positive"))
+	}
+	for _, prefix := range c.Prefixes {
+		if err := bundle.ValidatePrefix(prefix); err != nil {
+			errs.add(fmt.Errorf("invalid SYNC_PREFIXES: %w", err))
+		}
+	}
+	return errs.err()
+}
+
+// MemoryCacheConfig holds the settings of the in-memory cache of small files
+type MemoryCacheConfig struct {
+	// MaxBytes is the memory used for cached files, the memory cache is disabled if zero
+	MaxBytes int64 `env:"MEMORY_CACHE_MAX_BYTES" envDefault:"0"`
+	// MaxObjectBytes is the size of the largest file kept in memory
+	MaxObjectBytes int64 `env:"MEMORY_CACHE_MAX_OBJECT_BYTES" envDefault:"1MiB"`
+	// TTL is how long a file is served from memory before it's read from the storage again
+	TTL time.Duration `env:"MEMORY_CACHE_TTL" envDefault:"1m"`
+	// PreloadIndexes is the number of the most requested provider indexes read into memory on startup, the
+	// requests aren't counted if zero
+	PreloadIndexes int `env:"MEMORY_CACHE_PRELOAD_INDEXES" envDefault:"0"`
+	// PreloadSaveInterval is how often the counted index requests are persisted
+	PreloadSaveInterval time.Duration `env:"MEMORY_CACHE_PRELOAD_SAVE_INTERVAL" envDefault:"5m"`
+}
+
+// Enabled returns true if the memory cache has a size
+func (c *MemoryCacheConfig) Enabled() bool {
+	return c.MaxBytes > 0
+}
+
+// Validate checks if the memory cache configuration is valid
+func (c *MemoryCacheConfig) Validate() error {
+	var errs Errors
+	if c.MaxBytes < 0 {
+		errs.add(fmt.Errorf("MEMORY_CACHE_MAX_BYTES must not be negative"))
+	}
+	if c.MaxBytes > 0 && (c.MaxObjectBytes <= 0 || c.MaxObjectBytes > c.MaxBytes) {
+		errs.add(fmt.Errorf("MEMORY_CACHE_MAX_OBJECT_BYTES must be positive and not exceed MEMORY_CACHE_MAX_BYTES"))
+	}
+	if c.TTL < 0 {
+		errs.add(fmt.Errorf("MEMORY_CACHE_TTL must not be negative"))
+	}
+	if c.PreloadIndexes < 0 {
+		errs.add(fmt.Errorf("MEMORY_CACHE_PRELOAD_INDEXES must not be negative"))
+	}
+	if c.PreloadIndexes > 0 && c.MaxBytes == 0 {
+		errs.add(fmt.Errorf("MEMORY_CACHE_PRELOAD_INDEXES requires MEMORY_CACHE_MAX_BYTES"))
+	}
+	if c.PreloadIndexes > 0 && c.PreloadSaveInterval <= 0 {
+		errs.add(fmt.Errorf("MEMORY_CACHE_PRELOAD_SAVE_INTERVAL must be positive"))
+	}
+	return errs.err()
+}
+
+// HotCacheConfig holds the settings of the local disk cache of large files, see storage.HotCache
+type HotCacheConfig struct {
+	// Dir is the directory the large files are copied to, the hot cache is disabled if empty
+	Dir string `env:"HOT_CACHE_DIR"`
+	// MaxBytes is the disk space used for the copies
+	MaxBytes int64 `env:"HOT_CACHE_MAX_BYTES" envDefault:"1GiB"`
+	// MinObjectBytes is the size of the smallest file copied
+	MinObjectBytes int64 `env:"HOT_CACHE_MIN_OBJECT_BYTES" envDefault:"10MiB"`
+	// TTL is how long a file is served from the copy before it's read from the storage again
+	TTL time.Duration `env:"HOT_CACHE_TTL" envDefault:"1h"`
+}
+
+// Enabled returns true if the hot cache has a directory
+func (c *HotCacheConfig) Enabled() bool {
+	return c.Dir != ""
+}
+
+// Validate checks if the hot cache configuration is valid
+func (c *HotCacheConfig) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	var errs Errors
+	if c.MaxBytes <= 0 {
+		errs.add(fmt.Errorf("HOT_CACHE_MAX_BYTES must be positive"))
+	}
+	if c.MinObjectBytes <= 0 || c.MinObjectBytes > c.MaxBytes {
+		errs.add(fmt.Errorf("HOT_CACHE_MIN_OBJECT_BYTES must be positive and not exceed HOT_CACHE_MAX_BYTES"))
+	}
+	if c.TTL < 0 {
+		errs.add(fmt.Errorf("HOT_CACHE_TTL must not be negative"))
+	}
+	return errs.err()
+}
+
+// Scrub actions
+const (
+	// ScrubQuarantine moves corrupted files to the quarantine
+	ScrubQuarantine = "quarantine"
+	// ScrubDelete deletes corrupted files
+	ScrubDelete = "delete"
+)
+
+// ScrubConfig holds the settings of the background integrity checks of cached provider binaries
+type ScrubConfig struct {
+	// Interval is the time between two scrubs of the cache, scrubbing is disabled if zero
+	Interval time.Duration `env:"SCRUB_INTERVAL" envDefault:"0"`
+	// Action is what happens to corrupted files: quarantine or delete
+	Action string `env:"SCRUB_ACTION" envDefault:"quarantine"`
+}
+
+// Validate checks if the scrub configuration is valid
+func (c *ScrubConfig) Validate() error {
+	if c.Interval < 0 {
+		return fmt.Errorf("SCRUB_INTERVAL must not be negative")
+	}
+	if c.Interval == 0 {
+		return nil
+	}
+	var errs Errors
+	if c.Action != ScrubQuarantine && c.Action != ScrubDelete {
+		errs.add(fmt.Errorf("invalid SCRUB_ACTION %q: must be %s or %s", c.Action, ScrubQuarantine, ScrubDelete))
+	}
+	return errs.err()
+}
+
+// CatalogConfig holds the settings of the embedded catalog of the cached files, see catalog.Catalog
+type CatalogConfig struct {
+	// Path is the file the catalog is persisted to, the catalog is disabled if empty
+	Path string `env:"CATALOG_PATH"`
+	// SaveInterval is the time between two saves of the access times
+	SaveInterval time.Duration `env:"CATALOG_SAVE_INTERVAL" envDefault:"1m"`
+	// RebuildInterval is the time between two reconciliations with the storage, never if zero
+	RebuildInterval time.Duration `env:"CATALOG_REBUILD_INTERVAL" envDefault:"24h"`
+}
+
+// Enabled returns true if the catalog has a path
+func (c *CatalogConfig) Enabled() bool {
+	return c.Path != ""
+}
+
+// Validate checks if the catalog configuration is valid
+func (c *CatalogConfig) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	var errs Errors
+	if c.SaveInterval <= 0 {
+		errs.add(fmt.Errorf("CATALOG_SAVE_INTERVAL must be positive"))
+	}
+	if c.RebuildInterval < 0 {
+		errs.add(fmt.Errorf("CATALOG_REBUILD_INTERVAL must not be negative"))
+	}
+	return errs.err()
+}
+
+// validateCatalog checks that the catalog file isn't stored in the directory of a cache, where it would be listed
+// as a cached file
+func (c *Config) validateCatalog() error {
+	if !c.Catalog.Enabled() {
+		return nil
+	}
+	catalog := storageLocations(StorageTypeLocal, filepath.Dir(c.Catalog.Path), S3Config{})[0]
+	locations := storageLocations(c.StorageType, c.CacheDir, c.S3)
+	for _, cache := range c.Caches {
+		locations = append(locations, storageLocations(cache.StorageType, cache.CacheDir, cache.S3)...)
+	}
+	for _, location := range locations {
+		if strings.HasPrefix(catalog, location) {
+			return fmt.Errorf("CATALOG_PATH must not be in the directory of a cache")
+		}
+	}
+	return nil
+}
+
+// validateHotCache checks that the hot cache directory, which is emptied on startup, holds no cache
+func (c *Config) validateHotCache() error {
+	if !c.HotCache.Enabled() {
+		return nil
+	}
+	hot := storageLocations(StorageTypeLocal, c.HotCache.Dir, S3Config{})[0]
+	locations := storageLocations(c.StorageType, c.CacheDir, c.S3)
+	for _, cache := range c.Caches {
+		locations = append(locations, storageLocations(cache.StorageType, cache.CacheDir, cache.S3)...)
+	}
+	for _, location := range locations {
+		if overlaps(hot, location) {
+			return fmt.Errorf("HOT_CACHE_DIR must not overlap the directory of a cache")
+		}
+	}
+	return nil
+}
+
+// TelemetryConfig holds the settings of the anonymous usage statistics, which are off unless enabled
+type TelemetryConfig struct {
+	// Enabled opts in to sending usage statistics
+	Enabled bool `env:"TELEMETRY_ENABLED" envDefault:"false"`
+	// Endpoint is the URL the statistics are POSTed to
+	Endpoint string `env:"TELEMETRY_ENDPOINT"`
+	// Interval is the time between reports
+	Interval time.Duration `env:"TELEMETRY_INTERVAL" envDefault:"24h"`
+}
+
+// Validate checks if the telemetry configuration is valid
+func (c *TelemetryConfig) Validate() error {
+	var errs Errors
+	u, err := url.Parse(c.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs.add(fmt.Errorf("TELEMETRY_ENDPOINT must be an http or https URL when TELEMETRY_ENABLED is set"))
+	}
+	if c.Interval <= 0 {
+		errs.add(fmt.Errorf("TELEMETRY_INTERVAL must be positive"))
+	}
+	return errs.err()
+}
+
+// HTTPConfig holds the settings of the HTTP server and its Gin engine
+type HTTPConfig struct {
+	// GinMode is the mode of the Gin engine: release (if empty), debug or test. Debug mode prints banners and
+	// warnings.
+	GinMode string `env:"GIN_MODE" envDefault:"release"`
+	// DebugRoutes logs the registered routes on startup
+	DebugRoutes bool `env:"GIN_DEBUG_ROUTES" envDefault:"false"`
+	// MaxMultipartMemory is the part of multipart forms kept in memory, the rest is stored in temporary files.
+	// The Gin default applies if 0.
+	MaxMultipartMemory int64 `env:"HTTP_MAX_MULTIPART_MEMORY" envDefault:"32MiB"`
+	// ReadHeaderTimeout is the time allowed to read the request headers
+	ReadHeaderTimeout time.Duration `env:"HTTP_READ_HEADER_TIMEOUT" envDefault:"10s"`
+	// ReadTimeout is the time allowed to read a whole request, 0 disables it
+	ReadTimeout time.Duration `env:"HTTP_READ_TIMEOUT" envDefault:"0"`
+	// WriteTimeout is the time allowed to handle a request and write the response, 0 disables it. Large
+	// provider downloads from slow upstreams must fit in it.
+	WriteTimeout time.Duration `env:"HTTP_WRITE_TIMEOUT" envDefault:"0"`
+	// IdleTimeout is how long idle keep-alive connections are kept open
+	IdleTimeout time.Duration `env:"HTTP_IDLE_TIMEOUT" envDefault:"2m"`
+	// Middlewares lists the middlewares of the route groups, as group=name,name;group=name. The * group
+	// applies to every request.
+	Middlewares string `env:"ROUTE_MIDDLEWARES" envDefault:"*=client,log"`
+	// RateLimitRPS is the request rate allowed per client IP by the ratelimit middleware
+	RateLimitRPS float64 `env:"RATE_LIMIT_RPS" envDefault:"0"`
+	// RateLimitBurst is the number of requests a client IP may send at once over the rate limit
+	RateLimitBurst int `env:"RATE_LIMIT_BURST" envDefault:"20"`
+	// ResponseBandwidth caps the bytes per second each response body is written at, 0 doesn't limit them
+	ResponseBandwidth int64 `env:"RESPONSE_BANDWIDTH_LIMIT" envDefault:"0"`
+}
+
+// Validate checks if the HTTP server configuration is valid
+func (c *HTTPConfig) Validate() error {
+	var errs Errors
+	switch c.GinMode {
+	case "", "release", "debug", "test":
+	default:
+		errs.add(fmt.Errorf("invalid GIN_MODE: must be 'release', 'debug' or 'test'"))
+	}
+	if c.MaxMultipartMemory < 0 {
+		errs.add(fmt.Errorf("HTTP_MAX_MULTIPART_MEMORY must not be negative"))
+	}
+	if c.ReadHeaderTimeout < 0 || c.ReadTimeout < 0 || c.WriteTimeout < 0 || c.IdleTimeout < 0 {
+		errs.add(fmt.Errorf("HTTP_READ_HEADER_TIMEOUT, HTTP_READ_TIMEOUT, HTTP_WRITE_TIMEOUT and HTTP_IDLE_TIMEOUT must not be negative"))
+	}
+	hooks, err := middleware.ParseHooks(c.Middlewares)
+	if err != nil {
+		errs.add(fmt.Errorf("invalid ROUTE_MIDDLEWARES: %w", err))
+	}
+	if c.RateLimitRPS < 0 || c.RateLimitBurst < 0 {
+		errs.add(fmt.Errorf("RATE_LIMIT_RPS and RATE_LIMIT_BURST must not be negative"))
+	}
+	if c.ResponseBandwidth < 0 {
+		errs.add(fmt.Errorf("RESPONSE_BANDWIDTH_LIMIT must not be negative"))
+	}
+	for _, names := range hooks {
+		if slices.Contains(names, middleware.NameRateLimit) && c.RateLimitRPS == 0 {
+			errs.add(fmt.Errorf("the %s middleware of ROUTE_MIDDLEWARES requires RATE_LIMIT_RPS", middleware.NameRateLimit))
+			break
+		}
+	}
+	return errs.err()
+}
+
+// UpstreamConfig holds the settings for requests to upstream registries
+type UpstreamConfig struct {
+	// InsecureSkipVerify lists the hosts whose TLS certificates aren't verified
+	InsecureSkipVerify []string `env:"UPSTREAM_INSECURE_SKIP_VERIFY"`
+	// AllowedHosts lists the hosts outbound requests may be sent to, all hosts if empty
+	AllowedHosts []string `env:"UPSTREAM_ALLOWED_HOSTS"`
+	// AllowPrivateNetworks allows outbound connections to private, loopback and link-local addresses
+	AllowPrivateNetworks bool `env:"UPSTREAM_ALLOW_PRIVATE_NETWORKS" envDefault:"false"`
+	// MaxConcurrentFetches is the number of upstream requests allowed in flight at once, 0 doesn't limit them
+	MaxConcurrentFetches int `env:"UPSTREAM_MAX_CONCURRENT_FETCHES" envDefault:"0"`
+	// Registries configure the base URL and token of registry hosts, listed by name in UPSTREAM_REGISTRIES
+	Registries []RegistryConfig `env:"UPSTREAM_REGISTRIES"`
+}
+
+// AuthConfig holds the authentication configuration
+type AuthConfig struct {
+	// APIKeys lists the API keys in the format name:key:scope|scope,name:key:scope
+	APIKeys string `env:"AUTH_API_KEYS"`
+	// AnonymousScopes lists the scopes granted to callers without credentials
+	AnonymousScopes string `env:"AUTH_ANONYMOUS_SCOPES"`
+	// TokenSecret signs issued download tokens. Token issuance is disabled when empty.
+	TokenSecret string `env:"AUTH_TOKEN_SECRET"`
+	// TokenTTL is the lifetime of issued tokens that don't ask for one
+	TokenTTL time.Duration `env:"AUTH_TOKEN_TTL" envDefault:"15m"`
+	// TokenMaxTTL is the longest lifetime a token can be issued for
+	TokenMaxTTL time.Duration `env:"AUTH_TOKEN_MAX_TTL" envDefault:"1h"`
+	// KeysReloadInterval is the time between reloads of the managed keys, which picks up the keys created and
+	// revoked by other instances, never if zero
+	KeysReloadInterval time.Duration `env:"AUTH_KEYS_RELOAD_INTERVAL" envDefault:"30s"`
+}
+
+// Enabled returns true if any API keys are configured
+func (c *AuthConfig) Enabled() bool {
+	return c.APIKeys != ""
+}
+
+// Validate checks if the auth configuration is valid
+func (c *AuthConfig) Validate() error {
+	var errs Errors
+	if _, err := auth.ParseAPIKeys(c.APIKeys); err != nil {
+		errs.add(fmt.Errorf("invalid AUTH_API_KEYS: %w", err))
+	}
+	if _, err := auth.ParseScopes(strings.Split(c.AnonymousScopes, ",")); err != nil {
+		errs.add(fmt.Errorf("invalid AUTH_ANONYMOUS_SCOPES: %w", err))
+	}
+	if c.KeysReloadInterval < 0 {
+		errs.add(fmt.Errorf("AUTH_KEYS_RELOAD_INTERVAL must not be negative"))
+	}
+	if c.TokenSecret != "" {
+		if !c.Enabled() {
+			errs.add(fmt.Errorf("AUTH_TOKEN_SECRET requires AUTH_API_KEYS"))
+		}
+		if len(c.TokenSecret) < 32 {
+			errs.add(fmt.Errorf("AUTH_TOKEN_SECRET must be at least 32 characters"))
+		}
+		if c.TokenTTL <= 0 || c.TokenTTL > c.TokenMaxTTL {
+			errs.add(fmt.Errorf("AUTH_TOKEN_TTL must be positive and not exceed AUTH_TOKEN_MAX_TTL"))
+		}
+	}
+	return errs.err()
+}
+
 // Config holds the application configuration
 type Config struct {
-	ServerPort  int         `env:"PORT" envDefault:"8080"`
-	MetricsPort int         `env:"METRICS_PORT" envDefault:"9100"`
-	URIPrefix   string      `env:"URI_PREFIX" envDefault:"/providers"`
-	StorageType StorageType `env:"STORAGE_TYPE" envDefault:"local"`
-	CacheDir    string      `env:"CACHE_DIR" envDefault:"./cache"`
-	LogLevel    string      `env:"LOG_LEVEL" envDefault:"info"`
-	S3          S3Config
+	ServerPort   int         `env:"PORT" envDefault:"8080"`
+	MetricsPort  int         `env:"METRICS_PORT" envDefault:"9100"`
+	URIPrefix    string      `env:"URI_PREFIX" envDefault:"/providers"`
+	StorageType  StorageType `env:"STORAGE_TYPE" envDefault:"local"`
+	CacheDir     string      `env:"CACHE_DIR" envDefault:"./cache"`
+	CacheDedup   bool        `env:"CACHE_DEDUP" envDefault:"false"`
+	LogLevel     string      `env:"LOG_LEVEL" envDefault:"info"`
+	LogBackend   string      `env:"LOG_BACKEND" envDefault:"logrus"`
+	HTTP         HTTPConfig
+	S3           S3Config
+	Azure        AzureConfig
+	B2           B2Config
+	OCI          OCIConfig
+	SFTP         SFTPConfig
+	WebDAV       WebDAVConfig
+	Discovery    DiscoveryConfig
+	Modules      ModulesConfig
+	Verification VerificationConfig
+	Auth         AuthConfig
+	Upstream     UpstreamConfig
+	Expiration   ExpirationConfig
+	Eviction     EvictionConfig
+	Prewarm      PrewarmConfig
+	Mirror       MirrorConfig
+	Sync         SyncConfig
+	MemoryCache  MemoryCacheConfig
+	HotCache     HotCacheConfig
+	Scrub        ScrubConfig
+	Catalog      CatalogConfig
+	Telemetry    TelemetryConfig
+	// Pins lists the providers protected from eviction and deletion, as registry/namespace/provider[/version]
+	Pins string `env:"CACHE_PINS"`
+	// KeyLayout lays the cached artifacts out in the storage: path mirrors the registry paths, tenant nests them
+	// under KeyTenant
+	KeyLayout string `env:"KEY_LAYOUT" envDefault:"path"`
+	// KeyTenant is the tenant the keys are nested under by the tenant layout
+	KeyTenant string `env:"KEY_TENANT"`
+	// DefaultRegistry is the registry of the provider paths without the registry segment, e.g.
+	// /providers/hashicorp/aws/index.json. Such paths aren't served when it is empty.
+	DefaultRegistry string `env:"DEFAULT_REGISTRY"`
+	// RegistryAliases maps registry hosts to the registry they are served from, e.g. registry.opentofu.org to
+	// registry.terraform.io, so several hostnames share the same cached artifacts
+	RegistryAliases map[string]string `env:"REGISTRY_ALIASES"`
+	// ValidOS and ValidArch list the platforms of the provider binaries that are served, the platforms providers
+	// are commonly released for if empty
+	ValidOS   []string `env:"VALID_OS"`
+	ValidArch []string `env:"VALID_ARCH"`
+	// TransparencyLog records the checksum of every verified provider binary in an append-only log
+	TransparencyLog bool `env:"TRANSPARENCY_LOG" envDefault:"true"`
+	// AlertWebhookURL receives alerts, such as upstream checksum changes, as JSON POST requests
+	AlertWebhookURL string `env:"ALERT_WEBHOOK_URL"`
+	// OfflineMode serves the provider mirror exclusively from the cache, upstream registries are never contacted
+	OfflineMode bool `env:"OFFLINE_MODE" envDefault:"false"`
+	// StorageStartupTimeout is how long the storage is retried on startup while it's unavailable, 0 fails on the
+	// first error
+	StorageStartupTimeout time.Duration `env:"STORAGE_STARTUP_TIMEOUT" envDefault:"0"`
+	// StorageSelfTest writes, reads and deletes a canary object on startup, so missing permissions fail the startup
+	StorageSelfTest bool `env:"STORAGE_SELF_TEST" envDefault:"false"`
+	// MetadataGCInterval is the time between two collections of orphaned metadata, disabled if zero
+	MetadataGCInterval time.Duration `env:"METADATA_GC_INTERVAL" envDefault:"0"`
+	// StaleIfErrorMaxAge is how old a persisted provider index served during upstream outages may be, 0 disables it
+	StaleIfErrorMaxAge time.Duration `env:"STALE_IF_ERROR_MAX_AGE" envDefault:"0"`
+	// IndexCacheSize is the number of provider index.json responses kept in memory, 0 disables the response cache
+	IndexCacheSize int `env:"INDEX_CACHE_SIZE" envDefault:"1000"`
+	// IndexCacheTTL is how long an index.json response is served from memory, 0 disables the response cache
+	IndexCacheTTL time.Duration `env:"INDEX_CACHE_TTL" envDefault:"5s"`
+	// RenderCacheSize is the number of encoded index and version responses reused while upstream doesn't change
+	RenderCacheSize int `env:"RENDER_CACHE_SIZE" envDefault:"1000"`
+	// PresignedRedirectTTL redirects the downloads of cached provider binaries to presigned S3 URLs valid this
+	// long instead of proxying them, 0 disables the redirects
+	PresignedRedirectTTL time.Duration `env:"PRESIGNED_REDIRECT_TTL" envDefault:"0"`
+	// Caches are the additional caches served under their own URI prefix, listed by name in CACHES
+	Caches []CacheConfig `env:"CACHES"`
+}
+
+// ValidationRules returns the rules the request paths are validated with
+func (c *Config) ValidationRules() validate.Rules {
+	return validate.Rules{OS: c.ValidOS, Arch: c.ValidArch}
 }
 
-// Validate checks if the configuration is valid
+// Validate checks if the configuration is valid, reporting all the problems found
 func (c *Config) Validate() error {
+	var errs Errors
+
 	if c.ServerPort <= 0 || c.ServerPort > 65535 {
-		return fmt.Errorf("invalid PORT: must be between 1 and 65535")
+		errs.add(fmt.Errorf("invalid PORT: must be between 1 and 65535"))
+	}
+
+	if err := logger.ValidateBackend(c.LogBackend); err != nil {
+		errs.add(fmt.Errorf("invalid LOG_BACKEND: %w", err))
+	}
+
+	errs.add(c.HTTP.Validate())
+
+	if c.DefaultRegistry != "" && !validate.Registry(c.DefaultRegistry) {
+		errs.add(fmt.Errorf("invalid DEFAULT_REGISTRY %q: must be a registry host", c.DefaultRegistry))
+	}
+	// Sorted so the errors are reported in the same order every time
+	for _, alias := range slices.Sorted(maps.Keys(c.RegistryAliases)) {
+		registry := c.RegistryAliases[alias]
+		switch {
+		case !validate.Registry(alias) || !validate.Registry(registry):
+			errs.add(fmt.Errorf("invalid REGISTRY_ALIASES entry %s=%s: must map a registry host to another", alias, registry))
+		case alias == registry:
+			errs.add(fmt.Errorf("invalid REGISTRY_ALIASES entry %s=%s: a registry can't be its own alias", alias, registry))
+		case c.RegistryAliases[registry] != "":
+			// Aliases are resolved once
+			errs.add(fmt.Errorf("invalid REGISTRY_ALIASES entry %s=%s: %s is an alias itself", alias, registry, registry))
+		}
+	}
+
+	if c.Modules.Enabled && c.Modules.Upstream == "" {
+		errs.add(fmt.Errorf("MODULES_UPSTREAM is required when the module cache is enabled"))
+	}
+
+	errs.add(validateRetention(c.Expiration, c.Eviction, c.StorageType))
+
+	if c.Prewarm.File != "" && c.Prewarm.Interval <= 0 {
+		errs.add(fmt.Errorf("PREWARM_INTERVAL must be positive"))
+	}
+
+	if c.OfflineMode && (c.Prewarm.File != "" || c.Mirror.RefreshCron != "") {
+		errs.add(fmt.Errorf("PREWARM_FILE and MIRROR_REFRESH_CRON can't be used with OFFLINE_MODE"))
+	}
+
+	if c.StorageStartupTimeout < 0 {
+		errs.add(fmt.Errorf("STORAGE_STARTUP_TIMEOUT must not be negative"))
+	}
+	if c.MetadataGCInterval < 0 {
+		errs.add(fmt.Errorf("METADATA_GC_INTERVAL must not be negative"))
+	}
+
+	if c.StaleIfErrorMaxAge < 0 {
+		errs.add(fmt.Errorf("STALE_IF_ERROR_MAX_AGE must not be negative"))
+	}
+
+	if c.IndexCacheSize < 0 || c.IndexCacheTTL < 0 {
+		errs.add(fmt.Errorf("INDEX_CACHE_SIZE and INDEX_CACHE_TTL must not be negative"))
+	}
+
+	if c.RenderCacheSize < 0 {
+		errs.add(fmt.Errorf("RENDER_CACHE_SIZE must not be negative"))
 	}
 
-	if c.StorageType == StorageTypeS3 {
+	switch {
+	case c.PresignedRedirectTTL < 0 || c.PresignedRedirectTTL > maxPresignedRedirectTTL:
+		errs.add(fmt.Errorf("PRESIGNED_REDIRECT_TTL must be between 0 and 7 days"))
+	case c.PresignedRedirectTTL > 0 && c.StorageType != StorageTypeS3:
+		errs.add(fmt.Errorf("PRESIGNED_REDIRECT_TTL requires STORAGE_TYPE=s3"))
+	case c.PresignedRedirectTTL > 0 && c.Verification.OnServe:
+		errs.add(fmt.Errorf("PRESIGNED_REDIRECT_TTL can't be used with VERIFY_ON_SERVE, redirected downloads aren't verified"))
+	}
+
+	if c.Mirror.RefreshCron != "" {
+		if _, err := cron.Parse(c.Mirror.RefreshCron); err != nil {
+			errs.add(fmt.Errorf("invalid MIRROR_REFRESH_CRON: %w", err))
+		}
+	}
+
+	if _, err := pins.ParsePins(c.Pins); err != nil {
+		errs.add(fmt.Errorf("invalid CACHE_PINS: %w", err))
+	}
+
+	if _, err := layout.NewStrategy(c.KeyLayout, c.KeyTenant); err != nil {
+		errs.add(fmt.Errorf("invalid KEY_LAYOUT: %w", err))
+	}
+
+	if err := c.ValidationRules().Validate(); err != nil {
+		errs.add(fmt.Errorf("invalid VALID_OS or VALID_ARCH: %w", err))
+	}
+
+	if c.AlertWebhookURL != "" {
+		u, err := url.Parse(c.AlertWebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs.add(fmt.Errorf("invalid ALERT_WEBHOOK_URL: must be an http or https URL"))
+		}
+	}
+
+	errs.add(c.Auth.Validate())
+	errs.add(c.Upstream.validateRegistries())
+	if c.Upstream.MaxConcurrentFetches < 0 {
+		errs.add(fmt.Errorf("UPSTREAM_MAX_CONCURRENT_FETCHES must not be negative"))
+	}
+
+	if c.Sync.Enabled() {
+		errs.add(c.Sync.Validate())
+	}
+
+	errs.add(c.MemoryCache.Validate())
+	errs.add(c.HotCache.Validate())
+	errs.add(c.validateHotCache())
+	errs.add(c.Scrub.Validate())
+	errs.add(c.Catalog.Validate())
+	errs.add(c.validateCatalog())
+
+	if c.Telemetry.Enabled {
+		errs.add(c.Telemetry.Validate())
+	}
+
+	switch c.StorageType {
+	case StorageTypeLocal:
+	case StorageTypeS3, StorageTypeTiered:
 		if err := c.S3.Validate(); err != nil {
-			return fmt.Errorf("invalid S3 configuration: %w", err)
+			errs.add(fmt.Errorf("invalid S3 configuration: %w", err))
+		}
+		// Tiered storage writes to its local tier first already
+		if c.StorageType == StorageTypeTiered && c.S3.WriteBehindDir != "" {
+			errs.add(fmt.Errorf("S3_WRITE_BEHIND_DIR is only supported with s3 storage"))
+		}
+	case StorageTypeAzure:
+		errs.add(c.Azure.Validate())
+	case StorageTypeB2:
+		errs.add(c.B2.Validate())
+	case StorageTypeOCI:
+		errs.add(c.OCI.Validate())
+	case StorageTypeSFTP:
+		errs.add(c.SFTP.Validate())
+	case StorageTypeWebDAV:
+		errs.add(c.WebDAV.Validate())
+	default:
+		// Backends registered by forks validate their own configuration when they're created
+		if !storage.IsRegistered(string(c.StorageType)) {
+			errs.add(fmt.Errorf("invalid STORAGE_TYPE: must be one of %s", strings.Join(storage.Registered(), ", ")))
 		}
-	} else if c.StorageType != StorageTypeLocal {
-		return fmt.Errorf("invalid STORAGE_TYPE: must be 'local' or 's3'")
 	}
 
-	return nil
+	errs.add(c.validateCaches())
+
+	return errs.err()
+}
+
+// validateRetention checks the expiration and eviction settings of a cache
+func validateRetention(expiration ExpirationConfig, eviction EvictionConfig, storageType StorageType) error {
+	var errs Errors
+	if expiration.TTL < 0 || expiration.TTL > 0 && expiration.Interval <= 0 {
+		errs.add(fmt.Errorf("CACHE_TTL must not be negative and CACHE_EXPIRATION_INTERVAL must be positive"))
+	}
+
+	if eviction.MaxSizeBytes < 0 || eviction.MaxSizeBytes > 0 && eviction.Interval <= 0 {
+		errs.add(fmt.Errorf("CACHE_MAX_SIZE_BYTES must not be negative and CACHE_EVICTION_INTERVAL must be positive"))
+	}
+	if eviction.SizeScanInterval < 0 {
+		errs.add(fmt.Errorf("CACHE_SIZE_SCAN_INTERVAL must not be negative"))
+	}
+	if eviction.MaxSizeBytes > 0 && storageType != StorageTypeLocal {
+		errs.add(fmt.Errorf("CACHE_MAX_SIZE_BYTES is only supported with local storage"))
+	}
+	if eviction.DiskReserve < 0 {
+		errs.add(fmt.Errorf("CACHE_DISK_RESERVE must not be negative"))
+	}
+	if eviction.DiskReserve > 0 && storageType != StorageTypeLocal && storageType != StorageTypeTiered {
+		errs.add(fmt.Errorf("CACHE_DISK_RESERVE is only supported with local and tiered storage"))
+	}
+	if eviction.MaxSizeBytes > 0 {
+		switch eviction.Policy {
+		case "lru", "lfu", "fifo":
+		case "ttl":
+			if expiration.TTL <= 0 {
+				errs.add(fmt.Errorf("CACHE_EVICTION_POLICY=ttl requires CACHE_TTL"))
+			}
+		default:
+			errs.add(fmt.Errorf("invalid CACHE_EVICTION_POLICY: must be 'lru', 'lfu', 'fifo' or 'ttl'"))
+		}
+	}
+	return errs.err()
 }
 
 // IsS3 returns true if the storage type is S3
@@ -73,49 +1065,399 @@ func (c *Config) IsLocal() bool {
 
 // LoadConfig loads configuration from environment variables
 func LoadConfig() (*Config, error) {
-	// Load .env file if it exists (ignoring errors as .env is optional)
-	if err := godotenv.Load(); err != nil && !os.IsNotExist(err) {
-		return nil, fmt.Errorf("error loading .env file: %w", err)
+	// Load the env file, variables already set in the environment take precedence
+	if err := loadEnvFile(); err != nil {
+		return nil, err
 	}
 
+	// Invalid values are collected and reported together with the validation problems
+	env := &envParser{}
+
 	// Load basic configuration
-	port, err := strconv.Atoi(getEnv("PORT", "8080"))
-	if err != nil {
-		return nil, fmt.Errorf("invalid PORT value: %w", err)
-	}
+	port := env.int("PORT", "8080")
+	metricsPort := env.int("METRICS_PORT", "9100")
+	storageType := StorageType(getEnv("STORAGE_TYPE", "local"))
+	cacheDedup := env.bool("CACHE_DEDUP", "false")
+	s3UsePathStyle := env.bool("S3_USE_PATH_STYLE", "false")
+	s3DisableSSL := env.bool("S3_DISABLE_SSL", "false")
+	s3RequesterPays := env.bool("S3_REQUESTER_PAYS", "false")
+	s3WriteBehindMaxPending := env.int("S3_WRITE_BEHIND_MAX_PENDING", "1000")
+	discoveryEnabled := env.bool("DISCOVERY_ENABLED", "true")
+	modulesEnabled := env.bool("MODULES_ENABLED", "true")
 
-	metricsPort, err := strconv.Atoi(getEnv("METRICS_PORT", "9100"))
-	if err != nil {
-		return nil, fmt.Errorf("invalid METRICS_PORT value: %w", err)
-	}
+	// HTTP server and Gin engine
+	ginDebugRoutes := env.bool("GIN_DEBUG_ROUTES", "false")
+	maxMultipartMemory := env.size("HTTP_MAX_MULTIPART_MEMORY", "32MiB")
+	readHeaderTimeout := env.duration("HTTP_READ_HEADER_TIMEOUT", "10s")
+	readTimeout := env.duration("HTTP_READ_TIMEOUT", "0")
+	writeTimeout := env.duration("HTTP_WRITE_TIMEOUT", "0")
+	idleTimeout := env.duration("HTTP_IDLE_TIMEOUT", "2m")
+	rateLimitRPS := env.float("RATE_LIMIT_RPS", "0")
+	rateLimitBurst := env.int("RATE_LIMIT_BURST", "20")
+	responseBandwidth := env.size("RESPONSE_BANDWIDTH_LIMIT", "0")
 
-	storageType := StorageType(getEnv("STORAGE_TYPE", "local"))
-	if storageType != StorageTypeLocal && storageType != StorageTypeS3 {
-		return nil, fmt.Errorf("invalid STORAGE_TYPE: must be 'local' or 's3'")
+	// Verification and serving modes
+	gpgVerify := env.bool("GPG_VERIFY", "false")
+	gpgVerifyRequired := env.bool("GPG_VERIFY_REQUIRED", "false")
+	verifyOnServe := env.bool("VERIFY_ON_SERVE", "false")
+	offlineMode := env.bool("OFFLINE_MODE", "false")
+	staleIfErrorMaxAge := env.duration("STALE_IF_ERROR_MAX_AGE", "0")
+	storageStartupTimeout := env.duration("STORAGE_STARTUP_TIMEOUT", "0")
+	storageSelfTest := env.bool("STORAGE_SELF_TEST", "false")
+	metadataGCInterval := env.duration("METADATA_GC_INTERVAL", "0")
+	indexCacheSize := env.int("INDEX_CACHE_SIZE", "1000")
+	indexCacheTTL := env.duration("INDEX_CACHE_TTL", "5s")
+	renderCacheSize := env.int("RENDER_CACHE_SIZE", "1000")
+	presignedRedirectTTL := env.duration("PRESIGNED_REDIRECT_TTL", "0")
+	transparencyLog := env.bool("TRANSPARENCY_LOG", "true")
+
+	// Authentication and upstream requests
+	tokenTTL := env.duration("AUTH_TOKEN_TTL", "15m")
+	tokenMaxTTL := env.duration("AUTH_TOKEN_MAX_TTL", "1h")
+	keysReloadInterval := env.duration("AUTH_KEYS_RELOAD_INTERVAL", "30s")
+	allowPrivateNetworks := env.bool("UPSTREAM_ALLOW_PRIVATE_NETWORKS", "false")
+	maxConcurrentFetches := env.int("UPSTREAM_MAX_CONCURRENT_FETCHES", "0")
+
+	// Retention and background jobs
+	cacheTTL := env.duration("CACHE_TTL", "0")
+	expirationInterval := env.duration("CACHE_EXPIRATION_INTERVAL", "1h")
+	maxSizeBytes := env.size("CACHE_MAX_SIZE_BYTES", "0")
+	evictionInterval := env.duration("CACHE_EVICTION_INTERVAL", "1m")
+	sizeScanInterval := env.duration("CACHE_SIZE_SCAN_INTERVAL", "1h")
+	diskReserve := env.size("CACHE_DISK_RESERVE", "0")
+	prewarmInterval := env.duration("PREWARM_INTERVAL", "6h")
+	syncInterval := env.duration("SYNC_INTERVAL", "15m")
+
+	// In-memory cache of small files
+	memoryCacheMaxBytes := env.size("MEMORY_CACHE_MAX_BYTES", "0")
+	memoryCacheMaxObjectBytes := env.size("MEMORY_CACHE_MAX_OBJECT_BYTES", "1MiB")
+	memoryCacheTTL := env.duration("MEMORY_CACHE_TTL", "1m")
+	memoryCachePreloadIndexes := env.int("MEMORY_CACHE_PRELOAD_INDEXES", "0")
+	memoryCachePreloadSaveInterval := env.duration("MEMORY_CACHE_PRELOAD_SAVE_INTERVAL", "5m")
+
+	// Local disk cache of large files
+	hotCacheMaxBytes := env.size("HOT_CACHE_MAX_BYTES", "1GiB")
+	hotCacheMinObjectBytes := env.size("HOT_CACHE_MIN_OBJECT_BYTES", "10MiB")
+	hotCacheTTL := env.duration("HOT_CACHE_TTL", "1h")
+
+	// Background integrity checks of the cached binaries
+	scrubInterval := env.duration("SCRUB_INTERVAL", "0")
+	catalogSaveInterval := env.duration("CATALOG_SAVE_INTERVAL", "1m")
+	catalogRebuildInterval := env.duration("CATALOG_REBUILD_INTERVAL", "24h")
+
+	// Anonymous usage statistics, strictly opt-in
+	telemetryEnabled := env.bool("TELEMETRY_ENABLED", "false")
+	telemetryInterval := env.duration("TELEMETRY_INTERVAL", "24h")
+
+	// Hostnames served from the cached artifacts of another registry
+	registryAliases := parseEnv(env, "REGISTRY_ALIASES", "", ParseRegistryAliases)
+
+	uriPrefix := getEnv("URI_PREFIX", "/providers")
+	modulesURIPrefix := getEnv("MODULES_URI_PREFIX", "/modules")
+
+	// Only advertise modules.v1 by default when the module cache is served
+	defaultModulesV1 := ""
+	if modulesEnabled {
+		defaultModulesV1 = strings.TrimSuffix(modulesURIPrefix, "/") + "/"
 	}
 
 	// Create config instance
 	cfg := &Config{
 		ServerPort:  port,
 		MetricsPort: metricsPort,
-		URIPrefix:   getEnv("URI_PREFIX", "/providers"),
+		URIPrefix:   uriPrefix,
 		StorageType: storageType,
 		CacheDir:    getEnv("CACHE_DIR", "./cache"),
+		CacheDedup:  cacheDedup,
 		LogLevel:    getEnv("LOG_LEVEL", "info"),
+		LogBackend:  getEnv("LOG_BACKEND", logger.BackendLogrus),
+		HTTP: HTTPConfig{
+			// Gin defaults to debug mode, which isn't meant for production
+			GinMode:            getEnv("GIN_MODE", "release"),
+			DebugRoutes:        ginDebugRoutes,
+			MaxMultipartMemory: maxMultipartMemory,
+			ReadHeaderTimeout:  readHeaderTimeout,
+			ReadTimeout:        readTimeout,
+			WriteTimeout:       writeTimeout,
+			IdleTimeout:        idleTimeout,
+			Middlewares:        getEnv("ROUTE_MIDDLEWARES", "*=client,log"),
+			RateLimitRPS:       rateLimitRPS,
+			RateLimitBurst:     rateLimitBurst,
+			ResponseBandwidth:  responseBandwidth,
+		},
 		S3: S3Config{
-			Bucket: getEnv("S3_BUCKET", ""),
-			Region: getEnv("S3_REGION", "eu-central-1"),
+			Bucket:       getEnv("S3_BUCKET", ""),
+			Region:       getEnv("S3_REGION", "eu-central-1"),
+			KeyPrefix:    getEnv("S3_KEY_PREFIX", ""),
+			Endpoint:     getEnv("S3_ENDPOINT", ""),
+			UsePathStyle: s3UsePathStyle,
+			DisableSSL:   s3DisableSSL,
+			// The role is assumed with the default credentials, e.g. of the instance profile
+			RoleARN:         getEnv("S3_ROLE_ARN", ""),
+			ExternalID:      getEnv("S3_ROLE_EXTERNAL_ID", ""),
+			RoleSessionName: getEnv("S3_ROLE_SESSION_NAME", "cachetf"),
+			// Uploads use the default encryption of the bucket unless set
+			ServerSideEncryption: getEnv("S3_SSE", ""),
+			SSEKMSKeyID:          getEnv("S3_SSE_KMS_KEY_ID", ""),
+			RequesterPays:        s3RequesterPays,
+			StorageClass:         getEnv("S3_STORAGE_CLASS", ""),
+			// Writes are acknowledged once uploaded unless a staging directory is set
+			WriteBehindDir:        getEnv("S3_WRITE_BEHIND_DIR", ""),
+			WriteBehindMaxPending: s3WriteBehindMaxPending,
+		},
+		Azure: AzureConfig{
+			ConnectionString: getEnv("AZURE_STORAGE_CONNECTION_STRING", ""),
+			AccountName:      getEnv("AZURE_STORAGE_ACCOUNT", ""),
+			SASToken:         getEnv("AZURE_STORAGE_SAS_TOKEN", ""),
+			Container:        getEnv("AZURE_STORAGE_CONTAINER", ""),
+			KeyPrefix:        getEnv("AZURE_STORAGE_KEY_PREFIX", ""),
+			Endpoint:         getEnv("AZURE_STORAGE_ENDPOINT", ""),
+		},
+		B2: B2Config{
+			API:            getEnv("B2_API", storage.B2APINative),
+			KeyID:          getEnv("B2_KEY_ID", ""),
+			ApplicationKey: getEnv("B2_APPLICATION_KEY", ""),
+			Bucket:         getEnv("B2_BUCKET", ""),
+			Region:         getEnv("B2_REGION", ""),
+			KeyPrefix:      getEnv("B2_KEY_PREFIX", ""),
+			Endpoint:       getEnv("B2_ENDPOINT", ""),
+		},
+		OCI: OCIConfig{
+			Auth:           getEnv("OCI_AUTH", storage.OCIAuthAPIKey),
+			Region:         getEnv("OCI_REGION", ""),
+			Namespace:      getEnv("OCI_NAMESPACE", ""),
+			Bucket:         getEnv("OCI_BUCKET", ""),
+			KeyPrefix:      getEnv("OCI_KEY_PREFIX", ""),
+			TenancyOCID:    getEnv("OCI_TENANCY_OCID", ""),
+			UserOCID:       getEnv("OCI_USER_OCID", ""),
+			Fingerprint:    getEnv("OCI_FINGERPRINT", ""),
+			PrivateKeyFile: getEnv("OCI_PRIVATE_KEY_FILE", ""),
+			Endpoint:       getEnv("OCI_ENDPOINT", ""),
+		},
+		SFTP: SFTPConfig{
+			Host:                 getEnv("SFTP_HOST", ""),
+			User:                 getEnv("SFTP_USER", ""),
+			PrivateKeyFile:       getEnv("SFTP_PRIVATE_KEY_FILE", ""),
+			PrivateKeyPassphrase: getEnv("SFTP_PRIVATE_KEY_PASSPHRASE", ""),
+			KnownHostsFile:       getEnv("SFTP_KNOWN_HOSTS_FILE", ""),
+			BasePath:             getEnv("SFTP_BASE_PATH", ""),
+		},
+		WebDAV: WebDAVConfig{
+			URL:      getEnv("WEBDAV_URL", ""),
+			Username: getEnv("WEBDAV_USERNAME", ""),
+			Password: getEnv("WEBDAV_PASSWORD", ""),
+			Token:    getEnv("WEBDAV_TOKEN", ""),
+		},
+		Discovery: DiscoveryConfig{
+			Enabled: discoveryEnabled,
+			// Not advertised by default, providers.v1 is the registry protocol while the provider routes serve the
+			// network mirror protocol, which Terraform only uses when configured as a provider_installation mirror
+			ProvidersV1: getEnv("DISCOVERY_PROVIDERS_V1", ""),
+			ModulesV1:   getEnv("DISCOVERY_MODULES_V1", defaultModulesV1),
+		},
+		Modules: ModulesConfig{
+			Enabled:   modulesEnabled,
+			URIPrefix: modulesURIPrefix,
+			Upstream:  getEnv("MODULES_UPSTREAM", "registry.terraform.io"),
+		},
+		Verification: VerificationConfig{
+			// Mandatory verification implies verification
+			Enabled:     gpgVerify || gpgVerifyRequired,
+			Required:    gpgVerifyRequired,
+			KeyringFile: getEnv("GPG_KEYRING_FILE", ""),
+			OnServe:     verifyOnServe,
+		},
+		Expiration: ExpirationConfig{
+			TTL:      cacheTTL,
+			Interval: expirationInterval,
+		},
+		Pins:                  getEnv("CACHE_PINS", ""),
+		KeyLayout:             getEnv("KEY_LAYOUT", layout.StrategyPath),
+		KeyTenant:             getEnv("KEY_TENANT", ""),
+		DefaultRegistry:       getEnv("DEFAULT_REGISTRY", ""),
+		RegistryAliases:       registryAliases,
+		ValidOS:               splitList(getEnv("VALID_OS", "")),
+		ValidArch:             splitList(getEnv("VALID_ARCH", "")),
+		TransparencyLog:       transparencyLog,
+		AlertWebhookURL:       getEnv("ALERT_WEBHOOK_URL", ""),
+		OfflineMode:           offlineMode,
+		StaleIfErrorMaxAge:    staleIfErrorMaxAge,
+		StorageStartupTimeout: storageStartupTimeout,
+		StorageSelfTest:       storageSelfTest,
+		MetadataGCInterval:    metadataGCInterval,
+		IndexCacheSize:        indexCacheSize,
+		IndexCacheTTL:         indexCacheTTL,
+		RenderCacheSize:       renderCacheSize,
+		PresignedRedirectTTL:  presignedRedirectTTL,
+		Eviction: EvictionConfig{
+			MaxSizeBytes: maxSizeBytes,
+			Interval:     evictionInterval,
+			Policy:       strings.ToLower(getEnv("CACHE_EVICTION_POLICY", "lru")),
+			// Measuring the cache size on its own is only needed without a size limit
+			SizeScanInterval: sizeScanInterval,
+			DiskReserve:      diskReserve,
+		},
+		Prewarm: PrewarmConfig{
+			File:     getEnv("PREWARM_FILE", ""),
+			Interval: prewarmInterval,
+		},
+		Mirror: MirrorConfig{
+			RefreshCron: getEnv("MIRROR_REFRESH_CRON", ""),
+			Platforms:   splitList(getEnv("MIRROR_REFRESH_PLATFORMS", "")),
+		},
+		Sync: SyncConfig{
+			Source:   getEnv("SYNC_SOURCE", ""),
+			Token:    getEnv("SYNC_TOKEN", ""),
+			Interval: syncInterval,
+			Prefixes: splitList(getEnv("SYNC_PREFIXES", "")),
+		},
+		MemoryCache: MemoryCacheConfig{
+			MaxBytes:            memoryCacheMaxBytes,
+			MaxObjectBytes:      memoryCacheMaxObjectBytes,
+			TTL:                 memoryCacheTTL,
+			PreloadIndexes:      memoryCachePreloadIndexes,
+			PreloadSaveInterval: memoryCachePreloadSaveInterval,
+		},
+		HotCache: HotCacheConfig{
+			Dir:            getEnv("HOT_CACHE_DIR", ""),
+			MaxBytes:       hotCacheMaxBytes,
+			MinObjectBytes: hotCacheMinObjectBytes,
+			TTL:            hotCacheTTL,
+		},
+		Scrub: ScrubConfig{
+			Interval: scrubInterval,
+			Action:   getEnv("SCRUB_ACTION", ScrubQuarantine),
+		},
+		Catalog: CatalogConfig{
+			Path:            getEnv("CATALOG_PATH", ""),
+			SaveInterval:    catalogSaveInterval,
+			RebuildInterval: catalogRebuildInterval,
+		},
+		Telemetry: TelemetryConfig{
+			Enabled:  telemetryEnabled,
+			Endpoint: getEnv("TELEMETRY_ENDPOINT", ""),
+			Interval: telemetryInterval,
+		},
+		Upstream: UpstreamConfig{
+			InsecureSkipVerify:   splitList(getEnv("UPSTREAM_INSECURE_SKIP_VERIFY", "")),
+			AllowedHosts:         splitList(getEnv("UPSTREAM_ALLOWED_HOSTS", "")),
+			AllowPrivateNetworks: allowPrivateNetworks,
+			MaxConcurrentFetches: maxConcurrentFetches,
+			Registries:           loadRegistries(env),
+		},
+		Auth: AuthConfig{
+			APIKeys:            getEnv("AUTH_API_KEYS", ""),
+			AnonymousScopes:    getEnv("AUTH_ANONYMOUS_SCOPES", "read"),
+			TokenSecret:        getEnv("AUTH_TOKEN_SECRET", ""),
+			TokenTTL:           tokenTTL,
+			TokenMaxTTL:        tokenMaxTTL,
+			KeysReloadInterval: keysReloadInterval,
 		},
 	}
 
+	// Additional caches default to the settings of the primary cache
+	cfg.Caches = loadCaches(env, cfg)
+
 	// Validate configuration
-	if err := cfg.Validate(); err != nil {
+	errs := env.errs
+	errs.add(cfg.Validate())
+	if err := errs.err(); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
 
 	return cfg, nil
 }
 
+// envParser reads typed environment variables. Invalid values are collected instead of failing the load and
+// replaced by their default, so the rest of the configuration can still be validated.
+type envParser struct {
+	errs Errors
+}
+
+// parseEnv parses the value of key, recording it and falling back to the default value if it is invalid
+func parseEnv[T any](p *envParser, key, defaultValue string, parse func(string) (T, error)) T {
+	value, err := parse(getEnv(key, defaultValue))
+	if err != nil {
+		p.errs.add(fmt.Errorf("invalid %s value: %w", key, err))
+		value, _ = parse(defaultValue)
+	}
+	return value
+}
+
+func (p *envParser) int(key, defaultValue string) int {
+	return parseEnv(p, key, defaultValue, strconv.Atoi)
+}
+
+func (p *envParser) float(key, defaultValue string) float64 {
+	return parseEnv(p, key, defaultValue, func(s string) (float64, error) {
+		return strconv.ParseFloat(s, 64)
+	})
+}
+
+func (p *envParser) size(key, defaultValue string) int64 {
+	return parseEnv(p, key, defaultValue, ParseSize)
+}
+
+func (p *envParser) bool(key, defaultValue string) bool {
+	return parseEnv(p, key, defaultValue, strconv.ParseBool)
+}
+
+func (p *envParser) duration(key, defaultValue string) time.Duration {
+	return parseEnv(p, key, defaultValue, ParseDuration)
+}
+
+// loadEnvFile loads the file named by ENV_FILE into the environment. Without ENV_FILE, a .env file in the
+// working directory is loaded if it exists; an empty ENV_FILE disables env files entirely.
+func loadEnvFile() error {
+	path, explicit := os.LookupEnv("ENV_FILE")
+	if !explicit {
+		// The default file is optional
+		if err := godotenv.Load(); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error loading .env file: %w", err)
+		}
+		return nil
+	}
+	if path == "" {
+		return nil
+	}
+
+	// An explicit file must exist
+	if err := godotenv.Load(path); err != nil {
+		return fmt.Errorf("error loading ENV_FILE %s: %w", path, err)
+	}
+	return nil
+}
+
+// ParseRegistryAliases parses a comma-separated list of alias=registry hosts, e.g.
+// registry.opentofu.org=registry.terraform.io. Hosts are case-insensitive and returned in lowercase.
+func ParseRegistryAliases(value string) (map[string]string, error) {
+	aliases := make(map[string]string)
+	for _, entry := range splitList(value) {
+		alias, registry, ok := strings.Cut(entry, "=")
+		alias = strings.ToLower(strings.TrimSpace(alias))
+		registry = strings.ToLower(strings.TrimSpace(registry))
+		if !ok || alias == "" || registry == "" {
+			return nil, fmt.Errorf("%q isn't an alias=registry entry", entry)
+		}
+		if _, ok := aliases[alias]; ok {
+			return nil, fmt.Errorf("alias %s is listed twice", alias)
+		}
+		aliases[alias] = registry
+	}
+	return aliases, nil
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 // getEnv gets an environment variable or returns a default value
 func getEnv(key, defaultValue string) string {
 	if value, exists := os.LookupEnv(key); exists {
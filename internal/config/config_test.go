@@ -3,10 +3,15 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/storage"
+	"cachetf/pkg/validate"
 )
 
 func TestLoadConfig_Defaults(t *testing.T) {
@@ -128,6 +133,703 @@ func TestLoadConfig_InvalidStorageType(t *testing.T) {
 	assert.Contains(t, err.Error(), "invalid STORAGE_TYPE")
 }
 
+func TestLoadConfig_RegisteredStorageType(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	t.Setenv("METRICS_PORT", "9100")
+	t.Setenv("STORAGE_TYPE", "config-test")
+
+	// The error lists the registered backends
+	_, err := LoadConfig()
+	assert.ErrorContains(t, err, "invalid STORAGE_TYPE: must be one of azure, b2, local, oci, s3, sftp, tiered, webdav")
+
+	// Backends registered outside of the storage package are accepted, without configuration
+	storage.Register("config-test", func(opts storage.Options) (storage.Storage, error) {
+		return storage.NewLocalStorage(opts.CacheDir, opts.Logger), nil
+	})
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, storage.Options{CacheDir: cfg.CacheDir}, cfg.StorageOptions())
+
+	t.Setenv("STORAGE_TYPE", "webdav")
+	t.Setenv("WEBDAV_URL", "https://dav.example.com/cache")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, &storage.WebDAVConfig{URL: "https://dav.example.com/cache"}, cfg.StorageOptions().Config)
+}
+
+func TestLoadConfig_Discovery(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	t.Setenv("METRICS_PORT", "9100")
+	t.Setenv("STORAGE_TYPE", "local")
+	t.Setenv("URI_PREFIX", "/mirror")
+	t.Setenv("DISCOVERY_MODULES_V1", "/modules/")
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+
+	assert.True(t, cfg.Discovery.Enabled)
+	assert.Empty(t, cfg.Discovery.ProvidersV1)
+	assert.Equal(t, "/modules/", cfg.Discovery.ModulesV1)
+
+	// Invalid boolean values are rejected
+	t.Setenv("DISCOVERY_ENABLED", "maybe")
+	_, err = LoadConfig()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid DISCOVERY_ENABLED")
+}
+
+func TestLoadConfig_Upstream(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	t.Setenv("METRICS_PORT", "9100")
+	t.Setenv("STORAGE_TYPE", "local")
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Empty(t, cfg.Upstream.InsecureSkipVerify, "TLS verification should be enabled by default")
+
+	t.Setenv("UPSTREAM_INSECURE_SKIP_VERIFY", "registry.lab.internal, mirror.lab.internal:8443,")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"registry.lab.internal", "mirror.lab.internal:8443"}, cfg.Upstream.InsecureSkipVerify)
+
+	t.Setenv("UPSTREAM_ALLOWED_HOSTS", "registry.terraform.io,*.githubusercontent.com")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"registry.terraform.io", "*.githubusercontent.com"}, cfg.Upstream.AllowedHosts)
+	assert.False(t, cfg.Upstream.AllowPrivateNetworks, "Private networks should be blocked by default")
+
+	t.Setenv("UPSTREAM_ALLOW_PRIVATE_NETWORKS", "true")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.True(t, cfg.Upstream.AllowPrivateNetworks)
+
+	t.Setenv("UPSTREAM_ALLOW_PRIVATE_NETWORKS", "maybe")
+	_, err = LoadConfig()
+	assert.Error(t, err)
+}
+
+func TestLoadConfig_Expiration(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	t.Setenv("METRICS_PORT", "9100")
+	t.Setenv("STORAGE_TYPE", "local")
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Zero(t, cfg.Expiration.TTL, "Expiration should be disabled by default")
+	assert.Equal(t, time.Hour, cfg.Expiration.Interval)
+
+	t.Setenv("CACHE_TTL", "720h")
+	t.Setenv("CACHE_EXPIRATION_INTERVAL", "10m")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, 720*time.Hour, cfg.Expiration.TTL)
+	assert.Equal(t, 10*time.Minute, cfg.Expiration.Interval)
+
+	// Days are accepted too
+	t.Setenv("CACHE_TTL", "30d")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, 720*time.Hour, cfg.Expiration.TTL)
+
+	t.Setenv("CACHE_TTL", "30 days")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "invalid CACHE_TTL")
+
+	t.Setenv("CACHE_TTL", "-1h")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "CACHE_TTL must not be negative")
+}
+
+func TestLoadConfig_Eviction(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	t.Setenv("METRICS_PORT", "9100")
+	t.Setenv("STORAGE_TYPE", "local")
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Zero(t, cfg.Eviction.MaxSizeBytes, "Eviction should be disabled by default")
+	assert.Equal(t, time.Minute, cfg.Eviction.Interval)
+	assert.Equal(t, "lru", cfg.Eviction.Policy)
+
+	t.Setenv("CACHE_MAX_SIZE_BYTES", "10737418240")
+	t.Setenv("CACHE_EVICTION_INTERVAL", "30s")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, int64(10737418240), cfg.Eviction.MaxSizeBytes)
+	assert.Equal(t, 30*time.Second, cfg.Eviction.Interval)
+
+	t.Setenv("CACHE_EVICTION_POLICY", "LFU")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "lfu", cfg.Eviction.Policy)
+
+	t.Setenv("CACHE_EVICTION_POLICY", "random")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "invalid CACHE_EVICTION_POLICY")
+
+	t.Setenv("CACHE_EVICTION_POLICY", "ttl")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "requires CACHE_TTL")
+
+	t.Setenv("CACHE_TTL", "720h")
+	_, err = LoadConfig()
+	require.NoError(t, err)
+	t.Setenv("CACHE_EVICTION_POLICY", "lru")
+
+	// Sizes can have units
+	t.Setenv("CACHE_MAX_SIZE_BYTES", "10GiB")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, int64(10737418240), cfg.Eviction.MaxSizeBytes)
+
+	t.Setenv("CACHE_MAX_SIZE_BYTES", "10 gigabytes")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "invalid CACHE_MAX_SIZE_BYTES")
+
+	t.Setenv("CACHE_MAX_SIZE_BYTES", "-1")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "invalid CACHE_MAX_SIZE_BYTES")
+
+	t.Setenv("CACHE_MAX_SIZE_BYTES", "1024")
+	t.Setenv("STORAGE_TYPE", "s3")
+	t.Setenv("S3_BUCKET", "cache")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "only supported with local storage")
+}
+
+func TestLoadConfig_Pins(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	t.Setenv("METRICS_PORT", "9100")
+	t.Setenv("STORAGE_TYPE", "local")
+
+	t.Setenv("CACHE_PINS", "registry.terraform.io/hashicorp/aws/5.0.0,registry.terraform.io/hashicorp/google")
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "registry.terraform.io/hashicorp/aws/5.0.0,registry.terraform.io/hashicorp/google", cfg.Pins)
+
+	t.Setenv("CACHE_PINS", "hashicorp/aws")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "invalid CACHE_PINS")
+}
+
+func TestLoadConfig_SizeScan(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	t.Setenv("METRICS_PORT", "9100")
+	t.Setenv("STORAGE_TYPE", "local")
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, cfg.Eviction.SizeScanInterval)
+
+	t.Setenv("CACHE_SIZE_SCAN_INTERVAL", "0")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Zero(t, cfg.Eviction.SizeScanInterval)
+
+	t.Setenv("CACHE_SIZE_SCAN_INTERVAL", "-1m")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "CACHE_SIZE_SCAN_INTERVAL must not be negative")
+}
+
+func TestLoadConfig_DiskReserve(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	t.Setenv("METRICS_PORT", "9100")
+	t.Setenv("STORAGE_TYPE", "local")
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Zero(t, cfg.StorageOptions().DiskReserve)
+
+	t.Setenv("CACHE_DISK_RESERVE", "5GiB")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, int64(5<<30), cfg.Eviction.DiskReserve)
+	assert.Equal(t, int64(5<<30), cfg.StorageOptions().DiskReserve)
+
+	t.Setenv("CACHE_DISK_RESERVE", "-1")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "invalid CACHE_DISK_RESERVE")
+
+	// The reserve is kept on the local disk
+	t.Setenv("CACHE_DISK_RESERVE", "1GiB")
+	t.Setenv("STORAGE_TYPE", "s3")
+	t.Setenv("S3_BUCKET", "cache")
+	t.Setenv("S3_REGION", "eu-central-1")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "CACHE_DISK_RESERVE is only supported with local and tiered storage")
+}
+
+func TestLoadConfig_Dedup(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	t.Setenv("METRICS_PORT", "9100")
+	t.Setenv("STORAGE_TYPE", "local")
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.False(t, cfg.CacheDedup)
+	assert.False(t, cfg.StorageOptions().Dedup)
+
+	t.Setenv("CACHE_DEDUP", "true")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.True(t, cfg.CacheDedup)
+	assert.True(t, cfg.StorageOptions().Dedup)
+
+	t.Setenv("CACHE_DEDUP", "sometimes")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "invalid CACHE_DEDUP")
+}
+
+func TestLoadConfig_VerifyOnServe(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	t.Setenv("METRICS_PORT", "9100")
+	t.Setenv("STORAGE_TYPE", "local")
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.False(t, cfg.Verification.OnServe)
+
+	t.Setenv("VERIFY_ON_SERVE", "true")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.True(t, cfg.Verification.OnServe)
+
+	t.Setenv("VERIFY_ON_SERVE", "always")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "invalid VERIFY_ON_SERVE")
+}
+
+func TestLoadConfig_KeyLayout(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	t.Setenv("METRICS_PORT", "9100")
+	t.Setenv("STORAGE_TYPE", "local")
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "path", cfg.KeyLayout)
+	assert.Empty(t, cfg.KeyTenant)
+
+	t.Setenv("KEY_LAYOUT", "tenant")
+	t.Setenv("KEY_TENANT", "team-a")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "tenant", cfg.KeyLayout)
+	assert.Equal(t, "team-a", cfg.KeyTenant)
+
+	t.Setenv("KEY_TENANT", "")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "invalid KEY_LAYOUT")
+
+	t.Setenv("KEY_LAYOUT", "content")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "unknown key layout")
+}
+
+func TestLoadConfig_DefaultRegistry(t *testing.T) {
+	t.Setenv("STORAGE_TYPE", "local")
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Empty(t, cfg.DefaultRegistry)
+
+	t.Setenv("DEFAULT_REGISTRY", "registry.terraform.io")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "registry.terraform.io", cfg.DefaultRegistry)
+
+	t.Setenv("DEFAULT_REGISTRY", "https://registry.terraform.io/")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "invalid DEFAULT_REGISTRY")
+}
+
+func TestLoadConfig_RegistryAliases(t *testing.T) {
+	t.Setenv("STORAGE_TYPE", "local")
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Empty(t, cfg.RegistryAliases)
+
+	t.Setenv("REGISTRY_ALIASES", "Registry.OpenTofu.org=registry.terraform.io, tf-mirror.internal = registry.terraform.io")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"registry.opentofu.org": "registry.terraform.io",
+		"tf-mirror.internal":    "registry.terraform.io",
+	}, cfg.RegistryAliases)
+
+	tests := []struct {
+		value string
+		err   string
+	}{
+		{"registry.opentofu.org", "invalid REGISTRY_ALIASES value"},
+		{"a.example.com=b.example.com,a.example.com=c.example.com", "listed twice"},
+		{"a.example.com=https://b.example.com", "must map a registry host to another"},
+		{"a.example.com=a.example.com", "can't be its own alias"},
+		{"a.example.com=b.example.com,b.example.com=c.example.com", "b.example.com is an alias itself"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("REGISTRY_ALIASES", tt.value)
+			_, err := LoadConfig()
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func TestLoadConfig_ValidationRules(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	t.Setenv("METRICS_PORT", "9100")
+	t.Setenv("STORAGE_TYPE", "local")
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, validate.Rules{}, cfg.ValidationRules())
+
+	t.Setenv("VALID_OS", "linux, netbsd")
+	t.Setenv("VALID_ARCH", "amd64,arm64,riscv64")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, validate.Rules{OS: []string{"linux", "netbsd"}, Arch: []string{"amd64", "arm64", "riscv64"}}, cfg.ValidationRules())
+
+	// Platforms are split on underscores in file names
+	t.Setenv("VALID_ARCH", "arm_64")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "invalid VALID_OS or VALID_ARCH")
+}
+
+func TestLoadConfig_TransparencyLog(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	t.Setenv("METRICS_PORT", "9100")
+	t.Setenv("STORAGE_TYPE", "local")
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.True(t, cfg.TransparencyLog)
+
+	t.Setenv("TRANSPARENCY_LOG", "false")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.False(t, cfg.TransparencyLog)
+
+	t.Setenv("TRANSPARENCY_LOG", "maybe")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "invalid TRANSPARENCY_LOG")
+}
+
+func TestLoadConfig_Prewarm(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	t.Setenv("METRICS_PORT", "9100")
+	t.Setenv("STORAGE_TYPE", "local")
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Empty(t, cfg.Prewarm.File)
+	assert.Equal(t, 6*time.Hour, cfg.Prewarm.Interval)
+
+	t.Setenv("PREWARM_FILE", "/etc/cachetf/prewarm.json")
+	t.Setenv("PREWARM_INTERVAL", "1h")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "/etc/cachetf/prewarm.json", cfg.Prewarm.File)
+	assert.Equal(t, time.Hour, cfg.Prewarm.Interval)
+
+	t.Setenv("PREWARM_INTERVAL", "0s")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "PREWARM_INTERVAL must be positive")
+
+	t.Setenv("PREWARM_INTERVAL", "daily")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "invalid PREWARM_INTERVAL")
+}
+
+func TestLoadConfig_Mirror(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	t.Setenv("METRICS_PORT", "9100")
+	t.Setenv("STORAGE_TYPE", "local")
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Empty(t, cfg.Mirror.RefreshCron)
+	assert.Empty(t, cfg.Mirror.Platforms)
+
+	t.Setenv("MIRROR_REFRESH_CRON", "0 */6 * * *")
+	t.Setenv("MIRROR_REFRESH_PLATFORMS", "linux_amd64, darwin_arm64")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "0 */6 * * *", cfg.Mirror.RefreshCron)
+	assert.Equal(t, []string{"linux_amd64", "darwin_arm64"}, cfg.Mirror.Platforms)
+
+	t.Setenv("MIRROR_REFRESH_CRON", "every 6 hours")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "invalid MIRROR_REFRESH_CRON")
+}
+
+func TestLoadConfig_OfflineMode(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	t.Setenv("METRICS_PORT", "9100")
+	t.Setenv("STORAGE_TYPE", "local")
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.False(t, cfg.OfflineMode)
+
+	t.Setenv("OFFLINE_MODE", "true")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.True(t, cfg.OfflineMode)
+
+	// Nothing can be fetched in the background
+	t.Setenv("MIRROR_REFRESH_CRON", "@daily")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "can't be used with OFFLINE_MODE")
+
+	t.Setenv("OFFLINE_MODE", "sometimes")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "invalid OFFLINE_MODE")
+}
+
+func TestLoadConfig_StaleIfError(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	t.Setenv("METRICS_PORT", "9100")
+	t.Setenv("STORAGE_TYPE", "local")
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Zero(t, cfg.StaleIfErrorMaxAge)
+
+	t.Setenv("STALE_IF_ERROR_MAX_AGE", "24h")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, 24*time.Hour, cfg.StaleIfErrorMaxAge)
+
+	t.Setenv("STALE_IF_ERROR_MAX_AGE", "-1h")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "must not be negative")
+
+	t.Setenv("STALE_IF_ERROR_MAX_AGE", "a day")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "invalid STALE_IF_ERROR_MAX_AGE")
+}
+
+func TestLoadConfig_IndexCache(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	t.Setenv("METRICS_PORT", "9100")
+	t.Setenv("STORAGE_TYPE", "local")
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, 1000, cfg.IndexCacheSize)
+	assert.Equal(t, 5*time.Second, cfg.IndexCacheTTL)
+	assert.Equal(t, 1000, cfg.RenderCacheSize)
+
+	t.Setenv("INDEX_CACHE_SIZE", "0")
+	t.Setenv("INDEX_CACHE_TTL", "1m")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Zero(t, cfg.IndexCacheSize)
+	assert.Equal(t, time.Minute, cfg.IndexCacheTTL)
+
+	t.Setenv("INDEX_CACHE_SIZE", "-1")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "INDEX_CACHE_SIZE and INDEX_CACHE_TTL must not be negative")
+
+	t.Setenv("INDEX_CACHE_SIZE", "0")
+	t.Setenv("RENDER_CACHE_SIZE", "-1")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "RENDER_CACHE_SIZE must not be negative")
+}
+
+func TestLoadConfig_LogBackend(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	t.Setenv("METRICS_PORT", "9100")
+	t.Setenv("STORAGE_TYPE", "local")
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "logrus", cfg.LogBackend)
+
+	t.Setenv("LOG_BACKEND", "zap")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "zap", cfg.LogBackend)
+
+	t.Setenv("LOG_BACKEND", "zerolog")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "invalid LOG_BACKEND")
+}
+
+func TestLoadConfig_HTTP(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	t.Setenv("METRICS_PORT", "9100")
+	t.Setenv("STORAGE_TYPE", "local")
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, HTTPConfig{
+		GinMode:            "release",
+		MaxMultipartMemory: 32 << 20,
+		ReadHeaderTimeout:  10 * time.Second,
+		IdleTimeout:        2 * time.Minute,
+		Middlewares:        "*=client,log",
+		RateLimitBurst:     20,
+	}, cfg.HTTP)
+
+	t.Setenv("GIN_MODE", "debug")
+	t.Setenv("GIN_DEBUG_ROUTES", "true")
+	t.Setenv("HTTP_MAX_MULTIPART_MEMORY", "8MiB")
+	t.Setenv("HTTP_READ_TIMEOUT", "30s")
+	t.Setenv("HTTP_WRITE_TIMEOUT", "10m")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "debug", cfg.HTTP.GinMode)
+	assert.True(t, cfg.HTTP.DebugRoutes)
+	assert.Equal(t, int64(8<<20), cfg.HTTP.MaxMultipartMemory)
+	assert.Equal(t, 30*time.Second, cfg.HTTP.ReadTimeout)
+	assert.Equal(t, 10*time.Minute, cfg.HTTP.WriteTimeout)
+
+	// Rate limit the downloads only
+	t.Setenv("ROUTE_MIDDLEWARES", "*=requestid,client,log;providers=ratelimit;admin=auth")
+	t.Setenv("RATE_LIMIT_RPS", "2.5")
+	t.Setenv("RATE_LIMIT_BURST", "5")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "*=requestid,client,log;providers=ratelimit;admin=auth", cfg.HTTP.Middlewares)
+	assert.Equal(t, 2.5, cfg.HTTP.RateLimitRPS)
+	assert.Equal(t, 5, cfg.HTTP.RateLimitBurst)
+	assert.Equal(t, int64(0), cfg.HTTP.ResponseBandwidth)
+	assert.Equal(t, 0, cfg.Upstream.MaxConcurrentFetches)
+
+	// Bandwidth and upstream concurrency limits
+	t.Setenv("RESPONSE_BANDWIDTH_LIMIT", "10MiB")
+	t.Setenv("UPSTREAM_MAX_CONCURRENT_FETCHES", "8")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, int64(10<<20), cfg.HTTP.ResponseBandwidth)
+	assert.Equal(t, 8, cfg.Upstream.MaxConcurrentFetches)
+
+	t.Setenv("UPSTREAM_MAX_CONCURRENT_FETCHES", "-1")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "UPSTREAM_MAX_CONCURRENT_FETCHES must not be negative")
+	t.Setenv("UPSTREAM_MAX_CONCURRENT_FETCHES", "0")
+
+	t.Setenv("RATE_LIMIT_RPS", "0")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "requires RATE_LIMIT_RPS")
+
+	t.Setenv("ROUTE_MIDDLEWARES", "downloads=log")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "invalid ROUTE_MIDDLEWARES")
+
+	t.Setenv("ROUTE_MIDDLEWARES", "")
+	t.Setenv("GIN_MODE", "production")
+	t.Setenv("HTTP_IDLE_TIMEOUT", "-1s")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "invalid GIN_MODE")
+	assert.ErrorContains(t, err, "must not be negative")
+}
+
+func TestAuthConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  AuthConfig
+		enabled bool
+		errMsg  string
+	}{
+		{"disabled", AuthConfig{AnonymousScopes: "read"}, false, ""},
+		{"valid keys", AuthConfig{APIKeys: "ci:secret:read|prefetch", AnonymousScopes: "read"}, true, ""},
+		{"malformed key", AuthConfig{APIKeys: "ci-secret"}, true, "invalid AUTH_API_KEYS"},
+		{"unknown anonymous scope", AuthConfig{APIKeys: "ci:secret:read", AnonymousScopes: "write"}, true, "invalid AUTH_ANONYMOUS_SCOPES"},
+		{"token secret without keys", AuthConfig{TokenSecret: strings.Repeat("s", 32)}, false, "requires AUTH_API_KEYS"},
+		{"short token secret", AuthConfig{APIKeys: "ci:secret:read", TokenSecret: "short"}, true, "at least 32 characters"},
+		{"token ttl above max", AuthConfig{APIKeys: "ci:secret:read", TokenSecret: strings.Repeat("s", 32), TokenTTL: 2 * time.Hour, TokenMaxTTL: time.Hour}, true, "AUTH_TOKEN_TTL"},
+		{"valid tokens", AuthConfig{APIKeys: "ci:secret:read", TokenSecret: strings.Repeat("s", 32), TokenTTL: time.Minute, TokenMaxTTL: time.Hour}, true, ""},
+		{"negative keys reload interval", AuthConfig{APIKeys: "ci:secret:read", KeysReloadInterval: -time.Second}, true, "AUTH_KEYS_RELOAD_INTERVAL"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.enabled, tt.config.Enabled())
+			err := tt.config.Validate()
+			if tt.errMsg != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errMsg)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestSyncConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config SyncConfig
+		errMsg string
+	}{
+		{"valid", SyncConfig{Source: "https://hub.example.com", Interval: time.Minute}, ""},
+		{"valid prefixes", SyncConfig{Source: "http://hub:8080", Interval: time.Minute, Prefixes: []string{"providers/registry.terraform.io/hashicorp"}}, ""},
+		{"invalid source", SyncConfig{Source: "hub.example.com", Interval: time.Minute}, "invalid SYNC_SOURCE"},
+		{"zero interval", SyncConfig{Source: "https://hub.example.com"}, "SYNC_INTERVAL must be positive"},
+		{"invalid prefix", SyncConfig{Source: "https://hub.example.com", Interval: time.Minute, Prefixes: []string{"metadata/"}}, "invalid SYNC_PREFIXES"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.True(t, tt.config.Enabled())
+			err := tt.config.Validate()
+			if tt.errMsg != "" {
+				assert.ErrorContains(t, err, tt.errMsg)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_Sync(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	t.Setenv("METRICS_PORT", "9100")
+	t.Setenv("STORAGE_TYPE", "local")
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.False(t, cfg.Sync.Enabled())
+
+	t.Setenv("SYNC_SOURCE", "https://hub.example.com")
+	t.Setenv("SYNC_TOKEN", "secret")
+	t.Setenv("SYNC_PREFIXES", "providers/registry.terraform.io/hashicorp, modules/")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, SyncConfig{
+		Source:   "https://hub.example.com",
+		Token:    "secret",
+		Interval: 15 * time.Minute,
+		Prefixes: []string{"providers/registry.terraform.io/hashicorp", "modules/"},
+	}, cfg.Sync)
+}
+
+func TestLoadConfig_WriteBehind(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	t.Setenv("METRICS_PORT", "9100")
+	t.Setenv("STORAGE_TYPE", "s3")
+	t.Setenv("S3_BUCKET", "cache")
+	t.Setenv("S3_REGION", "eu-central-1")
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Nil(t, cfg.StorageOptions().Config.(*storage.S3Config).WriteBehind)
+
+	t.Setenv("S3_WRITE_BEHIND_DIR", "/var/lib/cachetf/staging")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, &storage.WriteBehindConfig{Dir: "/var/lib/cachetf/staging", MaxPending: 1000},
+		cfg.StorageOptions().Config.(*storage.S3Config).WriteBehind)
+
+	t.Setenv("STORAGE_TYPE", "tiered")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "S3_WRITE_BEHIND_DIR is only supported with s3 storage")
+}
+
 func TestS3Config_Validate(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -152,6 +854,102 @@ func TestS3Config_Validate(t *testing.T) {
 			hasErr:  true,
 			errMsg:  "S3_REGION is required",
 		},
+		{
+			name:    "key prefix",
+			config:  S3Config{Bucket: "my-bucket", Region: "us-west-2", KeyPrefix: "cachetf/prod/"},
+			hasErr:  false,
+		},
+		{
+			name:    "invalid key prefix",
+			config:  S3Config{Bucket: "my-bucket", Region: "us-west-2", KeyPrefix: "cachetf/../prod"},
+			hasErr:  true,
+			errMsg:  "invalid S3_KEY_PREFIX",
+		},
+		{
+			name:    "S3-compatible endpoint",
+			config:  S3Config{Bucket: "my-bucket", Region: "us-east-1", Endpoint: "minio.internal:9000", UsePathStyle: true, DisableSSL: true},
+			hasErr:  false,
+		},
+		{
+			name:    "invalid endpoint",
+			config:  S3Config{Bucket: "my-bucket", Region: "us-east-1", Endpoint: "ftp://minio.internal"},
+			hasErr:  true,
+			errMsg:  "invalid S3_ENDPOINT",
+		},
+		{
+			name:    "SSL disabled for an https endpoint",
+			config:  S3Config{Bucket: "my-bucket", Region: "us-east-1", Endpoint: "https://minio.internal", DisableSSL: true},
+			hasErr:  true,
+			errMsg:  "SSL is disabled but the endpoint uses https",
+		},
+		{
+			name:    "assumed role",
+			config:  S3Config{Bucket: "my-bucket", Region: "us-west-2", RoleARN: "arn:aws:iam::123456789012:role/cachetf", ExternalID: "mirror", RoleSessionName: "cachetf"},
+			hasErr:  false,
+		},
+		{
+			name:    "invalid role ARN",
+			config:  S3Config{Bucket: "my-bucket", Region: "us-west-2", RoleARN: "arn:aws:iam::123456789012:user/cachetf", RoleSessionName: "cachetf"},
+			hasErr:  true,
+			errMsg:  "invalid S3_ROLE_ARN",
+		},
+		{
+			name:    "invalid session name",
+			config:  S3Config{Bucket: "my-bucket", Region: "us-west-2", RoleARN: "arn:aws-cn:iam::123456789012:role/cachetf", RoleSessionName: "cache tf"},
+			hasErr:  true,
+			errMsg:  "invalid S3_ROLE_SESSION_NAME",
+		},
+		{
+			name:    "external ID without role",
+			config:  S3Config{Bucket: "my-bucket", Region: "us-west-2", ExternalID: "mirror"},
+			hasErr:  true,
+			errMsg:  "S3_ROLE_EXTERNAL_ID requires S3_ROLE_ARN",
+		},
+		{
+			name:    "KMS encryption",
+			config:  S3Config{Bucket: "my-bucket", Region: "us-west-2", ServerSideEncryption: "aws:kms", SSEKMSKeyID: "alias/cachetf"},
+			hasErr:  false,
+		},
+		{
+			name:    "unsupported encryption",
+			config:  S3Config{Bucket: "my-bucket", Region: "us-west-2", ServerSideEncryption: "aws:kms:dsse-x"},
+			hasErr:  true,
+			errMsg:  "invalid S3_SSE or S3_SSE_KMS_KEY_ID",
+		},
+		{
+			name:    "KMS key without KMS encryption",
+			config:  S3Config{Bucket: "my-bucket", Region: "us-west-2", ServerSideEncryption: "AES256", SSEKMSKeyID: "alias/cachetf"},
+			hasErr:  true,
+			errMsg:  "a KMS key requires aws:kms encryption",
+		},
+		{
+			name:    "requester pays bucket with storage class",
+			config:  S3Config{Bucket: "my-bucket", Region: "us-west-2", RequesterPays: true, StorageClass: "INTELLIGENT_TIERING"},
+			hasErr:  false,
+		},
+		{
+			name:    "unsupported storage class",
+			config:  S3Config{Bucket: "my-bucket", Region: "us-west-2", StorageClass: "intelligent-tiering"},
+			hasErr:  true,
+			errMsg:  "invalid S3_STORAGE_CLASS",
+		},
+		{
+			name:    "write-behind uploads",
+			config:  S3Config{Bucket: "my-bucket", Region: "us-west-2", WriteBehindDir: "/var/lib/cachetf/staging", WriteBehindMaxPending: 100},
+			hasErr:  false,
+		},
+		{
+			name:    "write-behind uploads without pending files",
+			config:  S3Config{Bucket: "my-bucket", Region: "us-west-2", WriteBehindDir: "/var/lib/cachetf/staging"},
+			hasErr:  true,
+			errMsg:  "S3_WRITE_BEHIND_MAX_PENDING must be positive",
+		},
+		{
+			name:    "SSL disabled without endpoint",
+			config:  S3Config{Bucket: "my-bucket", Region: "us-west-2", DisableSSL: true},
+			hasErr:  true,
+			errMsg:  "S3_DISABLE_SSL requires S3_ENDPOINT",
+		},
 	}
 
 	for _, tt := range tests {
@@ -204,6 +1002,72 @@ S3_REGION=eu-west-1
 	assert.Equal(t, "eu-west-1", cfg.S3.Region)
 }
 
+func TestLoadConfig_AllProblems(t *testing.T) {
+	t.Setenv("PORT", "eighty")
+	t.Setenv("METRICS_PORT", "9100")
+	t.Setenv("STORAGE_TYPE", "s3")
+	t.Setenv("S3_BUCKET", "")
+	t.Setenv("CACHE_TTL", "30 days")
+	t.Setenv("AUTH_TOKEN_SECRET", "secret")
+
+	_, err := LoadConfig()
+	require.Error(t, err)
+
+	// Every problem is reported at once
+	var errs Errors
+	require.ErrorAs(t, err, &errs)
+	assert.Len(t, errs, 5)
+	assert.ErrorContains(t, err, "invalid PORT value")
+	assert.ErrorContains(t, err, "invalid CACHE_TTL value")
+	assert.ErrorContains(t, err, "S3_BUCKET is required")
+	assert.ErrorContains(t, err, "AUTH_TOKEN_SECRET requires AUTH_API_KEYS")
+	assert.ErrorContains(t, err, "AUTH_TOKEN_SECRET must be at least 32 characters")
+
+	// Invalid values fall back to their default, so they don't cause follow-up problems
+	assert.NotContains(t, err.Error(), "must be between 1 and 65535")
+}
+
+func TestLoadConfig_EnvFile(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	t.Setenv("METRICS_PORT", "9100")
+	t.Setenv("STORAGE_TYPE", "local")
+
+	// A stray .env file in the working directory
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".env"), []byte("CACHE_DIR=/stray\n"), 0644))
+	oldWd, err := os.Getwd()
+	require.NoError(t, err)
+	t.Cleanup(func() { os.Chdir(oldWd) })
+	os.Chdir(dir)
+
+	// godotenv sets the loaded variables, restore them after the test
+	t.Setenv("CACHE_DIR", "")
+	os.Unsetenv("CACHE_DIR")
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Setenv("ENV_FILE", "")
+		cfg, err := LoadConfig()
+		require.NoError(t, err)
+		assert.Equal(t, "./cache", cfg.CacheDir)
+	})
+
+	t.Run("explicit path", func(t *testing.T) {
+		envPath := filepath.Join(t.TempDir(), "cachetf.env")
+		require.NoError(t, os.WriteFile(envPath, []byte("CACHE_DIR=/explicit\n"), 0644))
+		t.Setenv("ENV_FILE", envPath)
+
+		cfg, err := LoadConfig()
+		require.NoError(t, err)
+		assert.Equal(t, "/explicit", cfg.CacheDir)
+	})
+
+	t.Run("missing explicit file", func(t *testing.T) {
+		t.Setenv("ENV_FILE", filepath.Join(t.TempDir(), "missing.env"))
+		_, err := LoadConfig()
+		assert.ErrorContains(t, err, "error loading ENV_FILE")
+	})
+}
+
 func TestConfig_Validate(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -241,6 +1105,50 @@ func TestConfig_Validate(t *testing.T) {
 			},
 			wantErr: "invalid PORT",
 		},
+		{
+			name: "presigned redirects",
+			config: &Config{
+				ServerPort:           8080,
+				MetricsPort:          9100,
+				StorageType:          StorageTypeS3,
+				S3:                   S3Config{Bucket: "my-bucket", Region: "us-west-2"},
+				PresignedRedirectTTL: 5 * time.Minute,
+			},
+			wantErr: "",
+		},
+		{
+			name: "presigned redirects without s3",
+			config: &Config{
+				ServerPort:           8080,
+				MetricsPort:          9100,
+				StorageType:          StorageTypeLocal,
+				PresignedRedirectTTL: 5 * time.Minute,
+			},
+			wantErr: "PRESIGNED_REDIRECT_TTL requires STORAGE_TYPE=s3",
+		},
+		{
+			name: "presigned redirects longer than a week",
+			config: &Config{
+				ServerPort:           8080,
+				MetricsPort:          9100,
+				StorageType:          StorageTypeS3,
+				S3:                   S3Config{Bucket: "my-bucket", Region: "us-west-2"},
+				PresignedRedirectTTL: 8 * 24 * time.Hour,
+			},
+			wantErr: "PRESIGNED_REDIRECT_TTL must be between 0 and 7 days",
+		},
+		{
+			name: "presigned redirects verified on serve",
+			config: &Config{
+				ServerPort:           8080,
+				MetricsPort:          9100,
+				StorageType:          StorageTypeS3,
+				S3:                   S3Config{Bucket: "my-bucket", Region: "us-west-2"},
+				PresignedRedirectTTL: 5 * time.Minute,
+				Verification:         VerificationConfig{OnServe: true},
+			},
+			wantErr: "can't be used with VERIFY_ON_SERVE",
+		},
 		{
 			name: "invalid storage type",
 			config: &Config{
@@ -263,6 +1171,26 @@ func TestConfig_Validate(t *testing.T) {
 			},
 			wantErr: "S3_BUCKET is required",
 		},
+		{
+			name: "valid alert webhook",
+			config: &Config{
+				ServerPort:      8080,
+				MetricsPort:     9100,
+				StorageType:     StorageTypeLocal,
+				AlertWebhookURL: "https://hooks.example.com/cachetf",
+			},
+			wantErr: "",
+		},
+		{
+			name: "invalid alert webhook",
+			config: &Config{
+				ServerPort:      8080,
+				MetricsPort:     9100,
+				StorageType:     StorageTypeLocal,
+				AlertWebhookURL: "hooks.example.com/cachetf",
+			},
+			wantErr: "invalid ALERT_WEBHOOK_URL",
+		},
 	}
 
 	for _, tt := range tests {
@@ -277,3 +1205,374 @@ func TestConfig_Validate(t *testing.T) {
 		})
 	}
 }
+
+func TestConfig_ValidateTieredStorage(t *testing.T) {
+	cfg := &Config{
+		ServerPort:  8080,
+		StorageType: StorageTypeTiered,
+		CacheDir:    "./cache",
+		Eviction:    EvictionConfig{Policy: "lru"},
+	}
+	assert.ErrorContains(t, cfg.Validate(), "invalid S3 configuration")
+
+	cfg.S3 = S3Config{Bucket: "mirrors", Region: "eu-central-1"}
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestConfig_ValidateAzureStorage(t *testing.T) {
+	cfg := &Config{
+		ServerPort:  8080,
+		StorageType: StorageTypeAzure,
+		Eviction:    EvictionConfig{Policy: "lru"},
+		Azure:       AzureConfig{Container: "mirrors"},
+	}
+	assert.ErrorContains(t, cfg.Validate(), "invalid AZURE_STORAGE_* configuration")
+
+	cfg.Azure.AccountName = "cachetf"
+	cfg.Azure.SASToken = "sv=2021-08-06&sp=rwdl&sig=abc"
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Azure.ConnectionString = "DefaultEndpointsProtocol=https;AccountName=cachetf;AccountKey=a2V5"
+	assert.ErrorContains(t, cfg.Validate(), "can't be combined")
+}
+
+func TestLoadConfig_Azure(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	t.Setenv("METRICS_PORT", "9100")
+	t.Setenv("STORAGE_TYPE", "azure")
+	t.Setenv("AZURE_STORAGE_CONNECTION_STRING", "DefaultEndpointsProtocol=https;AccountName=cachetf;AccountKey=a2V5")
+	t.Setenv("AZURE_STORAGE_CONTAINER", "cachetf")
+	t.Setenv("AZURE_STORAGE_KEY_PREFIX", "prod/")
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, StorageTypeAzure, cfg.StorageType)
+	assert.Equal(t, AzureConfig{
+		ConnectionString: "DefaultEndpointsProtocol=https;AccountName=cachetf;AccountKey=a2V5",
+		Container:        "cachetf",
+		KeyPrefix:        "prod/",
+	}, cfg.Azure)
+}
+
+func TestLoadConfig_B2(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	t.Setenv("METRICS_PORT", "9100")
+	t.Setenv("STORAGE_TYPE", "b2")
+	t.Setenv("B2_KEY_ID", "key-id")
+	t.Setenv("B2_BUCKET", "cachetf")
+
+	_, err := LoadConfig()
+	assert.ErrorContains(t, err, "invalid B2_* configuration")
+
+	t.Setenv("B2_APPLICATION_KEY", "secret")
+	t.Setenv("B2_KEY_PREFIX", "cold/")
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, StorageTypeB2, cfg.StorageType)
+	assert.Equal(t, B2Config{
+		API:            "native",
+		KeyID:          "key-id",
+		ApplicationKey: "secret",
+		Bucket:         "cachetf",
+		KeyPrefix:      "cold/",
+	}, cfg.B2)
+
+	// The S3-compatible API requires the region of the bucket
+	t.Setenv("B2_API", "s3")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "invalid region")
+
+	t.Setenv("B2_REGION", "eu-central-003")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "s3", cfg.B2.API)
+	assert.Equal(t, "eu-central-003", cfg.B2.Region)
+}
+
+func TestLoadConfig_OCI(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	t.Setenv("METRICS_PORT", "9100")
+	t.Setenv("STORAGE_TYPE", "oci")
+	t.Setenv("OCI_BUCKET", "cachetf")
+
+	// API keys are the default
+	_, err := LoadConfig()
+	assert.ErrorContains(t, err, "invalid OCI_* configuration")
+
+	t.Setenv("OCI_AUTH", "instance_principal")
+	t.Setenv("OCI_KEY_PREFIX", "prod/")
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, StorageTypeOCI, cfg.StorageType)
+	assert.Equal(t, OCIConfig{
+		Auth:      "instance_principal",
+		Bucket:    "cachetf",
+		KeyPrefix: "prod/",
+	}, cfg.OCI)
+}
+
+func TestLoadConfig_SFTP(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	t.Setenv("METRICS_PORT", "9100")
+	t.Setenv("STORAGE_TYPE", "sftp")
+	t.Setenv("SFTP_HOST", "artifacts.internal:2222")
+	t.Setenv("SFTP_USER", "cachetf")
+	t.Setenv("SFTP_PRIVATE_KEY_FILE", "/etc/cachetf/id_ed25519")
+
+	// Host keys must be known
+	_, err := LoadConfig()
+	assert.ErrorContains(t, err, "invalid SFTP_* configuration")
+
+	t.Setenv("SFTP_KNOWN_HOSTS_FILE", "/etc/cachetf/known_hosts")
+	t.Setenv("SFTP_BASE_PATH", "/srv/artifacts/cachetf")
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, StorageTypeSFTP, cfg.StorageType)
+	assert.Equal(t, SFTPConfig{
+		Host:           "artifacts.internal:2222",
+		User:           "cachetf",
+		PrivateKeyFile: "/etc/cachetf/id_ed25519",
+		KnownHostsFile: "/etc/cachetf/known_hosts",
+		BasePath:       "/srv/artifacts/cachetf",
+	}, cfg.SFTP)
+}
+
+func TestLoadConfig_WebDAV(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	t.Setenv("METRICS_PORT", "9100")
+	t.Setenv("STORAGE_TYPE", "webdav")
+
+	_, err := LoadConfig()
+	assert.ErrorContains(t, err, "invalid WEBDAV_* configuration")
+
+	t.Setenv("WEBDAV_URL", "https://artifactory.example.com/artifactory/terraform-cache")
+	t.Setenv("WEBDAV_USERNAME", "cachetf")
+	t.Setenv("WEBDAV_TOKEN", "token")
+	// Basic and bearer authentication can't be combined
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "invalid WEBDAV_* configuration")
+
+	t.Setenv("WEBDAV_USERNAME", "")
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, StorageTypeWebDAV, cfg.StorageType)
+	assert.Equal(t, WebDAVConfig{
+		URL:   "https://artifactory.example.com/artifactory/terraform-cache",
+		Token: "token",
+	}, cfg.WebDAV)
+}
+
+func TestMemoryCacheConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  MemoryCacheConfig
+		wantErr string
+	}{
+		{name: "disabled", config: MemoryCacheConfig{TTL: time.Minute}},
+		{name: "valid", config: MemoryCacheConfig{MaxBytes: 64 << 20, MaxObjectBytes: 1 << 20, TTL: time.Minute}},
+		{name: "negative size", config: MemoryCacheConfig{MaxBytes: -1}, wantErr: "MEMORY_CACHE_MAX_BYTES"},
+		{
+			name:    "object larger than the cache",
+			config:  MemoryCacheConfig{MaxBytes: 1024, MaxObjectBytes: 2048},
+			wantErr: "MEMORY_CACHE_MAX_OBJECT_BYTES",
+		},
+		{name: "negative TTL", config: MemoryCacheConfig{TTL: -time.Second}, wantErr: "MEMORY_CACHE_TTL"},
+		{
+			name:   "preload",
+			config: MemoryCacheConfig{MaxBytes: 64 << 20, MaxObjectBytes: 1 << 20, PreloadIndexes: 100, PreloadSaveInterval: time.Minute},
+		},
+		{name: "negative preload", config: MemoryCacheConfig{PreloadIndexes: -1}, wantErr: "MEMORY_CACHE_PRELOAD_INDEXES"},
+		{
+			name:    "preload without memory cache",
+			config:  MemoryCacheConfig{PreloadIndexes: 100, PreloadSaveInterval: time.Minute},
+			wantErr: "MEMORY_CACHE_PRELOAD_INDEXES requires MEMORY_CACHE_MAX_BYTES",
+		},
+		{
+			name:    "preload without save interval",
+			config:  MemoryCacheConfig{MaxBytes: 64 << 20, MaxObjectBytes: 1 << 20, PreloadIndexes: 100},
+			wantErr: "MEMORY_CACHE_PRELOAD_SAVE_INTERVAL",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_HotCache(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	t.Setenv("METRICS_PORT", "9100")
+	t.Setenv("STORAGE_TYPE", "s3")
+	t.Setenv("S3_BUCKET", "cache")
+	t.Setenv("S3_REGION", "eu-central-1")
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.False(t, cfg.HotCache.Enabled())
+
+	t.Setenv("HOT_CACHE_DIR", "/var/cache/cachetf-hot")
+	t.Setenv("HOT_CACHE_MAX_BYTES", "20GiB")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, HotCacheConfig{
+		Dir:            "/var/cache/cachetf-hot",
+		MaxBytes:       20 << 30,
+		MinObjectBytes: 10 << 20,
+		TTL:            time.Hour,
+	}, cfg.HotCache)
+
+	// The directory is emptied on startup, it can't hold the cache
+	t.Setenv("STORAGE_TYPE", "tiered")
+	t.Setenv("CACHE_DIR", "/var/cache")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "HOT_CACHE_DIR must not overlap the directory of a cache")
+}
+
+func TestHotCacheConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  HotCacheConfig
+		wantErr string
+	}{
+		{name: "disabled", config: HotCacheConfig{MaxBytes: -1}},
+		{name: "valid", config: HotCacheConfig{Dir: "/tmp/hot", MaxBytes: 1 << 30, MinObjectBytes: 10 << 20, TTL: time.Hour}},
+		{name: "no size", config: HotCacheConfig{Dir: "/tmp/hot", MinObjectBytes: 1}, wantErr: "HOT_CACHE_MAX_BYTES"},
+		{
+			name:    "object larger than the cache",
+			config:  HotCacheConfig{Dir: "/tmp/hot", MaxBytes: 1024, MinObjectBytes: 2048},
+			wantErr: "HOT_CACHE_MIN_OBJECT_BYTES",
+		},
+		{
+			name:    "negative TTL",
+			config:  HotCacheConfig{Dir: "/tmp/hot", MaxBytes: 1024, MinObjectBytes: 1, TTL: -time.Second},
+			wantErr: "HOT_CACHE_TTL",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_Scrub(t *testing.T) {
+	t.Setenv("STORAGE_TYPE", "local")
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, ScrubConfig{Action: ScrubQuarantine}, cfg.Scrub)
+
+	t.Setenv("SCRUB_INTERVAL", "24h")
+	t.Setenv("SCRUB_ACTION", "delete")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, ScrubConfig{Interval: 24 * time.Hour, Action: ScrubDelete}, cfg.Scrub)
+
+	t.Setenv("SCRUB_ACTION", "repair")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "invalid SCRUB_ACTION")
+
+	t.Setenv("SCRUB_INTERVAL", "-1h")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "SCRUB_INTERVAL must not be negative")
+}
+
+func TestLoadConfig_Catalog(t *testing.T) {
+	t.Setenv("STORAGE_TYPE", "local")
+	t.Setenv("CACHE_DIR", "/data/cache")
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.False(t, cfg.Catalog.Enabled())
+
+	t.Setenv("CATALOG_PATH", "/data/catalog.db")
+	t.Setenv("CATALOG_REBUILD_INTERVAL", "0")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, CatalogConfig{Path: "/data/catalog.db", SaveInterval: time.Minute}, cfg.Catalog)
+
+	// The catalog would be listed as a cached file
+	t.Setenv("CATALOG_PATH", "/data/cache/catalog.db")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "CATALOG_PATH must not be in the directory of a cache")
+
+	t.Setenv("CATALOG_PATH", "/data/catalog.db")
+	t.Setenv("CATALOG_SAVE_INTERVAL", "0")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "CATALOG_SAVE_INTERVAL must be positive")
+}
+
+func TestLoadConfig_TelemetryIsOptIn(t *testing.T) {
+	t.Setenv("STORAGE_TYPE", "local")
+	t.Setenv("TELEMETRY_ENDPOINT", "https://stats.example.com/report")
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.False(t, cfg.Telemetry.Enabled)
+	assert.Equal(t, 24*time.Hour, cfg.Telemetry.Interval)
+
+	t.Setenv("TELEMETRY_ENABLED", "true")
+	t.Setenv("TELEMETRY_ENDPOINT", "")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "TELEMETRY_ENDPOINT must be an http or https URL")
+}
+
+func TestLoadConfig_StorageStartupTimeout(t *testing.T) {
+	t.Setenv("STORAGE_TYPE", "local")
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Zero(t, cfg.StorageStartupTimeout)
+
+	t.Setenv("STORAGE_STARTUP_TIMEOUT", "5m")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, cfg.StorageStartupTimeout)
+
+	t.Setenv("STORAGE_STARTUP_TIMEOUT", "-1m")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "STORAGE_STARTUP_TIMEOUT must not be negative")
+}
+
+func TestLoadConfig_MetadataGCInterval(t *testing.T) {
+	t.Setenv("STORAGE_TYPE", "local")
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Zero(t, cfg.MetadataGCInterval)
+
+	t.Setenv("METADATA_GC_INTERVAL", "24h")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, 24*time.Hour, cfg.MetadataGCInterval)
+
+	t.Setenv("METADATA_GC_INTERVAL", "-1h")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "METADATA_GC_INTERVAL must not be negative")
+}
+
+func TestLoadConfig_StorageSelfTest(t *testing.T) {
+	t.Setenv("STORAGE_TYPE", "local")
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.False(t, cfg.StorageSelfTest)
+
+	t.Setenv("STORAGE_SELF_TEST", "true")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.True(t, cfg.StorageSelfTest)
+}
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Errors lists every problem found in a configuration, so they can all be fixed at once
+type Errors []error
+
+// Error returns the problems in one message
+func (e Errors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("%d problems: %s", len(e), strings.Join(messages, "; "))
+}
+
+// Unwrap returns the problems, so errors.Is and errors.As match any of them
+func (e Errors) Unwrap() []error {
+	return e
+}
+
+// add records a problem, nil errors are ignored and nested Errors are flattened
+func (e *Errors) add(err error) {
+	switch err := err.(type) {
+	case nil:
+	case Errors:
+		*e = append(*e, err...)
+	default:
+		*e = append(*e, err)
+	}
+}
+
+// err returns the problems as an error, nil if there are none
+func (e Errors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
@@ -0,0 +1,30 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrors(t *testing.T) {
+	var errs Errors
+	assert.NoError(t, errs.err())
+
+	// nil errors are ignored
+	errs.add(nil)
+	assert.NoError(t, errs.err())
+
+	errInvalid := errors.New("invalid PORT")
+	errs.add(errInvalid)
+	assert.EqualError(t, errs.err(), "invalid PORT")
+
+	// Nested problems are flattened, wrapped ones are kept whole
+	errs.add(Errors{errors.New("S3_BUCKET is required"), errors.New("S3_REGION is required")})
+	errs.add(fmt.Errorf("invalid cache dev: %w", Errors{errors.New("a"), errors.New("b")}))
+	assert.Len(t, errs, 4)
+	assert.EqualError(t, errs.err(), "4 problems: invalid PORT; S3_BUCKET is required; S3_REGION is required; "+
+		"invalid cache dev: 2 problems: a; b")
+	assert.ErrorIs(t, fmt.Errorf("invalid configuration: %w", errs.err()), errInvalid)
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"cachetf/internal/upstream"
+)
+
+// RegistryConfig holds how an upstream registry host is reached, e.g. a private registry behind another URL or
+// requiring a token. The settings are read from UPSTREAM_REGISTRY_<NAME>_* variables.
+type RegistryConfig struct {
+	// Name identifies the registry in the UPSTREAM_REGISTRIES list
+	Name  string
+	Host  string `env:"UPSTREAM_REGISTRY_<NAME>_HOST"`
+	URL   string `env:"UPSTREAM_REGISTRY_<NAME>_URL"`
+	Token string `env:"UPSTREAM_REGISTRY_<NAME>_TOKEN"`
+	// ForwardAuth forwards the bearer token of the callers to the registry, for private providers
+	ForwardAuth bool `env:"UPSTREAM_REGISTRY_<NAME>_FORWARD_AUTH"`
+}
+
+// envPrefix returns the prefix of the environment variables of the registry
+func (c *RegistryConfig) envPrefix() string {
+	return "UPSTREAM_REGISTRY_" + strings.ToUpper(c.Name) + "_"
+}
+
+// NewRegistries indexes the configured upstream registries by host
+func (c *UpstreamConfig) NewRegistries() (*upstream.Registries, error) {
+	registries := make([]upstream.Registry, 0, len(c.Registries))
+	for _, registry := range c.Registries {
+		registries = append(registries, upstream.Registry{
+			Host:    registry.Host,
+			BaseURL: registry.URL,
+			Token:   registry.Token,
+			// Callers are checked with the registry before its providers are served
+			ForwardAuthorization: registry.ForwardAuth,
+		})
+	}
+	return upstream.NewRegistries(registries)
+}
+
+// validateRegistries checks the upstream registries listed in UPSTREAM_REGISTRIES
+func (c *UpstreamConfig) validateRegistries() error {
+	var errs Errors
+	names := make(map[string]bool, len(c.Registries))
+	for _, registry := range c.Registries {
+		// Names are part of environment variable names, like the names of the caches
+		if !cacheNamePattern.MatchString(registry.Name) {
+			errs.add(fmt.Errorf("invalid registry name %q in UPSTREAM_REGISTRIES: must be lowercase letters and digits", registry.Name))
+			continue
+		}
+		if names[registry.Name] {
+			errs.add(fmt.Errorf("duplicate registry %q in UPSTREAM_REGISTRIES", registry.Name))
+			continue
+		}
+		names[registry.Name] = true
+		if registry.Host == "" {
+			errs.add(fmt.Errorf("%sHOST is required", registry.envPrefix()))
+		}
+	}
+	if errs.err() != nil {
+		return errs.err()
+	}
+
+	if _, err := c.NewRegistries(); err != nil {
+		return fmt.Errorf("invalid UPSTREAM_REGISTRY_* configuration: %w", err)
+	}
+	return nil
+}
+
+// loadRegistries loads the upstream registries listed in UPSTREAM_REGISTRIES
+func loadRegistries(env *envParser) []RegistryConfig {
+	var registries []RegistryConfig
+	for _, name := range splitList(getEnv("UPSTREAM_REGISTRIES", "")) {
+		registry := RegistryConfig{Name: name}
+		prefix := registry.envPrefix()
+		registry.Host = getEnv(prefix+"HOST", "")
+		registry.URL = getEnv(prefix+"URL", "")
+		registry.Token = getEnv(prefix+"TOKEN", "")
+		registry.ForwardAuth = env.bool(prefix+"FORWARD_AUTH", "false")
+		registries = append(registries, registry)
+	}
+	return registries
+}
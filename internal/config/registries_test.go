@@ -0,0 +1,59 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfig_UpstreamRegistries(t *testing.T) {
+	t.Setenv("STORAGE_TYPE", "local")
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Empty(t, cfg.Upstream.Registries)
+
+	t.Setenv("UPSTREAM_REGISTRIES", "tfc, internal")
+	t.Setenv("UPSTREAM_REGISTRY_TFC_HOST", "app.terraform.io")
+	t.Setenv("UPSTREAM_REGISTRY_TFC_TOKEN", "tfc-token")
+	t.Setenv("UPSTREAM_REGISTRY_TFC_FORWARD_AUTH", "true")
+	t.Setenv("UPSTREAM_REGISTRY_INTERNAL_HOST", "terraform.example.com")
+	t.Setenv("UPSTREAM_REGISTRY_INTERNAL_URL", "https://artifactory.example.com/artifactory/api/terraform/local")
+
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, []RegistryConfig{
+		{Name: "tfc", Host: "app.terraform.io", Token: "tfc-token", ForwardAuth: true},
+		{Name: "internal", Host: "terraform.example.com", URL: "https://artifactory.example.com/artifactory/api/terraform/local"},
+	}, cfg.Upstream.Registries)
+
+	registries, err := cfg.Upstream.NewRegistries()
+	require.NoError(t, err)
+	baseURL, ok := registries.BaseURL("terraform.example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "https://artifactory.example.com/artifactory/api/terraform/local", baseURL)
+	assert.True(t, registries.ForwardsAuthorization("app.terraform.io"))
+	assert.False(t, registries.ForwardsAuthorization("terraform.example.com"))
+}
+
+func TestLoadConfig_InvalidUpstreamRegistries(t *testing.T) {
+	t.Setenv("STORAGE_TYPE", "local")
+
+	t.Setenv("UPSTREAM_REGISTRIES", "tfc,Other")
+	_, err := LoadConfig()
+	assert.ErrorContains(t, err, "UPSTREAM_REGISTRY_TFC_HOST is required")
+	assert.ErrorContains(t, err, `invalid registry name "Other"`)
+
+	t.Setenv("UPSTREAM_REGISTRIES", "tfc")
+	t.Setenv("UPSTREAM_REGISTRY_TFC_HOST", "app.terraform.io")
+	t.Setenv("UPSTREAM_REGISTRY_TFC_URL", "http://app.terraform.io")
+	t.Setenv("UPSTREAM_REGISTRY_TFC_TOKEN", "tfc-token")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "invalid UPSTREAM_REGISTRY_* configuration")
+
+	t.Setenv("UPSTREAM_REGISTRY_TFC_URL", "")
+	t.Setenv("UPSTREAM_REGISTRY_TFC_FORWARD_AUTH", "sometimes")
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "UPSTREAM_REGISTRY_TFC_FORWARD_AUTH")
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dayUnitPattern matches the day and week components time.ParseDuration doesn't support
+var dayUnitPattern = regexp.MustCompile(`(\d+(?:\.\d+)?)([dw])`)
+
+// ParseDuration parses a Go duration such as 72h or 1h30m, also accepting days (d) and weeks (w), e.g. 7d or 1w2d
+func ParseDuration(value string) (time.Duration, error) {
+	expanded := dayUnitPattern.ReplaceAllStringFunc(value, func(component string) string {
+		match := dayUnitPattern.FindStringSubmatch(component)
+		n, _ := strconv.ParseFloat(match[1], 64)
+		hours := n * 24
+		if match[2] == "w" {
+			hours *= 7
+		}
+		return strconv.FormatFloat(hours, 'f', -1, 64) + "h"
+	})
+
+	d, err := time.ParseDuration(expanded)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q: use units such as 15m, 72h or 7d", value)
+	}
+	return d, nil
+}
+
+// sizePattern matches a number followed by an optional unit
+var sizePattern = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*([A-Za-z]*)$`)
+
+// sizeUnits are the multipliers of the size units, decimal and binary
+var sizeUnits = map[string]float64{
+	"":    1,
+	"b":   1,
+	"kb":  1e3,
+	"mb":  1e6,
+	"gb":  1e9,
+	"tb":  1e12,
+	"pb":  1e15,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+	"pib": 1 << 50,
+}
+
+// ParseSize parses a size in bytes, either a plain number or a number with a decimal (KB, MB, GB, TB, PB)
+// or binary (KiB, MiB, GiB, TiB, PiB) unit, e.g. 50GiB or 1.5TB
+func ParseSize(value string) (int64, error) {
+	match := sizePattern.FindStringSubmatch(strings.TrimSpace(value))
+	if match == nil {
+		return 0, fmt.Errorf("invalid size %q: use bytes or units such as 500MB or 50GiB", value)
+	}
+
+	multiplier, ok := sizeUnits[strings.ToLower(match[2])]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: unknown unit %q", value, match[2])
+	}
+
+	// Byte counts are parsed exactly
+	if multiplier == 1 {
+		size, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid size %q: must be a whole number of bytes", value)
+		}
+		return size, nil
+	}
+
+	n, _ := strconv.ParseFloat(match[1], 64)
+	size := math.Round(n * multiplier)
+	if size >= math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size %q: too large", value)
+	}
+	return int64(size), nil
+}
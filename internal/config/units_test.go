@@ -0,0 +1,66 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"0", 0},
+		{"15m", 15 * time.Minute},
+		{"72h", 72 * time.Hour},
+		{"1h30m", 90 * time.Minute},
+		{"7d", 7 * 24 * time.Hour},
+		{"1.5d", 36 * time.Hour},
+		{"1w2d", 9 * 24 * time.Hour},
+		{"1d12h", 36 * time.Hour},
+		{"-1d", -24 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseDuration(tt.value)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	for _, value := range []string{"", "30 days", "5", "1y", "d"} {
+		_, err := ParseDuration(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+	}{
+		{"0", 0},
+		{"10737418240", 10737418240},
+		{"512B", 512},
+		{"500MB", 500_000_000},
+		{"50GiB", 50 << 30},
+		{"50gib", 50 << 30},
+		{"1.5TB", 1_500_000_000_000},
+		{"2 KiB", 2048},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseSize(tt.value)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	for _, value := range []string{"", "-1", "50G", "fifty GiB", "1.5", "9999999PiB"} {
+		_, err := ParseSize(value)
+		assert.Error(t, err, value)
+	}
+}
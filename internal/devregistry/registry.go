@@ -0,0 +1,323 @@
+// Package devregistry serves a small fake provider registry embedded in the binary, so the miss, fill and hit flow
+// of the cache can be exercised without internet access, e.g. while developing or in demos. The registry answers
+// the upstream requests of any host in-process, nothing is sent over the network.
+package devregistry
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/sirupsen/logrus"
+)
+
+//go:embed fixtures/providers.json
+var fixtures []byte
+
+// Platform is an os and architecture the fixture providers are released for
+type Platform struct {
+	OS   string `json:"os"`
+	Arch string `json:"arch"`
+}
+
+// Provider is a fixture provider
+type Provider struct {
+	Namespace string   `json:"namespace"`
+	Name      string   `json:"name"`
+	Versions  []string `json:"versions"`
+}
+
+// fixtureSet is the content of the fixtures file
+type fixtureSet struct {
+	Platforms []Platform `json:"platforms"`
+	Providers []Provider `json:"providers"`
+}
+
+// release holds the files of a provider version
+type release struct {
+	// binaries holds the zip archives by file name
+	binaries  map[string][]byte
+	sums      []byte
+	signature []byte
+}
+
+// Registry is the embedded fake registry. Its provider binaries are tiny zip archives generated on startup, and
+// their SHA256SUMS are signed with a key generated on startup as well, so signature verification passes.
+type Registry struct {
+	fixtures  fixtureSet
+	releases  map[string]*release
+	keyID     string
+	publicKey string
+	logger    *logrus.Logger
+}
+
+// releaseKey returns the key of a provider version in the releases
+func releaseKey(namespace, name, version string) string {
+	return namespace + "/" + name + "/" + version
+}
+
+// filename returns the file name of a provider binary
+func filename(name, version string, platform Platform) string {
+	return fmt.Sprintf("terraform-provider-%s_%s_%s_%s.zip", name, version, platform.OS, platform.Arch)
+}
+
+// New builds the fixture providers and signs their checksums
+func New(logger *logrus.Logger) (*Registry, error) {
+	r := &Registry{
+		releases: make(map[string]*release),
+		logger:   logger,
+	}
+	if err := json.Unmarshal(fixtures, &r.fixtures); err != nil {
+		return nil, fmt.Errorf("failed to read registry fixtures: %w", err)
+	}
+
+	entity, err := openpgp.NewEntity("cachetf development registry", "", "dev@cachetf.invalid", &packet.Config{RSABits: 2048})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	var publicKey bytes.Buffer
+	w, err := armor.Encode(&publicKey, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := entity.Serialize(w); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	r.publicKey = publicKey.String()
+	r.keyID = entity.PrimaryKey.KeyIdString()
+
+	for _, provider := range r.fixtures.Providers {
+		for _, version := range provider.Versions {
+			rel, err := r.build(entity, provider, version)
+			if err != nil {
+				return nil, fmt.Errorf("failed to build %s/%s %s: %w", provider.Namespace, provider.Name, version, err)
+			}
+			r.releases[releaseKey(provider.Namespace, provider.Name, version)] = rel
+		}
+	}
+	return r, nil
+}
+
+// build creates the binaries of a provider version and their signed checksums
+func (r *Registry) build(entity *openpgp.Entity, provider Provider, version string) (*release, error) {
+	rel := &release{binaries: make(map[string][]byte)}
+	var sums strings.Builder
+	names := make([]string, 0, len(r.fixtures.Platforms))
+	for _, platform := range r.fixtures.Platforms {
+		binary, err := buildBinary(provider.Name, version, platform)
+		if err != nil {
+			return nil, err
+		}
+		name := filename(provider.Name, version, platform)
+		rel.binaries[name] = binary
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sum := sha256.Sum256(rel.binaries[name])
+		fmt.Fprintf(&sums, "%s  %s\n", hex.EncodeToString(sum[:]), name)
+	}
+	rel.sums = []byte(sums.String())
+
+	var signature bytes.Buffer
+	if err := openpgp.DetachSign(&signature, entity, bytes.NewReader(rel.sums), nil); err != nil {
+		return nil, err
+	}
+	rel.signature = signature.Bytes()
+	return rel, nil
+}
+
+// buildBinary returns a zip archive holding a script in place of the provider executable. The archive is the
+// same on every start, so its checksum doesn't change.
+func buildBinary(name, version string, platform Platform) ([]byte, error) {
+	executable := fmt.Sprintf("terraform-provider-%s_v%s", name, version)
+	if platform.OS == "windows" {
+		executable += ".exe"
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     executable,
+		Method:   zip.Deflate,
+		Modified: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(w, "#!/bin/sh\necho 'cachetf development provider %s %s (%s_%s)'\n", name, version, platform.OS, platform.Arch)
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Providers returns the fixture providers
+func (r *Registry) Providers() []Provider {
+	return r.fixtures.Providers
+}
+
+// ServeHTTP serves the registry protocol for the fixture providers and their files
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	segments := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	base := "https://" + req.Host
+
+	switch {
+	case req.URL.Path == "/.well-known/terraform.json":
+		writeJSON(w, map[string]string{"providers.v1": "/v1/providers/"})
+	// /v1/providers/:namespace/:name/versions
+	case len(segments) == 5 && segments[0] == "v1" && segments[1] == "providers" && segments[4] == "versions":
+		r.serveVersions(w, segments[2], segments[3])
+	// /v1/providers/:namespace/:name/:version/download/:os/:arch
+	case len(segments) == 8 && segments[0] == "v1" && segments[1] == "providers" && segments[5] == "download":
+		r.serveDownload(w, base, segments[2], segments[3], segments[4], Platform{OS: segments[6], Arch: segments[7]})
+	// /files/:namespace/:name/:version/:filename
+	case len(segments) == 5 && segments[0] == "files":
+		r.serveFile(w, segments[1], segments[2], segments[3], segments[4])
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// provider returns the fixture provider of namespace and name
+func (r *Registry) provider(namespace, name string) (Provider, bool) {
+	for _, provider := range r.fixtures.Providers {
+		if provider.Namespace == namespace && provider.Name == name {
+			return provider, true
+		}
+	}
+	return Provider{}, false
+}
+
+func (r *Registry) serveVersions(w http.ResponseWriter, namespace, name string) {
+	provider, ok := r.provider(namespace, name)
+	if !ok {
+		writeError(w, http.StatusNotFound, "provider not found")
+		return
+	}
+
+	type version struct {
+		Version   string     `json:"version"`
+		Protocols []string   `json:"protocols"`
+		Platforms []Platform `json:"platforms"`
+	}
+	versions := make([]version, 0, len(provider.Versions))
+	for _, v := range provider.Versions {
+		versions = append(versions, version{Version: v, Protocols: []string{"5.0"}, Platforms: r.fixtures.Platforms})
+	}
+	writeJSON(w, map[string]any{"versions": versions})
+}
+
+func (r *Registry) serveDownload(w http.ResponseWriter, base, namespace, name, version string, platform Platform) {
+	rel, ok := r.releases[releaseKey(namespace, name, version)]
+	file := filename(name, version, platform)
+	if !ok || rel.binaries[file] == nil {
+		writeError(w, http.StatusNotFound, "provider version or platform not found")
+		return
+	}
+
+	sum := sha256.Sum256(rel.binaries[file])
+	files := fmt.Sprintf("%s/files/%s/%s/%s/", base, namespace, name, version)
+	sums := fmt.Sprintf("terraform-provider-%s_%s_SHA256SUMS", name, version)
+	writeJSON(w, map[string]any{
+		"protocols":             []string{"5.0"},
+		"os":                    platform.OS,
+		"arch":                  platform.Arch,
+		"filename":              file,
+		"download_url":          files + file,
+		"shasums_url":           files + sums,
+		"shasums_signature_url": files + sums + ".sig",
+		"shasum":                hex.EncodeToString(sum[:]),
+		"signing_keys": map[string]any{
+			"gpg_public_keys": []map[string]string{{
+				"key_id":      r.keyID,
+				"ascii_armor": r.publicKey,
+				"source":      "cachetf",
+			}},
+		},
+	})
+}
+
+func (r *Registry) serveFile(w http.ResponseWriter, namespace, name, version, file string) {
+	rel, ok := r.releases[releaseKey(namespace, name, version)]
+	if !ok {
+		writeError(w, http.StatusNotFound, "file not found")
+		return
+	}
+
+	sums := fmt.Sprintf("terraform-provider-%s_%s_SHA256SUMS", name, version)
+	var data []byte
+	switch file {
+	case sums:
+		data = rel.sums
+	case sums + ".sig":
+		data = rel.signature
+	default:
+		data = rel.binaries[file]
+	}
+	if data == nil {
+		writeError(w, http.StatusNotFound, "file not found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(data)
+}
+
+// Transport returns a transport answering every request from the registry, whatever its host
+func (r *Registry) Transport() http.RoundTripper {
+	return roundTripper{registry: r}
+}
+
+// roundTripper serves requests from the registry in-process
+type roundTripper struct {
+	registry *Registry
+}
+
+func (t roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	t.registry.logger.WithFields(logrus.Fields{
+		"method": req.Method,
+		"url":    req.URL.String(),
+	}).Debug("Serving upstream request from the development registry")
+
+	// The handler sees the host of the URL, like a server behind it would
+	served := req.Clone(req.Context())
+	served.Host = req.URL.Host
+	recorder := httptest.NewRecorder()
+	t.registry.ServeHTTP(recorder, served)
+	resp := recorder.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string][]string{"errors": {message}})
+}
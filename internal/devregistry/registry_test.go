@@ -0,0 +1,158 @@
+package devregistry_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/devregistry"
+	"cachetf/internal/handler"
+	"cachetf/internal/routes"
+	"cachetf/internal/storage"
+	"cachetf/internal/verify"
+)
+
+func newTestRegistry(t *testing.T) *devregistry.Registry {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	r, err := devregistry.New(logger)
+	require.NoError(t, err)
+	return r
+}
+
+// get sends a request through the transport of the registry
+func get(t *testing.T, transport http.RoundTripper, url string) (*http.Response, []byte) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	resp, err := transport.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, body
+}
+
+func TestRegistry_Download(t *testing.T) {
+	transport := newTestRegistry(t).Transport()
+
+	resp, body := get(t, transport, "https://registry.terraform.io/v1/providers/cachetf/hello/versions")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var versions handler.ProviderVersionsResponse
+	require.NoError(t, json.Unmarshal(body, &versions))
+	require.Len(t, versions.Versions, 2)
+	assert.Equal(t, "0.1.0", versions.Versions[0].Version)
+
+	resp, body = get(t, transport, "https://registry.terraform.io/v1/providers/cachetf/hello/0.2.0/download/linux/amd64")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var download handler.DownloadResponse
+	require.NoError(t, json.Unmarshal(body, &download))
+	assert.Equal(t, "terraform-provider-hello_0.2.0_linux_amd64.zip", download.Filename)
+	assert.Equal(t, "https://registry.terraform.io/files/cachetf/hello/0.2.0/"+download.Filename, download.DownloadURL)
+
+	// The checksums are signed by the key of the download response
+	_, sums := get(t, transport, download.SHASumsURL)
+	_, signature := get(t, transport, download.SHASumsSignatureURL)
+	var keys []string
+	for _, key := range download.SigningKeys.GPGPublicKeys {
+		keys = append(keys, key.ASCIIArmor)
+	}
+	verifier, err := verify.NewGPGVerifier("", true)
+	require.NoError(t, err)
+	assert.NoError(t, verifier.Verify(sums, signature, keys, download.Filename, download.SHASum))
+
+	resp, binary := get(t, transport, download.DownloadURL)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/octet-stream", resp.Header.Get("Content-Type"))
+	assert.NotEmpty(t, binary)
+}
+
+func TestRegistry_NotFound(t *testing.T) {
+	transport := newTestRegistry(t).Transport()
+
+	for _, url := range []string{
+		"https://registry.terraform.io/v1/providers/hashicorp/aws/versions",
+		"https://registry.terraform.io/v1/providers/cachetf/hello/9.9.9/download/linux/amd64",
+		"https://registry.terraform.io/v1/providers/cachetf/hello/0.2.0/download/plan9/amd64",
+		"https://registry.terraform.io/files/cachetf/hello/0.2.0/other.zip",
+		"https://registry.terraform.io/unknown",
+	} {
+		resp, _ := get(t, transport, url)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, url)
+	}
+}
+
+func TestRegistry_BinariesAreDeterministic(t *testing.T) {
+	url := "https://registry.terraform.io/v1/providers/cachetf/echo/1.0.0/download/darwin/arm64"
+	_, first := get(t, newTestRegistry(t).Transport(), url)
+	_, second := get(t, newTestRegistry(t).Transport(), url)
+
+	var a, b handler.DownloadResponse
+	require.NoError(t, json.Unmarshal(first, &a))
+	require.NoError(t, json.Unmarshal(second, &b))
+	assert.Equal(t, a.SHASum, b.SHASum)
+}
+
+// countingTransport counts the requests sent upstream
+type countingTransport struct {
+	http.RoundTripper
+	requests atomic.Int64
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests.Add(1)
+	return t.RoundTripper.RoundTrip(req)
+}
+
+func TestRegistry_MissFillHit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	verifier, err := verify.NewGPGVerifier("", true)
+	require.NoError(t, err)
+
+	transport := &countingTransport{RoundTripper: newTestRegistry(t).Transport()}
+	router := gin.New()
+	routes.SetupRoutes(router, &routes.Config{
+		URIPrefix: "/providers",
+		Storage:   storage.NewLocalStorage(t.TempDir(), logger),
+		Registry:  handler.RegistryOptions{Verifier: verifier},
+		Transport: transport,
+	})
+	serve := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		return w
+	}
+
+	w := serve("/providers/registry.terraform.io/cachetf/hello/0.1.0.json")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var version struct {
+		Archives map[string]struct {
+			URL string `json:"url"`
+		} `json:"archives"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &version))
+	archive, ok := version.Archives["linux_amd64"]
+	require.True(t, ok)
+	url := path.Join("/providers/registry.terraform.io/cachetf/hello", archive.URL)
+
+	// The first download fills the cache from the registry, the second one is served from the cache
+	w = serve(url)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	filled := w.Body.Bytes()
+	requests := transport.requests.Load()
+
+	w = serve(url)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, filled, w.Body.Bytes())
+	assert.Equal(t, requests, transport.requests.Load())
+}
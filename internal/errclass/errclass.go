@@ -0,0 +1,145 @@
+// Package errclass classifies storage and upstream errors into a few classes, so failures can be alerted on
+// by kind rather than by their free-text message
+package errclass
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+)
+
+// Class is the kind of an error
+type Class string
+
+const (
+	// Timeout is a request or operation that didn't complete in time
+	Timeout Class = "timeout"
+	// Throttle is a request refused because of rate limiting
+	Throttle Class = "throttle"
+	// Auth is a request refused because of missing or invalid credentials or permissions
+	Auth Class = "auth"
+	// NotFound is a missing file, object or upstream resource
+	NotFound Class = "not_found"
+	// Checksum is content that doesn't match its expected checksum
+	Checksum Class = "checksum"
+	// Canceled is an operation abandoned by the caller, e.g. a client disconnecting
+	Canceled Class = "canceled"
+	// Other is any other error
+	Other Class = "other"
+)
+
+// LogField is the name of the log field holding the class of an error
+const LogField = "errorClass"
+
+// ErrChecksum is wrapped by the errors of content that doesn't match its expected checksum
+var ErrChecksum = errors.New("checksum mismatch")
+
+// StatusError is an unexpected HTTP response status
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d", e.StatusCode)
+}
+
+// HTTPStatus returns the response status code
+func (e *StatusError) HTTPStatus() int {
+	return e.StatusCode
+}
+
+// httpStatusError is implemented by the errors of HTTP responses
+type httpStatusError interface {
+	HTTPStatus() int
+}
+
+// apiError is implemented by the errors of AWS APIs, such as S3
+type apiError interface {
+	ErrorCode() string
+}
+
+// responseError is implemented by the errors of AWS API responses
+type responseError interface {
+	HTTPStatusCode() int
+}
+
+// codes maps AWS API error codes to their class
+var codes = map[string]Class{
+	"RequestTimeout":           Timeout,
+	"RequestTimeoutException":  Timeout,
+	"SlowDown":                 Throttle,
+	"Throttling":               Throttle,
+	"ThrottlingException":      Throttle,
+	"RequestLimitExceeded":     Throttle,
+	"TooManyRequestsException": Throttle,
+	"AccessDenied":             Auth,
+	"AccessDeniedException":    Auth,
+	"InvalidAccessKeyId":       Auth,
+	"SignatureDoesNotMatch":    Auth,
+	"ExpiredToken":             Auth,
+	"InvalidToken":             Auth,
+	"NoSuchKey":                NotFound,
+	"NoSuchBucket":             NotFound,
+	"NotFound":                 NotFound,
+	"BadDigest":                Checksum,
+	"InvalidDigest":            Checksum,
+}
+
+// Of returns the class of err, Other if it isn't recognized
+func Of(err error) Class {
+	if err == nil {
+		return Other
+	}
+
+	switch {
+	case errors.Is(err, ErrChecksum):
+		return Checksum
+	case errors.Is(err, context.Canceled):
+		return Canceled
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return Timeout
+	case errors.Is(err, fs.ErrNotExist):
+		return NotFound
+	}
+
+	var api apiError
+	if errors.As(err, &api) {
+		if class, ok := codes[api.ErrorCode()]; ok {
+			return class
+		}
+	}
+
+	var status httpStatusError
+	if errors.As(err, &status) {
+		return OfStatus(status.HTTPStatus())
+	}
+	var response responseError
+	if errors.As(err, &response) {
+		return OfStatus(response.HTTPStatusCode())
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return Timeout
+	}
+	return Other
+}
+
+// OfStatus returns the class of an HTTP error response status
+func OfStatus(code int) Class {
+	switch code {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusProxyAuthRequired:
+		return Auth
+	case http.StatusNotFound, http.StatusGone:
+		return NotFound
+	case http.StatusTooManyRequests:
+		return Throttle
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return Timeout
+	}
+	return Other
+}
@@ -0,0 +1,52 @@
+package errclass
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAPIError mimics the errors of AWS APIs
+type fakeAPIError struct {
+	code   string
+	status int
+}
+
+func (e *fakeAPIError) Error() string       { return e.code }
+func (e *fakeAPIError) ErrorCode() string   { return e.code }
+func (e *fakeAPIError) HTTPStatusCode() int { return e.status }
+
+func TestOf(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want Class
+	}{
+		{"deadline", fmt.Errorf("failed to fetch: %w", context.DeadlineExceeded), Timeout},
+		{"network timeout", &net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}, Timeout},
+		{"canceled", fmt.Errorf("failed to fetch: %w", context.Canceled), Canceled},
+		{"missing file", fmt.Errorf("failed to open: %w", os.ErrNotExist), NotFound},
+		{"checksum", fmt.Errorf("%w: expected a, got b", ErrChecksum), Checksum},
+		{"too many requests", &StatusError{StatusCode: http.StatusTooManyRequests}, Throttle},
+		{"forbidden", fmt.Errorf("failed: %w", &StatusError{StatusCode: http.StatusForbidden}), Auth},
+		{"not found status", &StatusError{StatusCode: http.StatusNotFound}, NotFound},
+		{"server error", &StatusError{StatusCode: http.StatusInternalServerError}, Other},
+		{"s3 slow down", fmt.Errorf("failed to upload: %w", &fakeAPIError{code: "SlowDown", status: 503}), Throttle},
+		{"s3 access denied", &fakeAPIError{code: "AccessDenied", status: 403}, Auth},
+		{"s3 unknown code", &fakeAPIError{code: "InternalError", status: 401}, Auth},
+		{"plain", errors.New("something broke"), Other},
+		{"nil", nil, Other},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Of(tt.err))
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package eviction
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"cachetf/internal/storage"
+)
+
+// Access records the reads and writes of a file
+type Access struct {
+	// Last is when the file was last read or written
+	Last time.Time
+	// Count is the number of reads and writes
+	Count int64
+}
+
+// AccessTracker wraps a storage backend and records when and how often each file is read or written.
+// Files not accessed since startup have no record.
+type AccessTracker struct {
+	storage.Storage
+	mu       sync.RWMutex
+	accessed map[string]Access
+	// now is replaceable for tests
+	now func() time.Time
+}
+
+// NewAccessTracker creates a new AccessTracker wrapping s
+func NewAccessTracker(s storage.Storage) *AccessTracker {
+	return &AccessTracker{
+		Storage:  s,
+		accessed: make(map[string]Access),
+		now:      time.Now,
+	}
+}
+
+// Access returns the accesses of key through the tracker
+func (t *AccessTracker) Access(key string) (Access, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	access, ok := t.accessed[key]
+	return access, ok
+}
+
+// Get retrieves a file and records the access
+func (t *AccessTracker) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	r, err := t.Storage.Get(ctx, key)
+	if err == nil {
+		t.touch(key)
+	}
+	return r, err
+}
+
+// Put stores a file and records the access
+func (t *AccessTracker) Put(ctx context.Context, key string, r io.Reader) error {
+	err := t.Storage.Put(ctx, key, r)
+	if err == nil {
+		t.touch(key)
+	}
+	return err
+}
+
+// Delete deletes a file and forgets its access time
+func (t *AccessTracker) Delete(ctx context.Context, key string) error {
+	err := t.Storage.Delete(ctx, key)
+	t.mu.Lock()
+	delete(t.accessed, key)
+	t.mu.Unlock()
+	return err
+}
+
+// DeleteByPrefix deletes the files with the given prefix and forgets their access times
+func (t *AccessTracker) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
+	count, err := t.Storage.DeleteByPrefix(ctx, prefix)
+	t.mu.Lock()
+	for key := range t.accessed {
+		if strings.HasPrefix(key, prefix) {
+			delete(t.accessed, key)
+		}
+	}
+	t.mu.Unlock()
+	return count, err
+}
+
+func (t *AccessTracker) touch(key string) {
+	now := t.now()
+	t.mu.Lock()
+	access := t.accessed[key]
+	access.Last = now
+	access.Count++
+	t.accessed[key] = access
+	t.mu.Unlock()
+}
@@ -0,0 +1,45 @@
+package eviction
+
+import (
+	"path"
+	"strings"
+
+	"cachetf/internal/layout"
+	"cachetf/internal/metrics"
+)
+
+// coverage counts the providers, provider versions and artifacts of the cache while it's listed, so dashboards can
+// follow how much of the upstream registries it mirrors
+type coverage struct {
+	providers map[string]struct{}
+	versions  map[string]struct{}
+	artifacts int
+}
+
+func newCoverage() *coverage {
+	return &coverage{
+		providers: make(map[string]struct{}),
+		versions:  make(map[string]struct{}),
+	}
+}
+
+// add counts a cached file
+func (c *coverage) add(key string) {
+	if provider, version, filename, ok := layout.ProviderFileOf(key); ok {
+		c.providers[provider] = struct{}{}
+		c.versions[provider+version] = struct{}{}
+		if strings.HasSuffix(filename, ".zip") {
+			c.artifacts++
+		}
+		return
+	}
+	// Module archives are stored next to their download descriptor
+	if scheme, ok := layout.SchemeOf(key); ok && scheme == layout.Modules && strings.HasPrefix(path.Base(key), "archive.") {
+		c.artifacts++
+	}
+}
+
+// report sets the coverage gauges
+func (c *coverage) report(m *metrics.CacheMetrics) {
+	m.SetCoverage(len(c.providers), len(c.versions), c.artifacts)
+}
@@ -0,0 +1,33 @@
+package eviction
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"cachetf/internal/metrics"
+)
+
+func TestCoverage(t *testing.T) {
+	c := newCoverage()
+	for _, key := range []string{
+		"providers/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip",
+		"providers/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_darwin_arm64.zip",
+		"providers/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_SHA256SUMS",
+		"providers/registry.terraform.io/hashicorp/aws/5.1.0/terraform-provider-aws_5.1.0_SHA256SUMS",
+		"providers/_tenants/acme/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip",
+		"providers/registry.terraform.io/hashicorp/random/3.7.2/terraform-provider-random_3.7.2_linux_amd64.zip",
+		"modules/hashicorp/consul/aws/0.1.0/archive.tar.gz",
+		"modules/hashicorp/consul/aws/0.1.0/download.json",
+		"providers/a.zip",
+	} {
+		c.add(key)
+	}
+	c.report(metrics.NewCacheMetrics())
+
+	// The providers of tenants are counted apart, checksums count their version but aren't artifacts
+	assert.Equal(t, float64(3), testutil.ToFloat64(metrics.CachedProviders))
+	assert.Equal(t, float64(4), testutil.ToFloat64(metrics.CachedVersions))
+	assert.Equal(t, float64(5), testutil.ToFloat64(metrics.CachedArtifacts))
+}
@@ -0,0 +1,234 @@
+package eviction
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/layout"
+	"cachetf/internal/metadata"
+	"cachetf/internal/metrics"
+	"cachetf/internal/storage"
+)
+
+// AccessRecorder is a storage recording the accesses of its files, such as AccessTracker
+type AccessRecorder interface {
+	storage.Storage
+	// Access returns the accesses of key, false if none were recorded
+	Access(key string) (Access, bool)
+}
+
+// Evictor periodically evicts files selected by a Policy once the cache grows beyond a size limit
+type Evictor struct {
+	tracker  AccessRecorder
+	policy   Policy
+	maxSize  int64
+	interval time.Duration
+	logger   *logrus.Logger
+	metrics  *metrics.CacheMetrics
+	// protector keeps pinned files, if set
+	protector Protector
+	// now is replaceable for tests
+	now func() time.Time
+	// mu serializes the evictions, periodic and reclaiming disk space
+	mu sync.Mutex
+}
+
+// NewEvictor creates a new Evictor keeping the files behind tracker under maxSize bytes, checking every interval
+func NewEvictor(tracker AccessRecorder, policy Policy, maxSize int64, interval time.Duration, logger *logrus.Logger) *Evictor {
+	return &Evictor{
+		tracker:  tracker,
+		policy:   policy,
+		maxSize:  maxSize,
+		interval: interval,
+		logger:   logger,
+		metrics:  metrics.NewCacheMetrics(),
+		now:      time.Now,
+	}
+}
+
+// Protect makes the evictor keep the files p protects. They still count towards the cache size.
+func (e *Evictor) Protect(p Protector) {
+	e.protector = p
+}
+
+// Run checks the cache size every interval until ctx is cancelled
+func (e *Evictor) Run(ctx context.Context) {
+	e.logger.WithFields(logrus.Fields{
+		"policy":   e.policy.Name(),
+		"maxSize":  e.maxSize,
+		"interval": e.interval,
+	}).Info("Cache eviction enabled")
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := e.Evict(ctx); err != nil && ctx.Err() == nil {
+			e.logger.WithError(err).Error("Cache eviction failed")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Evict deletes the files selected by the policy and returns how many were evicted
+func (e *Evictor) Evict(ctx context.Context) (int, error) {
+	return e.evict(ctx, 0)
+}
+
+// Reclaim evicts the files selected by the policy until the cache is at least bytes smaller, or under its size
+// limit if that frees more, so a write finding the disk full can proceed
+func (e *Evictor) Reclaim(ctx context.Context, bytes int64) error {
+	_, err := e.evict(ctx, bytes)
+	return err
+}
+
+// evict deletes the files selected by the policy to bring the cache under its size limit, and reclaim bytes
+func (e *Evictor) evict(ctx context.Context, reclaim int64) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var entries []Entry
+	var size int64
+	coverage := newCoverage()
+	// The files of a module version are evicted as a unit, the archive is only served through its descriptor
+	units := make(map[string]*Entry)
+	protectedUnits := make(map[string]bool)
+
+	err := storage.Walk(ctx, e.tracker, "", func(obj storage.ObjectInfo) error {
+		// Internal documents are small and can't be fetched again, so they're never evicted
+		if strings.HasPrefix(obj.Key, metadata.KeyPrefix) {
+			return nil
+		}
+		coverage.add(obj.Key)
+
+		entry := Entry{
+			Key:        obj.Key,
+			Size:       obj.Size,
+			Modified:   obj.LastModified,
+			LastAccess: obj.LastModified,
+		}
+		if access, ok := e.tracker.Access(obj.Key); ok {
+			entry.LastAccess = access.Last
+			entry.Accesses = access.Count
+		}
+		size += obj.Size
+
+		// Protected files count towards the size but are never selected
+		protected := e.protector != nil && e.protector.Protected(obj.Key)
+		if prefix, ok := moduleVersionOf(obj.Key); ok {
+			unit, found := units[prefix]
+			if !found {
+				unit = &Entry{Key: prefix}
+				units[prefix] = unit
+			}
+			unit.add(entry)
+			protectedUnits[prefix] = protectedUnits[prefix] || protected
+			return nil
+		}
+		if !protected {
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for prefix, unit := range units {
+		if !protectedUnits[prefix] {
+			entries = append(entries, *unit)
+		}
+	}
+
+	e.metrics.SetSize(size)
+	// The files evicted below are still counted until the next run
+	coverage.report(e.metrics)
+
+	target := min(e.maxSize, size-reclaim)
+	evicted := 0
+	for _, entry := range e.policy.Select(entries, size, target, e.now()) {
+		if ctx.Err() != nil {
+			break
+		}
+
+		deleted, err := e.delete(ctx, entry.Key)
+		if err != nil {
+			// Deleted concurrently, it doesn't count towards the size anymore
+			if errors.Is(err, os.ErrNotExist) {
+				size -= entry.Size
+				continue
+			}
+			e.logger.WithError(err).WithField("key", entry.Key).Warn("Failed to evict file")
+			continue
+		}
+
+		size -= entry.Size
+		evicted += deleted
+		e.logger.WithFields(logrus.Fields{
+			"key":        entry.Key,
+			"size":       entry.Size,
+			"lastAccess": entry.LastAccess,
+			"accesses":   entry.Accesses,
+		}).Debug("Evicted file")
+	}
+
+	if evicted > 0 {
+		e.metrics.RecordEviction(evicted)
+	}
+	e.metrics.SetSize(size)
+	e.logger.WithFields(logrus.Fields{
+		"policy":  e.policy.Name(),
+		"evicted": evicted,
+		"size":    size,
+		"maxSize": e.maxSize,
+	}).Info("Cache eviction finished")
+
+	return evicted, ctx.Err()
+}
+
+// delete evicts the file at key, or the files of the module version if key is its prefix. It returns the number
+// of files deleted.
+func (e *Evictor) delete(ctx context.Context, key string) (int, error) {
+	if !strings.HasSuffix(key, "/") {
+		if err := e.tracker.Delete(ctx, key); err != nil {
+			return 0, err
+		}
+		return 1, nil
+	}
+	deleted, err := e.tracker.DeleteByPrefix(ctx, key)
+	if err == nil && deleted == 0 {
+		err = os.ErrNotExist
+	}
+	return deleted, err
+}
+
+// moduleVersionOf returns the prefix of the module version a key belongs to, the files of a version are stored
+// next to each other, in any layout
+func moduleVersionOf(key string) (string, bool) {
+	if scheme, ok := layout.SchemeOf(key); !ok || scheme != layout.Modules {
+		return "", false
+	}
+	return key[:strings.LastIndex(key, "/")+1], true
+}
+
+// add accounts for a file of a unit evicted as a whole, which is as recent as its most recent file
+func (u *Entry) add(file Entry) {
+	u.Size += file.Size
+	u.Accesses += file.Accesses
+	if file.Modified.After(u.Modified) {
+		u.Modified = file.Modified
+	}
+	if file.LastAccess.After(u.LastAccess) {
+		u.LastAccess = file.LastAccess
+	}
+}
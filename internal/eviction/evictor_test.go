@@ -0,0 +1,229 @@
+package eviction
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/metrics"
+	"cachetf/internal/storage"
+)
+
+func TestEvictor_Evict(t *testing.T) {
+	ctx := context.Background()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	dir := t.TempDir()
+	tracker := NewAccessTracker(storage.NewLocalStorage(dir, logger))
+
+	// Four 100 byte files written an hour apart, plus an internal document
+	now := time.Now()
+	keys := []string{
+		"providers/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip",
+		"providers/registry.terraform.io/hashicorp/aws/5.1.0/terraform-provider-aws_5.1.0_linux_amd64.zip",
+		"providers/registry.terraform.io/hashicorp/aws/5.2.0/terraform-provider-aws_5.2.0_linux_amd64.zip",
+		"modules/hashicorp/consul/aws/0.1.0/archive.zip",
+	}
+	for i, key := range keys {
+		modTime := now.Add(time.Duration(i-len(keys)) * time.Hour)
+		tracker.now = func() time.Time { return modTime }
+		require.NoError(t, tracker.Put(ctx, key, strings.NewReader(strings.Repeat("x", 100))))
+		require.NoError(t, os.Chtimes(filepath.Join(dir, key), modTime, modTime))
+	}
+	require.NoError(t, tracker.Put(ctx, "metadata/auth/keys.json", bytes.NewReader(make([]byte, 500))))
+
+	// Reading the oldest file makes it the most recently used
+	tracker.now = func() time.Time { return now }
+	r, err := tracker.Get(ctx, keys[0])
+	require.NoError(t, err)
+	r.Close()
+
+	policy, err := NewPolicy("lru", PolicyOptions{})
+	require.NoError(t, err)
+	evictor := NewEvictor(tracker, policy, 250, time.Minute, logger)
+
+	before := testutil.ToFloat64(metrics.CacheEvictionsTotal)
+	evicted, err := evictor.Evict(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, evicted)
+	assert.Equal(t, before+2, testutil.ToFloat64(metrics.CacheEvictionsTotal))
+	assert.Equal(t, float64(200), testutil.ToFloat64(metrics.CacheSizeBytes))
+
+	// The two least recently used files were evicted, internal documents don't count
+	expected := map[string]bool{
+		keys[0]:                   true,
+		keys[1]:                   false,
+		keys[2]:                   false,
+		keys[3]:                   true,
+		"metadata/auth/keys.json": true,
+	}
+	for key, exists := range expected {
+		ok, err := tracker.Exists(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, exists, ok, key)
+	}
+
+	_, tracked := tracker.Access(keys[1])
+	assert.False(t, tracked, "Evicted files should be forgotten")
+
+	// Under the limit, nothing is evicted
+	evicted, err = evictor.Evict(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, evicted)
+}
+
+func TestAccessTracker(t *testing.T) {
+	ctx := context.Background()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	tracker := NewAccessTracker(storage.NewLocalStorage(t.TempDir(), logger))
+	now := time.Now()
+	tracker.now = func() time.Time { return now }
+
+	// Misses aren't accesses
+	_, err := tracker.Get(ctx, "providers/registry.terraform.io/a/b/1.0.0/file.zip")
+	require.Error(t, err)
+	_, ok := tracker.Access("providers/registry.terraform.io/a/b/1.0.0/file.zip")
+	assert.False(t, ok)
+
+	require.NoError(t, tracker.Put(ctx, "providers/registry.terraform.io/a/b/1.0.0/file.zip", strings.NewReader("data")))
+	access, ok := tracker.Access("providers/registry.terraform.io/a/b/1.0.0/file.zip")
+	require.True(t, ok)
+	assert.Equal(t, Access{Last: now, Count: 1}, access)
+
+	later := now.Add(time.Minute)
+	tracker.now = func() time.Time { return later }
+	r, err := tracker.Get(ctx, "providers/registry.terraform.io/a/b/1.0.0/file.zip")
+	require.NoError(t, err)
+	r.Close()
+	access, _ = tracker.Access("providers/registry.terraform.io/a/b/1.0.0/file.zip")
+	assert.Equal(t, Access{Last: later, Count: 2}, access)
+
+	_, err = tracker.DeleteByPrefix(ctx, "providers/registry.terraform.io/a")
+	require.NoError(t, err)
+	_, ok = tracker.Access("providers/registry.terraform.io/a/b/1.0.0/file.zip")
+	assert.False(t, ok)
+}
+
+// keyProtector protects a fixed set of keys
+type keyProtector map[string]bool
+
+func (p keyProtector) Protected(key string) bool {
+	return p[key]
+}
+
+func TestEvictor_Protect(t *testing.T) {
+	ctx := context.Background()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	tracker := NewAccessTracker(storage.NewLocalStorage(t.TempDir(), logger))
+	keys := []string{
+		"providers/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip",
+		"providers/registry.terraform.io/hashicorp/aws/5.1.0/terraform-provider-aws_5.1.0_linux_amd64.zip",
+	}
+	now := time.Now()
+	for i, key := range keys {
+		tracker.now = func() time.Time { return now.Add(time.Duration(i) * time.Minute) }
+		require.NoError(t, tracker.Put(ctx, key, strings.NewReader(strings.Repeat("x", 100))))
+	}
+
+	// The least recently used file is pinned, so the other one goes even though the cache still exceeds the limit
+	policy, err := NewPolicy("lru", PolicyOptions{})
+	require.NoError(t, err)
+	evictor := NewEvictor(tracker, policy, 50, time.Minute, logger)
+	evictor.Protect(keyProtector{keys[0]: true})
+
+	evicted, err := evictor.Evict(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, evicted)
+
+	exists, err := tracker.Exists(ctx, keys[0])
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestEvictor_ModuleVersions(t *testing.T) {
+	ctx := context.Background()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	tracker := NewAccessTracker(storage.NewLocalStorage(t.TempDir(), logger))
+	keys := []string{
+		"modules/hashicorp/consul/aws/0.1.0/archive.tar.gz",
+		"providers/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip",
+		"modules/hashicorp/consul/aws/0.1.0/download.json",
+	}
+	now := time.Now()
+	for i, key := range keys {
+		tracker.now = func() time.Time { return now.Add(time.Duration(i) * time.Minute) }
+		require.NoError(t, tracker.Put(ctx, key, strings.NewReader(strings.Repeat("x", 100))))
+	}
+
+	// The archive is the least recently used file, but the version is as recent as its descriptor
+	policy, err := NewPolicy("lru", PolicyOptions{})
+	require.NoError(t, err)
+	evictor := NewEvictor(tracker, policy, 250, time.Minute, logger)
+
+	evicted, err := evictor.Evict(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, evicted)
+	for i, exists := range []bool{true, false, true} {
+		ok, err := tracker.Exists(ctx, keys[i])
+		require.NoError(t, err)
+		assert.Equal(t, exists, ok, keys[i])
+	}
+
+	// The archive and its descriptor are evicted together
+	evictor = NewEvictor(tracker, policy, 150, time.Minute, logger)
+	evicted, err = evictor.Evict(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, evicted)
+	for _, key := range keys {
+		exists, err := tracker.Exists(ctx, key)
+		require.NoError(t, err)
+		assert.False(t, exists, key)
+	}
+}
+
+func TestEvictor_Reclaim(t *testing.T) {
+	ctx := context.Background()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	tracker := NewAccessTracker(storage.NewLocalStorage(t.TempDir(), logger))
+	keys := []string{
+		"providers/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip",
+		"providers/registry.terraform.io/hashicorp/aws/5.1.0/terraform-provider-aws_5.1.0_linux_amd64.zip",
+		"providers/registry.terraform.io/hashicorp/aws/5.2.0/terraform-provider-aws_5.2.0_linux_amd64.zip",
+	}
+	now := time.Now()
+	for i, key := range keys {
+		tracker.now = func() time.Time { return now.Add(time.Duration(i) * time.Minute) }
+		require.NoError(t, tracker.Put(ctx, key, strings.NewReader(strings.Repeat("x", 100))))
+	}
+
+	// The cache is under its limit, but the disk is full: the least recently used files go until enough is freed
+	policy, err := NewPolicy("lru", PolicyOptions{})
+	require.NoError(t, err)
+	evictor := NewEvictor(tracker, policy, 1000, time.Minute, logger)
+	require.NoError(t, evictor.Reclaim(ctx, 150))
+
+	for i, exists := range []bool{false, false, true} {
+		ok, err := tracker.Exists(ctx, keys[i])
+		require.NoError(t, err)
+		assert.Equal(t, exists, ok, keys[i])
+	}
+}
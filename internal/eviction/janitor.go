@@ -0,0 +1,125 @@
+package eviction
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/layout"
+	"cachetf/internal/metrics"
+	"cachetf/internal/storage"
+)
+
+// Protector decides which files must never be evicted
+type Protector interface {
+	// Protected returns true if the file must be kept
+	Protected(key string) bool
+}
+
+// Janitor periodically evicts cached provider binaries older than a TTL
+type Janitor struct {
+	storage  storage.Storage
+	ttl      time.Duration
+	interval time.Duration
+	logger   *logrus.Logger
+	metrics  *metrics.CacheMetrics
+	// keys is the layout of the swept files
+	keys layout.Strategy
+	// protector keeps pinned files, if set
+	protector Protector
+	// now is replaceable for tests
+	now func() time.Time
+}
+
+// NewJanitor creates a new Janitor evicting files older than ttl every interval
+func NewJanitor(storage storage.Storage, ttl, interval time.Duration, logger *logrus.Logger) *Janitor {
+	return &Janitor{
+		storage:  storage,
+		ttl:      ttl,
+		interval: interval,
+		logger:   logger,
+		metrics:  metrics.NewCacheMetrics(),
+		keys:     layout.Default,
+		now:      time.Now,
+	}
+}
+
+// Protect makes the janitor keep the files p protects
+func (j *Janitor) Protect(p Protector) {
+	j.protector = p
+}
+
+// UseKeys makes the janitor sweep the provider binaries of the given layout
+func (j *Janitor) UseKeys(keys layout.Strategy) {
+	j.keys = keys
+}
+
+// Run sweeps the cache every interval until ctx is cancelled
+func (j *Janitor) Run(ctx context.Context) {
+	j.logger.WithFields(logrus.Fields{
+		"ttl":      j.ttl,
+		"interval": j.interval,
+	}).Info("Cache expiration enabled")
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := j.Sweep(ctx); err != nil && ctx.Err() == nil {
+			j.logger.WithError(err).Error("Cache expiration sweep failed")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep evicts the expired files once and returns how many were evicted
+func (j *Janitor) Sweep(ctx context.Context) (int, error) {
+	cutoff := j.now().Add(-j.ttl)
+	expired := 0
+
+	err := storage.Walk(ctx, j.storage, j.keys.Prefix(layout.Providers), func(obj storage.ObjectInfo) error {
+		if !isProviderBinary(obj.Key) || !obj.LastModified.Before(cutoff) {
+			return nil
+		}
+		if j.protector != nil && j.protector.Protected(obj.Key) {
+			return nil
+		}
+
+		if err := j.storage.Delete(ctx, obj.Key); err != nil {
+			// Another instance may have evicted it already
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			j.logger.WithError(err).WithField("key", obj.Key).Warn("Failed to evict expired file")
+			return nil
+		}
+
+		expired++
+		j.logger.WithFields(logrus.Fields{
+			"key":          obj.Key,
+			"lastModified": obj.LastModified,
+		}).Debug("Evicted expired file")
+		return nil
+	})
+
+	if expired > 0 {
+		j.metrics.RecordExpiration(expired)
+	}
+	j.logger.WithField("expired", expired).Info("Cache expiration sweep finished")
+
+	return expired, err
+}
+
+// isProviderBinary returns true for the keys of cached provider zips
+func isProviderBinary(key string) bool {
+	return strings.HasPrefix(key, layout.Providers.Prefix()) && strings.HasSuffix(key, ".zip")
+}
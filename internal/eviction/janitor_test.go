@@ -0,0 +1,137 @@
+package eviction
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/layout"
+	"cachetf/internal/metrics"
+	"cachetf/internal/storage"
+)
+
+func TestJanitor_Sweep(t *testing.T) {
+	ctx := context.Background()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	dir := t.TempDir()
+	store := storage.NewLocalStorage(dir, logger)
+
+	now := time.Now()
+	files := map[string]time.Duration{
+		"providers/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip": -48 * time.Hour,
+		"providers/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_SHA256SUMS":      -48 * time.Hour,
+		"providers/registry.terraform.io/hashicorp/aws/5.1.0/terraform-provider-aws_5.1.0_linux_amd64.zip": -time.Hour,
+		"modules/hashicorp/consul/aws/0.1.0/archive.zip":                                                   -48 * time.Hour,
+		"metadata/auth/keys.json": -48 * time.Hour,
+	}
+	for key, age := range files {
+		require.NoError(t, store.Put(ctx, key, bytes.NewReader([]byte(key))))
+		modTime := now.Add(age)
+		require.NoError(t, os.Chtimes(filepath.Join(dir, key), modTime, modTime))
+	}
+
+	janitor := NewJanitor(store, 24*time.Hour, time.Hour, logger)
+	janitor.now = func() time.Time { return now }
+
+	before := testutil.ToFloat64(metrics.CacheExpirationsTotal)
+	expired, err := janitor.Sweep(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, expired)
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.CacheExpirationsTotal))
+
+	// Only the old provider binary was evicted
+	for key := range files {
+		exists, err := store.Exists(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, key != "providers/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip", exists, key)
+	}
+
+	// Sweeping again is a no-op
+	expired, err = janitor.Sweep(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, expired)
+}
+
+func TestJanitor_Protect(t *testing.T) {
+	ctx := context.Background()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	dir := t.TempDir()
+	store := storage.NewLocalStorage(dir, logger)
+
+	key := "providers/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip"
+	require.NoError(t, store.Put(ctx, key, bytes.NewReader([]byte(key))))
+	modTime := time.Now().Add(-48 * time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, key), modTime, modTime))
+
+	// Pinned binaries never expire
+	janitor := NewJanitor(store, 24*time.Hour, time.Hour, logger)
+	janitor.Protect(keyProtector{key: true})
+
+	expired, err := janitor.Sweep(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, expired)
+}
+
+func TestJanitor_UseKeys(t *testing.T) {
+	ctx := context.Background()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	dir := t.TempDir()
+	store := storage.NewLocalStorage(dir, logger)
+
+	// The binaries of other tenants sharing the storage are left alone
+	ours := "providers/_tenants/acme/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip"
+	theirs := "providers/_tenants/other/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip"
+	modTime := time.Now().Add(-48 * time.Hour)
+	for _, key := range []string{ours, theirs} {
+		require.NoError(t, store.Put(ctx, key, bytes.NewReader([]byte(key))))
+		require.NoError(t, os.Chtimes(filepath.Join(dir, key), modTime, modTime))
+	}
+
+	keys, err := layout.NewTenantStrategy("acme")
+	require.NoError(t, err)
+	janitor := NewJanitor(store, 24*time.Hour, time.Hour, logger)
+	janitor.UseKeys(keys)
+
+	expired, err := janitor.Sweep(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, expired)
+	exists, err := store.Exists(ctx, theirs)
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestJanitor_Run(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	janitor := NewJanitor(storage.NewLocalStorage(t.TempDir(), logger), time.Hour, time.Millisecond, logger)
+
+	done := make(chan struct{})
+	go func() {
+		janitor.Run(ctx)
+		close(done)
+	}()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run should return when the context is cancelled")
+	}
+}
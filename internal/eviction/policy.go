@@ -0,0 +1,144 @@
+package eviction
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Entry is a cached file considered for eviction
+type Entry struct {
+	Key  string
+	Size int64
+	// Modified is when the file was written
+	Modified time.Time
+	// LastAccess is when the file was last read or written, Modified if it wasn't accessed since startup
+	LastAccess time.Time
+	// Accesses is the number of times the file was read or written since startup
+	Accesses int64
+}
+
+// Policy decides which files are evicted when the cache exceeds its size limit
+type Policy interface {
+	// Name returns the name the policy is configured with
+	Name() string
+	// Select returns the entries to evict, in order, given the current cache size and its limit
+	Select(entries []Entry, size, maxSize int64, now time.Time) []Entry
+}
+
+// PolicyOptions holds the settings policies may use
+type PolicyOptions struct {
+	// TTL is the age after which the ttl policy evicts files
+	TTL time.Duration
+}
+
+// policies maps the policy names to their constructors. New strategies are added here.
+var policies = map[string]func(PolicyOptions) (Policy, error){
+	"lru": func(PolicyOptions) (Policy, error) {
+		return &rankedPolicy{name: "lru", less: func(a, b Entry) bool { return a.LastAccess.Before(b.LastAccess) }}, nil
+	},
+	"lfu": func(PolicyOptions) (Policy, error) {
+		return &rankedPolicy{name: "lfu", less: func(a, b Entry) bool {
+			// Ties are broken by recency, so files nobody asked for since startup go first
+			if a.Accesses != b.Accesses {
+				return a.Accesses < b.Accesses
+			}
+			return a.LastAccess.Before(b.LastAccess)
+		}}, nil
+	},
+	"fifo": func(PolicyOptions) (Policy, error) {
+		return &rankedPolicy{name: "fifo", less: byModified}, nil
+	},
+	"ttl": func(opts PolicyOptions) (Policy, error) {
+		if opts.TTL <= 0 {
+			return nil, fmt.Errorf("the ttl eviction policy requires a positive TTL")
+		}
+		return &ttlPolicy{ttl: opts.TTL}, nil
+	},
+}
+
+// NewPolicy returns the policy with the given name
+func NewPolicy(name string, opts PolicyOptions) (Policy, error) {
+	newPolicy, ok := policies[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown eviction policy: %s", name)
+	}
+	return newPolicy(opts)
+}
+
+// PolicyNames returns the names of the available policies, sorted
+func PolicyNames() []string {
+	names := make([]string, 0, len(policies))
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// rankedPolicy evicts the entries in the order defined by less until the cache fits
+type rankedPolicy struct {
+	name string
+	less func(a, b Entry) bool
+}
+
+func (p *rankedPolicy) Name() string {
+	return p.name
+}
+
+func (p *rankedPolicy) Select(entries []Entry, size, maxSize int64, _ time.Time) []Entry {
+	return selectUntilFits(entries, size, maxSize, p.less)
+}
+
+// ttlPolicy evicts the entries older than the TTL, then the oldest ones until the cache fits
+type ttlPolicy struct {
+	ttl time.Duration
+}
+
+func (p *ttlPolicy) Name() string {
+	return "ttl"
+}
+
+func (p *ttlPolicy) Select(entries []Entry, size, maxSize int64, now time.Time) []Entry {
+	cutoff := now.Add(-p.ttl)
+
+	var selected, remaining []Entry
+	for _, entry := range entries {
+		if entry.Modified.Before(cutoff) {
+			selected = append(selected, entry)
+			size -= entry.Size
+		} else {
+			remaining = append(remaining, entry)
+		}
+	}
+
+	return append(selected, selectUntilFits(remaining, size, maxSize, byModified)...)
+}
+
+// byModified orders entries oldest first
+func byModified(a, b Entry) bool {
+	return a.Modified.Before(b.Modified)
+}
+
+// selectUntilFits sorts the entries with less and returns the first ones whose eviction brings size under maxSize
+func selectUntilFits(entries []Entry, size, maxSize int64, less func(a, b Entry) bool) []Entry {
+	if size <= maxSize {
+		return nil
+	}
+
+	sorted := append([]Entry(nil), entries...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return less(sorted[i], sorted[j])
+	})
+
+	var selected []Entry
+	for _, entry := range sorted {
+		if size <= maxSize {
+			break
+		}
+		selected = append(selected, entry)
+		size -= entry.Size
+	}
+	return selected
+}
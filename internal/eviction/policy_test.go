@@ -0,0 +1,59 @@
+package eviction
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicies(t *testing.T) {
+	now := time.Now()
+	hoursAgo := func(h int) time.Time { return now.Add(-time.Duration(h) * time.Hour) }
+
+	// Written oldest first, a is popular but hasn't been read lately, c was read recently once
+	entries := []Entry{
+		{Key: "a", Size: 100, Modified: hoursAgo(72), LastAccess: hoursAgo(5), Accesses: 50},
+		{Key: "b", Size: 100, Modified: hoursAgo(48), LastAccess: hoursAgo(48), Accesses: 1},
+		{Key: "c", Size: 100, Modified: hoursAgo(24), LastAccess: hoursAgo(1), Accesses: 2},
+		{Key: "d", Size: 100, Modified: hoursAgo(2), LastAccess: hoursAgo(2), Accesses: 1},
+	}
+
+	tests := []struct {
+		policy   string
+		maxSize  int64
+		expected []string
+	}{
+		{"lru", 400, nil},
+		{"lru", 250, []string{"b", "a"}},
+		{"lfu", 250, []string{"b", "d"}},
+		{"fifo", 250, []string{"a", "b"}},
+		{"ttl", 400, []string{"a", "b"}},
+		{"ttl", 150, []string{"a", "b", "c"}},
+		{"LRU", 350, []string{"b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			policy, err := NewPolicy(tt.policy, PolicyOptions{TTL: 36 * time.Hour})
+			require.NoError(t, err)
+
+			var keys []string
+			for _, entry := range policy.Select(entries, 400, tt.maxSize, now) {
+				keys = append(keys, entry.Key)
+			}
+			assert.Equal(t, tt.expected, keys)
+		})
+	}
+}
+
+func TestNewPolicy(t *testing.T) {
+	assert.Equal(t, []string{"fifo", "lfu", "lru", "ttl"}, PolicyNames())
+
+	_, err := NewPolicy("random", PolicyOptions{})
+	assert.ErrorContains(t, err, "unknown eviction policy")
+
+	_, err = NewPolicy("ttl", PolicyOptions{})
+	assert.ErrorContains(t, err, "requires a positive TTL")
+}
@@ -0,0 +1,87 @@
+package eviction
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/metadata"
+	"cachetf/internal/metrics"
+	"cachetf/internal/storage"
+)
+
+// Sizer measures the size of the cache in the background, so cache_size_bytes reports the files cached by previous
+// processes and by other instances sharing the storage, not only the ones written since startup. The Evictor
+// measures the size itself when the cache has a size limit.
+type Sizer struct {
+	storage  storage.Storage
+	interval time.Duration
+	logger   *logrus.Logger
+	metrics  *metrics.CacheMetrics
+}
+
+// NewSizer creates a new Sizer measuring the cache size on startup and every interval, only once if it's zero
+func NewSizer(storage storage.Storage, interval time.Duration, logger *logrus.Logger) *Sizer {
+	return &Sizer{
+		storage:  storage,
+		interval: interval,
+		logger:   logger,
+		metrics:  metrics.NewCacheMetrics(),
+	}
+}
+
+// Run measures the cache size, then every interval until ctx is cancelled
+func (s *Sizer) Run(ctx context.Context) {
+	if _, err := s.Measure(ctx); err != nil && ctx.Err() == nil {
+		s.logger.WithError(err).Error("Failed to measure the cache size")
+	}
+	if s.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := s.Measure(ctx); err != nil && ctx.Err() == nil {
+			s.logger.WithError(err).Error("Failed to measure the cache size")
+		}
+	}
+}
+
+// Measure lists the cache, reports its size and coverage and returns the size. Internal documents aren't counted, like by the Evictor.
+func (s *Sizer) Measure(ctx context.Context) (int64, error) {
+	start := time.Now()
+	var size int64
+	var count int
+	coverage := newCoverage()
+	err := storage.Walk(ctx, s.storage, "", func(obj storage.ObjectInfo) error {
+		if strings.HasPrefix(obj.Key, metadata.KeyPrefix) {
+			return nil
+		}
+		size += obj.Size
+		count++
+		coverage.add(obj.Key)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	s.metrics.SetSize(size)
+	coverage.report(s.metrics)
+	s.logger.WithFields(logrus.Fields{
+		"size":     size,
+		"files":    count,
+		"duration": time.Since(start),
+	}).Info("Measured the cache size")
+	return size, nil
+}
@@ -0,0 +1,76 @@
+package eviction
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/metrics"
+	"cachetf/internal/storage"
+)
+
+func TestSizer_Measure(t *testing.T) {
+	ctx := context.Background()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	// Files cached by a previous process
+	dir := t.TempDir()
+	previous := storage.NewLocalStorage(dir, logger)
+	for _, key := range []string{
+		"providers/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip",
+		"providers/registry.terraform.io/hashicorp/aws/5.1.0/terraform-provider-aws_5.1.0_linux_amd64.zip",
+		"modules/hashicorp/consul/aws/0.1.0/archive.zip",
+	} {
+		require.NoError(t, previous.Put(ctx, key, strings.NewReader(strings.Repeat("x", 100))))
+	}
+	require.NoError(t, previous.Put(ctx, "metadata/auth/keys.json", strings.NewReader(strings.Repeat("x", 500))))
+
+	sizer := NewSizer(storage.NewLocalStorage(dir, logger), time.Hour, logger)
+	size, err := sizer.Measure(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(300), size, "internal documents aren't counted")
+	assert.Equal(t, float64(300), testutil.ToFloat64(metrics.CacheSizeBytes))
+
+	// Two versions of one provider, and a module archive
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.CachedProviders))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.CachedVersions))
+	assert.Equal(t, float64(3), testutil.ToFloat64(metrics.CachedArtifacts))
+}
+
+func TestSizer_Run(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	store := storage.NewLocalStorage(t.TempDir(), logger)
+	require.NoError(t, store.Put(ctx, "providers/a.zip", strings.NewReader(strings.Repeat("x", 42))))
+
+	// Without an interval, the size is measured once
+	done := make(chan struct{})
+	go func() {
+		NewSizer(store, 0, logger).Run(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't return")
+	}
+	assert.Equal(t, float64(42), testutil.ToFloat64(metrics.CacheSizeBytes))
+
+	// The size is measured again every interval
+	go NewSizer(store, 10*time.Millisecond, logger).Run(ctx)
+	defer cancel()
+	require.NoError(t, store.Put(ctx, "providers/b.zip", strings.NewReader(strings.Repeat("x", 8))))
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.CacheSizeBytes) == 50
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"cachetf/internal/auth"
+	"cachetf/internal/middleware"
+	"cachetf/internal/provenance"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// AdminHandler handles the admin API
+type AdminHandler struct {
+	auth       *auth.Authenticator
+	provenance *provenance.Store
+	logger     *logrus.Logger
+}
+
+// NewAdminHandler creates a new AdminHandler. Origin records are only served when provenance isn't nil.
+func NewAdminHandler(authenticator *auth.Authenticator, provenance *provenance.Store, logger *logrus.Logger) *AdminHandler {
+	return &AdminHandler{
+		auth:       authenticator,
+		provenance: provenance,
+		logger:     logger,
+	}
+}
+
+// apiKeyResponse describes a managed key without its hash
+type apiKeyResponse struct {
+	ID        string       `json:"id"`
+	Name      string       `json:"name"`
+	Scopes    []auth.Scope `json:"scopes"`
+	CreatedAt time.Time    `json:"createdAt"`
+	RevokedAt *time.Time   `json:"revokedAt,omitempty"`
+	// Key is the secret, only returned when the key is created
+	Key string `json:"key,omitempty"`
+}
+
+func newAPIKeyResponse(key *auth.ManagedKey) apiKeyResponse {
+	return apiKeyResponse{
+		ID:        key.ID,
+		Name:      key.Name,
+		Scopes:    key.Scopes,
+		CreatedAt: key.CreatedAt,
+		RevokedAt: key.RevokedAt,
+	}
+}
+
+// CreateAPIKeyRequest is the request body of CreateAPIKey
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name" binding:"required"`
+	Scopes []string `json:"scopes" binding:"required"`
+}
+
+// ListAPIKeys handles GET requests listing the managed API keys
+func (h *AdminHandler) ListAPIKeys(c *gin.Context) {
+	keys := h.auth.ManagedKeys()
+
+	response := make([]apiKeyResponse, 0, len(keys))
+	for i := range keys {
+		response = append(response, newAPIKeyResponse(&keys[i]))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"keys": response})
+}
+
+// CreateAPIKey handles POST requests creating a managed API key
+func (h *AdminHandler) CreateAPIKey(c *gin.Context) {
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+		return
+	}
+
+	scopes, err := auth.ParseScopes(req.Scopes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(scopes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "at least one scope is required"})
+		return
+	}
+
+	key, secret, err := h.auth.CreateKey(c.Request.Context(), req.Name, scopes)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to create API key")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"id":        key.ID,
+		"name":      key.Name,
+		"scopes":    key.Scopes,
+		"createdBy": principalName(c),
+	}).Info("Created API key")
+
+	response := newAPIKeyResponse(key)
+	response.Key = secret
+	c.JSON(http.StatusCreated, response)
+}
+
+// RevokeAPIKey handles DELETE requests revoking a managed API key
+func (h *AdminHandler) RevokeAPIKey(c *gin.Context) {
+	key, err := h.auth.RevokeKey(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		if errors.Is(err, auth.ErrKeyNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "key not found"})
+			return
+		}
+		h.logger.WithError(err).Error("Failed to revoke API key")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"id":        key.ID,
+		"name":      key.Name,
+		"revokedBy": principalName(c),
+	}).Info("Revoked API key")
+
+	c.JSON(http.StatusOK, newAPIKeyResponse(key))
+}
+
+// GetOrigin handles GET requests returning the origin record of a cached artifact
+func (h *AdminHandler) GetOrigin(c *gin.Context) {
+	if h.provenance == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "origin records are not enabled"})
+		return
+	}
+
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	record, err := h.provenance.Load(c.Request.Context(), key)
+	if err != nil {
+		if errors.Is(err, provenance.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "origin record not found"})
+			return
+		}
+		if errors.Is(err, provenance.ErrInvalidKey) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.WithError(err).WithField("key", key).Error("Failed to load origin record")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, record)
+}
+
+// principalName returns the name of the authenticated principal, for audit logs
+func principalName(c *gin.Context) string {
+	if principal := middleware.GetPrincipal(c); principal != nil {
+		return principal.Name
+	}
+	return auth.Anonymous
+}
@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/auth"
+	"cachetf/internal/metadata"
+	"cachetf/internal/provenance"
+	"cachetf/internal/storage"
+)
+
+func TestAdminHandler_APIKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	authenticator := auth.NewAuthenticator(nil, nil)
+	store := metadata.NewStore(storage.NewLocalStorage(t.TempDir(), logger), logger)
+	require.NoError(t, authenticator.LoadKeys(t.Context(), store))
+
+	h := NewAdminHandler(authenticator, nil, logger)
+	router := gin.New()
+	router.GET("/admin/keys", h.ListAPIKeys)
+	router.POST("/admin/keys", h.CreateAPIKey)
+	router.DELETE("/admin/keys/:id", h.RevokeAPIKey)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Invalid requests are rejected
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/admin/keys", `{"name":"ci"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/admin/keys", `{"name":"ci","scopes":["write"]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/admin/keys", `{"name":"ci","scopes":[]}`).Code)
+
+	// Create a key, the secret is only returned once
+	w := do("POST", "/admin/keys", `{"name":"ci","scopes":["read","prefetch"]}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var created apiKeyResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.NotEmpty(t, created.Key)
+	assert.Equal(t, []auth.Scope{auth.ScopeRead, auth.ScopePrefetch}, created.Scopes)
+
+	_, ok := authenticator.Authenticate(created.Key)
+	assert.True(t, ok)
+
+	// Listing doesn't expose secrets or hashes
+	w = do("GET", "/admin/keys", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), created.Key)
+	assert.NotContains(t, w.Body.String(), "hash")
+	assert.Contains(t, w.Body.String(), created.ID)
+
+	// Revoke the key
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/admin/keys/missing", "").Code)
+	w = do("DELETE", "/admin/keys/"+created.ID, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "revokedAt")
+
+	_, ok = authenticator.Authenticate(created.Key)
+	assert.False(t, ok)
+}
+
+func TestAdminHandler_GetOrigin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	origins := provenance.NewStore(metadata.NewStore(storage.NewLocalStorage(t.TempDir(), logger), logger))
+	key := "registry.terraform.io/hashicorp/random/3.7.2/terraform-provider-random_3.7.2_linux_amd64.zip"
+	require.NoError(t, origins.Save(t.Context(), &provenance.Record{
+		Key:       key,
+		Host:      "releases.hashicorp.com",
+		Principal: "ci",
+	}))
+
+	h := NewAdminHandler(auth.NewAuthenticator(nil, nil), origins, logger)
+	router := gin.New()
+	router.GET("/admin/origins/*key", h.GetOrigin)
+
+	tests := []struct {
+		name         string
+		path         string
+		expectedCode int
+	}{
+		{"existing record", "/admin/origins/" + key, http.StatusOK},
+		{"missing record", "/admin/origins/registry.terraform.io/hashicorp/random/3.7.2/other.zip", http.StatusNotFound},
+		{"metadata document", "/admin/origins/metadata/auth/keys.json", http.StatusBadRequest},
+		{"empty key", "/admin/origins/", http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", tc.path, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code, w.Body.String())
+			if tc.expectedCode == http.StatusOK {
+				var record provenance.Record
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &record))
+				assert.Equal(t, key, record.Key)
+				assert.Equal(t, "ci", record.Principal)
+			}
+		})
+	}
+}
@@ -1,11 +1,20 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 
+	"cachetf/internal/layout"
+	"cachetf/internal/metadata"
+	"cachetf/internal/pins"
+	"cachetf/internal/provenance"
 	"cachetf/internal/storage"
+	"cachetf/pkg/validate"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
@@ -15,6 +24,12 @@ import (
 type CacheHandler struct {
 	storage storage.Storage
 	logger  *logrus.Logger
+	// keys lays the cached files out in the storage
+	keys layout.Strategy
+	// pins protects files from deletion, if set
+	pins *pins.Set
+	// provenance holds the metadata recorded when the artifacts were cached, if set
+	provenance *provenance.Store
 }
 
 // NewCacheHandler creates a new CacheHandler
@@ -22,9 +37,25 @@ func NewCacheHandler(storage storage.Storage, logger *logrus.Logger) *CacheHandl
 	return &CacheHandler{
 		storage: storage,
 		logger:  logger,
+		keys:    layout.Default,
 	}
 }
 
+// UseKeys makes the handler find the cached files with the given layout
+func (h *CacheHandler) UseKeys(keys layout.Strategy) {
+	h.keys = keys
+}
+
+// UsePins makes the handler refuse to delete pinned files
+func (h *CacheHandler) UsePins(pins *pins.Set) {
+	h.pins = pins
+}
+
+// UseProvenance makes the listing include the metadata recorded when the artifacts were cached
+func (h *CacheHandler) UseProvenance(store *provenance.Store) {
+	h.provenance = store
+}
+
 // DeleteCache handles DELETE requests to clear cache by prefix
 func (h *CacheHandler) DeleteCache(c *gin.Context) {
 	// Get path parameters
@@ -42,22 +73,38 @@ func (h *CacheHandler) DeleteCache(c *gin.Context) {
 			if version := c.Param("version"); version != "" {
 				params = append(params, version)
 
-				// Handle file deletion if specified
+				// A single file is deleted by its exact key, so it can't match other files sharing its name as a prefix
 				if file := c.Param("file"); file != "" {
-					params = append(params, file)
+					if err := validate.ProviderPath(params...); err != nil || file == "." || file == ".." {
+						c.JSON(http.StatusBadRequest, gin.H{"error": "invalid file path"})
+						return
+					}
+					h.deleteFile(c, h.keys.ProviderFile(params[0], params[1], params[2], params[3], file))
+					return
 				}
 			}
 		}
 	}
 
-	// Join parameters to create the prefix
-	prefix := strings.Join(params, "/")
+	if err := validate.ProviderPath(params...); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Prefix of the keys to delete, without its trailing slash
+	prefix := strings.TrimSuffix(h.keys.ProviderPrefix(params...), "/")
 
 	// Log the deletion attempt
 	h.logger.WithFields(logrus.Fields{
 		"prefix": prefix,
 	}).Info("Deleting cache by prefix")
 
+	// Pinned files have to be skipped one by one
+	if h.pins != nil && h.pins.Overlaps(prefix) {
+		h.deleteUnpinned(c, prefix)
+		return
+	}
+
 	// Delete objects with the given prefix
 	count, err := h.storage.DeleteByPrefix(c.Request.Context(), prefix)
 	if err != nil {
@@ -75,6 +122,207 @@ func (h *CacheHandler) DeleteCache(c *gin.Context) {
 	})
 }
 
+const (
+	// defaultListLimit is the page size of ListCache when no limit is given
+	defaultListLimit = 100
+	// maxListLimit is the largest page size ListCache accepts
+	maxListLimit = 1000
+)
+
+// listedObject is a cached artifact of the listing, with the metadata recorded when it was cached
+type listedObject struct {
+	storage.ObjectInfo
+	// SHA256 is the upstream checksum the artifact was verified against
+	SHA256 string `json:"sha256,omitempty"`
+	// SourceURL is where the artifact was downloaded from
+	SourceURL string `json:"sourceUrl,omitempty"`
+	// CachedAt is when the artifact was downloaded
+	CachedAt *time.Time `json:"cachedAt,omitempty"`
+}
+
+// ListCache handles GET requests returning a page of the cached artifacts.
+// Results can be filtered with the scheme, registry, namespace and provider query parameters
+// and paginated with limit and startAfter. With details=true, the artifacts include the checksum, source URL and
+// time recorded when they were cached.
+func (h *CacheHandler) ListCache(c *gin.Context) {
+	// Each filter level requires the previous one
+	if c.Query("provider") != "" && c.Query("namespace") == "" || c.Query("namespace") != "" && c.Query("registry") == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "provider requires namespace, and namespace requires registry"})
+		return
+	}
+
+	// The registry filters only apply to providers, which is the default scheme when they're given
+	scheme := layout.Scheme("")
+	if value := c.Query("scheme"); value != "" {
+		var ok bool
+		if scheme, ok = layout.ParseScheme(value); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid scheme"})
+			return
+		}
+	}
+	if c.Query("registry") != "" {
+		if scheme != "" && scheme != layout.Providers {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "registry filters only apply to the providers scheme"})
+			return
+		}
+		scheme = layout.Providers
+	}
+
+	// Build the prefix from the filters
+	var params []string
+	for _, name := range []string{"registry", "namespace", "provider"} {
+		value := c.Query(name)
+		if value == "" {
+			break
+		}
+		params = append(params, value)
+	}
+	if err := validate.ProviderPath(params...); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	prefix := ""
+	switch {
+	case len(params) > 0:
+		prefix = h.keys.ProviderPrefix(params...)
+	case scheme != "":
+		prefix = h.keys.Prefix(scheme)
+	}
+
+	limit := defaultListLimit
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 || n > maxListLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxListLimit)})
+			return
+		}
+		limit = n
+	}
+
+	page, err := h.storage.List(c.Request.Context(), prefix, storage.ListOptions{
+		StartAfter: c.Query("startAfter"),
+		MaxKeys:    limit,
+	})
+	if err != nil {
+		h.logger.WithError(err).WithField("prefix", prefix).Error("Failed to list cache")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to list cache: %v", err),
+		})
+		return
+	}
+
+	// Internal documents aren't cached artifacts
+	details := c.Query("details") == "true" && h.provenance != nil
+	objects := make([]listedObject, 0, len(page.Objects))
+	for _, obj := range page.Objects {
+		if strings.HasPrefix(obj.Key, metadata.KeyPrefix) {
+			continue
+		}
+		listed := listedObject{ObjectInfo: obj}
+		if details {
+			h.addDetails(c, &listed)
+		}
+		objects = append(objects, listed)
+	}
+
+	response := gin.H{
+		"objects":     objects,
+		"isTruncated": page.IsTruncated,
+	}
+	if page.IsTruncated {
+		response["nextStartAfter"] = page.NextStartAfter
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// addDetails adds the metadata recorded when an artifact was cached to its listing. Artifacts without a record,
+// such as checksum files, are listed as they are.
+func (h *CacheHandler) addDetails(c *gin.Context, listed *listedObject) {
+	record, err := h.provenance.Load(c.Request.Context(), listed.Key)
+	if err != nil {
+		if !errors.Is(err, provenance.ErrNotFound) {
+			h.logger.WithError(err).WithField("key", listed.Key).Warn("Failed to load origin record of listed file")
+		}
+		return
+	}
+	listed.SHA256 = record.Verification.SHA256
+	listed.SourceURL = record.URL
+	if !record.FetchedAt.IsZero() {
+		listed.CachedAt = &record.FetchedAt
+	}
+}
+
+// deleteUnpinned deletes the files with the given prefix that aren't pinned
+func (h *CacheHandler) deleteUnpinned(c *gin.Context, prefix string) {
+	ctx := c.Request.Context()
+	deleted, protected := 0, 0
+
+	err := storage.Walk(ctx, h.storage, prefix, func(obj storage.ObjectInfo) error {
+		if h.pins.Protected(obj.Key) {
+			protected++
+			return nil
+		}
+		if err := h.storage.Delete(ctx, obj.Key); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		deleted++
+		return nil
+	})
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to delete cache")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to delete cache: %v", err),
+		})
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"prefix":    prefix,
+		"deleted":   deleted,
+		"protected": protected,
+	}).Info("Kept pinned files while deleting cache by prefix")
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Cache cleared successfully, pinned files were kept",
+		"deleted":   deleted,
+		"protected": protected,
+	})
+}
+
+// deleteFile deletes a single cached file
+func (h *CacheHandler) deleteFile(c *gin.Context, key string) {
+	if h.pins != nil && h.pins.Protected(key) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "File is pinned, remove the pin before deleting it",
+		})
+		return
+	}
+
+	h.logger.WithField("key", key).Info("Deleting cached file")
+
+	if err := h.storage.Delete(c.Request.Context(), key); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "File not found in cache",
+			})
+			return
+		}
+		h.logger.WithError(err).Error("Failed to delete cache")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to delete cache: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Cache cleared successfully",
+		"deleted": 1,
+	})
+}
+
 // RegisterCacheRoutes registers cache-related routes
 func (h *CacheHandler) RegisterCacheRoutes(router *gin.RouterGroup) {
 	// DELETE /:registry/...
@@ -82,4 +330,5 @@ func (h *CacheHandler) RegisterCacheRoutes(router *gin.RouterGroup) {
 	router.DELETE("/:registry/:namespace", h.DeleteCache)
 	router.DELETE("/:registry/:namespace/:provider", h.DeleteCache)
 	router.DELETE("/:registry/:namespace/:provider/:version", h.DeleteCache)
+	router.DELETE("/:registry/:namespace/:provider/:version/:file", h.DeleteCache)
 }
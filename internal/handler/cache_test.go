@@ -7,13 +7,22 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 	"github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/layout"
+	"cachetf/internal/metadata"
+	"cachetf/internal/provenance"
+	"cachetf/internal/storage"
 )
 
 // MockStorage is a mock implementation of the storage interface
@@ -34,6 +43,14 @@ func (m *MockStorage) Put(ctx context.Context, key string, r io.Reader) error {
 	return args.Error(0)
 }
 
+func (m *MockStorage) List(ctx context.Context, prefix string, opts storage.ListOptions) (*storage.ListResult, error) {
+	args := m.Called(ctx, prefix, opts)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*storage.ListResult), args.Error(1)
+}
+
 func (m *MockStorage) Delete(ctx context.Context, key string) error {
 	args := m.Called(ctx, key)
 	return args.Error(0)
@@ -62,7 +79,7 @@ func TestDeleteCache(t *testing.T) {
 			name: "delete by registry only",
 			path: "/registry.terraform.io",
 			setupMock: func(ms *MockStorage) {
-				ms.On("DeleteByPrefix", mock.Anything, "registry.terraform.io").Return(5, nil)
+				ms.On("DeleteByPrefix", mock.Anything, "providers/registry.terraform.io").Return(5, nil)
 			},
 			expectedStatus: http.StatusOK,
 			expectedBody: map[string]interface{}{
@@ -75,7 +92,7 @@ func TestDeleteCache(t *testing.T) {
 			name: "delete by namespace",
 			path: "/registry.terraform.io/hashicorp",
 			setupMock: func(ms *MockStorage) {
-				ms.On("DeleteByPrefix", mock.Anything, "registry.terraform.io/hashicorp").Return(3, nil)
+				ms.On("DeleteByPrefix", mock.Anything, "providers/registry.terraform.io/hashicorp").Return(3, nil)
 			},
 			expectedStatus: http.StatusOK,
 			expectedBody: map[string]interface{}{
@@ -88,7 +105,7 @@ func TestDeleteCache(t *testing.T) {
 			name: "delete by provider",
 			path: "/registry.terraform.io/hashicorp/aws",
 			setupMock: func(ms *MockStorage) {
-				ms.On("DeleteByPrefix", mock.Anything, "registry.terraform.io/hashicorp/aws").Return(2, nil)
+				ms.On("DeleteByPrefix", mock.Anything, "providers/registry.terraform.io/hashicorp/aws").Return(2, nil)
 			},
 			expectedStatus: http.StatusOK,
 			expectedBody: map[string]interface{}{
@@ -101,7 +118,7 @@ func TestDeleteCache(t *testing.T) {
 			name: "delete by version",
 			path: "/registry.terraform.io/hashicorp/aws/1.2.3",
 			setupMock: func(ms *MockStorage) {
-				ms.On("DeleteByPrefix", mock.Anything, "registry.terraform.io/hashicorp/aws/1.2.3").Return(1, nil)
+				ms.On("DeleteByPrefix", mock.Anything, "providers/registry.terraform.io/hashicorp/aws/1.2.3").Return(1, nil)
 			},
 			expectedStatus: http.StatusOK,
 			expectedBody: map[string]interface{}{
@@ -110,11 +127,54 @@ func TestDeleteCache(t *testing.T) {
 			},
 			expectedLogs: []string{"Deleting cache by prefix"},
 		},
+		{
+			name: "delete single file",
+			path: "/registry.terraform.io/hashicorp/aws/1.2.3/terraform-provider-aws_1.2.3_linux_amd64.zip",
+			setupMock: func(ms *MockStorage) {
+				ms.On("Delete", mock.Anything, "providers/registry.terraform.io/hashicorp/aws/1.2.3/terraform-provider-aws_1.2.3_linux_amd64.zip").Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: map[string]interface{}{
+				"message": "Cache cleared successfully",
+				"deleted": float64(1),
+			},
+			expectedLogs: []string{"Deleting cached file"},
+		},
+		{
+			name: "delete missing file",
+			path: "/registry.terraform.io/hashicorp/aws/1.2.3/terraform-provider-aws_1.2.3_linux_arm64.zip",
+			setupMock: func(ms *MockStorage) {
+				ms.On("Delete", mock.Anything, "providers/registry.terraform.io/hashicorp/aws/1.2.3/terraform-provider-aws_1.2.3_linux_arm64.zip").Return(os.ErrNotExist)
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedBody: map[string]interface{}{
+				"error": "File not found in cache",
+			},
+			expectedLogs: []string{"Deleting cached file"},
+		},
+		{
+			name:           "invalid namespace",
+			path:           "/registry.terraform.io/-hashicorp",
+			setupMock:      func(ms *MockStorage) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody: map[string]interface{}{
+				"error": `invalid namespace "-hashicorp"`,
+			},
+		},
+		{
+			name:           "invalid version of a file",
+			path:           "/registry.terraform.io/hashicorp/aws/latest/terraform-provider-aws_1.2.3_linux_amd64.zip",
+			setupMock:      func(ms *MockStorage) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody: map[string]interface{}{
+				"error": "invalid file path",
+			},
+		},
 		{
 			name: "storage error",
 			path: "/registry.terraform.io",
 			setupMock: func(ms *MockStorage) {
-				ms.On("DeleteByPrefix", mock.Anything, "registry.terraform.io").Return(0, errors.New("storage error"))
+				ms.On("DeleteByPrefix", mock.Anything, "providers/registry.terraform.io").Return(0, errors.New("storage error"))
 			},
 			expectedStatus: http.StatusInternalServerError,
 			expectedBody: map[string]interface{}{
@@ -183,10 +243,10 @@ func TestRegisterCacheRoutes(t *testing.T) {
 	logger := logrus.New()
 
 	// Set up mock expectations for each route
-	mockStorage.On("DeleteByPrefix", mock.Anything, "registry.terraform.io").Return(1, nil)
-	mockStorage.On("DeleteByPrefix", mock.Anything, "registry.terraform.io/hashicorp").Return(1, nil)
-	mockStorage.On("DeleteByPrefix", mock.Anything, "registry.terraform.io/hashicorp/aws").Return(1, nil)
-	mockStorage.On("DeleteByPrefix", mock.Anything, "registry.terraform.io/hashicorp/aws/1.2.3").Return(1, nil)
+	mockStorage.On("DeleteByPrefix", mock.Anything, "providers/registry.terraform.io").Return(1, nil)
+	mockStorage.On("DeleteByPrefix", mock.Anything, "providers/registry.terraform.io/hashicorp").Return(1, nil)
+	mockStorage.On("DeleteByPrefix", mock.Anything, "providers/registry.terraform.io/hashicorp/aws").Return(1, nil)
+	mockStorage.On("DeleteByPrefix", mock.Anything, "providers/registry.terraform.io/hashicorp/aws/1.2.3").Return(1, nil)
 
 	handler := NewCacheHandler(mockStorage, logger)
 
@@ -208,7 +268,7 @@ func TestRegisterCacheRoutes(t *testing.T) {
 			method: "DELETE",
 			path:   "/api/registry.terraform.io",
 			setup: func(t *testing.T, ms *MockStorage) {
-				ms.On("DeleteByPrefix", mock.Anything, "registry.terraform.io").Return(1, nil).Once()
+				ms.On("DeleteByPrefix", mock.Anything, "providers/registry.terraform.io").Return(1, nil).Once()
 			},
 			status: http.StatusOK,
 		},
@@ -217,7 +277,7 @@ func TestRegisterCacheRoutes(t *testing.T) {
 			method: "DELETE",
 			path:   "/api/registry.terraform.io/hashicorp",
 			setup: func(t *testing.T, ms *MockStorage) {
-				ms.On("DeleteByPrefix", mock.Anything, "registry.terraform.io/hashicorp").Return(1, nil).Once()
+				ms.On("DeleteByPrefix", mock.Anything, "providers/registry.terraform.io/hashicorp").Return(1, nil).Once()
 			},
 			status: http.StatusOK,
 		},
@@ -226,7 +286,7 @@ func TestRegisterCacheRoutes(t *testing.T) {
 			method: "DELETE",
 			path:   "/api/registry.terraform.io/hashicorp/aws",
 			setup: func(t *testing.T, ms *MockStorage) {
-				ms.On("DeleteByPrefix", mock.Anything, "registry.terraform.io/hashicorp/aws").Return(1, nil).Once()
+				ms.On("DeleteByPrefix", mock.Anything, "providers/registry.terraform.io/hashicorp/aws").Return(1, nil).Once()
 			},
 			status: http.StatusOK,
 		},
@@ -235,7 +295,7 @@ func TestRegisterCacheRoutes(t *testing.T) {
 			method: "DELETE",
 			path:   "/api/registry.terraform.io/hashicorp/aws/1.2.3",
 			setup: func(t *testing.T, ms *MockStorage) {
-				ms.On("DeleteByPrefix", mock.Anything, "registry.terraform.io/hashicorp/aws/1.2.3").Return(1, nil).Once()
+				ms.On("DeleteByPrefix", mock.Anything, "providers/registry.terraform.io/hashicorp/aws/1.2.3").Return(1, nil).Once()
 			},
 			status: http.StatusOK,
 		},
@@ -262,3 +322,249 @@ func TestRegisterCacheRoutes(t *testing.T) {
 		})
 	}
 }
+
+func TestListCache(t *testing.T) {
+	modified := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name           string
+		query          string
+		setupMock      func(*MockStorage)
+		expectedStatus int
+		expectedKeys   []string
+		expectedNext   string
+	}{
+		{
+			name:  "everything",
+			query: "",
+			setupMock: func(ms *MockStorage) {
+				ms.On("List", mock.Anything, "", storage.ListOptions{MaxKeys: 100}).Return(&storage.ListResult{
+					Objects: []storage.ObjectInfo{
+						{Key: "metadata/auth/keys.json", Size: 10, LastModified: modified},
+						{Key: "providers/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip", Size: 42, LastModified: modified},
+					},
+				}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedKeys:   []string{"providers/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip"},
+		},
+		{
+			name:  "filtered and paginated",
+			query: "?registry=registry.terraform.io&namespace=hashicorp&provider=aws&limit=1&startAfter=a",
+			setupMock: func(ms *MockStorage) {
+				ms.On("List", mock.Anything, "providers/registry.terraform.io/hashicorp/aws/", storage.ListOptions{StartAfter: "a", MaxKeys: 1}).Return(&storage.ListResult{
+					Objects: []storage.ObjectInfo{
+						{Key: "providers/registry.terraform.io/hashicorp/aws/5.0.0/b.zip", Size: 1, LastModified: modified},
+					},
+					IsTruncated:    true,
+					NextStartAfter: "providers/registry.terraform.io/hashicorp/aws/5.0.0/b.zip",
+				}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedKeys:   []string{"providers/registry.terraform.io/hashicorp/aws/5.0.0/b.zip"},
+			expectedNext:   "providers/registry.terraform.io/hashicorp/aws/5.0.0/b.zip",
+		},
+		{
+			name:           "provider without namespace",
+			query:          "?registry=registry.terraform.io&provider=aws",
+			setupMock:      func(ms *MockStorage) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "path traversal",
+			query:          "?registry=..",
+			setupMock:      func(ms *MockStorage) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "invalid provider",
+			query:          "?registry=registry.terraform.io&namespace=hashicorp&provider=AWS",
+			setupMock:      func(ms *MockStorage) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:  "filter by scheme",
+			query: "?scheme=modules",
+			setupMock: func(ms *MockStorage) {
+				ms.On("List", mock.Anything, "modules/", storage.ListOptions{MaxKeys: 100}).Return(&storage.ListResult{
+					Objects: []storage.ObjectInfo{
+						{Key: "modules/hashicorp/consul/aws/0.1.0/archive.tar.gz", Size: 7, LastModified: modified},
+					},
+				}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedKeys:   []string{"modules/hashicorp/consul/aws/0.1.0/archive.tar.gz"},
+		},
+		{
+			name:           "invalid scheme",
+			query:          "?scheme=metadata",
+			setupMock:      func(ms *MockStorage) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "registry filter outside of providers",
+			query:          "?scheme=modules&registry=registry.terraform.io",
+			setupMock:      func(ms *MockStorage) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "invalid limit",
+			query:          "?limit=5000",
+			setupMock:      func(ms *MockStorage) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:  "storage error",
+			query: "?registry=registry.terraform.io",
+			setupMock: func(ms *MockStorage) {
+				ms.On("List", mock.Anything, "providers/registry.terraform.io/", storage.ListOptions{MaxKeys: 100}).Return(nil, errors.New("storage error"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockStorage := new(MockStorage)
+			tc.setupMock(mockStorage)
+
+			logger, _ := test.NewNullLogger()
+			handler := NewCacheHandler(mockStorage, logger)
+
+			router := gin.New()
+			router.GET("/cache", handler.ListCache)
+
+			req, _ := http.NewRequest("GET", "/cache"+tc.query, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedStatus, w.Code)
+			mockStorage.AssertExpectations(t)
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var response struct {
+				Objects        []storage.ObjectInfo `json:"objects"`
+				IsTruncated    bool                 `json:"isTruncated"`
+				NextStartAfter string               `json:"nextStartAfter"`
+			}
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+
+			var keys []string
+			for _, obj := range response.Objects {
+				keys = append(keys, obj.Key)
+				assert.True(t, modified.Equal(obj.LastModified))
+			}
+			assert.Equal(t, tc.expectedKeys, keys)
+			assert.Equal(t, tc.expectedNext, response.NextStartAfter)
+			assert.Equal(t, tc.expectedNext != "", response.IsTruncated)
+		})
+	}
+}
+
+func TestListCache_Details(t *testing.T) {
+	logger, _ := test.NewNullLogger()
+	store := storage.NewLocalStorage(t.TempDir(), logger)
+	origins := provenance.NewStore(metadata.NewStore(store, logger))
+
+	fetched := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	binary := "providers/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip"
+	sums := "providers/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_SHA256SUMS"
+	require.NoError(t, store.Put(t.Context(), binary, strings.NewReader("binary")))
+	require.NoError(t, store.Put(t.Context(), sums, strings.NewReader("sums")))
+	require.NoError(t, origins.Save(t.Context(), &provenance.Record{
+		Key:          binary,
+		URL:          "https://releases.hashicorp.com/terraform-provider-aws_5.0.0_linux_amd64.zip",
+		Size:         6,
+		FetchedAt:    fetched,
+		Verification: provenance.Verification{SHA256: "abc123"},
+	}))
+
+	handler := NewCacheHandler(store, logger)
+	handler.UseProvenance(origins)
+	router := gin.New()
+	router.GET("/cache", handler.ListCache)
+
+	list := func(query string) map[string]map[string]any {
+		req, _ := http.NewRequest("GET", "/cache?scheme=providers"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var response struct {
+			Objects []map[string]any `json:"objects"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		objects := make(map[string]map[string]any)
+		for _, obj := range response.Objects {
+			objects[obj["key"].(string)] = obj
+		}
+		return objects
+	}
+
+	// The metadata is only loaded on request
+	objects := list("")
+	require.Len(t, objects, 2)
+	assert.NotContains(t, objects[binary], "sha256")
+
+	objects = list("&details=true")
+	require.Len(t, objects, 2)
+	assert.Equal(t, "abc123", objects[binary]["sha256"])
+	assert.Equal(t, "https://releases.hashicorp.com/terraform-provider-aws_5.0.0_linux_amd64.zip", objects[binary]["sourceUrl"])
+	assert.Equal(t, "2025-01-02T03:04:05Z", objects[binary]["cachedAt"])
+	assert.EqualValues(t, 6, objects[binary]["size"])
+	assert.NotContains(t, objects[sums], "sha256", "files without a record are listed as they are")
+}
+
+func TestCacheHandler_UseKeys(t *testing.T) {
+	keys, err := layout.NewTenantStrategy("acme")
+	assert.NoError(t, err)
+
+	mockStorage := new(MockStorage)
+	mockStorage.On("DeleteByPrefix", mock.Anything, "providers/_tenants/acme/registry.terraform.io/hashicorp").Return(2, nil)
+	mockStorage.On("Delete", mock.Anything, "providers/_tenants/acme/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip").Return(nil)
+	mockStorage.On("List", mock.Anything, "providers/_tenants/acme/registry.terraform.io/", storage.ListOptions{MaxKeys: 100}).Return(&storage.ListResult{}, nil)
+	mockStorage.On("List", mock.Anything, "modules/_tenants/acme/", storage.ListOptions{MaxKeys: 100}).Return(&storage.ListResult{}, nil)
+
+	logger, _ := test.NewNullLogger()
+	handler := NewCacheHandler(mockStorage, logger)
+	handler.UseKeys(keys)
+
+	router := gin.New()
+	handler.RegisterCacheRoutes(router.Group("/"))
+	router.GET("/cache", handler.ListCache)
+
+	// Deletions and listings stay within the keys of the tenant
+	for _, request := range []struct{ method, path string }{
+		{"DELETE", "/registry.terraform.io/hashicorp"},
+		{"DELETE", "/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip"},
+		{"GET", "/cache?registry=registry.terraform.io"},
+		{"GET", "/cache?scheme=modules"},
+	} {
+		req, _ := http.NewRequest(request.method, request.path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, request.path)
+	}
+	mockStorage.AssertExpectations(t)
+}
+
+func TestDeleteCache_TenantsSegment(t *testing.T) {
+	// An instance with the path layout shares the bucket with tenants
+	mockStorage := new(MockStorage)
+	logger, _ := test.NewNullLogger()
+	handler := NewCacheHandler(mockStorage, logger)
+	router := gin.New()
+	handler.RegisterCacheRoutes(router.Group("/providers"))
+
+	// Purging the registry named like the tenant segment would delete the files of every tenant
+	for _, path := range []string{"/providers/_tenants", "/providers/_tenants/acme", "/providers/_tenants/acme/registry.terraform.io"} {
+		req, _ := http.NewRequest("DELETE", path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, path)
+	}
+	mockStorage.AssertNotCalled(t, "DeleteByPrefix", mock.Anything, mock.Anything)
+	mockStorage.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+}
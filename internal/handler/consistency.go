@@ -0,0 +1,231 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/errclass"
+	"cachetf/internal/provenance"
+	"cachetf/internal/verify"
+)
+
+// Consistency statuses of a cached provider binary
+const (
+	// ConsistencyOK is a binary whose checksum is listed in the signed upstream SHA256SUMS
+	ConsistencyOK = "ok"
+	// ConsistencyDrift is a binary whose checksum differs from the upstream or the recorded one
+	ConsistencyDrift = "drift"
+	// ConsistencyRemoved is a binary of a version or platform the upstream registry doesn't publish anymore
+	ConsistencyRemoved = "removed"
+	// ConsistencyRecorded is a binary matching the checksum recorded when it was cached, upstream wasn't contacted
+	ConsistencyRecorded = "recorded"
+	// ConsistencyUnverified is a binary without a recorded checksum, upstream wasn't contacted
+	ConsistencyUnverified = "unverified"
+	// ConsistencyError is a binary that couldn't be checked
+	ConsistencyError = "error"
+)
+
+// ArtifactConsistency is the result of the check of a cached provider binary
+type ArtifactConsistency struct {
+	Key     string `json:"key"`
+	Version string `json:"version"`
+	OS      string `json:"os"`
+	Arch    string `json:"arch"`
+	// SHA256 is the checksum of the cached file
+	SHA256 string `json:"sha256,omitempty"`
+	Status string `json:"status"`
+	// Detail explains drifts and errors
+	Detail string `json:"detail,omitempty"`
+}
+
+// ConsistencyReport lists the cached binaries of a provider and how they compare with upstream
+type ConsistencyReport struct {
+	Registry  string `json:"registry"`
+	Namespace string `json:"namespace"`
+	Provider  string `json:"provider"`
+	// Online is true if the binaries were checked against upstream, false if against the recorded checksums
+	Online    bool                  `json:"online"`
+	Artifacts []ArtifactConsistency `json:"artifacts"`
+	// Counts holds the number of binaries by status
+	Counts map[string]int `json:"counts"`
+}
+
+// Consistent returns true if no binary drifted and every binary could be checked
+func (r *ConsistencyReport) Consistent() bool {
+	return r.Counts[ConsistencyDrift] == 0 && r.Counts[ConsistencyError] == 0
+}
+
+// VerifyConsistency hashes every cached binary of a provider and, when online, checks that the checksum is listed
+// in the SHA256SUMS of its version, whose signature is verified with the upstream signing keys and the trusted
+// keyring. Offline, the binaries are compared with the checksums recorded when they were cached. Nothing is
+// changed in the cache.
+func (h *RegistryHandler) VerifyConsistency(ctx context.Context, registry, namespace, provider string, online bool) (*ConsistencyReport, error) {
+	report := &ConsistencyReport{
+		Registry:  registry,
+		Namespace: namespace,
+		Provider:  provider,
+		Online:    online,
+		Counts:    make(map[string]int),
+	}
+	if online && !h.isAllowedRegistry(registry) {
+		return nil, fmt.Errorf("registry %s isn't allowed", registry)
+	}
+
+	versions, err := h.cachedVersions(ctx, registry, namespace, provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cached binaries: %w", err)
+	}
+
+	verifier := h.verifier
+	if verifier == nil {
+		// Auditing requires the signature, the upstream signing keys are enough to check it
+		verifier, _ = verify.NewGPGVerifier("", true)
+	}
+
+	sorted := make([]string, 0, len(versions))
+	for version := range versions {
+		sorted = append(sorted, version)
+	}
+	sort.Strings(sorted)
+
+	for _, version := range sorted {
+		platforms := versions[version]
+		sort.Slice(platforms, func(i, j int) bool { return platforms[i].String() < platforms[j].String() })
+
+		// Any published platform gives the checksums of the version
+		var sums []byte
+		var sumsErr error
+		for _, platform := range platforms {
+			if !online {
+				break
+			}
+			sums, sumsErr = h.signedSHASums(ctx, verifier, registry, namespace, provider, version, platform)
+			if !errors.Is(sumsErr, errVersionNotFound) {
+				break
+			}
+		}
+
+		for _, platform := range platforms {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			artifact := ArtifactConsistency{
+				Key:     h.getCacheKey(registry, namespace, provider, version, platform.OS, platform.Arch),
+				Version: version,
+				OS:      platform.OS,
+				Arch:    platform.Arch,
+			}
+			filename := fmt.Sprintf("terraform-provider-%s_%s_%s_%s.zip", provider, version, platform.OS, platform.Arch)
+
+			artifact.SHA256, err = hashFile(ctx, h.storage, artifact.Key)
+			switch {
+			case err != nil:
+				artifact.Status, artifact.Detail = ConsistencyError, fmt.Sprintf("failed to read cached file: %v", err)
+			case online && errors.Is(sumsErr, errVersionNotFound):
+				artifact.Status = ConsistencyRemoved
+			case online && sumsErr != nil:
+				artifact.Status, artifact.Detail = ConsistencyError, sumsErr.Error()
+			case online:
+				artifact.Status, artifact.Detail = compareListed(sums, filename, artifact.SHA256)
+			default:
+				artifact.Status, artifact.Detail = h.compareRecorded(ctx, registry, namespace, provider, artifact)
+			}
+
+			report.Counts[artifact.Status]++
+			report.Artifacts = append(report.Artifacts, artifact)
+			if artifact.Status == ConsistencyDrift || artifact.Status == ConsistencyError {
+				h.logger.WithFields(logrus.Fields{
+					"key":    artifact.Key,
+					"status": artifact.Status,
+					"detail": artifact.Detail,
+				}).Warn("Cached provider binary is inconsistent")
+			}
+		}
+	}
+	return report, nil
+}
+
+// signedSHASums fetches the SHA256SUMS of a provider version and verifies its signature. The download info of a
+// cached platform gives the URLs of the files and the signing keys. errVersionNotFound is returned if upstream
+// doesn't publish the platform anymore.
+func (h *RegistryHandler) signedSHASums(ctx context.Context, verifier *verify.GPGVerifier, registry, namespace, provider, version string, platform Platform) ([]byte, error) {
+	downloadInfo, err := h.fetchDownloadInfo(ctx, registry, namespace, provider, version, platform.OS, platform.Arch)
+	var statusErr *upstreamStatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		return nil, errVersionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch download info: %w", err)
+	}
+	if downloadInfo.SHASumsURL == "" || downloadInfo.SHASumsSignatureURL == "" {
+		return nil, verify.ErrSignatureMissing
+	}
+
+	sums, err := h.fetchBytes(ctx, downloadInfo.SHASumsURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download SHA256SUMS: %w", err)
+	}
+	signature, err := h.fetchBytes(ctx, downloadInfo.SHASumsSignatureURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download SHA256SUMS signature: %w", err)
+	}
+
+	keys := make([]string, 0, len(downloadInfo.SigningKeys.GPGPublicKeys))
+	for _, key := range downloadInfo.SigningKeys.GPGPublicKeys {
+		keys = append(keys, key.ASCIIArmor)
+	}
+	filename := downloadInfo.Filename
+	if filename == "" {
+		filename = fmt.Sprintf("terraform-provider-%s_%s_%s_%s.zip", provider, version, platform.OS, platform.Arch)
+	}
+	// The signature covers the whole file, the checksum the registry reports must be listed as well
+	if err := verifier.Verify(sums, signature, keys, filename, downloadInfo.SHASum); err != nil {
+		return nil, fmt.Errorf("invalid upstream SHA256SUMS: %w", err)
+	}
+	return sums, nil
+}
+
+// compareListed compares the checksum of a cached binary with the one listed in the upstream SHA256SUMS
+func compareListed(sums []byte, filename, sha256 string) (status, detail string) {
+	err := verify.VerifyChecksumListed(sums, filename, sha256)
+	switch {
+	case err == nil:
+		return ConsistencyOK, ""
+	case errors.Is(err, errclass.ErrChecksum):
+		return ConsistencyDrift, "the signed upstream SHA256SUMS lists another checksum"
+	default:
+		// The platform isn't released anymore
+		return ConsistencyRemoved, err.Error()
+	}
+}
+
+// compareRecorded compares the checksum of a cached binary with the one recorded in its origin record, or the
+// transparency log, when it was cached
+func (h *RegistryHandler) compareRecorded(ctx context.Context, registry, namespace, provider string, artifact ArtifactConsistency) (status, detail string) {
+	var recorded string
+	if h.provenance != nil {
+		record, err := h.provenance.Load(ctx, artifact.Key)
+		if err != nil && !errors.Is(err, provenance.ErrNotFound) {
+			return ConsistencyError, fmt.Sprintf("failed to load origin record: %v", err)
+		}
+		if err == nil {
+			recorded = record.Verification.SHA256
+		}
+	}
+	if recorded == "" {
+		recorded, _ = h.recordedSHA256(registry, namespace, provider, artifact.Version, artifact.OS, artifact.Arch)
+	}
+	if recorded == "" {
+		return ConsistencyUnverified, ""
+	}
+	if !strings.EqualFold(recorded, artifact.SHA256) {
+		return ConsistencyDrift, fmt.Sprintf("recorded checksum is %s", strings.ToLower(recorded))
+	}
+	return ConsistencyRecorded, ""
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/devregistry"
+	"cachetf/internal/metadata"
+	"cachetf/internal/provenance"
+	"cachetf/internal/storage"
+)
+
+func TestVerifyConsistency(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	registry, err := devregistry.New(logger)
+	require.NoError(t, err)
+
+	store := storage.NewLocalStorage(t.TempDir(), logger)
+	handler := NewRegistryHandlerWithOptions(logger, store, RegistryOptions{
+		Transport:  registry.Transport(),
+		Provenance: provenance.NewStore(metadata.NewStore(store, logger)),
+	})
+	ctx := t.Context()
+
+	for _, platform := range []string{"linux_amd64", "darwin_arm64"} {
+		osName, arch, _ := strings.Cut(platform, "_")
+		require.NoError(t, handler.cacheProvider(ctx, "registry.terraform.io", "cachetf", "hello", "0.2.0", osName, arch, "test"))
+	}
+	require.NoError(t, handler.cacheProvider(ctx, "registry.terraform.io", "cachetf", "hello", "0.1.0", "linux", "amd64", "test"))
+
+	// Every cached binary is listed in the signed upstream checksums
+	report, err := handler.VerifyConsistency(ctx, "registry.terraform.io", "cachetf", "hello", true)
+	require.NoError(t, err)
+	assert.True(t, report.Consistent())
+	assert.Equal(t, map[string]int{ConsistencyOK: 3}, report.Counts)
+	require.Len(t, report.Artifacts, 3)
+	assert.Equal(t, "0.1.0", report.Artifacts[0].Version)
+	assert.Equal(t, "darwin", report.Artifacts[1].OS)
+	assert.Len(t, report.Artifacts[0].SHA256, 64)
+
+	// A binary changed in the cache drifted, one upstream doesn't publish was removed
+	tampered := handler.getCacheKey("registry.terraform.io", "cachetf", "hello", "0.2.0", "linux", "amd64")
+	require.NoError(t, store.Delete(ctx, tampered))
+	require.NoError(t, store.Put(ctx, tampered, strings.NewReader("tampered")))
+	removed := handler.getCacheKey("registry.terraform.io", "cachetf", "hello", "9.9.9", "linux", "amd64")
+	require.NoError(t, store.Put(ctx, removed, strings.NewReader("yanked")))
+
+	report, err = handler.VerifyConsistency(ctx, "registry.terraform.io", "cachetf", "hello", true)
+	require.NoError(t, err)
+	assert.False(t, report.Consistent())
+	assert.Equal(t, map[string]int{ConsistencyOK: 2, ConsistencyDrift: 1, ConsistencyRemoved: 1}, report.Counts)
+	for _, artifact := range report.Artifacts {
+		switch artifact.Key {
+		case tampered:
+			assert.Equal(t, ConsistencyDrift, artifact.Status)
+			assert.NotEmpty(t, artifact.Detail)
+		case removed:
+			assert.Equal(t, ConsistencyRemoved, artifact.Status)
+		}
+	}
+
+	// Offline, the binaries are compared with their origin records
+	report, err = handler.VerifyConsistency(ctx, "registry.terraform.io", "cachetf", "hello", false)
+	require.NoError(t, err)
+	assert.False(t, report.Online)
+	assert.Equal(t, map[string]int{ConsistencyRecorded: 2, ConsistencyDrift: 1, ConsistencyUnverified: 1}, report.Counts)
+
+	// Nothing cached, nothing to report
+	report, err = handler.VerifyConsistency(ctx, "registry.terraform.io", "cachetf", "echo", true)
+	require.NoError(t, err)
+	assert.True(t, report.Consistent())
+	assert.Empty(t, report.Artifacts)
+}
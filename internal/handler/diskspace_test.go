@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/storage"
+)
+
+func TestDownloadProvider_DiskFull(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var downloads int32
+	upstream := newPrewarmUpstream(t, &downloads)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	local := storage.NewLocalStorage(t.TempDir(), logger)
+	// No disk is large enough to keep the reserve
+	require.NoError(t, local.EnableDiskGuard(1<<62))
+	handler := NewRegistryHandler(logger, local)
+	handler.httpClient = upstream.Client()
+	router := newVerifyRouter(handler)
+
+	// The binary is served without being cached
+	registry := strings.TrimPrefix(upstream.URL, "https://")
+	for range 2 {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/"+registry+"/hashicorp/random/3.7.2/linux/amd64", nil)
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, "binary linux/amd64", w.Body.String())
+		assert.Equal(t, "18", w.Header().Get("Content-Length"))
+	}
+	assert.Equal(t, int32(2), downloads)
+
+	exists, err := local.Exists(t.Context(), handler.getCacheKey(registry, "hashicorp", "random", "3.7.2", "linux", "amd64"))
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
@@ -0,0 +1,267 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"cachetf/pkg/logger"
+	"cachetf/pkg/validate"
+)
+
+// userAgentOS maps the lowercase User-Agent tokens of operating systems to their Terraform names, matched in order
+var userAgentOS = []struct{ token, os string }{
+	{"windows", "windows"},
+	{"mac os x", "darwin"},
+	{"macintosh", "darwin"},
+	{"darwin", "darwin"},
+	{"freebsd", "freebsd"},
+	{"openbsd", "openbsd"},
+	{"sunos", "solaris"},
+	{"solaris", "solaris"},
+	{"linux", "linux"},
+}
+
+// userAgentArch maps the lowercase User-Agent tokens of architectures to their Terraform names, matched in order,
+// so the 64-bit tokens win over the tokens they contain
+var userAgentArch = []struct{ token, arch string }{
+	{"x86_64", "amd64"},
+	{"amd64", "amd64"},
+	{"win64", "amd64"},
+	{"x64", "amd64"},
+	{"aarch64", "arm64"},
+	{"arm64", "arm64"},
+	{"ppc64le", "ppc64le"},
+	{"armv7", "arm"},
+	{"armv6", "arm"},
+	{"i686", "386"},
+	{"i386", "386"},
+	{"x86", "386"},
+}
+
+// osAliases maps the lowercase operating system names reported by tools, e.g. uname -s or the Sec-CH-UA-Platform
+// client hint, to their Terraform names
+var osAliases = map[string]string{
+	"macos":      "darwin",
+	"mac os x":   "darwin",
+	"osx":        "darwin",
+	"sunos":      "solaris",
+	"windows_nt": "windows",
+	"win32":      "windows",
+	"win64":      "windows",
+}
+
+// windowsPrefixes are the prefixes of uname -s on the Unix environments of Windows, e.g. MINGW64_NT-10.0-19045 in
+// Git Bash
+var windowsPrefixes = []string{"mingw", "msys", "cygwin"}
+
+// archAliases maps the lowercase architecture names reported by tools, e.g. uname -m or Docker's TARGETARCH, to
+// their Terraform names
+var archAliases = map[string]string{
+	"x86_64":  "amd64",
+	"x86-64":  "amd64",
+	"x64":     "amd64",
+	"aarch64": "arm64",
+	"armv8":   "arm64",
+	// 32-bit userland on a 64-bit kernel
+	"armv8l":  "arm",
+	"armv7l":  "arm",
+	"armv7":   "arm",
+	"armv6l":  "arm",
+	"armv6":   "arm",
+	"armhf":   "arm",
+	"armel":   "arm",
+	"i386":    "386",
+	"i486":    "386",
+	"i586":    "386",
+	"i686":    "386",
+	"x86":     "386",
+	"ppc64el": "ppc64le",
+}
+
+// clientHints are the client hints giving the platform of browsers, Sec-CH-UA-Platform is sent by default, the
+// others once the server asked for them with Accept-CH
+const clientHints = "Sec-CH-UA-Platform, Sec-CH-UA-Arch, Sec-CH-UA-Bitness"
+
+// normalizeOS returns the Terraform name of an operating system name reported by a tool, the lowercase name if
+// unknown
+func normalizeOS(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if alias, ok := osAliases[name]; ok {
+		return alias
+	}
+	for _, prefix := range windowsPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return "windows"
+		}
+	}
+	return name
+}
+
+// normalizeArch returns the Terraform name of an architecture name reported by a tool, the lowercase name if
+// unknown. Docker variants are dropped, e.g. arm/v7 is arm.
+func normalizeArch(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	name, _, _ = strings.Cut(name, "/")
+	if alias, ok := archAliases[name]; ok {
+		return alias
+	}
+	return name
+}
+
+// parsePlatformParam parses the platform query parameter of a download, e.g. linux_amd64, linux/arm/v7 from Docker's
+// TARGETPLATFORM or "Linux x86_64" from uname -sm. Either name is empty if missing.
+func parsePlatformParam(value string) Platform {
+	value = strings.TrimSpace(value)
+	var osName, arch string
+	switch {
+	case strings.ContainsAny(value, " \t"):
+		// uname -s may contain underscores, e.g. MINGW64_NT-10.0, uname -m never contains spaces
+		fields := strings.Fields(value)
+		osName, arch = fields[0], fields[len(fields)-1]
+	case strings.Contains(value, "/"):
+		osName, arch, _ = strings.Cut(value, "/")
+	default:
+		// Architectures may contain underscores, e.g. x86_64, operating systems don't
+		osName, arch, _ = strings.Cut(value, "_")
+	}
+	return Platform{OS: normalizeOS(osName), Arch: normalizeArch(arch)}
+}
+
+// detectHintsPlatform infers the platform of a browser from its client hints, e.g. Sec-CH-UA-Platform: "macOS",
+// Sec-CH-UA-Arch: "arm" and Sec-CH-UA-Bitness: "64". The architecture is empty without the bitness.
+func detectHintsPlatform(header http.Header) Platform {
+	hint := func(name string) string {
+		return strings.ToLower(strings.Trim(strings.TrimSpace(header.Get(name)), `"`))
+	}
+	var platform Platform
+	if name := hint("Sec-CH-UA-Platform"); name != "" {
+		platform.OS = normalizeOS(name)
+	}
+	switch arch, bitness := hint("Sec-CH-UA-Arch"), hint("Sec-CH-UA-Bitness"); {
+	case arch == "x86" && bitness == "64":
+		platform.Arch = "amd64"
+	case arch == "x86" && bitness == "32":
+		platform.Arch = "386"
+	case arch == "arm" && bitness == "64":
+		platform.Arch = "arm64"
+	case arch == "arm" && bitness == "32":
+		platform.Arch = "arm"
+	}
+	return platform
+}
+
+// requestedPlatform returns the platform of a download request, given by the route, the platform query parameter or
+// the os and arch query parameters, e.g. from uname -s and uname -m. The names are mapped to the Terraform ones,
+// missing names are inferred from the client hints, then the User-Agent, inferred is true if they were looked up.
+func requestedPlatform(c *gin.Context) (platform Platform, inferred bool) {
+	platform = Platform{OS: c.GetString("os"), Arch: c.GetString("arch")}
+	if platform.OS == "" {
+		platform = parsePlatformParam(c.Query("platform"))
+		if platform.OS == "" {
+			platform.OS = c.Query("os")
+		}
+		if platform.Arch == "" {
+			platform.Arch = c.Query("arch")
+		}
+	}
+	platform = Platform{OS: normalizeOS(platform.OS), Arch: normalizeArch(platform.Arch)}
+	if platform.OS != "" && platform.Arch != "" {
+		return platform, false
+	}
+
+	// Client hints are more precise, e.g. browsers on Macs report Intel in the User-Agent whatever the architecture
+	for _, detected := range []Platform{detectHintsPlatform(c.Request.Header), DetectPlatform(c.GetHeader("User-Agent"))} {
+		if platform.OS == "" {
+			platform.OS = detected.OS
+		}
+		if platform.Arch == "" {
+			platform.Arch = detected.Arch
+		}
+	}
+	return platform, true
+}
+
+// DetectPlatform infers the platform of a client from its User-Agent, e.g. "curl/8.5.0 (x86_64-pc-linux-gnu)" or
+// "Mozilla/5.0 (X11; Linux aarch64)". Either name is empty if it can't be inferred, e.g. Macs report Intel in
+// browser User-Agents whatever their architecture.
+func DetectPlatform(userAgent string) Platform {
+	ua := strings.ToLower(userAgent)
+	var platform Platform
+	for _, candidate := range userAgentOS {
+		if strings.Contains(ua, candidate.token) {
+			platform.OS = candidate.os
+			break
+		}
+	}
+	for _, candidate := range userAgentArch {
+		if strings.Contains(ua, candidate.token) {
+			platform.Arch = candidate.arch
+			break
+		}
+	}
+	return platform
+}
+
+// RedirectDownload handles GET .../:version/download requests, redirecting to the binary of the platform given by
+// the route, or by the platform, os and arch query parameters. Missing parameters are inferred from the client hints
+// and the User-Agent, so scripts don't have to build the file name of the binary. The latest version resolves to the
+// newest release.
+func (h *RegistryHandler) RedirectDownload(c *gin.Context) {
+	registry := c.Param("registry")
+	namespace := c.Param("namespace")
+	provider := c.Param("provider")
+	requested := c.GetString("version")
+
+	if !h.isAllowedRegistry(registry) || !validate.Namespace(namespace) || !validate.Provider(provider) ||
+		(requested != LatestVersion && !validate.Version(requested)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid parameters"})
+		return
+	}
+
+	platform, inferred := requestedPlatform(c)
+	if inferred {
+		// The response depends on the User-Agent and the client hints, browsers only send some of them on request
+		c.Header("Vary", "User-Agent")
+		c.Writer.Header().Add("Vary", clientHints)
+		c.Header("Accept-CH", clientHints)
+	}
+	if platform.OS == "" || platform.Arch == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "the platform can't be inferred from the User-Agent, set the os and arch query parameters"})
+		return
+	}
+	if !validate.OS(platform.OS) || !validate.Arch(platform.Arch) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unsupported platform %s", platform)})
+		return
+	}
+
+	version := requested
+	if requested == LatestVersion {
+		var platforms []Platform
+		var ok bool
+		if version, platforms, ok = h.resolveLatest(c, registry, namespace, provider); !ok {
+			return
+		}
+		if !slices.Contains(platforms, platform) {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("version %s has no %s binary", version, platform)})
+			return
+		}
+	}
+
+	// Binaries are served next to the version documents, under whatever prefix the request was routed with
+	filename := fmt.Sprintf("terraform-provider-%s_%s_%s.zip", provider, version, platform)
+	base := c.Request.URL.Path[:strings.LastIndex(c.Request.URL.Path, "/"+requested+"/download")+1]
+
+	logger.Debug(h.logger, "Redirecting download to the provider binary", func() logrus.Fields {
+		return logrus.Fields{
+			"provider": registry + "/" + namespace + "/" + provider,
+			"version":  version,
+			"platform": platform.String(),
+		}
+	})
+	c.Redirect(http.StatusFound, base+filename)
+}
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectPlatform(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		expected  Platform
+	}{
+		{"curl/8.5.0 (x86_64-pc-linux-gnu)", Platform{OS: "linux", Arch: "amd64"}},
+		{"curl/8.5.0 (aarch64-unknown-linux-gnu)", Platform{OS: "linux", Arch: "arm64"}},
+		{"Wget/1.21.4 (linux-gnueabihf) armv7l", Platform{OS: "linux", Arch: "arm"}},
+		{"Mozilla/5.0 (X11; Linux i686; rv:109.0) Gecko/20100101 Firefox/115.0", Platform{OS: "linux", Arch: "386"}},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36", Platform{OS: "windows", Arch: "amd64"}},
+		{"Mozilla/5.0 (X11; FreeBSD amd64; rv:120.0) Gecko/20100101 Firefox/120.0", Platform{OS: "freebsd", Arch: "amd64"}},
+		{"curl/8.4.0 (arm64-apple-darwin23.0)", Platform{OS: "darwin", Arch: "arm64"}},
+		// Browsers on Macs report Intel whatever the architecture
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15", Platform{OS: "darwin"}},
+		{"Terraform/1.9.0 (+https://www.terraform.io)", Platform{}},
+		{"", Platform{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.userAgent, func(t *testing.T) {
+			assert.Equal(t, tt.expected, DetectPlatform(tt.userAgent))
+		})
+	}
+}
+
+func TestParsePlatformParam(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected Platform
+	}{
+		{"linux_amd64", Platform{OS: "linux", Arch: "amd64"}},
+		{"linux_x86_64", Platform{OS: "linux", Arch: "amd64"}},
+		// Docker's TARGETPLATFORM, variants are dropped
+		{"linux/arm64", Platform{OS: "linux", Arch: "arm64"}},
+		{"linux/arm/v7", Platform{OS: "linux", Arch: "arm"}},
+		{"linux/arm64/v8", Platform{OS: "linux", Arch: "arm64"}},
+		// uname -sm
+		{"Linux x86_64", Platform{OS: "linux", Arch: "amd64"}},
+		{"Linux aarch64", Platform{OS: "linux", Arch: "arm64"}},
+		{"Linux armv7l", Platform{OS: "linux", Arch: "arm"}},
+		{"Darwin arm64", Platform{OS: "darwin", Arch: "arm64"}},
+		{"MINGW64_NT-10.0-19045 x86_64", Platform{OS: "windows", Arch: "amd64"}},
+		{"FreeBSD i686", Platform{OS: "freebsd", Arch: "386"}},
+		{"linux", Platform{OS: "linux"}},
+		{"", Platform{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			assert.Equal(t, tt.expected, parsePlatformParam(tt.value))
+		})
+	}
+}
+
+func TestDetectHintsPlatform(t *testing.T) {
+	header := http.Header{}
+	header.Set("Sec-CH-UA-Platform", `"macOS"`)
+	header.Set("Sec-CH-UA-Arch", `"arm"`)
+	header.Set("Sec-CH-UA-Bitness", `"64"`)
+	assert.Equal(t, Platform{OS: "darwin", Arch: "arm64"}, detectHintsPlatform(header))
+
+	header.Set("Sec-CH-UA-Platform", `"Windows"`)
+	header.Set("Sec-CH-UA-Arch", `"x86"`)
+	header.Set("Sec-CH-UA-Bitness", `"32"`)
+	assert.Equal(t, Platform{OS: "windows", Arch: "386"}, detectHintsPlatform(header))
+
+	// The architecture is ambiguous without the bitness
+	header.Del("Sec-CH-UA-Bitness")
+	assert.Equal(t, Platform{OS: "windows"}, detectHintsPlatform(header))
+	assert.Equal(t, Platform{}, detectHintsPlatform(http.Header{}))
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"cachetf/internal/bundle"
+)
+
+// ExportCache streams a tar.gz bundle of the artifacts under the prefix query parameters, every artifact
+// if there are none, with their origin records and persisted indexes
+func (h *CacheHandler) ExportCache(c *gin.Context) {
+	prefixes := c.QueryArray("prefix")
+	for _, prefix := range prefixes {
+		if err := bundle.ValidatePrefix(prefix); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	filename := fmt.Sprintf("cachetf-%s.tar.gz", time.Now().UTC().Format("20060102T150405Z"))
+	c.Header("Content-Type", "application/gzip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Status(http.StatusOK)
+
+	// The status is sent already, a failure leaves a truncated bundle that doesn't pass import
+	if _, err := bundle.NewExporter(h.storage, h.logger).Export(c.Request.Context(), c.Writer, prefixes); err != nil {
+		h.logger.WithError(err).Error("Failed to export cache")
+		c.Abort()
+	}
+}
+
+// ImportCache writes the artifacts of the tar.gz bundle in the request body to the cache. The bundle is
+// rejected as a whole if a file doesn't match the manifest checksums.
+func (h *CacheHandler) ImportCache(c *gin.Context) {
+	result, err := bundle.NewImporter(h.storage, h.logger).Import(c.Request.Context(), c.Request.Body)
+	if err != nil {
+		if errors.Is(err, bundle.ErrInvalidBundle) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.WithError(err).Error("Failed to import cache bundle")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to import cache bundle"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
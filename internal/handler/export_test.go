@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/bundle"
+	"cachetf/internal/storage"
+)
+
+func TestExportCache(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	store := storage.NewLocalStorage(t.TempDir(), logger)
+	for _, key := range []string{
+		"providers/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip",
+		"providers/registry.terraform.io/hashicorp/random/3.7.2/terraform-provider-random_3.7.2_linux_amd64.zip",
+	} {
+		require.NoError(t, store.Put(t.Context(), key, strings.NewReader("binary")))
+	}
+
+	router := gin.New()
+	router.GET("/cache/export", NewCacheHandler(store, logger).ExportCache)
+
+	t.Run("selected prefixes", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/cache/export?prefix=providers/registry.terraform.io/hashicorp/random", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/gzip", w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Header().Get("Content-Disposition"), "attachment")
+
+		gz, err := gzip.NewReader(w.Body)
+		require.NoError(t, err)
+		tr := tar.NewReader(gz)
+
+		var names []string
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			names = append(names, header.Name)
+		}
+		assert.Equal(t, []string{
+			"providers/registry.terraform.io/hashicorp/random/3.7.2/terraform-provider-random_3.7.2_linux_amd64.zip",
+			bundle.ManifestName,
+		}, names)
+	})
+
+	t.Run("invalid prefix", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/cache/export?prefix=metadata/auth", nil))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "invalid bundle prefix")
+	})
+}
+
+func TestImportCache(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	key := "providers/registry.terraform.io/hashicorp/random/3.7.2/terraform-provider-random_3.7.2_linux_amd64.zip"
+	source := storage.NewLocalStorage(t.TempDir(), logger)
+	require.NoError(t, source.Put(t.Context(), key, strings.NewReader("binary")))
+	var buf bytes.Buffer
+	_, err := bundle.NewExporter(source, logger).Export(t.Context(), &buf, nil)
+	require.NoError(t, err)
+
+	target := storage.NewLocalStorage(t.TempDir(), logger)
+	router := gin.New()
+	router.POST("/cache/import", NewCacheHandler(target, logger).ImportCache)
+
+	t.Run("valid bundle", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/cache/import", bytes.NewReader(buf.Bytes())))
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"files": 1, "imported": 1, "skipped": 0}`, w.Body.String())
+
+		exists, err := target.Exists(t.Context(), key)
+		require.NoError(t, err)
+		assert.True(t, exists)
+	})
+
+	t.Run("invalid bundle", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/cache/import", strings.NewReader("not a bundle")))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "invalid bundle")
+	})
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/middleware"
+	"cachetf/internal/upstream"
+)
+
+const (
+	// forwardedAccessTTL is how long the upstream registry's answer to an access check is trusted
+	forwardedAccessTTL = time.Minute
+	// forwardedAccessEntries is the number of access checks remembered
+	forwardedAccessEntries = 1000
+)
+
+// authorizeForwarded checks that the caller may access a provider of a registry configured to forward the
+// caller's bearer token. The cached files of such providers are only served to callers the upstream registry
+// lets list the provider versions, the answer is remembered for forwardedAccessTTL. The returned context forwards
+// the token with the upstream requests made with it. It responds and returns false if access is denied.
+// Registries that don't forward tokens are always allowed, callers of the ones that do are refused in offline mode.
+func (h *RegistryHandler) authorizeForwarded(c *gin.Context, ctx context.Context, registry, namespace, provider string) (context.Context, bool) {
+	if !h.registries.ForwardsAuthorization(registry) {
+		return ctx, true
+	}
+
+	header := middleware.ForwardedAuthorization(c)
+	if header == "" {
+		c.Header("WWW-Authenticate", "Bearer")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "upstream credentials required"})
+		return ctx, false
+	}
+	ctx = upstream.WithAuthorization(ctx, header)
+
+	// Tokens are only kept hashed
+	sum := sha256.Sum256([]byte(header))
+	key := hex.EncodeToString(sum[:]) + "/" + h.keys.ProviderPrefix(registry, namespace, provider)
+	if _, ok := h.forwardedAccess.get(key); ok {
+		return ctx, true
+	}
+
+	if _, err := h.fetchProviderVersions(ctx, registry, namespace, provider); err != nil {
+		var statusErr *upstreamStatusError
+		if errors.As(err, &statusErr) && (statusErr.StatusCode == http.StatusUnauthorized ||
+			statusErr.StatusCode == http.StatusForbidden || statusErr.StatusCode == http.StatusNotFound) {
+			h.logger.WithFields(logrus.Fields{
+				"registry":  registry,
+				"namespace": namespace,
+				"provider":  provider,
+				"status":    statusErr.Status,
+			}).Info("Upstream registry denied access to provider")
+			if statusErr.StatusCode == http.StatusUnauthorized {
+				c.Header("WWW-Authenticate", "Bearer")
+			}
+			c.JSON(statusErr.StatusCode, gin.H{"error": "upstream registry denied access"})
+			return ctx, false
+		}
+		h.respondVersionsError(c, err)
+		return ctx, false
+	}
+	h.forwardedAccess.put(key, nil)
+	return ctx, true
+}
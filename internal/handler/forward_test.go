@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/storage"
+	"cachetf/internal/upstream"
+)
+
+// newPrivateRegistry serves the acme/tool provider to the callers sending the bearer token "good", counting the
+// requests of the versions list and the binary
+func newPrivateRegistry(t *testing.T, versionsRequests, downloads *atomic.Int32) *httptest.Server {
+	t.Helper()
+	binary := []byte("private provider binary")
+	sum := sha256.Sum256(binary)
+
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Authorization") {
+		case "Bearer good":
+		case "":
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/providers/acme/tool/versions":
+			versionsRequests.Add(1)
+			_, _ = w.Write([]byte(`{"versions":[{"version":"1.0.0","platforms":[{"os":"linux","arch":"amd64"}]}]}`))
+		case "/v1/providers/acme/tool/1.0.0/download/linux/amd64":
+			fmt.Fprintf(w, `{"os":"linux","arch":"amd64","filename":"terraform-provider-tool_1.0.0_linux_amd64.zip","download_url":"%s/files/tool.zip","shasum":"%s"}`,
+				server.URL, hex.EncodeToString(sum[:]))
+		case "/files/tool.zip":
+			downloads.Add(1)
+			_, _ = w.Write(binary)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestAuthorizeForwarded(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var versionsRequests, downloads atomic.Int32
+	server := newPrivateRegistry(t, &versionsRequests, &downloads)
+	registries, err := upstream.NewRegistries([]upstream.Registry{
+		{Host: "private.example.com", BaseURL: server.URL, ForwardAuthorization: true},
+	})
+	require.NoError(t, err)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	handler := NewRegistryHandlerWithOptions(logger, storage.NewLocalStorage(t.TempDir(), logger), RegistryOptions{
+		Transport:      server.Client().Transport,
+		Registries:     registries,
+		IndexCacheSize: 10,
+		IndexCacheTTL:  time.Minute,
+	})
+
+	request := func(authorization string, serve func(*gin.Context)) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Params = gin.Params{
+			{Key: "registry", Value: "private.example.com"},
+			{Key: "namespace", Value: "acme"},
+			{Key: "provider", Value: "tool"},
+		}
+		c.Request, _ = http.NewRequest("GET", "/", nil)
+		if authorization != "" {
+			c.Request.Header.Set("Authorization", authorization)
+		}
+		c.Set("version", "1.0.0")
+		c.Set("os", "linux")
+		c.Set("arch", "amd64")
+		serve(c)
+		return w
+	}
+
+	// Callers without a token are asked for one, upstream denials are passed on
+	w := request("", handler.DownloadProvider)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+	w = request("Bearer bad", handler.DownloadProvider)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.JSONEq(t, `{"error":"upstream registry denied access"}`, w.Body.String())
+	assert.Zero(t, downloads.Load())
+
+	// The binary is downloaded with the caller's token
+	w = request("Bearer good", handler.DownloadProvider)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "private provider binary", w.Body.String())
+	assert.Equal(t, int32(1), downloads.Load())
+
+	// The cached binary isn't served to other callers
+	w = request("Bearer bad", handler.DownloadProvider)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = request("Bearer good", handler.DownloadProvider)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int32(1), downloads.Load())
+
+	// Neither are the cached indexes, the access of the caller is remembered
+	before := versionsRequests.Load()
+	w = request("Bearer good", handler.GetProviderIndex)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"versions":{"1.0.0":{}}}`, w.Body.String())
+	assert.Equal(t, before+1, versionsRequests.Load())
+	w = request("Bearer bad", handler.GetProviderIndex)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = request("", handler.GetProviderVersion)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAuthorizeForwarded_NotForwarding(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	registries, err := upstream.NewRegistries([]upstream.Registry{{Host: "private.example.com"}})
+	require.NoError(t, err)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	handler := NewRegistryHandlerWithOptions(logger, storage.NewLocalStorage(t.TempDir(), logger), RegistryOptions{
+		Registries: registries,
+	})
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/", nil)
+	ctx, ok := handler.authorizeForwarded(c, c.Request.Context(), "private.example.com", "acme", "tool")
+	assert.True(t, ok)
+	assert.Equal(t, c.Request.Context(), ctx)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,27 @@
+package handler
+
+import (
+	"time"
+
+	"cachetf/internal/metrics"
+)
+
+// Stages of the provider binary miss path, timed in cache_miss_stage_duration_seconds
+const (
+	// stageMetadata fetches the download information from the upstream registry
+	stageMetadata = "metadata"
+	// stageVerify checks the signature of the upstream checksums
+	stageVerify = "verify"
+	// stageDownload downloads the binary and checks its checksum
+	stageDownload = "download"
+	// stageStore writes the binary to the storage backend
+	stageStore = "store"
+	// stageStream sends the freshly cached binary to the client
+	stageStream = "stream"
+)
+
+// observeStage records the duration of a completed stage of the miss path started at start.
+// Failed stages aren't observed, they are counted in cache_errors_total instead.
+func observeStage(stage string, start time.Time) {
+	metrics.MissStageDuration.WithLabelValues(stage).Observe(time.Since(start).Seconds())
+}
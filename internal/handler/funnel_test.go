@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/metrics"
+	"cachetf/internal/storage"
+)
+
+// stageCount returns the number of observations of a miss path stage
+func stageCount(t *testing.T, stage string) uint64 {
+	t.Helper()
+	var m dto.Metric
+	require.NoError(t, metrics.MissStageDuration.WithLabelValues(stage).(prometheus.Histogram).Write(&m))
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestDownloadProvider_MissStages(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var downloads int32
+	upstream := newPrewarmUpstream(t, &downloads)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	handler := NewRegistryHandler(logger, storage.NewLocalStorage(t.TempDir(), logger))
+	handler.httpClient = upstream.Client()
+	router := newVerifyRouter(handler)
+
+	stages := []string{stageMetadata, stageDownload, stageStore, stageStream}
+	before := make(map[string]uint64)
+	for _, stage := range append(stages, stageVerify) {
+		before[stage] = stageCount(t, stage)
+	}
+
+	registry := strings.TrimPrefix(upstream.URL, "https://")
+	for range 2 {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/"+registry+"/hashicorp/random/3.7.2/linux/amd64", nil)
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+
+	// Only the miss is observed, the hit doesn't go through the funnel
+	for _, stage := range stages {
+		assert.Equal(t, before[stage]+1, stageCount(t, stage), stage)
+	}
+	// Signatures aren't verified without a verifier
+	assert.Equal(t, before[stageVerify], stageCount(t, stageVerify))
+}
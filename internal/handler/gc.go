@@ -0,0 +1,212 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/layout"
+	"cachetf/internal/metadata"
+	"cachetf/internal/metrics"
+	"cachetf/internal/storage"
+)
+
+// GCOptions controls where the garbage collector lists the cached artifacts from
+type GCOptions struct {
+	// Source is the storage the cached artifacts are listed from, the storage of the handler if nil. Listing the
+	// backend sees the files deleted or added behind the back of the instance, e.g. by bucket operations.
+	Source storage.Storage
+}
+
+// GCResult counts the metadata documents and artifacts found out of sync by a garbage collection
+type GCResult struct {
+	// OrphanedRecords is the number of origin records deleted because their artifact is gone
+	OrphanedRecords int `json:"orphanedRecords"`
+	// OrphanedIndexes is the number of persisted provider indexes deleted because no file of the provider is cached
+	OrphanedIndexes int `json:"orphanedIndexes"`
+	// Unrecorded is the number of cached binaries and archives without an origin record, which are kept
+	Unrecorded int `json:"unrecorded"`
+	// Failed is the number of documents that couldn't be checked or deleted
+	Failed int `json:"failed"`
+}
+
+// CollectGarbage reconciles the metadata documents with the cached artifacts, keeping listings truthful after
+// files were deleted or added outside of the proxy. Origin records of artifacts that are gone and persisted indexes
+// of providers without any cached file are deleted, and artifacts cached without an origin record are reported.
+// Every tenant sharing the storage is reconciled.
+func (h *RegistryHandler) CollectGarbage(ctx context.Context, opts GCOptions) (GCResult, error) {
+	var result GCResult
+	source := opts.Source
+	if source == nil {
+		source = h.storage
+	}
+
+	start := time.Now()
+	// artifacts holds the keys of the cached files, indexed the indexes of the providers with a cached file
+	artifacts := make(map[string]struct{})
+	indexed := make(map[string]struct{})
+	for _, scheme := range []layout.Scheme{layout.Providers, layout.Modules} {
+		err := storage.Walk(ctx, source, scheme.Prefix(), func(obj storage.ObjectInfo) error {
+			artifacts[obj.Key] = struct{}{}
+			if provider, _, _, ok := layout.ProviderFileOf(obj.Key); ok {
+				indexed[providerIndexDocument(provider)] = struct{}{}
+			}
+			return nil
+		})
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if h.provenance != nil {
+		if err := h.collectOrigins(ctx, source, artifacts, &result); err != nil {
+			return result, err
+		}
+	}
+	if err := h.collectIndexes(ctx, start, indexed, &result); err != nil {
+		return result, err
+	}
+
+	metrics.GCLastRunTimestamp.SetToCurrentTime()
+	h.logger.WithFields(logrus.Fields{
+		"artifacts":       len(artifacts),
+		"orphanedRecords": result.OrphanedRecords,
+		"orphanedIndexes": result.OrphanedIndexes,
+		"unrecorded":      result.Unrecorded,
+		"failed":          result.Failed,
+		"duration":        time.Since(start),
+	}).Info("Collected orphaned metadata")
+	return result, nil
+}
+
+// collectOrigins deletes the origin records of the artifacts that aren't cached anymore, and counts the binaries
+// and archives cached without one
+func (h *RegistryHandler) collectOrigins(ctx context.Context, source storage.Storage, artifacts map[string]struct{}, result *GCResult) error {
+	keys, err := h.provenance.Keys(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	recorded := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		recorded[key] = struct{}{}
+		// Records of keys outside of the schemes, e.g. of artifacts not migrated yet, are left alone
+		if scheme, ok := layout.SchemeOf(key); !ok || (scheme != layout.Providers && scheme != layout.Modules) {
+			continue
+		}
+		if _, ok := artifacts[key]; ok {
+			continue
+		}
+
+		logger := h.logger.WithField("key", key)
+		// The artifact may have been cached since it was listed
+		exists, err := source.Exists(ctx, key)
+		if err == nil && !exists {
+			err = h.provenance.Delete(ctx, key)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			logger.WithError(err).Warn("Failed to collect origin record")
+			result.Failed++
+			metrics.GCFilesTotal.WithLabelValues("failed").Inc()
+			continue
+		}
+		if !exists {
+			logger.Info("Deleted origin record of artifact no longer cached")
+			result.OrphanedRecords++
+			metrics.GCFilesTotal.WithLabelValues("orphaned_record").Inc()
+		}
+	}
+
+	for key := range artifacts {
+		if _, ok := recorded[key]; ok || !hasOrigin(key) {
+			continue
+		}
+		h.logger.WithField("key", key).Debug("Cached artifact has no origin record")
+		result.Unrecorded++
+		metrics.GCFilesTotal.WithLabelValues("unrecorded").Inc()
+	}
+	return nil
+}
+
+// collectIndexes deletes the persisted indexes of the providers without any cached file. Indexes persisted since
+// start are kept, their provider may be being downloaded.
+func (h *RegistryHandler) collectIndexes(ctx context.Context, start time.Time, indexed map[string]struct{}, result *GCResult) error {
+	names, err := h.indexes.List(ctx, indexDocumentPrefix)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if _, ok := indexed[name]; ok {
+			continue
+		}
+		logger := h.logger.WithField("document", name)
+
+		var index persistedIndex
+		err := h.indexes.Load(ctx, name, &index)
+		switch {
+		case errors.Is(err, metadata.ErrNotFound):
+			continue
+		case err == nil && !index.FetchedAt.Before(start):
+			continue
+		case err == nil:
+			err = h.indexes.Delete(ctx, name)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			logger.WithError(err).Warn("Failed to collect persisted provider index")
+			result.Failed++
+			metrics.GCFilesTotal.WithLabelValues("failed").Inc()
+			continue
+		}
+		logger.Info("Deleted persisted index of provider without cached files")
+		result.OrphanedIndexes++
+		metrics.GCFilesTotal.WithLabelValues("orphaned_index").Inc()
+	}
+	return nil
+}
+
+// RunGC collects orphaned metadata every interval until ctx is cancelled
+func (h *RegistryHandler) RunGC(ctx context.Context, interval time.Duration, opts GCOptions) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := h.CollectGarbage(ctx, opts); err != nil && ctx.Err() == nil {
+			h.logger.WithError(err).Warn("Failed to collect orphaned metadata")
+		}
+	}
+}
+
+// providerIndexDocument returns the name of the persisted index of the provider of a key prefix, such as
+// providers/registry/namespace/provider/. Indexes are shared by the tenants, so the tenant is dropped.
+func providerIndexDocument(prefix string) string {
+	segments := strings.Split(strings.TrimSuffix(prefix, "/"), "/")
+	n := len(segments)
+	return indexDocument(segments[n-3], segments[n-2], segments[n-1])
+}
+
+// hasOrigin returns true for the keys of the artifacts an origin record is saved for: provider binaries and module
+// archives
+func hasOrigin(key string) bool {
+	if _, _, filename, ok := layout.ProviderFileOf(key); ok {
+		return strings.HasSuffix(filename, ".zip")
+	}
+	scheme, ok := layout.SchemeOf(key)
+	return ok && scheme == layout.Modules && strings.HasPrefix(path.Base(key), "archive.")
+}
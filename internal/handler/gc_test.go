@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/metadata"
+	"cachetf/internal/metrics"
+	"cachetf/internal/provenance"
+	"cachetf/internal/storage"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	store := storage.NewLocalStorage(t.TempDir(), logger)
+	handler := NewRegistryHandlerWithOptions(logger, store, RegistryOptions{
+		Provenance: provenance.NewStore(metadata.NewStore(store, logger)),
+	})
+	ctx := t.Context()
+
+	registry := "registry.terraform.io"
+	cached := handler.getCacheKey(registry, "hashicorp", "aws", "5.0.0", "linux", "amd64")
+	deleted := handler.getCacheKey(registry, "hashicorp", "google", "6.0.0", "linux", "amd64")
+	unrecorded := handler.getCacheKey(registry, "hashicorp", "aws", "5.0.0", "darwin", "arm64")
+	legacy := "registry.terraform.io/hashicorp/null/3.0.0/terraform-provider-null_3.0.0_linux_amd64.zip"
+	archive := "modules/hashicorp/consul/aws/0.1.0/archive.tar.gz"
+
+	for _, key := range []string{cached, unrecorded, archive} {
+		require.NoError(t, store.Put(ctx, key, strings.NewReader("content")))
+	}
+	for _, key := range []string{cached, deleted, legacy, archive} {
+		require.NoError(t, handler.provenance.Save(ctx, &provenance.Record{Key: key}))
+	}
+
+	// The provider of the deleted binary has no cached file left, the index of random was just persisted
+	response := &ProviderVersionsResponse{}
+	handler.saveIndex(ctx, registry, "hashicorp", "aws", response)
+	handler.saveIndex(ctx, registry, "hashicorp", "google", response)
+	require.NoError(t, handler.indexes.Save(ctx, indexDocument(registry, "hashicorp", "random"), persistedIndex{
+		FetchedAt: time.Now().Add(time.Hour),
+	}))
+
+	orphaned := testutil.ToFloat64(metrics.GCFilesTotal.WithLabelValues("orphaned_record"))
+	result, err := handler.CollectGarbage(ctx, GCOptions{Source: store})
+	require.NoError(t, err)
+	assert.Equal(t, GCResult{OrphanedRecords: 1, OrphanedIndexes: 1, Unrecorded: 1}, result)
+	assert.Equal(t, orphaned+1, testutil.ToFloat64(metrics.GCFilesTotal.WithLabelValues("orphaned_record")))
+
+	keys, err := handler.provenance.Keys(ctx, "")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{cached, legacy, archive}, keys)
+
+	_, err = handler.loadIndex(ctx, registry, "hashicorp", "aws")
+	assert.NoError(t, err)
+	_, err = handler.loadIndex(ctx, registry, "hashicorp", "google")
+	assert.ErrorIs(t, err, metadata.ErrNotFound)
+	_, err = handler.loadIndex(ctx, registry, "hashicorp", "random")
+	assert.NoError(t, err)
+
+	// The artifacts are never deleted
+	for _, key := range []string{cached, unrecorded, archive} {
+		exists, err := store.Exists(ctx, key)
+		require.NoError(t, err)
+		assert.True(t, exists, key)
+	}
+
+	// Once collected, the metadata is in sync
+	result, err = handler.CollectGarbage(ctx, GCOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, GCResult{Unrecorded: 1}, result)
+}
+
+func TestProviderIndexDocument(t *testing.T) {
+	expected := indexDocument("registry.terraform.io", "hashicorp", "aws")
+	assert.Equal(t, expected, providerIndexDocument("providers/registry.terraform.io/hashicorp/aws/"))
+	assert.Equal(t, expected, providerIndexDocument("providers/_tenants/acme/registry.terraform.io/hashicorp/aws/"))
+}
@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	"cachetf/internal/layout"
+)
+
+// indexDocumentPrefix is the metadata prefix the upstream provider indexes are persisted under
+const indexDocumentPrefix = "indexes/"
+
+// persistedIndex is the last upstream versions response of a provider
+type persistedIndex struct {
+	FetchedAt time.Time                `json:"fetchedAt"`
+	Response  ProviderVersionsResponse `json:"response"`
+}
+
+// indexDocument returns the name of the metadata document persisting the index of a provider
+func indexDocument(registry, namespace, provider string) string {
+	return indexDocumentPrefix + layout.Providers.Key(registry, namespace, provider) + ".json"
+}
+
+// saveIndex persists the upstream versions response of a provider, so it outlives restarts and upstream outages
+func (h *RegistryHandler) saveIndex(ctx context.Context, registry, namespace, provider string, response *ProviderVersionsResponse) {
+	index := persistedIndex{
+		FetchedAt: time.Now().UTC(),
+		Response:  *response,
+	}
+	if err := h.indexes.Save(ctx, indexDocument(registry, namespace, provider), index); err != nil {
+		h.logger.WithError(err).WithField("provider", provider).Warn("Failed to persist provider index")
+	}
+}
+
+// loadIndex returns the persisted upstream versions response of a provider, or metadata.ErrNotFound
+func (h *RegistryHandler) loadIndex(ctx context.Context, registry, namespace, provider string) (*persistedIndex, error) {
+	var index persistedIndex
+	if err := h.indexes.Load(ctx, indexDocument(registry, namespace, provider), &index); err != nil {
+		return nil, err
+	}
+	return &index, nil
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/metadata"
+	"cachetf/internal/storage"
+)
+
+func TestProviderIndexPersistence(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	upstream := newIndexUpstream(t, &status)
+	registry := strings.TrimPrefix(upstream.URL, "https://")
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	store := storage.NewLocalStorage(t.TempDir(), logger)
+	handler := NewRegistryHandler(logger, store)
+	handler.httpClient = upstream.Client()
+
+	_, err := handler.loadIndex(t.Context(), registry, "hashicorp", "random")
+	assert.ErrorIs(t, err, metadata.ErrNotFound)
+
+	w := httptest.NewRecorder()
+	newOfflineRouter(handler).ServeHTTP(w, httptest.NewRequest("GET", "/"+registry+"/hashicorp/random/index.json", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// The index is kept in the metadata key space of the storage
+	exists, err := store.Exists(t.Context(), metadata.KeyPrefix+"indexes/providers/"+registry+"/hashicorp/random.json")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	// and outlives the handler
+	restarted := NewRegistryHandler(logger, store)
+	index, err := restarted.loadIndex(t.Context(), registry, "hashicorp", "random")
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), index.FetchedAt, time.Minute)
+	require.Len(t, index.Response.Versions, 1)
+	assert.Equal(t, "3.7.2", index.Response.Versions[0].Version)
+	assert.Equal(t, "linux", index.Response.Versions[0].Platforms[0].OS)
+}
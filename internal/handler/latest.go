@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/semver"
+	"cachetf/pkg/logger"
+	"cachetf/pkg/validate"
+)
+
+// LatestVersion is the version segment resolving to the newest release of a provider
+const LatestVersion = "latest"
+
+// LatestResponse is the response of the latest version endpoint, the archives of the version document with the
+// version they're of
+type LatestResponse struct {
+	Version  string                 `json:"version"`
+	Archives map[string]ArchiveInfo `json:"archives"`
+}
+
+// latestRelease returns the newest version of versions that isn't a pre-release and meets the constraints, false if
+// there's none
+func latestRelease(versions map[string][]Platform, constraints semver.Constraints) (string, bool) {
+	var releases []semver.Version
+	for value := range versions {
+		v, err := semver.Parse(value)
+		if err != nil || v.Prerelease != "" || !constraints.Check(v) {
+			continue
+		}
+		releases = append(releases, v)
+	}
+	if len(releases) == 0 {
+		return "", false
+	}
+	return slices.MaxFunc(releases, semver.Version.Compare).String(), true
+}
+
+// resolveLatest resolves the newest release of a provider, among the versions meeting the constraints query
+// parameter if set, e.g. "~> 5.0". In offline mode only the cached versions are considered. The error response is
+// written if the version can't be resolved.
+func (h *RegistryHandler) resolveLatest(c *gin.Context, registry, namespace, provider string) (string, []Platform, bool) {
+	constraints, err := semver.ParseConstraints(c.Query("constraints"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", nil, false
+	}
+
+	var versions map[string][]Platform
+	if h.offline {
+		if versions, err = h.cachedVersions(c.Request.Context(), registry, namespace, provider); err != nil {
+			h.logger.WithError(err).Error("Failed to list cached provider versions")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list cached provider versions"})
+			return "", nil, false
+		}
+	} else {
+		response, age, err := h.providerVersions(c, registry, namespace, provider)
+		if err != nil {
+			h.respondVersionsError(c, err)
+			return "", nil, false
+		}
+		setStaleHeaders(c, age)
+
+		versions = make(map[string][]Platform, len(response.Versions))
+		for _, v := range response.Versions {
+			platforms := make([]Platform, 0, len(v.Platforms))
+			for _, p := range v.Platforms {
+				platforms = append(platforms, Platform{OS: p.OS, Arch: p.Arch})
+			}
+			versions[v.Version] = platforms
+		}
+	}
+
+	version, ok := latestRelease(versions, constraints)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no release matches the constraints"})
+		return "", nil, false
+	}
+
+	logger.Debug(h.logger, "Resolved latest provider version", func() logrus.Fields {
+		return logrus.Fields{
+			"provider":    registry + "/" + namespace + "/" + provider,
+			"constraints": c.Query("constraints"),
+			"version":     version,
+		}
+	})
+	return version, versions[version], true
+}
+
+// GetLatestVersion handles GET .../latest requests, returning the newest release of a provider with its archives,
+// for bootstrap scripts and dashboards. The URLs of the archives are relative to the provider, like those of the
+// version documents.
+func (h *RegistryHandler) GetLatestVersion(c *gin.Context) {
+	registry := c.Param("registry")
+	namespace := c.Param("namespace")
+	provider := c.Param("provider")
+
+	if !h.isAllowedRegistry(registry) || !validate.Namespace(namespace) || !validate.Provider(provider) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid parameters"})
+		return
+	}
+
+	version, platforms, ok := h.resolveLatest(c, registry, namespace, provider)
+	if !ok {
+		return
+	}
+
+	response := LatestResponse{
+		Version:  version,
+		Archives: make(map[string]ArchiveInfo, len(platforms)),
+	}
+	for _, platform := range platforms {
+		response.Archives[platform.String()] = ArchiveInfo{
+			URL: fmt.Sprintf("terraform-provider-%s_%s_%s.zip", provider, version, platform),
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/semver"
+)
+
+func TestLatestRelease(t *testing.T) {
+	versions := map[string][]Platform{
+		"4.9.0":        nil,
+		"5.10.0":       nil,
+		"5.9.1":        nil,
+		"6.0.0-beta.1": nil,
+		"invalid":      nil,
+	}
+
+	tests := []struct {
+		constraints string
+		expected    string
+	}{
+		// Versions are compared numerically and pre-releases are skipped
+		{"", "5.10.0"},
+		{"~> 5.9.0", "5.9.1"},
+		{"< 5.0", "4.9.0"},
+		{">= 5.0, != 5.10.0", "5.9.1"},
+		{"> 6.0.0", ""},
+		// Even when asked for exactly
+		{"= 6.0.0-beta.1", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.constraints, func(t *testing.T) {
+			constraints, err := semver.ParseConstraints(tt.constraints)
+			require.NoError(t, err)
+			version, ok := latestRelease(versions, constraints)
+			assert.Equal(t, tt.expected, version)
+			assert.Equal(t, tt.expected != "", ok)
+		})
+	}
+}
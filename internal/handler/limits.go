@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/limits"
+)
+
+// LimitsHandler handles the admin API adjusting the limits of the server at runtime
+type LimitsHandler struct {
+	limits *limits.Manager
+	logger *logrus.Logger
+}
+
+// NewLimitsHandler creates a new LimitsHandler
+func NewLimitsHandler(limits *limits.Manager, logger *logrus.Logger) *LimitsHandler {
+	return &LimitsHandler{
+		limits: limits,
+		logger: logger,
+	}
+}
+
+// GetLimits handles GET requests returning the limits in effect and the configured ones
+func (h *LimitsHandler) GetLimits(c *gin.Context) {
+	c.JSON(http.StatusOK, h.limits.Status())
+}
+
+// UpdateLimits handles PUT requests adjusting the limits of the body, the others keep their current value
+func (h *LimitsHandler) UpdateLimits(c *gin.Context) {
+	var req limits.Limits
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+		return
+	}
+
+	status, err := h.limits.Update(c.Request.Context(), req, principalName(c))
+	switch {
+	case errors.Is(err, limits.ErrNotAdjustable):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, limits.ErrNoLimits), errors.Is(err, limits.ErrInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case err != nil:
+		h.logger.WithError(err).Error("Failed to adjust limits")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, status)
+	}
+}
+
+// ResetLimits handles DELETE requests restoring the configured limits
+func (h *LimitsHandler) ResetLimits(c *gin.Context) {
+	status, err := h.limits.Reset(c.Request.Context(), principalName(c))
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to reset limits")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
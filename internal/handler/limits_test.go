@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/limits"
+	"cachetf/internal/metadata"
+	"cachetf/internal/middleware"
+	"cachetf/internal/storage"
+	"cachetf/internal/upstream"
+)
+
+func TestLimitsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	limiter := middleware.NewRateLimiter(10, 20)
+	fetches := upstream.NewFetchLimiter(http.DefaultTransport, 0)
+	bandwidth := middleware.NewBandwidthLimiter(10 << 20)
+	manager := limits.NewManager(limits.Limiters{RateLimiter: limiter, Fetches: fetches, Bandwidth: bandwidth}, logger)
+	require.NoError(t, manager.Load(t.Context(), metadata.NewStore(storage.NewLocalStorage(t.TempDir(), logger), logger)))
+	limitsHandler := NewLimitsHandler(manager, logger)
+
+	router := gin.New()
+	router.GET("/admin/limits", limitsHandler.GetLimits)
+	router.PUT("/admin/limits", limitsHandler.UpdateLimits)
+	router.DELETE("/admin/limits", limitsHandler.ResetLimits)
+
+	do := func(method, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, "/admin/limits", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("GET", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{
+		"current": {"rateLimit": {"rps": 10, "burst": 20}, "upstreamConcurrency": 0, "responseBandwidth": 10485760},
+		"configured": {"rateLimit": {"rps": 10, "burst": 20}, "upstreamConcurrency": 0, "responseBandwidth": 10485760},
+		"adjusted": false
+	}`, w.Body.String())
+
+	// The limiter is throttled right away
+	w = do("PUT", `{"rateLimit": {"rps": 0.5, "burst": 1}}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var status limits.Status
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.True(t, status.Adjusted)
+	assert.Equal(t, &limits.RateLimit{RPS: 0.5, Burst: 1}, status.Current.RateLimit)
+	rps, burst := limiter.Limits()
+	assert.Equal(t, 0.5, rps)
+	assert.Equal(t, 1, burst)
+
+	// The other limits keep their value until they're adjusted too
+	w = do("PUT", `{"upstreamConcurrency": 4, "responseBandwidth": 1048576}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, 4, fetches.Limit())
+	assert.Equal(t, int64(1<<20), bandwidth.Limit())
+	rps, _ = limiter.Limits()
+	assert.Equal(t, 0.5, rps)
+
+	assert.Equal(t, http.StatusBadRequest, do("PUT", `{}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do("PUT", `{"rateLimit": {"rps": -1, "burst": 1}}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do("PUT", `{"upstreamConcurrency": -1}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do("PUT", `not json`).Code)
+
+	// The configured limits are restored
+	w = do("DELETE", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	rps, _ = limiter.Limits()
+	assert.Equal(t, 10.0, rps)
+	assert.Equal(t, 0, fetches.Limit())
+	assert.Equal(t, int64(10<<20), bandwidth.Limit())
+
+	// Limits that aren't enforced can't be adjusted
+	unlimited := limits.NewManager(limits.Limiters{}, logger)
+	require.NoError(t, unlimited.Load(t.Context(), metadata.NewStore(storage.NewLocalStorage(t.TempDir(), logger), logger)))
+	router = gin.New()
+	router.PUT("/admin/limits", NewLimitsHandler(unlimited, logger).UpdateLimits)
+	assert.Equal(t, http.StatusConflict, do("PUT", `{"rateLimit": {"rps": 1, "burst": 1}}`).Code)
+}
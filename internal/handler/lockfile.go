@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/lockfile"
+	"cachetf/pkg/validate"
+)
+
+// maxLockFileSize bounds the size of uploaded dependency lock files
+const maxLockFileSize = 1 << 20
+
+// PrewarmLockFile handles POST requests caching, in the background, the binaries of every provider
+// version locked by the uploaded .terraform.lock.hcl. Platforms are selected with the comma-separated
+// platforms query parameter and default to all platforms upstream publishes each version for.
+func (h *RegistryHandler) PrewarmLockFile(c *gin.Context) {
+	if h.offline {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": errOffline.Error()})
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxLockFileSize))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "lock file too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read lock file"})
+		return
+	}
+
+	providers, err := lockfile.Parse(data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid lock file: " + err.Error()})
+		return
+	}
+	if len(providers) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no providers in lock file"})
+		return
+	}
+
+	for i, p := range providers {
+		// OpenTofu lock files name registry.opentofu.org, which may be an alias
+		p.Registry = h.resolveAlias(p.Registry)
+		providers[i] = p
+		if !h.isAllowedRegistry(p.Registry) || !validate.Namespace(p.Namespace) ||
+			!validate.Provider(p.Name) || !validate.Version(p.Version) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid provider " + p.Address + " " + p.Version})
+			return
+		}
+	}
+
+	var platforms []Platform
+	if value := c.Query("platforms"); value != "" {
+		for _, s := range strings.Split(value, ",") {
+			platform, err := ParsePlatform(s)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			platforms = append(platforms, platform)
+		}
+	}
+
+	// Keep downloading after the response is sent
+	principal := principalName(c)
+	ctx := context.WithoutCancel(c.Request.Context())
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, prewarmTimeout)
+		defer cancel()
+		for _, p := range providers {
+			h.prewarmLocked(ctx, p, platforms, principal)
+		}
+	}()
+
+	h.logger.WithFields(logrus.Fields{
+		"providers": len(providers),
+		"principal": principal,
+	}).Info("Prewarming providers from lock file")
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"providers": providers,
+		"platforms": platforms,
+	})
+}
+
+// prewarmLocked caches the binaries of a locked provider, checking them against the zh: hashes of the lock file
+func (h *RegistryHandler) prewarmLocked(ctx context.Context, p lockfile.Provider, platforms []Platform, principal string) PrewarmResult {
+	if len(platforms) == 0 {
+		var err error
+		platforms, err = h.versionPlatforms(ctx, p.Registry, p.Namespace, p.Name, p.Version)
+		if err != nil {
+			h.logger.WithError(err).WithFields(logrus.Fields{
+				"provider": p.Address,
+				"version":  p.Version,
+			}).Warn("Failed to list the platforms of a locked provider")
+			return PrewarmResult{}
+		}
+	}
+	return h.prewarm(ctx, p.Registry, p.Namespace, p.Name, p.Version, platforms, principal, p.ZipHashes())
+}
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/lockfile"
+	"cachetf/internal/storage"
+)
+
+func TestPrewarmLocked(t *testing.T) {
+	var downloads int32
+	upstream := newPrewarmUpstream(t, &downloads)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	handler := NewRegistryHandler(logger, storage.NewLocalStorage(t.TempDir(), logger))
+	handler.httpClient = upstream.Client()
+
+	registry := strings.TrimPrefix(upstream.URL, "https://")
+	sum := sha256.Sum256([]byte("binary linux/amd64"))
+	locked := lockfile.Provider{
+		Registry:  registry,
+		Namespace: "hashicorp",
+		Name:      "random",
+		Version:   "3.7.2",
+		Hashes:    []string{"h1:ignored=", "zh:" + hex.EncodeToString(sum[:])},
+	}
+
+	// Only the linux_amd64 checksum is locked
+	result := handler.prewarmLocked(t.Context(), locked, nil, "ci")
+	assert.Equal(t, PrewarmResult{Cached: 1, Failed: 2}, result)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&downloads))
+
+	// Without zh: hashes, nothing is checked
+	locked.Hashes = nil
+	result = handler.prewarmLocked(t.Context(), locked, []Platform{{OS: "linux", Arch: "amd64"}, {OS: "darwin", Arch: "arm64"}}, "ci")
+	assert.Equal(t, PrewarmResult{Cached: 1, Skipped: 1}, result)
+}
+
+func TestPrewarmLockFile(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var downloads int32
+	upstream := newPrewarmUpstream(t, &downloads)
+	registry := strings.TrimPrefix(upstream.URL, "https://")
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	store := storage.NewLocalStorage(t.TempDir(), logger)
+	handler := NewRegistryHandler(logger, store)
+	handler.httpClient = upstream.Client()
+
+	router := gin.New()
+	router.POST("/prewarm/lockfile", handler.PrewarmLockFile)
+
+	lock := fmt.Sprintf(`provider "%s/hashicorp/random" {
+  version     = "3.7.2"
+  constraints = "~> 3.7"
+}
+`, registry)
+
+	tests := []struct {
+		name         string
+		query        string
+		body         string
+		expectedCode int
+	}{
+		{"valid", "?platforms=linux_arm64,darwin_arm64", lock, http.StatusAccepted},
+		{"invalid platform", "?platforms=linux", lock, http.StatusBadRequest},
+		{"invalid lock file", "", "provider {", http.StatusBadRequest},
+		{"no providers", "", "# empty\n", http.StatusBadRequest},
+		{"invalid version", "", strings.Replace(lock, "3.7.2", "latest", 1), http.StatusBadRequest},
+		{"too large", "", strings.Repeat("#", maxLockFileSize+1), http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/prewarm/lockfile"+tc.query, strings.NewReader(tc.body))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.expectedCode, w.Code, w.Body.String())
+		})
+	}
+
+	// The binaries of the valid request are downloaded in the background
+	for _, platform := range []Platform{{OS: "linux", Arch: "arm64"}, {OS: "darwin", Arch: "arm64"}} {
+		key := handler.getCacheKey(registry, "hashicorp", "random", "3.7.2", platform.OS, platform.Arch)
+		require.Eventually(t, func() bool {
+			exists, _ := store.Exists(t.Context(), key)
+			return exists
+		}, 5*time.Second, 10*time.Millisecond, key)
+	}
+}
+
+func TestPrewarmLockFile_RegistryAlias(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var downloads int32
+	upstream := newPrewarmUpstream(t, &downloads)
+	registry := strings.TrimPrefix(upstream.URL, "https://")
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	store := storage.NewLocalStorage(t.TempDir(), logger)
+	handler := NewRegistryHandlerWithOptions(logger, store, RegistryOptions{
+		Aliases: map[string]string{"registry.opentofu.org": registry},
+	})
+	handler.httpClient = upstream.Client()
+
+	router := gin.New()
+	router.POST("/prewarm/lockfile", handler.PrewarmLockFile)
+
+	// OpenTofu lock files name their registry
+	lock := `provider "registry.opentofu.org/hashicorp/random" {
+  version = "3.7.2"
+}
+`
+	req, _ := http.NewRequest("POST", "/prewarm/lockfile?platforms=linux_amd64", strings.NewReader(lock))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"registry":"`+registry+`"`)
+
+	// The binary is cached under the registry the alias stands for
+	key := handler.getCacheKey(registry, "hashicorp", "random", "3.7.2", "linux", "amd64")
+	require.Eventually(t, func() bool {
+		exists, _ := store.Exists(t.Context(), key)
+		return exists
+	}, 5*time.Second, 10*time.Millisecond, key)
+}
@@ -0,0 +1,459 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/errclass"
+	"cachetf/internal/layout"
+	"cachetf/internal/metrics"
+	"cachetf/internal/provenance"
+	"cachetf/internal/storage"
+	"cachetf/internal/upstream"
+	"cachetf/pkg/logger"
+	"cachetf/pkg/validate"
+)
+
+// githubGitSource matches git sources hosted on GitHub, e.g. git::https://github.com/owner/repo.git//subdir?ref=v1.0.0
+var githubGitSource = regexp.MustCompile(`^git::https://github\.com/([^/]+)/([^/?]+?)(?:\.git)?(?://([^?]*))?\?ref=([^&]+)$`)
+
+// ModuleHandler handles Terraform module registry API requests
+type ModuleHandler struct {
+	logger     *logrus.Logger
+	httpClient *http.Client
+	storage    storage.Storage
+	// keys lays the cached archives out in the storage
+	keys layout.Strategy
+	// upstream is the registry host module requests are forwarded to
+	upstream string
+	// uriPrefix is the base path the module routes are served under
+	uriPrefix string
+	// provenance stores the origin records of cached archives, if set
+	provenance *provenance.Store
+}
+
+// moduleDownload is stored next to a cached module archive and describes how to serve it
+type moduleDownload struct {
+	// Archive is the file name of the cached archive within the module version key space
+	Archive string `json:"archive"`
+	// Subdir is the go-getter subdirectory to extract from the archive, if any
+	Subdir string `json:"subdir,omitempty"`
+	// Source is the original X-Terraform-Get value returned by the upstream registry
+	Source string `json:"source"`
+}
+
+// NewModuleHandler creates a new ModuleHandler. transport sends the upstream requests,
+// http.DefaultTransport is used if it is nil.
+func NewModuleHandler(logger *logrus.Logger, storage storage.Storage, upstream, uriPrefix string, transport http.RoundTripper) *ModuleHandler {
+	return &ModuleHandler{
+		logger: logger,
+		httpClient: &http.Client{
+			Timeout:   5 * time.Minute,
+			Transport: transport,
+		},
+		storage:   storage,
+		keys:      layout.Default,
+		upstream:  upstream,
+		uriPrefix: strings.TrimSuffix(uriPrefix, "/"),
+	}
+}
+
+// UseProvenance makes the handler store an origin record for every cached archive
+func (h *ModuleHandler) UseProvenance(store *provenance.Store) {
+	h.provenance = store
+}
+
+// UseKeys makes the handler store the cached archives with the given layout
+func (h *ModuleHandler) UseKeys(keys layout.Strategy) {
+	h.keys = keys
+}
+
+// upstreamURL builds the upstream module registry URL for the given path
+func (h *ModuleHandler) upstreamURL(path string) string {
+	baseURL := h.upstream
+	if !strings.HasPrefix(baseURL, "http") {
+		baseURL = "https://" + baseURL
+	}
+	return fmt.Sprintf("%s/v1/modules/%s", baseURL, path)
+}
+
+// GetModuleVersions returns the available versions of a module
+func (h *ModuleHandler) GetModuleVersions(c *gin.Context) {
+	namespace := c.Param("namespace")
+	name := c.Param("name")
+	system := c.Param("system")
+
+	if !validate.Namespace(namespace) || !validate.ModuleName(name) || !validate.ModuleName(system) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid parameters"})
+		return
+	}
+
+	url := h.upstreamURL(fmt.Sprintf("%s/%s/%s/versions", namespace, name, system))
+	logger.Debug(h.logger, "Fetching module versions from registry", func() logrus.Fields { return logrus.Fields{"url": url} })
+
+	req, err := http.NewRequestWithContext(c.Request.Context(), "GET", url, nil)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to create request")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+	req.Header.Set("User-Agent", "Terraform/1.0.0")
+
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		class := metrics.RecordError(metrics.ComponentUpstream, "module_versions", err)
+		h.logger.WithError(err).WithField(errclass.LogField, class).Error("Failed to fetch module versions")
+		c.JSON(http.StatusBadGateway, gin.H{"error": "failed to fetch module versions"})
+		return
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode == http.StatusNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "module not found"})
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		class := metrics.RecordError(metrics.ComponentUpstream, "module_versions", &errclass.StatusError{StatusCode: resp.StatusCode})
+		h.logger.WithFields(logrus.Fields{
+			"status":          resp.Status,
+			"body":            string(body),
+			errclass.LogField: class,
+		}).Error("Unexpected response from registry")
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error":  "failed to fetch module versions",
+			"status": resp.Status,
+		})
+		return
+	}
+
+	// Make sure we only relay well-formed responses
+	if !json.Valid(body) {
+		h.logger.Error("Failed to parse module versions response")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to parse module versions"})
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json", body)
+}
+
+// DownloadModule resolves the download location of a module version, caching the archive when possible
+func (h *ModuleHandler) DownloadModule(c *gin.Context) {
+	namespace := c.Param("namespace")
+	name := c.Param("name")
+	system := c.Param("system")
+	version := c.Param("version")
+
+	if !validate.Namespace(namespace) || !validate.ModuleName(name) || !validate.ModuleName(system) || !validate.Version(version) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid parameters"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	prefix := h.keys.ModulePrefix(namespace, name, system, version)
+
+	// Serve from cache if the archive was stored before
+	download, err := h.loadDownload(ctx, prefix)
+	if err != nil {
+		h.logger.WithError(err).WithField("key", prefix).Error("Failed to read cached module download")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get module from cache"})
+		return
+	}
+	if download != nil {
+		// The archive may have been removed without its descriptor, e.g. evicted, it's downloaded again then
+		exists, err := h.storage.Exists(ctx, prefix+download.Archive)
+		if err != nil {
+			h.logger.WithError(err).WithField("key", prefix+download.Archive).Error("Failed to check cached module archive")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get module from cache"})
+			return
+		}
+		if !exists {
+			h.logger.WithField("key", prefix+download.Archive).Warn("Cached module archive is missing, downloading it again")
+			if err := h.storage.Delete(ctx, prefix+"download.json"); err != nil && !errors.Is(err, os.ErrNotExist) {
+				h.logger.WithError(err).WithField("key", prefix).Error("Failed to remove stale module download")
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get module from cache"})
+				return
+			}
+			download = nil
+		}
+	}
+	if download != nil {
+		h.logger.WithField("key", prefix).Info("Serving module from cache")
+		c.Header("X-Terraform-Get", h.getterURL(namespace, name, system, version, download))
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	// Ask the upstream registry where the module lives
+	source, err := h.fetchDownloadSource(ctx, namespace, name, system, version)
+	if err != nil {
+		h.logger.WithError(err).WithField(errclass.LogField, errclass.Of(err)).Error("Failed to fetch module download location")
+		c.JSON(http.StatusBadGateway, gin.H{"error": "failed to fetch module download location"})
+		return
+	}
+	if source == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "module version not found"})
+		return
+	}
+
+	archiveURL, archive, subdir, ok := resolveModuleArchive(source)
+	if !ok {
+		// Sources such as plain git or mercurial can't be cached as a single archive
+		h.logger.WithField("source", source).Info("Module source is not cacheable, passing through")
+		c.Header("X-Terraform-Get", source)
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	download = &moduleDownload{
+		Archive: archive,
+		Subdir:  subdir,
+		Source:  source,
+	}
+	origin, size, err := h.cacheArchive(ctx, prefix, archiveURL, download)
+	if err != nil {
+		h.logger.WithError(err).WithFields(logrus.Fields{
+			"source":          source,
+			errclass.LogField: errclass.Of(err),
+		}).Error("Failed to cache module archive")
+		c.JSON(http.StatusBadGateway, gin.H{"error": "failed to download module archive"})
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"key":    prefix,
+		"source": source,
+	}).Info("Successfully cached module archive")
+
+	// Module archives have no checksums to verify against
+	if h.provenance != nil {
+		saveOrigin(ctx, h.provenance, h.logger, prefix+download.Archive, origin, size, provenance.Verification{
+			Checksum:  provenance.Disabled,
+			Signature: provenance.Disabled,
+		}, principalName(c))
+	}
+
+	c.Header("X-Terraform-Get", h.getterURL(namespace, name, system, version, download))
+	c.Status(http.StatusNoContent)
+}
+
+// GetModuleArchive serves a cached module archive
+func (h *ModuleHandler) GetModuleArchive(c *gin.Context) {
+	namespace := c.Param("namespace")
+	name := c.Param("name")
+	system := c.Param("system")
+	version := c.Param("version")
+	file := c.Param("file")
+
+	if !validate.Namespace(namespace) || !validate.ModuleName(name) || !validate.ModuleName(system) ||
+		!validate.Version(version) || (file != "archive.tar.gz" && file != "archive.zip") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid parameters"})
+		return
+	}
+
+	key := h.keys.ModulePrefix(namespace, name, system, version) + file
+	reader, err := h.storage.Get(c.Request.Context(), key)
+	if errors.Is(err, os.ErrNotExist) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "module archive not found"})
+		return
+	}
+	if err != nil {
+		h.logger.WithError(err).WithField("key", key).Error("Failed to get module archive from cache")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get file from cache"})
+		return
+	}
+	defer reader.Close()
+
+	contentType := "application/gzip"
+	if strings.HasSuffix(file, ".zip") {
+		contentType = "application/zip"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", file))
+
+	if _, err := io.Copy(c.Writer, reader); err != nil && !isBrokenPipeError(err) {
+		h.logger.WithError(err).Error("Failed to send module archive")
+	}
+}
+
+// getterURL builds the X-Terraform-Get value pointing at the cached archive
+func (h *ModuleHandler) getterURL(namespace, name, system, version string, download *moduleDownload) string {
+	getter := fmt.Sprintf("%s/%s/%s/%s/%s/%s", h.uriPrefix, namespace, name, system, version, download.Archive)
+	if download.Subdir != "" {
+		getter += "//" + download.Subdir
+	}
+	return getter
+}
+
+// loadDownload returns the cached download descriptor of the module version stored under prefix, or nil if it
+// isn't cached
+func (h *ModuleHandler) loadDownload(ctx context.Context, prefix string) (*moduleDownload, error) {
+	reader, err := h.storage.Get(ctx, prefix+"download.json")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var download moduleDownload
+	if err := json.NewDecoder(reader).Decode(&download); err != nil {
+		return nil, fmt.Errorf("failed to decode module download: %w", err)
+	}
+	return &download, nil
+}
+
+// fetchDownloadSource asks the upstream registry for the X-Terraform-Get location of a module version
+func (h *ModuleHandler) fetchDownloadSource(ctx context.Context, namespace, name, system, version string) (string, error) {
+	downloadURL := h.upstreamURL(fmt.Sprintf("%s/%s/%s/%s/download", namespace, name, system, version))
+	logger.Debug(h.logger, "Fetching module download location from upstream", func() logrus.Fields { return logrus.Fields{"url": downloadURL} })
+
+	req, err := http.NewRequestWithContext(ctx, "GET", downloadURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", "Terraform/1.0.0")
+
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return "", upstreamError("module_download_info", fmt.Errorf("failed to fetch download location: %w", err))
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return "", nil
+	case http.StatusNoContent, http.StatusOK:
+	default:
+		return "", upstreamError("module_download_info", &errclass.StatusError{StatusCode: resp.StatusCode})
+	}
+
+	source := resp.Header.Get("X-Terraform-Get")
+	if source == "" && resp.StatusCode == http.StatusOK {
+		// Newer registries may return the location in the body instead
+		var body struct {
+			Location string `json:"location"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err == nil {
+			source = body.Location
+		}
+	}
+	if source == "" {
+		return "", fmt.Errorf("upstream response is missing the module location")
+	}
+
+	// Relative locations are resolved against the download URL
+	base, err := url.Parse(downloadURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid download URL: %w", err)
+	}
+	if ref, err := url.Parse(source); err == nil && !strings.Contains(source, "::") && !ref.IsAbs() {
+		source = base.ResolveReference(ref).String()
+	}
+
+	return source, nil
+}
+
+// cacheArchive downloads a module archive and stores it together with its download descriptor under the prefix
+// of the module version. It returns where the archive was downloaded from and its size.
+func (h *ModuleHandler) cacheArchive(ctx context.Context, prefix, archiveURL string, download *moduleDownload) (*upstream.Origin, int64, error) {
+	ctx, origin := upstream.WithOrigin(ctx)
+	req, err := http.NewRequestWithContext(ctx, "GET", archiveURL, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return nil, 0, upstreamError("module_download", fmt.Errorf("failed to download archive: %w", err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, upstreamError("module_download", &errclass.StatusError{StatusCode: resp.StatusCode})
+	}
+
+	body := &countingReader{Reader: resp.Body}
+	if err := h.storage.Put(ctx, prefix+download.Archive, body); err != nil {
+		return nil, 0, fmt.Errorf("failed to store archive: %w", err)
+	}
+	logFill(h.logger, prefix+download.Archive, origin)
+
+	// Store the descriptor last so a partially written archive is never served
+	data, err := json.Marshal(download)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to encode module download: %w", err)
+	}
+	if err := h.storage.Put(ctx, prefix+"download.json", strings.NewReader(string(data))); err != nil {
+		return nil, 0, fmt.Errorf("failed to store module download: %w", err)
+	}
+
+	return origin, body.n, nil
+}
+
+// resolveModuleArchive maps a go-getter source to a downloadable archive URL.
+// It returns the archive URL, the archive file name to store it under, the
+// subdirectory to extract, and whether the source can be cached at all.
+func resolveModuleArchive(source string) (string, string, string, bool) {
+	// GitHub git sources can be fetched as tarballs from codeload
+	if m := githubGitSource.FindStringSubmatch(source); m != nil {
+		archiveURL := fmt.Sprintf("https://codeload.github.com/%s/%s/tar.gz/%s", m[1], m[2], m[4])
+		// Tarballs contain a single top-level directory named after the repository and ref
+		subdir := "*"
+		if m[3] != "" {
+			subdir += "/" + strings.Trim(m[3], "/")
+		}
+		return archiveURL, "archive.tar.gz", subdir, true
+	}
+
+	// Any other forced getter (git::, hg::, s3::, ...) isn't a plain HTTP download
+	if strings.Contains(source, "::") {
+		return "", "", "", false
+	}
+
+	u, err := url.Parse(source)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", "", "", false
+	}
+
+	// Split off a go-getter subdirectory, e.g. https://host/archive.tar.gz//subdir
+	subdir := ""
+	if idx := strings.Index(u.Path, "//"); idx >= 0 {
+		subdir = strings.Trim(u.Path[idx+2:], "/")
+		u.Path = u.Path[:idx]
+	}
+
+	// The archive type is either forced via the query or taken from the file extension
+	archiveType := u.Query().Get("archive")
+	if archiveType == "" {
+		switch {
+		case strings.HasSuffix(u.Path, ".tar.gz"), strings.HasSuffix(u.Path, ".tgz"):
+			archiveType = "tar.gz"
+		case strings.HasSuffix(u.Path, ".zip"):
+			archiveType = "zip"
+		}
+	}
+
+	switch archiveType {
+	case "tar.gz", "tgz":
+		return u.String(), "archive.tar.gz", subdir, true
+	case "zip":
+		return u.String(), "archive.zip", subdir, true
+	default:
+		return "", "", "", false
+	}
+}
@@ -0,0 +1,207 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/storage"
+)
+
+func TestResolveModuleArchive(t *testing.T) {
+	tests := []struct {
+		name        string
+		source      string
+		expectedURL string
+		archive     string
+		subdir      string
+		cacheable   bool
+	}{
+		{
+			name:        "github git source",
+			source:      "git::https://github.com/terraform-aws-modules/terraform-aws-vpc?ref=v5.0.0",
+			expectedURL: "https://codeload.github.com/terraform-aws-modules/terraform-aws-vpc/tar.gz/v5.0.0",
+			archive:     "archive.tar.gz",
+			subdir:      "*",
+			cacheable:   true,
+		},
+		{
+			name:        "github git source with subdir",
+			source:      "git::https://github.com/hashicorp/example.git//modules/foo?ref=v1.0.0",
+			expectedURL: "https://codeload.github.com/hashicorp/example/tar.gz/v1.0.0",
+			archive:     "archive.tar.gz",
+			subdir:      "*/modules/foo",
+			cacheable:   true,
+		},
+		{
+			name:        "http tarball",
+			source:      "https://example.com/modules/vpc-1.0.0.tar.gz",
+			expectedURL: "https://example.com/modules/vpc-1.0.0.tar.gz",
+			archive:     "archive.tar.gz",
+			cacheable:   true,
+		},
+		{
+			name:        "http zip with subdir",
+			source:      "https://example.com/modules/vpc.zip//network",
+			expectedURL: "https://example.com/modules/vpc.zip",
+			archive:     "archive.zip",
+			subdir:      "network",
+			cacheable:   true,
+		},
+		{
+			name:      "plain git source",
+			source:    "git::https://gitlab.com/example/vpc.git?ref=v1.0.0",
+			cacheable: false,
+		},
+		{
+			name:      "http without archive type",
+			source:    "https://example.com/modules/vpc",
+			cacheable: false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			archiveURL, archive, subdir, ok := resolveModuleArchive(tc.source)
+			assert.Equal(t, tc.cacheable, ok)
+			if tc.cacheable {
+				assert.Equal(t, tc.expectedURL, archiveURL)
+				assert.Equal(t, tc.archive, archive)
+				assert.Equal(t, tc.subdir, subdir)
+			}
+		})
+	}
+}
+
+func TestModuleHandler_DownloadAndServe(t *testing.T) {
+	var downloadCalls int32
+
+	// Fake upstream registry serving the download location and the archive itself
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/modules/example/vpc/aws/versions":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"modules":[{"versions":[{"version":"1.0.0"}]}]}`))
+		case "/v1/modules/example/vpc/aws/1.0.0/download":
+			atomic.AddInt32(&downloadCalls, 1)
+			w.Header().Set("X-Terraform-Get", "/archives/vpc-1.0.0.tar.gz")
+			w.WriteHeader(http.StatusNoContent)
+		case "/archives/vpc-1.0.0.tar.gz":
+			_, _ = w.Write([]byte("module archive"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+
+	// Use a real local storage so the archive round-trips
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	store := storage.NewLocalStorage(t.TempDir(), logger)
+
+	h := NewModuleHandler(logger, store, strings.TrimPrefix(upstream.URL, "https://"), "/modules", nil)
+	h.httpClient = upstream.Client()
+
+	router := gin.New()
+	modules := router.Group("/modules/:namespace/:name/:system")
+	modules.GET("/versions", h.GetModuleVersions)
+	modules.GET("/:version/download", h.DownloadModule)
+	modules.GET("/:version/:file", h.GetModuleArchive)
+
+	t.Run("versions are relayed", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/modules/example/vpc/aws/versions", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"modules":[{"versions":[{"version":"1.0.0"}]}]}`, w.Body.String())
+	})
+
+	t.Run("download caches the archive", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/modules/example/vpc/aws/1.0.0/download", nil)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusNoContent, w.Code)
+			assert.Equal(t, "/modules/example/vpc/aws/1.0.0/archive.tar.gz", w.Header().Get("X-Terraform-Get"))
+		}
+
+		// The second download must be answered from the cache
+		assert.Equal(t, int32(1), atomic.LoadInt32(&downloadCalls))
+	})
+
+	t.Run("archive is served from the cache", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/modules/example/vpc/aws/1.0.0/archive.tar.gz", nil)
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "module archive", w.Body.String())
+	})
+
+	t.Run("missing archive is downloaded again", func(t *testing.T) {
+		require.NoError(t, store.Delete(context.Background(), "modules/example/vpc/aws/1.0.0/archive.tar.gz"))
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/modules/example/vpc/aws/1.0.0/download", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, "/modules/example/vpc/aws/1.0.0/archive.tar.gz", w.Header().Get("X-Terraform-Get"))
+		assert.Equal(t, int32(2), atomic.LoadInt32(&downloadCalls))
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/modules/example/vpc/aws/1.0.0/archive.tar.gz", nil)
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "module archive", w.Body.String())
+	})
+
+	t.Run("invalid archive name", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/modules/example/vpc/aws/1.0.0/other.bin", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+// wrappedNotFoundStorage reports missing files with a wrapped os.ErrNotExist, as the remote backends do
+type wrappedNotFoundStorage struct {
+	storage.Storage
+}
+
+func (s wrappedNotFoundStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	reader, err := s.Storage.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", key, err)
+	}
+	return reader, nil
+}
+
+func TestModuleHandler_WrappedNotFound(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	store := wrappedNotFoundStorage{storage.NewLocalStorage(t.TempDir(), logger)}
+	h := NewModuleHandler(logger, store, "registry.example.com", "/modules", nil)
+
+	router := gin.New()
+	router.GET("/modules/:namespace/:name/:system/:version/:file", h.GetModuleArchive)
+
+	// A missing archive is a 404, not a storage failure
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/modules/example/vpc/aws/1.0.0/archive.tar.gz", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/storage"
+	"cachetf/internal/transparency"
+)
+
+// errOffline is returned instead of contacting upstream in offline mode
+var errOffline = errors.New("upstream registries aren't contacted in offline mode")
+
+// offlineTransport refuses every request, so nothing can reach upstream in offline mode
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errOffline
+}
+
+// cachedVersions returns the versions of a provider with at least one cached binary, with their cached platforms
+func (h *RegistryHandler) cachedVersions(ctx context.Context, registry, namespace, provider string) (map[string][]Platform, error) {
+	prefix := h.keys.ProviderPrefix(registry, namespace, provider)
+	versions := make(map[string][]Platform)
+
+	err := storage.Walk(ctx, h.storage, prefix, func(obj storage.ObjectInfo) error {
+		version, filename, ok := strings.Cut(strings.TrimPrefix(obj.Key, prefix), "/")
+		if !ok || strings.Contains(filename, "/") {
+			return nil
+		}
+
+		// Only provider binaries, named terraform-provider-{name}_{version}_{os}_{arch}.zip
+		name, ok := strings.CutPrefix(filename, fmt.Sprintf("terraform-provider-%s_%s_", provider, version))
+		if !ok {
+			return nil
+		}
+		name, ok = strings.CutSuffix(name, ".zip")
+		if !ok {
+			return nil
+		}
+		platform, err := ParsePlatform(name)
+		if err != nil {
+			return nil
+		}
+
+		versions[version] = append(versions[version], platform)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// serveOfflineIndex answers the provider index from the cached binaries
+func (h *RegistryHandler) serveOfflineIndex(c *gin.Context, registry, namespace, provider string) {
+	versions, err := h.cachedVersions(c.Request.Context(), registry, namespace, provider)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to list cached provider versions")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list cached provider versions"})
+		return
+	}
+	if len(versions) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "provider not cached"})
+		return
+	}
+
+	versionsMap := make(map[string]struct{}, len(versions))
+	for version := range versions {
+		versionsMap[version] = struct{}{}
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"provider": provider,
+		"versions": len(versionsMap),
+	}).Info("Returning cached provider versions")
+
+	c.JSON(http.StatusOK, gin.H{
+		"versions": versionsMap,
+	})
+}
+
+// serveOfflineVersion answers the platforms of a provider version from the cached binaries
+func (h *RegistryHandler) serveOfflineVersion(c *gin.Context, registry, namespace, provider, version string) {
+	versions, err := h.cachedVersions(c.Request.Context(), registry, namespace, provider)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to list cached provider versions")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list cached provider versions"})
+		return
+	}
+
+	platforms, ok := versions[version]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "version not found"})
+		return
+	}
+
+	response := VersionResponse{
+		Archives: make(map[string]ArchiveInfo, len(platforms)),
+	}
+	for _, platform := range platforms {
+		response.Archives[platform.String()] = ArchiveInfo{
+			URL: fmt.Sprintf("terraform-provider-%s_%s_%s_%s.zip", provider, version, platform.OS, platform.Arch),
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// recordedSHA256 returns the checksum the transparency log recorded last for a provider binary
+func (h *RegistryHandler) recordedSHA256(registry, namespace, provider, version, osName, arch string) (string, error) {
+	if h.transparency == nil {
+		return "", errOffline
+	}
+
+	entries := h.transparency.Query(transparency.Artifact{
+		Registry:  registry,
+		Namespace: namespace,
+		Provider:  provider,
+		Version:   version,
+		OS:        osName,
+		Arch:      arch,
+	}, false)
+	if len(entries) == 0 {
+		return "", fmt.Errorf("no checksum recorded: %w", errOffline)
+	}
+	return entries[len(entries)-1].SHA256, nil
+}
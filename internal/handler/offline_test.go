@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/metadata"
+	"cachetf/internal/storage"
+	"cachetf/internal/transparency"
+)
+
+// newOfflineRouter serves the provider mirror endpoints of a handler
+func newOfflineRouter(handler *RegistryHandler) *gin.Engine {
+	router := gin.New()
+	router.GET("/:registry/:namespace/:provider/index.json", handler.GetProviderIndex)
+	router.GET("/:registry/:namespace/:provider/versions/:version", func(c *gin.Context) {
+		c.Set("version", c.Param("version"))
+		handler.GetProviderVersion(c)
+	})
+	router.GET("/:registry/:namespace/:provider/download/:version/:os/:arch", func(c *gin.Context) {
+		c.Set("version", c.Param("version"))
+		c.Set("os", c.Param("os"))
+		c.Set("arch", c.Param("arch"))
+		handler.DownloadProvider(c)
+	})
+	router.GET("/:registry/:namespace/:provider/shasums/:version", func(c *gin.Context) {
+		c.Set("version", c.Param("version"))
+		handler.GetSHASums(c)
+	})
+	router.POST("/prewarm/:registry/:namespace/:provider/:version", handler.PrewarmProvider)
+	return router
+}
+
+func TestOfflineMode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	store := storage.NewLocalStorage(t.TempDir(), logger)
+	handler := NewRegistryHandlerWithOptions(logger, store, RegistryOptions{Offline: true})
+	router := newOfflineRouter(handler)
+
+	const registry = "registry.terraform.io"
+	for _, file := range []struct{ version, os, arch string }{
+		{"5.30.0", "linux", "amd64"},
+		{"5.31.0", "linux", "amd64"},
+		{"5.31.0", "darwin", "arm64"},
+	} {
+		key := handler.getCacheKey(registry, "hashicorp", "aws", file.version, file.os, file.arch)
+		require.NoError(t, store.Put(t.Context(), key, strings.NewReader("binary "+file.os+"/"+file.arch)))
+	}
+	// Checksum files don't make a version
+	sums := handler.getSHASumsKey(registry, "hashicorp", "aws", "5.32.0", false)
+	require.NoError(t, store.Put(t.Context(), sums, strings.NewReader("sums")))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// The index lists the cached versions
+	w := get("/" + registry + "/hashicorp/aws/index.json")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"versions": {"5.30.0": {}, "5.31.0": {}}}`, w.Body.String())
+
+	w = get("/" + registry + "/hashicorp/google/index.json")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// A version lists its cached platforms
+	w = get("/" + registry + "/hashicorp/aws/versions/5.31.0")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var version VersionResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &version))
+	assert.Equal(t, map[string]ArchiveInfo{
+		"linux_amd64":  {URL: "terraform-provider-aws_5.31.0_linux_amd64.zip"},
+		"darwin_arm64": {URL: "terraform-provider-aws_5.31.0_darwin_arm64.zip"},
+	}, version.Archives)
+
+	assert.Equal(t, http.StatusNotFound, get("/"+registry+"/hashicorp/aws/versions/5.32.0").Code)
+
+	// Cached binaries are served, anything else is missing
+	w = get("/" + registry + "/hashicorp/aws/download/5.31.0/darwin/arm64")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "binary darwin/arm64", w.Body.String())
+	assert.Equal(t, http.StatusNotFound, get("/"+registry+"/hashicorp/aws/download/5.30.0/darwin/arm64").Code)
+
+	assert.Equal(t, http.StatusOK, get("/"+registry+"/hashicorp/aws/shasums/5.32.0").Code)
+	assert.Equal(t, http.StatusNotFound, get("/"+registry+"/hashicorp/aws/shasums/5.31.0").Code)
+
+	// Nothing can be prewarmed
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/prewarm/"+registry+"/hashicorp/aws/5.32.0", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	// Upstream is never contacted, even by internal calls
+	_, err := handler.fetchProviderVersions(t.Context(), registry, "hashicorp", "aws")
+	assert.ErrorIs(t, err, errOffline)
+}
+
+func TestOfflineMode_VerifyOnServe(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	store := storage.NewLocalStorage(t.TempDir(), logger)
+	log := transparency.NewLog(metadata.NewStore(store, logger), logger)
+	handler := NewRegistryHandlerWithOptions(logger, store, RegistryOptions{
+		Offline:       true,
+		VerifyOnServe: true,
+		Transparency:  log,
+	})
+	router := newOfflineRouter(handler)
+
+	const registry = "registry.terraform.io"
+	content := "binary linux/amd64"
+	for _, version := range []string{"5.30.0", "5.31.0"} {
+		key := handler.getCacheKey(registry, "hashicorp", "aws", version, "linux", "amd64")
+		require.NoError(t, store.Put(t.Context(), key, strings.NewReader(content)))
+	}
+
+	// Only 5.31.0 has a recorded checksum
+	sum := sha256.Sum256([]byte(content))
+	_, err := log.Append(t.Context(), transparency.Artifact{
+		Registry: registry, Namespace: "hashicorp", Provider: "aws", Version: "5.31.0", OS: "linux", Arch: "amd64",
+	}, hex.EncodeToString(sum[:]))
+	require.NoError(t, err)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/" + registry + "/hashicorp/aws/download/5.31.0/linux/amd64")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, content, w.Body.String())
+
+	// Without a trusted checksum, the binary can't be verified
+	assert.Equal(t, http.StatusBadGateway, get("/"+registry+"/hashicorp/aws/download/5.30.0/linux/amd64").Code)
+}
@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"cachetf/internal/pins"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// PinHandler handles the pinning API
+type PinHandler struct {
+	pins   *pins.Set
+	logger *logrus.Logger
+}
+
+// NewPinHandler creates a new PinHandler
+func NewPinHandler(pins *pins.Set, logger *logrus.Logger) *PinHandler {
+	return &PinHandler{
+		pins:   pins,
+		logger: logger,
+	}
+}
+
+// pinPath returns the registry/namespace/provider[/version] path of the request
+func pinPath(c *gin.Context) string {
+	params := []string{c.Param("registry"), c.Param("namespace"), c.Param("provider")}
+	if version := c.Param("version"); version != "" {
+		params = append(params, version)
+	}
+	return strings.Join(params, "/")
+}
+
+// ListPins handles GET requests listing the pins
+func (h *PinHandler) ListPins(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"pins": h.pins.List()})
+}
+
+// PinProvider handles PUT requests pinning a provider or a provider version
+func (h *PinHandler) PinProvider(c *gin.Context) {
+	pin, err := pins.ParsePin(pinPath(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	pin.CreatedBy = principalName(c)
+
+	pin, err = h.pins.Add(c.Request.Context(), pin)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to add pin")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"pin":       pin.Path(),
+		"createdBy": principalName(c),
+	}).Info("Pinned provider")
+
+	c.JSON(http.StatusOK, pin)
+}
+
+// UnpinProvider handles DELETE requests removing a pin
+func (h *PinHandler) UnpinProvider(c *gin.Context) {
+	path := pinPath(c)
+	if err := h.pins.Remove(c.Request.Context(), path); err != nil {
+		switch {
+		case errors.Is(err, pins.ErrNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "pin not found"})
+		case errors.Is(err, pins.ErrStatic):
+			c.JSON(http.StatusConflict, gin.H{"error": "pin is configured in CACHE_PINS and can't be removed"})
+		default:
+			h.logger.WithError(err).Error("Failed to remove pin")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"pin":       path,
+		"removedBy": principalName(c),
+	}).Info("Unpinned provider")
+
+	c.JSON(http.StatusOK, gin.H{"message": "Pin removed"})
+}
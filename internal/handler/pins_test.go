@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/layout"
+	"cachetf/internal/metadata"
+	"cachetf/internal/pins"
+	"cachetf/internal/storage"
+)
+
+func TestPinHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	store := storage.NewLocalStorage(t.TempDir(), logger)
+	static, err := pins.ParsePins("registry.terraform.io/hashicorp/aws/5.0.0")
+	require.NoError(t, err)
+	set := pins.NewSet(static)
+	require.NoError(t, set.Load(t.Context(), metadata.NewStore(store, logger)))
+
+	// Cache a few files, one of them pinned statically
+	files := []string{
+		"registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip",
+		"registry.terraform.io/hashicorp/aws/5.1.0/terraform-provider-aws_5.1.0_linux_amd64.zip",
+		"registry.terraform.io/hashicorp/google/6.0.0/terraform-provider-google_6.0.0_linux_amd64.zip",
+	}
+	for _, key := range files {
+		require.NoError(t, store.Put(t.Context(), layout.Providers.Key(key), bytes.NewReader([]byte("data"))))
+	}
+
+	pinHandler := NewPinHandler(set, logger)
+	cacheHandler := NewCacheHandler(store, logger)
+	cacheHandler.UsePins(set)
+
+	router := gin.New()
+	router.GET("/cache/pins", pinHandler.ListPins)
+	router.PUT("/cache/pins/:registry/:namespace/:provider", pinHandler.PinProvider)
+	router.PUT("/cache/pins/:registry/:namespace/:provider/:version", pinHandler.PinProvider)
+	router.DELETE("/cache/pins/:registry/:namespace/:provider", pinHandler.UnpinProvider)
+	router.DELETE("/cache/pins/:registry/:namespace/:provider/:version", pinHandler.UnpinProvider)
+	cacheHandler.RegisterCacheRoutes(router.Group("/providers"))
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Pin the google provider
+	w := do("PUT", "/cache/pins/registry.terraform.io/hashicorp/google")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, http.StatusBadRequest, do("PUT", "/cache/pins/registry.terraform.io/hashicorp/google/..").Code)
+
+	w = do("GET", "/cache/pins")
+	require.Equal(t, http.StatusOK, w.Code)
+	var list struct {
+		Pins []pins.Pin `json:"pins"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.Pins, 2)
+	assert.True(t, list.Pins[0].Static)
+	assert.Equal(t, "registry.terraform.io/hashicorp/google", list.Pins[1].Path())
+
+	// Pinned files can't be deleted one by one
+	assert.Equal(t, http.StatusConflict, do("DELETE", "/providers/"+files[0]).Code)
+
+	// A broad delete keeps the pinned files
+	w = do("DELETE", "/providers/registry.terraform.io")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"message":"Cache cleared successfully, pinned files were kept","deleted":1,"protected":2}`, w.Body.String())
+	for i, key := range files {
+		exists, err := store.Exists(t.Context(), layout.Providers.Key(key))
+		require.NoError(t, err)
+		assert.Equal(t, i != 1, exists, key)
+	}
+
+	// Static pins can't be removed, others can
+	assert.Equal(t, http.StatusConflict, do("DELETE", "/cache/pins/registry.terraform.io/hashicorp/aws/5.0.0").Code)
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/cache/pins/registry.terraform.io/hashicorp/azurerm").Code)
+	assert.Equal(t, http.StatusOK, do("DELETE", "/cache/pins/registry.terraform.io/hashicorp/google").Code)
+	assert.Equal(t, http.StatusOK, do("DELETE", "/providers/"+files[2]).Code)
+}
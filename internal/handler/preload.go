@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/metadata"
+)
+
+const (
+	// indexHitsDocument is the metadata document persisting the number of requests of every provider index
+	indexHitsDocument = "index-hits.json"
+	// maxIndexHits bounds the providers whose requests are persisted, the least requested ones are dropped
+	maxIndexHits = 10000
+)
+
+// indexHits counts the index requests of every provider, by index document, until they're persisted
+type indexHits struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+// newIndexHits creates a counter of index requests, nil if requests aren't counted
+func newIndexHits(enabled bool) *indexHits {
+	if !enabled {
+		return nil
+	}
+	return &indexHits{counts: make(map[string]int64)}
+}
+
+// add counts a request of the index document. A nil counter doesn't count.
+func (h *indexHits) add(document string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[document]++
+}
+
+// take returns the requests counted so far and resets the counter
+func (h *indexHits) take() map[string]int64 {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	counts := h.counts
+	h.counts = make(map[string]int64)
+	return counts
+}
+
+// restore counts again the requests of counts, which couldn't be persisted
+func (h *indexHits) restore(counts map[string]int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for document, n := range counts {
+		h.counts[document] += n
+	}
+}
+
+// mostRequested returns the index documents of counts, the most requested first, at most n
+func mostRequested(counts map[string]int64, n int) []string {
+	documents := make([]string, 0, len(counts))
+	for document := range counts {
+		documents = append(documents, document)
+	}
+	sort.Slice(documents, func(i, j int) bool {
+		if counts[documents[i]] != counts[documents[j]] {
+			return counts[documents[i]] > counts[documents[j]]
+		}
+		return documents[i] < documents[j]
+	})
+	if len(documents) > n {
+		documents = documents[:n]
+	}
+	return documents
+}
+
+// SaveIndexHits adds the index requests counted since the last call to the persisted counts, which instances
+// sharing the storage add up. Counts that can't be persisted are kept for the next call.
+func (h *RegistryHandler) SaveIndexHits(ctx context.Context) error {
+	counts := h.indexHits.take()
+	if len(counts) == 0 {
+		return nil
+	}
+
+	persisted := make(map[string]int64)
+	err := h.indexes.Load(ctx, indexHitsDocument, &persisted)
+	if err != nil && !errors.Is(err, metadata.ErrNotFound) {
+		h.indexHits.restore(counts)
+		return err
+	}
+	for document, n := range counts {
+		persisted[document] += n
+	}
+
+	// Requests for providers that don't matter, e.g. typos, don't accumulate forever
+	if len(persisted) > maxIndexHits {
+		kept := make(map[string]int64, maxIndexHits)
+		for _, document := range mostRequested(persisted, maxIndexHits) {
+			kept[document] = persisted[document]
+		}
+		persisted = kept
+	}
+
+	if err := h.indexes.Save(ctx, indexHitsDocument, persisted); err != nil {
+		h.indexHits.restore(counts)
+		return err
+	}
+	return nil
+}
+
+// RunIndexHits persists the counted index requests every interval until ctx is cancelled, and a last time then
+func (h *RegistryHandler) RunIndexHits(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// The requests since the last save survive the shutdown
+			saveCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			if err := h.SaveIndexHits(saveCtx); err != nil {
+				h.logger.WithError(err).Warn("Failed to persist provider index requests")
+			}
+			cancel()
+			return
+		case <-ticker.C:
+		}
+
+		if err := h.SaveIndexHits(ctx); err != nil && ctx.Err() == nil {
+			h.logger.WithError(err).Warn("Failed to persist provider index requests")
+		}
+	}
+}
+
+// PreloadIndexes reads the persisted indexes of the n most requested providers, so a memory cache in front of
+// the storage holds them before the first requests after a restart. It returns the number of indexes read.
+func (h *RegistryHandler) PreloadIndexes(ctx context.Context, n int) (int, error) {
+	start := time.Now()
+	var persisted map[string]int64
+	if err := h.indexes.Load(ctx, indexHitsDocument, &persisted); err != nil {
+		if errors.Is(err, metadata.ErrNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	loaded := 0
+	for _, document := range mostRequested(persisted, n) {
+		if err := ctx.Err(); err != nil {
+			return loaded, err
+		}
+		// Only index documents are preloaded
+		if !strings.HasPrefix(document, indexDocumentPrefix) {
+			continue
+		}
+		var index persistedIndex
+		if err := h.indexes.Load(ctx, document, &index); err != nil {
+			if !errors.Is(err, metadata.ErrNotFound) {
+				h.logger.WithError(err).WithField("document", document).Warn("Failed to preload provider index")
+			}
+			continue
+		}
+		loaded++
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"indexes":  loaded,
+		"duration": time.Since(start),
+	}).Info("Preloaded the most requested provider indexes")
+	return loaded, nil
+}
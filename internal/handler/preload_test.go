@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/metrics"
+	"cachetf/internal/storage"
+)
+
+func TestPreloadIndexes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	upstream := newIndexUpstream(t, &status)
+	registry := strings.TrimPrefix(upstream.URL, "https://")
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	local := storage.NewLocalStorage(t.TempDir(), logger)
+	handler := NewRegistryHandlerWithOptions(logger, local, RegistryOptions{CountIndexHits: true})
+	handler.httpClient = upstream.Client()
+	router := newOfflineRouter(handler)
+	get := func(provider string) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/"+registry+"/hashicorp/"+provider+"/index.json", nil)
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	// The requests are counted by provider, and added to the persisted counts
+	get("random")
+	get("random")
+	get("null")
+	require.NoError(t, handler.SaveIndexHits(t.Context()))
+	get("random")
+	require.NoError(t, handler.SaveIndexHits(t.Context()))
+	require.NoError(t, handler.SaveIndexHits(t.Context()), "nothing to save")
+
+	var persisted map[string]int64
+	require.NoError(t, handler.indexes.Load(t.Context(), indexHitsDocument, &persisted))
+	assert.Equal(t, map[string]int64{
+		indexDocument(registry, "hashicorp", "random"): 3,
+		indexDocument(registry, "hashicorp", "null"):   1,
+	}, persisted)
+
+	// After a restart, the most requested indexes are read into the memory cache
+	memory := storage.NewMemoryCache(local, storage.MemoryCacheOptions{MaxBytes: 1 << 20, MaxObjectBytes: 1 << 20})
+	restarted := NewRegistryHandlerWithOptions(logger, memory, RegistryOptions{CountIndexHits: true})
+	loaded, err := restarted.PreloadIndexes(t.Context(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, loaded)
+
+	hits := testutil.ToFloat64(metrics.MemoryCacheRequestsTotal.WithLabelValues("hit"))
+	_, err = restarted.loadIndex(t.Context(), registry, "hashicorp", "random")
+	require.NoError(t, err)
+	assert.Equal(t, hits+1, testutil.ToFloat64(metrics.MemoryCacheRequestsTotal.WithLabelValues("hit")))
+	_, err = restarted.loadIndex(t.Context(), registry, "hashicorp", "null")
+	require.NoError(t, err)
+	assert.Equal(t, hits+1, testutil.ToFloat64(metrics.MemoryCacheRequestsTotal.WithLabelValues("hit")), "only the most requested index is preloaded")
+
+	// Without persisted counts, nothing is preloaded
+	empty := NewRegistryHandlerWithOptions(logger, storage.NewLocalStorage(t.TempDir(), logger), RegistryOptions{})
+	loaded, err = empty.PreloadIndexes(t.Context(), 10)
+	require.NoError(t, err)
+	assert.Zero(t, loaded)
+}
+
+func TestMostRequested(t *testing.T) {
+	counts := map[string]int64{"a": 1, "b": 5, "c": 5, "d": 2}
+	assert.Equal(t, []string{"b", "c", "d"}, mostRequested(counts, 3))
+	assert.Equal(t, []string{"b", "c", "d", "a"}, mostRequested(counts, 10))
+}
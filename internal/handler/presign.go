@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"cachetf/internal/metrics"
+)
+
+// redirectToStorage redirects the client to a presigned URL of a cached file, so the file is downloaded from
+// the storage directly. It returns false if the file must be served by the handler, e.g. when it isn't cached.
+func (h *RegistryHandler) redirectToStorage(c *gin.Context, key, filename string) bool {
+	logger := h.logger.WithField("key", key)
+
+	exists, err := h.storage.Exists(c.Request.Context(), key)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to check cached file before redirecting")
+		return false
+	}
+	if !exists {
+		return false
+	}
+
+	url, err := h.presigner.PresignGet(c.Request.Context(), key, filename, h.presignTTL)
+	if err != nil {
+		// Proxying the file is slower but still works
+		logger.WithError(err).Error("Failed to presign cached file, serving it instead")
+		return false
+	}
+
+	logger.WithField("ttl", h.presignTTL).Info("Redirecting to presigned storage URL")
+	metrics.PresignedRedirectsTotal.Inc()
+
+	// Presigned URLs expire, the redirect must not be reused
+	c.Header("Cache-Control", "no-store")
+	c.Redirect(http.StatusFound, url)
+	return true
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/metrics"
+	"cachetf/internal/storage"
+)
+
+// fakePresigner signs URLs of a fake bucket, or fails if err is set
+type fakePresigner struct {
+	err error
+}
+
+func (p *fakePresigner) PresignGet(ctx context.Context, key, filename string, ttl time.Duration) (string, error) {
+	if p.err != nil {
+		return "", p.err
+	}
+	return fmt.Sprintf("https://bucket.example.com/%s?filename=%s&expires=%d", key, filename, int(ttl.Seconds())), nil
+}
+
+func TestDownloadProvider_PresignedRedirect(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var downloads int32
+	upstream := newPrewarmUpstream(t, &downloads)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	presigner := &fakePresigner{}
+	handler := NewRegistryHandlerWithOptions(logger, storage.NewLocalStorage(t.TempDir(), logger), RegistryOptions{
+		Presigner:  presigner,
+		PresignTTL: time.Minute,
+	})
+	handler.httpClient = upstream.Client()
+	router := newVerifyRouter(handler)
+
+	registry := strings.TrimPrefix(upstream.URL, "https://")
+	key := handler.getCacheKey(registry, "hashicorp", "random", "3.7.2", "linux", "amd64")
+	location := "https://bucket.example.com/" + key + "?filename=terraform-provider-random_3.7.2_linux_amd64.zip&expires=60"
+	download := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/"+registry+"/hashicorp/random/3.7.2/linux/amd64", nil))
+		return w
+	}
+
+	// The binary is cached on a miss, then downloaded from the storage like on a hit
+	before := testutil.ToFloat64(metrics.PresignedRedirectsTotal)
+	for range 2 {
+		w := download()
+		require.Equal(t, http.StatusFound, w.Code, w.Body.String())
+		assert.Equal(t, location, w.Header().Get("Location"))
+		assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&downloads))
+	assert.Equal(t, before+2, testutil.ToFloat64(metrics.PresignedRedirectsTotal))
+
+	// The binary is served by the handler if it can't be presigned
+	presigner.err = errors.New("no credentials")
+	w := download()
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "binary linux/amd64", w.Body.String())
+}
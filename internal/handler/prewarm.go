@@ -0,0 +1,234 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"cachetf/internal/metrics"
+	"cachetf/pkg/validate"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// prewarmTimeout bounds the background downloads of a prewarm request
+const prewarmTimeout = 30 * time.Minute
+
+// Platform is an operating system and architecture pair, written os_arch
+type Platform struct {
+	OS   string `json:"os"`
+	Arch string `json:"arch"`
+}
+
+// String returns the platform in the os_arch format
+func (p Platform) String() string {
+	return p.OS + "_" + p.Arch
+}
+
+// ParsePlatform parses a platform in the os_arch format
+func ParsePlatform(s string) (Platform, error) {
+	osName, arch, ok := strings.Cut(strings.TrimSpace(s), "_")
+	if !ok || !validate.OS(osName) || !validate.Arch(arch) {
+		return Platform{}, fmt.Errorf("invalid platform %q: expected os_arch, e.g. linux_amd64", s)
+	}
+	return Platform{OS: osName, Arch: arch}, nil
+}
+
+// PrewarmRequest is the optional request body of PrewarmProvider
+type PrewarmRequest struct {
+	// Platforms lists the platforms to cache in the os_arch format, all platforms of the version if empty
+	Platforms []string `json:"platforms"`
+}
+
+// PrewarmResult counts the outcome of a prewarm
+type PrewarmResult struct {
+	// Cached is the number of binaries downloaded
+	Cached int `json:"cached"`
+	// Skipped is the number of binaries that were cached already
+	Skipped int `json:"skipped"`
+	// Failed is the number of binaries that couldn't be cached
+	Failed int `json:"failed"`
+}
+
+// PrewarmProvider handles POST requests caching the binaries of a provider version in the background
+func (h *RegistryHandler) PrewarmProvider(c *gin.Context) {
+	if h.offline {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": errOffline.Error()})
+		return
+	}
+
+	registry := c.Param("registry")
+	namespace := c.Param("namespace")
+	provider := c.Param("provider")
+	version := c.Param("version")
+
+	if !h.isAllowedRegistry(registry) || !validate.Namespace(namespace) ||
+		!validate.Provider(provider) || !validate.Version(version) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid parameters"})
+		return
+	}
+
+	// The body is optional
+	var req PrewarmRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+			return
+		}
+	}
+
+	var platforms []Platform
+	for _, value := range req.Platforms {
+		platform, err := ParsePlatform(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		platforms = append(platforms, platform)
+	}
+
+	// Default to every platform upstream publishes the version for
+	if len(platforms) == 0 {
+		var err error
+		platforms, err = h.versionPlatforms(c.Request.Context(), registry, namespace, provider, version)
+		if err != nil {
+			var statusErr *upstreamStatusError
+			switch {
+			case errors.Is(err, errVersionNotFound):
+				c.JSON(http.StatusNotFound, gin.H{"error": "version not found"})
+			case errors.As(err, &statusErr):
+				c.JSON(http.StatusBadGateway, gin.H{
+					"error":  "failed to fetch provider versions",
+					"status": statusErr.Status,
+				})
+			default:
+				h.logger.WithError(err).Error("Failed to fetch provider versions")
+				c.JSON(http.StatusBadGateway, gin.H{"error": "failed to fetch provider versions"})
+			}
+			return
+		}
+	}
+
+	// Keep downloading after the response is sent
+	principal := principalName(c)
+	ctx := context.WithoutCancel(c.Request.Context())
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, prewarmTimeout)
+		defer cancel()
+		h.Prewarm(ctx, registry, namespace, provider, version, platforms, principal)
+	}()
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"registry":  registry,
+		"namespace": namespace,
+		"provider":  provider,
+		"version":   version,
+		"platforms": platforms,
+	})
+}
+
+// Prewarm caches the binaries of a provider version for the given platforms, skipping those cached already.
+// principal is recorded as the cause of the downloads.
+func (h *RegistryHandler) Prewarm(ctx context.Context, registry, namespace, provider, version string, platforms []Platform, principal string) PrewarmResult {
+	return h.prewarm(ctx, registry, namespace, provider, version, platforms, principal, nil)
+}
+
+// prewarm caches the binaries of a provider version for the given platforms. When zipHashes isn't empty,
+// binaries whose upstream checksum isn't one of them are refused.
+func (h *RegistryHandler) prewarm(ctx context.Context, registry, namespace, provider, version string, platforms []Platform, principal string, zipHashes []string) PrewarmResult {
+	var result PrewarmResult
+	for _, platform := range platforms {
+		if ctx.Err() != nil {
+			result.Failed++
+			continue
+		}
+
+		key := h.getCacheKey(registry, namespace, provider, version, platform.OS, platform.Arch)
+		logger := h.logger.WithFields(logrus.Fields{
+			"key":       key,
+			"principal": principal,
+		})
+
+		exists, err := h.storage.Exists(ctx, key)
+		if err == nil && exists {
+			result.Skipped++
+			metrics.PrewarmTotal.WithLabelValues("skipped").Inc()
+			continue
+		}
+
+		if len(zipHashes) > 0 {
+			sum, err := h.expectedSHA256(ctx, registry, namespace, provider, version, platform.OS, platform.Arch)
+			if err == nil && !slices.Contains(zipHashes, sum) {
+				err = fmt.Errorf("upstream checksum %s isn't listed in the lock file", sum)
+			}
+			if err != nil {
+				result.Failed++
+				metrics.PrewarmTotal.WithLabelValues("failed").Inc()
+				logger.WithError(err).Error("Refusing to prewarm provider binary")
+				continue
+			}
+		}
+
+		if err := h.cacheProvider(ctx, registry, namespace, provider, version, platform.OS, platform.Arch, principal); err != nil {
+			result.Failed++
+			metrics.PrewarmTotal.WithLabelValues("failed").Inc()
+			logger.WithError(err).Warn("Failed to prewarm provider binary")
+			continue
+		}
+		result.Cached++
+		metrics.PrewarmTotal.WithLabelValues("cached").Inc()
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"registry":  registry,
+		"namespace": namespace,
+		"provider":  provider,
+		"version":   version,
+		"cached":    result.Cached,
+		"skipped":   result.Skipped,
+		"failed":    result.Failed,
+	}).Info("Prewarm finished")
+
+	return result
+}
+
+// versionPlatforms returns the platforms upstream publishes a provider version for
+func (h *RegistryHandler) versionPlatforms(ctx context.Context, registry, namespace, provider, version string) ([]Platform, error) {
+	versions, err := h.Versions(ctx, registry, namespace, provider)
+	if err != nil {
+		return nil, err
+	}
+
+	platforms, ok := versions[version]
+	if !ok {
+		return nil, errVersionNotFound
+	}
+	return platforms, nil
+}
+
+// Versions returns the versions upstream publishes for a provider, with the platforms of each version
+func (h *RegistryHandler) Versions(ctx context.Context, registry, namespace, provider string) (map[string][]Platform, error) {
+	response, err := h.fetchProviderVersions(ctx, registry, namespace, provider)
+	if err != nil {
+		return nil, err
+	}
+	h.saveIndex(ctx, registry, namespace, provider, response)
+
+	versions := make(map[string][]Platform, len(response.Versions))
+	for _, v := range response.Versions {
+		platforms := make([]Platform, 0, len(v.Platforms))
+		for _, p := range v.Platforms {
+			// Platforms this mirror can't serve are ignored
+			if validate.OS(p.OS) && validate.Arch(p.Arch) {
+				platforms = append(platforms, Platform{OS: p.OS, Arch: p.Arch})
+			}
+		}
+		versions[v.Version] = platforms
+	}
+	return versions, nil
+}
@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/storage"
+)
+
+// newPrewarmUpstream starts a fake registry publishing random 3.7.2 for linux_amd64, linux_arm64 and darwin_arm64.
+// Only windows_amd64 can't be downloaded.
+func newPrewarmUpstream(t *testing.T, downloads *int32) *httptest.Server {
+	var upstream *httptest.Server
+	upstream = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/providers/hashicorp/random/versions" {
+			_, _ = w.Write([]byte(`{"versions":[{"version":"3.7.2","platforms":[
+				{"os":"linux","arch":"amd64"},{"os":"linux","arch":"arm64"},{"os":"darwin","arch":"arm64"},{"os":"plan9","arch":"amd64"}]}]}`))
+			return
+		}
+
+		if platform, ok := strings.CutPrefix(r.URL.Path, "/v1/providers/hashicorp/random/3.7.2/download/"); ok && platform != "windows/amd64" {
+			content := "binary " + platform
+			sum := sha256.Sum256([]byte(content))
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"download_url": upstream.URL + "/files/" + strings.ReplaceAll(platform, "/", "_"),
+				"shasum":       hex.EncodeToString(sum[:]),
+			})
+			return
+		}
+
+		if platform, ok := strings.CutPrefix(r.URL.Path, "/files/"); ok {
+			atomic.AddInt32(downloads, 1)
+			_, _ = w.Write([]byte("binary " + strings.ReplaceAll(platform, "_", "/")))
+			return
+		}
+
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+func TestPrewarm(t *testing.T) {
+	var downloads int32
+	upstream := newPrewarmUpstream(t, &downloads)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	store := storage.NewLocalStorage(t.TempDir(), logger)
+	handler := NewRegistryHandler(logger, store)
+	handler.httpClient = upstream.Client()
+
+	registry := strings.TrimPrefix(upstream.URL, "https://")
+	platforms := []Platform{{OS: "linux", Arch: "amd64"}, {OS: "windows", Arch: "amd64"}}
+
+	// windows_amd64 isn't published upstream
+	result := handler.Prewarm(t.Context(), registry, "hashicorp", "random", "3.7.2", platforms, "ci")
+	assert.Equal(t, PrewarmResult{Cached: 1, Failed: 1}, result)
+
+	// Cached binaries are skipped
+	result = handler.Prewarm(t.Context(), registry, "hashicorp", "random", "3.7.2", platforms[:1], "ci")
+	assert.Equal(t, PrewarmResult{Skipped: 1}, result)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&downloads))
+}
+
+func TestPrewarmProvider(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name         string
+		version      string
+		body         string
+		expectedCode int
+		expected     []string
+	}{
+		{"all platforms", "3.7.2", "", http.StatusAccepted, []string{"linux_amd64", "linux_arm64", "darwin_arm64"}},
+		{"selected platforms", "3.7.2", `{"platforms":["darwin_arm64"]}`, http.StatusAccepted, []string{"darwin_arm64"}},
+		{"invalid platform", "3.7.2", `{"platforms":["linux"]}`, http.StatusBadRequest, nil},
+		{"invalid body", "3.7.2", `{`, http.StatusBadRequest, nil},
+		{"unknown version", "9.9.9", "", http.StatusNotFound, nil},
+		{"invalid version", "latest", "", http.StatusBadRequest, nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var downloads int32
+			upstream := newPrewarmUpstream(t, &downloads)
+
+			logger := logrus.New()
+			logger.SetOutput(io.Discard)
+			store := storage.NewLocalStorage(t.TempDir(), logger)
+			handler := NewRegistryHandler(logger, store)
+			handler.httpClient = upstream.Client()
+
+			router := gin.New()
+			router.POST("/prewarm/:registry/:namespace/:provider/:version", handler.PrewarmProvider)
+
+			registry := strings.TrimPrefix(upstream.URL, "https://")
+			req, _ := http.NewRequest("POST", fmt.Sprintf("/prewarm/%s/hashicorp/random/%s", registry, tc.version), strings.NewReader(tc.body))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tc.expectedCode, w.Code, w.Body.String())
+			if tc.expectedCode != http.StatusAccepted {
+				return
+			}
+
+			var response struct {
+				Platforms []Platform `json:"platforms"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			var platforms []string
+			for _, p := range response.Platforms {
+				platforms = append(platforms, p.String())
+			}
+			assert.Equal(t, tc.expected, platforms)
+
+			// The binaries are downloaded in the background
+			for _, platform := range response.Platforms {
+				key := handler.getCacheKey(registry, "hashicorp", "random", "3.7.2", platform.OS, platform.Arch)
+				assert.Eventually(t, func() bool {
+					exists, _ := store.Exists(t.Context(), key)
+					return exists
+				}, 5*time.Second, 10*time.Millisecond, key)
+			}
+		})
+	}
+}
+
+func TestParsePlatform(t *testing.T) {
+	platform, err := ParsePlatform("linux_arm64")
+	require.NoError(t, err)
+	assert.Equal(t, Platform{OS: "linux", Arch: "arm64"}, platform)
+
+	for _, value := range []string{"linux", "linux-amd64", "plan9_amd64", "linux_sparc"} {
+		_, err := ParsePlatform(value)
+		assert.Error(t, err, value)
+	}
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"cachetf/internal/provenance"
+	"cachetf/internal/upstream"
+
+	"github.com/sirupsen/logrus"
+)
+
+// saveOrigin stores the origin record of an artifact that was just cached.
+// Failures are logged only, the artifact is served either way.
+func saveOrigin(ctx context.Context, store *provenance.Store, logger *logrus.Logger, key string, origin *upstream.Origin, size int64, verification provenance.Verification, principal string) {
+	record := &provenance.Record{
+		Key:          key,
+		URL:          origin.URL,
+		Host:         origin.Host,
+		IP:           origin.IP,
+		Status:       origin.Status,
+		Header:       origin.Header,
+		Size:         size,
+		FetchedAt:    time.Now().UTC(),
+		Verification: verification,
+		Principal:    principal,
+	}
+	if err := store.Save(ctx, record); err != nil {
+		logger.WithError(err).WithField("key", key).Warn("Failed to store origin record")
+	}
+}
+
+// recordedSize returns the content length in the origin record of a cached artifact, -1 if unknown
+func recordedSize(ctx context.Context, store *provenance.Store, key string) int64 {
+	if store == nil {
+		return -1
+	}
+	record, err := store.Load(ctx, key)
+	if err != nil || record.Size <= 0 {
+		return -1
+	}
+	return record.Size
+}
+
+// contentLength returns the length of a cached file being served, -1 if unknown. Readers of files on disk know
+// their size, the others, e.g. S3 objects, fall back to the size in the origin record.
+func contentLength(ctx context.Context, store *provenance.Store, key string, reader io.Reader) int64 {
+	if fi, ok := reader.(interface{ Stat() (os.FileInfo, error) }); ok {
+		if stat, err := fi.Stat(); err == nil {
+			return stat.Size()
+		}
+	}
+	return recordedSize(ctx, store, key)
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
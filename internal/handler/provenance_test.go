@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/metadata"
+	"cachetf/internal/provenance"
+	"cachetf/internal/storage"
+)
+
+func TestContentLength(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	dir := t.TempDir()
+	origins := provenance.NewStore(metadata.NewStore(storage.NewLocalStorage(dir, logger), logger))
+	key := "providers/registry.terraform.io/hashicorp/random/3.7.2/terraform-provider-random_3.7.2_linux_amd64.zip"
+	require.NoError(t, origins.Save(t.Context(), &provenance.Record{Key: key, Size: 42}))
+
+	// Files on disk know their size
+	path := filepath.Join(dir, "file.zip")
+	require.NoError(t, os.WriteFile(path, []byte("binary"), 0644))
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	assert.Equal(t, int64(6), contentLength(t.Context(), origins, key, file))
+
+	// Other readers, e.g. S3 objects, fall back to the recorded size
+	assert.Equal(t, int64(42), contentLength(t.Context(), origins, key, strings.NewReader("binary")))
+
+	// Unknown without a record
+	assert.Equal(t, int64(-1), contentLength(t.Context(), origins, "providers/unknown.zip", strings.NewReader("binary")))
+	assert.Equal(t, int64(-1), contentLength(t.Context(), nil, key, strings.NewReader("binary")))
+}
+
+func TestCountingReader(t *testing.T) {
+	r := &countingReader{Reader: strings.NewReader("archive")}
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "archive", string(data))
+	assert.Equal(t, int64(7), r.n)
+}
@@ -6,12 +6,13 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"os"
-	"regexp"
+	"path"
 	"strconv"
 	"strings"
 	"sync"
@@ -20,7 +21,18 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 
+	"cachetf/internal/alert"
+	"cachetf/internal/errclass"
+	"cachetf/internal/layout"
+	"cachetf/internal/metadata"
+	"cachetf/internal/metrics"
+	"cachetf/internal/provenance"
 	"cachetf/internal/storage"
+	"cachetf/internal/transparency"
+	"cachetf/internal/upstream"
+	"cachetf/internal/verify"
+	"cachetf/pkg/logger"
+	"cachetf/pkg/validate"
 )
 
 // RegistryHandler handles Terraform registry API requests
@@ -29,7 +41,103 @@ type RegistryHandler struct {
 	httpClient *http.Client
 	apiVersion string
 	storage    storage.Storage
-	mu         sync.RWMutex // Protects concurrent access to the cache
+	// keys lays the cached files out in the storage
+	keys       layout.Strategy
+	verifier   *verify.GPGVerifier
+	hostPolicy HostPolicy
+	// registries maps the configured registry hosts to their base URL, their tokens are added by the transport
+	registries *upstream.Registries
+	// aliases maps registry hosts to the registry they are served from
+	aliases map[string]string
+	// forwardedAccess remembers the callers the registries forwarding their token let access a provider
+	forwardedAccess *responseCache
+	provenance      *provenance.Store
+	// transparency records the checksum of every cached provider binary
+	transparency *transparency.Log
+	alerts       Alerter
+	// verifyOnServe recomputes the checksum of cached provider binaries while serving them
+	verifyOnServe bool
+	// offline serves exclusively from the cache, upstream is never contacted
+	offline bool
+	// indexes persists the last upstream index of every requested provider
+	indexes *metadata.Store
+	// staleIfError is the max age of the persisted indexes served when upstream fails, disabled if zero
+	staleIfError time.Duration
+	// indexHits counts the index requests of every provider, disabled if nil
+	indexHits *indexHits
+	// indexResponses caches the index.json responses built from fresh upstream versions, disabled if nil
+	indexResponses *responseCache
+	// renderedResponses keeps the encoded index and version responses by upstream listing, disabled if nil
+	renderedResponses *responseCache
+	// presigner redirects clients to the storage for cached provider binaries, disabled if nil
+	presigner  storage.Presigner
+	presignTTL time.Duration
+	// checksums remembers the upstream checksum of provider binaries by cache key
+	checksums sync.Map
+	mu        sync.RWMutex // Protects concurrent access to the cache
+}
+
+// RegistryOptions holds optional settings for a RegistryHandler
+type RegistryOptions struct {
+	// Verifier checks the upstream SHA256SUMS signature before a provider binary is cached.
+	// Signature verification is skipped when it is nil.
+	Verifier *verify.GPGVerifier
+	// Transport sends the upstream requests, http.DefaultTransport if nil
+	Transport http.RoundTripper
+	// HostPolicy decides which registry hosts may be contacted. Only the syntax of
+	// the registry is checked when it is nil.
+	HostPolicy HostPolicy
+	// Registries maps registry hosts to the base URL of their API and the token sent to it, e.g. for private
+	// registries. Configured hosts are trusted regardless of the HostPolicy. Registries are reached at
+	// https://<host> without credentials when it is nil.
+	Registries *upstream.Registries
+	// Provenance stores an origin record for every cached provider binary. Records aren't kept when it is nil.
+	Provenance *provenance.Store
+	// Transparency records the checksum of every cached provider binary. Checksums aren't recorded when it is nil.
+	Transparency *transparency.Log
+	// Alerts is notified of upstream checksum changes, which are only logged when it is nil
+	Alerts Alerter
+	// VerifyOnServe recomputes the SHA256 of cached provider binaries while they are streamed to clients,
+	// for environments that don't trust the storage layer
+	VerifyOnServe bool
+	// Offline answers exclusively from the cache: indexes list the cached binaries and
+	// uncached files are reported missing instead of being downloaded
+	Offline bool
+	// StaleIfError serves the last persisted provider index, up to this old, when the upstream registry is
+	// unreachable or failing. Upstream failures are reported when it is zero.
+	StaleIfError time.Duration
+	// IndexCacheSize is the number of provider index.json responses kept in memory for IndexCacheTTL, so
+	// repeated requests don't reach upstream or the storage. Responses aren't cached when either is zero.
+	IndexCacheSize int
+	// IndexCacheTTL is how long an index.json response is served from memory
+	IndexCacheTTL time.Duration
+	// CountIndexHits counts the index requests of every provider, persisted by SaveIndexHits, so the most
+	// requested indexes can be preloaded on startup
+	CountIndexHits bool
+	// RenderCacheSize is the number of encoded index and version responses reused while the upstream listing
+	// they were built from doesn't change. Responses are encoded for every request when it is zero.
+	RenderCacheSize int
+	// Presigner redirects the downloads of cached provider binaries to presigned storage URLs valid for
+	// PresignTTL, instead of proxying the files. Binaries are served by the handler if nil.
+	Presigner  storage.Presigner
+	PresignTTL time.Duration
+	// Keys lays the cached files out in the storage, layout.Default if nil
+	Keys layout.Strategy
+	// Aliases maps lowercase registry hosts to the registry they are served from. The routes resolve the aliases of
+	// the request paths, the handler those of the registries named in request bodies, e.g. lock files.
+	Aliases map[string]string
+}
+
+// HostPolicy decides whether a registry host may be contacted
+type HostPolicy interface {
+	// CheckRegistry returns an error if requests to the registry host must not be made
+	CheckRegistry(host string) error
+}
+
+// Alerter delivers operational alerts
+type Alerter interface {
+	// Notify sends the event in the background
+	Notify(event alert.Event)
 }
 
 // Logger returns the logger instance for this handler
@@ -49,6 +157,8 @@ type ProviderVersionsResponse struct {
 		} `json:"platforms"`
 	} `json:"versions"`
 	Warnings interface{} `json:"warnings"`
+	// ETag identifies the upstream listing the response was decoded from, empty for persisted listings
+	ETag string `json:"-"`
 }
 
 // ProviderResponse represents the response from the Terraform registry
@@ -103,61 +213,113 @@ type IndexResponse struct {
 
 // NewRegistryHandler creates a new RegistryHandler
 func NewRegistryHandler(logger *logrus.Logger, storage storage.Storage) *RegistryHandler {
+	return NewRegistryHandlerWithOptions(logger, storage, RegistryOptions{})
+}
+
+// NewRegistryHandlerWithOptions creates a new RegistryHandler with optional settings
+func NewRegistryHandlerWithOptions(logger *logrus.Logger, storage storage.Storage, opts RegistryOptions) *RegistryHandler {
 	// Create HTTP client with timeout
 	httpClient := &http.Client{
-		Timeout: 30 * time.Second,
+		Timeout:   30 * time.Second,
+		Transport: opts.Registries.Transport(opts.Transport),
+	}
+	if opts.Offline {
+		httpClient.Transport = offlineTransport{}
+	}
+
+	// Offline indexes list the cached binaries, which change as soon as a binary is cached
+	var indexResponses *responseCache
+	if !opts.Offline {
+		indexResponses = newResponseCache(opts.IndexCacheSize, opts.IndexCacheTTL)
+	}
+
+	keys := opts.Keys
+	if keys == nil {
+		keys = layout.Default
 	}
 
 	return &RegistryHandler{
-		logger:     logger,
-		httpClient: httpClient,
-		apiVersion: "1.0.0",
-		storage:    storage,
+		logger:            logger,
+		httpClient:        httpClient,
+		apiVersion:        "1.0.0",
+		storage:           storage,
+		keys:              keys,
+		verifier:          opts.Verifier,
+		hostPolicy:        opts.HostPolicy,
+		registries:        opts.Registries,
+		aliases:           opts.Aliases,
+		forwardedAccess:   newResponseCache(forwardedAccessEntries, forwardedAccessTTL),
+		provenance:        opts.Provenance,
+		transparency:      opts.Transparency,
+		alerts:            opts.Alerts,
+		verifyOnServe:     opts.VerifyOnServe,
+		offline:           opts.Offline,
+		indexes:           metadata.NewStore(storage, logger),
+		staleIfError:      opts.StaleIfError,
+		indexHits:         newIndexHits(opts.CountIndexHits),
+		indexResponses:    indexResponses,
+		renderedResponses: newResponseCache(opts.RenderCacheSize, renderedResponseTTL),
+		presigner:         opts.Presigner,
+		presignTTL:        opts.PresignTTL,
 	}
 }
 
-// getCacheKey returns the storage key for a cached file in the format:
-// registry/namespace/provider/version/filename.zip
-// where filename is in the format: terraform-provider-{name}_{version}_{os}_{arch}.zip
+// getCacheKey returns the storage key of a provider binary, named in the format:
+// terraform-provider-{name}_{version}_{os}_{arch}.zip
 func (h *RegistryHandler) getCacheKey(registry, namespace, provider, version, platform, arch string) string {
 	// Construct the filename in the standard Terraform provider format
 	filename := fmt.Sprintf("terraform-provider-%s_%s_%s_%s.zip", provider, version, platform, arch)
 
-	// Return the full path with the original filename
-	return fmt.Sprintf("%s/%s/%s/%s/%s",
-		registry, namespace, provider, version, filename)
+	return h.keys.ProviderFile(registry, namespace, provider, version, filename)
 }
 
-// Helper function to download a file and store it with checksum verification
-func (h *RegistryHandler) downloadFile(url, key, expectedSHA256 string) ([]byte, error) {
+// getSHASumsKey returns the storage key for the SHA256SUMS file of a provider version,
+// or for its detached signature when signature is true
+func (h *RegistryHandler) getSHASumsKey(registry, namespace, provider, version string, signature bool) string {
+	filename := fmt.Sprintf("terraform-provider-%s_%s_SHA256SUMS", provider, version)
+	if signature {
+		filename += ".sig"
+	}
+
+	return h.keys.ProviderFile(registry, namespace, provider, version, filename)
+}
+
+// Helper function to download a file and store it with checksum verification. The download outlives the
+// cancellation of ctx, which only forwards its values, such as the caller's credentials.
+func (h *RegistryHandler) downloadFile(ctx context.Context, url, key, expectedSHA256 string) ([]byte, *upstream.Origin, error) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	h.logger.WithFields(logrus.Fields{
-		"url": url,
-		"key": key,
-	}).Debug("Downloading file")
+	logger.Debug(h.logger, "Downloading file", func() logrus.Fields {
+		return logrus.Fields{
+			"url": url,
+			"key": key,
+		}
+	})
 
 	// Download the file
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	// Set a timeout for the request
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Minute)
 	defer cancel()
 
+	// Record where the file is actually downloaded from, after redirects
+	ctx, origin := upstream.WithOrigin(ctx)
 	req = req.WithContext(ctx)
 
+	start := time.Now()
 	resp, err := h.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to download file: %w", err)
+		return nil, nil, upstreamError("download", fmt.Errorf("failed to download file: %w", err))
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, nil, upstreamError("download", &errclass.StatusError{StatusCode: resp.StatusCode})
 	}
 
 	// Create a buffer to store the downloaded data for checksum verification
@@ -170,90 +332,60 @@ func (h *RegistryHandler) downloadFile(url, key, expectedSHA256 string) ([]byte,
 	// Download the file to memory for checksum verification
 	data, err := io.ReadAll(io.TeeReader(resp.Body, multiWriter))
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return nil, nil, upstreamError("download", fmt.Errorf("failed to read response body: %w", err))
 	}
 
 	// Verify the checksum if provided
 	if expectedSHA256 != "" {
 		computedSum := hex.EncodeToString(hasher.Sum(nil))
 		if computedSum != expectedSHA256 {
-			return nil, fmt.Errorf("checksum verification failed: expected %s, got %s",
-				expectedSHA256, computedSum)
+			return nil, nil, upstreamError("download", fmt.Errorf("%w: expected %s, got %s",
+				errclass.ErrChecksum, expectedSHA256, computedSum))
 		}
 	}
+	observeStage(stageDownload, start)
 
 	// Store the file in the storage backend
+	start = time.Now()
 	if err := h.storage.Put(context.Background(), key, bytes.NewReader(data)); err != nil {
-		return nil, fmt.Errorf("failed to store file: %w", err)
+		// The verified file can still be served without caching it
+		if errors.Is(err, storage.ErrInsufficientSpace) {
+			return nil, nil, &notCachedError{data: data, err: err}
+		}
+		return nil, nil, fmt.Errorf("failed to store file: %w", err)
 	}
+	observeStage(stageStore, start)
 
-	h.logger.WithField("key", key).Debug("Successfully downloaded and verified file")
-	return data, nil
+	logFill(h.logger, key, origin)
+	logger.Debug(h.logger, "Successfully downloaded and verified file", func() logrus.Fields { return logrus.Fields{"key": key} })
+	return data, origin, nil
 }
 
-// Validation functions
-func isValidOS(osName string) bool {
-	// Common Terraform operating systems
-	validOS := map[string]bool{
-		"darwin":  true,
-		"freebsd": true,
-		"linux":   true,
-		"openbsd": true,
-		"solaris": true,
-		"windows": true,
+// resolveAlias returns the registry a registry host is served from, the host itself if it isn't an alias
+func (h *RegistryHandler) resolveAlias(registry string) string {
+	if resolved, ok := h.aliases[strings.ToLower(registry)]; ok {
+		return resolved
 	}
-	_, valid := validOS[osName]
-	return valid
+	return registry
 }
 
-func isValidRegistry(registry string) bool {
-	// Registry should be a valid hostname or IP address
-	// For now, just check it's not empty and doesn't contain invalid characters
-	if registry == "" {
+// isAllowedRegistry checks the registry syntax and, if configured, the host policy,
+// so the registry path segment can't be used to reach internal services. Configured
+// registries are trusted.
+func (h *RegistryHandler) isAllowedRegistry(registry string) bool {
+	if !validate.Registry(registry) {
 		return false
 	}
-	// Basic check for invalid characters
-	return !strings.ContainsAny(registry, " /\\")
-}
-
-func isValidNamespace(namespace string) bool {
-	// Namespace should be a valid DNS label (alphanumeric and hyphens, not starting/ending with hyphen)
-	if namespace == "" {
-		return false
-	}
-	matched, _ := regexp.MatchString(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?$`, namespace)
-	return matched
-}
-
-func isValidProvider(provider string) bool {
-	// Provider name should be alphanumeric with hyphens
-	if provider == "" {
-		return false
+	if _, ok := h.registries.BaseURL(registry); ok {
+		return true
 	}
-	matched, _ := regexp.MatchString(`^[a-z0-9-]+$`, provider)
-	return matched && !strings.HasPrefix(provider, "-") && !strings.HasSuffix(provider, "-")
-}
-
-func isValidVersion(version string) bool {
-	// Version should be in semantic versioning format (e.g., 1.2.3)
-	if version == "" {
-		return false
-	}
-	matched, _ := regexp.MatchString(`^\d+\.\d+\.\d+(-[a-zA-Z0-9.+-]+)?(\+[a-zA-Z0-9.+-]+)?$`, version)
-	return matched
-}
-
-func isValidArch(arch string) bool {
-	// Common Terraform architectures
-	validArches := map[string]bool{
-		"386":     true,
-		"amd64":   true,
-		"arm":     true,
-		"arm64":   true,
-		"ppc64le": true,
+	if h.hostPolicy != nil {
+		if err := h.hostPolicy.CheckRegistry(registry); err != nil {
+			h.logger.WithError(err).WithField("registry", registry).Warn("Refused registry host")
+			return false
+		}
 	}
-	_, valid := validArches[arch]
-	return valid
+	return true
 }
 
 // isBrokenPipeError checks if the error is a broken pipe error
@@ -267,39 +399,50 @@ func isBrokenPipeError(err error) bool {
 	return false
 }
 
-// GetProviderIndex returns the provider index
-func (h *RegistryHandler) GetProviderIndex(c *gin.Context) {
-	registry := c.Param("registry")
-	namespace := c.Param("namespace")
-	provider := c.Param("provider")
+// errInvalidUpstreamResponse is returned when the upstream registry response can't be parsed
+var errInvalidUpstreamResponse = errors.New("invalid upstream response")
 
-	h.logger.WithFields(logrus.Fields{
-		"registry":  registry,
-		"namespace": namespace,
-		"provider":  provider,
-	}).Info("Provider index requested")
+// upstreamStatusError is returned when the upstream registry responds with an unexpected status
+type upstreamStatusError struct {
+	StatusCode int
+	Status     string
+}
 
-	// Validate parameters
-	if !isValidRegistry(registry) || !isValidNamespace(namespace) || !isValidProvider(provider) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid parameters"})
-		return
-	}
+func (e *upstreamStatusError) Error() string {
+	return fmt.Sprintf("unexpected response from registry: %s", e.Status)
+}
+
+// HTTPStatus returns the response status code, to classify the error
+func (e *upstreamStatusError) HTTPStatus() int {
+	return e.StatusCode
+}
 
-	// Build the registry API URL
-	baseURL := registry
-	if !strings.HasPrefix(baseURL, "http") {
-		baseURL = "https://" + baseURL
+// upstreamError counts a failed upstream operation in cache_errors_total by error class and returns err
+func upstreamError(operation string, err error) error {
+	metrics.RecordError(metrics.ComponentUpstream, operation, err)
+	return err
+}
+
+// registryBaseURL returns the base URL of the upstream registry, the configured one if any
+func (h *RegistryHandler) registryBaseURL(registry string) string {
+	if baseURL, ok := h.registries.BaseURL(registry); ok {
+		return baseURL
 	}
-	url := fmt.Sprintf("%s/v1/providers/%s/%s/versions", baseURL, namespace, provider)
+	if strings.HasPrefix(registry, "http") {
+		return registry
+	}
+	return "https://" + registry
+}
 
-	h.logger.WithField("url", url).Debug("Fetching provider versions from registry")
+// fetchProviderVersions fetches the list of versions and platforms of a provider from the upstream registry
+func (h *RegistryHandler) fetchProviderVersions(ctx context.Context, registry, namespace, provider string) (*ProviderVersionsResponse, error) {
+	url := fmt.Sprintf("%s/v1/providers/%s/%s/versions", h.registryBaseURL(registry), namespace, provider)
 
-	// Make the request to the registry
-	req, err := http.NewRequest("GET", url, nil)
+	logger.Debug(h.logger, "Fetching provider versions from registry", func() logrus.Fields { return logrus.Fields{"url": url} })
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		h.logger.WithError(err).Error("Failed to create request")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
-		return
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	// Add Terraform user agent
@@ -307,9 +450,7 @@ func (h *RegistryHandler) GetProviderIndex(c *gin.Context) {
 
 	resp, err := h.httpClient.Do(req)
 	if err != nil {
-		h.logger.WithError(err).Error("Failed to fetch provider versions")
-		c.JSON(http.StatusBadGateway, gin.H{"error": "failed to fetch provider versions"})
-		return
+		return nil, upstreamError("versions", fmt.Errorf("failed to fetch provider versions: %w", err))
 	}
 	defer resp.Body.Close()
 
@@ -320,24 +461,117 @@ func (h *RegistryHandler) GetProviderIndex(c *gin.Context) {
 			"status": resp.Status,
 			"body":   string(body),
 		}).Error("Unexpected response from registry")
-		c.JSON(http.StatusBadGateway, gin.H{
-			"error":  "failed to fetch provider versions",
+		return nil, upstreamError("versions", &upstreamStatusError{StatusCode: resp.StatusCode, Status: resp.Status})
+	}
+
+	var versionsResp ProviderVersionsResponse
+	if err := json.Unmarshal(body, &versionsResp); err != nil {
+		h.logger.WithError(err).Error("Failed to parse provider versions response")
+		return nil, upstreamError("versions", fmt.Errorf("%w: %v", errInvalidUpstreamResponse, err))
+	}
+	versionsResp.ETag = listingETag(body)
+
+	return &versionsResp, nil
+}
+
+// fetchDownloadInfo fetches the download information of a provider binary from the upstream registry
+func (h *RegistryHandler) fetchDownloadInfo(ctx context.Context, registry, namespace, provider, version, osName, arch string) (*DownloadResponse, error) {
+	downloadURL := fmt.Sprintf("%s/v1/providers/%s/%s/%s/download/%s/%s",
+		h.registryBaseURL(registry),
+		namespace,
+		provider,
+		version,
+		osName,
+		arch,
+	)
+
+	logger.Debug(h.logger, "Fetching download info from upstream", func() logrus.Fields { return logrus.Fields{"url": downloadURL} })
+
+	req, err := http.NewRequestWithContext(ctx, "GET", downloadURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return nil, upstreamError("download_info", fmt.Errorf("failed to fetch download info: %w", err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		h.logger.WithFields(logrus.Fields{
 			"status": resp.Status,
-		})
+			"body":   string(body),
+		}).Error("Unexpected response from registry")
+		return nil, upstreamError("download_info", &upstreamStatusError{StatusCode: resp.StatusCode, Status: resp.Status})
+	}
+
+	var downloadInfo DownloadResponse
+	if err := json.NewDecoder(resp.Body).Decode(&downloadInfo); err != nil {
+		h.logger.WithError(err).Error("Failed to parse download info response")
+		return nil, upstreamError("download_info", fmt.Errorf("%w: %v", errInvalidUpstreamResponse, err))
+	}
+
+	return &downloadInfo, nil
+}
+
+// GetProviderIndex returns the provider index
+func (h *RegistryHandler) GetProviderIndex(c *gin.Context) {
+	registry := c.Param("registry")
+	namespace := c.Param("namespace")
+	provider := c.Param("provider")
+
+	logger.Debug(h.logger, "Provider index requested", func() logrus.Fields {
+		return logrus.Fields{
+			"registry":  registry,
+			"namespace": namespace,
+			"provider":  provider,
+		}
+	})
+
+	// Validate parameters
+	if !h.isAllowedRegistry(registry) || !validate.Namespace(namespace) || !validate.Provider(provider) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid parameters"})
 		return
 	}
 
-	// Parse the response to get the list of versions
-	var versionsResp struct {
-		ID       string `json:"id"`
-		Versions []struct {
-			Version string `json:"version"`
-		} `json:"versions"`
+	// The cached responses are only served to callers allowed to list the provider
+	ctx, ok := h.authorizeForwarded(c, c, registry, namespace, provider)
+	if !ok {
+		return
 	}
 
-	if err := json.Unmarshal(body, &versionsResp); err != nil {
-		h.logger.WithError(err).Error("Failed to parse provider versions response")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to parse provider versions"})
+	h.indexHits.add(indexDocument(registry, namespace, provider))
+
+	if h.offline {
+		h.serveOfflineIndex(c, registry, namespace, provider)
+		return
+	}
+
+	cacheKey := h.keys.ProviderPrefix(registry, namespace, provider)
+	if h.indexResponses != nil {
+		if body, ok := h.indexResponses.get(cacheKey); ok {
+			metrics.IndexCacheRequestsTotal.WithLabelValues("hit").Inc()
+			writeJSON(c, body)
+			return
+		}
+		metrics.IndexCacheRequestsTotal.WithLabelValues("miss").Inc()
+	}
+
+	versionsResp, age, err := h.providerVersions(ctx, registry, namespace, provider)
+	if err != nil {
+		h.respondVersionsError(c, err)
+		return
+	}
+	setStaleHeaders(c, age)
+
+	renderKey := cacheKey + "/index.json"
+	if body, ok := h.cachedRendering(renderKey, versionsResp.ETag); ok {
+		h.indexResponses.put(cacheKey, body)
+		writeJSON(c, body)
 		return
 	}
 
@@ -347,15 +581,26 @@ func (h *RegistryHandler) GetProviderIndex(c *gin.Context) {
 		versionsMap[v.Version] = struct{}{}
 	}
 
-	h.logger.WithFields(logrus.Fields{
-		"provider": provider,
-		"versions": len(versionsMap),
-	}).Info("Returning provider versions")
+	logger.Debug(h.logger, "Returning provider versions", func() logrus.Fields {
+		return logrus.Fields{
+			"provider": provider,
+			"versions": len(versionsMap),
+		}
+	})
 
 	// Return the versions in the expected format with empty objects as values
-	c.JSON(http.StatusOK, gin.H{
-		"versions": versionsMap,
-	})
+	body, err := json.Marshal(gin.H{"versions": versionsMap})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encode provider versions"})
+		return
+	}
+
+	// Stale responses are served again only while upstream fails
+	if age == 0 {
+		h.indexResponses.put(cacheKey, body)
+	}
+	h.storeRendering(renderKey, versionsResp.ETag, body)
+	writeJSON(c, body)
 }
 
 // GetProviderVersion returns the provider version details
@@ -378,143 +623,100 @@ func (h *RegistryHandler) GetProviderVersion(c *gin.Context) {
 	}
 
 	// Validate parameters
-	if !isValidRegistry(registry) || !isValidNamespace(namespace) ||
-		!isValidProvider(provider) || !isValidVersion(version) {
+	if !h.isAllowedRegistry(registry) || !validate.Namespace(namespace) ||
+		!validate.Provider(provider) || !validate.Version(version) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid parameters"})
 		return
 	}
 
-	h.logger.WithFields(logrus.Fields{
-		"registry":  registry,
-		"namespace": namespace,
-		"provider":  provider,
-		"version":   version,
-	}).Info("Fetching provider version details")
+	logger.Debug(h.logger, "Fetching provider version details", func() logrus.Fields {
+		return logrus.Fields{
+			"registry":  registry,
+			"namespace": namespace,
+			"provider":  provider,
+			"version":   version,
+		}
+	})
 
-	// Build the registry API URL
-	baseURL := registry
-	if !strings.HasPrefix(baseURL, "http") {
-		baseURL = "https://" + baseURL
+	ctx, ok := h.authorizeForwarded(c, c, registry, namespace, provider)
+	if !ok {
+		return
 	}
-	url := fmt.Sprintf("%s/v1/providers/%s/%s/versions", baseURL, namespace, provider)
 
-	h.logger.WithField("url", url).Debug("Fetching provider versions from registry")
-
-	// Make the request to the registry
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		h.logger.WithError(err).Error("Failed to create request")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+	if h.offline {
+		h.serveOfflineVersion(c, registry, namespace, provider, version)
 		return
 	}
 
-	// Add Terraform user agent
-	req.Header.Set("User-Agent", "Terraform/1.0.0")
-
-	resp, err := h.httpClient.Do(req)
+	versionsResp, age, err := h.providerVersions(ctx, registry, namespace, provider)
 	if err != nil {
-		h.logger.WithError(err).Error("Failed to fetch provider versions")
-		c.JSON(http.StatusBadGateway, gin.H{"error": "failed to fetch provider versions"})
+		h.respondVersionsError(c, err)
 		return
 	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
+	setStaleHeaders(c, age)
 
-	if resp.StatusCode != http.StatusOK {
-		h.logger.WithFields(logrus.Fields{
-			"status": resp.Status,
-			"body":   string(body),
-		}).Error("Unexpected response from registry")
-		c.JSON(http.StatusBadGateway, gin.H{
-			"error":  "failed to fetch provider versions",
-			"status": resp.Status,
-		})
+	renderKey := h.keys.ProviderPrefix(registry, namespace, provider) + version + ".json"
+	if body, ok := h.cachedRendering(renderKey, versionsResp.ETag); ok {
+		writeJSON(c, body)
 		return
 	}
 
-	// Parse the response
-	var versionsResp struct {
-		ID       string `json:"id"`
-		Versions []struct {
-			Version   string   `json:"version"`
-			Protocols []string `json:"protocols"`
-			Platforms []struct {
-				OS   string `json:"os"`
-				Arch string `json:"arch"`
-			} `json:"platforms"`
-		} `json:"versions"`
-	}
-
-	if err := json.Unmarshal(body, &versionsResp); err != nil {
-		h.logger.WithError(err).Error("Failed to parse provider versions response")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to parse provider versions"})
-		return
+	var foundVersion *struct {
+		Version   string   `json:"version"`
+		Protocols []string `json:"protocols"`
+		Platforms []struct {
+			OS   string `json:"os"`
+			Arch string `json:"arch"`
+		} `json:"platforms"`
 	}
 
-	// Build the response with all available versions
-	versions := make([]string, 0, len(versionsResp.Versions))
-	for _, v := range versionsResp.Versions {
-		versions = append(versions, v.Version)
-	}
-
-	// If a specific version was requested, return its details
-	if version != "" && version != "index.json" {
-		var foundVersion *struct {
-			Version   string   `json:"version"`
-			Protocols []string `json:"protocols"`
-			Platforms []struct {
-				OS   string `json:"os"`
-				Arch string `json:"arch"`
-			} `json:"platforms"`
-		}
-
-		for i, v := range versionsResp.Versions {
-			if v.Version == version {
-				// Create a copy of the version to avoid referencing loop variable
-				ver := versionsResp.Versions[i]
-				foundVersion = &ver
-				break
-			}
+	for i, v := range versionsResp.Versions {
+		if v.Version == version {
+			// Create a copy of the version to avoid referencing loop variable
+			ver := versionsResp.Versions[i]
+			foundVersion = &ver
+			break
 		}
+	}
 
-		if foundVersion == nil {
-			h.logger.WithField("version", version).Warn("Version not found")
-			c.JSON(http.StatusNotFound, gin.H{"error": "version not found"})
-			return
-		}
+	if foundVersion == nil {
+		h.logger.WithField("version", version).Warn("Version not found")
+		c.JSON(http.StatusNotFound, gin.H{"error": "version not found"})
+		return
+	}
 
-		// Build the response for specific version
-		response := VersionResponse{
-			Archives: make(map[string]ArchiveInfo),
-		}
+	// Build the response for specific version
+	response := VersionResponse{
+		Archives: make(map[string]ArchiveInfo),
+	}
 
-		// Add each platform/arch combination to the response
-		for _, platform := range foundVersion.Platforms {
-			key := fmt.Sprintf("%s_%s", platform.OS, platform.Arch)
-			filename := fmt.Sprintf("terraform-provider-%s_%s_%s_%s.zip",
-				provider, version, platform.OS, platform.Arch)
+	// Add each platform/arch combination to the response
+	for _, platform := range foundVersion.Platforms {
+		key := fmt.Sprintf("%s_%s", platform.OS, platform.Arch)
+		filename := fmt.Sprintf("terraform-provider-%s_%s_%s_%s.zip",
+			provider, version, platform.OS, platform.Arch)
 
-			response.Archives[key] = ArchiveInfo{
-				URL: filename,
-			}
+		response.Archives[key] = ArchiveInfo{
+			URL: filename,
 		}
+	}
 
-		h.logger.WithFields(logrus.Fields{
+	logger.Debug(h.logger, "Returning version details", func() logrus.Fields {
+		return logrus.Fields{
 			"registry":  registry,
 			"namespace": namespace,
 			"provider":  provider,
 			"version":   version,
-		}).Info("Returning version details")
+		}
+	})
 
-		c.JSON(http.StatusOK, response)
+	body, err := json.Marshal(response)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encode provider version"})
 		return
 	}
-
-	// Return the list of versions if no specific version was requested
-	c.JSON(http.StatusOK, gin.H{
-		"versions": versions,
-	})
+	h.storeRendering(renderKey, versionsResp.ETag, body)
+	writeJSON(c, body)
 }
 
 // DownloadProvider downloads the provider binary
@@ -549,18 +751,20 @@ func (h *RegistryHandler) DownloadProvider(c *gin.Context) {
 	osName := osVal.(string)
 	arch := archVal.(string)
 
-	h.logger.WithFields(logrus.Fields{
-		"registry":  registry,
-		"namespace": namespace,
-		"provider":  provider,
-		"version":   version,
-		"os":        osName,
-		"arch":      arch,
-	}).Debug("Processing download request")
+	logger.Debug(h.logger, "Processing download request", func() logrus.Fields {
+		return logrus.Fields{
+			"registry":  registry,
+			"namespace": namespace,
+			"provider":  provider,
+			"version":   version,
+			"os":        osName,
+			"arch":      arch,
+		}
+	})
 
 	// Validate inputs
-	if !isValidRegistry(registry) || !isValidNamespace(namespace) || !isValidProvider(provider) ||
-		!isValidVersion(version) || !isValidOS(osName) || !isValidArch(arch) {
+	if !h.isAllowedRegistry(registry) || !validate.Namespace(namespace) || !validate.Provider(provider) ||
+		!validate.Version(version) || !validate.OS(osName) || !validate.Arch(arch) {
 		h.logger.WithFields(logrus.Fields{
 			"registry":  registry,
 			"namespace": namespace,
@@ -573,23 +777,48 @@ func (h *RegistryHandler) DownloadProvider(c *gin.Context) {
 		return
 	}
 
+	// Cached binaries of private providers are only served to callers the upstream registry lets access them
+	ctx, ok := h.authorizeForwarded(c, c.Request.Context(), registry, namespace, provider)
+	if !ok {
+		return
+	}
+
 	// Construct the filename
 	filename := fmt.Sprintf("terraform-provider-%s_%s_%s_%s.zip", provider, version, osName, arch)
 
 	// Get the cache key
 	cacheKey := h.getCacheKey(registry, namespace, provider, version, osName, arch)
 
+	// Cached files are downloaded from the storage directly, there is nothing to verify them with
+	if h.presigner != nil && !h.verifyOnServe && h.redirectToStorage(c, cacheKey, filename) {
+		return
+	}
+
 	// Try to get the file directly - this will handle cache hit/miss metrics
-	h.logger.WithField("key", cacheKey).Debug("Attempting to get file from cache")
+	logger.Debug(h.logger, "Attempting to get file from cache", func() logrus.Fields { return logrus.Fields{"key": cacheKey} })
 	fileReader, err := h.storage.Get(c.Request.Context(), cacheKey)
 	if err == nil {
 		// File exists in cache, serve it
 		defer fileReader.Close()
 		h.logger.WithField("key", cacheKey).Info("Serving from cache")
 
+		if h.verifyOnServe {
+			expected, err := h.expectedSHA256(c.Request.Context(), registry, namespace, provider, version, osName, arch)
+			if err != nil {
+				h.logger.WithError(err).WithField("key", cacheKey).Error("Failed to get checksum to verify cached file")
+				c.JSON(http.StatusBadGateway, gin.H{"error": "failed to verify cached provider binary"})
+				return
+			}
+			h.serveVerified(c, cacheKey, filename, fileReader, expected)
+			return
+		}
+
 		// Set the appropriate headers
 		c.Header("Content-Type", "application/zip")
 		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+		if size := contentLength(c.Request.Context(), h.provenance, cacheKey, fileReader); size >= 0 {
+			c.Header("Content-Length", strconv.FormatInt(size, 10))
+		}
 
 		// Stream the file
 		_, err = io.Copy(c.Writer, fileReader)
@@ -597,94 +826,76 @@ func (h *RegistryHandler) DownloadProvider(c *gin.Context) {
 			h.logger.WithError(err).Error("Failed to send file")
 		}
 		return
-	} else if err != os.ErrNotExist {
+	} else if !errors.Is(err, os.ErrNotExist) {
 		// Handle other errors
 		h.logger.WithError(err).WithField("key", cacheKey).Error("Failed to get file from cache")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get file from cache"})
 		return
 	}
 
-	// File not in cache, download it
-	h.logger.WithField("key", cacheKey).Info("File not found in cache, downloading...")
-
-	// Get the download URL from the upstream registry
-	downloadURL := fmt.Sprintf("https://%s/v1/providers/%s/%s/%s/download/%s/%s",
-		registry,
-		namespace,
-		provider,
-		version,
-		osName,
-		arch,
-	)
-
-	h.logger.WithField("url", downloadURL).Debug("Fetching download info from upstream")
-
-	req, err := http.NewRequest("GET", downloadURL, nil)
-	if err != nil {
-		h.logger.WithError(err).Error("Failed to create request")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
-		return
-	}
-
-	req.Header.Set("Accept", "application/json")
-
-	resp, err := h.httpClient.Do(req)
-	if err != nil {
-		h.logger.WithError(err).Error("Failed to fetch download info")
-		c.JSON(http.StatusBadGateway, gin.H{"error": "failed to fetch download info"})
+	if h.offline {
+		h.logger.WithField("key", cacheKey).Info("File not found in cache in offline mode")
+		c.JSON(http.StatusNotFound, gin.H{"error": "provider binary not cached"})
 		return
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		h.logger.WithFields(logrus.Fields{
-			"status": resp.Status,
-			"body":   string(body),
-		}).Error("Unexpected response from registry")
-		c.JSON(http.StatusBadGateway, gin.H{
-			"error":  "failed to fetch download info",
-			"status": resp.Status,
-		})
-		return
-	}
+	// File not in cache, download it
+	h.logger.WithField("key", cacheKey).Info("File not found in cache, downloading...")
 
-	var downloadInfo DownloadResponse
-	if err := json.NewDecoder(resp.Body).Decode(&downloadInfo); err != nil {
-		h.logger.WithError(err).Error("Failed to parse download info response")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to parse download info"})
+	// Download, verify and store the binary
+	if err := h.cacheProvider(ctx, registry, namespace, provider, version, osName, arch, principalName(c)); err != nil {
+		var statusErr *upstreamStatusError
+		var verifyErr *verificationError
+		var downloadErr *downloadError
+		var changedErr *checksumChangedError
+		var notCached *notCachedError
+		switch {
+		case errors.As(err, &notCached):
+			h.serveNotCached(c, cacheKey, filename, notCached.data)
+		case errors.As(err, &changedErr):
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error":    "upstream checksum changed, waiting for an admin approval",
+				"sha256":   changedErr.change.SHA256,
+				"previous": changedErr.change.Previous,
+			})
+		case errors.As(err, &verifyErr):
+			h.logger.WithError(err).WithFields(logrus.Fields{
+				"key":             cacheKey,
+				errclass.LogField: errclass.Of(err),
+			}).Error("Refusing to cache provider binary")
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error":   "failed to verify provider binary signature",
+				"details": err.Error(),
+			})
+		case errors.As(err, &downloadErr):
+			h.logger.WithError(err).WithField(errclass.LogField, errclass.Of(err)).Error("Failed to download or verify provider binary")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "failed to download or verify provider binary",
+				"details": err.Error(),
+			})
+		case errors.As(err, &statusErr):
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error":  "failed to fetch download info",
+				"status": statusErr.Status,
+			})
+		case errors.Is(err, errInvalidUpstreamResponse):
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to parse download info"})
+		case errors.Is(err, errInvalidDownloadInfo):
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "invalid download information"})
+		default:
+			h.logger.WithError(err).WithField(errclass.LogField, errclass.Of(err)).Error("Failed to fetch download info")
+			c.JSON(http.StatusBadGateway, gin.H{"error": "failed to fetch download info"})
+		}
 		return
 	}
 
-	// Validate download info
-	if downloadInfo.DownloadURL == "" || downloadInfo.SHASum == "" {
-		h.logger.Error("Missing download URL or SHA256 checksum in response")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "invalid download information"})
+	// The client downloads the newly cached file from the storage as well
+	if h.presigner != nil && !h.verifyOnServe && h.redirectToStorage(c, cacheKey, filename) {
 		return
 	}
 
-	h.logger.WithFields(logrus.Fields{
-		"download_url": downloadInfo.DownloadURL,
-		"sha256":       downloadInfo.SHASum,
-	}).Info("Downloading provider binary")
-
-	// Download and store the file
-	_, err = h.downloadFile(downloadInfo.DownloadURL, cacheKey, downloadInfo.SHASum)
-	if err != nil {
-		h.logger.WithError(err).Error("Failed to download or verify provider binary")
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "failed to download or verify provider binary",
-			"details": err.Error(),
-		})
-		return
-	}
-
-	h.logger.WithFields(logrus.Fields{
-		"key":    cacheKey,
-		"sha256": downloadInfo.SHASum,
-	}).Info("Successfully downloaded and verified provider binary")
-
 	// Get the file from storage
+	start := time.Now()
 	reader, err := h.storage.Get(c.Request.Context(), cacheKey)
 	if err != nil {
 		h.logger.WithError(err).Error("Error getting file from storage")
@@ -693,22 +904,36 @@ func (h *RegistryHandler) DownloadProvider(c *gin.Context) {
 	}
 	defer reader.Close()
 
-	// Get file info for content length if available
-	var contentLength int64 = -1
-	if fi, ok := reader.(interface{ Stat() (os.FileInfo, error) }); ok {
-		if stat, err := fi.Stat(); err == nil {
-			contentLength = stat.Size()
+	// The checksum was remembered when the file was downloaded
+	if h.verifyOnServe {
+		// On a miss, the checksum is looked up like for files found in the cache
+		expected, _ := h.checksums.Load(cacheKey)
+		sha256sum, ok := expected.(string)
+		if !ok {
+			sha256sum, err = h.expectedSHA256(c.Request.Context(), registry, namespace, provider, version, osName, arch)
+			if err != nil {
+				h.logger.WithError(err).WithField("key", cacheKey).Error("Failed to get checksum to verify cached file")
+				c.JSON(http.StatusBadGateway, gin.H{"error": "failed to verify cached provider binary"})
+				return
+			}
 		}
+		if h.serveVerified(c, cacheKey, filename, reader, sha256sum) {
+			observeStage(stageStream, start)
+		}
+		return
 	}
 
+	// The content length is known from the file, or its origin record
+	size := contentLength(c.Request.Context(), h.provenance, cacheKey, reader)
+
 	// Set headers for file download
 	c.Header("Content-Description", "File Transfer")
 	c.Header("Content-Transfer-Encoding", "binary")
 	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
 	c.Header("Content-Type", "application/zip")
 
-	if contentLength >= 0 {
-		c.Header("Content-Length", strconv.FormatInt(contentLength, 10))
+	if size >= 0 {
+		c.Header("Content-Length", strconv.FormatInt(size, 10))
 	}
 
 	// Use a buffer to stream the file in chunks
@@ -727,8 +952,404 @@ func (h *RegistryHandler) DownloadProvider(c *gin.Context) {
 		if err != nil {
 			if err != io.EOF {
 				h.logger.WithError(err).Error("Error reading file from storage")
+				return
+			}
+			break
+		}
+	}
+	observeStage(stageStream, start)
+}
+
+// serveNotCached serves a provider binary which was downloaded and verified but couldn't be cached
+func (h *RegistryHandler) serveNotCached(c *gin.Context, cacheKey, filename string, data []byte) {
+	h.logger.WithField("key", cacheKey).Warn("Serving provider binary without caching it, not enough disk space")
+
+	c.Header("Content-Description", "File Transfer")
+	c.Header("Content-Transfer-Encoding", "binary")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Length", strconv.Itoa(len(data)))
+
+	if _, err := c.Writer.Write(data); err != nil && !isBrokenPipeError(err) {
+		h.logger.WithError(err).Error("Failed to send file")
+	}
+}
+
+// errInvalidDownloadInfo is returned when upstream download info lacks the URL or checksum
+var errInvalidDownloadInfo = errors.New("invalid download information")
+
+// verificationError wraps a failed signature verification
+type verificationError struct {
+	err error
+}
+
+func (e *verificationError) Error() string {
+	return e.err.Error()
+}
+
+func (e *verificationError) Unwrap() error {
+	return e.err
+}
+
+// downloadError wraps a failure to download or store a provider binary
+type downloadError struct {
+	err error
+}
+
+func (e *downloadError) Error() string {
+	return e.err.Error()
+}
+
+func (e *downloadError) Unwrap() error {
+	return e.err
+}
+
+// notCachedError is returned when a downloaded and verified provider binary couldn't be stored for lack of disk
+// space, the binary is served from memory instead
+type notCachedError struct {
+	data []byte
+	err  error
+}
+
+func (e *notCachedError) Error() string {
+	return "provider binary not cached: " + e.err.Error()
+}
+
+func (e *notCachedError) Unwrap() error {
+	return e.err
+}
+
+// checksumChangedError is returned when upstream changed the checksum of a recorded provider binary
+type checksumChangedError struct {
+	change *transparency.Change
+}
+
+func (e *checksumChangedError) Error() string {
+	return fmt.Sprintf("upstream checksum of %s changed to %s, waiting for an admin approval", e.change.Artifact, e.change.SHA256)
+}
+
+// cacheProvider downloads a provider binary from upstream, verifies it and stores it in the cache.
+// principal is recorded as the cause of the download in the origin record.
+func (h *RegistryHandler) cacheProvider(ctx context.Context, registry, namespace, provider, version, osName, arch, principal string) error {
+	cacheKey := h.getCacheKey(registry, namespace, provider, version, osName, arch)
+
+	// Get the download info from the upstream registry
+	start := time.Now()
+	downloadInfo, err := h.fetchDownloadInfo(ctx, registry, namespace, provider, version, osName, arch)
+	if err != nil {
+		return err
+	}
+	observeStage(stageMetadata, start)
+
+	// Validate download info
+	if downloadInfo.DownloadURL == "" || downloadInfo.SHASum == "" {
+		h.logger.Error("Missing download URL or SHA256 checksum in response")
+		return errInvalidDownloadInfo
+	}
+
+	// Refuse binaries whose checksum changed since they were recorded, until an admin approves the change
+	artifact := transparency.Artifact{
+		Registry:  registry,
+		Namespace: namespace,
+		Provider:  provider,
+		Version:   version,
+		OS:        osName,
+		Arch:      arch,
+	}
+	if h.transparency != nil {
+		if change, detected := h.transparency.Check(artifact, downloadInfo.SHASum); change != nil {
+			if detected && h.alerts != nil {
+				h.alerts.Notify(alert.Event{
+					Type:    "checksum_changed",
+					Message: "Upstream checksum changed for " + artifact.String(),
+					Details: map[string]any{
+						"artifact": artifact,
+						"sha256":   change.SHA256,
+						"previous": change.Previous,
+					},
+				})
+			}
+			return &checksumChangedError{change: change}
+		}
+	}
+
+	// Verify the signed checksums before anything is cached
+	if h.verifier != nil {
+		if downloadInfo.Filename == "" {
+			downloadInfo.Filename = fmt.Sprintf("terraform-provider-%s_%s_%s_%s.zip", provider, version, osName, arch)
+		}
+		start := time.Now()
+		if err := h.verifyDownload(ctx, registry, namespace, provider, version, downloadInfo); err != nil {
+			return &verificationError{err: err}
+		}
+		observeStage(stageVerify, start)
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"download_url": downloadInfo.DownloadURL,
+		"sha256":       downloadInfo.SHASum,
+	}).Info("Downloading provider binary")
+
+	// Download and store the file
+	data, origin, err := h.downloadFile(ctx, downloadInfo.DownloadURL, cacheKey, downloadInfo.SHASum)
+	var notCached *notCachedError
+	if errors.As(err, &notCached) {
+		// Nothing was cached, so there's nothing to record either
+		return notCached
+	}
+	if err != nil {
+		return &downloadError{err: err}
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"key":    cacheKey,
+		"sha256": downloadInfo.SHASum,
+	}).Info("Successfully downloaded and verified provider binary")
+	h.checksums.Store(cacheKey, strings.ToLower(downloadInfo.SHASum))
+
+	// Keep track of where the binary came from, for incident forensics
+	if h.provenance != nil {
+		saveOrigin(ctx, h.provenance, h.logger, cacheKey, origin, int64(len(data)), h.originVerification(downloadInfo), principal)
+	}
+
+	// Detect upstream re-publishing different bytes under the same version
+	if h.transparency != nil {
+		if _, err := h.transparency.Append(ctx, artifact, downloadInfo.SHASum); err != nil {
+			h.logger.WithError(err).WithField("key", cacheKey).Warn("Failed to record checksum in the transparency log")
+		}
+	}
+
+	return nil
+}
+
+// errVersionNotFound is returned when the requested provider version isn't known upstream
+var errVersionNotFound = errors.New("version not found")
+
+// GetSHASums serves the SHA256SUMS file of a provider version or its detached signature
+func (h *RegistryHandler) GetSHASums(c *gin.Context) {
+	registry := c.Param("registry")
+	namespace := c.Param("namespace")
+	provider := c.Param("provider")
+	version := c.GetString("version")
+	signature := c.GetBool("signature")
+
+	// Validate parameters
+	if !h.isAllowedRegistry(registry) || !validate.Namespace(namespace) ||
+		!validate.Provider(provider) || !validate.Version(version) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid parameters"})
+		return
+	}
+
+	ctx, ok := h.authorizeForwarded(c, c.Request.Context(), registry, namespace, provider)
+	if !ok {
+		return
+	}
+	key := h.getSHASumsKey(registry, namespace, provider, version, signature)
+
+	reader, err := h.storage.Get(ctx, key)
+	if errors.Is(err, os.ErrNotExist) && h.offline {
+		c.JSON(http.StatusNotFound, gin.H{"error": "checksums not cached"})
+		return
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		// Not cached yet, fetch both the checksums and the signature from upstream
+		h.logger.WithField("key", key).Info("SHA256SUMS not found in cache, downloading...")
+		if err := h.cacheSHASums(ctx, registry, namespace, provider, version); err != nil {
+			var statusErr *upstreamStatusError
+			switch {
+			case errors.Is(err, errVersionNotFound):
+				c.JSON(http.StatusNotFound, gin.H{"error": "version not found"})
+			case errors.As(err, &statusErr):
+				c.JSON(http.StatusBadGateway, gin.H{
+					"error":  "failed to fetch checksums",
+					"status": statusErr.Status,
+				})
+			default:
+				h.logger.WithError(err).Error("Failed to cache SHA256SUMS")
+				c.JSON(http.StatusBadGateway, gin.H{"error": "failed to fetch checksums"})
 			}
+			return
+		}
+		reader, err = h.storage.Get(ctx, key)
+	}
+	if err != nil {
+		h.logger.WithError(err).WithField("key", key).Error("Failed to get file from cache")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get file from cache"})
+		return
+	}
+	defer reader.Close()
+
+	filename := path.Base(key)
+	contentType := "text/plain; charset=utf-8"
+	if signature {
+		contentType = "application/octet-stream"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+
+	if _, err := io.Copy(c.Writer, reader); err != nil && !isBrokenPipeError(err) {
+		h.logger.WithError(err).Error("Failed to send file")
+	}
+}
+
+// cacheSHASums downloads the SHA256SUMS file and its signature for a provider version into the cache
+func (h *RegistryHandler) cacheSHASums(ctx context.Context, registry, namespace, provider, version string) error {
+	// The checksums are the same for every platform, so any published platform will do
+	versions, err := h.fetchProviderVersions(ctx, registry, namespace, provider)
+	if err != nil {
+		return err
+	}
+
+	var osName, arch string
+	for _, v := range versions.Versions {
+		if v.Version == version && len(v.Platforms) > 0 {
+			osName, arch = v.Platforms[0].OS, v.Platforms[0].Arch
 			break
 		}
 	}
+	if osName == "" {
+		return errVersionNotFound
+	}
+
+	downloadInfo, err := h.fetchDownloadInfo(ctx, registry, namespace, provider, version, osName, arch)
+	if err != nil {
+		return err
+	}
+	if downloadInfo.SHASumsURL == "" || downloadInfo.SHASumsSignatureURL == "" {
+		return fmt.Errorf("%w: missing SHA256SUMS URLs", errInvalidUpstreamResponse)
+	}
+
+	if err := h.fetchToStorage(ctx, downloadInfo.SHASumsURL, h.getSHASumsKey(registry, namespace, provider, version, false)); err != nil {
+		return fmt.Errorf("failed to cache SHA256SUMS: %w", err)
+	}
+	if err := h.fetchToStorage(ctx, downloadInfo.SHASumsSignatureURL, h.getSHASumsKey(registry, namespace, provider, version, true)); err != nil {
+		return fmt.Errorf("failed to cache SHA256SUMS signature: %w", err)
+	}
+
+	return nil
+}
+
+// originVerification returns the verification results of a provider binary that was just cached
+func (h *RegistryHandler) originVerification(downloadInfo *DownloadResponse) provenance.Verification {
+	signature := provenance.Disabled
+	if h.verifier != nil {
+		// verifyDownload only skips the check when upstream doesn't provide the checksum files
+		signature = provenance.Verified
+		if downloadInfo.SHASumsURL == "" || downloadInfo.SHASumsSignatureURL == "" {
+			signature = provenance.Skipped
+		}
+	}
+
+	return provenance.Verification{
+		SHA256:    downloadInfo.SHASum,
+		Checksum:  provenance.Verified,
+		Signature: signature,
+	}
+}
+
+// verifyDownload checks the upstream SHA256SUMS signature and that it lists the checksum of the
+// provider binary. Verified checksum files are stored in the cache as well.
+func (h *RegistryHandler) verifyDownload(ctx context.Context, registry, namespace, provider, version string, downloadInfo *DownloadResponse) error {
+	if downloadInfo.SHASumsURL == "" || downloadInfo.SHASumsSignatureURL == "" {
+		if h.verifier.Required() {
+			return verify.ErrSignatureMissing
+		}
+		h.logger.WithField("filename", downloadInfo.Filename).Warn("Upstream did not return SHA256SUMS URLs, skipping signature verification")
+		return nil
+	}
+
+	sums, err := h.fetchBytes(ctx, downloadInfo.SHASumsURL)
+	if err != nil {
+		return fmt.Errorf("failed to download SHA256SUMS: %w", err)
+	}
+	signature, err := h.fetchBytes(ctx, downloadInfo.SHASumsSignatureURL)
+	if err != nil {
+		return fmt.Errorf("failed to download SHA256SUMS signature: %w", err)
+	}
+
+	keys := make([]string, 0, len(downloadInfo.SigningKeys.GPGPublicKeys))
+	for _, key := range downloadInfo.SigningKeys.GPGPublicKeys {
+		keys = append(keys, key.ASCIIArmor)
+	}
+
+	if err := h.verifier.Verify(sums, signature, keys, downloadInfo.Filename, downloadInfo.SHASum); err != nil {
+		return upstreamError("verify", err)
+	}
+
+	h.logger.WithField("filename", downloadInfo.Filename).Info("Verified SHA256SUMS signature")
+
+	// Keep the verified checksum files so they can be served from the cache
+	for key, data := range map[string][]byte{
+		h.getSHASumsKey(registry, namespace, provider, version, false): sums,
+		h.getSHASumsKey(registry, namespace, provider, version, true):  signature,
+	} {
+		if err := h.storage.Put(ctx, key, bytes.NewReader(data)); err != nil {
+			h.logger.WithError(err).WithField("key", key).Warn("Failed to cache verified checksum file")
+		}
+	}
+
+	return nil
+}
+
+// fetchBytes downloads a small file from upstream into memory
+func (h *RegistryHandler) fetchBytes(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return nil, upstreamError("download", fmt.Errorf("failed to download file: %w", err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, upstreamError("download", &errclass.StatusError{StatusCode: resp.StatusCode})
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// fetchToStorage downloads a URL and streams it into the storage backend under the given key
+func (h *RegistryHandler) fetchToStorage(ctx context.Context, url, key string) error {
+	logger.Debug(h.logger, "Downloading file", func() logrus.Fields {
+		return logrus.Fields{
+			"url": url,
+			"key": key,
+		}
+	})
+
+	ctx, origin := upstream.WithOrigin(ctx)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return upstreamError("download", fmt.Errorf("failed to download file: %w", err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return upstreamError("download", &errclass.StatusError{StatusCode: resp.StatusCode})
+	}
+
+	if err := h.storage.Put(ctx, key, resp.Body); err != nil {
+		return fmt.Errorf("failed to store file: %w", err)
+	}
+
+	logFill(h.logger, key, origin)
+	return nil
+}
+
+// logFill logs where a file added to the cache was downloaded from, for auditing
+func logFill(logger *logrus.Logger, key string, origin *upstream.Origin) {
+	logger.WithFields(logrus.Fields{
+		"key":          key,
+		"upstreamURL":  origin.URL,
+		"upstreamHost": origin.Host,
+		"upstreamIP":   origin.IP,
+	}).Info("Cached file from upstream")
 }
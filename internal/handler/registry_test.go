@@ -1,18 +1,46 @@
 package handler
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/auth"
+	"cachetf/internal/errclass"
+	"cachetf/internal/metadata"
+	"cachetf/internal/metrics"
+	"cachetf/internal/provenance"
+	"cachetf/internal/storage"
+	"cachetf/internal/upstream"
+	"cachetf/internal/verify"
 )
 
 // Using MockStorage from cache_test.go
 
+// isIndexDocument matches the storage keys of persisted provider indexes
+func isIndexDocument(key string) bool {
+	return strings.HasPrefix(key, metadata.KeyPrefix+indexDocumentPrefix)
+}
+
 func TestGetProviderVersion(t *testing.T) {
 	// Set up test cases
 	tests := []struct {
@@ -26,7 +54,9 @@ func TestGetProviderVersion(t *testing.T) {
 		{
 			name: "successful response",
 			setupMock: func(ms *MockStorage) {
-				// No storage calls expected for this test case
+				// Only the provider index is persisted
+				ms.On("Delete", mock.Anything, mock.MatchedBy(isIndexDocument)).Return(nil).Maybe()
+				ms.On("Put", mock.Anything, mock.MatchedBy(isIndexDocument), mock.Anything).Return(nil).Maybe()
 			},
 			version:        "1.4.1",
 			expectedStatus: http.StatusOK,
@@ -227,7 +257,9 @@ func TestGetProviderIndex(t *testing.T) {
 		{
 			name: "successful response",
 			setupMock: func(ms *MockStorage) {
-				// No storage calls expected for this test case
+				// Only the provider index is persisted
+				ms.On("Delete", mock.Anything, mock.MatchedBy(isIndexDocument)).Return(nil).Maybe()
+				ms.On("Put", mock.Anything, mock.MatchedBy(isIndexDocument), mock.Anything).Return(nil).Maybe()
 			},
 			expectedStatus: http.StatusOK,
 			expectedBody: map[string]interface{}{
@@ -352,3 +384,318 @@ func TestGetProviderIndex(t *testing.T) {
 		})
 	}
 }
+
+func TestGetSHASums(t *testing.T) {
+	var sumsDownloads int32
+
+	// Fake upstream registry serving versions, download info and the checksum files
+	var upstream *httptest.Server
+	upstream = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/providers/hashicorp/random/versions":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"versions":[{"version":"3.7.2","platforms":[{"os":"linux","arch":"amd64"}]}]}`))
+		case "/v1/providers/hashicorp/random/3.7.2/download/linux/amd64":
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"download_url":          upstream.URL + "/files/terraform-provider-random_3.7.2_linux_amd64.zip",
+				"shasums_url":           upstream.URL + "/files/terraform-provider-random_3.7.2_SHA256SUMS",
+				"shasums_signature_url": upstream.URL + "/files/terraform-provider-random_3.7.2_SHA256SUMS.sig",
+				"shasum":                "abc",
+			})
+		case "/files/terraform-provider-random_3.7.2_SHA256SUMS":
+			atomic.AddInt32(&sumsDownloads, 1)
+			_, _ = w.Write([]byte("abc  terraform-provider-random_3.7.2_linux_amd64.zip\n"))
+		case "/files/terraform-provider-random_3.7.2_SHA256SUMS.sig":
+			_, _ = w.Write([]byte("signature"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	handler := NewRegistryHandler(logger, storage.NewLocalStorage(t.TempDir(), logger))
+	handler.httpClient = upstream.Client()
+
+	registry := strings.TrimPrefix(upstream.URL, "https://")
+
+	tests := []struct {
+		name         string
+		version      string
+		signature    bool
+		expectedCode int
+		expectedBody string
+	}{
+		{"checksums", "3.7.2", false, http.StatusOK, "abc  terraform-provider-random_3.7.2_linux_amd64.zip\n"},
+		{"signature", "3.7.2", true, http.StatusOK, "signature"},
+		{"checksums from cache", "3.7.2", false, http.StatusOK, "abc  terraform-provider-random_3.7.2_linux_amd64.zip\n"},
+		{"unknown version", "9.9.9", false, http.StatusNotFound, ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Params = gin.Params{
+				{Key: "registry", Value: registry},
+				{Key: "namespace", Value: "hashicorp"},
+				{Key: "provider", Value: "random"},
+			}
+			c.Request, _ = http.NewRequest("GET", "/", nil)
+			c.Set("version", tc.version)
+			c.Set("signature", tc.signature)
+
+			handler.GetSHASums(c)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			if tc.expectedBody != "" {
+				assert.Equal(t, tc.expectedBody, w.Body.String())
+			}
+		})
+	}
+
+	// The checksums were only fetched from upstream once
+	assert.Equal(t, int32(1), atomic.LoadInt32(&sumsDownloads))
+}
+
+func TestDownloadProvider_SignatureVerification(t *testing.T) {
+	zipContent := []byte("provider binary")
+	sum := sha256.Sum256(zipContent)
+	shasum := hex.EncodeToString(sum[:])
+	sums := []byte(shasum + "  terraform-provider-random_3.7.2_linux_amd64.zip\n")
+
+	// Generate the key that signs the checksums and an unrelated one
+	signer, err := openpgp.NewEntity("signer", "", "signer@example.com", nil)
+	require.NoError(t, err)
+	other, err := openpgp.NewEntity("other", "", "other@example.com", nil)
+	require.NoError(t, err)
+
+	var signature bytes.Buffer
+	require.NoError(t, openpgp.DetachSign(&signature, signer, bytes.NewReader(sums), nil))
+
+	armoredKey := func(e *openpgp.Entity) string {
+		var buf bytes.Buffer
+		w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+		require.NoError(t, err)
+		require.NoError(t, e.Serialize(w))
+		require.NoError(t, w.Close())
+		return buf.String()
+	}
+
+	tests := []struct {
+		name         string
+		signingKey   string
+		expectedCode int
+	}{
+		{"valid signature", armoredKey(signer), http.StatusOK},
+		{"signed by unknown key", armoredKey(other), http.StatusBadGateway},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			logger := logrus.New()
+			logger.SetOutput(io.Discard)
+
+			// The upstream transport records the origin of downloads, the test server is local and self-signed
+			transport := upstream.NewTransport(upstream.Options{
+				InsecureSkipVerify:   []string{"127.0.0.1"},
+				AllowPrivateNetworks: true,
+			}, logger)
+
+			var upstream *httptest.Server
+			upstream = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/v1/providers/hashicorp/random/3.7.2/download/linux/amd64":
+					json.NewEncoder(w).Encode(map[string]interface{}{
+						"filename":              "terraform-provider-random_3.7.2_linux_amd64.zip",
+						"download_url":          upstream.URL + "/files/provider.zip",
+						"shasums_url":           upstream.URL + "/files/SHA256SUMS",
+						"shasums_signature_url": upstream.URL + "/files/SHA256SUMS.sig",
+						"shasum":                shasum,
+						"signing_keys": map[string]interface{}{
+							"gpg_public_keys": []map[string]interface{}{{"ascii_armor": tc.signingKey}},
+						},
+					})
+				case "/files/provider.zip":
+					_, _ = w.Write(zipContent)
+				case "/files/SHA256SUMS":
+					_, _ = w.Write(sums)
+				case "/files/SHA256SUMS.sig":
+					_, _ = w.Write(signature.Bytes())
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer upstream.Close()
+
+			verifier, err := verify.NewGPGVerifier("", true)
+			require.NoError(t, err)
+
+			store := storage.NewLocalStorage(t.TempDir(), logger)
+			origins := provenance.NewStore(metadata.NewStore(store, logger))
+			handler := NewRegistryHandlerWithOptions(logger, store, RegistryOptions{
+				Verifier:   verifier,
+				Transport:  transport,
+				Provenance: origins,
+			})
+
+			registry := strings.TrimPrefix(upstream.URL, "https://")
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Params = gin.Params{
+				{Key: "registry", Value: registry},
+				{Key: "namespace", Value: "hashicorp"},
+				{Key: "provider", Value: "random"},
+			}
+			c.Request, _ = http.NewRequest("GET", "/", nil)
+			c.Set("version", "3.7.2")
+			c.Set("os", "linux")
+			c.Set("arch", "amd64")
+			c.Set("principal", &auth.Principal{Name: "ci"})
+
+			handler.DownloadProvider(c)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+
+			// Unverified binaries must never reach the cache
+			key := handler.getCacheKey(registry, "hashicorp", "random", "3.7.2", "linux", "amd64")
+			exists, err := store.Exists(context.Background(), key)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedCode == http.StatusOK, exists)
+
+			record, err := origins.Load(context.Background(), key)
+			if tc.expectedCode != http.StatusOK {
+				assert.ErrorIs(t, err, provenance.ErrNotFound)
+				return
+			}
+			assert.Equal(t, zipContent, w.Body.Bytes())
+
+			// The origin of the cached binary was recorded
+			require.NoError(t, err)
+			assert.Equal(t, upstream.URL+"/files/provider.zip", record.URL)
+			assert.Equal(t, "127.0.0.1", record.Host)
+			assert.Equal(t, "127.0.0.1", record.IP)
+			assert.Equal(t, http.StatusOK, record.Status)
+			assert.NotEmpty(t, record.Header.Get("Content-Length"))
+			assert.Equal(t, "ci", record.Principal)
+			assert.Equal(t, provenance.Verification{
+				SHA256:    shasum,
+				Checksum:  provenance.Verified,
+				Signature: provenance.Verified,
+			}, record.Verification)
+			assert.WithinDuration(t, time.Now(), record.FetchedAt, time.Minute)
+		})
+	}
+}
+
+// denyHostPolicy refuses every registry except the allowed one
+type denyHostPolicy struct {
+	allowed string
+}
+
+func (p denyHostPolicy) CheckRegistry(host string) error {
+	if host != p.allowed {
+		return errors.New("registry not allowed")
+	}
+	return nil
+}
+
+func TestGetProviderIndex_HostPolicy(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	handler := NewRegistryHandlerWithOptions(logger, storage.NewLocalStorage(t.TempDir(), logger), RegistryOptions{
+		HostPolicy: denyHostPolicy{allowed: "registry.terraform.io"},
+	})
+
+	for _, registry := range []string{"169.254.169.254", "localhost:8080", "metadata.google.internal"} {
+		t.Run(registry, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Params = gin.Params{
+				{Key: "registry", Value: registry},
+				{Key: "namespace", Value: "hashicorp"},
+				{Key: "provider", Value: "random"},
+			}
+			c.Request, _ = http.NewRequest("GET", "/", nil)
+
+			handler.GetProviderIndex(c)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
+
+func TestGetProviderIndex_ConfiguredRegistry(t *testing.T) {
+	var authorization string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		if r.URL.Path != "/api/terraform/v1/providers/acme/tool/versions" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"versions":[{"version":"1.0.0","platforms":[{"os":"linux","arch":"amd64"}]}]}`))
+	}))
+	defer server.Close()
+
+	registries, err := upstream.NewRegistries([]upstream.Registry{
+		{Host: "terraform.corp.internal", BaseURL: server.URL + "/api/terraform", Token: "secret"},
+	})
+	require.NoError(t, err)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	// The host policy would refuse the internal host, configured registries are trusted
+	handler := NewRegistryHandlerWithOptions(logger, storage.NewLocalStorage(t.TempDir(), logger), RegistryOptions{
+		Transport:  server.Client().Transport,
+		HostPolicy: denyHostPolicy{allowed: "registry.terraform.io"},
+		Registries: registries,
+	})
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Params = gin.Params{
+		{Key: "registry", Value: "terraform.corp.internal"},
+		{Key: "namespace", Value: "acme"},
+		{Key: "provider", Value: "tool"},
+	}
+	c.Request, _ = http.NewRequest("GET", "/", nil)
+
+	handler.GetProviderIndex(c)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"versions":{"1.0.0":{}}}`, w.Body.String())
+	assert.Equal(t, "Bearer secret", authorization)
+}
+
+func TestFetchProviderVersions_ErrorClasses(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var status atomic.Int32
+	upstream := newIndexUpstream(t, &status)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	handler := NewRegistryHandler(logger, storage.NewLocalStorage(t.TempDir(), logger))
+	handler.httpClient = upstream.Client()
+	registry := strings.TrimPrefix(upstream.URL, "https://")
+
+	// Upstream failures are counted by class
+	for code, class := range map[int]errclass.Class{
+		http.StatusTooManyRequests:     errclass.Throttle,
+		http.StatusForbidden:           errclass.Auth,
+		http.StatusNotFound:            errclass.NotFound,
+		http.StatusInternalServerError: errclass.Other,
+	} {
+		counter := metrics.ErrorsTotal.WithLabelValues(metrics.ComponentUpstream, "versions", string(class))
+		before := testutil.ToFloat64(counter)
+
+		status.Store(int32(code))
+		_, err := handler.fetchProviderVersions(t.Context(), registry, "hashicorp", "random")
+		require.Error(t, err)
+		assert.Equal(t, class, errclass.Of(err))
+		assert.Equal(t, before+1, testutil.ToFloat64(counter), code)
+	}
+}
@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"cachetf/internal/metrics"
+)
+
+// renderedResponseTTL bounds how long the encoding of a listing nobody requests anymore is kept
+const renderedResponseTTL = time.Hour
+
+// listingETag identifies the content of an upstream version listing
+func listingETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:16])
+}
+
+// renderedKey returns the key of a response built from the upstream listing with the given etag
+func renderedKey(key, etag string) string {
+	return key + "@" + etag
+}
+
+// cachedRendering returns the JSON encoding of a response built from the same upstream listing before.
+// Listings without an etag, such as stale ones, are never cached.
+func (h *RegistryHandler) cachedRendering(key, etag string) ([]byte, bool) {
+	if h.renderedResponses == nil || etag == "" {
+		return nil, false
+	}
+	body, ok := h.renderedResponses.get(renderedKey(key, etag))
+	if ok {
+		metrics.RenderedResponsesTotal.WithLabelValues("hit").Inc()
+	} else {
+		metrics.RenderedResponsesTotal.WithLabelValues("miss").Inc()
+	}
+	return body, ok
+}
+
+// storeRendering keeps the JSON encoding of a response built from the upstream listing with the given etag
+func (h *RegistryHandler) storeRendering(key, etag string, body []byte) {
+	if etag == "" {
+		return
+	}
+	h.renderedResponses.put(renderedKey(key, etag), body)
+}
+
+// writeJSON sends an encoded JSON response
+func writeJSON(c *gin.Context, body []byte) {
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
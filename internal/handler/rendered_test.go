@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/metrics"
+	"cachetf/internal/storage"
+)
+
+func TestListingETag(t *testing.T) {
+	assert.Equal(t, listingETag([]byte(`{"versions": []}`)), listingETag([]byte(`{"versions": []}`)))
+	assert.NotEqual(t, listingETag([]byte(`{"versions": []}`)), listingETag([]byte(`{"versions": [{}]}`)))
+}
+
+func TestRenderedResponses(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// The upstream listing can gain a platform between requests
+	var platforms atomic.Int32
+	platforms.Store(1)
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		listed := []map[string]string{{"os": "linux", "arch": "amd64"}, {"os": "darwin", "arch": "arm64"}}
+		json.NewEncoder(w).Encode(map[string]any{
+			"id":       "hashicorp/random",
+			"versions": []map[string]any{{"version": "3.7.2", "platforms": listed[:platforms.Load()]}},
+		})
+	}))
+	t.Cleanup(upstream.Close)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	handler := NewRegistryHandlerWithOptions(logger, storage.NewLocalStorage(t.TempDir(), logger), RegistryOptions{RenderCacheSize: 10})
+	handler.httpClient = upstream.Client()
+	router := newOfflineRouter(handler)
+
+	base := "/" + strings.TrimPrefix(upstream.URL, "https://") + "/hashicorp/random/"
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", base+path, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+		return w
+	}
+
+	hits := testutil.ToFloat64(metrics.RenderedResponsesTotal.WithLabelValues("hit"))
+	misses := testutil.ToFloat64(metrics.RenderedResponsesTotal.WithLabelValues("miss"))
+
+	first := get("versions/3.7.2").Body.String()
+	assert.Contains(t, first, "terraform-provider-random_3.7.2_linux_amd64.zip")
+	assert.Equal(t, first, get("versions/3.7.2").Body.String())
+	assert.JSONEq(t, `{"versions": {"3.7.2": {}}}`, get("index.json").Body.String())
+	assert.JSONEq(t, `{"versions": {"3.7.2": {}}}`, get("index.json").Body.String())
+	assert.Equal(t, hits+2, testutil.ToFloat64(metrics.RenderedResponsesTotal.WithLabelValues("hit")))
+	assert.Equal(t, misses+2, testutil.ToFloat64(metrics.RenderedResponsesTotal.WithLabelValues("miss")))
+
+	// A changed listing is encoded again
+	platforms.Store(2)
+	assert.Contains(t, get("versions/3.7.2").Body.String(), "terraform-provider-random_3.7.2_darwin_arm64.zip")
+	assert.Equal(t, misses+3, testutil.ToFloat64(metrics.RenderedResponsesTotal.WithLabelValues("miss")))
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// responseEntry is a cached response body
+type responseEntry struct {
+	key     string
+	body    []byte
+	expires time.Time
+}
+
+// responseCache keeps recently served response bodies for a short time, so bursts of identical requests, such
+// as a fleet of runners initializing at once, are answered without contacting upstream or the storage.
+// The least recently used responses are dropped above maxEntries.
+type responseCache struct {
+	maxEntries int
+	ttl        time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// lru holds the entries, the most recently used first
+	lru *list.List
+}
+
+// newResponseCache creates a response cache, nil if maxEntries or ttl isn't positive
+func newResponseCache(maxEntries int, ttl time.Duration) *responseCache {
+	if maxEntries <= 0 || ttl <= 0 {
+		return nil
+	}
+	return &responseCache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+// get returns the cached body of a response. A nil cache never has responses.
+func (r *responseCache) get(key string) ([]byte, bool) {
+	if r == nil {
+		return nil, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	element, ok := r.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*responseEntry)
+	if time.Now().After(entry.expires) {
+		r.lru.Remove(element)
+		delete(r.entries, key)
+		return nil, false
+	}
+	r.lru.MoveToFront(element)
+	return entry.body, true
+}
+
+// put caches the body of a response, the body must not be modified afterwards
+func (r *responseCache) put(key string, body []byte) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if element, ok := r.entries[key]; ok {
+		r.lru.Remove(element)
+	}
+	r.entries[key] = r.lru.PushFront(&responseEntry{key: key, body: body, expires: time.Now().Add(r.ttl)})
+
+	for r.lru.Len() > r.maxEntries {
+		oldest := r.lru.Back()
+		r.lru.Remove(oldest)
+		delete(r.entries, oldest.Value.(*responseEntry).key)
+	}
+}
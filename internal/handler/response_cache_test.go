@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/metrics"
+	"cachetf/internal/storage"
+)
+
+func TestResponseCache(t *testing.T) {
+	cache := newResponseCache(2, time.Hour)
+	cache.put("a", []byte("A"))
+	cache.put("b", []byte("B"))
+
+	body, ok := cache.get("a")
+	require.True(t, ok)
+	assert.Equal(t, "A", string(body))
+
+	// b is the least recently used response
+	cache.put("c", []byte("C"))
+	_, ok = cache.get("b")
+	assert.False(t, ok)
+	_, ok = cache.get("a")
+	assert.True(t, ok)
+	_, ok = cache.get("c")
+	assert.True(t, ok)
+
+	// Replacing a response doesn't count twice
+	cache.put("c", []byte("C2"))
+	body, ok = cache.get("c")
+	require.True(t, ok)
+	assert.Equal(t, "C2", string(body))
+	assert.Equal(t, 2, cache.lru.Len())
+}
+
+func TestResponseCache_Expiry(t *testing.T) {
+	cache := newResponseCache(10, time.Millisecond)
+	cache.put("a", []byte("A"))
+	time.Sleep(5 * time.Millisecond)
+
+	_, ok := cache.get("a")
+	assert.False(t, ok)
+	assert.Empty(t, cache.entries)
+}
+
+func TestResponseCache_Disabled(t *testing.T) {
+	assert.Nil(t, newResponseCache(0, time.Second))
+	assert.Nil(t, newResponseCache(10, 0))
+
+	// A nil cache is usable
+	var cache *responseCache
+	cache.put("a", []byte("A"))
+	_, ok := cache.get("a")
+	assert.False(t, ok)
+}
+
+func TestGetProviderIndex_ResponseCache(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var requests atomic.Int32
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		json.NewEncoder(w).Encode(map[string]any{
+			"id":       "hashicorp/random",
+			"versions": []map[string]any{{"version": "3.7.2"}},
+		})
+	}))
+	t.Cleanup(upstream.Close)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	handler := NewRegistryHandlerWithOptions(logger, storage.NewLocalStorage(t.TempDir(), logger), RegistryOptions{
+		IndexCacheSize: 10,
+		IndexCacheTTL:  time.Hour,
+	})
+	handler.httpClient = upstream.Client()
+	router := newOfflineRouter(handler)
+
+	path := "/" + strings.TrimPrefix(upstream.URL, "https://") + "/hashicorp/random/index.json"
+	hits := testutil.ToFloat64(metrics.IndexCacheRequestsTotal.WithLabelValues("hit"))
+	misses := testutil.ToFloat64(metrics.IndexCacheRequestsTotal.WithLabelValues("miss"))
+
+	for range 3 {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"versions": {"3.7.2": {}}}`, w.Body.String())
+		assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+	}
+
+	// Only the first request reached upstream
+	assert.Equal(t, int32(1), requests.Load())
+	assert.Equal(t, hits+2, testutil.ToFloat64(metrics.IndexCacheRequestsTotal.WithLabelValues("hit")))
+	assert.Equal(t, misses+1, testutil.ToFloat64(metrics.IndexCacheRequestsTotal.WithLabelValues("miss")))
+}
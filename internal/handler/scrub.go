@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/layout"
+	"cachetf/internal/metrics"
+	"cachetf/internal/provenance"
+	"cachetf/internal/storage"
+)
+
+// ScrubOptions controls what the scrubber reads and what it does with corrupted files
+type ScrubOptions struct {
+	// Source is the storage the cached binaries are read from to be hashed, the storage of the handler if nil.
+	// Reading them from the backend keeps the scrub out of the memory caches and the access times.
+	Source storage.Storage
+	// Delete deletes corrupted binaries instead of moving them to the quarantine
+	Delete bool
+}
+
+// ScrubResult counts the cached provider binaries checked by a scrub
+type ScrubResult struct {
+	// Checked is the number of binaries hashed and compared with their recorded checksum
+	Checked int `json:"checked"`
+	// Corrupted is the number of binaries that didn't match and were removed from the cache
+	Corrupted int `json:"corrupted"`
+	// Unverified is the number of binaries without a recorded checksum
+	Unverified int `json:"unverified"`
+	// Failed is the number of binaries that couldn't be read
+	Failed int `json:"failed"`
+}
+
+// Scrub hashes every cached provider binary and compares it with the checksum recorded in its origin record
+// when it was cached. Binaries that don't match, e.g. after bit rot on a local disk, are quarantined or deleted,
+// so the next request fetches them from upstream again instead of serving them.
+func (h *RegistryHandler) Scrub(ctx context.Context, opts ScrubOptions) (ScrubResult, error) {
+	var result ScrubResult
+	if h.provenance == nil {
+		return result, errors.New("origin records are required to scrub the cache")
+	}
+	source := opts.Source
+	if source == nil {
+		source = h.storage
+	}
+
+	start := time.Now()
+	err := storage.Walk(ctx, source, h.keys.Prefix(layout.Providers), func(obj storage.ObjectInfo) error {
+		// Only the provider binaries have their checksum recorded
+		if !strings.HasSuffix(obj.Key, ".zip") {
+			return nil
+		}
+		logger := h.logger.WithField("key", obj.Key)
+
+		record, err := h.provenance.Load(ctx, obj.Key)
+		switch {
+		case errors.Is(err, provenance.ErrNotFound) || (err == nil && record.Verification.SHA256 == ""):
+			result.Unverified++
+			metrics.ScrubFilesTotal.WithLabelValues("unverified").Inc()
+			return nil
+		case err != nil:
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			logger.WithError(err).Warn("Failed to load origin record to scrub cached file")
+			result.Failed++
+			metrics.ScrubFilesTotal.WithLabelValues("failed").Inc()
+			return nil
+		}
+
+		// A file whose size doesn't match, e.g. truncated, is corrupted without reading it
+		expected := strings.ToLower(record.Verification.SHA256)
+		if record.Size > 0 && obj.Size != record.Size {
+			result.Checked++
+			h.removeCorrupted(ctx, obj.Key, opts.Delete, &result, logger.WithFields(logrus.Fields{
+				"expectedSize": record.Size,
+				"actualSize":   obj.Size,
+			}))
+			return nil
+		}
+
+		actual, err := hashFile(ctx, source, obj.Key)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// Files deleted since they were listed, e.g. evicted, are skipped
+			if !errors.Is(err, os.ErrNotExist) {
+				logger.WithError(err).Warn("Failed to read cached file to scrub it")
+				result.Failed++
+				metrics.ScrubFilesTotal.WithLabelValues("failed").Inc()
+			}
+			return nil
+		}
+
+		result.Checked++
+		if actual == expected {
+			metrics.ScrubFilesTotal.WithLabelValues("ok").Inc()
+			return nil
+		}
+		h.removeCorrupted(ctx, obj.Key, opts.Delete, &result, logger.WithFields(logrus.Fields{
+			"expected": expected,
+			"actual":   actual,
+		}))
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	metrics.ScrubLastRunTimestamp.SetToCurrentTime()
+	h.logger.WithFields(logrus.Fields{
+		"checked":    result.Checked,
+		"corrupted":  result.Corrupted,
+		"unverified": result.Unverified,
+		"failed":     result.Failed,
+		"duration":   time.Since(start),
+	}).Info("Scrubbed cached provider binaries")
+	return result, nil
+}
+
+// RunScrubber scrubs the cache every interval until ctx is cancelled
+func (h *RegistryHandler) RunScrubber(ctx context.Context, interval time.Duration, opts ScrubOptions) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := h.Scrub(ctx, opts); err != nil && ctx.Err() == nil {
+			h.logger.WithError(err).Warn("Failed to scrub the cache")
+		}
+	}
+}
+
+// removeCorrupted quarantines or deletes a cached file found corrupted by the scrubber
+func (h *RegistryHandler) removeCorrupted(ctx context.Context, key string, remove bool, result *ScrubResult, logger *logrus.Entry) {
+	result.Corrupted++
+	metrics.ScrubFilesTotal.WithLabelValues("corrupted").Inc()
+	logger.Error("Cached provider binary doesn't match its recorded checksum")
+
+	if !remove {
+		h.quarantine(ctx, key)
+		return
+	}
+	if err := h.storage.Delete(ctx, key); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.WithError(err).Error("Failed to delete corrupted file from the cache")
+	}
+}
+
+// hashFile returns the hex-encoded SHA256 of a stored file
+func hashFile(ctx context.Context, s storage.Storage, key string) (string, error) {
+	reader, err := s.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, reader); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/metadata"
+	"cachetf/internal/metrics"
+	"cachetf/internal/provenance"
+	"cachetf/internal/storage"
+)
+
+func TestScrub(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var downloads int32
+	upstream := newPrewarmUpstream(t, &downloads)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	dir := t.TempDir()
+	store := storage.NewLocalStorage(dir, logger)
+	handler := NewRegistryHandlerWithOptions(logger, store, RegistryOptions{
+		Provenance: provenance.NewStore(metadata.NewStore(store, logger)),
+	})
+	handler.httpClient = upstream.Client()
+	router := newVerifyRouter(handler)
+
+	registry := strings.TrimPrefix(upstream.URL, "https://")
+	download := func(arch string) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/"+registry+"/hashicorp/random/3.7.2/linux/"+arch, nil)
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+	download("amd64")
+	download("arm64")
+	amd64 := handler.getCacheKey(registry, "hashicorp", "random", "3.7.2", "linux", "amd64")
+	arm64 := handler.getCacheKey(registry, "hashicorp", "random", "3.7.2", "linux", "arm64")
+
+	// The size of the binaries is recorded with their checksum
+	record, err := handler.provenance.Load(t.Context(), amd64)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("binary linux/amd64")), record.Size)
+
+	// A binary cached without an origin record can't be verified
+	unrecorded := handler.getCacheKey(registry, "hashicorp", "random", "3.7.2", "darwin", "arm64")
+	require.NoError(t, store.Put(t.Context(), unrecorded, strings.NewReader("binary darwin/arm64")))
+
+	corrupted := testutil.ToFloat64(metrics.ScrubFilesTotal.WithLabelValues("corrupted"))
+	result, err := handler.Scrub(t.Context(), ScrubOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, ScrubResult{Checked: 2, Unverified: 1}, result)
+
+	// Bit rot flips a byte of a cached binary
+	require.NoError(t, os.WriteFile(filepath.Join(dir, amd64), []byte("binary linux/amd65"), 0644))
+	result, err = handler.Scrub(t.Context(), ScrubOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, ScrubResult{Checked: 2, Corrupted: 1, Unverified: 1}, result)
+	assert.Equal(t, corrupted+1, testutil.ToFloat64(metrics.ScrubFilesTotal.WithLabelValues("corrupted")))
+
+	// The corrupted binary was quarantined, the intact one is kept
+	exists, err := store.Exists(t.Context(), amd64)
+	require.NoError(t, err)
+	assert.False(t, exists)
+	quarantined, err := os.ReadFile(filepath.Join(dir, quarantinePrefix+amd64))
+	require.NoError(t, err)
+	assert.Equal(t, "binary linux/amd65", string(quarantined))
+	exists, err = store.Exists(t.Context(), arm64)
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	// The next request fetches the binary again
+	download("amd64")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&downloads))
+
+	// Corrupted binaries can be deleted instead, a truncated binary is detected by its size
+	require.NoError(t, os.WriteFile(filepath.Join(dir, arm64), []byte("binary"), 0644))
+	result, err = handler.Scrub(t.Context(), ScrubOptions{Source: store, Delete: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Corrupted)
+	exists, err = store.Exists(t.Context(), arm64)
+	require.NoError(t, err)
+	assert.False(t, exists)
+	_, err = os.Stat(filepath.Join(dir, quarantinePrefix+arm64))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestScrub_RequiresOriginRecords(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	handler := NewRegistryHandlerWithOptions(logger, storage.NewLocalStorage(t.TempDir(), logger), RegistryOptions{})
+
+	_, err := handler.Scrub(t.Context(), ScrubOptions{})
+	assert.ErrorContains(t, err, "origin records are required")
+}
@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/metadata"
+	"cachetf/internal/metrics"
+)
+
+// quarantinePrefix is where cached files failing verification on serve are moved to.
+// It lives under the metadata prefix so quarantined files are never served, listed or evicted.
+const quarantinePrefix = metadata.KeyPrefix + "quarantine/"
+
+// expectedSHA256 returns the checksum a cached provider binary must match when it is served.
+// Checksums are taken from the upstream registry, or the transparency log in offline mode, and remembered
+// for the lifetime of the process. The storage layer is never trusted to provide them.
+func (h *RegistryHandler) expectedSHA256(ctx context.Context, registry, namespace, provider, version, osName, arch string) (string, error) {
+	key := h.getCacheKey(registry, namespace, provider, version, osName, arch)
+	if sum, ok := h.checksums.Load(key); ok {
+		return sum.(string), nil
+	}
+
+	if h.offline {
+		sum, err := h.recordedSHA256(registry, namespace, provider, version, osName, arch)
+		if err != nil {
+			return "", err
+		}
+		h.checksums.Store(key, sum)
+		return sum, nil
+	}
+
+	downloadInfo, err := h.fetchDownloadInfo(ctx, registry, namespace, provider, version, osName, arch)
+	if err != nil {
+		return "", err
+	}
+	if downloadInfo.SHASum == "" {
+		return "", errInvalidDownloadInfo
+	}
+
+	sum := strings.ToLower(downloadInfo.SHASum)
+	h.checksums.Store(key, sum)
+	return sum, nil
+}
+
+// serveVerified streams a cached provider binary while recomputing its SHA256.
+// The last chunk is held back until the checksum is known, so a client never receives
+// a complete file that doesn't match. On mismatch the connection is aborted and the file is quarantined.
+// It returns true if the whole file was sent.
+func (h *RegistryHandler) serveVerified(c *gin.Context, key, filename string, reader io.Reader, expected string) bool {
+	c.Header("Content-Description", "File Transfer")
+	c.Header("Content-Transfer-Encoding", "binary")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	c.Header("Content-Type", "application/zip")
+
+	// With a known length, clients detect the aborted transfer even if the connection can't be closed
+	if size := contentLength(c.Request.Context(), h.provenance, key, reader); size >= 0 {
+		c.Header("Content-Length", strconv.FormatInt(size, 10))
+	}
+
+	hasher := sha256.New()
+
+	// Two buffers are used alternately, one holds the pending chunk while the other is read into
+	bufs := [2][]byte{make([]byte, 32*1024), make([]byte, 32*1024)}
+	var pending []byte
+	for i := 0; ; {
+		buf := bufs[i%2]
+		n, err := reader.Read(buf)
+		if n > 0 {
+			if len(pending) > 0 {
+				if _, err := c.Writer.Write(pending); err != nil {
+					if !isBrokenPipeError(err) {
+						h.logger.WithError(err).Error("Error writing file chunk to response")
+					}
+					return false
+				}
+				c.Writer.Flush()
+			}
+			hasher.Write(buf[:n])
+			pending = buf[:n]
+			i++
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			h.logger.WithError(err).WithField("key", key).Error("Error reading file from storage")
+			abortConnection(c)
+			return false
+		}
+	}
+
+	actual := hex.EncodeToString(hasher.Sum(nil))
+	if actual != expected {
+		h.logger.WithFields(logrus.Fields{
+			"key":      key,
+			"expected": expected,
+			"actual":   actual,
+		}).Error("Cached provider binary failed verification, aborting transfer")
+		metrics.ServeVerificationFailuresTotal.Inc()
+		abortConnection(c)
+		h.quarantine(context.WithoutCancel(c.Request.Context()), key)
+		return false
+	}
+
+	if len(pending) > 0 {
+		if _, err := c.Writer.Write(pending); err != nil {
+			if !isBrokenPipeError(err) {
+				h.logger.WithError(err).Error("Error writing file chunk to response")
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// abortConnection fails the response, closing the client connection if the transfer already started
+func abortConnection(c *gin.Context) {
+	if !c.Writer.Written() {
+		c.Writer.Header().Del("Content-Length")
+		c.Writer.Header().Del("Content-Disposition")
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"error": "cached provider binary failed verification"})
+		return
+	}
+
+	conn, _, err := c.Writer.Hijack()
+	if err != nil {
+		// The truncated body is still detected by clients when the length was announced
+		c.Abort()
+		return
+	}
+	conn.Close()
+}
+
+// quarantine moves a cached file out of the way so it is fetched again from upstream on the next request
+func (h *RegistryHandler) quarantine(ctx context.Context, key string) {
+	logger := h.logger.WithFields(logrus.Fields{
+		"key":        key,
+		"quarantine": quarantinePrefix + key,
+	})
+
+	reader, err := h.storage.Get(ctx, key)
+	if err != nil {
+		logger.WithError(err).Error("Failed to read file for quarantine")
+		return
+	}
+	err = h.storage.Put(ctx, quarantinePrefix+key, reader)
+	reader.Close()
+	if err != nil {
+		// Removing the file matters more than keeping a copy of it
+		logger.WithError(err).Error("Failed to copy file to quarantine")
+	}
+
+	if err := h.storage.Delete(ctx, key); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.WithError(err).Error("Failed to remove quarantined file from the cache")
+		return
+	}
+	logger.Warn("Quarantined cached file")
+}
@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/metrics"
+	"cachetf/internal/storage"
+)
+
+// newVerifyRouter serves provider downloads of a handler verifying cached binaries while serving them
+func newVerifyRouter(handler *RegistryHandler) *gin.Engine {
+	router := gin.New()
+	router.GET("/:registry/:namespace/:provider/:version/:os/:arch", func(c *gin.Context) {
+		c.Set("version", c.Param("version"))
+		c.Set("os", c.Param("os"))
+		c.Set("arch", c.Param("arch"))
+		handler.DownloadProvider(c)
+	})
+	return router
+}
+
+func TestDownloadProvider_VerifyOnServe(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var downloads int32
+	upstream := newPrewarmUpstream(t, &downloads)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	dir := t.TempDir()
+	store := storage.NewLocalStorage(dir, logger)
+	handler := NewRegistryHandlerWithOptions(logger, store, RegistryOptions{VerifyOnServe: true})
+	handler.httpClient = upstream.Client()
+	router := newVerifyRouter(handler)
+
+	registry := strings.TrimPrefix(upstream.URL, "https://")
+	key := handler.getCacheKey(registry, "hashicorp", "random", "3.7.2", "linux", "amd64")
+	download := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/"+registry+"/hashicorp/random/3.7.2/linux/amd64", nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Cache miss, then cache hit
+	for i := 0; i < 2; i++ {
+		w := download()
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, "binary linux/amd64", w.Body.String())
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&downloads))
+
+	// The storage layer is tampered with
+	require.NoError(t, os.WriteFile(filepath.Join(dir, key), []byte("malicious"), 0644))
+
+	before := testutil.ToFloat64(metrics.ServeVerificationFailuresTotal)
+	w := download()
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.NotContains(t, w.Body.String(), "malicious")
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.ServeVerificationFailuresTotal))
+
+	// The file was quarantined
+	exists, err := store.Exists(t.Context(), key)
+	require.NoError(t, err)
+	assert.False(t, exists)
+	quarantined, err := os.ReadFile(filepath.Join(dir, quarantinePrefix+key))
+	require.NoError(t, err)
+	assert.Equal(t, "malicious", string(quarantined))
+
+	// The next request fetches the binary again
+	w = download()
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "binary linux/amd64", w.Body.String())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&downloads))
+}
+
+func TestServeVerified_AbortsTransfer(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	dir := t.TempDir()
+	store := storage.NewLocalStorage(dir, logger)
+	handler := NewRegistryHandlerWithOptions(logger, store, RegistryOptions{VerifyOnServe: true})
+
+	// A file larger than a chunk that doesn't match the remembered checksum
+	key := handler.getCacheKey("registry.terraform.io", "hashicorp", "random", "3.7.2", "linux", "amd64")
+	content := bytes.Repeat([]byte("x"), 100*1024)
+	require.NoError(t, store.Put(t.Context(), key, bytes.NewReader(content)))
+	handler.checksums.Store(key, strings.Repeat("0", 64))
+
+	server := httptest.NewServer(newVerifyRouter(handler))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/registry.terraform.io/hashicorp/random/3.7.2/linux/amd64")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	// The transfer started but never completes
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	assert.Error(t, err)
+	assert.Less(t, len(body), len(content))
+
+	assert.Eventually(t, func() bool {
+		exists, _ := store.Exists(t.Context(), key)
+		return !exists
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/snapshot"
+)
+
+// SnapshotHandler handles the admin API taking snapshots of the metadata documents and the catalog. Snapshots are
+// restored with cmd/snapshot while the servers are stopped.
+type SnapshotHandler struct {
+	snapshots *snapshot.Snapshotter
+	logger    *logrus.Logger
+}
+
+// NewSnapshotHandler creates a new SnapshotHandler
+func NewSnapshotHandler(snapshots *snapshot.Snapshotter, logger *logrus.Logger) *SnapshotHandler {
+	return &SnapshotHandler{
+		snapshots: snapshots,
+		logger:    logger,
+	}
+}
+
+// ListSnapshots handles GET requests listing the stored snapshots, the latest first
+func (h *SnapshotHandler) ListSnapshots(c *gin.Context) {
+	snapshots, err := h.snapshots.List(c.Request.Context())
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to list snapshots")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if snapshots == nil {
+		snapshots = []snapshot.Info{}
+	}
+	c.JSON(http.StatusOK, gin.H{"snapshots": snapshots})
+}
+
+// CreateSnapshot handles POST requests taking a snapshot and writing it to the storage
+func (h *SnapshotHandler) CreateSnapshot(c *gin.Context) {
+	manifest, err := h.snapshots.Create(c.Request.Context(), principalName(c))
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to take a snapshot")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, manifest)
+}
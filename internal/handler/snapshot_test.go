@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/snapshot"
+	"cachetf/internal/storage"
+)
+
+func TestSnapshotHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	snapshotHandler := NewSnapshotHandler(snapshot.NewSnapshotter(storage.NewLocalStorage(t.TempDir(), logger), nil, logger), logger)
+	router := gin.New()
+	router.GET("/admin/snapshots", snapshotHandler.ListSnapshots)
+	router.POST("/admin/snapshots", snapshotHandler.CreateSnapshot)
+
+	do := func(method string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, "/admin/snapshots", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("GET")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"snapshots": []}`, w.Body.String())
+
+	w = do("POST")
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var manifest snapshot.Manifest
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &manifest))
+	assert.NotEmpty(t, manifest.Name)
+	assert.False(t, manifest.Catalog)
+
+	w = do("GET")
+	require.Equal(t, http.StatusOK, w.Code)
+	var list struct {
+		Snapshots []snapshot.Info `json:"snapshots"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.Snapshots, 1)
+	assert.Equal(t, manifest.Name, list.Snapshots[0].Name)
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/errclass"
+	"cachetf/internal/metadata"
+	"cachetf/internal/metrics"
+)
+
+// isUpstreamOutage returns true for the errors of an unreachable or failing upstream registry,
+// as opposed to answers such as a missing provider
+func isUpstreamOutage(err error) bool {
+	var statusErr *upstreamStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= http.StatusInternalServerError || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+	return !errors.Is(err, errInvalidUpstreamResponse) && !errors.Is(err, errOffline)
+}
+
+// providerVersions fetches the versions of a provider from upstream and persists them. With stale-if-error
+// enabled, the persisted versions are returned instead of an upstream outage as long as they aren't older than
+// the max staleness. age is zero for fresh responses.
+func (h *RegistryHandler) providerVersions(ctx context.Context, registry, namespace, provider string) (response *ProviderVersionsResponse, age time.Duration, err error) {
+	response, err = h.fetchProviderVersions(ctx, registry, namespace, provider)
+	if err == nil {
+		h.saveIndex(ctx, registry, namespace, provider, response)
+		return response, 0, nil
+	}
+	if h.staleIfError <= 0 || !isUpstreamOutage(err) {
+		return nil, 0, err
+	}
+
+	persisted, loadErr := h.loadIndex(ctx, registry, namespace, provider)
+	if loadErr != nil {
+		if !errors.Is(loadErr, metadata.ErrNotFound) {
+			h.logger.WithError(loadErr).Warn("Failed to load persisted provider index")
+		}
+		return nil, 0, err
+	}
+
+	age = time.Since(persisted.FetchedAt)
+	if age > h.staleIfError {
+		return nil, 0, err
+	}
+
+	metrics.StaleResponsesTotal.Inc()
+	h.logger.WithError(err).WithFields(logrus.Fields{
+		"registry":        registry,
+		"namespace":       namespace,
+		"provider":        provider,
+		"age":             age.Round(time.Second),
+		errclass.LogField: errclass.Of(err),
+	}).Warn("Upstream registry failed, serving stale provider index")
+
+	// Never report a fresh response as stale
+	return &persisted.Response, max(age, time.Second), nil
+}
+
+// setStaleHeaders marks a response built from a stale provider index
+func setStaleHeaders(c *gin.Context, age time.Duration) {
+	if age <= 0 {
+		return
+	}
+	c.Header("Age", strconv.Itoa(int(age.Seconds())))
+	c.Header("Warning", `110 cachetf "Response is Stale"`)
+}
+
+// respondVersionsError answers a failure to get the versions of a provider from upstream
+func (h *RegistryHandler) respondVersionsError(c *gin.Context, err error) {
+	var statusErr *upstreamStatusError
+	switch {
+	case errors.As(err, &statusErr):
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error":  "failed to fetch provider versions",
+			"status": statusErr.Status,
+		})
+	case errors.Is(err, errInvalidUpstreamResponse):
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to parse provider versions"})
+	default:
+		h.logger.WithError(err).WithField(errclass.LogField, errclass.Of(err)).Error("Failed to fetch provider versions")
+		c.JSON(http.StatusBadGateway, gin.H{"error": "failed to fetch provider versions"})
+	}
+}
@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/metrics"
+	"cachetf/internal/storage"
+)
+
+// newIndexUpstream starts a fake registry serving a provider index, or failing with the given status
+func newIndexUpstream(t *testing.T, status *atomic.Int32) *httptest.Server {
+	t.Helper()
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if code := int(status.Load()); code != http.StatusOK {
+			w.WriteHeader(code)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"id": "hashicorp/random",
+			"versions": []map[string]any{
+				{"version": "3.7.2", "platforms": []map[string]string{{"os": "linux", "arch": "amd64"}}},
+			},
+		})
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+func TestStaleIfError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// The upstream status can be changed between requests
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	upstream := newIndexUpstream(t, &status)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	handler := NewRegistryHandlerWithOptions(logger, storage.NewLocalStorage(t.TempDir(), logger), RegistryOptions{StaleIfError: time.Hour})
+	handler.httpClient = upstream.Client()
+	router := newOfflineRouter(handler)
+
+	registry := strings.TrimPrefix(upstream.URL, "https://")
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/"+registry+"/hashicorp/random/"+path, nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Fresh responses are persisted
+	w := get("index.json")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Warning"))
+
+	// Upstream outages are answered from the persisted index
+	before := testutil.ToFloat64(metrics.StaleResponsesTotal)
+	status.Store(http.StatusServiceUnavailable)
+	w = get("index.json")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"versions": {"3.7.2": {}}}`, w.Body.String())
+	assert.Equal(t, `110 cachetf "Response is Stale"`, w.Header().Get("Warning"))
+	assert.NotEmpty(t, w.Header().Get("Age"))
+
+	w = get("versions/3.7.2")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "terraform-provider-random_3.7.2_linux_amd64.zip")
+	assert.NotEmpty(t, w.Header().Get("Warning"))
+	assert.Equal(t, before+2, testutil.ToFloat64(metrics.StaleResponsesTotal))
+
+	// Upstream answers aren't outages
+	status.Store(http.StatusNotFound)
+	assert.Equal(t, http.StatusBadGateway, get("index.json").Code)
+
+	// Indexes older than the max staleness aren't served
+	status.Store(http.StatusBadGateway)
+	doc := indexDocument(registry, "hashicorp", "random")
+	var persisted persistedIndex
+	require.NoError(t, handler.indexes.Load(t.Context(), doc, &persisted))
+	persisted.FetchedAt = time.Now().Add(-2 * time.Hour)
+	require.NoError(t, handler.indexes.Save(t.Context(), doc, persisted))
+	assert.Equal(t, http.StatusBadGateway, get("index.json").Code)
+
+	// Unreachable upstreams are outages too
+	status.Store(http.StatusOK)
+	persisted.FetchedAt = time.Now()
+	require.NoError(t, handler.indexes.Save(t.Context(), doc, persisted))
+	upstream.Close()
+	w = get("index.json")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Warning"))
+}
+
+func TestStaleIfError_Disabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	upstream := newIndexUpstream(t, &status)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	handler := NewRegistryHandler(logger, storage.NewLocalStorage(t.TempDir(), logger))
+	handler.httpClient = upstream.Client()
+	router := newOfflineRouter(handler)
+
+	path := "/" + strings.TrimPrefix(upstream.URL, "https://") + "/hashicorp/random/index.json"
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// The index is persisted but upstream failures are reported
+	status.Store(http.StatusServiceUnavailable)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"cachetf/internal/auth"
+	"cachetf/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// TokenHandler issues short-lived download tokens
+type TokenHandler struct {
+	issuer *auth.TokenIssuer
+	logger *logrus.Logger
+}
+
+// NewTokenHandler creates a new TokenHandler
+func NewTokenHandler(issuer *auth.TokenIssuer, logger *logrus.Logger) *TokenHandler {
+	return &TokenHandler{
+		issuer: issuer,
+		logger: logger,
+	}
+}
+
+// IssueTokenRequest is the request body of IssueToken
+type IssueTokenRequest struct {
+	// Scopes granted to the token
+	Scopes []string `json:"scopes" binding:"required"`
+	// Prefix restricts the token to request paths below it
+	Prefix string `json:"prefix"`
+	// TTL is the token lifetime as a Go duration, e.g. 15m
+	TTL string `json:"ttl"`
+}
+
+// IssueToken handles POST requests minting a token for the authenticated principal
+func (h *TokenHandler) IssueToken(c *gin.Context) {
+	principal := middleware.GetPrincipal(c)
+	if principal == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+
+	var req IssueTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+		return
+	}
+
+	scopes, err := auth.ParseScopes(req.Scopes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var ttl time.Duration
+	if req.TTL != "" {
+		if ttl, err = time.ParseDuration(req.TTL); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ttl: " + err.Error()})
+			return
+		}
+	}
+
+	token, err := h.issuer.Issue(principal, auth.TokenRequest{
+		Scopes: scopes,
+		Prefix: req.Prefix,
+		TTL:    ttl,
+	})
+	if err != nil {
+		h.logger.WithError(err).WithField("principal", principal.Name).Warn("Refused to issue token")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"principal": principal.Name,
+		"scopes":    token.Scopes,
+		"prefix":    token.Prefix,
+		"expiresAt": token.ExpiresAt,
+	}).Info("Issued token")
+
+	c.JSON(http.StatusCreated, token)
+}
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/auth"
+	"cachetf/internal/middleware"
+)
+
+func TestIssueToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	issuer := auth.NewTokenIssuer([]byte("0123456789abcdef0123456789abcdef"), 15*time.Minute, time.Hour)
+	authenticator := auth.NewAuthenticator([]auth.APIKey{
+		{Name: "ci", Key: "ci-key", Scopes: []auth.Scope{auth.ScopeRead, auth.ScopePrefetch}},
+	}, []auth.Scope{auth.ScopeRead})
+	authenticator.UseTokens(issuer)
+
+	router := gin.New()
+	router.POST("/auth/tokens", middleware.RequireAuthenticated(authenticator), NewTokenHandler(issuer, logger).IssueToken)
+
+	tests := []struct {
+		name           string
+		key            string
+		body           string
+		expectedStatus int
+	}{
+		{"anonymous", "", `{"scopes":["read"]}`, http.StatusUnauthorized},
+		{"invalid body", "ci-key", `{`, http.StatusBadRequest},
+		{"unknown scope", "ci-key", `{"scopes":["write"]}`, http.StatusBadRequest},
+		{"scope not held", "ci-key", `{"scopes":["purge"]}`, http.StatusBadRequest},
+		{"invalid ttl", "ci-key", `{"scopes":["read"],"ttl":"soon"}`, http.StatusBadRequest},
+		{"issued", "ci-key", `{"scopes":["read"],"prefix":"/providers/registry.terraform.io/hashicorp/aws","ttl":"5m"}`, http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/auth/tokens", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.key != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.key)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+			if tt.expectedStatus != http.StatusCreated {
+				return
+			}
+
+			var issued auth.IssuedToken
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &issued))
+			assert.Equal(t, "/providers/registry.terraform.io/hashicorp/aws", issued.Prefix)
+			assert.WithinDuration(t, time.Now().Add(5*time.Minute), issued.ExpiresAt, 2*time.Second)
+
+			// The issued token authenticates as a narrowed principal
+			principal, ok := authenticator.Authenticate(issued.Token)
+			require.True(t, ok)
+			assert.Equal(t, []auth.Scope{auth.ScopeRead}, principal.Scopes)
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/transparency"
+)
+
+// TransparencyHandler serves the checksum transparency log
+type TransparencyHandler struct {
+	log    *transparency.Log
+	logger *logrus.Logger
+}
+
+// NewTransparencyHandler creates a new TransparencyHandler
+func NewTransparencyHandler(log *transparency.Log, logger *logrus.Logger) *TransparencyHandler {
+	return &TransparencyHandler{
+		log:    log,
+		logger: logger,
+	}
+}
+
+// QueryLog handles GET requests returning the log entries matching the registry, namespace, provider,
+// version, os and arch query parameters. With conflicts=true, only the entries of artifacts recorded
+// with more than one checksum are returned.
+func (h *TransparencyHandler) QueryLog(c *gin.Context) {
+	conflicts := false
+	if value := c.Query("conflicts"); value != "" {
+		var err error
+		conflicts, err = strconv.ParseBool(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conflicts value"})
+			return
+		}
+	}
+
+	filter := transparency.Artifact{
+		Registry:  c.Query("registry"),
+		Namespace: c.Query("namespace"),
+		Provider:  c.Query("provider"),
+		Version:   c.Query("version"),
+		OS:        c.Query("os"),
+		Arch:      c.Query("arch"),
+	}
+
+	size, head := h.log.Head()
+	c.JSON(http.StatusOK, gin.H{
+		"size":    size,
+		"head":    head,
+		"entries": h.log.Query(filter, conflicts),
+	})
+}
+
+// ApproveChangeRequest is the request body of ApproveChange
+type ApproveChangeRequest struct {
+	transparency.Artifact
+	// SHA256 is the new checksum being approved
+	SHA256 string `json:"sha256" binding:"required"`
+}
+
+// ListChanges handles GET requests returning the upstream checksum changes waiting for an approval
+func (h *TransparencyHandler) ListChanges(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"changes": h.log.Pending()})
+}
+
+// ApproveChange handles POST requests approving a pending checksum change, so the new binary can be cached
+func (h *TransparencyHandler) ApproveChange(c *gin.Context) {
+	var req ApproveChangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+		return
+	}
+
+	entry, err := h.log.Approve(c.Request.Context(), req.Artifact, req.SHA256, principalName(c))
+	if err != nil {
+		if errors.Is(err, transparency.ErrNoChange) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.WithError(err).WithField("artifact", req.Artifact.String()).Error("Failed to approve checksum change")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, entry)
+}
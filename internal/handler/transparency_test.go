@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/alert"
+	"cachetf/internal/metadata"
+	"cachetf/internal/storage"
+	"cachetf/internal/transparency"
+)
+
+func TestQueryLog(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var downloads int32
+	upstream := newPrewarmUpstream(t, &downloads)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	store := storage.NewLocalStorage(t.TempDir(), logger)
+	log := transparency.NewLog(metadata.NewStore(store, logger), logger)
+	handler := NewRegistryHandlerWithOptions(logger, store, RegistryOptions{Transparency: log})
+	handler.httpClient = upstream.Client()
+
+	// Cached binaries are recorded in the log
+	registry := strings.TrimPrefix(upstream.URL, "https://")
+	result := handler.Prewarm(t.Context(), registry, "hashicorp", "random", "3.7.2",
+		[]Platform{{OS: "linux", Arch: "amd64"}, {OS: "darwin", Arch: "arm64"}}, "ci")
+	require.Equal(t, 2, result.Cached)
+
+	router := gin.New()
+	router.GET("/transparency", NewTransparencyHandler(log, logger).QueryLog)
+
+	tests := []struct {
+		name         string
+		query        string
+		expectedCode int
+		expected     int
+	}{
+		{"all entries", "", http.StatusOK, 2},
+		{"filtered", "?provider=random&os=darwin", http.StatusOK, 1},
+		{"no match", "?provider=aws", http.StatusOK, 0},
+		{"conflicts", "?conflicts=true", http.StatusOK, 0},
+		{"invalid conflicts", "?conflicts=sometimes", http.StatusBadRequest, 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/transparency"+tc.query, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tc.expectedCode, w.Code, w.Body.String())
+			if tc.expectedCode != http.StatusOK {
+				return
+			}
+
+			var response struct {
+				Size    uint64               `json:"size"`
+				Head    string               `json:"head"`
+				Entries []transparency.Entry `json:"entries"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, uint64(2), response.Size)
+			assert.NotEmpty(t, response.Head)
+			assert.Len(t, response.Entries, tc.expected)
+		})
+	}
+
+	sum := sha256.Sum256([]byte("binary darwin/arm64"))
+	entries := log.Query(transparency.Artifact{OS: "darwin"}, false)
+	require.Len(t, entries, 1)
+	assert.Equal(t, hex.EncodeToString(sum[:]), entries[0].SHA256)
+	assert.Equal(t, registry, entries[0].Registry)
+}
+
+// recordingAlerter keeps the alerts it is notified of
+type recordingAlerter struct {
+	events []alert.Event
+}
+
+func (a *recordingAlerter) Notify(event alert.Event) {
+	a.events = append(a.events, event)
+}
+
+func TestDownloadProvider_ChecksumChanged(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	content := "original"
+	var upstream *httptest.Server
+	upstream = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/providers/hashicorp/random/3.7.2/download/linux/amd64":
+			sum := sha256.Sum256([]byte(content))
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"download_url": upstream.URL + "/files/provider.zip",
+				"shasum":       hex.EncodeToString(sum[:]),
+			})
+		case "/files/provider.zip":
+			_, _ = w.Write([]byte(content))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	store := storage.NewLocalStorage(t.TempDir(), logger)
+	log := transparency.NewLog(metadata.NewStore(store, logger), logger)
+	alerts := &recordingAlerter{}
+	handler := NewRegistryHandlerWithOptions(logger, store, RegistryOptions{Transparency: log, Alerts: alerts})
+	handler.httpClient = upstream.Client()
+
+	router := newVerifyRouter(handler)
+	router.POST("/admin/checksum-changes/approve", NewTransparencyHandler(log, logger).ApproveChange)
+
+	registry := strings.TrimPrefix(upstream.URL, "https://")
+	key := handler.getCacheKey(registry, "hashicorp", "random", "3.7.2", "linux", "amd64")
+	download := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/"+registry+"/hashicorp/random/3.7.2/linux/amd64", nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := download()
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// Upstream re-publishes the version after the binary left the cache
+	require.NoError(t, store.Delete(t.Context(), key))
+	content = "re-published"
+
+	for i := 0; i < 2; i++ {
+		w = download()
+		assert.Equal(t, http.StatusBadGateway, w.Code)
+		assert.Contains(t, w.Body.String(), "waiting for an admin approval")
+		exists, err := store.Exists(t.Context(), key)
+		require.NoError(t, err)
+		assert.False(t, exists, "The changed binary must not be cached")
+	}
+	require.Len(t, alerts.events, 1, "The change is only alerted once")
+	assert.Equal(t, "checksum_changed", alerts.events[0].Type)
+
+	// An admin approves the change
+	pending := log.Pending()
+	require.Len(t, pending, 1)
+	body, _ := json.Marshal(ApproveChangeRequest{Artifact: pending[0].Artifact, SHA256: pending[0].SHA256})
+	req, _ := http.NewRequest("POST", "/admin/checksum-changes/approve", bytes.NewReader(body))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// Approving twice fails
+	req, _ = http.NewRequest("POST", "/admin/checksum-changes/approve", bytes.NewReader(body))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = download()
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "re-published", w.Body.String())
+}
@@ -0,0 +1,73 @@
+// Package layout defines how cached artifacts are laid out in storage. Every key starts with a scheme segment
+// naming the Terraform ecosystem it belongs to, so provider, module and CLI artifacts never collide.
+package layout
+
+import (
+	"slices"
+	"strings"
+)
+
+// Scheme is the top-level segment of cache keys
+type Scheme string
+
+const (
+	// Providers holds provider binaries and checksums: providers/registry/namespace/provider/version/file
+	Providers Scheme = "providers"
+	// Modules holds module archives: modules/namespace/name/system/version
+	Modules Scheme = "modules"
+	// CLI is reserved for the Terraform CLI mirror
+	CLI Scheme = "cli"
+)
+
+// Schemes returns every scheme
+func Schemes() []Scheme {
+	return []Scheme{Providers, Modules, CLI}
+}
+
+// ParseScheme returns the scheme named s
+func ParseScheme(s string) (Scheme, bool) {
+	for _, scheme := range Schemes() {
+		if string(scheme) == s {
+			return scheme, true
+		}
+	}
+	return "", false
+}
+
+// Prefix returns the key prefix of the scheme, with a trailing slash
+func (s Scheme) Prefix() string {
+	return string(s) + "/"
+}
+
+// Key joins the path segments under the scheme
+func (s Scheme) Key(segments ...string) string {
+	return s.Prefix() + strings.Join(segments, "/")
+}
+
+// SchemeOf returns the scheme of a key, false for keys outside of any scheme
+func SchemeOf(key string) (Scheme, bool) {
+	first, _, ok := strings.Cut(key, "/")
+	if !ok {
+		return "", false
+	}
+	return ParseScheme(first)
+}
+
+// ProviderFileOf splits the key of a provider file, in any layout, into the prefix of its provider with a trailing
+// slash, e.g. providers/registry/namespace/provider/, its version and its file name. Keys of other files, such as
+// the files of a scheme or directories, return false.
+func ProviderFileOf(key string) (provider, version, filename string, ok bool) {
+	rest, found := strings.CutPrefix(key, Providers.Prefix())
+	if !found {
+		return "", "", "", false
+	}
+	segments := strings.Split(rest, "/")
+	offset := 0
+	if segments[0] == tenantSegment {
+		offset = 2
+	}
+	if len(segments) != offset+5 || slices.Contains(segments, "") {
+		return "", "", "", false
+	}
+	return Providers.Key(segments[:offset+3]...) + "/", segments[offset+3], segments[offset+4], true
+}
@@ -0,0 +1,81 @@
+package layout
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheme_Key(t *testing.T) {
+	assert.Equal(t, "providers/", Providers.Prefix())
+	assert.Equal(t, "providers/registry.terraform.io/hashicorp/aws", Providers.Key("registry.terraform.io", "hashicorp", "aws"))
+	assert.Equal(t, "modules/hashicorp/consul/aws/0.1.0", Modules.Key("hashicorp", "consul", "aws", "0.1.0"))
+}
+
+func TestSchemeOf(t *testing.T) {
+	tests := []struct {
+		key    string
+		scheme Scheme
+		ok     bool
+	}{
+		{"providers/registry.terraform.io/hashicorp/aws/5.0.0/file.zip", Providers, true},
+		{"modules/hashicorp/consul/aws/0.1.0/archive.tar.gz", Modules, true},
+		{"cli/terraform/1.9.0/terraform_1.9.0_linux_amd64.zip", CLI, true},
+		{"registry.terraform.io/hashicorp/aws/5.0.0/file.zip", "", false},
+		{"metadata/auth/keys.json", "", false},
+		{"providers", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			scheme, ok := SchemeOf(tt.key)
+			assert.Equal(t, tt.scheme, scheme)
+			assert.Equal(t, tt.ok, ok)
+		})
+	}
+}
+
+func TestParseScheme(t *testing.T) {
+	for _, scheme := range Schemes() {
+		parsed, ok := ParseScheme(string(scheme))
+		assert.True(t, ok)
+		assert.Equal(t, scheme, parsed)
+	}
+
+	_, ok := ParseScheme("metadata")
+	assert.False(t, ok)
+}
+
+func TestProviderFileOf(t *testing.T) {
+	tenant, _ := NewTenantStrategy("acme")
+	tests := []struct {
+		key      string
+		provider string
+		version  string
+		filename string
+		ok       bool
+	}{
+		{
+			PathStrategy{}.ProviderFile("registry.terraform.io", "hashicorp", "aws", "5.0.0", "file.zip"),
+			"providers/registry.terraform.io/hashicorp/aws/", "5.0.0", "file.zip", true,
+		},
+		{
+			tenant.ProviderFile("registry.terraform.io", "hashicorp", "aws", "5.0.0", "file.zip"),
+			"providers/_tenants/acme/registry.terraform.io/hashicorp/aws/", "5.0.0", "file.zip", true,
+		},
+		{"providers/registry.terraform.io/hashicorp/aws/5.0.0", "", "", "", false},
+		{"providers/registry.terraform.io/hashicorp/aws/5.0.0/", "", "", "", false},
+		{"modules/hashicorp/consul/aws/0.1.0/archive.tar.gz", "", "", "", false},
+		{"metadata/auth/keys.json", "", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			provider, version, filename, ok := ProviderFileOf(tt.key)
+			assert.Equal(t, tt.provider, provider)
+			assert.Equal(t, tt.version, version)
+			assert.Equal(t, tt.filename, filename)
+			assert.Equal(t, tt.ok, ok)
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package layout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/metadata"
+	"cachetf/internal/provenance"
+	"cachetf/internal/storage"
+)
+
+// MigrationResult counts the keys handled by a migration
+type MigrationResult struct {
+	// Moved is the number of keys moved under their scheme
+	Moved int `json:"moved"`
+	// Failed is the number of keys that couldn't be moved
+	Failed int `json:"failed"`
+}
+
+// Migrator moves the artifacts cached before keys had a scheme segment. Those keys always belong
+// to providers, since modules were already stored under modules/.
+type Migrator struct {
+	storage storage.Storage
+	origins *provenance.Store
+	logger  *logrus.Logger
+	// DryRun only logs the keys that would be moved
+	DryRun bool
+}
+
+// NewMigrator creates a new Migrator. The origin records of moved artifacts are moved along if origins is set.
+func NewMigrator(storage storage.Storage, origins *provenance.Store, logger *logrus.Logger) *Migrator {
+	return &Migrator{
+		storage: storage,
+		origins: origins,
+		logger:  logger,
+	}
+}
+
+// IsLegacy returns true for artifact keys written without a scheme segment
+func IsLegacy(key string) bool {
+	if strings.HasPrefix(key, metadata.KeyPrefix) {
+		return false
+	}
+	_, ok := SchemeOf(key)
+	return !ok
+}
+
+// Migrate moves every legacy key under the providers scheme. Keys failing to move are logged and
+// counted, so the migration can simply be run again.
+func (m *Migrator) Migrate(ctx context.Context) (MigrationResult, error) {
+	var result MigrationResult
+
+	err := storage.Walk(ctx, m.storage, "", func(obj storage.ObjectInfo) error {
+		if !IsLegacy(obj.Key) {
+			return nil
+		}
+
+		to := Providers.Key(obj.Key)
+		logger := m.logger.WithFields(logrus.Fields{
+			"from": obj.Key,
+			"to":   to,
+		})
+		if m.DryRun {
+			logger.Info("Would move cached file")
+			result.Moved++
+			return nil
+		}
+
+		if err := m.move(ctx, obj.Key, to); err != nil {
+			logger.WithError(err).Error("Failed to move cached file")
+			result.Failed++
+			return nil
+		}
+		logger.Debug("Moved cached file")
+		result.Moved++
+		return nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("failed to migrate cache layout: %w", err)
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"moved":  result.Moved,
+		"failed": result.Failed,
+		"dryRun": m.DryRun,
+	}).Info("Cache layout migration finished")
+	return result, nil
+}
+
+// move copies a file to its new key, moves its origin record and deletes the original
+func (m *Migrator) move(ctx context.Context, from, to string) error {
+	r, err := m.storage.Get(ctx, from)
+	if err != nil {
+		return err
+	}
+	err = m.storage.Put(ctx, to, r)
+	r.Close()
+	if err != nil {
+		return err
+	}
+
+	if m.origins != nil {
+		if err := m.origins.Rename(ctx, from, to); err != nil && !errors.Is(err, provenance.ErrNotFound) {
+			return fmt.Errorf("failed to move origin record: %w", err)
+		}
+	}
+
+	if err := m.storage.Delete(ctx, from); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package layout
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/metadata"
+	"cachetf/internal/provenance"
+	"cachetf/internal/storage"
+)
+
+func TestMigrator_Migrate(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	store := storage.NewLocalStorage(t.TempDir(), logger)
+	origins := provenance.NewStore(metadata.NewStore(store, logger))
+
+	legacy := "registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip"
+	keep := []string{
+		"providers/registry.terraform.io/hashicorp/aws/5.1.0/terraform-provider-aws_5.1.0_linux_amd64.zip",
+		"modules/hashicorp/consul/aws/0.1.0/archive.tar.gz",
+		"metadata/auth/keys.json",
+	}
+	for _, key := range append([]string{legacy}, keep...) {
+		require.NoError(t, store.Put(t.Context(), key, strings.NewReader(key)))
+	}
+	require.NoError(t, origins.Save(t.Context(), &provenance.Record{Key: legacy, Principal: "ci"}))
+
+	// A dry run doesn't change anything
+	migrator := NewMigrator(store, origins, logger)
+	migrator.DryRun = true
+	result, err := migrator.Migrate(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, MigrationResult{Moved: 1}, result)
+	exists, err := store.Exists(t.Context(), legacy)
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	migrator.DryRun = false
+	result, err = migrator.Migrate(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, MigrationResult{Moved: 1}, result)
+
+	exists, err = store.Exists(t.Context(), legacy)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	r, err := store.Get(t.Context(), Providers.Key(legacy))
+	require.NoError(t, err)
+	data, _ := io.ReadAll(r)
+	r.Close()
+	assert.Equal(t, legacy, string(data))
+
+	record, err := origins.Load(t.Context(), Providers.Key(legacy))
+	require.NoError(t, err)
+	assert.Equal(t, "ci", record.Principal)
+
+	for _, key := range keep {
+		exists, err := store.Exists(t.Context(), key)
+		require.NoError(t, err)
+		assert.True(t, exists, key)
+	}
+
+	// Running it again is a no-op
+	result, err = migrator.Migrate(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, MigrationResult{}, result)
+}
@@ -0,0 +1,120 @@
+package layout
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Strategy maps cached artifacts to storage keys. The handlers build every key through it instead of joining
+// paths themselves, so deployments can choose how artifacts are laid out. Keys must start with the prefix of the
+// scheme they belong to, expiration, bundles and migrations rely on it.
+type Strategy interface {
+	// Prefix returns the prefix of the keys of a scheme, with a trailing slash
+	Prefix(scheme Scheme) string
+	// ProviderPrefix returns the prefix of the keys of a registry, namespace, provider or provider version, given
+	// by the leading segments of registry/namespace/provider/version, with a trailing slash
+	ProviderPrefix(segments ...string) string
+	// ProviderFile returns the key of a file of a provider version, under its ProviderPrefix
+	ProviderFile(registry, namespace, provider, version, filename string) string
+	// ModulePrefix returns the prefix of the keys of a module version, with a trailing slash. The archive and the
+	// download descriptor of the version are stored under it.
+	ModulePrefix(namespace, name, system, version string) string
+}
+
+// Strategy names, as configured with KEY_LAYOUT
+const (
+	// StrategyPath lays artifacts out by their registry path, see PathStrategy
+	StrategyPath = "path"
+	// StrategyTenant separates the artifacts of tenants sharing a storage, see TenantStrategy
+	StrategyTenant = "tenant"
+)
+
+// Default is the layout used when none is configured
+var Default Strategy = PathStrategy{}
+
+// tenantPattern matches tenant names, which are a single key segment
+var tenantPattern = regexp.MustCompile(`^[a-z0-9](?:[a-z0-9-]{0,62}[a-z0-9])?$`)
+
+// NewStrategy returns the layout with the given name. The tenant is required by the tenant layout only.
+func NewStrategy(name, tenant string) (Strategy, error) {
+	switch name {
+	case "", StrategyPath:
+		if tenant != "" {
+			return nil, fmt.Errorf("a tenant requires the %s layout", StrategyTenant)
+		}
+		return PathStrategy{}, nil
+	case StrategyTenant:
+		return NewTenantStrategy(tenant)
+	}
+	return nil, fmt.Errorf("unknown key layout %q: must be '%s' or '%s'", name, StrategyPath, StrategyTenant)
+}
+
+// PathStrategy is the default layout, mirroring the registry paths under the scheme:
+// providers/registry/namespace/provider/version/file and modules/namespace/name/system/version/file
+type PathStrategy struct{}
+
+func (PathStrategy) Prefix(scheme Scheme) string {
+	return scheme.Prefix()
+}
+
+func (PathStrategy) ProviderPrefix(segments ...string) string {
+	if len(segments) == 0 {
+		return Providers.Prefix()
+	}
+	return Providers.Key(segments...) + "/"
+}
+
+func (PathStrategy) ProviderFile(registry, namespace, provider, version, filename string) string {
+	return Providers.Key(registry, namespace, provider, version, filename)
+}
+
+func (PathStrategy) ModulePrefix(namespace, name, system, version string) string {
+	return Modules.Key(namespace, name, system, version) + "/"
+}
+
+// tenantSegment is the key segment the tenants are nested under. Registry hosts and module namespaces can't
+// start with an underscore, validate.Registry refuses them, so the keys of tenants never collide with the path
+// layout.
+const tenantSegment = "_tenants"
+
+// TenantStrategy nests the path layout under a tenant after the scheme, e.g.
+// providers/_tenants/acme/registry/namespace/provider/version/file, so several deployments can share a bucket
+// while every key still starts with its scheme
+type TenantStrategy struct {
+	tenant string
+}
+
+// NewTenantStrategy returns the layout of a tenant, named with lowercase letters, digits and dashes
+func NewTenantStrategy(tenant string) (*TenantStrategy, error) {
+	if !tenantPattern.MatchString(tenant) {
+		return nil, fmt.Errorf("invalid tenant %q: must be lowercase letters, digits and dashes", tenant)
+	}
+	return &TenantStrategy{tenant: tenant}, nil
+}
+
+// Tenant returns the name of the tenant
+func (s *TenantStrategy) Tenant() string {
+	return s.tenant
+}
+
+func (s *TenantStrategy) Prefix(scheme Scheme) string {
+	return scheme.Key(s.path()) + "/"
+}
+
+func (s *TenantStrategy) ProviderPrefix(segments ...string) string {
+	return Providers.Key(s.path(segments...)) + "/"
+}
+
+func (s *TenantStrategy) ProviderFile(registry, namespace, provider, version, filename string) string {
+	return Providers.Key(s.path(registry, namespace, provider, version, filename))
+}
+
+func (s *TenantStrategy) ModulePrefix(namespace, name, system, version string) string {
+	return Modules.Key(s.path(namespace, name, system, version)) + "/"
+}
+
+// path joins the segments under the tenant
+func (s *TenantStrategy) path(segments ...string) string {
+	return strings.Join(append([]string{tenantSegment, s.tenant}, segments...), "/")
+}
@@ -0,0 +1,71 @@
+package layout
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathStrategy(t *testing.T) {
+	keys := PathStrategy{}
+
+	assert.Equal(t, "providers/", keys.Prefix(Providers))
+	assert.Equal(t, "modules/", keys.Prefix(Modules))
+	assert.Equal(t, "providers/", keys.ProviderPrefix())
+	assert.Equal(t, "providers/registry.terraform.io/", keys.ProviderPrefix("registry.terraform.io"))
+	assert.Equal(t, "providers/registry.terraform.io/hashicorp/aws/5.0.0/", keys.ProviderPrefix("registry.terraform.io", "hashicorp", "aws", "5.0.0"))
+	assert.Equal(t, "providers/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_SHA256SUMS",
+		keys.ProviderFile("registry.terraform.io", "hashicorp", "aws", "5.0.0", "terraform-provider-aws_5.0.0_SHA256SUMS"))
+	assert.Equal(t, "modules/hashicorp/consul/aws/0.1.0/", keys.ModulePrefix("hashicorp", "consul", "aws", "0.1.0"))
+}
+
+func TestTenantStrategy(t *testing.T) {
+	keys, err := NewTenantStrategy("team-a")
+	require.NoError(t, err)
+	assert.Equal(t, "team-a", keys.Tenant())
+
+	assert.Equal(t, "providers/_tenants/team-a/", keys.Prefix(Providers))
+	assert.Equal(t, "modules/_tenants/team-a/", keys.Prefix(Modules))
+	assert.Equal(t, "providers/_tenants/team-a/", keys.ProviderPrefix())
+	assert.Equal(t, "providers/_tenants/team-a/registry.terraform.io/hashicorp/", keys.ProviderPrefix("registry.terraform.io", "hashicorp"))
+	assert.Equal(t, "modules/_tenants/team-a/hashicorp/consul/aws/0.1.0/", keys.ModulePrefix("hashicorp", "consul", "aws", "0.1.0"))
+
+	// Keys stay under their scheme and their ProviderPrefix
+	key := keys.ProviderFile("registry.terraform.io", "hashicorp", "aws", "5.0.0", "terraform-provider-aws_5.0.0_linux_amd64.zip")
+	assert.Equal(t, "providers/_tenants/team-a/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip", key)
+	scheme, ok := SchemeOf(key)
+	assert.True(t, ok)
+	assert.Equal(t, Providers, scheme)
+	assert.Contains(t, key, keys.ProviderPrefix("registry.terraform.io", "hashicorp", "aws", "5.0.0"))
+}
+
+func TestNewStrategy(t *testing.T) {
+	tests := []struct {
+		name   string
+		layout string
+		tenant string
+		want   Strategy
+		errMsg string
+	}{
+		{"default", "", "", PathStrategy{}, ""},
+		{"path", "path", "", PathStrategy{}, ""},
+		{"tenant", "tenant", "acme", &TenantStrategy{tenant: "acme"}, ""},
+		{"tenant without name", "tenant", "", nil, "invalid tenant"},
+		{"invalid tenant", "tenant", "Acme/prod", nil, "invalid tenant"},
+		{"tenant with path layout", "path", "acme", nil, "requires the tenant layout"},
+		{"unknown layout", "hashed", "", nil, "unknown key layout"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := NewStrategy(tt.layout, tt.tenant)
+			if tt.errMsg != "" {
+				assert.ErrorContains(t, err, tt.errMsg)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, keys)
+		})
+	}
+}
@@ -0,0 +1,302 @@
+// Package limits lets operators adjust the request, upstream concurrency and bandwidth limits of a running server
+// through the admin API, e.g. to throttle a misbehaving client or spare a struggling upstream during an incident. Adjusted limits are persisted in the metadata store and
+// applied again on startup, until they are reset to the configured ones.
+package limits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/metadata"
+	"cachetf/internal/middleware"
+	"cachetf/internal/upstream"
+)
+
+// limitsDocument is the metadata document the limits adjusted through the API are persisted in
+const limitsDocument = "limits.json"
+
+var (
+	// ErrNotAdjustable is returned when adjusting a limit that isn't enforced, e.g. the rate limit when the
+	// ratelimit middleware isn't enabled
+	ErrNotAdjustable = errors.New("limit is not enforced")
+	// ErrNoLimits is returned when an update doesn't set any limit
+	ErrNoLimits = errors.New("no limits given")
+	// ErrInvalid is returned for limit values out of range
+	ErrInvalid = errors.New("invalid limit")
+)
+
+// RateLimit is the request rate allowed per client IP by the ratelimit middleware
+type RateLimit struct {
+	// RPS is the number of requests per second, 0 lets every request through
+	RPS float64 `json:"rps"`
+	// Burst is the number of requests a client may send at once
+	Burst int `json:"burst"`
+}
+
+// Validate checks the rate limit values
+func (r *RateLimit) Validate() error {
+	if r.RPS < 0 || math.IsNaN(r.RPS) || math.IsInf(r.RPS, 0) {
+		return fmt.Errorf("%w: rate limit rps %v must be 0 or more", ErrInvalid, r.RPS)
+	}
+	if r.Burst < 0 {
+		return fmt.Errorf("%w: rate limit burst %d must be 0 or more", ErrInvalid, r.Burst)
+	}
+	return nil
+}
+
+// Limits holds the adjustable limits, the limits that aren't enforced are nil
+type Limits struct {
+	RateLimit *RateLimit `json:"rateLimit,omitempty"`
+	// UpstreamConcurrency is the number of upstream requests allowed in flight at once, 0 doesn't limit them
+	UpstreamConcurrency *int `json:"upstreamConcurrency,omitempty"`
+	// ResponseBandwidth is the bytes per second each response body is written at, 0 doesn't limit them
+	ResponseBandwidth *int64 `json:"responseBandwidth,omitempty"`
+}
+
+// empty returns true if no limit is set
+func (l Limits) empty() bool {
+	return l.RateLimit == nil && l.UpstreamConcurrency == nil && l.ResponseBandwidth == nil
+}
+
+// Validate checks the values of the limits that are set
+func (l Limits) Validate() error {
+	if l.RateLimit != nil {
+		if err := l.RateLimit.Validate(); err != nil {
+			return err
+		}
+	}
+	if l.UpstreamConcurrency != nil && *l.UpstreamConcurrency < 0 {
+		return fmt.Errorf("%w: upstream concurrency %d must be 0 or more", ErrInvalid, *l.UpstreamConcurrency)
+	}
+	if l.ResponseBandwidth != nil && *l.ResponseBandwidth < 0 {
+		return fmt.Errorf("%w: response bandwidth %d must be 0 or more", ErrInvalid, *l.ResponseBandwidth)
+	}
+	return nil
+}
+
+// Limiters are the limiters of the server adjusted by a manager, a nil limiter isn't enforced and can't be adjusted
+type Limiters struct {
+	// RateLimiter limits the request rate of the ratelimit middleware
+	RateLimiter *middleware.RateLimiter
+	// Fetches limits the upstream requests in flight
+	Fetches *upstream.FetchLimiter
+	// Bandwidth caps the bandwidth of the responses
+	Bandwidth *middleware.BandwidthLimiter
+}
+
+// limitsFile is the persisted document, it only holds the adjusted limits
+type limitsFile struct {
+	Limits
+	UpdatedAt time.Time `json:"updatedAt,omitzero"`
+	UpdatedBy string    `json:"updatedBy,omitempty"`
+}
+
+// Status is the state of the limits reported by the API
+type Status struct {
+	// Current are the limits in effect
+	Current Limits `json:"current"`
+	// Configured are the limits of the configuration, restored by a reset
+	Configured Limits `json:"configured"`
+	// Adjusted is true while limits adjusted through the API are in effect
+	Adjusted  bool      `json:"adjusted"`
+	UpdatedAt time.Time `json:"updatedAt,omitzero"`
+	UpdatedBy string    `json:"updatedBy,omitempty"`
+}
+
+// Manager applies the limits adjusted through the API to the limiters of the server
+type Manager struct {
+	limiters Limiters
+	// configured holds the limits of the configuration, it is never modified
+	configured Limits
+	logger     *logrus.Logger
+
+	mu       sync.RWMutex
+	store    *metadata.Store
+	adjusted *limitsFile
+	// writeMu serializes updates, which are persisted before they're applied
+	writeMu sync.Mutex
+}
+
+// NewManager creates a manager of the given limiters, their limits when it's created are the configured ones
+func NewManager(limiters Limiters, logger *logrus.Logger) *Manager {
+	return &Manager{limiters: limiters, configured: limiters.current(), logger: logger}
+}
+
+// Load applies the limits persisted in the metadata store and persists later changes to it
+func (m *Manager) Load(ctx context.Context, store *metadata.Store) error {
+	var file limitsFile
+	err := store.Load(ctx, limitsDocument, &file)
+	if err != nil && !errors.Is(err, metadata.ErrNotFound) {
+		return fmt.Errorf("failed to load limits: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.store = store
+	if err == nil {
+		m.adjusted = &file
+		m.apply(file.Limits)
+		fields := describe(file.Limits)
+		fields["updatedBy"] = file.UpdatedBy
+		fields["updatedAt"] = file.UpdatedAt
+		m.logger.WithFields(fields).Warn("Limits adjusted through the admin API are in effect")
+		if file.RateLimit != nil && m.limiters.RateLimiter == nil {
+			m.logger.Warn("The adjusted rate limit isn't enforced, the ratelimit middleware isn't enabled")
+		}
+	}
+	return nil
+}
+
+// Status returns the limits in effect and the configured ones
+func (m *Manager) Status() Status {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	status := Status{Current: m.limiters.current(), Configured: m.configured}
+	if m.adjusted != nil {
+		status.Adjusted = true
+		status.UpdatedAt = m.adjusted.UpdatedAt
+		status.UpdatedBy = m.adjusted.UpdatedBy
+	}
+	return status
+}
+
+// Update adjusts the limits set in limits, the others keep their current value. The limits are persisted before
+// they're applied.
+func (m *Manager) Update(ctx context.Context, limits Limits, principal string) (Status, error) {
+	if limits.empty() {
+		return Status{}, ErrNoLimits
+	}
+	if limits.RateLimit != nil && m.limiters.RateLimiter == nil {
+		return Status{}, fmt.Errorf("rate limit: %w", ErrNotAdjustable)
+	}
+	if limits.UpstreamConcurrency != nil && m.limiters.Fetches == nil {
+		return Status{}, fmt.Errorf("upstream concurrency: %w", ErrNotAdjustable)
+	}
+	if limits.ResponseBandwidth != nil && m.limiters.Bandwidth == nil {
+		return Status{}, fmt.Errorf("response bandwidth: %w", ErrNotAdjustable)
+	}
+	if err := limits.Validate(); err != nil {
+		return Status{}, err
+	}
+
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+
+	m.mu.RLock()
+	store := m.store
+	file := limitsFile{}
+	if m.adjusted != nil {
+		file = *m.adjusted
+	}
+	m.mu.RUnlock()
+	if store == nil {
+		return Status{}, errors.New("limit management is not enabled")
+	}
+
+	if limits.RateLimit != nil {
+		file.RateLimit = limits.RateLimit
+	}
+	if limits.UpstreamConcurrency != nil {
+		file.UpstreamConcurrency = limits.UpstreamConcurrency
+	}
+	if limits.ResponseBandwidth != nil {
+		file.ResponseBandwidth = limits.ResponseBandwidth
+	}
+	file.UpdatedAt = time.Now().UTC().Truncate(time.Second)
+	file.UpdatedBy = principal
+
+	if err := store.Save(ctx, limitsDocument, file); err != nil {
+		return Status{}, fmt.Errorf("failed to persist limits: %w", err)
+	}
+
+	m.mu.Lock()
+	m.adjusted = &file
+	m.apply(file.Limits)
+	m.mu.Unlock()
+
+	fields := describe(file.Limits)
+	fields["updatedBy"] = principal
+	m.logger.WithFields(fields).Warn("Limits adjusted")
+	return m.Status(), nil
+}
+
+// Reset restores the configured limits and deletes the persisted ones
+func (m *Manager) Reset(ctx context.Context, principal string) (Status, error) {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+
+	m.mu.RLock()
+	store := m.store
+	m.mu.RUnlock()
+	if store == nil {
+		return Status{}, errors.New("limit management is not enabled")
+	}
+
+	if err := store.Delete(ctx, limitsDocument); err != nil {
+		return Status{}, fmt.Errorf("failed to delete persisted limits: %w", err)
+	}
+
+	m.mu.Lock()
+	m.adjusted = nil
+	m.apply(m.configured)
+	m.mu.Unlock()
+
+	fields := describe(m.configured)
+	fields["resetBy"] = principal
+	m.logger.WithFields(fields).Warn("Limits reset to the configuration")
+	return m.Status(), nil
+}
+
+// apply sets the limits on the limiters, the caller holds the lock
+func (m *Manager) apply(limits Limits) {
+	if limits.RateLimit != nil && m.limiters.RateLimiter != nil {
+		m.limiters.RateLimiter.SetLimits(limits.RateLimit.RPS, limits.RateLimit.Burst)
+	}
+	if limits.UpstreamConcurrency != nil && m.limiters.Fetches != nil {
+		m.limiters.Fetches.SetLimit(*limits.UpstreamConcurrency)
+	}
+	if limits.ResponseBandwidth != nil && m.limiters.Bandwidth != nil {
+		m.limiters.Bandwidth.SetLimit(*limits.ResponseBandwidth)
+	}
+}
+
+// current returns the limits of the limiters
+func (l Limiters) current() Limits {
+	var limits Limits
+	if l.RateLimiter != nil {
+		rps, burst := l.RateLimiter.Limits()
+		limits.RateLimit = &RateLimit{RPS: rps, Burst: burst}
+	}
+	if l.Fetches != nil {
+		concurrency := l.Fetches.Limit()
+		limits.UpstreamConcurrency = &concurrency
+	}
+	if l.Bandwidth != nil {
+		bandwidth := l.Bandwidth.Limit()
+		limits.ResponseBandwidth = &bandwidth
+	}
+	return limits
+}
+
+// describe returns the log fields of limits
+func describe(limits Limits) logrus.Fields {
+	fields := logrus.Fields{}
+	if limits.RateLimit != nil {
+		fields["rateLimitRPS"] = limits.RateLimit.RPS
+		fields["rateLimitBurst"] = limits.RateLimit.Burst
+	}
+	if limits.UpstreamConcurrency != nil {
+		fields["upstreamConcurrency"] = *limits.UpstreamConcurrency
+	}
+	if limits.ResponseBandwidth != nil {
+		fields["responseBandwidth"] = *limits.ResponseBandwidth
+	}
+	return fields
+}
@@ -0,0 +1,149 @@
+package limits
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/metadata"
+	"cachetf/internal/middleware"
+	"cachetf/internal/storage"
+	"cachetf/internal/upstream"
+)
+
+func newStore(t *testing.T) (*metadata.Store, *logrus.Logger) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return metadata.NewStore(storage.NewLocalStorage(t.TempDir(), logger), logger), logger
+}
+
+func TestManager(t *testing.T) {
+	store, logger := newStore(t)
+	ctx := t.Context()
+
+	limiter := middleware.NewRateLimiter(10, 20)
+	manager := NewManager(Limiters{RateLimiter: limiter}, logger)
+	require.NoError(t, manager.Load(ctx, store))
+
+	configured := &RateLimit{RPS: 10, Burst: 20}
+	status := manager.Status()
+	assert.False(t, status.Adjusted)
+	assert.Equal(t, configured, status.Current.RateLimit)
+	assert.Equal(t, configured, status.Configured.RateLimit)
+
+	// Adjusted limits are applied to the limiter
+	status, err := manager.Update(ctx, Limits{RateLimit: &RateLimit{RPS: 1, Burst: 2}}, "oncall")
+	require.NoError(t, err)
+	assert.True(t, status.Adjusted)
+	assert.Equal(t, "oncall", status.UpdatedBy)
+	assert.False(t, status.UpdatedAt.IsZero())
+	assert.Equal(t, &RateLimit{RPS: 1, Burst: 2}, status.Current.RateLimit)
+	assert.Equal(t, configured, status.Configured.RateLimit)
+	rps, burst := limiter.Limits()
+	assert.Equal(t, 1.0, rps)
+	assert.Equal(t, 2, burst)
+
+	// They survive a restart
+	restarted := middleware.NewRateLimiter(10, 20)
+	require.NoError(t, NewManager(Limiters{RateLimiter: restarted}, logger).Load(ctx, store))
+	rps, burst = restarted.Limits()
+	assert.Equal(t, 1.0, rps)
+	assert.Equal(t, 2, burst)
+
+	// Until they are reset
+	status, err = manager.Reset(ctx, "oncall")
+	require.NoError(t, err)
+	assert.False(t, status.Adjusted)
+	assert.Equal(t, configured, status.Current.RateLimit)
+	restarted = middleware.NewRateLimiter(10, 20)
+	require.NoError(t, NewManager(Limiters{RateLimiter: restarted}, logger).Load(ctx, store))
+	rps, _ = restarted.Limits()
+	assert.Equal(t, 10.0, rps)
+}
+
+func TestManager_InvalidUpdates(t *testing.T) {
+	store, logger := newStore(t)
+	ctx := t.Context()
+
+	manager := NewManager(Limiters{
+		RateLimiter: middleware.NewRateLimiter(10, 20),
+		Fetches:     upstream.NewFetchLimiter(http.DefaultTransport, 0),
+		Bandwidth:   middleware.NewBandwidthLimiter(0),
+	}, logger)
+	require.NoError(t, manager.Load(ctx, store))
+
+	_, err := manager.Update(ctx, Limits{}, "oncall")
+	assert.ErrorIs(t, err, ErrNoLimits)
+	_, err = manager.Update(ctx, Limits{RateLimit: &RateLimit{RPS: -1, Burst: 1}}, "oncall")
+	assert.ErrorContains(t, err, "rate limit rps")
+	_, err = manager.Update(ctx, Limits{RateLimit: &RateLimit{RPS: math.Inf(1), Burst: 1}}, "oncall")
+	assert.ErrorContains(t, err, "rate limit rps")
+	_, err = manager.Update(ctx, Limits{RateLimit: &RateLimit{RPS: 1, Burst: -1}}, "oncall")
+	assert.ErrorIs(t, err, ErrInvalid)
+	_, err = manager.Update(ctx, Limits{UpstreamConcurrency: ptr(-1)}, "oncall")
+	assert.ErrorContains(t, err, "upstream concurrency")
+	_, err = manager.Update(ctx, Limits{ResponseBandwidth: ptr[int64](-1)}, "oncall")
+	assert.ErrorIs(t, err, ErrInvalid)
+	assert.False(t, manager.Status().Adjusted)
+
+	// Limits that aren't enforced can't be adjusted
+	unlimited := NewManager(Limiters{}, logger)
+	require.NoError(t, unlimited.Load(ctx, store))
+	assert.Nil(t, unlimited.Status().Current.RateLimit)
+	_, err = unlimited.Update(ctx, Limits{RateLimit: &RateLimit{RPS: 1, Burst: 1}}, "oncall")
+	assert.ErrorIs(t, err, ErrNotAdjustable)
+	_, err = unlimited.Update(ctx, Limits{UpstreamConcurrency: ptr(1)}, "oncall")
+	assert.ErrorIs(t, err, ErrNotAdjustable)
+	_, err = unlimited.Update(ctx, Limits{ResponseBandwidth: ptr[int64](1)}, "oncall")
+	assert.ErrorIs(t, err, ErrNotAdjustable)
+}
+
+func TestManager_UpstreamAndBandwidth(t *testing.T) {
+	store, logger := newStore(t)
+	ctx := t.Context()
+
+	rateLimiter := middleware.NewRateLimiter(10, 20)
+	fetches := upstream.NewFetchLimiter(http.DefaultTransport, 8)
+	bandwidth := middleware.NewBandwidthLimiter(0)
+	manager := NewManager(Limiters{RateLimiter: rateLimiter, Fetches: fetches, Bandwidth: bandwidth}, logger)
+	require.NoError(t, manager.Load(ctx, store))
+	assert.Equal(t, ptr(8), manager.Status().Configured.UpstreamConcurrency)
+	assert.Equal(t, ptr[int64](0), manager.Status().Configured.ResponseBandwidth)
+
+	// Updates merge with the limits adjusted before
+	_, err := manager.Update(ctx, Limits{RateLimit: &RateLimit{RPS: 1, Burst: 2}}, "oncall")
+	require.NoError(t, err)
+	status, err := manager.Update(ctx, Limits{UpstreamConcurrency: ptr(2), ResponseBandwidth: ptr[int64](1 << 20)}, "oncall")
+	require.NoError(t, err)
+	assert.Equal(t, Limits{
+		RateLimit:           &RateLimit{RPS: 1, Burst: 2},
+		UpstreamConcurrency: ptr(2),
+		ResponseBandwidth:   ptr[int64](1 << 20),
+	}, status.Current)
+	assert.Equal(t, 2, fetches.Limit())
+	assert.Equal(t, int64(1<<20), bandwidth.Limit())
+
+	// They survive a restart
+	restartedFetches := upstream.NewFetchLimiter(http.DefaultTransport, 8)
+	restartedBandwidth := middleware.NewBandwidthLimiter(0)
+	restarted := NewManager(Limiters{Fetches: restartedFetches, Bandwidth: restartedBandwidth}, logger)
+	require.NoError(t, restarted.Load(ctx, store))
+	assert.Equal(t, 2, restartedFetches.Limit())
+	assert.Equal(t, int64(1<<20), restartedBandwidth.Limit())
+
+	// Until they are reset
+	_, err = manager.Reset(ctx, "oncall")
+	require.NoError(t, err)
+	assert.Equal(t, 8, fetches.Limit())
+	assert.Equal(t, int64(0), bandwidth.Limit())
+}
+
+// ptr returns a pointer to v
+func ptr[T any](v T) *T {
+	return &v
+}
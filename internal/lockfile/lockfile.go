@@ -0,0 +1,325 @@
+// Package lockfile parses Terraform dependency lock files (.terraform.lock.hcl).
+//
+// Only the subset of HCL written by Terraform is supported: provider blocks holding
+// string and string list attributes. Other blocks are skipped.
+package lockfile
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Provider is a provider locked by a dependency lock file
+type Provider struct {
+	// Address is the source address of the provider, e.g. registry.terraform.io/hashicorp/aws
+	Address     string   `json:"address"`
+	Registry    string   `json:"registry"`
+	Namespace   string   `json:"namespace"`
+	Name        string   `json:"provider"`
+	Version     string   `json:"version"`
+	Constraints string   `json:"constraints,omitempty"`
+	Hashes      []string `json:"hashes,omitempty"`
+}
+
+// ZipHashes returns the SHA256 checksums of the provider zips listed with the zh: scheme
+func (p *Provider) ZipHashes() []string {
+	var sums []string
+	for _, hash := range p.Hashes {
+		if sum, ok := strings.CutPrefix(hash, "zh:"); ok {
+			sums = append(sums, strings.ToLower(sum))
+		}
+	}
+	return sums
+}
+
+// ParseAddress splits a fully qualified provider source address into its parts
+func ParseAddress(address string) (registry, namespace, name string, err error) {
+	parts := strings.Split(address, "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return "", "", "", fmt.Errorf("invalid provider address %q: expected registry/namespace/provider", address)
+	}
+	return strings.ToLower(parts[0]), parts[1], parts[2], nil
+}
+
+// Parse parses the content of a dependency lock file
+func Parse(data []byte) ([]Provider, error) {
+	tokens, err := tokenize(string(data))
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+
+	var providers []Provider
+	for !p.done() {
+		blockType, err := p.expect(tokenIdent)
+		if err != nil {
+			return nil, err
+		}
+
+		var labels []string
+		for p.peek().kind == tokenString {
+			labels = append(labels, p.next().value)
+		}
+		if _, err := p.expect(tokenLBrace); err != nil {
+			return nil, err
+		}
+
+		if blockType.value != "provider" {
+			if err := p.skipBlock(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if len(labels) != 1 {
+			return nil, fmt.Errorf("line %d: provider block must have exactly one label", blockType.line)
+		}
+
+		provider, err := p.parseProvider(labels[0])
+		if err != nil {
+			return nil, err
+		}
+		if provider.Version == "" {
+			return nil, fmt.Errorf("line %d: provider %q has no version", blockType.line, provider.Address)
+		}
+		providers = append(providers, provider)
+	}
+	return providers, nil
+}
+
+// parseProvider parses the body of a provider block, up to and including its closing brace
+func (p *parser) parseProvider(address string) (Provider, error) {
+	registry, namespace, name, err := ParseAddress(address)
+	if err != nil {
+		return Provider{}, err
+	}
+	provider := Provider{
+		Address:   address,
+		Registry:  registry,
+		Namespace: namespace,
+		Name:      name,
+	}
+
+	for {
+		tok := p.next()
+		switch tok.kind {
+		case tokenRBrace:
+			return provider, nil
+		case tokenIdent:
+		default:
+			return Provider{}, p.unexpected(tok)
+		}
+
+		// Nested blocks aren't written by Terraform, skip them
+		if p.peek().kind == tokenLBrace {
+			p.next()
+			if err := p.skipBlock(); err != nil {
+				return Provider{}, err
+			}
+			continue
+		}
+
+		if _, err := p.expect(tokenEquals); err != nil {
+			return Provider{}, err
+		}
+		switch tok.value {
+		case "version":
+			value, err := p.expect(tokenString)
+			if err != nil {
+				return Provider{}, err
+			}
+			provider.Version = value.value
+		case "constraints":
+			value, err := p.expect(tokenString)
+			if err != nil {
+				return Provider{}, err
+			}
+			provider.Constraints = value.value
+		case "hashes":
+			provider.Hashes, err = p.parseList()
+			if err != nil {
+				return Provider{}, err
+			}
+		default:
+			if err := p.skipValue(); err != nil {
+				return Provider{}, err
+			}
+		}
+	}
+}
+
+// parseList parses a list of strings
+func (p *parser) parseList() ([]string, error) {
+	if _, err := p.expect(tokenLBracket); err != nil {
+		return nil, err
+	}
+	var values []string
+	for {
+		tok := p.next()
+		switch tok.kind {
+		case tokenRBracket:
+			return values, nil
+		case tokenString:
+			values = append(values, tok.value)
+			// A comma is required between elements but optional after the last one
+			if p.peek().kind == tokenComma {
+				p.next()
+			} else if p.peek().kind != tokenRBracket {
+				return nil, p.unexpected(p.peek())
+			}
+		default:
+			return nil, p.unexpected(tok)
+		}
+	}
+}
+
+// skipValue skips a string or a list of strings
+func (p *parser) skipValue() error {
+	if p.peek().kind == tokenLBracket {
+		_, err := p.parseList()
+		return err
+	}
+	tok := p.next()
+	if tok.kind != tokenString && tok.kind != tokenIdent {
+		return p.unexpected(tok)
+	}
+	return nil
+}
+
+// skipBlock skips the body of a block, up to and including its closing brace
+func (p *parser) skipBlock() error {
+	for depth := 1; depth > 0; {
+		tok := p.next()
+		switch tok.kind {
+		case tokenEOF:
+			return p.unexpected(tok)
+		case tokenLBrace:
+			depth++
+		case tokenRBrace:
+			depth--
+		}
+	}
+	return nil
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenLBrace
+	tokenRBrace
+	tokenLBracket
+	tokenRBracket
+	tokenEquals
+	tokenComma
+)
+
+func (k tokenKind) String() string {
+	return [...]string{"end of file", "identifier", "string", "'{'", "'}'", "'['", "']'", "'='", "','"}[k]
+}
+
+type token struct {
+	kind  tokenKind
+	value string
+	line  int
+}
+
+// tokenize splits the lock file into tokens, dropping whitespace and comments
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#' || strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated comment", line)
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 4
+		case c == '"':
+			var b strings.Builder
+			i++
+			for {
+				if i >= len(src) || src[i] == '\n' {
+					return nil, fmt.Errorf("line %d: unterminated string", line)
+				}
+				if src[i] == '"' {
+					i++
+					break
+				}
+				if src[i] == '\\' && i+1 < len(src) {
+					i++
+				}
+				b.WriteByte(src[i])
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenString, value: b.String(), line: line})
+		case strings.IndexByte("{}[]=,", c) >= 0:
+			kind := map[byte]tokenKind{
+				'{': tokenLBrace, '}': tokenRBrace, '[': tokenLBracket, ']': tokenRBracket, '=': tokenEquals, ',': tokenComma,
+			}[c]
+			tokens = append(tokens, token{kind: kind, value: string(c), line: line})
+			i++
+		case isIdentByte(c):
+			start := i
+			for i < len(src) && isIdentByte(src[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, value: src[start:i], line: line})
+		default:
+			return nil, fmt.Errorf("line %d: unexpected character %q", line, c)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, line: line}), nil
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '-' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+}
+
+// parser walks the tokens of a lock file
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) done() bool {
+	return p.peek().kind == tokenEOF
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) expect(kind tokenKind) (token, error) {
+	tok := p.next()
+	if tok.kind != kind {
+		return tok, fmt.Errorf("line %d: expected %s, found %s", tok.line, kind, tok.kind)
+	}
+	return tok, nil
+}
+
+func (p *parser) unexpected(tok token) error {
+	return fmt.Errorf("line %d: unexpected %s", tok.line, tok.kind)
+}
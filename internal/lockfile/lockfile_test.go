@@ -0,0 +1,106 @@
+package lockfile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const lockFile = `# This file is maintained automatically by "terraform init".
+# Manual edits may be lost in future updates.
+
+provider "registry.terraform.io/hashicorp/aws" {
+  version     = "5.31.0"
+  constraints = ">= 4.0.0, ~> 5.0"
+  hashes = [
+    "h1:ltxyuBWIy9cq0kIKDJH1jeWJy/y7XJLjS4QrsQK4plA=",
+    "zh:0cdb9c2083bf0902442384f7309367791e4640581652dda456f2d6d7abf0de8d",
+    "zh:2FE2ED5C6A7B0C3E4B8B8F8A6C3E1F7A9C2D0E4B1A8F6D3C2B1A0F9E8D7C6B5A",
+  ]
+}
+
+/* Providers locked
+   for the network module */
+provider "registry.terraform.io/hashicorp/random" {
+  version = "3.7.2" // pinned
+  hashes  = ["zh:1111111111111111111111111111111111111111111111111111111111111111"]
+}
+
+provider "terraform.example.com/Acme/widget" {
+  version = "0.1.0"
+}
+`
+
+func TestParse(t *testing.T) {
+	providers, err := Parse([]byte(lockFile))
+	require.NoError(t, err)
+	require.Len(t, providers, 3)
+
+	assert.Equal(t, Provider{
+		Address:     "registry.terraform.io/hashicorp/aws",
+		Registry:    "registry.terraform.io",
+		Namespace:   "hashicorp",
+		Name:        "aws",
+		Version:     "5.31.0",
+		Constraints: ">= 4.0.0, ~> 5.0",
+		Hashes: []string{
+			"h1:ltxyuBWIy9cq0kIKDJH1jeWJy/y7XJLjS4QrsQK4plA=",
+			"zh:0cdb9c2083bf0902442384f7309367791e4640581652dda456f2d6d7abf0de8d",
+			"zh:2FE2ED5C6A7B0C3E4B8B8F8A6C3E1F7A9C2D0E4B1A8F6D3C2B1A0F9E8D7C6B5A",
+		},
+	}, providers[0])
+	assert.Equal(t, []string{
+		"0cdb9c2083bf0902442384f7309367791e4640581652dda456f2d6d7abf0de8d",
+		"2fe2ed5c6a7b0c3e4b8b8f8a6c3e1f7a9c2d0e4b1a8f6d3c2b1a0f9e8d7c6b5a",
+	}, providers[0].ZipHashes())
+
+	assert.Equal(t, "3.7.2", providers[1].Version)
+	assert.Len(t, providers[1].ZipHashes(), 1)
+
+	assert.Equal(t, "terraform.example.com", providers[2].Registry)
+	assert.Equal(t, "Acme", providers[2].Namespace)
+	assert.Empty(t, providers[2].ZipHashes())
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"missing version", `provider "registry.terraform.io/hashicorp/aws" {}`, "has no version"},
+		{"short address", `provider "hashicorp/aws" { version = "1.0.0" }`, "invalid provider address"},
+		{"missing label", `provider { version = "1.0.0" }`, "exactly one label"},
+		{"unterminated block", `provider "registry.terraform.io/hashicorp/aws" { version = "1.0.0"`, "line 1: unexpected end of file"},
+		{"unterminated string", "provider \"registry.terraform.io/hashicorp/aws\" {\n version = \"1.0.0\n}", "line 2: unterminated string"},
+		{"missing comma", `provider "registry.terraform.io/hashicorp/aws" { version = "1.0.0" hashes = ["a" "b"] }`, "unexpected string"},
+		{"unexpected character", `provider "registry.terraform.io/hashicorp/aws" { version = 1.0 }`, "unexpected character"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.content))
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func TestParse_SkipsUnknownBlocks(t *testing.T) {
+	providers, err := Parse([]byte(`
+module "network" {
+  source = "terraform-aws-modules/vpc/aws"
+  nested {
+    value = ["a", "b"]
+  }
+}
+
+provider "registry.terraform.io/hashicorp/aws" {
+  version = "5.31.0"
+  future  = ["x"]
+}
+`))
+	require.NoError(t, err)
+	require.Len(t, providers, 1)
+	assert.Equal(t, "5.31.0", providers[0].Version)
+}
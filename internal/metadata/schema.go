@@ -0,0 +1,47 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"cachetf/internal/schema"
+)
+
+// LatestSchemaVersion is the version of the last migration of the metadata documents, the newest schema this server
+// understands
+const LatestSchemaVersion = 1
+
+// schemaDocument records the schema version of the metadata documents
+const schemaDocument = "schema.json"
+
+// schemaFile is the persisted schema version
+type schemaFile struct {
+	Version    int       `json:"version"`
+	MigratedAt time.Time `json:"migratedAt,omitzero"`
+}
+
+// SchemaVersion returns the schema version of the metadata documents, 0 if none was recorded
+func (s *Store) SchemaVersion(ctx context.Context) (int, error) {
+	var file schemaFile
+	if err := s.Load(ctx, schemaDocument, &file); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return file.Version, nil
+}
+
+// SetSchemaVersion records the schema version of the metadata documents
+func (s *Store) SetSchemaVersion(ctx context.Context, version int) error {
+	return s.Save(ctx, schemaDocument, schemaFile{Version: version, MigratedAt: time.Now().UTC().Truncate(time.Second)})
+}
+
+// Migrations returns the migrations of the metadata documents. A migration changing the documents of a feature
+// reads and rewrites them through the store.
+func (s *Store) Migrations() []schema.Migration {
+	return []schema.Migration{
+		{Version: 1, Description: "JSON documents under metadata/, replaced through a pending copy"},
+	}
+}
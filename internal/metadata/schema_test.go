@@ -0,0 +1,34 @@
+package metadata
+
+import (
+	"io"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/schema"
+)
+
+func TestStore_SchemaVersion(t *testing.T) {
+	store := newTestStore(t)
+	ctx := t.Context()
+
+	// Documents written before versioning are at version 0
+	version, err := store.SchemaVersion(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, version)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	target := schema.Target{Name: "metadata", State: store, Migrations: store.Migrations()}
+	status, err := schema.Migrate(ctx, target, logger)
+	require.NoError(t, err)
+	assert.True(t, status.UpToDate())
+
+	version, err = store.SchemaVersion(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, LatestSchemaVersion, version)
+	assert.Equal(t, LatestSchemaVersion, target.Latest())
+}
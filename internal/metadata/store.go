@@ -0,0 +1,158 @@
+package metadata
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/storage"
+	"cachetf/pkg/logger"
+)
+
+// KeyPrefix is the storage prefix metadata documents are kept under, next to the cached artifacts
+const KeyPrefix = "metadata/"
+
+// pendingSuffix marks the copy of a document written before the document is replaced, which is read instead if
+// the replacement didn't complete
+const pendingSuffix = ".pending"
+
+// ErrNotFound is returned when a document doesn't exist
+var ErrNotFound = errors.New("metadata document not found")
+
+// Store persists small JSON documents in the cache storage
+type Store struct {
+	storage storage.Storage
+	logger  *logrus.Logger
+	// mu serializes writes, which replace documents in several steps
+	mu sync.Mutex
+}
+
+// NewStore creates a new Store on top of the cache storage
+func NewStore(storage storage.Storage, logger *logrus.Logger) *Store {
+	return &Store{
+		storage: storage,
+		logger:  logger,
+	}
+}
+
+// key returns the storage key of a document
+func key(name string) string {
+	return KeyPrefix + name
+}
+
+// Load reads the document name into v, returning ErrNotFound if it doesn't exist. If the document is missing or
+// unreadable because a replacement was interrupted, the pending copy of that replacement is read instead.
+func (s *Store) Load(ctx context.Context, name string, v any) error {
+	err := s.load(ctx, name, key(name), v)
+	if err == nil {
+		return nil
+	}
+	if s.load(ctx, name, key(name)+pendingSuffix, v) == nil {
+		s.logger.WithError(err).WithField("document", name).Warn("Read the pending copy of an interrupted metadata write")
+		return nil
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// load reads the file at key into v
+func (s *Store) load(ctx context.Context, name, key string, v any) error {
+	r, err := s.storage.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to read metadata %s: %w", name, err)
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read metadata %s: %w", name, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to decode metadata %s: %w", name, err)
+	}
+	return nil
+}
+
+// Save writes v as the document name, replacing any previous version. Storage backends don't all overwrite
+// existing keys nor rename them, so the new version is written to a pending copy first, then the document is
+// replaced and the copy removed. A crash at any point leaves either the previous or the new version readable.
+func (s *Store) Save(ctx context.Context, name string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata %s: %w", name, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pending := key(name) + pendingSuffix
+	// A copy left by an interrupted write is outdated by this one
+	if err := s.storage.Delete(ctx, pending); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to replace metadata %s: %w", name, err)
+	}
+	if err := s.storage.Put(ctx, pending, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("failed to write metadata %s: %w", name, err)
+	}
+
+	if err := s.storage.Delete(ctx, key(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to replace metadata %s: %w", name, err)
+	}
+	if err := s.storage.Put(ctx, key(name), bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("failed to write metadata %s: %w", name, err)
+	}
+
+	// The document is complete, a leftover copy is ignored and replaced by the next write
+	if err := s.storage.Delete(ctx, pending); err != nil && !errors.Is(err, os.ErrNotExist) {
+		s.logger.WithError(err).WithField("document", name).Warn("Failed to remove the pending copy of a metadata document")
+	}
+
+	logger.Debug(s.logger, "Saved metadata", func() logrus.Fields { return logrus.Fields{"document": name} })
+	return nil
+}
+
+// List returns the names of the documents starting with prefix, in lexical order
+func (s *Store) List(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	err := storage.Walk(ctx, s.storage, key(prefix), func(obj storage.ObjectInfo) error {
+		name := strings.TrimPrefix(obj.Key, KeyPrefix)
+		if pendingName, ok := strings.CutSuffix(name, pendingSuffix); ok {
+			// The copy of a document whose write was interrupted, listed under the name of the document
+			if exists, err := s.storage.Exists(ctx, key(pendingName)); err != nil || exists {
+				return err
+			}
+			name = pendingName
+		}
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list metadata %s: %w", prefix, err)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Delete removes the document name, if it exists
+func (s *Store) Delete(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.storage.Delete(ctx, key(name)+pendingSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete metadata %s: %w", name, err)
+	}
+	if err := s.storage.Delete(ctx, key(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete metadata %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package metadata
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/storage"
+)
+
+type document struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return NewStore(storage.NewLocalStorage(t.TempDir(), logger), logger)
+}
+
+func TestStore_SaveAndLoad(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+
+	// Missing documents are reported
+	var doc document
+	assert.ErrorIs(t, store.Load(ctx, "test/doc.json", &doc), ErrNotFound)
+
+	// Saved documents can be loaded back
+	require.NoError(t, store.Save(ctx, "test/doc.json", document{Name: "a", Count: 1}))
+	require.NoError(t, store.Load(ctx, "test/doc.json", &doc))
+	assert.Equal(t, document{Name: "a", Count: 1}, doc)
+
+	// Saving again replaces the previous version
+	require.NoError(t, store.Save(ctx, "test/doc.json", document{Name: "b", Count: 2}))
+	require.NoError(t, store.Load(ctx, "test/doc.json", &doc))
+	assert.Equal(t, document{Name: "b", Count: 2}, doc)
+
+	// Deleting is idempotent
+	require.NoError(t, store.Delete(ctx, "test/doc.json"))
+	require.NoError(t, store.Delete(ctx, "test/doc.json"))
+	assert.ErrorIs(t, store.Load(ctx, "test/doc.json", &doc), ErrNotFound)
+}
+
+func TestStore_List(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+
+	names, err := store.List(ctx, "test/")
+	require.NoError(t, err)
+	assert.Empty(t, names)
+
+	for _, name := range []string{"test/b.json", "test/a.json", "other/c.json"} {
+		require.NoError(t, store.Save(ctx, name, document{Name: name}))
+	}
+
+	names, err = store.List(ctx, "test/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"test/a.json", "test/b.json"}, names)
+}
+
+func TestStore_InterruptedSave(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+
+	require.NoError(t, store.Save(ctx, "test/doc.json", document{Name: "a", Count: 1}))
+
+	// A write interrupted after the previous version was deleted leaves only the pending copy
+	require.NoError(t, store.storage.Put(ctx, key("test/doc.json")+pendingSuffix, strings.NewReader(`{"name": "b", "count": 2}`)))
+	require.NoError(t, store.storage.Delete(ctx, key("test/doc.json")))
+
+	var doc document
+	require.NoError(t, store.Load(ctx, "test/doc.json", &doc))
+	assert.Equal(t, document{Name: "b", Count: 2}, doc)
+	names, err := store.List(ctx, "test/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"test/doc.json"}, names)
+
+	// A truncated document falls back to the pending copy as well
+	require.NoError(t, store.storage.Put(ctx, key("test/doc.json"), strings.NewReader(`{"name": "b", "co`)))
+	doc = document{}
+	require.NoError(t, store.Load(ctx, "test/doc.json", &doc))
+	assert.Equal(t, document{Name: "b", Count: 2}, doc)
+
+	// The next write completes and removes the copy
+	require.NoError(t, store.Delete(ctx, "test/doc.json"))
+	require.NoError(t, store.Save(ctx, "test/doc.json", document{Name: "c", Count: 3}))
+	exists, err := store.storage.Exists(ctx, key("test/doc.json")+pendingSuffix)
+	require.NoError(t, err)
+	assert.False(t, exists)
+	require.NoError(t, store.Load(ctx, "test/doc.json", &doc))
+	assert.Equal(t, document{Name: "c", Count: 3}, doc)
+	names, err = store.List(ctx, "test/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"test/doc.json"}, names)
+}
@@ -5,6 +5,14 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"cachetf/internal/errclass"
+)
+
+// Components of the errors counted in cache_errors_total
+const (
+    ComponentStorage  = "storage"
+    ComponentUpstream = "upstream"
 )
 
 var (
@@ -26,12 +34,266 @@ var (
         Help: "Total number of cache deletions",
     })
 
-    // CacheSizeBytes is a gauge for current cache size in bytes
+    // CacheExpirationsTotal is a counter for files evicted because they outlived the cache TTL
+    CacheExpirationsTotal = promauto.NewCounter(prometheus.CounterOpts{
+        Name: "cache_expirations_total",
+        Help: "Total number of cached files evicted because they expired",
+    })
+
+    // CacheEvictionsTotal is a counter for files evicted to keep the cache under its size limit
+    CacheEvictionsTotal = promauto.NewCounter(prometheus.CounterOpts{
+        Name: "cache_evictions_total",
+        Help: "Total number of cached files evicted because the cache exceeded its size limit",
+    })
+
+    // ServeVerificationFailuresTotal counts cached files whose checksum didn't match while being served
+    ServeVerificationFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+        Name: "cache_serve_verification_failures_total",
+        Help: "Total number of cached provider binaries that failed checksum verification while being served",
+    })
+
+    // ScrubFilesTotal counts the cached provider binaries checked by the scrubber, by result
+    ScrubFilesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+        Name: "cache_scrub_files_total",
+        Help: "Total number of cached provider binaries checked by the scrubber, by result (ok, corrupted, unverified, failed)",
+    }, []string{"result"})
+
+    // ScrubLastRunTimestamp is the time of the last completed scrub
+    ScrubLastRunTimestamp = promauto.NewGauge(prometheus.GaugeOpts{
+        Name: "cache_scrub_last_run_timestamp_seconds",
+        Help: "Unix timestamp of the last completed scrub of the cache",
+    })
+
+    // GCFilesTotal counts the metadata documents and artifacts found out of sync by the garbage collector, by result
+    GCFilesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+        Name: "cache_gc_files_total",
+        Help: "Total number of files found out of sync by the metadata garbage collector, by result (orphaned_record, orphaned_index, unrecorded, failed)",
+    }, []string{"result"})
+
+    // GCLastRunTimestamp is the time of the last completed garbage collection
+    GCLastRunTimestamp = promauto.NewGauge(prometheus.GaugeOpts{
+        Name: "cache_gc_last_run_timestamp_seconds",
+        Help: "Unix timestamp of the last completed collection of orphaned metadata",
+    })
+
+    // TransparencyConflictsTotal counts artifacts upstream re-published with a different checksum
+    TransparencyConflictsTotal = promauto.NewCounter(prometheus.CounterOpts{
+        Name: "cache_transparency_conflicts_total",
+        Help: "Total number of provider binaries recorded in the transparency log with a checksum differing from a previous one",
+    })
+
+    // ChecksumChangesTotal counts upstream checksum changes refused until an admin approves them
+    ChecksumChangesTotal = promauto.NewCounter(prometheus.CounterOpts{
+        Name: "cache_checksum_changes_total",
+        Help: "Total number of upstream checksum changes detected for provider binaries recorded in the transparency log",
+    })
+
+    // PrewarmTotal counts the provider binaries handled by prewarm requests, by result
+    PrewarmTotal = promauto.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "cache_prewarm_total",
+            Help: "Total number of provider binaries handled by prewarm requests by result (cached, skipped, failed)",
+        },
+        []string{"result"},
+    )
+
+    // MirrorNewVersionsTotal counts the new provider versions cached by the scheduled mirror refresh
+    MirrorNewVersionsTotal = promauto.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "cache_mirror_new_versions_total",
+            Help: "Total number of new provider versions cached by the scheduled mirror refresh by provider",
+        },
+        []string{"provider"},
+    )
+
+    // MirrorLastRefreshTimestamp is the time of the last completed mirror refresh
+    MirrorLastRefreshTimestamp = promauto.NewGauge(prometheus.GaugeOpts{
+        Name: "cache_mirror_last_refresh_timestamp_seconds",
+        Help: "Unix time of the last completed scheduled mirror refresh",
+    })
+
+    // StaleResponsesTotal counts the provider indexes served from persisted metadata during upstream outages
+    StaleResponsesTotal = promauto.NewCounter(prometheus.CounterOpts{
+        Name: "cache_stale_responses_total",
+        Help: "Total number of stale provider indexes served because the upstream registry failed",
+    })
+
+    // ReplicationFilesTotal counts the files imported from the source instance by pull replication
+    ReplicationFilesTotal = promauto.NewCounter(prometheus.CounterOpts{
+        Name: "cache_replication_files_total",
+        Help: "Total number of files imported from the source instance by pull replication",
+    })
+
+    // ReplicationLastSyncTimestamp is the time of the last pull replication that pulled every missing artifact
+    ReplicationLastSyncTimestamp = promauto.NewGauge(prometheus.GaugeOpts{
+        Name: "cache_replication_last_sync_timestamp_seconds",
+        Help: "Unix time of the last pull replication that pulled every missing artifact",
+    })
+
+    // TieredReadsTotal counts the reads of tiered storage by the tier that served them
+    TieredReadsTotal = promauto.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "cache_tiered_reads_total",
+            Help: "Total number of reads of tiered storage by the tier that served them (local, remote)",
+        },
+        []string{"tier"},
+    )
+
+    // WriteBehindUploadsTotal counts the uploads of files staged by write-behind storage by result
+    WriteBehindUploadsTotal = promauto.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "cache_write_behind_uploads_total",
+            Help: "Total number of uploads of write-behind storage by result (uploaded, failed, sync)",
+        },
+        []string{"result"},
+    )
+
+    // WriteBehindPending is the number of files staged by write-behind storage and not uploaded yet
+    WriteBehindPending = promauto.NewGauge(prometheus.GaugeOpts{
+        Name: "cache_write_behind_pending",
+        Help: "Number of files acknowledged by write-behind storage and not uploaded yet",
+    })
+
+    // TieredFailoversTotal counts the reads of the local tier that failed and were served by the remote tier, by
+    // whether the file failed to open or while it was read
+    TieredFailoversTotal = promauto.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "cache_tiered_failovers_total",
+            Help: "Total number of failed reads of the local tier of tiered storage served by the remote tier by stage (open, read)",
+        },
+        []string{"stage"},
+    )
+
+    // ClientRequestsTotal counts the requests by CLI and major version, from the User-Agent header
+    ClientRequestsTotal = promauto.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "client_requests_total",
+            Help: "Total number of requests by client (terraform, opentofu, other) and major version",
+        },
+        []string{"client", "version"},
+    )
+
+    // IndexCacheRequestsTotal counts the provider index.json requests by result of the response cache (hit, miss)
+    IndexCacheRequestsTotal = promauto.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "index_cache_requests_total",
+            Help: "Total number of provider index.json requests by result of the in-memory response cache (hit, miss)",
+        },
+        []string{"result"},
+    )
+
+    // RenderedResponsesTotal counts the provider index and version responses by whether their JSON encoding
+    // was reused from an identical upstream listing (hit) or built again (miss)
+    RenderedResponsesTotal = promauto.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "rendered_responses_total",
+            Help: "Total number of provider index and version responses by reuse of their JSON encoding (hit, miss)",
+        },
+        []string{"result"},
+    )
+
+    // PresignedRedirectsTotal counts the provider downloads redirected to a presigned storage URL
+    PresignedRedirectsTotal = promauto.NewCounter(
+        prometheus.CounterOpts{
+            Name: "presigned_redirects_total",
+            Help: "Total number of provider downloads redirected to a presigned storage URL",
+        },
+    )
+
+    // RateLimitedRequestsTotal counts the requests rejected by the rate limit
+    RateLimitedRequestsTotal = promauto.NewCounter(
+        prometheus.CounterOpts{
+            Name: "http_rate_limited_requests_total",
+            Help: "Total number of requests rejected by the rate limit",
+        },
+    )
+
+    // MemoryCacheRequestsTotal counts the reads of the memory cache by result (hit, miss)
+    MemoryCacheRequestsTotal = promauto.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "cache_memory_requests_total",
+            Help: "Total number of reads of the in-memory cache by result (hit, miss)",
+        },
+        []string{"result"},
+    )
+
+    // MemoryCacheBytes is the size of the files held by the memory cache
+    MemoryCacheBytes = promauto.NewGauge(prometheus.GaugeOpts{
+        Name: "cache_memory_bytes",
+        Help: "Current size of the files held by the in-memory cache in bytes",
+    })
+
+    // StorageCredentialReloadsTotal counts the reloads of the storage credentials after they were rejected as
+    // expired, by result (reloaded, failed)
+    StorageCredentialReloadsTotal = promauto.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "cache_storage_credential_reloads_total",
+            Help: "Total number of reloads of the storage credentials rejected as expired by result (reloaded, failed)",
+        },
+        []string{"result"},
+    )
+
+    // HotCacheRequestsTotal counts the reads of the hot cache by result (hit, miss)
+    HotCacheRequestsTotal = promauto.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "cache_hot_requests_total",
+            Help: "Total number of reads of the hot cache of large files by result (hit, miss)",
+        },
+        []string{"result"},
+    )
+
+    // HotCacheBytes is the size of the files copied to the hot cache
+    HotCacheBytes = promauto.NewGauge(prometheus.GaugeOpts{
+        Name: "cache_hot_bytes",
+        Help: "Current size of the files held by the hot cache in bytes",
+    })
+
+    // CatalogFiles is the number of cached files in the catalog
+    CatalogFiles = promauto.NewGauge(prometheus.GaugeOpts{
+        Name: "cache_catalog_files",
+        Help: "Current number of cached files in the catalog",
+    })
+
+    // CacheSizeBytes is a gauge for current cache size in bytes. Backends add and subtract the files they write and
+    // delete, the Evictor or Sizer recount it periodically.
     CacheSizeBytes = promauto.NewGauge(prometheus.GaugeOpts{
         Name: "cache_size_bytes",
         Help: "Current size of the cache in bytes",
     })
 
+    // CachedProviders, CachedVersions and CachedArtifacts measure how much of the upstream registries the cache
+    // mirrors, the Evictor or Sizer recount them when they list the cache
+    CachedProviders = promauto.NewGauge(prometheus.GaugeOpts{
+        Name: "cachetf_cached_providers",
+        Help: "Current number of providers with at least one cached file",
+    })
+    CachedVersions = promauto.NewGauge(prometheus.GaugeOpts{
+        Name: "cachetf_cached_versions",
+        Help: "Current number of provider versions with at least one cached file",
+    })
+    CachedArtifacts = promauto.NewGauge(prometheus.GaugeOpts{
+        Name: "cachetf_cached_artifacts",
+        Help: "Current number of cached provider binaries and module archives",
+    })
+
+    // CacheDiskFullTotal counts the writes to local storage finding less free disk space than the reserve
+    CacheDiskFullTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+        Name: "cache_disk_full_total",
+        Help: "Writes to local storage finding less free disk space than the reserve, by whether space was reclaimed or the write rejected",
+    }, []string{"result"})
+
+    // CacheLogicalSizeBytes is the size of the files of deduplicated local storage, as served to clients
+    CacheLogicalSizeBytes = promauto.NewGauge(prometheus.GaugeOpts{
+        Name: "cache_logical_size_bytes",
+        Help: "Size of the files of deduplicated local storage in bytes, identical files counted once per key",
+    })
+
+    // CacheStoredSizeBytes is the disk space used by deduplicated local storage
+    CacheStoredSizeBytes = promauto.NewGauge(prometheus.GaugeOpts{
+        Name: "cache_stored_size_bytes",
+        Help: "Disk space used by the files of deduplicated local storage in bytes, identical files counted once",
+    })
+
     // CacheOperationsTotal is a counter for all cache operations
     CacheOperationsTotal = promauto.NewCounterVec(
         prometheus.CounterOpts{
@@ -41,14 +303,33 @@ var (
         []string{"operation", "status"},
     )
 
-    // CacheOperationDuration tracks the duration of cache operations
+    // ErrorsTotal counts storage and upstream errors by component, operation and error class
+    ErrorsTotal = promauto.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "cache_errors_total",
+            Help: "Total number of storage and upstream errors by component, operation and class (timeout, throttle, auth, not_found, checksum, canceled, other)",
+        },
+        []string{"component", "operation", "class"},
+    )
+
+    // MissStageDuration tracks the time spent in each stage of the provider binary miss path
+    MissStageDuration = promauto.NewHistogramVec(
+        prometheus.HistogramOpts{
+            Name:    "cache_miss_stage_duration_seconds",
+            Help:    "Time spent in each completed stage of the provider binary miss path (metadata, verify, download, store, stream)",
+            Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+        },
+        []string{"stage"},
+    )
+
+    // CacheOperationDuration tracks the duration of storage operations by backend
     CacheOperationDuration = promauto.NewHistogramVec(
         prometheus.HistogramOpts{
             Name:    "cache_operation_duration_seconds",
-            Help:    "Time taken to process cache operations",
+            Help:    "Time taken to process storage operations by backend (local, s3) and operation",
             Buckets: prometheus.DefBuckets,
         },
-        []string{"operation"},
+        []string{"backend", "operation"},
     )
 )
 
@@ -80,17 +361,62 @@ func (m *CacheMetrics) RecordDeletion(count int) {
     CacheOperationsTotal.WithLabelValues("delete", "success").Add(float64(count))
 }
 
-// RecordError records an error for an operation
-func (m *CacheMetrics) RecordError(operation string) {
+// RecordExpiration increments the expiration counter
+func (m *CacheMetrics) RecordExpiration(count int) {
+    CacheExpirationsTotal.Add(float64(count))
+    CacheOperationsTotal.WithLabelValues("expire", "success").Add(float64(count))
+}
+
+// RecordEviction increments the eviction counter
+func (m *CacheMetrics) RecordEviction(count int) {
+    CacheEvictionsTotal.Add(float64(count))
+    CacheOperationsTotal.WithLabelValues("evict", "success").Add(float64(count))
+}
+
+// RecordError records an error for a storage operation and returns its class
+func (m *CacheMetrics) RecordError(operation string, err error) errclass.Class {
     CacheOperationsTotal.WithLabelValues(operation, "error").Inc()
+    return RecordError(ComponentStorage, operation, err)
+}
+
+// RecordError counts an error of a component operation by class and returns the class
+func RecordError(component, operation string, err error) errclass.Class {
+    class := errclass.Of(err)
+    ErrorsTotal.WithLabelValues(component, operation, string(class)).Inc()
+    return class
+}
+
+// RecordOperationDuration records the duration of an operation on a storage backend
+func (m *CacheMetrics) RecordOperationDuration(backend, operation string, duration float64) {
+    CacheOperationDuration.WithLabelValues(backend, operation).Observe(duration)
+}
+
+// AddSize adds the size of a file written to the cache to the cache size gauge
+func (m *CacheMetrics) AddSize(bytes int64) {
+    CacheSizeBytes.Add(float64(bytes))
 }
 
-// RecordOperationDuration records the duration of an operation
-func (m *CacheMetrics) RecordOperationDuration(operation string, duration float64) {
-    CacheOperationDuration.WithLabelValues(operation).Observe(duration)
+// SubSize subtracts the size of files deleted from the cache from the cache size gauge
+func (m *CacheMetrics) SubSize(bytes int64) {
+    CacheSizeBytes.Sub(float64(bytes))
 }
 
-// UpdateSize updates the cache size gauge
-func (m *CacheMetrics) UpdateSize(size int64) {
+// SetSize sets the cache size gauge to the size of the cache, as measured by listing it. The measure is
+// authoritative, it corrects the drift of the additions and subtractions, e.g. from overwritten files or files
+// written by other instances sharing the storage.
+func (m *CacheMetrics) SetSize(size int64) {
     CacheSizeBytes.Set(float64(size))
 }
+
+// SetCoverage sets the numbers of cached providers, provider versions and artifacts, as counted by listing the cache
+func (m *CacheMetrics) SetCoverage(providers, versions, artifacts int) {
+    CachedProviders.Set(float64(providers))
+    CachedVersions.Set(float64(versions))
+    CachedArtifacts.Set(float64(artifacts))
+}
+
+// AddDedupSize adds to the logical and stored sizes of deduplicated local storage
+func (m *CacheMetrics) AddDedupSize(logical, stored int64) {
+    CacheLogicalSizeBytes.Add(float64(logical))
+    CacheStoredSizeBytes.Add(float64(stored))
+}
@@ -1,6 +1,9 @@
 package metrics
 
 import (
+	"context"
+	"errors"
+	"os"
 	"testing"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -96,38 +99,67 @@ func TestCacheMetrics(t *testing.T) {
 		beforeOp1 := getCounterVecValue(CacheOperationsTotal, op1, "error")
 		beforeOp2 := getCounterVecValue(CacheOperationsTotal, op2, "error")
 
-		metrics.RecordError(op1)
+		metrics.RecordError(op1, os.ErrNotExist)
 		assert.Equal(t, beforeOp1+1, getCounterVecValue(CacheOperationsTotal, op1, "error"))
+		assert.Equal(t, float64(1), getCounterVecValue(ErrorsTotal, ComponentStorage, op1, "not_found"))
 
 		// Record another error for the same operation
-		metrics.RecordError(op1)
+		metrics.RecordError(op1, context.DeadlineExceeded)
 		assert.Equal(t, beforeOp1+2, getCounterVecValue(CacheOperationsTotal, op1, "error"))
+		assert.Equal(t, float64(1), getCounterVecValue(ErrorsTotal, ComponentStorage, op1, "timeout"))
 
 		// Record error for a different operation
-		metrics.RecordError(op2)
+		metrics.RecordError(op2, errors.New("test error"))
 		assert.Equal(t, beforeOp2+1, getCounterVecValue(CacheOperationsTotal, op2, "error"))
+		assert.Equal(t, float64(1), getCounterVecValue(ErrorsTotal, ComponentStorage, op2, "other"))
 	})
 
-	t.Run("Test UpdateSize", func(t *testing.T) {
+	t.Run("Test SetSize", func(t *testing.T) {
 		// Test setting a new size
 		testSize1 := int64(1024)
-		metrics.UpdateSize(testSize1)
+		metrics.SetSize(testSize1)
 		assert.Equal(t, float64(testSize1), getGaugeValue(CacheSizeBytes))
 
 		// Test updating the size
 		testSize2 := int64(2048)
-		metrics.UpdateSize(testSize2)
+		metrics.SetSize(testSize2)
 		assert.Equal(t, float64(testSize2), getGaugeValue(CacheSizeBytes))
 	})
 
+	t.Run("Test AddSize and SubSize", func(t *testing.T) {
+		metrics.SetSize(1000)
+
+		// The sizes of the files written and deleted accumulate
+		metrics.AddSize(300)
+		metrics.AddSize(200)
+		assert.Equal(t, float64(1500), getGaugeValue(CacheSizeBytes))
+		metrics.SubSize(300)
+		assert.Equal(t, float64(1200), getGaugeValue(CacheSizeBytes))
+
+		// A recount replaces the accumulated size
+		metrics.SetSize(800)
+		metrics.AddSize(100)
+		assert.Equal(t, float64(900), getGaugeValue(CacheSizeBytes))
+	})
+
+	t.Run("Test AddDedupSize", func(t *testing.T) {
+		beforeLogical := getGaugeValue(CacheLogicalSizeBytes)
+		beforeStored := getGaugeValue(CacheStoredSizeBytes)
+
+		metrics.AddDedupSize(2048, 1024)
+		metrics.AddDedupSize(-1024, 0)
+		assert.Equal(t, beforeLogical+1024, getGaugeValue(CacheLogicalSizeBytes))
+		assert.Equal(t, beforeStored+1024, getGaugeValue(CacheStoredSizeBytes))
+	})
+
 	t.Run("Test RecordOperationDuration", func(t *testing.T) {
 		// Note: We can't easily verify the histogram values directly, but we can check that the metric exists
 		// and that the operation doesn't panic
-		metrics.RecordOperationDuration("test_operation", 1.5)
-		metrics.RecordOperationDuration("test_operation", 2.5)
+		metrics.RecordOperationDuration("test_backend", "test_operation", 1.5)
+		metrics.RecordOperationDuration("test_backend", "test_operation", 2.5)
 
 		// Just verify that the metric exists and has some observations
-		hist, err := CacheOperationDuration.GetMetricWithLabelValues("test_operation")
+		hist, err := CacheOperationDuration.GetMetricWithLabelValues("test_backend", "test_operation")
 		assert.NoError(t, err)
 		assert.NotNil(t, hist)
 	})
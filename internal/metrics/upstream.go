@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// UpstreamTLSVerificationDisabled is set to 1 for upstream hosts whose TLS certificates aren't verified
+	UpstreamTLSVerificationDisabled = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "upstream_tls_verification_disabled",
+			Help: "Whether TLS certificate verification is disabled for an upstream host (1 = disabled)",
+		},
+		[]string{"host"},
+	)
+
+	// UpstreamEgressDeniedTotal counts outbound requests refused by the egress allowlist.
+	// It has no host label, as denied hosts are chosen by the caller.
+	UpstreamEgressDeniedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "upstream_egress_denied_total",
+		Help: "Total number of outbound requests refused because the host isn't in the egress allowlist",
+	})
+
+	// UpstreamPrivateAddressDeniedTotal counts outbound connections refused because they target a private address
+	UpstreamPrivateAddressDeniedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "upstream_private_address_denied_total",
+		Help: "Total number of outbound connections refused because the address is private, loopback or link-local",
+	})
+
+	// UpstreamInsecureRequestsTotal counts requests sent without TLS certificate verification
+	UpstreamInsecureRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "upstream_insecure_requests_total",
+			Help: "Total number of upstream requests sent without TLS certificate verification",
+		},
+		[]string{"host"},
+	)
+
+	// UpstreamRequestsTotal counts upstream requests by host and response status, "error" if no response was received.
+	// Each redirect hop is counted separately.
+	UpstreamRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "upstream_requests_total",
+			Help: "Total number of upstream requests by host and response status",
+		},
+		[]string{"host", "status"},
+	)
+
+	// UpstreamRequestDuration tracks the time until upstream response headers are received, by host
+	UpstreamRequestDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "upstream_request_duration_seconds",
+			Help:    "Time until the response headers of upstream requests are received",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"host"},
+	)
+
+	// UpstreamFetchesInFlight is the number of upstream requests holding a slot of the fetch limit, until their
+	// response body is closed
+	UpstreamFetchesInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "upstream_fetches_in_flight",
+		Help: "Number of upstream requests in flight under the fetch concurrency limit",
+	})
+
+	// UpstreamFetchesWaiting is the number of upstream requests waiting for a slot of the fetch limit
+	UpstreamFetchesWaiting = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "upstream_fetches_waiting",
+		Help: "Number of upstream requests waiting for the fetch concurrency limit",
+	})
+)
@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/auth"
+)
+
+// principalKey is the gin context key holding the authenticated principal
+const principalKey = "principal"
+
+// RequireScope returns a Gin middleware that only lets principals with the given scope through.
+// All requests are allowed when authenticator is nil, i.e. authentication is disabled.
+func RequireScope(authenticator *auth.Authenticator, scope auth.Scope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if authenticator == nil {
+			c.Next()
+			return
+		}
+
+		principal := authenticator.Anonymous()
+		if key := credentials(c.Request); key != "" {
+			var ok bool
+			principal, ok = authenticator.Authenticate(key)
+			if !ok {
+				logrus.WithFields(logrus.Fields{
+					"path":     c.Request.URL.Path,
+					"clientIP": c.ClientIP(),
+				}).Warn("Rejected invalid credentials")
+				c.Header("WWW-Authenticate", "Bearer")
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+				return
+			}
+		}
+
+		if !principal.HasScope(scope) {
+			if principal.Name == auth.Anonymous {
+				c.Header("WWW-Authenticate", "Bearer")
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+				return
+			}
+			logrus.WithFields(logrus.Fields{
+				"principal": principal.Name,
+				"scope":     scope,
+				"path":      c.Request.URL.Path,
+			}).Warn("Principal is missing the required scope")
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient scope"})
+			return
+		}
+
+		// Issued tokens can be restricted to part of the cache
+		if !principal.Allows(c.Request.URL.Path) {
+			logrus.WithFields(logrus.Fields{
+				"principal": principal.Name,
+				"prefix":    principal.Prefix,
+				"path":      c.Request.URL.Path,
+			}).Warn("Principal is not allowed to access the path")
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "path not allowed"})
+			return
+		}
+
+		c.Set(principalKey, principal)
+		c.Next()
+	}
+}
+
+// RequireAuthenticated returns a Gin middleware that rejects anonymous callers.
+// All requests are allowed when authenticator is nil.
+func RequireAuthenticated(authenticator *auth.Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if authenticator == nil {
+			c.Next()
+			return
+		}
+
+		principal, ok := authenticator.Authenticate(credentials(c.Request))
+		if !ok {
+			c.Header("WWW-Authenticate", "Bearer")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+			return
+		}
+
+		c.Set(principalKey, principal)
+		c.Next()
+	}
+}
+
+// GetPrincipal returns the principal authenticated for the request, or nil if authentication is disabled
+func GetPrincipal(c *gin.Context) *auth.Principal {
+	if value, exists := c.Get(principalKey); exists {
+		if principal, ok := value.(*auth.Principal); ok {
+			return principal
+		}
+	}
+	return nil
+}
+
+// credentials extracts the API key from the X-Api-Key header or the Authorization bearer token. The X-Api-Key
+// header takes precedence, so the Authorization header can hold upstream credentials, see ForwardedAuthorization.
+func credentials(r *http.Request) string {
+	if key := strings.TrimSpace(r.Header.Get("X-Api-Key")); key != "" {
+		return key
+	}
+	if header := r.Header.Get("Authorization"); header != "" {
+		if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+	return ""
+}
+
+// ForwardedAuthorization returns the Authorization header of the request if it holds a bearer token that may be
+// forwarded to upstream registries, i.e. it isn't the API key the caller was authenticated with
+func ForwardedAuthorization(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	if GetPrincipal(c) != nil && strings.TrimSpace(c.GetHeader("X-Api-Key")) == "" {
+		return ""
+	}
+	return header
+}
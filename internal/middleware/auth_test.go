@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"cachetf/internal/auth"
+)
+
+func TestRequireScope(t *testing.T) {
+	authenticator := auth.NewAuthenticator([]auth.APIKey{
+		{Name: "ci", Key: "ci-key", Scopes: []auth.Scope{auth.ScopeRead, auth.ScopePrefetch}},
+		{Name: "ops", Key: "ops-key", Scopes: []auth.Scope{auth.ScopeAdmin}},
+	}, []auth.Scope{auth.ScopeRead})
+
+	// Tokens restricted to one provider
+	issuer := auth.NewTokenIssuer([]byte("0123456789abcdef0123456789abcdef"), time.Minute, time.Hour)
+	authenticator.UseTokens(issuer)
+	token, err := issuer.Issue(&auth.Principal{Name: "ci", Scopes: []auth.Scope{auth.ScopeRead}}, auth.TokenRequest{
+		Scopes: []auth.Scope{auth.ScopeRead},
+		Prefix: "/test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherToken, err := issuer.Issue(&auth.Principal{Name: "ci", Scopes: []auth.Scope{auth.ScopeRead}}, auth.TokenRequest{
+		Scopes: []auth.Scope{auth.ScopeRead},
+		Prefix: "/other",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		authenticator  *auth.Authenticator
+		scope          auth.Scope
+		header         string
+		value          string
+		expectedStatus int
+		expectedName   string
+	}{
+		{"auth disabled", nil, auth.ScopePurge, "", "", http.StatusOK, ""},
+		{"anonymous read", authenticator, auth.ScopeRead, "", "", http.StatusOK, auth.Anonymous},
+		{"anonymous purge", authenticator, auth.ScopePurge, "", "", http.StatusUnauthorized, ""},
+		{"invalid key", authenticator, auth.ScopeRead, "Authorization", "Bearer nope", http.StatusUnauthorized, ""},
+		{"missing scope", authenticator, auth.ScopePurge, "Authorization", "Bearer ci-key", http.StatusForbidden, ""},
+		{"bearer token", authenticator, auth.ScopePrefetch, "Authorization", "Bearer ci-key", http.StatusOK, "ci"},
+		{"api key header", authenticator, auth.ScopePurge, "X-Api-Key", "ops-key", http.StatusOK, "ops"},
+		{"token within prefix", authenticator, auth.ScopeRead, "Authorization", "Bearer " + token.Token, http.StatusOK, "token:ci"},
+		{"token outside prefix", authenticator, auth.ScopeRead, "Authorization", "Bearer " + otherToken.Token, http.StatusForbidden, ""},
+		{"token missing scope", authenticator, auth.ScopePurge, "Authorization", "Bearer " + token.Token, http.StatusForbidden, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var principal *auth.Principal
+
+			router := gin.New()
+			router.GET("/test", RequireScope(tt.authenticator, tt.scope), func(c *gin.Context) {
+				principal = GetPrincipal(c)
+				c.Status(http.StatusOK)
+			})
+
+			req, _ := http.NewRequest("GET", "/test", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedName != "" {
+				if assert.NotNil(t, principal) {
+					assert.Equal(t, tt.expectedName, principal.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestForwardedAuthorization(t *testing.T) {
+	authenticator := auth.NewAuthenticator([]auth.APIKey{
+		{Name: "ci", Key: "ci-key", Scopes: []auth.Scope{auth.ScopeRead}},
+	}, []auth.Scope{auth.ScopeRead})
+
+	tests := []struct {
+		name          string
+		authenticator *auth.Authenticator
+		headers       map[string]string
+		expected      string
+	}{
+		{"auth disabled", nil, map[string]string{"Authorization": "Bearer upstream"}, "Bearer upstream"},
+		{"no header", nil, nil, ""},
+		{"not a bearer token", nil, map[string]string{"Authorization": "Basic dXNlcjpwYXNz"}, ""},
+		{"anonymous", authenticator, nil, ""},
+		{"proxy key", authenticator, map[string]string{"Authorization": "Bearer ci-key"}, ""},
+		{"proxy key header", authenticator, map[string]string{"X-Api-Key": "ci-key", "Authorization": "Bearer upstream"}, "Bearer upstream"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var forwarded string
+
+			router := gin.New()
+			router.GET("/test", RequireScope(tt.authenticator, auth.ScopeRead), func(c *gin.Context) {
+				forwarded = ForwardedAuthorization(c)
+				c.Status(http.StatusOK)
+			})
+
+			req, _ := http.NewRequest("GET", "/test", nil)
+			for header, value := range tt.headers {
+				req.Header.Set(header, value)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			// The X-Api-Key header authenticates the caller, the Authorization header is left to upstream
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tt.expected, forwarded)
+		})
+	}
+}
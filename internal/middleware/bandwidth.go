@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxBandwidthChunk is the largest write of a throttled response between pauses
+const maxBandwidthChunk = 64 << 10
+
+// BandwidthLimiter caps the rate each response body is written at, in bytes per second. The limit can be changed
+// while responses are written and applies to them from their next write, 0 doesn't limit the responses.
+type BandwidthLimiter struct {
+	limit atomic.Int64
+	// now and sleep are replaceable for tests
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewBandwidthLimiter returns a limiter writing each response at up to limit bytes per second
+func NewBandwidthLimiter(limit int64) *BandwidthLimiter {
+	l := &BandwidthLimiter{now: time.Now, sleep: sleepContext}
+	l.SetLimit(limit)
+	return l
+}
+
+// Limit returns the bytes per second allowed per response, 0 if unlimited
+func (l *BandwidthLimiter) Limit() int64 {
+	return l.limit.Load()
+}
+
+// SetLimit changes the bytes per second allowed per response
+func (l *BandwidthLimiter) SetLimit(limit int64) {
+	l.limit.Store(max(limit, 0))
+}
+
+// Middleware returns a Gin middleware throttling the response bodies. Redirects to presigned URLs aren't
+// throttled, the bucket serves the files.
+func (l *BandwidthLimiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Writer = &throttledWriter{ResponseWriter: c.Writer, limiter: l, ctx: c.Request.Context()}
+		c.Next()
+	}
+}
+
+// throttledWriter paces the writes of a response at the limit of its limiter
+type throttledWriter struct {
+	gin.ResponseWriter
+	limiter *BandwidthLimiter
+	ctx     context.Context
+
+	// rate is the limit the bytes written since start were paced at, the pacing restarts when it changes
+	rate    int64
+	start   time.Time
+	written int64
+}
+
+// Write writes p in chunks, pausing so that the body isn't written faster than the limit
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		rate := w.limiter.Limit()
+		if rate == 0 {
+			n, err := w.ResponseWriter.Write(p)
+			return total + n, err
+		}
+		if rate != w.rate {
+			w.rate = rate
+			w.start = w.limiter.now()
+			w.written = 0
+		}
+
+		// About a tenth of a second of data is written at once
+		chunk := min(int64(len(p)), max(rate/10, 1), maxBandwidthChunk)
+		n, err := w.ResponseWriter.Write(p[:chunk])
+		total += n
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+		w.written += int64(n)
+
+		due := w.start.Add(time.Duration(float64(w.written) / float64(rate) * float64(time.Second)))
+		if wait := due.Sub(w.limiter.now()); wait > 0 {
+			if err := w.limiter.sleep(w.ctx, wait); err != nil {
+				return total, err
+			}
+		}
+	}
+	return total, nil
+}
+
+// WriteString writes s like Write
+func (w *throttledWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// sleepContext waits for d, returning the error of ctx if it's done first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// newThrottledRouter returns a router serving body on / through the middleware of limiter, whose clock only
+// advances when it sleeps. It returns the time slept.
+func newThrottledRouter(limiter *BandwidthLimiter, body string, onWrite func()) (*gin.Engine, *time.Duration) {
+	now := time.Unix(1700000000, 0)
+	slept := new(time.Duration)
+	limiter.now = func() time.Time { return now }
+	limiter.sleep = func(ctx context.Context, d time.Duration) error {
+		*slept += d
+		now = now.Add(d)
+		return ctx.Err()
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(limiter.Middleware())
+	r.GET("/", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+		for chunk := range strings.SplitSeq(body, "|") {
+			c.Writer.WriteString(chunk)
+			if onWrite != nil {
+				onWrite()
+			}
+		}
+	})
+	return r, slept
+}
+
+func TestBandwidthLimiter(t *testing.T) {
+	limiter := NewBandwidthLimiter(1000)
+	body := strings.Repeat("x", 2500)
+	r, slept := newThrottledRouter(limiter, body, nil)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, body, w.Body.String())
+	assert.Equal(t, 2500*time.Millisecond, *slept)
+
+	// 0 doesn't limit the responses
+	limiter.SetLimit(0)
+	assert.Equal(t, int64(0), limiter.Limit())
+	*slept = 0
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, body, w.Body.String())
+	assert.Zero(t, *slept)
+}
+
+func TestBandwidthLimiter_SetLimit(t *testing.T) {
+	limiter := NewBandwidthLimiter(1000)
+	// The limit is raised after the first part of the response, the rest is written faster
+	r, slept := newThrottledRouter(limiter, strings.Repeat("x", 1000)+"|"+strings.Repeat("x", 4000), func() {
+		limiter.SetLimit(4000)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, 5000, w.Body.Len())
+	assert.Equal(t, 2*time.Second, *slept)
+}
+
+func TestBandwidthLimiter_Canceled(t *testing.T) {
+	limiter := NewBandwidthLimiter(10)
+	r, _ := newThrottledRouter(limiter, strings.Repeat("x", 100), nil)
+
+	// The response stops once the client is gone
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+	assert.Equal(t, "x", w.Body.String())
+}
@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"cachetf/internal/metrics"
+)
+
+// clientKey is the gin context key holding the client of the request
+const clientKey = "client"
+
+// ClientIDHeader optionally identifies the runner or pipeline a request comes from
+const ClientIDHeader = "X-Client-Id"
+
+// Clients reported in the client label of the metrics
+const (
+	ClientTerraform = "terraform"
+	ClientOpenTofu  = "opentofu"
+	ClientOther     = "other"
+)
+
+// unknownVersion is the version label of clients without a parsable version
+const unknownVersion = "unknown"
+
+// maxClientIDLength is the length above which X-Client-Id headers are ignored
+const maxClientIDLength = 64
+
+var (
+	// productPattern matches the name/version products of a User-Agent header
+	productPattern = regexp.MustCompile(`([A-Za-z][A-Za-z0-9._-]*)/v?([0-9][0-9A-Za-z.+-]*)`)
+	// clientIDPattern is the character set accepted in X-Client-Id headers
+	clientIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:/-]+$`)
+)
+
+// Client identifies the CLI a request comes from
+type Client struct {
+	// Name is terraform, opentofu or other
+	Name string
+	// Version is the full CLI version, e.g. 1.5.7, empty if unknown
+	Version string
+	// Runner is the first product appended to the User-Agent, e.g. with TF_APPEND_USER_AGENT, empty if none
+	Runner string
+	// ID is the X-Client-Id header, empty if missing or invalid
+	ID string
+}
+
+// MajorVersion returns the low-cardinality version used in metric labels: the major version,
+// or major.minor for 0.x releases, which were breaking each other
+func (c Client) MajorVersion() string {
+	parts := strings.SplitN(c.Version, ".", 3)
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return unknownVersion
+	}
+	if major == 0 {
+		if len(parts) < 2 {
+			return unknownVersion
+		}
+		minor, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return unknownVersion
+		}
+		return "0." + strconv.Itoa(minor)
+	}
+	return strconv.Itoa(major)
+}
+
+// ParseClient identifies the client from the User-Agent and X-Client-Id headers, e.g.
+// "Terraform/1.5.7 (+https://www.terraform.io) atlantis/0.27.0"
+func ParseClient(userAgent, clientID string) Client {
+	client := Client{Name: ClientOther}
+	for i, product := range productPattern.FindAllStringSubmatch(userAgent, -1) {
+		if i == 0 {
+			switch strings.ToLower(product[1]) {
+			case "terraform":
+				client.Name = ClientTerraform
+			case "opentofu", "tofu":
+				client.Name = ClientOpenTofu
+			default:
+				// Only the CLIs get a version, other user agents are lumped together
+				return client
+			}
+			client.Version = product[2]
+			continue
+		}
+		client.Runner = product[1]
+		break
+	}
+
+	if len(clientID) <= maxClientIDLength && clientIDPattern.MatchString(clientID) {
+		client.ID = clientID
+	}
+	return client
+}
+
+// ClientMiddleware returns a Gin middleware that identifies the client of requests, counting them by CLI
+// and major version and making the client available to later handlers with GetClient
+func ClientMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		client := ParseClient(c.Request.UserAgent(), c.GetHeader(ClientIDHeader))
+		c.Set(clientKey, client)
+		metrics.ClientRequestsTotal.WithLabelValues(client.Name, client.MajorVersion()).Inc()
+		c.Next()
+	}
+}
+
+// GetClient returns the client of the request, if it was identified by ClientMiddleware
+func GetClient(c *gin.Context) (Client, bool) {
+	value, ok := c.Get(clientKey)
+	if !ok {
+		return Client{}, false
+	}
+	client, ok := value.(Client)
+	return client, ok
+}
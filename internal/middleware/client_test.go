@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/metrics"
+)
+
+func TestParseClient(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		clientID  string
+		want      Client
+		major     string
+	}{
+		{
+			name:      "terraform",
+			userAgent: "Terraform/1.5.7 (+https://www.terraform.io)",
+			want:      Client{Name: ClientTerraform, Version: "1.5.7"},
+			major:     "1",
+		},
+		{
+			name:      "terraform 0.x",
+			userAgent: "Terraform/0.12.31 (+https://www.terraform.io)",
+			want:      Client{Name: ClientTerraform, Version: "0.12.31"},
+			major:     "0.12",
+		},
+		{
+			name:      "opentofu with runner",
+			userAgent: "OpenTofu/1.6.0-beta1 (+https://opentofu.org) atlantis/0.27.0",
+			clientID:  "ci-runner-42",
+			want:      Client{Name: ClientOpenTofu, Version: "1.6.0-beta1", Runner: "atlantis", ID: "ci-runner-42"},
+			major:     "1",
+		},
+		{
+			name:      "other client",
+			userAgent: "curl/8.4.0",
+			want:      Client{Name: ClientOther},
+			major:     "unknown",
+		},
+		{
+			name:  "missing user agent",
+			want:  Client{Name: ClientOther},
+			major: "unknown",
+		},
+		{
+			name:      "invalid client id",
+			userAgent: "Terraform/1.9.0",
+			clientID:  "runner\nforged=1",
+			want:      Client{Name: ClientTerraform, Version: "1.9.0"},
+			major:     "1",
+		},
+		{
+			name:      "client id too long",
+			userAgent: "Terraform/1.9.0",
+			clientID:  strings.Repeat("a", maxClientIDLength+1),
+			want:      Client{Name: ClientTerraform, Version: "1.9.0"},
+			major:     "1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := ParseClient(tt.userAgent, tt.clientID)
+			assert.Equal(t, tt.want, client)
+			assert.Equal(t, tt.major, client.MajorVersion())
+		})
+	}
+}
+
+func TestClientMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var buf bytes.Buffer
+	logrus.SetOutput(&buf)
+	logrus.SetFormatter(&logrus.JSONFormatter{})
+
+	router := gin.New()
+	router.Use(ClientMiddleware(), LoggerMiddleware())
+	router.GET("/test", func(c *gin.Context) {
+		client, ok := GetClient(c)
+		require.True(t, ok)
+		assert.Equal(t, ClientTerraform, client.Name)
+		c.Status(http.StatusOK)
+	})
+
+	before := testutil.ToFloat64(metrics.ClientRequestsTotal.WithLabelValues(ClientTerraform, "1"))
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("User-Agent", "Terraform/1.5.7 (+https://www.terraform.io) github-actions/2.0")
+	req.Header.Set(ClientIDHeader, "infra-pipeline")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.ClientRequestsTotal.WithLabelValues(ClientTerraform, "1")))
+
+	var logEntry map[string]interface{}
+	require.NoError(t, json.Unmarshal(bytes.TrimSpace(buf.Bytes()), &logEntry))
+	assert.Equal(t, "terraform", logEntry["client"])
+	assert.Equal(t, "1.5.7", logEntry["clientVersion"])
+	assert.Equal(t, "github-actions", logEntry["runner"])
+	assert.Equal(t, "infra-pipeline", logEntry["clientId"])
+}
@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"cachetf/internal/auth"
+)
+
+// Route groups middlewares are attached to with ROUTE_MIDDLEWARES
+const (
+	// GroupAll applies to every request, including unmatched routes
+	GroupAll = "*"
+	// GroupHealth is /health, /readyz, /version and the service discovery document
+	GroupHealth = "health"
+	// GroupAdmin is the admin API, token issuance and the cache bundles
+	GroupAdmin = "admin"
+	// GroupCache is the cache inventory, pins, purges and the transparency log
+	GroupCache = "cache"
+	// GroupPrewarm is the prewarming API
+	GroupPrewarm = "prewarm"
+	// GroupProviders is the provider mirror: indexes, version documents, checksums and binaries
+	GroupProviders = "providers"
+	// GroupModules is the module registry
+	GroupModules = "modules"
+)
+
+// RouteGroups lists the route groups in the order they're documented
+var RouteGroups = []string{GroupAll, GroupHealth, GroupAdmin, GroupCache, GroupPrewarm, GroupProviders, GroupModules}
+
+// Configurable middlewares, by the name used in ROUTE_MIDDLEWARES
+const (
+	// NameClient identifies the CLI of the requests, see ClientMiddleware
+	NameClient = "client"
+	// NameLog logs the requests, see LoggerMiddleware
+	NameLog = "log"
+	// NameRequestID tags the requests with an ID, see RequestID
+	NameRequestID = "requestid"
+	// NameRateLimit limits the request rate of each client IP, see RateLimiter
+	NameRateLimit = "ratelimit"
+	// NameAuth requires an authenticated principal, on top of the scope checks of the routes
+	NameAuth = "auth"
+)
+
+// Names lists the configurable middlewares
+var Names = []string{NameClient, NameLog, NameRequestID, NameRateLimit, NameAuth}
+
+// HookOptions holds the dependencies of the configurable middlewares
+type HookOptions struct {
+	// Auth authenticates the principals of the auth middleware, which lets every request through if it's nil
+	Auth *auth.Authenticator
+	// RateLimiter is shared by the groups the ratelimit middleware is attached to
+	RateLimiter *RateLimiter
+}
+
+// ParseHooks parses the middlewares of the route groups, as group=name,name;group=name. The scope checks of the
+// routes aren't part of it, they always apply.
+func ParseHooks(spec string) (map[string][]string, error) {
+	hooks := make(map[string][]string)
+	for _, entry := range strings.Split(spec, ";") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		group, names, ok := strings.Cut(entry, "=")
+		group = strings.TrimSpace(group)
+		if !ok {
+			return nil, fmt.Errorf("invalid entry %q: must be group=middleware,...", entry)
+		}
+		if !slices.Contains(RouteGroups, group) {
+			return nil, fmt.Errorf("unknown route group %q: must be one of %s", group, strings.Join(RouteGroups, ", "))
+		}
+		if _, ok := hooks[group]; ok {
+			return nil, fmt.Errorf("route group %q is listed twice", group)
+		}
+		hooks[group] = []string{}
+		for _, name := range strings.Split(names, ",") {
+			if name = strings.TrimSpace(name); name == "" {
+				continue
+			}
+			if !slices.Contains(Names, name) {
+				return nil, fmt.Errorf("unknown middleware %q: must be one of %s", name, strings.Join(Names, ", "))
+			}
+			if slices.Contains(hooks[group], name) {
+				return nil, fmt.Errorf("middleware %q is listed twice for route group %q", name, group)
+			}
+			hooks[group] = append(hooks[group], name)
+		}
+	}
+	return hooks, nil
+}
+
+// BuildHooks creates the middlewares of the route groups, in the order they're listed
+func BuildHooks(hooks map[string][]string, opts HookOptions) (map[string][]gin.HandlerFunc, error) {
+	handlers := make(map[string][]gin.HandlerFunc, len(hooks))
+	for group, names := range hooks {
+		for _, name := range names {
+			h, err := newHook(name, opts)
+			if err != nil {
+				return nil, err
+			}
+			handlers[group] = append(handlers[group], h)
+		}
+	}
+	return handlers, nil
+}
+
+// newHook creates a configurable middleware
+func newHook(name string, opts HookOptions) (gin.HandlerFunc, error) {
+	switch name {
+	case NameClient:
+		return ClientMiddleware(), nil
+	case NameLog:
+		return LoggerMiddleware(), nil
+	case NameRequestID:
+		return RequestID(), nil
+	case NameRateLimit:
+		if opts.RateLimiter == nil {
+			return nil, fmt.Errorf("the %s middleware requires a rate limit", name)
+		}
+		return opts.RateLimiter.Middleware(), nil
+	case NameAuth:
+		return RequireAuthenticated(opts.Auth), nil
+	}
+	return nil, fmt.Errorf("unknown middleware %q", name)
+}
@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/auth"
+)
+
+func TestParseHooks(t *testing.T) {
+	tests := []struct {
+		name   string
+		spec   string
+		want   map[string][]string
+		errMsg string
+	}{
+		{"empty", "", map[string][]string{}, ""},
+		{"default", "*=client,log", map[string][]string{"*": {"client", "log"}}, ""},
+		{
+			name: "several groups",
+			spec: " *=requestid, client ,log ; providers=ratelimit;admin=auth,ratelimit;",
+			want: map[string][]string{
+				"*":         {"requestid", "client", "log"},
+				"providers": {"ratelimit"},
+				"admin":     {"auth", "ratelimit"},
+			},
+		},
+		{"group without middlewares", "health=", map[string][]string{"health": {}}, ""},
+		{"missing separator", "providers", nil, "must be group=middleware"},
+		{"unknown group", "downloads=ratelimit", nil, "unknown route group"},
+		{"unknown middleware", "*=trace", nil, "unknown middleware"},
+		{"repeated group", "*=log;*=client", nil, "listed twice"},
+		{"repeated middleware", "*=log,log", nil, "listed twice"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hooks, err := ParseHooks(tt.spec)
+			if tt.errMsg != "" {
+				assert.ErrorContains(t, err, tt.errMsg)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, hooks)
+		})
+	}
+}
+
+func TestBuildHooks(t *testing.T) {
+	hooks, err := ParseHooks("*=requestid;admin=auth;providers=ratelimit")
+	require.NoError(t, err)
+
+	// The rate limiter must be configured
+	_, err = BuildHooks(hooks, HookOptions{})
+	assert.ErrorContains(t, err, "requires a rate limit")
+
+	keys, err := auth.ParseAPIKeys("ci:secret:read")
+	require.NoError(t, err)
+	handlers, err := BuildHooks(hooks, HookOptions{
+		Auth:        auth.NewAuthenticator(keys, []auth.Scope{auth.ScopeRead}),
+		RateLimiter: NewRateLimiter(1, 1),
+	})
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.Use(handlers[GroupAll]...)
+	router.GET("/admin", append(handlers[GroupAdmin], func(c *gin.Context) { c.Status(http.StatusOK) })...)
+	router.GET("/providers", append(handlers[GroupProviders], func(c *gin.Context) { c.Status(http.StatusOK) })...)
+
+	get := func(path, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Anonymous callers are refused by the auth middleware of the admin group only
+	w := get("/admin", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.NotEmpty(t, w.Header().Get(RequestIDHeader))
+	assert.Equal(t, http.StatusOK, get("/admin", "secret").Code)
+
+	// The rate limit only applies to the providers group
+	assert.Equal(t, http.StatusOK, get("/providers", "").Code)
+	assert.Equal(t, http.StatusTooManyRequests, get("/providers", "").Code)
+	assert.Equal(t, http.StatusOK, get("/admin", "secret").Code)
+}
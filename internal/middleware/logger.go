@@ -4,7 +4,8 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/sirupsen/logrus"
+
+	"cachetf/pkg/logger"
 )
 
 // LoggerMiddleware returns a Gin middleware that logs HTTP requests
@@ -22,22 +23,46 @@ func LoggerMiddleware() gin.HandlerFunc {
 		// Get status code
 		statusCode := c.Writer.Status()
 
-		// Log the request
-		entry := logrus.WithFields(logrus.Fields{
-			"method":   c.Request.Method,
-			"path":     c.Request.URL.Path,
-			"status":   statusCode,
-			"latency":  latency,
-			"clientIP": c.ClientIP(),
-		})
-
 		// Log based on status code
+		log := logger.Default()
+		level, msg := logger.InfoLevel, "Request processed"
 		if statusCode >= 500 {
-			entry.Error("Server error")
+			level, msg = logger.ErrorLevel, "Server error"
 		} else if statusCode >= 400 {
-			entry.Warn("Client error")
-		} else {
-			entry.Info("Request processed")
+			level, msg = logger.WarnLevel, "Client error"
+		}
+		if !log.Enabled(level) {
+			return
 		}
+
+		// Build the fields in a single slice, the selected backend encodes them without intermediate copies
+		fields := make([]logger.Field, 0, 10)
+		fields = append(fields,
+			logger.Field{Key: "method", Value: c.Request.Method},
+			logger.Field{Key: "path", Value: c.Request.URL.Path},
+			logger.Field{Key: "status", Value: statusCode},
+			logger.Field{Key: "latency", Value: latency},
+			logger.Field{Key: "clientIP", Value: c.ClientIP()},
+		)
+
+		// Add the client identified by ClientMiddleware
+		if client, ok := GetClient(c); ok {
+			fields = append(fields, logger.Field{Key: "client", Value: client.Name})
+			if client.Version != "" {
+				fields = append(fields, logger.Field{Key: "clientVersion", Value: client.Version})
+			}
+			if client.Runner != "" {
+				fields = append(fields, logger.Field{Key: "runner", Value: client.Runner})
+			}
+			if client.ID != "" {
+				fields = append(fields, logger.Field{Key: "clientId", Value: client.ID})
+			}
+		}
+
+		if id := GetRequestID(c); id != "" {
+			fields = append(fields, logger.Field{Key: "requestId", Value: id})
+		}
+
+		log.Log(level, msg, fields...)
 	}
 }
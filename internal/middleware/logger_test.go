@@ -212,3 +212,27 @@ func TestMain(m *testing.M) {
 	// Exit with the appropriate code
 	os.Exit(code)
 }
+
+// TestLoggerMiddlewareLevel tests that requests below the log level aren't logged
+func TestLoggerMiddlewareLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logrus.SetOutput(&buf)
+	logrus.SetFormatter(&logrus.JSONFormatter{})
+	level := logrus.GetLevel()
+	logrus.SetLevel(logrus.WarnLevel)
+	t.Cleanup(func() { logrus.SetLevel(level) })
+
+	router := gin.New()
+	router.Use(LoggerMiddleware())
+	router.GET("/ok", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/missing", func(c *gin.Context) { c.Status(http.StatusNotFound) })
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/ok", nil))
+	assert.Empty(t, buf.String())
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/missing", nil))
+	var logEntry map[string]interface{}
+	require.NoError(t, json.Unmarshal(bytes.TrimSpace(buf.Bytes()), &logEntry))
+	assert.Equal(t, "Client error", logEntry["msg"])
+	assert.Equal(t, "/missing", logEntry["path"])
+}
@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"cachetf/internal/metrics"
+)
+
+// maxRateLimitBuckets is the number of client buckets above which the idle ones are dropped
+const maxRateLimitBuckets = 10000
+
+// RateLimiter limits the request rate of each client IP with a token bucket: clients may send Burst requests at
+// once, refilled at RPS per second. The limits can be changed while requests are served, a rate of 0 lets every
+// request through.
+type RateLimiter struct {
+	mu      sync.Mutex
+	rps     float64
+	burst   float64
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+}
+
+// tokenBucket holds the tokens of a client, as of last
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a limiter allowing rps requests per second per client, with bursts of burst requests
+func NewRateLimiter(rps float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		rps:     rps,
+		burst:   float64(max(burst, 1)),
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// Limits returns the current request rate and burst
+func (l *RateLimiter) Limits() (rps float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rps, int(l.burst)
+}
+
+// SetLimits changes the request rate and burst. Clients keep their tokens, up to the new burst.
+func (l *RateLimiter) SetLimits(rps float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Tokens are refilled at the previous rate until now
+	now := l.now()
+	newBurst := float64(max(burst, 1))
+	for _, b := range l.buckets {
+		b.tokens = math.Min(newBurst, math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rps))
+		b.last = now
+	}
+	l.rps = rps
+	l.burst = newBurst
+}
+
+// Allow takes a token of the client, returning false and how long until a token is available if there's none
+func (l *RateLimiter) Allow(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Limiting is disabled
+	if l.rps <= 0 {
+		return true, 0
+	}
+
+	now := l.now()
+	b, ok := l.buckets[client]
+	if !ok {
+		if len(l.buckets) >= maxRateLimitBuckets {
+			l.prune(now)
+		}
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rps)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rps * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// prune drops the buckets that refilled completely, they're recreated full
+func (l *RateLimiter) prune(now time.Time) {
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rps >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// Middleware returns a Gin middleware rejecting the requests of clients over the limit with 429
+func (l *RateLimiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowed, wait := l.Allow(c.ClientIP())
+		if !allowed {
+			metrics.RateLimitedRequestsTotal.Inc()
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"cachetf/internal/metrics"
+)
+
+func TestRateLimiter_Allow(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	limiter := NewRateLimiter(2, 3)
+	limiter.now = func() time.Time { return now }
+
+	// Bursts are allowed up to the burst size
+	for i := 0; i < 3; i++ {
+		allowed, _ := limiter.Allow("10.0.0.1")
+		assert.True(t, allowed, "request %d", i)
+	}
+	allowed, wait := limiter.Allow("10.0.0.1")
+	assert.False(t, allowed)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	// Other clients have their own bucket
+	allowed, _ = limiter.Allow("10.0.0.2")
+	assert.True(t, allowed)
+
+	// Tokens refill at the rate, up to the burst size
+	now = now.Add(500 * time.Millisecond)
+	allowed, _ = limiter.Allow("10.0.0.1")
+	assert.True(t, allowed)
+	allowed, _ = limiter.Allow("10.0.0.1")
+	assert.False(t, allowed)
+
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		allowed, _ = limiter.Allow("10.0.0.1")
+		assert.True(t, allowed, "request %d", i)
+	}
+	allowed, _ = limiter.Allow("10.0.0.1")
+	assert.False(t, allowed)
+}
+
+func TestRateLimiter_SetLimits(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	limiter := NewRateLimiter(1, 5)
+	limiter.now = func() time.Time { return now }
+
+	allowed, _ := limiter.Allow("10.0.0.1")
+	assert.True(t, allowed)
+
+	// Clients keep their tokens up to the new burst
+	limiter.SetLimits(10, 2)
+	rps, burst := limiter.Limits()
+	assert.Equal(t, 10.0, rps)
+	assert.Equal(t, 2, burst)
+	for i := 0; i < 2; i++ {
+		allowed, _ = limiter.Allow("10.0.0.1")
+		assert.True(t, allowed, "request %d", i)
+	}
+	allowed, wait := limiter.Allow("10.0.0.1")
+	assert.False(t, allowed)
+	assert.Equal(t, 100*time.Millisecond, wait)
+
+	// A rate of 0 lets every request through
+	limiter.SetLimits(0, 2)
+	for i := 0; i < 10; i++ {
+		allowed, _ = limiter.Allow("10.0.0.1")
+		assert.True(t, allowed, "request %d", i)
+	}
+}
+
+func TestRateLimiter_Prune(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	limiter := NewRateLimiter(1, 1)
+	limiter.now = func() time.Time { return now }
+
+	limiter.Allow("idle")
+	now = now.Add(time.Minute)
+	limiter.Allow("active")
+	limiter.prune(now)
+
+	assert.NotContains(t, limiter.buckets, "idle")
+	assert.Contains(t, limiter.buckets, "active")
+}
+
+func TestRateLimiter_Middleware(t *testing.T) {
+	limiter := NewRateLimiter(0.5, 1)
+
+	router := gin.New()
+	router.GET("/test", limiter.Middleware(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	before := testutil.ToFloat64(metrics.RateLimitedRequestsTotal)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test", nil))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.RateLimitedRequestsTotal))
+}
@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader carries the ID of a request, from the client or a proxy in front of the cache, or generated
+const RequestIDHeader = "X-Request-Id"
+
+// requestIDKey is the gin context key holding the ID of the request
+const requestIDKey = "requestID"
+
+// requestIDPattern is the character set accepted in incoming request IDs
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestID returns a Gin middleware that tags each request with an ID, echoed in the response and logged with
+// the request, so a request can be traced through proxies and the logs
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			var b [16]byte
+			rand.Read(b[:])
+			id = hex.EncodeToString(b[:])
+		}
+		c.Set(requestIDKey, id)
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+// GetRequestID returns the ID of the request, empty if the RequestID middleware isn't used
+func GetRequestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
@@ -0,0 +1,163 @@
+// Package mirror keeps the pinned providers mirrored: on a cron schedule, it checks upstream for new releases
+// and caches them before anyone asks for them
+package mirror
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/cron"
+	"cachetf/internal/handler"
+	"cachetf/internal/metrics"
+	"cachetf/internal/pins"
+	"cachetf/internal/prewarm"
+	"cachetf/internal/semver"
+)
+
+// Principal is recorded as the cause of the downloads made by the refresh
+const Principal = "mirror-refresh"
+
+// Refresher caches the new releases of the providers pinned without a version
+type Refresher struct {
+	warmer   prewarm.Warmer
+	pins     *pins.Set
+	schedule *cron.Schedule
+	// platforms lists the platforms to cache, every published platform if empty
+	platforms []handler.Platform
+	logger    *logrus.Logger
+	now       func() time.Time
+
+	mu sync.Mutex
+	// latest holds the newest version mirrored of each provider
+	latest map[string]semver.Version
+}
+
+// NewRefresher creates a new Refresher running on the cron schedule
+func NewRefresher(warmer prewarm.Warmer, pins *pins.Set, schedule *cron.Schedule, platforms []handler.Platform, logger *logrus.Logger) *Refresher {
+	return &Refresher{
+		warmer:    warmer,
+		pins:      pins,
+		schedule:  schedule,
+		platforms: platforms,
+		logger:    logger,
+		now:       time.Now,
+		latest:    make(map[string]semver.Version),
+	}
+}
+
+// Run refreshes the mirror at every activation of the schedule until ctx is cancelled
+func (r *Refresher) Run(ctx context.Context) {
+	r.logger.WithField("schedule", r.schedule.String()).Info("Scheduled mirror refresh enabled")
+
+	for {
+		next := r.schedule.Next(r.now())
+		if next.IsZero() {
+			r.logger.Warn("Mirror refresh schedule never activates")
+			return
+		}
+
+		timer := time.NewTimer(next.Sub(r.now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		r.Refresh(ctx)
+	}
+}
+
+// Refresh checks upstream once and caches the new versions of the pinned providers.
+// It returns the number of versions cached.
+func (r *Refresher) Refresh(ctx context.Context) int {
+	mirrored := 0
+	seen := make(map[string]bool)
+
+	for _, pin := range r.pins.List() {
+		// Version pins don't get new releases
+		path := pin.Path()
+		if pin.Version != "" || seen[path] {
+			continue
+		}
+		seen[path] = true
+		if ctx.Err() != nil {
+			break
+		}
+
+		mirrored += r.refreshProvider(ctx, pin)
+	}
+
+	if ctx.Err() == nil {
+		metrics.MirrorLastRefreshTimestamp.Set(float64(r.now().Unix()))
+	}
+	r.logger.WithField("versions", mirrored).Info("Mirror refresh finished")
+	return mirrored
+}
+
+// refreshProvider caches the releases of a provider newer than the newest one mirrored. The first time a
+// provider is seen, only its newest release is cached.
+func (r *Refresher) refreshProvider(ctx context.Context, pin pins.Pin) int {
+	path := pin.Path()
+	logger := r.logger.WithField("provider", path)
+
+	published, err := r.warmer.Versions(ctx, pin.Registry, pin.Namespace, pin.Provider)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to list the versions of a mirrored provider")
+		return 0
+	}
+
+	r.mu.Lock()
+	latest, known := r.latest[path]
+	r.mu.Unlock()
+
+	// Pre-releases aren't mirrored
+	var releases []semver.Version
+	for value := range published {
+		v, err := semver.Parse(value)
+		if err != nil || v.Prerelease != "" {
+			continue
+		}
+		if !known || v.Compare(latest) > 0 {
+			releases = append(releases, v)
+		}
+	}
+	if len(releases) == 0 {
+		return 0
+	}
+
+	// Oldest first, so a failure doesn't skip the versions before it
+	slices.SortFunc(releases, semver.Version.Compare)
+	if !known {
+		releases = releases[len(releases)-1:]
+	}
+
+	mirrored := 0
+	for _, v := range releases {
+		platforms := r.platforms
+		if len(platforms) == 0 {
+			platforms = published[v.String()]
+		}
+
+		result := r.warmer.Prewarm(ctx, pin.Registry, pin.Namespace, pin.Provider, v.String(), platforms, Principal)
+		if result.Failed > 0 {
+			// Retried at the next activation
+			logger.WithField("version", v.String()).Warn("Failed to mirror a provider version")
+			break
+		}
+		if result.Cached > 0 {
+			mirrored++
+			metrics.MirrorNewVersionsTotal.WithLabelValues(path).Inc()
+			logger.WithField("version", v.String()).Info("Mirrored new provider version")
+		}
+
+		r.mu.Lock()
+		r.latest[path] = v
+		r.mu.Unlock()
+	}
+	return mirrored
+}
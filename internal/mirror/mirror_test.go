@@ -0,0 +1,174 @@
+package mirror
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/cron"
+	"cachetf/internal/handler"
+	"cachetf/internal/metrics"
+	"cachetf/internal/pins"
+)
+
+// fakeWarmer publishes versions that can be changed between refreshes and records the prewarm calls
+type fakeWarmer struct {
+	mu       sync.Mutex
+	versions map[string]map[string][]handler.Platform
+	cached   map[string]bool
+	fail     map[string]bool
+	calls    []string
+}
+
+func (w *fakeWarmer) Versions(ctx context.Context, registry, namespace, provider string) (map[string][]handler.Platform, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	versions, ok := w.versions[registry+"/"+namespace+"/"+provider]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return versions, nil
+}
+
+func (w *fakeWarmer) Prewarm(ctx context.Context, registry, namespace, provider, version string, platforms []handler.Platform, principal string) handler.PrewarmResult {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var result handler.PrewarmResult
+	for _, platform := range platforms {
+		call := registry + "/" + namespace + "/" + provider + "/" + version + "/" + platform.String()
+		w.calls = append(w.calls, call)
+		switch {
+		case w.fail[version]:
+			result.Failed++
+		case w.cached[call]:
+			result.Skipped++
+		default:
+			w.cached[call] = true
+			result.Cached++
+		}
+	}
+	return result
+}
+
+func newTestRefresher(t *testing.T, warmer *fakeWarmer, spec string, platforms []handler.Platform) *Refresher {
+	t.Helper()
+
+	set := pins.NewSet([]pins.Pin{
+		{Registry: "registry.terraform.io", Namespace: "hashicorp", Provider: "aws"},
+		{Registry: "registry.terraform.io", Namespace: "hashicorp", Provider: "random", Version: "3.7.2"},
+		{Registry: "registry.terraform.io", Namespace: "hashicorp", Provider: "google"},
+	})
+	schedule, err := cron.Parse(spec)
+	require.NoError(t, err)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return NewRefresher(warmer, set, schedule, platforms, logger)
+}
+
+func TestRefresher_Refresh(t *testing.T) {
+	all := []handler.Platform{{OS: "linux", Arch: "amd64"}, {OS: "darwin", Arch: "arm64"}}
+	warmer := &fakeWarmer{
+		versions: map[string]map[string][]handler.Platform{
+			"registry.terraform.io/hashicorp/aws": {
+				"5.30.0":      all,
+				"5.31.0":      all,
+				"5.32.0-beta": all,
+			},
+			"registry.terraform.io/hashicorp/random": {"3.7.2": all, "3.8.0": all},
+		},
+		cached: map[string]bool{},
+		fail:   map[string]bool{},
+	}
+	refresher := newTestRefresher(t, warmer, "@hourly", []handler.Platform{{OS: "linux", Arch: "amd64"}})
+	counter := metrics.MirrorNewVersionsTotal.WithLabelValues("registry.terraform.io/hashicorp/aws")
+	before := testutil.ToFloat64(counter)
+
+	// The first refresh only mirrors the newest release, version pins and unknown providers are skipped
+	assert.Equal(t, 1, refresher.Refresh(t.Context()))
+	assert.Equal(t, []string{"registry.terraform.io/hashicorp/aws/5.31.0/linux_amd64"}, warmer.calls)
+
+	// Nothing new
+	assert.Equal(t, 0, refresher.Refresh(t.Context()))
+	assert.Len(t, warmer.calls, 1)
+
+	// Every release published since the last refresh is mirrored
+	warmer.mu.Lock()
+	warmer.versions["registry.terraform.io/hashicorp/aws"]["5.32.0"] = all
+	warmer.versions["registry.terraform.io/hashicorp/aws"]["5.33.0"] = all
+	warmer.fail["5.33.0"] = true
+	warmer.mu.Unlock()
+
+	assert.Equal(t, 1, refresher.Refresh(t.Context()))
+	assert.Equal(t, []string{
+		"registry.terraform.io/hashicorp/aws/5.31.0/linux_amd64",
+		"registry.terraform.io/hashicorp/aws/5.32.0/linux_amd64",
+		"registry.terraform.io/hashicorp/aws/5.33.0/linux_amd64",
+	}, warmer.calls)
+
+	// Failed versions are retried
+	warmer.mu.Lock()
+	warmer.fail["5.33.0"] = false
+	warmer.mu.Unlock()
+	assert.Equal(t, 1, refresher.Refresh(t.Context()))
+	assert.Equal(t, before+3, testutil.ToFloat64(counter))
+}
+
+func TestRefresher_PublishedPlatforms(t *testing.T) {
+	all := []handler.Platform{{OS: "linux", Arch: "amd64"}, {OS: "darwin", Arch: "arm64"}}
+	warmer := &fakeWarmer{
+		versions: map[string]map[string][]handler.Platform{
+			"registry.terraform.io/hashicorp/google": {"6.0.0": all},
+		},
+		cached: map[string]bool{"registry.terraform.io/hashicorp/google/6.0.0/linux_amd64": true},
+		fail:   map[string]bool{},
+	}
+	refresher := newTestRefresher(t, warmer, "@hourly", nil)
+
+	assert.Equal(t, 1, refresher.Refresh(t.Context()))
+	assert.Equal(t, []string{
+		"registry.terraform.io/hashicorp/google/6.0.0/linux_amd64",
+		"registry.terraform.io/hashicorp/google/6.0.0/darwin_arm64",
+	}, warmer.calls)
+}
+
+func TestRefresher_Run(t *testing.T) {
+	warmer := &fakeWarmer{
+		versions: map[string]map[string][]handler.Platform{
+			"registry.terraform.io/hashicorp/aws": {"5.31.0": {{OS: "linux", Arch: "amd64"}}},
+		},
+		cached: map[string]bool{},
+		fail:   map[string]bool{},
+	}
+	refresher := newTestRefresher(t, warmer, "* * * * *", nil)
+	// Always a few milliseconds before the next activation
+	refresher.now = func() time.Time {
+		return time.Now().Truncate(time.Minute).Add(time.Minute - 5*time.Millisecond)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		refresher.Run(ctx)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool {
+		warmer.mu.Lock()
+		defer warmer.mu.Unlock()
+		return len(warmer.calls) >= 1
+	}, 5*time.Second, 5*time.Millisecond)
+
+	cancel()
+	<-done
+}
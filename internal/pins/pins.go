@@ -0,0 +1,249 @@
+package pins
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"cachetf/internal/layout"
+	"cachetf/internal/metadata"
+	"cachetf/pkg/validate"
+)
+
+// pinsDocument is the metadata document pins created through the API are persisted in
+const pinsDocument = "pins.json"
+
+var (
+	// ErrNotFound is returned when removing a pin that doesn't exist
+	ErrNotFound = errors.New("pin not found")
+	// ErrStatic is returned when removing a pin from the configuration
+	ErrStatic = errors.New("pin is configured statically")
+)
+
+// Pin protects the cached files of a provider, or of a single provider version, from eviction and deletion
+type Pin struct {
+	Registry  string `json:"registry"`
+	Namespace string `json:"namespace"`
+	Provider  string `json:"provider"`
+	// Version is empty when all versions of the provider are pinned
+	Version string `json:"version,omitempty"`
+	// Static is true for pins from the configuration, which can't be removed through the API
+	Static    bool      `json:"static,omitempty"`
+	CreatedAt time.Time `json:"createdAt,omitzero"`
+	CreatedBy string    `json:"createdBy,omitempty"`
+}
+
+// Path returns the pinned registry/namespace/provider[/version]
+func (p *Pin) Path() string {
+	path := p.Registry + "/" + p.Namespace + "/" + p.Provider
+	if p.Version != "" {
+		path += "/" + p.Version
+	}
+	return path
+}
+
+// prefix returns the prefix of the cache keys covered by the pin in the layout
+func (p *Pin) prefix(keys layout.Strategy) string {
+	segments := []string{p.Registry, p.Namespace, p.Provider}
+	if p.Version != "" {
+		segments = append(segments, p.Version)
+	}
+	return keys.ProviderPrefix(segments...)
+}
+
+// Protects returns true if the pin covers the cache key in the layout
+func (p *Pin) Protects(keys layout.Strategy, key string) bool {
+	return strings.HasPrefix(key, p.prefix(keys))
+}
+
+// ParsePin parses a pin in the format registry/namespace/provider[/version]
+func ParsePin(s string) (Pin, error) {
+	parts := strings.Split(strings.Trim(strings.TrimSpace(s), "/"), "/")
+	if len(parts) < 3 || len(parts) > 4 {
+		return Pin{}, fmt.Errorf("invalid pin %q: expected registry/namespace/provider[/version]", s)
+	}
+	if err := validate.ProviderPath(parts...); err != nil {
+		return Pin{}, fmt.Errorf("invalid pin %q: %w", s, err)
+	}
+
+	pin := Pin{Registry: parts[0], Namespace: parts[1], Provider: parts[2]}
+	if len(parts) == 4 {
+		pin.Version = parts[3]
+	}
+	return pin, nil
+}
+
+// ParsePins parses a comma-separated list of pins
+func ParsePins(s string) ([]Pin, error) {
+	var pins []Pin
+	for _, entry := range strings.Split(s, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		pin, err := ParsePin(entry)
+		if err != nil {
+			return nil, err
+		}
+		pin.Static = true
+		pins = append(pins, pin)
+	}
+	return pins, nil
+}
+
+// pinsFile is the persisted form of the pins created through the API
+type pinsFile struct {
+	Pins []Pin `json:"pins"`
+}
+
+// Set holds the pins from the configuration and those created through the API
+type Set struct {
+	mu sync.RWMutex
+	// static holds the pins from the configuration, it is never modified
+	static  []Pin
+	dynamic []Pin
+	// keys is the layout of the protected cache keys
+	keys  layout.Strategy
+	store *metadata.Store
+	// writeMu serializes updates, which are persisted before they're applied
+	writeMu sync.Mutex
+}
+
+// NewSet creates a new Set with the static pins from the configuration
+func NewSet(static []Pin) *Set {
+	return &Set{static: static, keys: layout.Default}
+}
+
+// UseKeys makes the pins cover the cache keys of the given layout
+func (s *Set) UseKeys(keys layout.Strategy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+// Load loads the pins created through the API from the metadata store and persists later changes to it
+func (s *Set) Load(ctx context.Context, store *metadata.Store) error {
+	var file pinsFile
+	if err := store.Load(ctx, pinsDocument, &file); err != nil && !errors.Is(err, metadata.ErrNotFound) {
+		return fmt.Errorf("failed to load pins: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store = store
+	s.dynamic = file.Pins
+	return nil
+}
+
+// List returns all pins, the static ones first
+func (s *Set) List() []Pin {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append(append([]Pin(nil), s.static...), s.dynamic...)
+}
+
+// Protected returns true if a pin covers the cache key
+func (s *Set) Protected(key string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, list := range [][]Pin{s.static, s.dynamic} {
+		for i := range list {
+			if list[i].Protects(s.keys, key) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Overlaps returns true if a pin may cover keys starting with prefix
+func (s *Set) Overlaps(prefix string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, list := range [][]Pin{s.static, s.dynamic} {
+		for i := range list {
+			pinned := list[i].prefix(s.keys)
+			if strings.HasPrefix(pinned, prefix) || strings.HasPrefix(prefix, pinned) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Add adds a pin. Adding a pin that already exists returns the existing one.
+func (s *Set) Add(ctx context.Context, pin Pin) (Pin, error) {
+	pin.Static = false
+	if pin.CreatedAt.IsZero() {
+		pin.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	}
+
+	added := pin
+	err := s.update(ctx, func(pins []Pin) ([]Pin, error) {
+		// Static pins are never modified, so they can be read without the lock
+		for _, existing := range append(append([]Pin(nil), s.static...), pins...) {
+			if existing.Path() == pin.Path() {
+				added = existing
+				return nil, errUnchanged
+			}
+		}
+		return append(pins, pin), nil
+	})
+	if errors.Is(err, errUnchanged) {
+		return added, nil
+	}
+	return added, err
+}
+
+// Remove removes the pin with the given registry/namespace/provider[/version] path
+func (s *Set) Remove(ctx context.Context, path string) error {
+	for i := range s.static {
+		if s.static[i].Path() == path {
+			return ErrStatic
+		}
+	}
+
+	return s.update(ctx, func(pins []Pin) ([]Pin, error) {
+		for i := range pins {
+			if pins[i].Path() == path {
+				return append(pins[:i], pins[i+1:]...), nil
+			}
+		}
+		return nil, ErrNotFound
+	})
+}
+
+// errUnchanged aborts an update without persisting anything
+var errUnchanged = errors.New("unchanged")
+
+// update applies fn to a copy of the dynamic pins, persists the result and swaps it in
+func (s *Set) update(ctx context.Context, fn func([]Pin) ([]Pin, error)) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.mu.RLock()
+	store := s.store
+	pins := append([]Pin(nil), s.dynamic...)
+	s.mu.RUnlock()
+
+	if store == nil {
+		return errors.New("pin management is not enabled")
+	}
+
+	pins, err := fn(pins)
+	if err != nil {
+		return err
+	}
+
+	// Persist before swapping, so the in-memory pins never get ahead of the stored ones
+	if err := store.Save(ctx, pinsDocument, pinsFile{Pins: pins}); err != nil {
+		return fmt.Errorf("failed to persist pins: %w", err)
+	}
+
+	s.mu.Lock()
+	s.dynamic = pins
+	s.mu.Unlock()
+	return nil
+}
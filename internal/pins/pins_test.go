@@ -0,0 +1,118 @@
+package pins
+
+import (
+	"io"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/layout"
+	"cachetf/internal/metadata"
+	"cachetf/internal/storage"
+)
+
+func TestParsePin(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		errMsg   string
+	}{
+		{"registry.terraform.io/hashicorp/aws", "registry.terraform.io/hashicorp/aws", ""},
+		{" registry.terraform.io/hashicorp/aws/5.0.0/ ", "registry.terraform.io/hashicorp/aws/5.0.0", ""},
+		{"registry.terraform.io/hashicorp", "", "expected registry/namespace/provider[/version]"},
+		{"registry.terraform.io/hashicorp/aws/5.0.0/file.zip", "", "expected registry/namespace/provider[/version]"},
+		{"registry.terraform.io/../aws", "", "invalid pin"},
+		{"registry.terraform.io//aws", "", "invalid pin"},
+		{"registry.terraform.io/hashicorp/AWS", "", `invalid provider "AWS"`},
+		{"registry.terraform.io/hashicorp/aws/latest", "", `invalid version "latest"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			pin, err := ParsePin(tt.input)
+			if tt.errMsg != "" {
+				assert.ErrorContains(t, err, tt.errMsg)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, pin.Path())
+		})
+	}
+
+	parsed, err := ParsePins("registry.terraform.io/hashicorp/aws/5.0.0, ,registry.terraform.io/hashicorp/google")
+	require.NoError(t, err)
+	require.Len(t, parsed, 2)
+	assert.True(t, parsed[0].Static)
+	assert.Equal(t, "5.0.0", parsed[0].Version)
+	assert.Empty(t, parsed[1].Version)
+}
+
+func TestSet(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	store := metadata.NewStore(storage.NewLocalStorage(t.TempDir(), logger), logger)
+
+	static, err := ParsePins("registry.terraform.io/hashicorp/aws/5.0.0")
+	require.NoError(t, err)
+	set := NewSet(static)
+	require.NoError(t, set.Load(t.Context(), store))
+
+	// Static pins cover their version only
+	assert.True(t, set.Protected("providers/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip"))
+	assert.False(t, set.Protected("providers/registry.terraform.io/hashicorp/aws/5.0.01/terraform-provider-aws_5.0.01_linux_amd64.zip"))
+	assert.False(t, set.Protected("providers/registry.terraform.io/hashicorp/aws/5.1.0/terraform-provider-aws_5.1.0_linux_amd64.zip"))
+
+	// Pin all versions of a provider
+	pin, err := ParsePin("registry.terraform.io/hashicorp/google")
+	require.NoError(t, err)
+	pin.CreatedBy = "ops"
+	added, err := set.Add(t.Context(), pin)
+	require.NoError(t, err)
+	assert.False(t, added.CreatedAt.IsZero())
+	assert.True(t, set.Protected("providers/registry.terraform.io/hashicorp/google/6.0.0/terraform-provider-google_6.0.0_linux_amd64.zip"))
+	assert.False(t, set.Protected("providers/registry.terraform.io/hashicorp/google-beta/6.0.0/terraform-provider-google-beta_6.0.0_linux_amd64.zip"))
+
+	// Adding a pin twice keeps the original
+	again, err := set.Add(t.Context(), Pin{Registry: "registry.terraform.io", Namespace: "hashicorp", Provider: "google", CreatedBy: "other"})
+	require.NoError(t, err)
+	assert.Equal(t, "ops", again.CreatedBy)
+	assert.Len(t, set.List(), 2)
+
+	// Prefixes overlap pins in both directions
+	assert.True(t, set.Overlaps("providers/registry.terraform.io"))
+	assert.True(t, set.Overlaps("providers/registry.terraform.io/hashicorp/aws"))
+	assert.True(t, set.Overlaps("providers/registry.terraform.io/hashicorp/google/6.0.0"))
+	assert.False(t, set.Overlaps("providers/registry.terraform.io/hashicorp/aws/5.1.0"))
+	assert.False(t, set.Overlaps("providers/registry.opentofu.org"))
+	assert.True(t, set.Overlaps("providers/"))
+	assert.False(t, set.Overlaps("modules/"))
+
+	// Pins are persisted
+	reloaded := NewSet(static)
+	require.NoError(t, reloaded.Load(t.Context(), store))
+	assert.Len(t, reloaded.List(), 2)
+
+	// Static pins can't be removed
+	assert.ErrorIs(t, set.Remove(t.Context(), "registry.terraform.io/hashicorp/aws/5.0.0"), ErrStatic)
+	assert.ErrorIs(t, set.Remove(t.Context(), "registry.terraform.io/hashicorp/azurerm"), ErrNotFound)
+	require.NoError(t, set.Remove(t.Context(), "registry.terraform.io/hashicorp/google"))
+	assert.False(t, set.Protected("providers/registry.terraform.io/hashicorp/google/6.0.0/terraform-provider-google_6.0.0_linux_amd64.zip"))
+}
+
+func TestSet_UseKeys(t *testing.T) {
+	static, err := ParsePins("registry.terraform.io/hashicorp/aws")
+	require.NoError(t, err)
+	keys, err := layout.NewTenantStrategy("acme")
+	require.NoError(t, err)
+	set := NewSet(static)
+	set.UseKeys(keys)
+
+	// Pins cover the keys of the tenant only
+	assert.True(t, set.Protected("providers/_tenants/acme/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip"))
+	assert.False(t, set.Protected("providers/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip"))
+	assert.False(t, set.Protected("providers/_tenants/other/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip"))
+	assert.True(t, set.Overlaps("providers/_tenants/acme/registry.terraform.io"))
+	assert.False(t, set.Overlaps("providers/registry.terraform.io"))
+}
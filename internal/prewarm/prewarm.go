@@ -0,0 +1,208 @@
+// Package prewarm keeps the providers declared in a prewarm list cached, so a fresh deployment is
+// immediately useful, including in air-gapped environments
+package prewarm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/handler"
+	"cachetf/internal/semver"
+)
+
+// Principal is recorded as the cause of the downloads made for the prewarm list
+const Principal = "prewarm-list"
+
+// defaultRegistry is the registry of sources written namespace/name
+const defaultRegistry = "registry.terraform.io"
+
+// Provider is a provider declared in the prewarm list
+type Provider struct {
+	// Source is the provider address, namespace/name or registry/namespace/name
+	Source string `json:"source"`
+	// Versions is a version constraint such as "~> 5.0", every release if empty
+	Versions string `json:"versions"`
+	// Limit is the number of newest matching versions kept cached, 1 if zero
+	Limit int `json:"limit"`
+	// Platforms lists the platforms to cache in the os_arch format, every published platform if empty
+	Platforms []string `json:"platforms"`
+
+	registry    string
+	namespace   string
+	name        string
+	constraints semver.Constraints
+	platforms   []handler.Platform
+}
+
+// ParseFile reads a prewarm list from a JSON file
+func ParseFile(path string) ([]Provider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read prewarm list: %w", err)
+	}
+	return Parse(data)
+}
+
+// Parse parses a prewarm list, a JSON array of providers
+func Parse(data []byte) ([]Provider, error) {
+	var providers []Provider
+	if err := json.Unmarshal(data, &providers); err != nil {
+		return nil, fmt.Errorf("invalid prewarm list: %w", err)
+	}
+
+	for i := range providers {
+		p := &providers[i]
+
+		parts := strings.Split(p.Source, "/")
+		switch len(parts) {
+		case 2:
+			p.registry, p.namespace, p.name = defaultRegistry, parts[0], parts[1]
+		case 3:
+			p.registry, p.namespace, p.name = strings.ToLower(parts[0]), parts[1], parts[2]
+		default:
+			return nil, fmt.Errorf("invalid prewarm source %q: expected [registry/]namespace/name", p.Source)
+		}
+		if slices.Contains(parts, "") {
+			return nil, fmt.Errorf("invalid prewarm source %q: expected [registry/]namespace/name", p.Source)
+		}
+
+		var err error
+		if p.constraints, err = semver.ParseConstraints(p.Versions); err != nil {
+			return nil, fmt.Errorf("invalid versions of %s: %w", p.Source, err)
+		}
+		if p.Limit < 0 {
+			return nil, fmt.Errorf("invalid limit of %s: must not be negative", p.Source)
+		}
+		if p.Limit == 0 {
+			p.Limit = 1
+		}
+		for _, value := range p.Platforms {
+			platform, err := handler.ParsePlatform(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid platforms of %s: %w", p.Source, err)
+			}
+			p.platforms = append(p.platforms, platform)
+		}
+	}
+	return providers, nil
+}
+
+// Warmer caches provider binaries, implemented by the registry handler
+type Warmer interface {
+	// Versions returns the versions upstream publishes for a provider, with the platforms of each version
+	Versions(ctx context.Context, registry, namespace, provider string) (map[string][]handler.Platform, error)
+	// Prewarm caches the binaries of a provider version for the given platforms
+	Prewarm(ctx context.Context, registry, namespace, provider, version string, platforms []handler.Platform, principal string) handler.PrewarmResult
+}
+
+// Scheduler caches the providers of the prewarm list on startup and refreshes them periodically,
+// picking up new versions matching their constraints
+type Scheduler struct {
+	warmer    Warmer
+	providers []Provider
+	interval  time.Duration
+	logger    *logrus.Logger
+}
+
+// NewScheduler creates a new Scheduler refreshing the providers every interval
+func NewScheduler(warmer Warmer, providers []Provider, interval time.Duration, logger *logrus.Logger) *Scheduler {
+	return &Scheduler{
+		warmer:    warmer,
+		providers: providers,
+		interval:  interval,
+		logger:    logger,
+	}
+}
+
+// Run refreshes the providers immediately, then every interval until ctx is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	s.logger.WithFields(logrus.Fields{
+		"providers": len(s.providers),
+		"interval":  s.interval,
+	}).Info("Prewarm list enabled")
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.Refresh(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh caches the binaries of the providers once and returns the combined result
+func (s *Scheduler) Refresh(ctx context.Context) handler.PrewarmResult {
+	var total handler.PrewarmResult
+	for _, p := range s.providers {
+		if ctx.Err() != nil {
+			break
+		}
+
+		logger := s.logger.WithFields(logrus.Fields{
+			"source":   p.Source,
+			"versions": p.Versions,
+		})
+
+		published, err := s.warmer.Versions(ctx, p.registry, p.namespace, p.name)
+		if err != nil {
+			logger.WithError(err).Warn("Failed to list the versions of a prewarmed provider")
+			continue
+		}
+
+		versions := p.selectVersions(published)
+		if len(versions) == 0 {
+			logger.Warn("No published version matches the prewarm constraints")
+			continue
+		}
+
+		for _, version := range versions {
+			platforms := p.platforms
+			if len(platforms) == 0 {
+				platforms = published[version]
+			}
+			result := s.warmer.Prewarm(ctx, p.registry, p.namespace, p.name, version, platforms, Principal)
+			total.Cached += result.Cached
+			total.Skipped += result.Skipped
+			total.Failed += result.Failed
+		}
+	}
+	return total
+}
+
+// selectVersions returns the newest published versions matching the constraints, up to the limit
+func (p *Provider) selectVersions(published map[string][]handler.Platform) []string {
+	var matching []semver.Version
+	for value := range published {
+		v, err := semver.Parse(value)
+		if err != nil || !p.constraints.Check(v) {
+			continue
+		}
+		matching = append(matching, v)
+	}
+
+	// Newest first
+	slices.SortFunc(matching, func(a, b semver.Version) int {
+		return b.Compare(a)
+	})
+	if len(matching) > p.Limit {
+		matching = matching[:p.Limit]
+	}
+
+	versions := make([]string, len(matching))
+	for i, v := range matching {
+		versions[i] = v.String()
+	}
+	return versions
+}
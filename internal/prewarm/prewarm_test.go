@@ -0,0 +1,161 @@
+package prewarm
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/handler"
+)
+
+// fakeWarmer publishes fixed versions and records the prewarm calls
+type fakeWarmer struct {
+	versions map[string]map[string][]handler.Platform
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func (w *fakeWarmer) Versions(ctx context.Context, registry, namespace, provider string) (map[string][]handler.Platform, error) {
+	versions, ok := w.versions[registry+"/"+namespace+"/"+provider]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return versions, nil
+}
+
+func (w *fakeWarmer) Prewarm(ctx context.Context, registry, namespace, provider, version string, platforms []handler.Platform, principal string) handler.PrewarmResult {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, platform := range platforms {
+		w.calls = append(w.calls, registry+"/"+namespace+"/"+provider+"/"+version+"/"+platform.String())
+	}
+	return handler.PrewarmResult{Cached: len(platforms)}
+}
+
+func TestParse(t *testing.T) {
+	providers, err := Parse([]byte(`[
+		{"source": "hashicorp/aws", "versions": "~> 5.0", "limit": 2, "platforms": ["linux_amd64"]},
+		{"source": "Terraform.Example.com/acme/widget"}
+	]`))
+	require.NoError(t, err)
+	require.Len(t, providers, 2)
+
+	assert.Equal(t, "registry.terraform.io", providers[0].registry)
+	assert.Equal(t, 2, providers[0].Limit)
+	assert.Equal(t, []handler.Platform{{OS: "linux", Arch: "amd64"}}, providers[0].platforms)
+
+	assert.Equal(t, "terraform.example.com", providers[1].registry)
+	assert.Equal(t, "acme", providers[1].namespace)
+	assert.Equal(t, 1, providers[1].Limit)
+	assert.Empty(t, providers[1].platforms)
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"not json", `{`, "invalid prewarm list"},
+		{"short source", `[{"source": "aws"}]`, "invalid prewarm source"},
+		{"empty namespace", `[{"source": "registry.terraform.io//aws"}]`, "invalid prewarm source"},
+		{"invalid constraint", `[{"source": "hashicorp/aws", "versions": "latest"}]`, "invalid versions"},
+		{"negative limit", `[{"source": "hashicorp/aws", "limit": -1}]`, "invalid limit"},
+		{"invalid platform", `[{"source": "hashicorp/aws", "platforms": ["linux"]}]`, "invalid platforms"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.data))
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prewarm.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[{"source": "hashicorp/aws"}]`), 0644))
+
+	providers, err := ParseFile(path)
+	require.NoError(t, err)
+	assert.Len(t, providers, 1)
+
+	_, err = ParseFile(filepath.Join(t.TempDir(), "missing.json"))
+	assert.ErrorContains(t, err, "failed to read prewarm list")
+}
+
+func TestScheduler_Refresh(t *testing.T) {
+	linux := []handler.Platform{{OS: "linux", Arch: "amd64"}}
+	all := []handler.Platform{{OS: "linux", Arch: "amd64"}, {OS: "darwin", Arch: "arm64"}}
+	warmer := &fakeWarmer{versions: map[string]map[string][]handler.Platform{
+		"registry.terraform.io/hashicorp/aws": {
+			"4.67.0":      all,
+			"5.30.0":      all,
+			"5.31.0":      all,
+			"5.32.0-beta": all,
+			"6.0.0":       all,
+		},
+		"registry.terraform.io/hashicorp/random": {
+			"3.7.2": linux,
+		},
+	}}
+
+	providers, err := Parse([]byte(`[
+		{"source": "hashicorp/aws", "versions": "~> 5.0", "limit": 2, "platforms": ["linux_amd64"]},
+		{"source": "hashicorp/random"},
+		{"source": "hashicorp/google"},
+		{"source": "hashicorp/random", "versions": ">= 4.0"}
+	]`))
+	require.NoError(t, err)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	scheduler := NewScheduler(warmer, providers, time.Hour, logger)
+
+	result := scheduler.Refresh(context.Background())
+	assert.Equal(t, handler.PrewarmResult{Cached: 3}, result)
+	assert.Equal(t, []string{
+		"registry.terraform.io/hashicorp/aws/5.31.0/linux_amd64",
+		"registry.terraform.io/hashicorp/aws/5.30.0/linux_amd64",
+		"registry.terraform.io/hashicorp/random/3.7.2/linux_amd64",
+	}, warmer.calls)
+}
+
+func TestScheduler_Run(t *testing.T) {
+	warmer := &fakeWarmer{versions: map[string]map[string][]handler.Platform{
+		"registry.terraform.io/hashicorp/random": {"3.7.2": {{OS: "linux", Arch: "amd64"}}},
+	}}
+	providers, err := Parse([]byte(`[{"source": "hashicorp/random"}]`))
+	require.NoError(t, err)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	scheduler := NewScheduler(warmer, providers, 10*time.Millisecond, logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		scheduler.Run(ctx)
+		close(done)
+	}()
+
+	// The list is refreshed on startup and periodically
+	assert.Eventually(t, func() bool {
+		warmer.mu.Lock()
+		defer warmer.mu.Unlock()
+		return len(warmer.calls) >= 2
+	}, 5*time.Second, 5*time.Millisecond)
+
+	cancel()
+	<-done
+}
@@ -0,0 +1,152 @@
+package provenance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"cachetf/internal/metadata"
+)
+
+// documentPrefix is the metadata prefix origin records are stored under, followed by the artifact key
+const documentPrefix = "origins/"
+
+var (
+	// ErrNotFound is returned when an artifact has no origin record
+	ErrNotFound = errors.New("origin record not found")
+	// ErrInvalidKey is returned for keys that can't be cached artifacts
+	ErrInvalidKey = errors.New("invalid artifact key")
+)
+
+// Verification states
+const (
+	// Verified means the check was performed and passed
+	Verified = "verified"
+	// Skipped means the check is enabled but upstream didn't provide what it needs
+	Skipped = "skipped"
+	// Disabled means the check isn't enabled
+	Disabled = "disabled"
+)
+
+// Verification holds the results of the checks performed before an artifact was cached
+type Verification struct {
+	// SHA256 is the checksum the artifact was verified against
+	SHA256 string `json:"sha256,omitempty"`
+	// Checksum is the state of the checksum verification
+	Checksum string `json:"checksum"`
+	// Signature is the state of the SHA256SUMS signature verification
+	Signature string `json:"signature"`
+}
+
+// Record describes where a cached artifact came from
+type Record struct {
+	// Key is the cache key of the artifact
+	Key string `json:"key"`
+	// URL is the final upstream URL, after redirects and without its query string
+	URL string `json:"url"`
+	// Host is the final upstream host
+	Host string `json:"host"`
+	// IP is the address the artifact was received from
+	IP string `json:"ip"`
+	// Status is the upstream response status
+	Status int `json:"status"`
+	// Header holds the upstream response headers
+	Header http.Header `json:"header,omitempty"`
+	// Size is the content length of the cached artifact, 0 in records saved before sizes were recorded
+	Size int64 `json:"size,omitempty"`
+	// FetchedAt is when the artifact was downloaded
+	FetchedAt time.Time `json:"fetchedAt"`
+	// Verification holds the results of the checks performed before caching
+	Verification Verification `json:"verification"`
+	// Principal is the name of the principal whose request caused the download
+	Principal string `json:"principal"`
+}
+
+// Store persists origin records in the metadata store
+type Store struct {
+	metadata *metadata.Store
+}
+
+// NewStore creates a new Store
+func NewStore(metadata *metadata.Store) *Store {
+	return &Store{metadata: metadata}
+}
+
+// document returns the name of the metadata document holding the record of key
+func document(key string) (string, error) {
+	clean := path.Clean("/" + key)[1:]
+	if key == "" || clean != key || strings.HasPrefix(key, metadata.KeyPrefix) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	return documentPrefix + key + ".json", nil
+}
+
+// Save stores the record of an artifact, replacing the previous one
+func (s *Store) Save(ctx context.Context, record *Record) error {
+	name, err := document(record.Key)
+	if err != nil {
+		return err
+	}
+	return s.metadata.Save(ctx, name, record)
+}
+
+// Load returns the record of an artifact, ErrNotFound if there is none
+func (s *Store) Load(ctx context.Context, key string) (*Record, error) {
+	name, err := document(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var record Record
+	if err := s.metadata.Load(ctx, name, &record); err != nil {
+		if errors.Is(err, metadata.ErrNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &record, nil
+}
+
+// Rename moves the record of an artifact to its new key, ErrNotFound if there is none
+func (s *Store) Rename(ctx context.Context, from, to string) error {
+	record, err := s.Load(ctx, from)
+	if err != nil {
+		return err
+	}
+	previous, _ := document(from)
+
+	record.Key = to
+	if err := s.Save(ctx, record); err != nil {
+		return err
+	}
+	return s.metadata.Delete(ctx, previous)
+}
+
+// Delete removes the record of an artifact, if there is one
+func (s *Store) Delete(ctx context.Context, key string) error {
+	name, err := document(key)
+	if err != nil {
+		return err
+	}
+	return s.metadata.Delete(ctx, name)
+}
+
+// Keys returns the keys of the artifacts with a record whose key starts with prefix, in lexical order
+func (s *Store) Keys(ctx context.Context, prefix string) ([]string, error) {
+	names, err := s.metadata.List(ctx, documentPrefix+prefix)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(names))
+	for _, name := range names {
+		key, ok := strings.CutSuffix(strings.TrimPrefix(name, documentPrefix), ".json")
+		if ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,117 @@
+package provenance
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/metadata"
+	"cachetf/internal/storage"
+)
+
+func TestStore(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	backend := storage.NewLocalStorage(t.TempDir(), logger)
+	store := NewStore(metadata.NewStore(backend, logger))
+
+	key := "registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip"
+	_, err := store.Load(t.Context(), key)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	record := &Record{
+		Key:       key,
+		URL:       "https://releases.hashicorp.com/terraform-provider-aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip",
+		Host:      "releases.hashicorp.com",
+		IP:        "18.66.102.52",
+		Status:    http.StatusOK,
+		Header:    http.Header{"Etag": []string{`"abc"`}},
+		FetchedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Verification: Verification{
+			SHA256:    "abc",
+			Checksum:  Verified,
+			Signature: Skipped,
+		},
+		Principal: "ci",
+	}
+	require.NoError(t, store.Save(t.Context(), record))
+
+	loaded, err := store.Load(t.Context(), key)
+	require.NoError(t, err)
+	assert.Equal(t, record, loaded)
+
+	// Records are kept with the metadata documents, not next to the artifact
+	exists, err := backend.Exists(t.Context(), metadata.KeyPrefix+"origins/"+key+".json")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	// Saving again replaces the record
+	record.Principal = "admin"
+	require.NoError(t, store.Save(t.Context(), record))
+	loaded, err = store.Load(t.Context(), key)
+	require.NoError(t, err)
+	assert.Equal(t, "admin", loaded.Principal)
+}
+
+func TestStore_InvalidKeys(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	store := NewStore(metadata.NewStore(storage.NewLocalStorage(t.TempDir(), logger), logger))
+
+	for _, key := range []string{"", "../secret", "registry/../../etc/passwd", "/absolute", "metadata/auth/keys.json", "a//b"} {
+		t.Run(key, func(t *testing.T) {
+			_, err := store.Load(t.Context(), key)
+			assert.ErrorIs(t, err, ErrInvalidKey)
+			assert.ErrorIs(t, store.Save(t.Context(), &Record{Key: key}), ErrInvalidKey)
+		})
+	}
+}
+
+func TestStore_Rename(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	store := NewStore(metadata.NewStore(storage.NewLocalStorage(t.TempDir(), logger), logger))
+
+	from := "registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip"
+	to := "providers/" + from
+	assert.ErrorIs(t, store.Rename(t.Context(), from, to), ErrNotFound)
+
+	require.NoError(t, store.Save(t.Context(), &Record{Key: from, Principal: "ci"}))
+	require.NoError(t, store.Rename(t.Context(), from, to))
+
+	_, err := store.Load(t.Context(), from)
+	assert.ErrorIs(t, err, ErrNotFound)
+	loaded, err := store.Load(t.Context(), to)
+	require.NoError(t, err)
+	assert.Equal(t, to, loaded.Key)
+	assert.Equal(t, "ci", loaded.Principal)
+}
+
+func TestStore_KeysAndDelete(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	store := NewStore(metadata.NewStore(storage.NewLocalStorage(t.TempDir(), logger), logger))
+
+	aws := "providers/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip"
+	consul := "modules/hashicorp/consul/aws/0.1.0/archive.tar.gz"
+	require.NoError(t, store.Save(t.Context(), &Record{Key: aws}))
+	require.NoError(t, store.Save(t.Context(), &Record{Key: consul}))
+
+	keys, err := store.Keys(t.Context(), "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{consul, aws}, keys)
+	keys, err = store.Keys(t.Context(), "providers/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{aws}, keys)
+
+	require.NoError(t, store.Delete(t.Context(), aws))
+	require.NoError(t, store.Delete(t.Context(), aws), "deleting a missing record succeeds")
+	_, err = store.Load(t.Context(), aws)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, store.Delete(t.Context(), "../keys.json"), ErrInvalidKey)
+}
@@ -0,0 +1,246 @@
+// Package replay records the metadata responses of upstream registries to fixture files and serves them back, so
+// the quirks of a registry reported by users, e.g. unusual platform lists, can be reproduced deterministically in
+// regression tests or locally without contacting the registry.
+//
+// Every response is stored in its own JSON file, named after the host and path of the request, e.g.
+// registry.terraform.io/v1/providers/hashicorp/aws/versions.json. Fixtures can be edited by hand, JSON bodies are
+// stored as JSON.
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrNotRecorded is returned by the replayer for the requests without a fixture
+var ErrNotRecorded = errors.New("no recorded upstream response")
+
+// maxBodySize bounds the size of the recorded responses, metadata responses are much smaller
+const maxBodySize = 16 << 20
+
+// Fixture is a recorded upstream response
+type Fixture struct {
+	Method string `json:"method"`
+	// URL is the URL of the request, without its query string as it may hold credentials
+	URL    string `json:"url"`
+	Status int    `json:"status"`
+	// ContentType is the only header recorded, the others may hold credentials or change with every response
+	ContentType string `json:"content_type,omitempty"`
+	// Body holds JSON response bodies
+	Body json.RawMessage `json:"body,omitempty"`
+	// Text holds the other response bodies
+	Text string `json:"text,omitempty"`
+}
+
+// isMetadata returns true for the requests to the registry API and the service discovery, whose responses are
+// recorded. Binaries and checksums aren't.
+func isMetadata(u *url.URL) bool {
+	return strings.Contains(u.Path, "/v1/") || strings.HasSuffix(u.Path, "/.well-known/terraform.json")
+}
+
+// fixturePath returns the path of the fixture of a request URL in dir. The query string is ignored, the registry
+// API doesn't use one.
+func fixturePath(dir string, u *url.URL) (string, error) {
+	// Ports are kept, with a separator allowed in file names everywhere
+	host := strings.ReplaceAll(strings.ToLower(u.Host), ":", "_")
+	if host == "" || strings.ContainsAny(host, `/\`) || strings.Trim(host, ".") == "" {
+		return "", fmt.Errorf("invalid host %q", u.Host)
+	}
+	p := path.Clean("/" + u.Path)
+	if p == "/" {
+		p = "/index"
+	}
+	return filepath.Join(dir, host, filepath.FromSlash(p)+".json"), nil
+}
+
+// Recorder is an http.RoundTripper writing the metadata responses received through it to fixtures
+type Recorder struct {
+	dir    string
+	next   http.RoundTripper
+	logger *logrus.Logger
+}
+
+// NewRecorder creates a recorder writing the fixtures to dir, the requests are sent through next,
+// http.DefaultTransport if nil. Existing fixtures are overwritten.
+func NewRecorder(dir string, next http.RoundTripper, logger *logrus.Logger) *Recorder {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Recorder{dir: dir, next: next, logger: logger}
+}
+
+// RoundTrip implements http.RoundTripper
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.next.RoundTrip(req)
+	if err != nil || req.Method != http.MethodGet || !isMetadata(req.URL) {
+		return resp, err
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize+1))
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	// The response is passed on whether it could be recorded or not
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	if len(body) > maxBodySize {
+		r.logger.WithField("url", stripQuery(req.URL)).Warn("Upstream response too large to be recorded")
+		return resp, nil
+	}
+	if err := r.record(req, resp, body); err != nil {
+		r.logger.WithError(err).WithField("url", stripQuery(req.URL)).Warn("Failed to record upstream response")
+	}
+	return resp, nil
+}
+
+// record writes the fixture of a response
+func (r *Recorder) record(req *http.Request, resp *http.Response, body []byte) error {
+	file, err := fixturePath(r.dir, req.URL)
+	if err != nil {
+		return err
+	}
+
+	fixture := Fixture{
+		Method:      req.Method,
+		URL:         stripQuery(req.URL),
+		Status:      resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+	if len(body) > 0 && json.Valid(body) {
+		fixture.Body = body
+	} else {
+		fixture.Text = string(body)
+	}
+	data, err := json.MarshalIndent(fixture, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode fixture: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return fmt.Errorf("failed to create fixture directory: %w", err)
+	}
+	// Concurrent requests of the same URL replace the fixture as a whole
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".fixture-*")
+	if err != nil {
+		return fmt.Errorf("failed to create fixture: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	// Fixtures are meant to be shared, e.g. committed as test data
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write fixture: %w", err)
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write fixture: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write fixture: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return fmt.Errorf("failed to write fixture: %w", err)
+	}
+
+	r.logger.WithFields(logrus.Fields{
+		"url":     fixture.URL,
+		"status":  fixture.Status,
+		"fixture": file,
+	}).Debug("Recorded upstream response")
+	return nil
+}
+
+// Replayer is an http.RoundTripper answering requests from recorded fixtures, nothing is sent over the network
+type Replayer struct {
+	dir    string
+	logger *logrus.Logger
+}
+
+// NewReplayer creates a replayer serving the fixtures of dir
+func NewReplayer(dir string, logger *logrus.Logger) (*Replayer, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open fixtures: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("fixtures %s is not a directory", dir)
+	}
+	return &Replayer{dir: dir, logger: logger}, nil
+}
+
+// RoundTrip implements http.RoundTripper
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	file, err := fixturePath(r.dir, req.URL)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		r.logger.WithField("url", stripQuery(req.URL)).Warn("No recorded response for upstream request")
+		return nil, fmt.Errorf("%w for %s %s", ErrNotRecorded, req.Method, stripQuery(req.URL))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixture: %w", err)
+	}
+
+	var fixture Fixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return nil, fmt.Errorf("invalid fixture %s: %w", file, err)
+	}
+	if fixture.Method != "" && fixture.Method != req.Method {
+		return nil, fmt.Errorf("%w for %s %s", ErrNotRecorded, req.Method, stripQuery(req.URL))
+	}
+
+	body := []byte(fixture.Text)
+	if len(fixture.Body) > 0 {
+		body = fixture.Body
+	}
+	header := make(http.Header)
+	if fixture.ContentType != "" {
+		header.Set("Content-Type", fixture.ContentType)
+	}
+	status := fixture.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	r.logger.WithFields(logrus.Fields{
+		"url":     stripQuery(req.URL),
+		"fixture": file,
+	}).Debug("Replaying recorded upstream response")
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// stripQuery returns u without its query string and credentials
+func stripQuery(u *url.URL) string {
+	stripped := *u
+	stripped.User = nil
+	stripped.RawQuery = ""
+	stripped.Fragment = ""
+	return stripped.String()
+}
@@ -0,0 +1,155 @@
+package replay_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/replay"
+	"cachetf/internal/routes"
+	"cachetf/internal/storage"
+)
+
+func newLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+// get sends a request through a transport
+func get(t *testing.T, transport http.RoundTripper, url string) (*http.Response, []byte, error) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, body, nil
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/providers/acme/tool/versions":
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Set-Cookie", "session=secret")
+			_, _ = w.Write([]byte(`{"versions":[{"version":"1.0.0","platforms":[{"os":"linux","arch":"amd64"}]}]}`))
+		case "/v1/providers/acme/missing/versions":
+			http.Error(w, "Not Found", http.StatusNotFound)
+		default:
+			_, _ = w.Write([]byte("binary"))
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	recorder := replay.NewRecorder(dir, nil, newLogger())
+
+	// Responses are passed on while they are recorded
+	resp, body, err := get(t, recorder, server.URL+"/v1/providers/acme/tool/versions")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	recorded := string(body)
+	_, _, err = get(t, recorder, server.URL+"/v1/providers/acme/missing/versions?token=secret")
+	require.NoError(t, err)
+	_, body, err = get(t, recorder, server.URL+"/files/tool.zip")
+	require.NoError(t, err)
+	assert.Equal(t, "binary", string(body))
+
+	// Credentials aren't recorded, neither are binaries
+	host := strings.ReplaceAll(strings.TrimPrefix(server.URL, "http://"), ":", "_")
+	data, err := os.ReadFile(filepath.Join(dir, host, "v1/providers/acme/tool/versions.json"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "secret")
+	var fixture replay.Fixture
+	require.NoError(t, json.Unmarshal(data, &fixture))
+	assert.Equal(t, server.URL+"/v1/providers/acme/tool/versions", fixture.URL)
+	assert.Equal(t, "application/json", fixture.ContentType)
+	assert.JSONEq(t, recorded, string(fixture.Body))
+	data, err = os.ReadFile(filepath.Join(dir, host, "v1/providers/acme/missing/versions.json"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "secret")
+	assert.NoFileExists(t, filepath.Join(dir, host, "files/tool.zip.json"))
+
+	// The replayer serves the fixtures without the server
+	server.Close()
+	replayer, err := replay.NewReplayer(dir, newLogger())
+	require.NoError(t, err)
+
+	resp, body, err = get(t, replayer, server.URL+"/v1/providers/acme/tool/versions")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	assert.JSONEq(t, recorded, string(body))
+
+	resp, body, err = get(t, replayer, server.URL+"/v1/providers/acme/missing/versions")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "Not Found\n", string(body))
+
+	_, _, err = get(t, replayer, server.URL+"/files/tool.zip")
+	assert.ErrorIs(t, err, replay.ErrNotRecorded)
+	_, _, err = get(t, replayer, server.URL+"/../../etc/passwd")
+	assert.ErrorIs(t, err, replay.ErrNotRecorded)
+}
+
+func TestNewReplayer_MissingFixtures(t *testing.T) {
+	_, err := replay.NewReplayer(filepath.Join(t.TempDir(), "missing"), newLogger())
+	assert.Error(t, err)
+}
+
+// TestReplay_UnusualPlatforms reproduces a provider listing duplicated platforms, rarely used ones and a version
+// without any, from the fixtures of testdata
+func TestReplay_UnusualPlatforms(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := newLogger()
+	replayer, err := replay.NewReplayer("testdata", logger)
+	require.NoError(t, err)
+
+	router := gin.New()
+	routes.SetupRoutes(router, &routes.Config{
+		URIPrefix: "/providers",
+		Storage:   storage.NewLocalStorage(t.TempDir(), logger),
+		Transport: replayer,
+	})
+	serve := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		return w
+	}
+
+	w := serve("/providers/registry.terraform.io/acme/quirky/index.json")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"versions":{"2.0.0":{},"1.0.0-rc1":{}}}`, w.Body.String())
+
+	w = serve("/providers/registry.terraform.io/acme/quirky/2.0.0.json")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"archives":{
+		"linux_amd64":{"url":"terraform-provider-quirky_2.0.0_linux_amd64.zip"},
+		"freebsd_arm":{"url":"terraform-provider-quirky_2.0.0_freebsd_arm.zip"},
+		"solaris_amd64":{"url":"terraform-provider-quirky_2.0.0_solaris_amd64.zip"}
+	}}`, w.Body.String())
+
+	w = serve("/providers/registry.terraform.io/acme/quirky/1.0.0-rc1.json")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"archives":{}}`, w.Body.String())
+
+	// Nothing else was recorded
+	w = serve("/providers/registry.terraform.io/acme/other/index.json")
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+}
@@ -0,0 +1,235 @@
+// Package replication pulls artifacts from another cachetf instance: it periodically lists the inventory of
+// the source and imports the artifacts missing locally as bundles, so caches in several regions can be fed
+// from a hub without sharing a bucket
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/bundle"
+	"cachetf/internal/metrics"
+	"cachetf/internal/storage"
+)
+
+// pageSize is the number of inventory entries requested at once, the largest page the inventory returns
+const pageSize = 1000
+
+// Options configures a Puller
+type Options struct {
+	// Source is the base URL of the instance to pull from
+	Source string
+	// Token is sent as bearer token to the source, it needs the admin scope to export bundles
+	Token string
+	// Prefixes restrict the pulled artifacts to the keys starting with one of them, every artifact if empty
+	Prefixes []string
+	// Interval is the time between syncs
+	Interval time.Duration
+}
+
+// Puller copies the artifacts missing from the local cache from another instance
+type Puller struct {
+	storage  storage.Storage
+	importer *bundle.Importer
+	options  Options
+	client   *http.Client
+	logger   *logrus.Logger
+	// now is replaceable for tests
+	now func() time.Time
+}
+
+// NewPuller creates a new Puller writing to storage
+func NewPuller(storage storage.Storage, options Options, logger *logrus.Logger) *Puller {
+	options.Source = strings.TrimSuffix(options.Source, "/")
+	return &Puller{
+		storage:  storage,
+		importer: bundle.NewImporter(storage, logger),
+		options:  options,
+		client:   http.DefaultClient,
+		logger:   logger,
+		now:      time.Now,
+	}
+}
+
+// Run syncs with the source every interval until ctx is cancelled
+func (p *Puller) Run(ctx context.Context) {
+	p.logger.WithFields(logrus.Fields{
+		"source":   p.options.Source,
+		"prefixes": p.options.Prefixes,
+		"interval": p.options.Interval,
+	}).Info("Pull replication enabled")
+
+	ticker := time.NewTicker(p.options.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := p.Sync(ctx); err != nil && ctx.Err() == nil {
+			p.logger.WithError(err).Error("Pull replication failed")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync pulls the artifacts missing locally once and returns the number of files imported. Artifacts are
+// pulled by directory, i.e. a provider or module version at a time, with their origin records. A directory
+// failing to import is retried at the next sync and doesn't stop the others.
+func (p *Puller) Sync(ctx context.Context) (int, error) {
+	directories, err := p.missingDirectories(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	imported, failed := 0, 0
+	for _, directory := range directories {
+		if ctx.Err() != nil {
+			return imported, ctx.Err()
+		}
+
+		result, err := p.pull(ctx, directory)
+		if err != nil {
+			failed++
+			p.logger.WithError(err).WithField("prefix", directory).Warn("Failed to pull artifacts")
+			continue
+		}
+		imported += result.Imported
+		metrics.ReplicationFilesTotal.Add(float64(result.Imported))
+	}
+
+	p.logger.WithFields(logrus.Fields{
+		"source":      p.options.Source,
+		"directories": len(directories),
+		"imported":    imported,
+		"failed":      failed,
+	}).Info("Pull replication finished")
+
+	if failed > 0 {
+		return imported, fmt.Errorf("failed to pull %d of %d directories", failed, len(directories))
+	}
+	metrics.ReplicationLastSyncTimestamp.Set(float64(p.now().Unix()))
+	return imported, nil
+}
+
+// inventoryPage is a page of the inventory of the source
+type inventoryPage struct {
+	Objects        []storage.ObjectInfo `json:"objects"`
+	IsTruncated    bool                 `json:"isTruncated"`
+	NextStartAfter string               `json:"nextStartAfter"`
+}
+
+// missingDirectories lists the inventory of the source and returns the directories holding artifacts
+// that aren't cached locally, in inventory order
+func (p *Puller) missingDirectories(ctx context.Context) ([]string, error) {
+	var directories []string
+	seen := make(map[string]bool)
+
+	startAfter := ""
+	for {
+		page, err := p.listPage(ctx, startAfter)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, obj := range page.Objects {
+			directory := path.Dir(obj.Key) + "/"
+			if seen[directory] || !p.selected(obj.Key) || bundle.ValidatePrefix(directory) != nil {
+				continue
+			}
+			exists, err := p.storage.Exists(ctx, obj.Key)
+			if err != nil {
+				return nil, fmt.Errorf("failed to check %s: %w", obj.Key, err)
+			}
+			if !exists {
+				seen[directory] = true
+				directories = append(directories, directory)
+			}
+		}
+
+		if !page.IsTruncated {
+			return directories, nil
+		}
+		startAfter = page.NextStartAfter
+	}
+}
+
+// selected returns true if the key starts with one of the configured prefixes
+func (p *Puller) selected(key string) bool {
+	if len(p.options.Prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range p.options.Prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// listPage requests a page of the inventory of the source
+func (p *Puller) listPage(ctx context.Context, startAfter string) (*inventoryPage, error) {
+	query := url.Values{"limit": {strconv.Itoa(pageSize)}}
+	if startAfter != "" {
+		query.Set("startAfter", startAfter)
+	}
+
+	resp, err := p.get(ctx, "/cache?"+query.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the source inventory: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var page inventoryPage
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, fmt.Errorf("failed to decode the source inventory: %w", err)
+	}
+	if page.IsTruncated && page.NextStartAfter <= startAfter {
+		return nil, fmt.Errorf("source inventory doesn't advance after %q", startAfter)
+	}
+	return &page, nil
+}
+
+// pull imports a bundle of the artifacts under the directory exported by the source
+func (p *Puller) pull(ctx context.Context, directory string) (*bundle.ImportResult, error) {
+	resp, err := p.get(ctx, "/cache/export?"+url.Values{"prefix": {directory}}.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("failed to export from the source: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// A bundle truncated by a failure of the source doesn't pass import
+	return p.importer.Import(ctx, resp.Body)
+}
+
+// get sends an authenticated GET request to the source, failing on other statuses than 200
+func (p *Puller) get(ctx context.Context, uri string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.options.Source+uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	if p.options.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.options.Token)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp, nil
+}
@@ -0,0 +1,123 @@
+package replication
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/handler"
+	"cachetf/internal/metrics"
+	"cachetf/internal/storage"
+)
+
+const (
+	awsBinary    = "providers/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip"
+	awsSums      = "providers/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_SHA256SUMS"
+	randomBinary = "providers/registry.terraform.io/hashicorp/random/3.7.2/terraform-provider-random_3.7.2_linux_amd64.zip"
+	module       = "modules/hashicorp/consul/aws/0.1.0/archive.tar.gz"
+)
+
+func newTestStorage(t *testing.T, logger *logrus.Logger, keys ...string) storage.Storage {
+	t.Helper()
+	store := storage.NewLocalStorage(t.TempDir(), logger)
+	for _, key := range keys {
+		require.NoError(t, store.Put(t.Context(), key, strings.NewReader(key)))
+	}
+	return store
+}
+
+// newSource serves the inventory and export endpoints of a cache, requiring the token
+func newSource(t *testing.T, store storage.Storage, logger *logrus.Logger, token string) *httptest.Server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	cacheHandler := handler.NewCacheHandler(store, logger)
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if c.GetHeader("Authorization") != "Bearer "+token {
+			c.AbortWithStatus(http.StatusUnauthorized)
+		}
+	})
+	router.GET("/cache", cacheHandler.ListCache)
+	router.GET("/cache/export", cacheHandler.ExportCache)
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestPuller_Sync(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	source := newSource(t, newTestStorage(t, logger, awsBinary, awsSums, randomBinary, module, "metadata/origins/"+awsBinary+".json"), logger, "secret")
+	local := newTestStorage(t, logger, awsSums, randomBinary)
+
+	puller := NewPuller(local, Options{
+		Source:   source.URL + "/",
+		Token:    "secret",
+		Prefixes: []string{"providers/"},
+		Interval: time.Minute,
+	}, logger)
+
+	before := testutil.ToFloat64(metrics.ReplicationFilesTotal)
+	imported, err := puller.Sync(t.Context())
+	require.NoError(t, err)
+
+	// The missing binary is pulled with its origin record, files cached already are kept
+	assert.Equal(t, 2, imported)
+	assert.Equal(t, before+2, testutil.ToFloat64(metrics.ReplicationFilesTotal))
+	for key, expected := range map[string]bool{
+		awsBinary: true,
+		"metadata/origins/" + awsBinary + ".json": true,
+		module: false,
+	} {
+		exists, err := local.Exists(t.Context(), key)
+		require.NoError(t, err)
+		assert.Equal(t, expected, exists, key)
+	}
+
+	// Nothing is missing anymore
+	imported, err = puller.Sync(t.Context())
+	require.NoError(t, err)
+	assert.Zero(t, imported)
+}
+
+func TestPuller_SyncPaginates(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	keys := make([]string, 0, pageSize+1)
+	for i := range pageSize + 1 {
+		keys = append(keys, fmt.Sprintf("modules/hashicorp/consul/aws/0.1.%04d/archive.tar.gz", i))
+	}
+	source := newSource(t, newTestStorage(t, logger, keys...), logger, "secret")
+	local := newTestStorage(t, logger)
+
+	puller := NewPuller(local, Options{Source: source.URL, Token: "secret", Interval: time.Minute}, logger)
+	imported, err := puller.Sync(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, pageSize+1, imported)
+}
+
+func TestPuller_SyncFailure(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	source := newSource(t, newTestStorage(t, logger, awsBinary), logger, "secret")
+	local := newTestStorage(t, logger)
+
+	puller := NewPuller(local, Options{Source: source.URL, Token: "wrong", Interval: time.Minute}, logger)
+	_, err := puller.Sync(t.Context())
+	assert.ErrorContains(t, err, "401")
+}
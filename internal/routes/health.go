@@ -0,0 +1,140 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/storage"
+)
+
+// BuildInfo describes the running build, served by GET /version
+type BuildInfo struct {
+	Version string `json:"version"`
+	// Revision and Time are the VCS revision and commit time the binary was built from, if known
+	Revision  string `json:"revision,omitempty"`
+	Time      string `json:"time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"goVersion"`
+}
+
+// ReadBuildInfo returns the build info of the running binary, whose version is set at build time
+func ReadBuildInfo(version string) BuildInfo {
+	info := BuildInfo{Version: version, GoVersion: runtime.Version()}
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Revision = setting.Value
+		case "vcs.time":
+			info.Time = setting.Value
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+	return info
+}
+
+// healthResponse is the response of GET /health
+var healthResponse = map[string]string{"status": "ok"}
+
+// readinessTimeout bounds the storage checks of GET /readyz
+const readinessTimeout = 5 * time.Second
+
+// readinessBackend is a storage backend checked by GET /readyz
+type readinessBackend struct {
+	cache   string
+	backend storage.Storage
+}
+
+// Readiness checks that the storage backends of the caches are reachable, for GET /readyz. Unlike /health, which
+// only tells the process is alive, an instance whose bucket is unreachable or whose cache directory isn't writable
+// is reported unready, so Kubernetes stops routing traffic to it without restarting it.
+type Readiness struct {
+	logger *logrus.Logger
+
+	mu       sync.Mutex
+	backends []readinessBackend
+}
+
+// NewReadiness creates a Readiness checking no backend until they're added
+func NewReadiness(logger *logrus.Logger) *Readiness {
+	return &Readiness{logger: logger}
+}
+
+// Add checks the backend of a cache, named with its cache name or empty for the primary cache. Backends that
+// aren't storage.Checkers are always ready.
+func (r *Readiness) Add(cache string, backend storage.Storage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.backends = append(r.backends, readinessBackend{cache: cache, backend: backend})
+}
+
+// Check returns an error if the backend of a cache can't serve requests
+func (r *Readiness) Check(ctx context.Context) error {
+	r.mu.Lock()
+	backends := r.backends
+	r.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+	for _, b := range backends {
+		checker, ok := b.backend.(storage.Checker)
+		if !ok {
+			continue
+		}
+		if err := checker.Check(ctx); err != nil {
+			if b.cache != "" {
+				err = fmt.Errorf("storage of cache %s: %w", b.cache, err)
+			}
+			r.logger.WithError(err).Warn("Storage isn't ready")
+			return err
+		}
+	}
+	return nil
+}
+
+// readyResponse returns the status and response of GET /readyz
+func (r *Readiness) readyResponse(ctx context.Context) (int, map[string]string) {
+	if err := r.Check(ctx); err != nil {
+		return http.StatusServiceUnavailable, map[string]string{"status": "unavailable", "error": err.Error()}
+	}
+	return http.StatusOK, healthResponse
+}
+
+// NewManagementHandler serves the metrics listener: metrics under /metrics, and /health, /readyz and /version like
+// the main listener, so infrastructure that can only reach the management port can check liveness, readiness and
+// the build
+func NewManagementHandler(metrics http.Handler, buildInfo BuildInfo, readiness *Readiness) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		writeManagementJSON(w, healthResponse)
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		status, response := readiness.readyResponse(r.Context())
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(response)
+	})
+	mux.HandleFunc("GET /version", func(w http.ResponseWriter, r *http.Request) {
+		writeManagementJSON(w, buildInfo)
+	})
+	return mux
+}
+
+// writeManagementJSON writes a JSON response of the management handler
+func writeManagementJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,121 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/storage"
+)
+
+func TestReadBuildInfo(t *testing.T) {
+	info := ReadBuildInfo("1.2.3")
+	assert.Equal(t, "1.2.3", info.Version)
+	assert.Equal(t, runtime.Version(), info.GoVersion)
+}
+
+func TestHealthAndVersionOnBothListeners(t *testing.T) {
+	buildInfo := BuildInfo{Version: "1.2.3", Revision: "0123abc", GoVersion: "go1.24.4"}
+
+	router := gin.New()
+	SetupRoutes(router, &Config{URIPrefix: "/providers", Storage: new(MockStorage), BuildInfo: buildInfo})
+	metrics := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("# metrics"))
+	})
+	management := NewManagementHandler(metrics, buildInfo, NewReadiness(logrus.StandardLogger()))
+
+	for name, listener := range map[string]http.Handler{"main": router, "management": management} {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			listener.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.JSONEq(t, `{"status": "ok"}`, w.Body.String())
+
+			w = httptest.NewRecorder()
+			listener.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.JSONEq(t, `{"status": "ok"}`, w.Body.String())
+
+			w = httptest.NewRecorder()
+			listener.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/version", nil))
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+			assert.JSONEq(t, `{"version": "1.2.3", "revision": "0123abc", "goVersion": "go1.24.4"}`, w.Body.String())
+		})
+	}
+
+	w := httptest.NewRecorder()
+	management.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, "# metrics", w.Body.String())
+	w = httptest.NewRecorder()
+	management.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/health", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+// checkedStorage is a storage whose check returns err
+type checkedStorage struct {
+	MockStorage
+	err error
+}
+
+func (s *checkedStorage) Check(ctx context.Context) error {
+	return s.err
+}
+
+func TestReadiness(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	readiness := NewReadiness(logger)
+	local := storage.NewLocalStorage(t.TempDir(), logger)
+	bucket := &checkedStorage{}
+	readiness.Add("", local)
+	readiness.Add("team-a", bucket)
+	readiness.Add("team-b", new(MockStorage))
+
+	router := gin.New()
+	SetupRoutes(router, &Config{URIPrefix: "/providers", Storage: new(MockStorage), Readiness: readiness})
+	management := NewManagementHandler(http.NotFoundHandler(), BuildInfo{}, readiness)
+
+	for name, listener := range map[string]http.Handler{"main": router, "management": management} {
+		t.Run(name, func(t *testing.T) {
+			bucket.err = nil
+			w := httptest.NewRecorder()
+			listener.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			assert.Equal(t, http.StatusOK, w.Code)
+
+			// An unreachable bucket makes the instance unready, but it's still alive
+			bucket.err = errors.New("connection refused")
+			w = httptest.NewRecorder()
+			listener.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+			assert.JSONEq(t, `{"status": "unavailable", "error": "storage of cache team-a: connection refused"}`, w.Body.String())
+
+			w = httptest.NewRecorder()
+			listener.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+			assert.Equal(t, http.StatusOK, w.Code)
+		})
+	}
+}
+
+func TestReadiness_LocalStorageNotWritable(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	// The cache directory is a file
+	dir := t.TempDir() + "/cache"
+	require.NoError(t, os.WriteFile(dir, nil, 0644))
+	readiness := NewReadiness(logger)
+	readiness.Add("", storage.NewLocalStorage(dir, logger))
+	assert.Error(t, readiness.Check(t.Context()))
+}
@@ -0,0 +1,44 @@
+package routes
+
+import (
+	"net/http"
+	"strings"
+)
+
+// NormalizePath serves requests with repeated and trailing slashes in their path as if they had been sent without
+// them, e.g. /providers//registry.terraform.io/hashicorp/aws/index.json/ as
+// /providers/registry.terraform.io/hashicorp/aws/index.json. Generated CLI configurations join the mirror URL
+// and the provider path with extra slashes, which the router would otherwise answer with a 404 or a redirect.
+func NormalizePath(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if path := normalizePath(r.URL.Path); path != r.URL.Path {
+			// The request belongs to the caller, so change a copy of it
+			r = r.Clone(r.Context())
+			r.URL.Path = path
+			if r.URL.RawPath != "" {
+				r.URL.RawPath = normalizePath(r.URL.RawPath)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// normalizePath collapses repeated slashes and removes the trailing slash of a path, / stays /
+func normalizePath(path string) string {
+	if !strings.Contains(path, "//") && (len(path) <= 1 || !strings.HasSuffix(path, "/")) {
+		return path
+	}
+
+	var b strings.Builder
+	b.Grow(len(path))
+	for i := 0; i < len(path); i++ {
+		if path[i] == '/' && i > 0 && path[i-1] == '/' {
+			continue
+		}
+		b.WriteByte(path[i])
+	}
+	if normalized := b.String(); len(normalized) > 1 {
+		return strings.TrimSuffix(normalized, "/")
+	}
+	return "/"
+}
@@ -0,0 +1,78 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestNormalizePath(t *testing.T) {
+	tests := map[string]string{
+		"":                          "",
+		"/":                         "/",
+		"//":                        "/",
+		"/health":                   "/health",
+		"/health/":                  "/health",
+		"//providers//a///b/":       "/providers/a/b",
+		"/providers/a/index.json":   "/providers/a/index.json",
+		"/providers/a/index.json//": "/providers/a/index.json",
+	}
+	for path, want := range tests {
+		assert.Equal(t, want, normalizePath(path), path)
+	}
+}
+
+// TestNormalizePath_Routes covers the URI prefixes and mirror URLs that generated CLI configurations produce
+func TestNormalizePath_Routes(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		path   string
+	}{
+		{"plain", "/providers", "/providers/registry1/namespace1/provider1/invalid-file.txt"},
+		{"trailing slash", "/providers", "/providers/registry1/namespace1/provider1/invalid-file.txt/"},
+		{"double slash after the prefix", "/providers", "/providers//registry1/namespace1/provider1/invalid-file.txt"},
+		{"double slash inside", "/providers", "/providers/registry1//namespace1/provider1/invalid-file.txt"},
+		{"leading double slash", "/providers", "//providers/registry1/namespace1/provider1/invalid-file.txt"},
+		{"prefix with trailing slash", "/providers/", "/providers/registry1/namespace1/provider1/invalid-file.txt"},
+		{"nested prefix", "/mirror/v1", "/mirror//v1/registry1/namespace1/provider1/invalid-file.txt/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			SetupRoutes(router, &Config{URIPrefix: tt.prefix, Storage: new(MockStorage)})
+
+			// The mirror route answers invalid file names with a 400 instead of the 404 of unknown routes
+			w := httptest.NewRecorder()
+			NormalizePath(router).ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Empty(t, w.Header().Get("Location"))
+		})
+	}
+}
+
+func TestNormalizePath_Delete(t *testing.T) {
+	mockStorage := new(MockStorage)
+	mockStorage.On("DeleteByPrefix", mock.Anything, "providers/registry1/namespace1").Return(1, nil)
+	router := gin.New()
+	SetupRoutes(router, &Config{URIPrefix: "/providers", Storage: mockStorage})
+
+	w := httptest.NewRecorder()
+	NormalizePath(router).ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/providers//registry1/namespace1/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockStorage.AssertExpectations(t)
+
+	// Unknown routes are still not found
+	w = httptest.NewRecorder()
+	NormalizePath(router).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "//unknown//", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	NormalizePath(router).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
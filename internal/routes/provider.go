@@ -0,0 +1,154 @@
+package routes
+
+import (
+	"net/http"
+	"strings"
+
+	"cachetf/internal/handler"
+	"cachetf/pkg/logger"
+	"cachetf/pkg/validate"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// fileParams are the request parameters encoded in the name of a provider file
+type fileParams struct {
+	Version   string
+	OS        string
+	Arch      string
+	Signature bool
+}
+
+// set passes the parameters on to the registry handler as context values
+func (p fileParams) set(c *gin.Context) {
+	c.Set("version", p.Version)
+	if p.OS != "" {
+		c.Set("os", p.OS)
+		c.Set("arch", p.Arch)
+	}
+	c.Set("signature", p.Signature)
+}
+
+// providerFile is a type of file served under the last path segment of the provider routes, as gin can't
+// register different routes for the names of a single segment. New artifact types are added to providerFiles.
+type providerFile struct {
+	// kind names the type in logs
+	kind string
+	// parse returns the parameters encoded in a file name, false if the name isn't of this type
+	parse func(name string) (fileParams, bool)
+	// serve handles the requests for files of this type
+	serve func(h *handler.RegistryHandler, c *gin.Context)
+}
+
+// providerFiles are the types of provider files, matched in order
+var providerFiles = []providerFile{
+	{kind: "latest", parse: parseLatestFile, serve: (*handler.RegistryHandler).GetLatestVersion},
+	{kind: "version", parse: parseVersionFile, serve: (*handler.RegistryHandler).GetProviderVersion},
+	{kind: "shasums", parse: parseSHASumsFile, serve: (*handler.RegistryHandler).GetSHASums},
+	{kind: "signature", parse: parseSignatureFile, serve: (*handler.RegistryHandler).GetSHASums},
+	{kind: "binary", parse: parseBinaryFile, serve: (*handler.RegistryHandler).DownloadProvider},
+}
+
+// matchProviderFile returns the type of a file name and the parameters it encodes
+func matchProviderFile(name string) (*providerFile, fileParams, bool) {
+	for i := range providerFiles {
+		if params, ok := providerFiles[i].parse(name); ok {
+			return &providerFiles[i], params, true
+		}
+	}
+	return nil, fileParams{}, false
+}
+
+// parseLatestFile parses the name of the latest version document, latest. It isn't a version document, so it has
+// no .json form.
+func parseLatestFile(name string) (fileParams, bool) {
+	return fileParams{}, name == handler.LatestVersion
+}
+
+// parseVersionFile parses the name of a version document, 1.2.3 or 1.2.3.json. Both names serve the same
+// document.
+func parseVersionFile(name string) (fileParams, bool) {
+	version := strings.TrimSuffix(name, ".json")
+	return fileParams{Version: version}, validate.Version(version)
+}
+
+// parseArtifactName splits a release artifact name, terraform-provider-<provider>_<version>_<suffix>, into the
+// version and the suffix. Neither provider names nor versions contain underscores.
+func parseArtifactName(name string) (version, suffix string, ok bool) {
+	rest, ok := strings.CutPrefix(name, "terraform-provider-")
+	if !ok {
+		return "", "", false
+	}
+	provider, rest, ok := strings.Cut(rest, "_")
+	if !ok || provider == "" {
+		return "", "", false
+	}
+	version, suffix, ok = strings.Cut(rest, "_")
+	if !ok || !validate.Version(version) {
+		return "", "", false
+	}
+	return version, suffix, true
+}
+
+// parseSHASumsFile parses the name of a checksums file, terraform-provider-<provider>_<version>_SHA256SUMS
+func parseSHASumsFile(name string) (fileParams, bool) {
+	version, suffix, ok := parseArtifactName(name)
+	return fileParams{Version: version}, ok && suffix == "SHA256SUMS"
+}
+
+// parseSignatureFile parses the name of a checksums signature, terraform-provider-<provider>_<version>_SHA256SUMS.sig
+func parseSignatureFile(name string) (fileParams, bool) {
+	version, suffix, ok := parseArtifactName(name)
+	return fileParams{Version: version, Signature: true}, ok && suffix == "SHA256SUMS.sig"
+}
+
+// parseBinaryFile parses the name of a provider binary, terraform-provider-<provider>_<version>_<os>_<arch>.zip
+func parseBinaryFile(name string) (fileParams, bool) {
+	version, suffix, ok := parseArtifactName(name)
+	if !ok {
+		return fileParams{}, false
+	}
+	platform, ok := strings.CutSuffix(suffix, ".zip")
+	if !ok {
+		return fileParams{}, false
+	}
+	osName, arch, ok := strings.Cut(platform, "_")
+	if !ok || osName == "" || arch == "" || strings.Contains(arch, ".") {
+		return fileParams{}, false
+	}
+	return fileParams{Version: version, OS: osName, Arch: arch}, true
+}
+
+// serveProviderFile serves the files of a provider under the last path segment, with the handler of their type
+func serveProviderFile(registryHandler *handler.RegistryHandler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("fileOrVersion")
+
+		file, params, ok := matchProviderFile(name)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported request"})
+			return
+		}
+
+		logger.Debug(registryHandler.Logger(), "Serving provider file", func() logrus.Fields {
+			return logrus.Fields{
+				"file":    name,
+				"kind":    file.kind,
+				"version": params.Version,
+			}
+		})
+
+		params.set(c)
+		file.serve(registryHandler, c)
+	}
+}
+
+// redirectDownload redirects to the binary of a version, or of the latest version, which share their path segment
+// with the provider files. The platform is given by the os and arch segments if routed with them.
+func redirectDownload(registryHandler *handler.RegistryHandler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		fileParams{Version: c.Param("fileOrVersion"), OS: c.Param("os"), Arch: c.Param("arch")}.set(c)
+		registryHandler.RedirectDownload(c)
+	}
+}
@@ -0,0 +1,207 @@
+package routes
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/handler"
+	"cachetf/internal/layout"
+	"cachetf/internal/storage"
+)
+
+// newOfflineProviderRouter serves the provider routes of an offline cache holding a binary of random 3.7.2 and
+// of random 3.8.0-beta.1
+func newOfflineProviderRouter(t *testing.T) *gin.Engine {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	store := storage.NewLocalStorage(t.TempDir(), logger)
+	for _, version := range []string{"3.7.2", "3.8.0-beta.1"} {
+		file := "terraform-provider-random_" + version + "_linux_amd64.zip"
+		key := layout.Providers.Key("registry.terraform.io", "hashicorp", "random", version, file)
+		require.NoError(t, store.Put(t.Context(), key, strings.NewReader("zip")))
+	}
+
+	router := gin.New()
+	SetupRoutes(router, &Config{
+		URIPrefix: "/providers",
+		Storage:   store,
+		Registry:  handler.RegistryOptions{Offline: true},
+	})
+	return router
+}
+
+func TestProviderVersionRoutes(t *testing.T) {
+	router := newOfflineProviderRouter(t)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/providers/registry.terraform.io/hashicorp/random/"+path, nil))
+		return w
+	}
+
+	// The version document is served with and without the .json suffix
+	for _, version := range []string{"3.7.2", "3.8.0-beta.1"} {
+		plain, suffixed := get(version), get(version+".json")
+		assert.Equal(t, http.StatusOK, plain.Code, version)
+		assert.Equal(t, plain.Code, suffixed.Code, version)
+		assert.Equal(t, plain.Body.String(), suffixed.Body.String(), version)
+		assert.Contains(t, plain.Body.String(), "terraform-provider-random_"+version+"_linux_amd64.zip")
+	}
+
+	plain, suffixed := get("9.9.9"), get("9.9.9.json")
+	assert.Equal(t, http.StatusNotFound, plain.Code)
+	assert.Equal(t, plain.Body.String(), suffixed.Body.String())
+
+	// Only a single suffix is stripped
+	assert.Equal(t, http.StatusBadRequest, get("3.7.2.json.json").Code)
+	assert.Equal(t, http.StatusBadRequest, get("latest.json").Code)
+}
+
+func TestMatchProviderFile(t *testing.T) {
+	tests := []struct {
+		name   string
+		kind   string
+		params fileParams
+	}{
+		{name: "latest", kind: "latest"},
+		{name: "3.7.2", kind: "version", params: fileParams{Version: "3.7.2"}},
+		{name: "3.7.2.json", kind: "version", params: fileParams{Version: "3.7.2"}},
+		{name: "3.8.0-beta.1+build.json", kind: "version", params: fileParams{Version: "3.8.0-beta.1+build"}},
+		{
+			name:   "terraform-provider-random_3.7.2_SHA256SUMS",
+			kind:   "shasums",
+			params: fileParams{Version: "3.7.2"},
+		},
+		{
+			name:   "terraform-provider-random_3.7.2_SHA256SUMS.sig",
+			kind:   "signature",
+			params: fileParams{Version: "3.7.2", Signature: true},
+		},
+		{
+			name:   "terraform-provider-random_3.8.0-beta.1_linux_amd64.zip",
+			kind:   "binary",
+			params: fileParams{Version: "3.8.0-beta.1", OS: "linux", Arch: "amd64"},
+		},
+		{name: "invalid-file.txt"},
+		{name: "3.7.2.json.json"},
+		{name: "terraform-provider-random_3.7.2_SHA256SUMS.asc"},
+		{name: "terraform-provider-random_3.7.2_linux.zip"},
+		{name: "terraform-provider-random_latest_linux_amd64.zip"},
+		{name: "terraform-provider-_3.7.2_linux_amd64.zip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, params, ok := matchProviderFile(tt.name)
+			if tt.kind == "" {
+				assert.False(t, ok)
+				return
+			}
+			require.True(t, ok)
+			assert.Equal(t, tt.kind, file.kind)
+			assert.Equal(t, tt.params, params)
+		})
+	}
+}
+
+func TestProviderDownloadRedirect(t *testing.T) {
+	router := newOfflineProviderRouter(t)
+	get := func(path, userAgent string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/providers/registry.terraform.io/hashicorp/random/"+path, nil)
+		req.Header.Set("User-Agent", userAgent)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// The platform is given by the query parameters, or inferred from the User-Agent
+	binary := "/providers/registry.terraform.io/hashicorp/random/terraform-provider-random_3.7.2_linux_amd64.zip"
+	for _, w := range []*httptest.ResponseRecorder{
+		get("3.7.2/download?os=linux&arch=amd64", "Terraform/1.9.0"),
+		get("3.7.2/download", "curl/8.5.0 (x86_64-pc-linux-gnu)"),
+		get("3.7.2/download?os=linux", "Wget/1.21.4 (linux-gnu) x86_64"),
+	} {
+		assert.Equal(t, http.StatusFound, w.Code)
+		assert.Equal(t, binary, w.Header().Get("Location"))
+	}
+	assert.Equal(t, "User-Agent", get("3.7.2/download", "curl/8.5.0 (x86_64-pc-linux-gnu)").Header().Get("Vary"))
+
+	// The redirect leads to the binary
+	w := get(strings.TrimPrefix(binary, "/providers/registry.terraform.io/hashicorp/random/"), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "zip", w.Body.String())
+
+	assert.Equal(t, http.StatusBadRequest, get("3.7.2/download", "Terraform/1.9.0").Code)
+	assert.Equal(t, http.StatusBadRequest, get("3.7.2/download?os=plan9&arch=amd64", "").Code)
+	assert.Equal(t, http.StatusBadRequest, get("3.7.x/download?os=linux&arch=amd64", "").Code)
+
+	// The platform can be given by path segments
+	w = get("3.7.2/download/linux/amd64", "")
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, binary, w.Header().Get("Location"))
+
+	// Names reported by uname and Docker are mapped to the Terraform ones
+	for _, path := range []string{
+		"3.7.2/download?os=Linux&arch=x86_64",
+		"3.7.2/download?platform=linux/amd64",
+		"3.7.2/download?platform=Linux+x86_64",
+		"3.7.2/download/Linux/x86_64",
+	} {
+		w = get(path, "")
+		assert.Equal(t, http.StatusFound, w.Code, path)
+		assert.Equal(t, binary, w.Header().Get("Location"), path)
+	}
+	assert.Equal(t, http.StatusBadRequest, get("3.7.2/download?platform=plan9/amd64", "").Code)
+
+	// Client hints win over the User-Agent, browsers are asked for them
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/providers/registry.terraform.io/hashicorp/random/3.7.2/download", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7)")
+	req.Header.Set("Sec-CH-UA-Platform", `"Linux"`)
+	req.Header.Set("Sec-CH-UA-Arch", `"x86"`)
+	req.Header.Set("Sec-CH-UA-Bitness", `"64"`)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, binary, w.Header().Get("Location"))
+	assert.Contains(t, w.Header().Get("Accept-CH"), "Sec-CH-UA-Arch")
+	assert.Contains(t, w.Header().Values("Vary"), "Sec-CH-UA-Platform, Sec-CH-UA-Arch, Sec-CH-UA-Bitness")
+}
+
+func TestProviderLatestRoutes(t *testing.T) {
+	router := newOfflineProviderRouter(t)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/providers/registry.terraform.io/hashicorp/random/"+path, nil))
+		return w
+	}
+
+	// Pre-releases are never the latest version
+	for _, path := range []string{"latest", "latest?constraints=~>+3.0"} {
+		w := get(path)
+		assert.Equal(t, http.StatusOK, w.Code, path)
+		assert.JSONEq(t, `{
+			"version": "3.7.2",
+			"archives": {"linux_amd64": {"url": "terraform-provider-random_3.7.2_linux_amd64.zip"}}
+		}`, w.Body.String(), path)
+	}
+	assert.Equal(t, http.StatusNotFound, get("latest?constraints=>=+4.0").Code)
+	assert.Equal(t, http.StatusBadRequest, get("latest?constraints=~>+x").Code)
+
+	binary := "/providers/registry.terraform.io/hashicorp/random/terraform-provider-random_3.7.2_linux_amd64.zip"
+	for _, path := range []string{"latest/download/linux/amd64", "latest/download?os=linux&arch=amd64"} {
+		w := get(path)
+		assert.Equal(t, http.StatusFound, w.Code, path)
+		assert.Equal(t, binary, w.Header().Get("Location"), path)
+	}
+	// Only the platforms of the latest version are redirected to
+	assert.Equal(t, http.StatusNotFound, get("latest/download/darwin/arm64").Code)
+	assert.Equal(t, http.StatusNotFound, get("latest/download/linux/amd64?constraints=>=+4.0").Code)
+	assert.Equal(t, http.StatusBadRequest, get("latest/download/plan9/amd64").Code)
+}
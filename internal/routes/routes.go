@@ -1,13 +1,20 @@
 package routes
 
 import (
-	"fmt"
 	"net/http"
-	"regexp"
+	"slices"
 	"strings"
 
+	"cachetf/internal/auth"
 	"cachetf/internal/handler"
+	"cachetf/internal/layout"
+	"cachetf/internal/limits"
+	"cachetf/internal/middleware"
+	"cachetf/internal/pins"
+	"cachetf/internal/provenance"
+	"cachetf/internal/snapshot"
 	"cachetf/internal/storage"
+	"cachetf/internal/transparency"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
@@ -22,109 +29,357 @@ func SetupRoutes(router *gin.Engine, config *Config) {
 
 	// Create handlers with logger and storage
 	logger := logrus.StandardLogger()
-	registryHandler := handler.NewRegistryHandler(logger, config.Storage)
-	cacheHandler := handler.NewCacheHandler(config.Storage, logger)
+	registryHandler := config.RegistryHandler()
+	registryOpts := config.registryOptions()
+	cacheHandler := config.cacheHandler()
+
+	// Middlewares of every request, including unmatched routes
+	router.Use(config.Middlewares[middleware.GroupAll]...)
 
 	// Health check endpoint
-	router.GET("/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{
-			"status": "ok",
+	router.GET("/health", config.handlers(middleware.GroupHealth, func(c *gin.Context) {
+		c.JSON(200, healthResponse)
+	})...)
+
+	// Readiness check, also served by the metrics listener
+	readiness := config.Readiness
+	if readiness == nil {
+		readiness = NewReadiness(logger)
+	}
+	router.GET("/readyz", config.handlers(middleware.GroupHealth, func(c *gin.Context) {
+		c.JSON(readiness.readyResponse(c.Request.Context()))
+	})...)
+
+	// Build info, also served by the metrics listener
+	router.GET("/version", config.handlers(middleware.GroupHealth, func(c *gin.Context) {
+		c.JSON(http.StatusOK, config.BuildInfo)
+	})...)
+
+	// Service discovery document, so the instance can be used as a registry host
+	if config.ServiceDiscovery != nil {
+		router.GET("/.well-known/terraform.json", config.handlers(middleware.GroupHealth, func(c *gin.Context) {
+			c.JSON(http.StatusOK, config.ServiceDiscovery)
+		})...)
+	}
+
+	// Token issuance, only available to authenticated principals
+	if config.Auth != nil && config.Auth.Tokens() != nil {
+		tokenHandler := handler.NewTokenHandler(config.Auth.Tokens(), logger)
+		router.POST("/auth/tokens", config.handlers(middleware.GroupAdmin,
+			middleware.RequireAuthenticated(config.Auth), tokenHandler.IssueToken)...)
+	}
+
+	// Admin API, only served when callers can be authenticated
+	if config.Auth != nil {
+		adminHandler := handler.NewAdminHandler(config.Auth, config.Provenance, logger)
+		admin := router.Group("/admin", config.handlers(middleware.GroupAdmin,
+			middleware.RequireScope(config.Auth, auth.ScopeAdmin))...)
+		{
+			admin.GET("/keys", adminHandler.ListAPIKeys)
+			admin.POST("/keys", adminHandler.CreateAPIKey)
+			admin.DELETE("/keys/:id", adminHandler.RevokeAPIKey)
+			admin.GET("/origins/*key", adminHandler.GetOrigin)
+			if registryOpts.Transparency != nil {
+				transparencyHandler := handler.NewTransparencyHandler(registryOpts.Transparency, logger)
+				admin.GET("/checksum-changes", transparencyHandler.ListChanges)
+				admin.POST("/checksum-changes/approve", transparencyHandler.ApproveChange)
+			}
+			if config.Limits != nil {
+				limitsHandler := handler.NewLimitsHandler(config.Limits, logger)
+				admin.GET("/limits", limitsHandler.GetLimits)
+				admin.PUT("/limits", limitsHandler.UpdateLimits)
+				admin.DELETE("/limits", limitsHandler.ResetLimits)
+			}
+			if config.Snapshots != nil {
+				snapshotHandler := handler.NewSnapshotHandler(config.Snapshots, logger)
+				admin.GET("/snapshots", snapshotHandler.ListSnapshots)
+				admin.POST("/snapshots", snapshotHandler.CreateSnapshot)
+			}
+		}
+	}
+
+	// Cache inventory
+	router.GET("/cache", config.handlers(middleware.GroupCache,
+		middleware.RequireScope(config.Auth, auth.ScopeRead), cacheHandler.ListCache)...)
+
+	// Cache bundles, they include origin records which are only available to admins
+	router.GET("/cache/export", config.handlers(middleware.GroupAdmin,
+		middleware.RequireScope(config.Auth, auth.ScopeAdmin), cacheHandler.ExportCache)...)
+	router.POST("/cache/import", config.handlers(middleware.GroupAdmin,
+		middleware.RequireScope(config.Auth, auth.ScopeAdmin), cacheHandler.ImportCache)...)
+
+	// Prewarming, downloads provider binaries in the background
+	prewarm := router.Group("/prewarm", config.registryHandlers(middleware.GroupPrewarm,
+		middleware.RequireScope(config.Auth, auth.ScopePrefetch))...)
+	{
+		prewarm.POST("/:registry/:namespace/:provider/:version", registryHandler.PrewarmProvider)
+		prewarm.POST("/lockfile", registryHandler.PrewarmLockFile)
+	}
+
+	// Checksum transparency log
+	if registryOpts.Transparency != nil {
+		transparencyHandler := handler.NewTransparencyHandler(registryOpts.Transparency, logger)
+		router.GET("/transparency", config.handlers(middleware.GroupCache,
+			middleware.RequireScope(config.Auth, auth.ScopeRead), transparencyHandler.QueryLog)...)
+	}
+
+	// Pinning API
+	if config.Pins != nil {
+		pinHandler := handler.NewPinHandler(config.Pins, logger)
+		router.GET("/cache/pins", config.handlers(middleware.GroupCache,
+			middleware.RequireScope(config.Auth, auth.ScopeRead), pinHandler.ListPins)...)
+		pinning := router.Group("/cache/pins", config.registryHandlers(middleware.GroupCache,
+			middleware.RequireScope(config.Auth, auth.ScopePurge))...)
+		{
+			pinning.PUT("/:registry/:namespace/:provider", pinHandler.PinProvider)
+			pinning.PUT("/:registry/:namespace/:provider/:version", pinHandler.PinProvider)
+			pinning.DELETE("/:registry/:namespace/:provider", pinHandler.UnpinProvider)
+			pinning.DELETE("/:registry/:namespace/:provider/:version", pinHandler.UnpinProvider)
+		}
+	}
+
+	// Provider mirror and cache management endpoints
+	registerProviderRoutes(router, config, registryHandler, cacheHandler)
+
+	// Terraform module registry API endpoints
+	if config.ModulesURIPrefix != "" {
+		moduleHandler := handler.NewModuleHandler(logger, config.Storage, config.ModulesUpstream, config.ModulesURIPrefix, config.Transport)
+		if config.Keys != nil {
+			moduleHandler.UseKeys(config.Keys)
+		}
+		if config.Provenance != nil {
+			moduleHandler.UseProvenance(config.Provenance)
+		}
+		modules := router.Group(config.ModulesURIPrefix+"/:namespace/:name/:system", config.handlers(middleware.GroupModules,
+			middleware.RequireScope(config.Auth, auth.ScopeRead))...)
+		{
+			// GET /:namespace/:name/:system/versions
+			modules.GET("/versions", moduleHandler.GetModuleVersions)
+			// GET /:namespace/:name/:system/:version/download
+			modules.GET("/:version/download", moduleHandler.DownloadModule)
+			// GET /:namespace/:name/:system/:version/archive.tar.gz
+			modules.GET("/:version/:file", moduleHandler.GetModuleArchive)
+		}
+	}
+
+	// Add 404 handler
+	router.NoRoute(func(c *gin.Context) {
+		c.JSON(404, gin.H{
+			"error": "Not Found",
 		})
 	})
+}
+
+// SetupCacheRoutes serves an additional cache under the URI prefix of its config. Only the provider mirror and
+// cache management endpoints are registered, the other APIs are served by SetupRoutes for the primary cache.
+func SetupCacheRoutes(router *gin.Engine, config *Config) {
+	if config.Storage == nil {
+		logrus.Fatal("Storage is not configured")
+	}
 
+	registerProviderRoutes(router, config, config.RegistryHandler(), config.cacheHandler())
+}
+
+// registerProviderRoutes registers the provider mirror and cache management endpoints under the URI prefix
+func registerProviderRoutes(router *gin.Engine, config *Config, registryHandler *handler.RegistryHandler, cacheHandler *handler.CacheHandler) {
 	// Base group with configurable URI prefix
 	base := router.Group(config.URIPrefix)
 
+	// Scope checks for the route groups
+	requireRead := middleware.RequireScope(config.Auth, auth.ScopeRead)
+	requirePurge := middleware.RequireScope(config.Auth, auth.ScopePurge)
+
 	// Cache management endpoints
+	purge := base.Group("", config.registryHandlers(middleware.GroupCache, requirePurge)...)
 	{
 		// DELETE /:registry/...
-		base.DELETE("/:registry", cacheHandler.DeleteCache)
-		base.DELETE("/:registry/:namespace", cacheHandler.DeleteCache)
-		base.DELETE("/:registry/:namespace/:provider", cacheHandler.DeleteCache)
-		base.DELETE("/:registry/:namespace/:provider/:version", cacheHandler.DeleteCache)
+		purge.DELETE("/:registry", cacheHandler.DeleteCache)
+		purge.DELETE("/:registry/:namespace", cacheHandler.DeleteCache)
+		purge.DELETE("/:registry/:namespace/:provider", cacheHandler.DeleteCache)
+		purge.DELETE("/:registry/:namespace/:provider/:version", cacheHandler.DeleteCache)
+		purge.DELETE("/:registry/:namespace/:provider/:version/:file", cacheHandler.DeleteCache)
 	}
 
 	// Terraform Registry API endpoints
-	registry := base.Group("/:registry/:namespace/:provider")
+	registry := base.Group("/:registry/:namespace/:provider", config.registryHandlers(middleware.GroupProviders, requireRead)...)
 	{
 		// GET /:registry/:namespace/:provider/index.json
 		registry.GET("/index.json", registryHandler.GetProviderIndex)
 
-		// Handle both version and file requests
-		re := regexp.MustCompile(`^(\d+\.\d+\.\d+)(?:-[\w-]+)?(?:\.json)?$`)
-		registry.GET("/:fileOrVersion", func(c *gin.Context) {
-			fileOrVersion := c.Param("fileOrVersion")
-
-			// Check if it's a version request (e.g., 1.2.3 or 1.2.3.json)
-			version := strings.TrimSuffix(fileOrVersion, ".json")
-			if re.MatchString(version) {
-				c.Set("version", version)
-				registryHandler.GetProviderVersion(c)
-				return
-			}
+		// Version documents, SHA256SUMS files and provider binaries share the last path segment
+		registry.GET("/:fileOrVersion", serveProviderFile(registryHandler))
 
-			// Check if it's a .zip file (provider binary)
-			if strings.HasSuffix(fileOrVersion, ".zip") {
-				// Debug log the incoming filename
-				logrus.WithField("filename", fileOrVersion).Debug("Processing provider binary request")
-
-				// More permissive pattern to match the provider binary filename
-				pattern := `^terraform-provider-([^_]+?)_(\d+\.\d+\.\d+(?:-[\w-]+)?)_([^_]+)_([^.]+)\.zip$`
-				re := regexp.MustCompile(pattern)
-				matches := re.FindStringSubmatch(fileOrVersion)
-
-				logrus.WithFields(logrus.Fields{
-					"filename": fileOrVersion,
-					"pattern":  pattern,
-					"matches":  matches,
-				}).Debug("Regex match results")
-
-				if len(matches) < 5 { // full match + 4 groups
-					errMsg := fmt.Sprintf("invalid file format: %s (pattern: %s, matches: %v)", fileOrVersion, pattern, matches)
-					logrus.WithField("filename", fileOrVersion).Error("Failed to match provider binary pattern")
-					c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
-					return
-				}
-
-				// Set the file parameter in the URL parameters
-				c.Params = append(c.Params, gin.Param{
-					Key:   "file",
-					Value: fileOrVersion,
-				})
-
-				// Also set the individual components as context values
-				c.Set("version", matches[2])
-				c.Set("os", matches[3])
-				c.Set("arch", matches[4])
-
-				// Log the file download request
-				registryHandler.Logger().WithFields(logrus.Fields{
-					"file":    fileOrVersion,
-					"version": matches[2],
-					"os":      matches[3],
-					"arch":    matches[4],
-				}).Debug("Calling DownloadProvider")
-
-				// Call the download handler
-				registryHandler.DownloadProvider(c)
-				return
-			}
+		// GET /:registry/:namespace/:provider/:version/download?os=&arch= and .../download/:os/:arch redirect to the
+		// binary of a platform, the latest version resolves to the newest release
+		registry.GET("/:fileOrVersion/download", redirectDownload(registryHandler))
+		registry.GET("/:fileOrVersion/download/:os/:arch", redirectDownload(registryHandler))
+	}
 
-			// If we get here, it's an unsupported request
-			c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported request"})
-		})
+	// The same endpoints without the registry segment serve the providers of the default registry. gin requires
+	// the parameters of the routes sharing a path segment to share their name, so the names are shifted by
+	// useDefaultRegistry before anything reads them.
+	if config.DefaultRegistry != "" {
+		defaults := base.Group("/:registry/:namespace", append([]gin.HandlerFunc{useDefaultRegistry(config.DefaultRegistry)},
+			config.registryHandlers(middleware.GroupProviders, requireRead)...)...)
+		{
+			// GET /:namespace/:provider/index.json
+			defaults.GET("/index.json", registryHandler.GetProviderIndex)
+			// GET /:namespace/:provider/:fileOrVersion
+			defaults.GET("/:provider", serveProviderFile(registryHandler))
+			defaults.GET("/:provider/download", redirectDownload(registryHandler))
+			defaults.GET("/:provider/download/:os/:arch", redirectDownload(registryHandler))
+		}
 	}
+}
 
-	// Add 404 handler
-	router.NoRoute(func(c *gin.Context) {
-		c.JSON(404, gin.H{
-			"error": "Not Found",
-		})
-	})
+// useDefaultRegistry returns a middleware passing the parameters of the routes without the registry segment on
+// under their names in the full routes, with the default registry as registry: the registry parameter holds the
+// namespace, the namespace one the provider and the provider one the file or version.
+func useDefaultRegistry(registry string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		params := gin.Params{
+			{Key: "registry", Value: registry},
+			{Key: "namespace", Value: c.Param("registry")},
+			{Key: "provider", Value: c.Param("namespace")},
+		}
+		if file, ok := c.Params.Get("provider"); ok {
+			params = append(params, gin.Param{Key: "fileOrVersion", Value: file})
+		}
+		for _, param := range c.Params {
+			if param.Key == "os" || param.Key == "arch" {
+				params = append(params, param)
+			}
+		}
+		c.Params = params
+		c.Next()
+	}
+}
+
+// useRegistryAliases returns a middleware replacing an alias in the registry parameter with the registry it stands
+// for, so the requests for both hosts are served from the same cached artifacts
+func useRegistryAliases(aliases map[string]string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for i, param := range c.Params {
+			if param.Key != "registry" {
+				continue
+			}
+			if registry, ok := aliases[strings.ToLower(param.Value)]; ok {
+				c.Params[i].Value = registry
+			}
+		}
+		c.Next()
+	}
 }
 
 // Config holds the configuration for routes
 type Config struct {
 	URIPrefix string
-	Storage   storage.Storage
+	// DefaultRegistry is the registry host of the provider routes without the registry segment, e.g.
+	// /:namespace/:provider/index.json. Those routes are only registered when it is non-empty.
+	DefaultRegistry string
+	// RegistryAliases maps lowercase registry hosts to the registry they are served from, e.g.
+	// registry.opentofu.org to registry.terraform.io. The registry path segment is rewritten before the middlewares
+	// run, so the routes of an alias behave as the routes of its registry in every respect.
+	RegistryAliases map[string]string
+	Storage         storage.Storage
+	// ServiceDiscovery maps service identifiers (e.g. providers.v1) to their base paths.
+	// The /.well-known/terraform.json endpoint is only registered when it is non-nil.
+	ServiceDiscovery map[string]string
+	// ModulesURIPrefix is the base path of the module registry API. Module routes are
+	// only registered when it is non-empty.
+	ModulesURIPrefix string
+	// ModulesUpstream is the registry host module requests are forwarded to
+	ModulesUpstream string
+	// Transport sends the requests to upstream registries, http.DefaultTransport if nil
+	Transport http.RoundTripper
+	// Registry holds optional settings for the provider registry handler
+	Registry handler.RegistryOptions
+	// Auth authenticates callers and enforces scopes per route group.
+	// Authentication is disabled when it is nil, in which case the admin API isn't served.
+	// POST /auth/tokens is registered when it has a token issuer.
+	Auth *auth.Authenticator
+	// Provenance stores the origin records of cached artifacts, served under /admin/origins.
+	// Records aren't kept when it is nil.
+	Provenance *provenance.Store
+	// Pins protects files from deletion and is managed under /cache/pins. Nothing is pinned when it is nil.
+	Pins *pins.Set
+	// Transparency records the checksum of every cached provider binary and is served under /transparency.
+	// Checksums aren't recorded when it is nil.
+	Transparency *transparency.Log
+	// Limits adjusts the limits of the server at runtime under /admin/limits, which isn't served when it is nil
+	Limits *limits.Manager
+	// Snapshots backs up the metadata documents and the catalog under /admin/snapshots, which isn't served when it
+	// is nil
+	Snapshots *snapshot.Snapshotter
+	// Middlewares are the configurable middlewares of the route groups, by the group names of the middleware
+	// package. They run before the scope checks of the routes. SetupRoutes applies the * group to the whole
+	// router, additional caches only use the groups of their routes.
+	Middlewares map[string][]gin.HandlerFunc
+	// Keys lays the cached artifacts out in the storage, layout.Default if nil
+	Keys layout.Strategy
+	// BuildInfo is served by GET /version
+	BuildInfo BuildInfo
+	// Readiness checks the storage backends for GET /readyz, which is always ready if it is nil
+	Readiness *Readiness
+
+	registryHandler *handler.RegistryHandler
+}
+
+// handlers returns the middlewares of a route group followed by the handlers of a route
+func (c *Config) handlers(group string, handlers ...gin.HandlerFunc) []gin.HandlerFunc {
+	return append(slices.Clone(c.Middlewares[group]), handlers...)
+}
+
+// registryHandlers returns the handlers of a route group with a registry parameter, resolving the registry aliases
+// before the middlewares of the group
+func (c *Config) registryHandlers(group string, handlers ...gin.HandlerFunc) []gin.HandlerFunc {
+	if len(c.RegistryAliases) == 0 {
+		return c.handlers(group, handlers...)
+	}
+	return append([]gin.HandlerFunc{useRegistryAliases(c.RegistryAliases)}, c.handlers(group, handlers...)...)
+}
+
+// registryOptions returns the registry handler options, completed with the shared settings of the config
+func (c *Config) registryOptions() handler.RegistryOptions {
+	opts := c.Registry
+	if opts.Transport == nil {
+		opts.Transport = c.Transport
+	}
+	if opts.Provenance == nil {
+		opts.Provenance = c.Provenance
+	}
+	if opts.Transparency == nil {
+		opts.Transparency = c.Transparency
+	}
+	if opts.Keys == nil {
+		opts.Keys = c.Keys
+	}
+	if opts.Aliases == nil {
+		opts.Aliases = c.RegistryAliases
+	}
+	return opts
+}
+
+// cacheHandler returns a cache management handler for the storage, pins and layout of the config
+func (c *Config) cacheHandler() *handler.CacheHandler {
+	cacheHandler := handler.NewCacheHandler(c.Storage, logrus.StandardLogger())
+	if c.Pins != nil {
+		cacheHandler.UsePins(c.Pins)
+	}
+	if c.Keys != nil {
+		cacheHandler.UseKeys(c.Keys)
+	}
+	if c.Provenance != nil {
+		cacheHandler.UseProvenance(c.Provenance)
+	}
+	return cacheHandler
+}
+
+// RegistryHandler returns the provider registry handler serving the routes, creating it on first use.
+// It is shared with the background jobs caching provider binaries.
+func (c *Config) RegistryHandler() *handler.RegistryHandler {
+	if c.registryHandler == nil {
+		c.registryHandler = handler.NewRegistryHandlerWithOptions(logrus.StandardLogger(), c.Storage, c.registryOptions())
+	}
+	return c.registryHandler
 }
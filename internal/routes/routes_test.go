@@ -9,8 +9,12 @@ import (
 	"testing"
 
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+
+	"cachetf/internal/middleware"
+	"cachetf/internal/storage"
 )
 
 // MockStorage is a mock implementation of the storage.Storage interface
@@ -36,9 +40,12 @@ func (m *MockStorage) Get(ctx context.Context, key string) (io.ReadCloser, error
 	return &readCloser{strings.NewReader(args.String(0))}, args.Error(1)
 }
 
-func (m *MockStorage) List(ctx context.Context, prefix string) ([]string, error) {
-	args := m.Called(ctx, prefix)
-	return args.Get(0).([]string), args.Error(1)
+func (m *MockStorage) List(ctx context.Context, prefix string, opts storage.ListOptions) (*storage.ListResult, error) {
+	args := m.Called(ctx, prefix, opts)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*storage.ListResult), args.Error(1)
 }
 
 func (m *MockStorage) Put(ctx context.Context, key string, data io.Reader) error {
@@ -99,7 +106,7 @@ func TestSetupRoutes(t *testing.T) {
 			path:           "/v1/registry1",
 			expectedStatus: http.StatusOK,
 			setupMock: func() {
-				mockStorage.On("DeleteByPrefix", mock.Anything, "registry1").Return(1, nil)
+				mockStorage.On("DeleteByPrefix", mock.Anything, "providers/registry1").Return(1, nil)
 			},
 		},
 		// Skipping provider index test as it requires mocking HTTP requests
@@ -158,6 +165,45 @@ func TestSetupRoutes(t *testing.T) {
 	}
 }
 
+// TestSetupRoutes_ServiceDiscovery tests the /.well-known/terraform.json endpoint
+func TestSetupRoutes_ServiceDiscovery(t *testing.T) {
+	t.Run("enabled", func(t *testing.T) {
+		router := gin.New()
+		SetupRoutes(router, &Config{
+			URIPrefix: "/providers",
+			Storage:   new(MockStorage),
+			ServiceDiscovery: map[string]string{
+				"providers.v1": "/providers/",
+			},
+		})
+
+		req, err := http.NewRequest("GET", "/.well-known/terraform.json", nil)
+		assert.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"providers.v1": "/providers/"}`, w.Body.String())
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		router := gin.New()
+		SetupRoutes(router, &Config{
+			URIPrefix: "/providers",
+			Storage:   new(MockStorage),
+		})
+
+		req, err := http.NewRequest("GET", "/.well-known/terraform.json", nil)
+		assert.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
 // TestSetupRoutes_NoStorage tests that SetupRoutes logs a fatal error when storage is not configured
 func TestSetupRoutes_NoStorage(t *testing.T) {
 	// Skip this test since it would cause the test process to exit
@@ -225,6 +271,34 @@ func TestSetupRoutes_URIPrefix(t *testing.T) {
 	})
 }
 
+// TestSetupCacheRoutes tests that additional caches are served from their own storage
+func TestSetupCacheRoutes(t *testing.T) {
+	prodStorage := new(MockStorage)
+	devStorage := new(MockStorage)
+
+	router := gin.New()
+	SetupRoutes(router, &Config{URIPrefix: "/providers", Storage: prodStorage})
+	SetupCacheRoutes(router, &Config{URIPrefix: "/dev/providers", Storage: devStorage})
+
+	devStorage.On("DeleteByPrefix", mock.Anything, "providers/registry1").Return(1, nil)
+
+	req, err := http.NewRequest("DELETE", "/dev/providers/registry1", nil)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	devStorage.AssertExpectations(t)
+	prodStorage.AssertNotCalled(t, "DeleteByPrefix", mock.Anything, mock.Anything)
+
+	// The provider mirror is served under the prefix of the additional cache
+	req, err = http.NewRequest("GET", "/dev/providers/registry1/namespace1/provider1/invalid-file.txt", nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
 // TestMain provides setup and teardown for all tests
 func TestMain(m *testing.M) {
 	// Set Gin to test mode
@@ -233,3 +307,181 @@ func TestMain(m *testing.M) {
 	// Run tests
 	m.Run()
 }
+
+func TestSetupRoutes_Middlewares(t *testing.T) {
+	var groups []string
+	hook := func(group string) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			groups = append(groups, group)
+		}
+	}
+
+	router := gin.New()
+	config := &Config{
+		URIPrefix: "/providers",
+		Storage:   new(MockStorage),
+		Middlewares: map[string][]gin.HandlerFunc{
+			middleware.GroupAll:       {hook("*")},
+			middleware.GroupHealth:    {hook("health")},
+			middleware.GroupProviders: {hook("providers")},
+			middleware.GroupPrewarm:   {hook("prewarm")},
+		},
+	}
+	SetupRoutes(router, config)
+	SetupCacheRoutes(router, &Config{URIPrefix: "/dev/providers", Storage: new(MockStorage), Middlewares: config.Middlewares})
+
+	tests := []struct {
+		method string
+		path   string
+		want   []string
+	}{
+		{"GET", "/health", []string{"*", "health"}},
+		{"GET", "/version", []string{"*", "health"}},
+		{"GET", "/providers/registry1/namespace1/provider1/invalid-file.txt", []string{"*", "providers"}},
+		{"GET", "/dev/providers/registry1/namespace1/provider1/invalid-file.txt", []string{"*", "providers"}},
+		{"POST", "/prewarm/lockfile", []string{"*", "prewarm"}},
+		{"GET", "/unknown", []string{"*"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			groups = nil
+			req, err := http.NewRequest(tt.method, tt.path, strings.NewReader(""))
+			assert.NoError(t, err)
+			router.ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, tt.want, groups)
+		})
+	}
+}
+
+// upstreamFunc answers the upstream requests of the registry handler
+type upstreamFunc func(req *http.Request) *http.Response
+
+func (f upstreamFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req), nil
+}
+
+// TestSetupRoutes_DefaultRegistry tests that the provider routes without the registry segment serve the default registry
+func TestSetupRoutes_DefaultRegistry(t *testing.T) {
+	var requested []string
+	upstream := upstreamFunc(func(req *http.Request) *http.Response {
+		requested = append(requested, req.URL.String())
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(`{"versions":[{"version":"5.0.0","platforms":[{"os":"linux","arch":"amd64"}]}]}`)),
+			Request:    req,
+		}
+	})
+	newRouter := func(defaultRegistry string) *gin.Engine {
+		router := gin.New()
+		SetupRoutes(router, &Config{
+			URIPrefix:       "/providers",
+			DefaultRegistry: defaultRegistry,
+			Storage:         storage.NewLocalStorage(t.TempDir(), logrus.StandardLogger()),
+			Transport:       upstream,
+		})
+		return router
+	}
+	serve := func(router *gin.Engine, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	router := newRouter("registry.terraform.io")
+
+	w := serve(router, "/providers/hashicorp/aws/index.json")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"versions":{"5.0.0":{}}}`, w.Body.String())
+	assert.Equal(t, []string{"https://registry.terraform.io/v1/providers/hashicorp/aws/versions"}, requested)
+
+	w = serve(router, "/providers/hashicorp/aws/5.0.0.json")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"archives":{"linux_amd64":{"url":"terraform-provider-aws_5.0.0_linux_amd64.zip"}}}`, w.Body.String())
+
+	w = serve(router, "/providers/hashicorp/aws/5.0.0/download/linux/amd64")
+	assert.Equal(t, http.StatusFound, w.Code, w.Body.String())
+	assert.Equal(t, "/providers/hashicorp/aws/terraform-provider-aws_5.0.0_linux_amd64.zip", w.Header().Get("Location"))
+
+	w = serve(router, "/providers/hashicorp/aws/invalid-file.txt")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// The full paths are still served, for any registry
+	requested = nil
+	w = serve(router, "/providers/example.com/acme/tool/index.json")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, []string{"https://example.com/v1/providers/acme/tool/versions"}, requested)
+	w = serve(router, "/providers/registry.terraform.io/hashicorp/aws/5.0.0.json")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// Without a default registry, the short paths aren't routed
+	w = serve(newRouter(""), "/providers/hashicorp/aws/index.json")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+// TestSetupRoutes_RegistryAliases tests that the routes of an alias serve the artifacts of its registry
+func TestSetupRoutes_RegistryAliases(t *testing.T) {
+	var requested []string
+	upstream := upstreamFunc(func(req *http.Request) *http.Response {
+		requested = append(requested, req.URL.String())
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(`{"versions":[{"version":"5.0.0","platforms":[{"os":"linux","arch":"amd64"}]}]}`)),
+			Request:    req,
+		}
+	})
+	store := storage.NewLocalStorage(t.TempDir(), logrus.StandardLogger())
+	router := gin.New()
+	SetupRoutes(router, &Config{
+		URIPrefix:       "/providers",
+		DefaultRegistry: "tf-mirror.internal",
+		RegistryAliases: map[string]string{
+			"registry.opentofu.org": "registry.terraform.io",
+			"tf-mirror.internal":    "registry.terraform.io",
+		},
+		Storage:   store,
+		Transport: upstream,
+	})
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	// Aliases are requested from their registry, hosts are case-insensitive
+	for _, path := range []string{
+		"/providers/registry.opentofu.org/hashicorp/aws/index.json",
+		"/providers/Registry.OpenTofu.org/hashicorp/aws/index.json",
+		"/providers/tf-mirror.internal/hashicorp/aws/index.json",
+		// The default registry may be an alias
+		"/providers/hashicorp/aws/index.json",
+	} {
+		requested = nil
+		w := serve(http.MethodGet, path)
+		assert.Equal(t, http.StatusOK, w.Code, path)
+		assert.JSONEq(t, `{"versions":{"5.0.0":{}}}`, w.Body.String(), path)
+		assert.Equal(t, []string{"https://registry.terraform.io/v1/providers/hashicorp/aws/versions"}, requested, path)
+	}
+
+	// Downloads are redirected under the requested host
+	w := serve(http.MethodGet, "/providers/registry.opentofu.org/hashicorp/aws/5.0.0/download/linux/amd64")
+	assert.Equal(t, http.StatusFound, w.Code, w.Body.String())
+	assert.Equal(t, "/providers/registry.opentofu.org/hashicorp/aws/terraform-provider-aws_5.0.0_linux_amd64.zip", w.Header().Get("Location"))
+
+	// Purging an alias purges the artifacts of its registry
+	ctx := t.Context()
+	key := "providers/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip"
+	assert.NoError(t, store.Put(ctx, key, strings.NewReader("zip")))
+	w = serve(http.MethodDelete, "/providers/registry.opentofu.org/hashicorp/aws")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	exists, err := store.Exists(ctx, key)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	// Other registries are untouched
+	requested = nil
+	w = serve(http.MethodGet, "/providers/example.com/acme/tool/index.json")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, []string{"https://example.com/v1/providers/acme/tool/versions"}, requested)
+}
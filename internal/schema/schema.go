@@ -0,0 +1,124 @@
+// Package schema versions the layout of the state persisted by the server, the metadata documents and the
+// catalog, and upgrades it on startup. Features that change the layout add a migration, so instances of an earlier
+// version refuse to start on state they don't understand instead of corrupting it.
+package schema
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrTooNew is returned when the state was migrated by a later version of the server
+var ErrTooNew = errors.New("schema version is newer than this server supports")
+
+// Migration upgrades the state to Version from the previous version
+type Migration struct {
+	// Version is the schema version after the migration, the migrations of a state are numbered from 1
+	Version int
+	// Description says what the migration changes
+	Description string
+	// Up changes the state, nil if the version only records a layout. It's run again if it's interrupted before the
+	// version is recorded, and concurrently by instances sharing the state, so it must be idempotent.
+	Up func(ctx context.Context) error
+}
+
+// Versioned is state recording the version of its schema
+type Versioned interface {
+	// SchemaVersion returns the recorded version, 0 if none was recorded yet
+	SchemaVersion(ctx context.Context) (int, error)
+	// SetSchemaVersion records the version
+	SetSchemaVersion(ctx context.Context, version int) error
+}
+
+// Target is a state and the migrations of its schema
+type Target struct {
+	Name       string
+	State      Versioned
+	Migrations []Migration
+}
+
+// Latest returns the version of the last migration
+func (t *Target) Latest() int {
+	if len(t.Migrations) == 0 {
+		return 0
+	}
+	return t.Migrations[len(t.Migrations)-1].Version
+}
+
+// validate checks that the migrations are numbered from 1 without gaps
+func (t *Target) validate() error {
+	for i, migration := range t.Migrations {
+		if migration.Version != i+1 {
+			return fmt.Errorf("migration %d of %s has version %d", i+1, t.Name, migration.Version)
+		}
+	}
+	return nil
+}
+
+// Status is the schema version of a state and the migrations it's missing
+type Status struct {
+	Name    string      `json:"name"`
+	Current int         `json:"current"`
+	Latest  int         `json:"latest"`
+	Pending []Migration `json:"-"`
+}
+
+// UpToDate returns true if no migration is pending
+func (s *Status) UpToDate() bool {
+	return len(s.Pending) == 0
+}
+
+// Check returns the schema version of the target and its pending migrations, without applying them. It returns
+// ErrTooNew if the state was migrated by a later version.
+func Check(ctx context.Context, target Target) (Status, error) {
+	if err := target.validate(); err != nil {
+		return Status{}, err
+	}
+	current, err := target.State.SchemaVersion(ctx)
+	if err != nil {
+		return Status{}, fmt.Errorf("failed to read the schema version of %s: %w", target.Name, err)
+	}
+
+	status := Status{Name: target.Name, Current: current, Latest: target.Latest()}
+	if current > status.Latest {
+		return status, fmt.Errorf("%w: %s is at version %d, the latest known is %d", ErrTooNew, target.Name, current, status.Latest)
+	}
+	status.Pending = target.Migrations[current:]
+	return status, nil
+}
+
+// Migrate applies the pending migrations of the target in order, recording the version after each one
+func Migrate(ctx context.Context, target Target, logger *logrus.Logger) (Status, error) {
+	status, err := Check(ctx, target)
+	if err != nil {
+		return status, err
+	}
+
+	for len(status.Pending) > 0 {
+		migration := status.Pending[0]
+		fields := logrus.Fields{
+			"schema":      target.Name,
+			"version":     migration.Version,
+			"description": migration.Description,
+		}
+		start := time.Now()
+		if migration.Up != nil {
+			if err := migration.Up(ctx); err != nil {
+				return status, fmt.Errorf("migration %d of %s failed: %w", migration.Version, target.Name, err)
+			}
+		}
+		if err := target.State.SetSchemaVersion(ctx, migration.Version); err != nil {
+			return status, fmt.Errorf("failed to record the schema version of %s: %w", target.Name, err)
+		}
+		status.Current = migration.Version
+		status.Pending = status.Pending[1:]
+
+		fields["duration"] = time.Since(start)
+		logger.WithFields(fields).Info("Migrated schema")
+	}
+	return status, nil
+}
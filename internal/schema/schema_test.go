@@ -0,0 +1,99 @@
+package schema
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryState records its version in memory
+type memoryState struct {
+	version int
+}
+
+func (s *memoryState) SchemaVersion(context.Context) (int, error) {
+	return s.version, nil
+}
+
+func (s *memoryState) SetSchemaVersion(_ context.Context, version int) error {
+	s.version = version
+	return nil
+}
+
+func newTestLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := t.Context()
+	state := &memoryState{}
+	var applied []int
+	up := func(version int) func(context.Context) error {
+		return func(context.Context) error {
+			applied = append(applied, version)
+			return nil
+		}
+	}
+	target := Target{Name: "test", State: state, Migrations: []Migration{
+		{Version: 1, Description: "layout"},
+		{Version: 2, Description: "second", Up: up(2)},
+		{Version: 3, Description: "third", Up: up(3)},
+	}}
+
+	status, err := Check(ctx, target)
+	require.NoError(t, err)
+	assert.Equal(t, 0, status.Current)
+	assert.Equal(t, 3, status.Latest)
+	assert.Len(t, status.Pending, 3)
+	assert.False(t, status.UpToDate())
+	assert.Equal(t, 0, state.version, "checking doesn't migrate")
+
+	status, err = Migrate(ctx, target, newTestLogger())
+	require.NoError(t, err)
+	assert.True(t, status.UpToDate())
+	assert.Equal(t, 3, status.Current)
+	assert.Equal(t, 3, state.version)
+	assert.Equal(t, []int{2, 3}, applied)
+
+	// Migrated state isn't migrated again
+	_, err = Migrate(ctx, target, newTestLogger())
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 3}, applied)
+}
+
+func TestMigrate_Failure(t *testing.T) {
+	ctx := t.Context()
+	state := &memoryState{version: 1}
+	target := Target{Name: "test", State: state, Migrations: []Migration{
+		{Version: 1},
+		{Version: 2, Up: func(context.Context) error { return nil }},
+		{Version: 3, Up: func(context.Context) error { return errors.New("boom") }},
+	}}
+
+	// The migrations before the failure are recorded
+	status, err := Migrate(ctx, target, newTestLogger())
+	assert.ErrorContains(t, err, "migration 3 of test failed: boom")
+	assert.Equal(t, 2, status.Current)
+	assert.Equal(t, 2, state.version)
+}
+
+func TestCheck_TooNew(t *testing.T) {
+	target := Target{Name: "test", State: &memoryState{version: 2}, Migrations: []Migration{{Version: 1}}}
+	_, err := Check(t.Context(), target)
+	assert.ErrorIs(t, err, ErrTooNew)
+	_, err = Migrate(t.Context(), target, newTestLogger())
+	assert.ErrorIs(t, err, ErrTooNew)
+}
+
+func TestCheck_InvalidMigrations(t *testing.T) {
+	target := Target{Name: "test", State: &memoryState{}, Migrations: []Migration{{Version: 1}, {Version: 3}}}
+	_, err := Check(t.Context(), target)
+	assert.ErrorContains(t, err, "migration 2 of test has version 3")
+}
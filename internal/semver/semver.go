@@ -0,0 +1,184 @@
+// Package semver parses provider versions and Terraform version constraints such as "~> 5.0, != 5.1.0"
+package semver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a semantic version
+type Version struct {
+	Segments   [3]int
+	Prerelease string
+	// specified is the number of segments written, constraints like "~> 5.0" depend on it
+	specified int
+	original  string
+}
+
+// Parse parses a version such as 5.31.0 or 1.0.0-beta1, an optional v prefix and build metadata are ignored.
+// Missing minor and patch segments are zero.
+func Parse(s string) (Version, error) {
+	v := Version{original: s}
+	rest := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	rest, _, _ = strings.Cut(rest, "+")
+	rest, v.Prerelease, _ = strings.Cut(rest, "-")
+
+	parts := strings.Split(rest, ".")
+	if len(parts) > 3 || rest == "" {
+		return Version{}, fmt.Errorf("invalid version %q", s)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		// Leading zeros and signs aren't allowed
+		if err != nil || part != strconv.Itoa(n) {
+			return Version{}, fmt.Errorf("invalid version %q", s)
+		}
+		v.Segments[i] = n
+	}
+	v.specified = len(parts)
+	return v, nil
+}
+
+// String returns the version as it was parsed
+func (v Version) String() string {
+	return v.original
+}
+
+// Compare returns -1, 0 or 1 if v is lower than, equal to or greater than o.
+// Pre-releases are lower than the release they precede.
+func (v Version) Compare(o Version) int {
+	for i := range v.Segments {
+		if v.Segments[i] != o.Segments[i] {
+			return compareInt(v.Segments[i], o.Segments[i])
+		}
+	}
+	switch {
+	case v.Prerelease == o.Prerelease:
+		return 0
+	case v.Prerelease == "":
+		return 1
+	case o.Prerelease == "":
+		return -1
+	}
+	return comparePrerelease(v.Prerelease, o.Prerelease)
+}
+
+// comparePrerelease compares dot-separated pre-release identifiers, numeric ones sort before alphanumeric ones
+func comparePrerelease(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if an != bn {
+				return compareInt(an, bn)
+			}
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(as[i], bs[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return compareInt(len(as), len(bs))
+}
+
+func compareInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// constraint is a single operator and version
+type constraint struct {
+	op      string
+	version Version
+}
+
+// Constraints is a set of version constraints, all of which must be met
+type Constraints []constraint
+
+// ParseConstraints parses comma-separated constraints using the =, !=, >, >=, <, <= and ~> operators.
+// A version without operator means =. An empty string allows every release.
+func ParseConstraints(s string) (Constraints, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	var constraints Constraints
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		op := "="
+		for _, candidate := range []string{"~>", ">=", "<=", "!=", ">", "<", "="} {
+			if rest, ok := strings.CutPrefix(part, candidate); ok {
+				op, part = candidate, strings.TrimSpace(rest)
+				break
+			}
+		}
+		v, err := Parse(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid constraint %q: %w", s, err)
+		}
+		constraints = append(constraints, constraint{op: op, version: v})
+	}
+	return constraints, nil
+}
+
+// Check returns true if the version meets all constraints.
+// Like Terraform, pre-releases are only selected by an exact = constraint.
+func (cs Constraints) Check(v Version) bool {
+	if v.Prerelease != "" {
+		exact := false
+		for _, c := range cs {
+			if c.op == "=" && c.version.Compare(v) == 0 {
+				exact = true
+			}
+		}
+		if !exact {
+			return false
+		}
+	}
+
+	for _, c := range cs {
+		if !c.check(v) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c constraint) check(v Version) bool {
+	cmp := v.Compare(c.version)
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case "~>":
+		// Only the rightmost specified segment may increase
+		for i := 0; i < c.version.specified-1; i++ {
+			if v.Segments[i] != c.version.Segments[i] {
+				return false
+			}
+		}
+		return cmp >= 0
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package semver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	v, err := Parse("v5.31.0-beta.1+build")
+	require.NoError(t, err)
+	assert.Equal(t, [3]int{5, 31, 0}, v.Segments)
+	assert.Equal(t, "beta.1", v.Prerelease)
+	assert.Equal(t, "v5.31.0-beta.1+build", v.String())
+
+	v, err = Parse("5")
+	require.NoError(t, err)
+	assert.Equal(t, [3]int{5, 0, 0}, v.Segments)
+
+	for _, s := range []string{"", "latest", "1.2.3.4", "1..2", "01.2.3", "1.-2.3", "+1.2.3"} {
+		_, err := Parse(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	ordered := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.2.0", "2.0.0"}
+	for i := 0; i < len(ordered)-1; i++ {
+		a, err := Parse(ordered[i])
+		require.NoError(t, err)
+		b, err := Parse(ordered[i+1])
+		require.NoError(t, err)
+		assert.Equal(t, -1, a.Compare(b), "%s < %s", a, b)
+		assert.Equal(t, 1, b.Compare(a), "%s > %s", b, a)
+		assert.Equal(t, 0, a.Compare(a))
+	}
+}
+
+func TestConstraints_Check(t *testing.T) {
+	tests := []struct {
+		constraints string
+		version     string
+		expected    bool
+	}{
+		{"", "1.0.0", true},
+		{"", "1.0.0-beta", false},
+		{"5.31.0", "5.31.0", true},
+		{"= 5.31.0", "5.31.1", false},
+		{"!= 5.31.0", "5.31.1", true},
+		{">= 5.0, < 6.0", "5.99.0", true},
+		{">= 5.0, < 6.0", "6.0.0", false},
+		{"> 5.0.0", "5.0.0", false},
+		{"<= 5.0.0", "5.0.0", true},
+		{"~> 5.0", "5.31.0", true},
+		{"~> 5.0", "6.0.0", false},
+		{"~> 5.0", "4.67.0", false},
+		{"~> 5.31.0", "5.31.9", true},
+		{"~> 5.31.0", "5.32.0", false},
+		{"~> 5", "7.0.0", true},
+		{"~> 5.0, != 5.1.0", "5.1.0", false},
+		{">= 1.0.0", "2.0.0-beta", false},
+		{"2.0.0-beta", "2.0.0-beta", true},
+	}
+
+	for _, tt := range tests {
+		constraints, err := ParseConstraints(tt.constraints)
+		require.NoError(t, err, tt.constraints)
+		v, err := Parse(tt.version)
+		require.NoError(t, err)
+		assert.Equal(t, tt.expected, constraints.Check(v), "%q %s", tt.constraints, tt.version)
+	}
+}
+
+func TestParseConstraints_Invalid(t *testing.T) {
+	for _, s := range []string{">= latest", "~>", "5.0,", "=> 5.0"} {
+		_, err := ParseConstraints(s)
+		assert.Error(t, err, s)
+	}
+}
@@ -0,0 +1,504 @@
+// Package snapshot backs up the state of the server that isn't a cached artifact, the metadata documents and the
+// catalog, to the blob store and restores it. Losing them would lose the pins, managed keys, adjusted limits,
+// origin records and access times.
+//
+// A snapshot is a tar.gz object under metadata/snapshots/: a manifest entry, the metadata documents under their
+// storage keys and a copy of the catalog database. The manifest holds the SHA256 of every other entry, a snapshot
+// is only restored once all of them were read and verified. Snapshots are taken while the server runs and restored
+// while the servers sharing the storage are stopped.
+package snapshot
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/catalog"
+	"cachetf/internal/metadata"
+	"cachetf/internal/storage"
+)
+
+// KeyPrefix is the storage prefix snapshots are kept under, with the metadata documents so they're never listed
+// as cached files or evicted
+const KeyPrefix = metadata.KeyPrefix + "snapshots/"
+
+// FormatVersion is the version of the snapshot format written by Create
+const FormatVersion = 1
+
+const (
+	// manifestName is the name of the manifest entry, the first entry of a snapshot
+	manifestName = "manifest.json"
+	// catalogName is the name of the entry holding the catalog database
+	catalogName = "catalog.db"
+	// nameLayout formats the creation time of a snapshot into its name
+	nameLayout = "20060102T150405.000Z"
+)
+
+// excludedPrefixes are the metadata keys that aren't part of the snapshots: the snapshots themselves and the
+// canaries of the storage self-test
+var excludedPrefixes = []string{KeyPrefix, metadata.KeyPrefix + "selftest/"}
+
+// latestSchemas are the latest schema versions this version understands, snapshots of newer schemas are refused
+var latestSchemas = map[string]int{"metadata": metadata.LatestSchemaVersion, "catalog": catalog.LatestSchemaVersion}
+
+// namePattern matches the names of snapshots
+var namePattern = regexp.MustCompile(`^\d{8}T\d{6}\.\d{3}Z$`)
+
+var (
+	// ErrNotFound is returned when restoring a snapshot that doesn't exist
+	ErrNotFound = errors.New("snapshot not found")
+	// ErrInvalid is returned when restoring a snapshot that is truncated, corrupt or of a newer version
+	ErrInvalid = errors.New("invalid snapshot")
+)
+
+// Manifest describes a snapshot
+type Manifest struct {
+	// Version is the snapshot format version
+	Version int    `json:"version"`
+	Name    string `json:"name"`
+	// CreatedAt is when the snapshot was taken
+	CreatedAt time.Time `json:"createdAt"`
+	CreatedBy string    `json:"createdBy,omitempty"`
+	// Schemas are the schema versions of the metadata documents and the catalog, see schema.Versioned
+	Schemas map[string]int `json:"schemas"`
+	// Catalog is true if the snapshot holds a copy of the catalog
+	Catalog bool `json:"catalog"`
+	// Checksums are the hex-encoded SHA256 of the other entries, by entry name
+	Checksums map[string]string `json:"checksums"`
+}
+
+// Info describes a stored snapshot
+type Info struct {
+	Name      string    `json:"name"`
+	Key       string    `json:"key"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Snapshotter takes and restores snapshots
+type Snapshotter struct {
+	// storage is the backend, caches in front of it would hide the documents written by other instances
+	storage storage.Storage
+	// catalog is nil if the catalog isn't enabled
+	catalog *catalog.Catalog
+	logger  *logrus.Logger
+	// now is replaceable for tests
+	now func() time.Time
+}
+
+// NewSnapshotter creates a Snapshotter of the metadata documents in storage and of catalog, nil if it isn't enabled
+func NewSnapshotter(storage storage.Storage, catalog *catalog.Catalog, logger *logrus.Logger) *Snapshotter {
+	return &Snapshotter{
+		storage: storage,
+		catalog: catalog,
+		logger:  logger,
+		now:     time.Now,
+	}
+}
+
+// key returns the storage key of the snapshot name
+func key(name string) string {
+	return KeyPrefix + name + ".tar.gz"
+}
+
+// excluded returns true if the metadata key isn't part of the snapshots
+func excluded(key string) bool {
+	for _, prefix := range excludedPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Create takes a snapshot of the metadata documents and the catalog and writes it to the storage
+func (s *Snapshotter) Create(ctx context.Context, principal string) (*Manifest, error) {
+	createdAt := s.now().UTC()
+	manifest := &Manifest{
+		Version:   FormatVersion,
+		Name:      createdAt.Format(nameLayout),
+		CreatedAt: createdAt,
+		CreatedBy: principal,
+		Schemas:   map[string]int{},
+		Catalog:   s.catalog != nil,
+		Checksums: map[string]string{},
+	}
+
+	meta := metadata.NewStore(s.storage, s.logger)
+	var err error
+	if manifest.Schemas["metadata"], err = meta.SchemaVersion(ctx); err != nil {
+		return nil, fmt.Errorf("failed to read the metadata schema version: %w", err)
+	}
+
+	// The manifest is the first entry and holds the checksums of the others, the documents are read and the catalog
+	// copied to a file before anything is written
+	var catalogCopy *os.File
+	if s.catalog != nil {
+		if manifest.Schemas["catalog"], err = s.catalog.SchemaVersion(ctx); err != nil {
+			return nil, fmt.Errorf("failed to read the catalog schema version: %w", err)
+		}
+		catalogCopy, err = os.CreateTemp("", "cachetf-catalog-*")
+		if err != nil {
+			return nil, fmt.Errorf("failed to copy catalog: %w", err)
+		}
+		defer os.Remove(catalogCopy.Name())
+		defer catalogCopy.Close()
+		hash := sha256.New()
+		if _, err := s.catalog.WriteTo(io.MultiWriter(catalogCopy, hash)); err != nil {
+			return nil, fmt.Errorf("failed to copy catalog: %w", err)
+		}
+		manifest.Checksums[catalogName] = hex.EncodeToString(hash.Sum(nil))
+	}
+
+	documents, err := s.readDocuments(ctx, meta)
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range documents {
+		manifest.Checksums[doc.key] = checksum(doc.data)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(write(pw, manifest, documents, catalogCopy))
+	}()
+	if err := s.storage.Put(ctx, key(manifest.Name), pr); err != nil {
+		pr.CloseWithError(err)
+		return nil, fmt.Errorf("failed to write snapshot %s: %w", manifest.Name, err)
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"snapshot":  manifest.Name,
+		"documents": len(documents),
+		"catalog":   manifest.Catalog,
+		"createdBy": principal,
+	}).Info("Took a snapshot of the metadata")
+	return manifest, nil
+}
+
+// documentEntry is a metadata document of a snapshot
+type documentEntry struct {
+	// key is the storage key of the document, its entry name
+	key  string
+	data []byte
+}
+
+// readDocuments reads the metadata documents that are part of the snapshots. The documents of interrupted writes
+// are read from their pending copy, like the metadata store does.
+func (s *Snapshotter) readDocuments(ctx context.Context, meta *metadata.Store) ([]documentEntry, error) {
+	names, err := meta.List(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list metadata: %w", err)
+	}
+
+	var documents []documentEntry
+	for _, name := range names {
+		if excluded(metadata.KeyPrefix + name) {
+			continue
+		}
+		var data json.RawMessage
+		if err := meta.Load(ctx, name, &data); errors.Is(err, metadata.ErrNotFound) {
+			// Deleted since it was listed
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		documents = append(documents, documentEntry{key: metadata.KeyPrefix + name, data: data})
+	}
+	return documents, nil
+}
+
+// checksum returns the hex-encoded SHA256 of data
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// write writes the entries of a snapshot to w
+func write(w io.Writer, manifest *Manifest, documents []documentEntry, catalogCopy *os.File) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeEntry(tw, manifestName, int64(len(data)), manifest.CreatedAt, bytes.NewReader(data)); err != nil {
+		return err
+	}
+
+	for _, doc := range documents {
+		if err := writeEntry(tw, doc.key, int64(len(doc.data)), manifest.CreatedAt, bytes.NewReader(doc.data)); err != nil {
+			return err
+		}
+	}
+
+	if catalogCopy != nil {
+		info, err := catalogCopy.Stat()
+		if err != nil {
+			return err
+		}
+		if _, err := catalogCopy.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := writeEntry(tw, catalogName, info.Size(), manifest.CreatedAt, catalogCopy); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// writeEntry writes a file entry to tw
+func writeEntry(tw *tar.Writer, name string, size int64, modified time.Time, r io.Reader) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    size,
+		ModTime: modified,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if _, err := io.Copy(tw, r); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// List returns the stored snapshots, the latest first
+func (s *Snapshotter) List(ctx context.Context) ([]Info, error) {
+	var snapshots []Info
+	err := storage.Walk(ctx, s.storage, KeyPrefix, func(obj storage.ObjectInfo) error {
+		name, ok := strings.CutSuffix(strings.TrimPrefix(obj.Key, KeyPrefix), ".tar.gz")
+		if !ok || !namePattern.MatchString(name) {
+			return nil
+		}
+		createdAt, _ := time.Parse(nameLayout, name)
+		snapshots = append(snapshots, Info{Name: name, Key: obj.Key, Size: obj.Size, CreatedAt: createdAt})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Name > snapshots[j].Name })
+	return snapshots, nil
+}
+
+// RestoreOptions tune Restore
+type RestoreOptions struct {
+	// CatalogPath is the file the catalog is restored to, the catalog isn't restored if empty
+	CatalogPath string
+	// Check is called with the manifest before anything is restored, snapshots of newer formats or schemas than
+	// this version understands are refused anyway
+	Check func(*Manifest) error
+}
+
+// Restore replaces the metadata documents with the ones of the snapshot name, deleting the documents it doesn't
+// hold, and the catalog with its copy. The whole snapshot is read and verified first, a truncated or corrupt
+// snapshot changes nothing. The documents are then replaced one by one through the metadata store, so a crash
+// leaves each of them either at its previous or its restored version. The servers sharing the storage must be
+// stopped, they'd keep the state they loaded and overwrite the restored documents.
+func (s *Snapshotter) Restore(ctx context.Context, name string, opts RestoreOptions) (*Manifest, error) {
+	if !namePattern.MatchString(name) {
+		return nil, fmt.Errorf("%w: invalid name %q", ErrNotFound, name)
+	}
+	r, err := s.storage.Get(ctx, key(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot %s: %w", name, err)
+	}
+	defer r.Close()
+
+	staged, err := s.read(r, opts)
+	if staged != nil && staged.catalog != nil {
+		defer os.Remove(staged.catalog.Name())
+		defer staged.catalog.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot %s: %w", name, err)
+	}
+	manifest := staged.manifest
+
+	if staged.catalog != nil {
+		if _, err := staged.catalog.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if err := catalog.Restore(opts.CatalogPath, staged.catalog); err != nil {
+			return nil, err
+		}
+	} else if manifest.Catalog {
+		s.logger.Warn("The catalog isn't enabled, its copy in the snapshot isn't restored")
+	}
+
+	meta := metadata.NewStore(s.storage, s.logger)
+	restored := make(map[string]bool, len(staged.documents))
+	for _, doc := range staged.documents {
+		name := strings.TrimPrefix(doc.key, metadata.KeyPrefix)
+		if err := meta.Save(ctx, name, json.RawMessage(doc.data)); err != nil {
+			return nil, fmt.Errorf("failed to restore %s: %w", doc.key, err)
+		}
+		restored[name] = true
+	}
+
+	// Documents written since the snapshot was taken are deleted once the snapshot is restored completely
+	names, err := meta.List(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list metadata: %w", err)
+	}
+	deleted := 0
+	for _, name := range names {
+		if restored[name] || excluded(metadata.KeyPrefix+name) {
+			continue
+		}
+		if err := meta.Delete(ctx, name); err != nil {
+			return nil, err
+		}
+		deleted++
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"snapshot":  name,
+		"documents": len(restored),
+		"deleted":   deleted,
+		"catalog":   staged.catalog != nil,
+	}).Info("Restored a snapshot of the metadata")
+	return manifest, nil
+}
+
+// stagedSnapshot holds the verified entries of a snapshot before they're restored
+type stagedSnapshot struct {
+	manifest  *Manifest
+	documents []documentEntry
+	// catalog is a temporary copy of the catalog, nil if it isn't restored
+	catalog *os.File
+}
+
+// read reads and verifies every entry of a snapshot. The staged catalog copy is returned with the error too, so
+// the caller removes it.
+func (s *Snapshotter) read(r io.Reader, opts RestoreOptions) (*stagedSnapshot, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	tr := tar.NewReader(gz)
+
+	header, err := tr.Next()
+	if err != nil || header.Name != manifestName {
+		return nil, fmt.Errorf("%w: the first entry isn't the manifest", ErrInvalid)
+	}
+	var manifest Manifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	if err := validate(&manifest); err != nil {
+		return nil, err
+	}
+	if opts.Check != nil {
+		if err := opts.Check(&manifest); err != nil {
+			return nil, err
+		}
+	}
+
+	staged := &stagedSnapshot{manifest: &manifest}
+	seen := make(map[string]bool, len(manifest.Checksums))
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return staged, fmt.Errorf("%w: %w", ErrInvalid, err)
+		}
+
+		expected, ok := manifest.Checksums[header.Name]
+		if !ok || seen[header.Name] {
+			return staged, fmt.Errorf("%w: unexpected entry %q", ErrInvalid, header.Name)
+		}
+		seen[header.Name] = true
+
+		hash := sha256.New()
+		switch {
+		case header.Name == catalogName && opts.CatalogPath != "":
+			staged.catalog, err = os.CreateTemp("", "cachetf-catalog-*")
+			if err != nil {
+				return staged, fmt.Errorf("failed to stage catalog: %w", err)
+			}
+			if _, err := io.Copy(io.MultiWriter(staged.catalog, hash), tr); err != nil {
+				return staged, fmt.Errorf("%w: %s: %w", ErrInvalid, header.Name, err)
+			}
+		case header.Name == catalogName:
+			if _, err := io.Copy(hash, tr); err != nil {
+				return staged, fmt.Errorf("%w: %s: %w", ErrInvalid, header.Name, err)
+			}
+		case strings.HasPrefix(header.Name, metadata.KeyPrefix) && !excluded(header.Name):
+			data, err := io.ReadAll(io.TeeReader(tr, hash))
+			if err != nil {
+				return staged, fmt.Errorf("%w: %s: %w", ErrInvalid, header.Name, err)
+			}
+			if !json.Valid(data) {
+				return staged, fmt.Errorf("%w: %s isn't a JSON document", ErrInvalid, header.Name)
+			}
+			staged.documents = append(staged.documents, documentEntry{key: header.Name, data: data})
+		default:
+			return staged, fmt.Errorf("%w: unexpected entry %q", ErrInvalid, header.Name)
+		}
+		if actual := hex.EncodeToString(hash.Sum(nil)); actual != expected {
+			return staged, fmt.Errorf("%w: checksum mismatch of %s", ErrInvalid, header.Name)
+		}
+	}
+
+	// The rest of the stream holds the gzip checksum, verified once it's read
+	if _, err := io.Copy(io.Discard, gz); err != nil {
+		return staged, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	for entry := range manifest.Checksums {
+		if !seen[entry] {
+			return staged, fmt.Errorf("%w: entry %q is missing", ErrInvalid, entry)
+		}
+	}
+	if manifest.Catalog != seen[catalogName] {
+		return staged, fmt.Errorf("%w: the catalog entry doesn't match the manifest", ErrInvalid)
+	}
+	return staged, nil
+}
+
+// validate checks that the manifest is of a format and schemas this version understands
+func validate(manifest *Manifest) error {
+	if manifest.Version < 1 || manifest.Version > FormatVersion {
+		return fmt.Errorf("%w: format version %d, the latest known is %d", ErrInvalid, manifest.Version, FormatVersion)
+	}
+	if manifest.Checksums == nil {
+		return fmt.Errorf("%w: the manifest has no checksums", ErrInvalid)
+	}
+	if _, ok := manifest.Schemas["metadata"]; !ok {
+		return fmt.Errorf("%w: the manifest has no metadata schema version", ErrInvalid)
+	}
+	for name, version := range manifest.Schemas {
+		latest, ok := latestSchemas[name]
+		if !ok {
+			return fmt.Errorf("%w: unknown schema %q", ErrInvalid, name)
+		}
+		if version > latest {
+			return fmt.Errorf("%w: the %s schema is at version %d, the latest known is %d", ErrInvalid, name, version, latest)
+		}
+	}
+	return nil
+}
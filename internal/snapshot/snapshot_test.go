@@ -0,0 +1,243 @@
+package snapshot
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/catalog"
+	"cachetf/internal/metadata"
+	"cachetf/internal/storage"
+)
+
+type document struct {
+	Value string `json:"value"`
+}
+
+func newTestLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func TestSnapshotter_CreateAndRestore(t *testing.T) {
+	ctx := t.Context()
+	logger := newTestLogger()
+	store := storage.NewLocalStorage(t.TempDir(), logger)
+	meta := metadata.NewStore(store, logger)
+	catalogPath := filepath.Join(t.TempDir(), "catalog.db")
+	cat, _, err := catalog.Open(catalogPath, logger)
+	require.NoError(t, err)
+
+	require.NoError(t, meta.Save(ctx, "pins.json", document{Value: "pinned"}))
+	require.NoError(t, meta.Save(ctx, "auth/keys.json", document{Value: "keys"}))
+	require.NoError(t, meta.SetSchemaVersion(ctx, 1))
+	cat.Put(catalog.Entry{Key: "providers/a.zip", Size: 1})
+
+	snapshotter := NewSnapshotter(store, cat, logger)
+	snapshotter.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 6e6, time.UTC) }
+	manifest, err := snapshotter.Create(ctx, "oncall")
+	require.NoError(t, err)
+	assert.Equal(t, "20240102T030405.006Z", manifest.Name)
+	assert.Equal(t, "oncall", manifest.CreatedBy)
+	assert.Equal(t, map[string]int{"metadata": 1, "catalog": 0}, manifest.Schemas)
+	assert.True(t, manifest.Catalog)
+
+	snapshots, err := snapshotter.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, snapshots, 1)
+	assert.Equal(t, "20240102T030405.006Z", snapshots[0].Name)
+	assert.Equal(t, KeyPrefix+"20240102T030405.006Z.tar.gz", snapshots[0].Key)
+	assert.Positive(t, snapshots[0].Size)
+
+	// The state changes after the snapshot
+	require.NoError(t, meta.Save(ctx, "pins.json", document{Value: "changed"}))
+	require.NoError(t, meta.Delete(ctx, "auth/keys.json"))
+	require.NoError(t, meta.Save(ctx, "limits.json", document{Value: "limits"}))
+	cat.Delete("providers/a.zip")
+	require.NoError(t, cat.Close())
+
+	manifest, err = snapshotter.Restore(ctx, "20240102T030405.006Z", RestoreOptions{CatalogPath: catalogPath})
+	require.NoError(t, err)
+	assert.Equal(t, "oncall", manifest.CreatedBy)
+
+	var doc document
+	require.NoError(t, meta.Load(ctx, "pins.json", &doc))
+	assert.Equal(t, "pinned", doc.Value)
+	require.NoError(t, meta.Load(ctx, "auth/keys.json", &doc))
+	assert.Equal(t, "keys", doc.Value)
+	assert.ErrorIs(t, meta.Load(ctx, "limits.json", &doc), metadata.ErrNotFound)
+
+	// Snapshots are kept
+	snapshots, err = snapshotter.List(ctx)
+	require.NoError(t, err)
+	assert.Len(t, snapshots, 1)
+
+	cat, _, err = catalog.Open(catalogPath, logger)
+	require.NoError(t, err)
+	defer cat.Close()
+	_, ok := cat.Get("providers/a.zip")
+	assert.True(t, ok)
+}
+
+func TestSnapshotter_RestoreChecks(t *testing.T) {
+	ctx := t.Context()
+	logger := newTestLogger()
+	store := storage.NewLocalStorage(t.TempDir(), logger)
+	meta := metadata.NewStore(store, logger)
+	require.NoError(t, meta.Save(ctx, "pins.json", document{Value: "pinned"}))
+
+	snapshotter := NewSnapshotter(store, nil, logger)
+	manifest, err := snapshotter.Create(ctx, "")
+	require.NoError(t, err)
+	assert.False(t, manifest.Catalog)
+	require.NoError(t, meta.Save(ctx, "pins.json", document{Value: "changed"}))
+
+	_, err = snapshotter.Restore(ctx, "20240102T030405.006Z", RestoreOptions{})
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = snapshotter.Restore(ctx, "../pins.json", RestoreOptions{})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// A refused snapshot changes nothing
+	_, err = snapshotter.Restore(ctx, manifest.Name, RestoreOptions{Check: func(*Manifest) error {
+		return errors.New("schema too new")
+	}})
+	assert.ErrorContains(t, err, "schema too new")
+	var doc document
+	require.NoError(t, meta.Load(ctx, "pins.json", &doc))
+	assert.Equal(t, "changed", doc.Value)
+
+	_, err = snapshotter.Restore(ctx, manifest.Name, RestoreOptions{})
+	require.NoError(t, err)
+	require.NoError(t, meta.Load(ctx, "pins.json", &doc))
+	assert.Equal(t, "pinned", doc.Value)
+}
+
+// rewrite replaces the stored snapshot name with the archive returned by edit
+func rewrite(t *testing.T, store storage.Storage, name string, edit func([]byte) []byte) {
+	r, err := store.Get(t.Context(), key(name))
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.NoError(t, store.Delete(t.Context(), key(name)))
+	require.NoError(t, store.Put(t.Context(), key(name), bytes.NewReader(edit(data))))
+}
+
+// repack rewrites the entries of a snapshot archive, edit returns the new content of each entry
+func repack(t *testing.T, data []byte, edit func(name string, content []byte) []byte) []byte {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	tr := tar.NewReader(gz)
+	var out bytes.Buffer
+	gw := gzip.NewWriter(&out)
+	tw := tar.NewWriter(gw)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		content, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		content = edit(header.Name, content)
+		require.NoError(t, writeEntry(tw, header.Name, int64(len(content)), header.ModTime, bytes.NewReader(content)))
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+	return out.Bytes()
+}
+
+func TestSnapshotter_RestoreCorrupt(t *testing.T) {
+	ctx := t.Context()
+	logger := newTestLogger()
+	store := storage.NewLocalStorage(t.TempDir(), logger)
+	meta := metadata.NewStore(store, logger)
+	catalogPath := filepath.Join(t.TempDir(), "catalog.db")
+	cat, _, err := catalog.Open(catalogPath, logger)
+	require.NoError(t, err)
+
+	require.NoError(t, meta.Save(ctx, "a.json", document{Value: "a"}))
+	require.NoError(t, meta.Save(ctx, "z.json", document{Value: "z"}))
+	cat.Put(catalog.Entry{Key: "providers/a.zip", Size: 1})
+	manifest, err := NewSnapshotter(store, cat, logger).Create(ctx, "")
+	require.NoError(t, err)
+	require.Contains(t, manifest.Checksums, metadata.KeyPrefix+"a.json")
+	require.Contains(t, manifest.Checksums, catalogName)
+
+	// The state changes after the snapshot
+	require.NoError(t, meta.Save(ctx, "a.json", document{Value: "changed"}))
+	require.NoError(t, meta.Save(ctx, "z.json", document{Value: "changed"}))
+	require.NoError(t, meta.Save(ctx, "limits.json", document{Value: "limits"}))
+	cat.Delete("providers/a.zip")
+	require.NoError(t, cat.Close())
+
+	var original []byte
+	rewrite(t, store, manifest.Name, func(data []byte) []byte {
+		original = data
+		return data
+	})
+
+	tests := []struct {
+		name string
+		edit func([]byte) []byte
+	}{
+		{"truncated", func(data []byte) []byte { return data[:len(data)-20] }},
+		{"tampered document", func(data []byte) []byte {
+			return repack(t, data, func(name string, content []byte) []byte {
+				if name == metadata.KeyPrefix+"z.json" {
+					return []byte(`{"value":"tampered"}`)
+				}
+				return content
+			})
+		}},
+		{"tampered catalog", func(data []byte) []byte {
+			return repack(t, data, func(name string, content []byte) []byte {
+				if name == catalogName {
+					return content[:len(content)/2]
+				}
+				return content
+			})
+		}},
+		{"newer schema", func(data []byte) []byte {
+			return repack(t, data, func(name string, content []byte) []byte {
+				if name == manifestName {
+					return bytes.Replace(content, []byte(`"metadata": 0`), []byte(`"metadata": 99`), 1)
+				}
+				return content
+			})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rewrite(t, store, manifest.Name, func([]byte) []byte { return tt.edit(original) })
+
+			_, err := NewSnapshotter(store, nil, logger).Restore(ctx, manifest.Name, RestoreOptions{CatalogPath: catalogPath})
+			assert.ErrorIs(t, err, ErrInvalid)
+
+			// Nothing was restored
+			var doc document
+			require.NoError(t, meta.Load(ctx, "a.json", &doc))
+			assert.Equal(t, "changed", doc.Value)
+			require.NoError(t, meta.Load(ctx, "z.json", &doc))
+			assert.Equal(t, "changed", doc.Value)
+			require.NoError(t, meta.Load(ctx, "limits.json", &doc))
+			assert.Equal(t, "limits", doc.Value)
+
+			cat, _, err := catalog.Open(catalogPath, logger)
+			require.NoError(t, err)
+			defer cat.Close()
+			_, ok := cat.Get("providers/a.zip")
+			assert.False(t, ok)
+		})
+	}
+}
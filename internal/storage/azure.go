@@ -0,0 +1,395 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/errclass"
+	"cachetf/internal/metrics"
+	"cachetf/pkg/logger"
+)
+
+const (
+	// azureBlockSize is the size of the blocks of uploads, smaller files are uploaded with a single request
+	azureBlockSize = 8 << 20
+	// azureUploadConcurrency is the number of blocks of an upload staged at once, each buffered in memory
+	azureUploadConcurrency = 4
+	// azureMaxMarkers bounds the listing markers remembered for the next page
+	azureMaxMarkers = 64
+)
+
+// azureContainerPattern matches the names of blob containers
+var azureContainerPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{1,61}[a-z0-9])$`)
+
+// AzureConfig holds the configuration of Azure Blob Storage. The storage account is accessed with a connection
+// string, or with an account name and a SAS token.
+type AzureConfig struct {
+	// ConnectionString of the storage account, with an AccountKey or a SharedAccessSignature
+	ConnectionString string
+	// AccountName is the storage account accessed with SASToken when there's no connection string
+	AccountName string
+	// SASToken is a shared access signature granting read, write, delete and list access to the container
+	SASToken string
+	// Container is the blob container the files are stored in
+	Container string
+	// KeyPrefix scopes the cache to a part of the container, e.g. cachetf/prod/
+	KeyPrefix string
+	// Endpoint is the URL of the blob service accessed with SASToken, https://<account>.blob.core.windows.net if
+	// empty. Connection strings set theirs with BlobEndpoint.
+	Endpoint string
+}
+
+// ValidateAzureConfig checks that cfg names a container and credentials to access it with
+func ValidateAzureConfig(cfg *AzureConfig) error {
+	if !azureContainerPattern.MatchString(cfg.Container) || strings.Contains(cfg.Container, "--") {
+		return fmt.Errorf("invalid container %q: must be 3 to 63 lowercase letters, digits and single hyphens", cfg.Container)
+	}
+	_, _, err := cfg.client(nil)
+	return err
+}
+
+// client returns the client of the container using options and the authorization of its requests. Connection
+// strings are parsed by the SDK, which signs the requests with the AccountKey or appends the SharedAccessSignature.
+func (cfg *AzureConfig) client(options *container.ClientOptions) (*container.Client, string, error) {
+	switch {
+	case cfg.ConnectionString != "" && (cfg.AccountName != "" || cfg.SASToken != "" || cfg.Endpoint != ""):
+		return nil, "", errors.New("a connection string can't be combined with an account name, SAS token or endpoint")
+	case cfg.ConnectionString != "":
+		client, err := azblob.NewClientFromConnectionString(cfg.ConnectionString, (*azblob.ClientOptions)(options))
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid connection string: %w", err)
+		}
+		return client.ServiceClient().NewContainerClient(cfg.Container), "connection string", nil
+	case cfg.AccountName != "" && cfg.SASToken != "":
+		sas, err := url.ParseQuery(strings.TrimPrefix(cfg.SASToken, "?"))
+		if err != nil || sas.Get("sig") == "" {
+			return nil, "", errors.New("invalid SAS token: must be the query string of a shared access signature")
+		}
+		endpoint := "https://" + cfg.AccountName + ".blob.core.windows.net"
+		if cfg.Endpoint != "" {
+			u, err := url.Parse(cfg.Endpoint)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, "", fmt.Errorf("invalid endpoint %q: must be an http or https URL", cfg.Endpoint)
+			}
+			endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+		}
+		client, err := container.NewClientWithNoCredential(endpoint+"/"+cfg.Container+"?"+sas.Encode(), options)
+		if err != nil {
+			return nil, "", err
+		}
+		return client, "SAS token", nil
+	case cfg.AccountName != "":
+		return nil, "", errors.New("an account name requires a SAS token")
+	default:
+		return nil, "", errors.New("a connection string or an account name and a SAS token is required")
+	}
+}
+
+// AzureStorage implements Storage interface for Azure Blob Storage
+type AzureStorage struct {
+	client *container.Client
+	// prefix is prepended to every blob name, empty or ending with a slash
+	prefix    string
+	blockSize int64
+	logger    *logrus.Logger
+	metrics   *metrics.CacheMetrics
+
+	// markers maps the last key of a listed page to the marker of the next page, as the Blob service can't
+	// start listing after a given name
+	mu      sync.Mutex
+	markers map[string]string
+}
+
+// NewAzureStorage creates a new Azure Blob Storage instance
+func NewAzureStorage(cfg *AzureConfig, logger *logrus.Logger) (*AzureStorage, error) {
+	if cfg == nil {
+		return nil, errors.New("Azure config cannot be nil")
+	}
+	if err := ValidateAzureConfig(cfg); err != nil {
+		return nil, err
+	}
+	client, auth, err := cfg.client(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Azure client: %w", err)
+	}
+
+	logger.WithFields(logrus.Fields{
+		"container": cfg.Container,
+		"auth":      auth,
+	}).Info("Accessing Azure Blob Storage")
+
+	return &AzureStorage{
+		client:    client,
+		prefix:    NormalizeKeyPrefix(cfg.KeyPrefix),
+		blockSize: azureBlockSize,
+		logger:    logger,
+		metrics:   metrics.NewCacheMetrics(),
+		markers:   make(map[string]string),
+	}, nil
+}
+
+// blobName returns the blob name of a cache key
+func (s *AzureStorage) blobName(key string) string {
+	return s.prefix + key
+}
+
+// blob returns the client of the blob of a cache key
+func (s *AzureStorage) blob(key string) *blockblob.Client {
+	return s.client.NewBlockBlobClient(s.blobName(key))
+}
+
+// azureNotFound returns true if err is a response of the Blob service for a missing blob
+func azureNotFound(err error) bool {
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound
+}
+
+// deref returns the value p points to, the zero value if p is nil
+func deref[T any](p *T) T {
+	var v T
+	if p != nil {
+		v = *p
+	}
+	return v
+}
+
+// Get downloads a file from Azure Blob Storage
+func (s *AzureStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.blob(key).DownloadStream(ctx, nil)
+	if azureNotFound(err) {
+		s.metrics.RecordMiss()
+		logger.Debug(s.logger, "Cache miss: file not found in Azure", func() logrus.Fields { return logrus.Fields{"key": key} })
+		return nil, os.ErrNotExist
+	}
+	if err != nil {
+		class := s.metrics.RecordError("get", err)
+		s.logger.WithError(err).WithFields(logrus.Fields{
+			"key":             key,
+			errclass.LogField: class,
+		}).Error("Failed to get blob from Azure")
+		return nil, fmt.Errorf("failed to get blob %s: %w", key, err)
+	}
+
+	s.metrics.RecordHit()
+
+	logger.Debug(s.logger, "Cache hit: file found in Azure", func() logrus.Fields { return logrus.Fields{"key": key} })
+	return resp.Body, nil
+}
+
+// Put uploads a file to Azure Blob Storage. Files larger than a block are uploaded block by block, so they're
+// never fully buffered in memory.
+func (s *AzureStorage) Put(ctx context.Context, key string, data io.Reader) error {
+	// An overwritten blob only adds the difference with its previous size
+	previous, err := s.blobSize(ctx, key)
+	if err != nil {
+		s.logger.WithError(err).WithField("key", key).Warn("Failed to get the size of the overwritten blob")
+	}
+
+	size, err := s.upload(ctx, key, data)
+	if err != nil {
+		class := s.metrics.RecordError("put", err)
+		s.logger.WithError(err).WithFields(logrus.Fields{
+			"key":             key,
+			errclass.LogField: class,
+		}).Error("Failed to upload blob to Azure")
+		return fmt.Errorf("failed to upload blob %s: %w", key, err)
+	}
+
+	s.metrics.AddSize(size - previous)
+
+	s.logger.WithField("path", key).Info("Successfully uploaded blob to Azure")
+	return nil
+}
+
+// blobSize returns the size of a blob, 0 if it doesn't exist
+func (s *AzureStorage) blobSize(ctx context.Context, key string) (int64, error) {
+	props, err := s.blob(key).GetProperties(ctx, nil)
+	if azureNotFound(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return deref(props.ContentLength), nil
+}
+
+// upload writes data to a block blob, returning its size. A file that fits in a block is uploaded with a single
+// request, larger files are staged block by block and committed once complete.
+func (s *AzureStorage) upload(ctx context.Context, key string, data io.Reader) (int64, error) {
+	counter := &countingReader{r: data}
+	_, err := s.blob(key).UploadStream(ctx, counter, &blockblob.UploadStreamOptions{
+		BlockSize:   s.blockSize,
+		Concurrency: azureUploadConcurrency,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return counter.n, nil
+}
+
+// Exists checks if a file exists in Azure Blob Storage
+func (s *AzureStorage) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := s.blob(key).GetProperties(ctx, nil)
+	if err == nil {
+		return true, nil
+	}
+	if azureNotFound(err) {
+		return false, nil
+	}
+	s.metrics.RecordError("exists", err)
+	return false, fmt.Errorf("failed to check if blob exists: %w", err)
+}
+
+// List returns a page of the blobs whose key starts with prefix, ordered by key. Pages following a page
+// returned by List resume from its marker, other start keys are found by listing from the first blob.
+func (s *AzureStorage) List(ctx context.Context, prefix string, opts ListOptions) (*ListResult, error) {
+	marker := ""
+	if opts.StartAfter != "" {
+		s.mu.Lock()
+		marker = s.markers[opts.StartAfter]
+		s.mu.Unlock()
+	}
+
+	result := &ListResult{Objects: []ObjectInfo{}}
+	for {
+		listOpts := &container.ListBlobsFlatOptions{
+			Prefix:     to.Ptr(s.blobName(prefix)),
+			MaxResults: to.Ptr(int32(opts.maxKeys())),
+		}
+		if marker != "" {
+			listOpts.Marker = to.Ptr(marker)
+		}
+		page, err := s.client.NewListBlobsFlatPager(listOpts).NextPage(ctx)
+		if err != nil {
+			s.metrics.RecordError("list", err)
+			return nil, fmt.Errorf("failed to list blobs: %w", err)
+		}
+
+		for _, blob := range page.Segment.BlobItems {
+			key := strings.TrimPrefix(*blob.Name, s.prefix)
+			if key <= opts.StartAfter {
+				continue
+			}
+			obj := ObjectInfo{Key: key}
+			if blob.Properties != nil {
+				obj.Size = deref(blob.Properties.ContentLength)
+				obj.LastModified = deref(blob.Properties.LastModified).UTC()
+			}
+			result.Objects = append(result.Objects, obj)
+		}
+
+		marker = deref(page.NextMarker)
+		if marker == "" || len(result.Objects) > 0 {
+			break
+		}
+	}
+
+	if marker != "" {
+		result.IsTruncated = true
+		result.NextStartAfter = result.Objects[len(result.Objects)-1].Key
+		s.mu.Lock()
+		if len(s.markers) >= azureMaxMarkers {
+			clear(s.markers)
+		}
+		s.markers[result.NextStartAfter] = marker
+		s.mu.Unlock()
+	}
+
+	logger.Debug(s.logger, "Listed blobs", func() logrus.Fields {
+		return logrus.Fields{
+			"prefix": prefix,
+			"count":  len(result.Objects),
+		}
+	})
+
+	return result, nil
+}
+
+// Delete deletes a single blob
+func (s *AzureStorage) Delete(ctx context.Context, key string) error {
+	// The size is read first, the response to the deletion doesn't include it
+	props, err := s.blob(key).GetProperties(ctx, nil)
+	if err == nil {
+		err = s.remove(ctx, key)
+	}
+	if azureNotFound(err) || errors.Is(err, os.ErrNotExist) {
+		return os.ErrNotExist
+	}
+	if err != nil {
+		s.metrics.RecordError("delete", err)
+		return fmt.Errorf("failed to delete blob %s: %w", key, err)
+	}
+
+	s.metrics.SubSize(deref(props.ContentLength))
+	s.metrics.RecordDeletion(1)
+	s.logger.WithField("key", key).Info("Deleted blob from Azure")
+	return nil
+}
+
+// remove deletes a blob, os.ErrNotExist if it's missing
+func (s *AzureStorage) remove(ctx context.Context, key string) error {
+	_, err := s.blob(key).Delete(ctx, nil)
+	if azureNotFound(err) {
+		return os.ErrNotExist
+	}
+	return err
+}
+
+// DeleteByPrefix deletes all blobs with the given prefix. The Blob service deletes blobs one by one.
+func (s *AzureStorage) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
+	s.logger.WithField("prefix", prefix).Info("Deleting blobs by prefix")
+
+	// Collect the files first, so deletions don't shift the listing
+	var objects []ObjectInfo
+	err := Walk(ctx, s, prefix, func(obj ObjectInfo) error {
+		objects = append(objects, obj)
+		return nil
+	})
+	if err != nil {
+		s.metrics.RecordError("delete_by_prefix", err)
+		return 0, err
+	}
+
+	// The listed sizes are subtracted, without reading them again
+	deleted := 0
+	var totalSize int64
+	for _, obj := range objects {
+		err := s.remove(ctx, obj.Key)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			s.metrics.SubSize(totalSize)
+			s.metrics.RecordDeletion(deleted)
+			s.metrics.RecordError("delete_by_prefix", err)
+			return deleted, fmt.Errorf("failed to delete blob %s: %w", obj.Key, err)
+		}
+		deleted++
+		totalSize += obj.Size
+	}
+	s.metrics.SubSize(totalSize)
+	s.metrics.RecordDeletion(deleted)
+
+	s.logger.WithFields(logrus.Fields{
+		"prefix": prefix,
+		"count":  deleted,
+		"size":   totalSize,
+	}).Info("Finished deleting blobs by prefix")
+
+	return deleted, nil
+}
@@ -0,0 +1,353 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBlobService serves a container of the Blob service REST API from memory
+type fakeBlobService struct {
+	t      *testing.T
+	mu     sync.Mutex
+	blobs  map[string][]byte
+	blocks map[string][]byte
+	// authorize checks the credentials of a request
+	authorize func(r *http.Request) bool
+}
+
+func (f *fakeBlobService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.authorize(r) {
+		w.Header().Set("x-ms-error-code", "AuthenticationFailed")
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	assert.NotEmpty(f.t, r.Header.Get("x-ms-version"))
+
+	// The path is /container or /container/blob
+	container, name, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	assert.Equal(f.t, "cachetf", container)
+	query := r.URL.Query()
+
+	switch {
+	case r.Method == http.MethodGet && query.Get("comp") == "list":
+		f.list(w, query)
+	case r.Method == http.MethodPut && query.Get("comp") == "block":
+		body, _ := io.ReadAll(r.Body)
+		f.blocks[query.Get("blockid")] = body
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && query.Get("comp") == "blocklist":
+		var list struct {
+			Latest []string `xml:"Latest"`
+		}
+		require.NoError(f.t, xml.NewDecoder(r.Body).Decode(&list))
+		var blob []byte
+		for _, id := range list.Latest {
+			blob = append(blob, f.blocks[id]...)
+		}
+		f.blobs[name] = blob
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut:
+		assert.Equal(f.t, "BlockBlob", r.Header.Get("x-ms-blob-type"))
+		body, _ := io.ReadAll(r.Body)
+		f.blobs[name] = body
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		blob, ok := f.blobs[name]
+		if !ok {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(blob)))
+		if r.Method == http.MethodGet {
+			w.Write(blob)
+		}
+	case r.Method == http.MethodDelete:
+		if _, ok := f.blobs[name]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.blobs, name)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// list serves List Blobs, the marker being the index of the next blob
+func (f *fakeBlobService) list(w http.ResponseWriter, query map[string][]string) {
+	var names []string
+	for name := range f.blobs {
+		if strings.HasPrefix(name, first(query["prefix"])) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	start, _ := strconv.Atoi(first(query["marker"]))
+	maxResults, _ := strconv.Atoi(first(query["maxresults"]))
+	end := min(start+maxResults, len(names))
+
+	fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs>`)
+	for _, name := range names[start:end] {
+		fmt.Fprintf(w, `<Blob><Name>%s</Name><Properties><Last-Modified>%s</Last-Modified><Content-Length>%d</Content-Length></Properties></Blob>`,
+			name, time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC).Format(http.TimeFormat), len(f.blobs[name]))
+	}
+	fmt.Fprint(w, `</Blobs><NextMarker>`)
+	if end < len(names) {
+		fmt.Fprint(w, end)
+	}
+	fmt.Fprint(w, `</NextMarker></EnumerationResults>`)
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// newFakeAzure returns a storage backed by a fake Blob service accepting the SAS token sig=secret
+func newFakeAzure(t *testing.T) (*AzureStorage, *fakeBlobService) {
+	service := &fakeBlobService{
+		t:      t,
+		blobs:  make(map[string][]byte),
+		blocks: make(map[string][]byte),
+		authorize: func(r *http.Request) bool {
+			return r.URL.Query().Get("sig") == "secret" && r.Header.Get("Authorization") == ""
+		},
+	}
+	server := httptest.NewServer(service)
+	t.Cleanup(server.Close)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	s, err := NewAzureStorage(&AzureConfig{
+		AccountName: "cachetf",
+		SASToken:    "?sv=2021-08-06&sp=rwdl&sig=secret",
+		Container:   "cachetf",
+		KeyPrefix:   "prod",
+		Endpoint:    server.URL,
+	}, logger)
+	require.NoError(t, err)
+	return s, service
+}
+
+func TestAzureStorage(t *testing.T) {
+	s, service := newFakeAzure(t)
+	ctx := t.Context()
+	key := "registry.terraform.io/hashicorp/random/3.7.2/terraform-provider-random_3.7.2_linux_amd64.zip"
+
+	_, err := s.Get(ctx, key)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	exists, err := s.Exists(ctx, key)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	require.NoError(t, s.Put(ctx, key, strings.NewReader("provider")))
+	assert.Contains(t, service.blobs, "prod/"+key)
+
+	exists, err = s.Exists(ctx, key)
+	require.NoError(t, err)
+	assert.True(t, exists)
+	body, err := s.Get(ctx, key)
+	require.NoError(t, err)
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	body.Close()
+	assert.Equal(t, "provider", string(data))
+
+	require.NoError(t, s.Delete(ctx, key))
+	assert.ErrorIs(t, s.Delete(ctx, key), os.ErrNotExist)
+}
+
+func TestAzureStorage_SizeTracking(t *testing.T) {
+	s, _ := newFakeAzure(t)
+	assertSizeTracking(t, s, true)
+}
+
+func TestAzureStorage_BlockUpload(t *testing.T) {
+	s, service := newFakeAzure(t)
+	// The smallest block size of the client
+	s.blockSize = 1 << 20
+
+	// Two and a half blocks are uploaded as three blocks, two blocks as two full blocks
+	data := bytes.Repeat([]byte("0123456789"), 5<<20/20)
+	require.NoError(t, s.Put(t.Context(), "a", bytes.NewReader(data)))
+	assert.Equal(t, data, service.blobs["prod/a"])
+	assert.Len(t, service.blocks, 3)
+
+	clear(service.blocks)
+	require.NoError(t, s.Put(t.Context(), "b", bytes.NewReader(data[:2<<20])))
+	assert.Equal(t, data[:2<<20], service.blobs["prod/b"])
+	assert.Len(t, service.blocks, 2)
+}
+
+func TestAzureStorage_List(t *testing.T) {
+	s, _ := newFakeAzure(t)
+	ctx := t.Context()
+	for _, key := range []string{"a/1", "a/2", "a/3", "a/4", "a/5", "b/1"} {
+		require.NoError(t, s.Put(ctx, key, strings.NewReader(key)))
+	}
+
+	// Pages resume from the marker of the previous page
+	var keys []string
+	opts := ListOptions{MaxKeys: 2}
+	for {
+		page, err := s.List(ctx, "a/", opts)
+		require.NoError(t, err)
+		for _, obj := range page.Objects {
+			keys = append(keys, obj.Key)
+			assert.Equal(t, int64(3), obj.Size)
+		}
+		if !page.IsTruncated {
+			break
+		}
+		assert.Contains(t, s.markers, page.NextStartAfter)
+		opts.StartAfter = page.NextStartAfter
+	}
+	assert.Equal(t, []string{"a/1", "a/2", "a/3", "a/4", "a/5"}, keys)
+
+	// Other start keys are found by listing from the first blob
+	page, err := s.List(ctx, "a/", ListOptions{StartAfter: "a/3", MaxKeys: 10})
+	require.NoError(t, err)
+	require.Len(t, page.Objects, 2)
+	assert.Equal(t, "a/4", page.Objects[0].Key)
+	assert.False(t, page.IsTruncated)
+	assert.Equal(t, time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC), page.Objects[0].LastModified)
+
+	count, err := s.DeleteByPrefix(ctx, "a/")
+	require.NoError(t, err)
+	assert.Equal(t, 5, count)
+	exists, err := s.Exists(ctx, "b/1")
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestAzureStorage_SharedKey(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString([]byte("account-key"))
+	service := &fakeBlobService{t: t, blobs: make(map[string][]byte), blocks: make(map[string][]byte)}
+	server := httptest.NewServer(service)
+	t.Cleanup(server.Close)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	s, err := NewAzureStorage(&AzureConfig{
+		ConnectionString: "DefaultEndpointsProtocol=https;AccountName=cachetf;AccountKey=" + key + ";BlobEndpoint=" + server.URL,
+		Container:        "cachetf",
+	}, logger)
+	require.NoError(t, err)
+
+	// Shared Key signs the requests in the Authorization header
+	service.authorize = func(r *http.Request) bool {
+		return strings.HasPrefix(r.Header.Get("Authorization"), "SharedKey cachetf:") && r.URL.Query().Get("sig") == ""
+	}
+	require.NoError(t, s.Put(t.Context(), "a b/c", strings.NewReader("data")))
+	assert.Contains(t, service.blobs, "a b/c")
+	exists, err := s.Exists(t.Context(), "a b/c")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	// A rejected request fails
+	service.authorize = func(r *http.Request) bool { return false }
+	_, err = s.Get(t.Context(), "a b/c")
+	assert.ErrorContains(t, err, "AuthenticationFailed")
+}
+
+func TestAzureStorage_ConnectionStringSAS(t *testing.T) {
+	service := &fakeBlobService{
+		t:      t,
+		blobs:  make(map[string][]byte),
+		blocks: make(map[string][]byte),
+		authorize: func(r *http.Request) bool {
+			return r.URL.Query().Get("sig") == "secret" && r.Header.Get("Authorization") == ""
+		},
+	}
+	server := httptest.NewServer(service)
+	t.Cleanup(server.Close)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	s, err := NewAzureStorage(&AzureConfig{
+		ConnectionString: "BlobEndpoint=" + server.URL + ";SharedAccessSignature=sv=2021-08-06&sp=rwdl&sig=secret",
+		Container:        "cachetf",
+	}, logger)
+	require.NoError(t, err)
+
+	// The signature of the connection string is appended to every request
+	require.NoError(t, s.Put(t.Context(), "a", strings.NewReader("data")))
+	assert.Contains(t, service.blobs, "a")
+	exists, err := s.Exists(t.Context(), "a")
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestValidateAzureConfig(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString([]byte("account-key"))
+	tests := []struct {
+		name    string
+		cfg     AzureConfig
+		wantErr string
+	}{
+		{"account key", AzureConfig{Container: "cachetf", ConnectionString: "AccountName=cachetf;AccountKey=" + key}, ""},
+		{"connection string SAS", AzureConfig{Container: "cachetf", ConnectionString: "BlobEndpoint=https://cachetf.blob.core.windows.net;SharedAccessSignature=sv=2021-08-06&sig=abc"}, ""},
+		{"account and SAS", AzureConfig{Container: "cachetf", AccountName: "cachetf", SASToken: "sv=2021-08-06&sig=abc"}, ""},
+		{"account without SAS", AzureConfig{Container: "cachetf", AccountName: "cachetf"}, "requires a SAS token"},
+		{"no credentials", AzureConfig{Container: "cachetf"}, "is required"},
+		{"both", AzureConfig{Container: "cachetf", ConnectionString: "AccountName=cachetf;AccountKey=" + key, SASToken: "sig=abc"}, "can't be combined"},
+		{"connection string and endpoint", AzureConfig{Container: "cachetf", ConnectionString: "AccountName=cachetf;AccountKey=" + key, Endpoint: "https://blob.local"}, "can't be combined"},
+		{"SAS without signature", AzureConfig{Container: "cachetf", AccountName: "cachetf", SASToken: "sv=2021-08-06"}, "invalid SAS token"},
+		{"key without account", AzureConfig{Container: "cachetf", ConnectionString: "BlobEndpoint=https://x;AccountKey=" + key}, "invalid connection string"},
+		{"invalid key", AzureConfig{Container: "cachetf", ConnectionString: "AccountName=cachetf;AccountKey=***"}, "decode account key"},
+		{"invalid setting", AzureConfig{Container: "cachetf", ConnectionString: "AccountName"}, "invalid connection string"},
+		{"invalid container", AzureConfig{Container: "Cache_TF", AccountName: "cachetf", SASToken: "sig=abc"}, "invalid container"},
+		{"double hyphen", AzureConfig{Container: "cache--tf", AccountName: "cachetf", SASToken: "sig=abc"}, "invalid container"},
+		{"invalid endpoint", AzureConfig{Container: "cachetf", AccountName: "cachetf", SASToken: "sig=abc", Endpoint: "blob.local"}, "invalid endpoint"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateAzureConfig(&tt.cfg)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAzureConfig_Endpoint(t *testing.T) {
+	cfg := AzureConfig{
+		ConnectionString: "DefaultEndpointsProtocol=http;AccountName=cachetf;AccountKey=a2V5;EndpointSuffix=core.chinacloudapi.cn",
+		Container:        "cachetf",
+	}
+	client, auth, err := cfg.client(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "http://cachetf.blob.core.chinacloudapi.cn/cachetf", client.URL())
+	assert.Equal(t, "connection string", auth)
+
+	cfg = AzureConfig{AccountName: "cachetf", SASToken: "sig=abc", Container: "cachetf"}
+	client, auth, err = cfg.client(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "https://cachetf.blob.core.windows.net/cachetf?sig=abc", client.URL())
+	assert.Equal(t, "SAS token", auth)
+}
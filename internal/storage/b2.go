@@ -0,0 +1,684 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/errclass"
+	"cachetf/internal/metrics"
+	"cachetf/pkg/logger"
+)
+
+const (
+	// B2APINative accesses the bucket with the native B2 API, the default
+	B2APINative = "native"
+	// B2APIS3 accesses the bucket with the S3-compatible API of B2 through the S3 storage
+	B2APIS3 = "s3"
+
+	// b2AuthEndpoint is where application keys are exchanged for an API URL and an authorization token
+	b2AuthEndpoint = "https://api.backblazeb2.com"
+	// b2PartSize is the size of the parts of large file uploads, smaller files are uploaded with a single
+	// request. B2 requires parts of at least 5 MB.
+	b2PartSize = 16 << 20
+)
+
+// b2RegionPattern matches the regions of B2 buckets, e.g. us-west-004
+var b2RegionPattern = regexp.MustCompile(`^[a-z]+-[a-z]+-\d{3}$`)
+
+// B2Config holds the configuration of Backblaze B2 storage, accessed with an application key through the native B2
+// API, or through the S3-compatible API of B2 if API is B2APIS3
+type B2Config struct {
+	// API is B2APINative or B2APIS3, B2APINative if empty
+	API string
+	// KeyID is the ID of the application key, its access key ID on the S3-compatible API
+	KeyID string
+	// ApplicationKey is the secret of the application key
+	ApplicationKey string
+	// Bucket is the name of the bucket the files are stored in
+	Bucket string
+	// KeyPrefix scopes the cache to a part of the bucket, e.g. cachetf/prod/
+	KeyPrefix string
+	// AuthEndpoint is where the application key is authorized with the native API, the B2 API if empty
+	AuthEndpoint string
+	// Region of the bucket for the S3-compatible API, the part of its S3 endpoint after s3., e.g. us-west-004
+	Region string
+	// Endpoint is the URL of the S3-compatible API, https://s3.<region>.backblazeb2.com if empty
+	Endpoint string
+}
+
+// ValidateB2Config checks that cfg names a bucket and an application key, and the region of the bucket for the
+// S3-compatible API
+func ValidateB2Config(cfg *B2Config) error {
+	var errs []error
+	if cfg.KeyID == "" || cfg.ApplicationKey == "" {
+		errs = append(errs, errors.New("an application key ID and key are required"))
+	}
+	if cfg.Bucket == "" {
+		errs = append(errs, errors.New("a bucket is required"))
+	}
+	switch cfg.API {
+	case "", B2APINative:
+		if cfg.Region != "" || cfg.Endpoint != "" {
+			errs = append(errs, errors.New("a region and an endpoint only apply to the S3-compatible API"))
+		}
+	case B2APIS3:
+		if !b2RegionPattern.MatchString(cfg.Region) {
+			errs = append(errs, fmt.Errorf("invalid region %q: must be the region of the bucket's S3 endpoint, e.g. us-west-004", cfg.Region))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("invalid API %q: must be %s or %s", cfg.API, B2APINative, B2APIS3))
+	}
+	return errors.Join(errs...)
+}
+
+// S3Config returns the configuration of the S3 storage accessing the bucket through the S3-compatible API
+func (cfg *B2Config) S3Config() *S3Config {
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + cfg.Region + ".backblazeb2.com"
+	}
+	return &S3Config{
+		Bucket:          cfg.Bucket,
+		Region:          cfg.Region,
+		KeyPrefix:       cfg.KeyPrefix,
+		Endpoint:        endpoint,
+		UsePathStyle:    true,
+		AccessKeyID:     cfg.KeyID,
+		SecretAccessKey: cfg.ApplicationKey,
+	}
+}
+
+// NewB2S3Storage creates a storage of a Backblaze B2 bucket accessed through the S3-compatible API of B2 with the
+// S3 storage
+func NewB2S3Storage(cfg *B2Config, logger *logrus.Logger) (*S3Storage, error) {
+	if cfg == nil {
+		return nil, errors.New("B2 config cannot be nil")
+	}
+	if err := ValidateB2Config(cfg); err != nil {
+		return nil, err
+	}
+	if cfg.API != B2APIS3 {
+		return nil, fmt.Errorf("the B2 API is %q, not %s", cfg.API, B2APIS3)
+	}
+
+	s3Cfg := cfg.S3Config()
+	logger.WithFields(logrus.Fields{
+		"bucket":   cfg.Bucket,
+		"endpoint": s3Cfg.Endpoint,
+	}).Info("Accessing Backblaze B2 through its S3-compatible API")
+	return NewS3Storage(s3Cfg, logger)
+}
+
+// b2Session is the result of b2_authorize_account
+type b2Session struct {
+	APIURL             string `json:"apiUrl"`
+	DownloadURL        string `json:"downloadUrl"`
+	AuthorizationToken string `json:"authorizationToken"`
+	AccountID          string `json:"accountId"`
+	Allowed            struct {
+		BucketID   string `json:"bucketId"`
+		BucketName string `json:"bucketName"`
+	} `json:"allowed"`
+}
+
+// b2Error is the error response of the B2 API
+type b2Error struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *b2Error) Error() string {
+	return fmt.Sprintf("B2 API error %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// b2File is a file version listed by the B2 API
+type b2File struct {
+	FileID          string `json:"fileId"`
+	FileName        string `json:"fileName"`
+	Action          string `json:"action"`
+	ContentLength   int64  `json:"contentLength"`
+	UploadTimestamp int64  `json:"uploadTimestamp"`
+}
+
+// B2Storage implements Storage interface for Backblaze B2, with the native B2 API
+type B2Storage struct {
+	client       *http.Client
+	authEndpoint string
+	keyID        string
+	key          string
+	bucket       string
+	// prefix is prepended to every file name, empty or ending with a slash
+	prefix   string
+	partSize int
+	logger   *logrus.Logger
+	metrics  *metrics.CacheMetrics
+
+	// The session is replaced when its token expires, after 24 hours
+	mu       sync.Mutex
+	session  *b2Session
+	bucketID string
+}
+
+// NewB2Storage creates a new B2 storage instance with the native API, authorizing the application key
+func NewB2Storage(cfg *B2Config, logger *logrus.Logger) (*B2Storage, error) {
+	if cfg == nil {
+		return nil, errors.New("B2 config cannot be nil")
+	}
+	if err := ValidateB2Config(cfg); err != nil {
+		return nil, err
+	}
+	if cfg.API == B2APIS3 {
+		return nil, errors.New("the S3-compatible API of B2 is accessed with NewB2S3Storage")
+	}
+
+	s := &B2Storage{
+		client:       &http.Client{},
+		authEndpoint: strings.TrimSuffix(cfg.AuthEndpoint, "/"),
+		keyID:        cfg.KeyID,
+		key:          cfg.ApplicationKey,
+		bucket:       cfg.Bucket,
+		prefix:       NormalizeKeyPrefix(cfg.KeyPrefix),
+		partSize:     b2PartSize,
+		logger:       logger,
+		metrics:      metrics.NewCacheMetrics(),
+	}
+	if s.authEndpoint == "" {
+		s.authEndpoint = b2AuthEndpoint
+	}
+
+	// Fail early on invalid keys and buckets
+	if _, err := s.authorize(context.Background(), nil); err != nil {
+		return nil, fmt.Errorf("failed to authorize B2 application key: %w", err)
+	}
+	logger.WithField("bucket", cfg.Bucket).Info("Accessing Backblaze B2")
+	return s, nil
+}
+
+// fileName returns the B2 file name of a cache key
+func (s *B2Storage) fileName(key string) string {
+	return s.prefix + key
+}
+
+// authorize returns the current session, authorizing the application key if there's none or if expired is the
+// session whose token expired
+func (s *B2Storage) authorize(ctx context.Context, expired *b2Session) (*b2Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.session != nil && s.session != expired {
+		return s.session, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.authEndpoint+"/b2api/v2/b2_authorize_account", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(s.keyID, s.key)
+	session := &b2Session{}
+	if err := s.send(req, session); err != nil {
+		return nil, err
+	}
+
+	// Keys restricted to a bucket report it, others have to look it up
+	bucketID := session.Allowed.BucketID
+	if bucketID != "" && session.Allowed.BucketName != s.bucket {
+		return nil, fmt.Errorf("the application key is restricted to bucket %s", session.Allowed.BucketName)
+	}
+	if bucketID == "" {
+		var buckets struct {
+			Buckets []struct {
+				BucketID string `json:"bucketId"`
+			} `json:"buckets"`
+		}
+		request := map[string]string{"accountId": session.AccountID, "bucketName": s.bucket}
+		if err := s.post(ctx, session, "b2_list_buckets", request, &buckets); err != nil {
+			return nil, err
+		}
+		if len(buckets.Buckets) == 0 {
+			return nil, fmt.Errorf("bucket %s not found", s.bucket)
+		}
+		bucketID = buckets.Buckets[0].BucketID
+	}
+
+	s.session = session
+	s.bucketID = bucketID
+	return session, nil
+}
+
+// send sends a request and decodes the JSON response into v, returning a *b2Error for error responses
+func (s *B2Storage) send(req *http.Request, v any) error {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &b2Error{Status: resp.StatusCode}
+		if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(apiErr); err != nil {
+			apiErr.Code = http.StatusText(resp.StatusCode)
+		}
+		return apiErr
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// post calls an API operation of a session
+func (s *B2Storage) post(ctx context.Context, session *b2Session, operation string, request, response any) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, session.APIURL+"/b2api/v2/"+operation, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", session.AuthorizationToken)
+	return s.send(req, response)
+}
+
+// call calls an API operation, authorizing the application key again once if the token expired
+func (s *B2Storage) call(ctx context.Context, operation string, request, response any) error {
+	session, err := s.authorize(ctx, nil)
+	if err != nil {
+		return err
+	}
+	err = s.post(ctx, session, operation, request, response)
+	if isB2ExpiredToken(err) {
+		if session, err = s.authorize(ctx, session); err != nil {
+			return err
+		}
+		err = s.post(ctx, session, operation, request, response)
+	}
+	return err
+}
+
+// isB2ExpiredToken returns true for the errors of expired authorization tokens
+func isB2ExpiredToken(err error) bool {
+	var apiErr *b2Error
+	return errors.As(err, &apiErr) && apiErr.Status == http.StatusUnauthorized && apiErr.Code == "expired_auth_token"
+}
+
+// download sends a download request for a file
+func (s *B2Storage) download(ctx context.Context, method, key string) (*http.Response, error) {
+	session, err := s.authorize(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.sendDownload(ctx, session, method, key)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	// Retry once with a new token
+	resp.Body.Close()
+	if session, err = s.authorize(ctx, session); err != nil {
+		return nil, err
+	}
+	return s.sendDownload(ctx, session, method, key)
+}
+
+// sendDownload sends a download request with the token of a session
+func (s *B2Storage) sendDownload(ctx context.Context, session *b2Session, method, key string) (*http.Response, error) {
+	u := session.DownloadURL + "/file/" + url.PathEscape(s.bucket) + "/" + escapeFileName(s.fileName(key))
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", session.AuthorizationToken)
+	return s.client.Do(req)
+}
+
+// Get downloads a file from B2
+func (s *B2Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.download(ctx, http.MethodGet, key)
+	if err == nil && resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		s.metrics.RecordMiss()
+		logger.Debug(s.logger, "Cache miss: file not found in B2", func() logrus.Fields { return logrus.Fields{"key": key} })
+		return nil, os.ErrNotExist
+	}
+	if err == nil && resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		err = fmt.Errorf("unexpected response %s", resp.Status)
+	}
+	if err != nil {
+		class := s.metrics.RecordError("get", err)
+		s.logger.WithError(err).WithFields(logrus.Fields{
+			"key":             key,
+			errclass.LogField: class,
+		}).Error("Failed to get file from B2")
+		return nil, fmt.Errorf("failed to get file %s: %w", key, err)
+	}
+
+	s.metrics.RecordHit()
+
+	logger.Debug(s.logger, "Cache hit: file found in B2", func() logrus.Fields { return logrus.Fields{"key": key} })
+	return resp.Body, nil
+}
+
+// Put uploads a file to B2. Files larger than a part are uploaded as large files part by part, so they're never
+// fully buffered in memory.
+func (s *B2Storage) Put(ctx context.Context, key string, data io.Reader) error {
+	// An overwritten file only adds the difference to the cache size, like its deletion only subtracts the size of
+	// its last version
+	previous, err := s.fileSize(ctx, key)
+	if err != nil {
+		s.logger.WithError(err).WithField("key", key).Warn("Failed to get the size of the overwritten file")
+	}
+
+	size, err := s.upload(ctx, key, data)
+	if err != nil {
+		class := s.metrics.RecordError("put", err)
+		s.logger.WithError(err).WithFields(logrus.Fields{
+			"key":             key,
+			errclass.LogField: class,
+		}).Error("Failed to upload file to B2")
+		return fmt.Errorf("failed to upload file %s: %w", key, err)
+	}
+
+	s.metrics.AddSize(size - previous)
+
+	s.logger.WithField("path", key).Info("Successfully uploaded file to B2")
+	return nil
+}
+
+// fileSize returns the size of the last version of a file, 0 if it doesn't exist
+func (s *B2Storage) fileSize(ctx context.Context, key string) (int64, error) {
+	resp, err := s.download(ctx, http.MethodHead, key)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.ContentLength, nil
+	case http.StatusNotFound:
+		return 0, nil
+	}
+	return 0, fmt.Errorf("unexpected response %s", resp.Status)
+}
+
+// upload writes data to a file, returning its size
+func (s *B2Storage) upload(ctx context.Context, key string, data io.Reader) (int64, error) {
+	part, err := s.readPart(data)
+	if err != nil {
+		return 0, err
+	}
+	if len(part) < s.partSize {
+		return int64(len(part)), s.uploadFile(ctx, key, part)
+	}
+
+	var file struct {
+		FileID string `json:"fileId"`
+	}
+	request := map[string]string{"bucketId": s.bucketID, "fileName": s.fileName(key), "contentType": "b2/x-auto"}
+	if err := s.call(ctx, "b2_start_large_file", request, &file); err != nil {
+		return 0, err
+	}
+
+	var checksums []string
+	var size int64
+	for len(part) > 0 {
+		checksum, err := s.uploadPart(ctx, file.FileID, len(checksums)+1, part)
+		if err != nil {
+			// Unfinished large files are kept, and billed, until they're canceled
+			s.call(context.WithoutCancel(ctx), "b2_cancel_large_file", map[string]string{"fileId": file.FileID}, nil)
+			return 0, err
+		}
+		checksums = append(checksums, checksum)
+		size += int64(len(part))
+
+		if len(part) < s.partSize {
+			break
+		}
+		if part, err = s.readPart(data); err != nil {
+			s.call(context.WithoutCancel(ctx), "b2_cancel_large_file", map[string]string{"fileId": file.FileID}, nil)
+			return 0, err
+		}
+	}
+
+	finish := map[string]any{"fileId": file.FileID, "partSha1Array": checksums}
+	if err := s.call(ctx, "b2_finish_large_file", finish, nil); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// readPart reads up to a part from r
+func (s *B2Storage) readPart(r io.Reader) ([]byte, error) {
+	var part bytes.Buffer
+	if _, err := io.Copy(&part, io.LimitReader(r, int64(s.partSize))); err != nil {
+		return nil, fmt.Errorf("failed to read data: %w", err)
+	}
+	return part.Bytes(), nil
+}
+
+// uploadTarget is the result of b2_get_upload_url and b2_get_upload_part_url
+type uploadTarget struct {
+	UploadURL          string `json:"uploadUrl"`
+	AuthorizationToken string `json:"authorizationToken"`
+}
+
+// uploadFile uploads a small file with a single request
+func (s *B2Storage) uploadFile(ctx context.Context, key string, data []byte) error {
+	var target uploadTarget
+	if err := s.call(ctx, "b2_get_upload_url", map[string]string{"bucketId": s.bucketID}, &target); err != nil {
+		return err
+	}
+
+	checksum := sha1.Sum(data)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.UploadURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", target.AuthorizationToken)
+	req.Header.Set("X-Bz-File-Name", escapeFileName(s.fileName(key)))
+	req.Header.Set("Content-Type", "b2/x-auto")
+	req.Header.Set("X-Bz-Content-Sha1", hex.EncodeToString(checksum[:]))
+	return s.send(req, nil)
+}
+
+// uploadPart uploads a part of a large file, returning its SHA-1 checksum
+func (s *B2Storage) uploadPart(ctx context.Context, fileID string, number int, data []byte) (string, error) {
+	var target uploadTarget
+	if err := s.call(ctx, "b2_get_upload_part_url", map[string]string{"fileId": fileID}, &target); err != nil {
+		return "", err
+	}
+
+	sum := sha1.Sum(data)
+	checksum := hex.EncodeToString(sum[:])
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.UploadURL, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", target.AuthorizationToken)
+	req.Header.Set("X-Bz-Part-Number", strconv.Itoa(number))
+	req.Header.Set("X-Bz-Content-Sha1", checksum)
+	return checksum, s.send(req, nil)
+}
+
+// Exists checks if a file exists in B2
+func (s *B2Storage) Exists(ctx context.Context, key string) (bool, error) {
+	resp, err := s.download(ctx, http.MethodHead, key)
+	if err == nil {
+		resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusOK:
+			return true, nil
+		case http.StatusNotFound:
+			return false, nil
+		}
+		err = fmt.Errorf("unexpected response %s", resp.Status)
+	}
+	s.metrics.RecordError("exists", err)
+	return false, fmt.Errorf("failed to check if file exists: %w", err)
+}
+
+// List returns a page of the files whose key starts with prefix, ordered by key
+func (s *B2Storage) List(ctx context.Context, prefix string, opts ListOptions) (*ListResult, error) {
+	// The start file name is included in the listing, so ask for one more file to skip it
+	request := map[string]any{
+		"bucketId":     s.bucketID,
+		"prefix":       s.fileName(prefix),
+		"maxFileCount": opts.maxKeys() + 1,
+	}
+	if opts.StartAfter != "" {
+		request["startFileName"] = s.fileName(opts.StartAfter)
+	}
+	var response struct {
+		Files        []b2File `json:"files"`
+		NextFileName *string  `json:"nextFileName"`
+	}
+	if err := s.call(ctx, "b2_list_file_names", request, &response); err != nil {
+		s.metrics.RecordError("list", err)
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+
+	result := &ListResult{Objects: make([]ObjectInfo, 0, len(response.Files))}
+	for _, file := range response.Files {
+		key := strings.TrimPrefix(file.FileName, s.prefix)
+		if file.Action != "upload" || key <= opts.StartAfter {
+			continue
+		}
+		if len(result.Objects) == opts.maxKeys() {
+			result.IsTruncated = true
+			break
+		}
+		result.Objects = append(result.Objects, ObjectInfo{
+			Key:          key,
+			Size:         file.ContentLength,
+			LastModified: time.UnixMilli(file.UploadTimestamp).UTC(),
+		})
+	}
+	if response.NextFileName != nil {
+		result.IsTruncated = true
+	}
+	if result.IsTruncated && len(result.Objects) > 0 {
+		result.NextStartAfter = result.Objects[len(result.Objects)-1].Key
+	}
+
+	logger.Debug(s.logger, "Listed files", func() logrus.Fields {
+		return logrus.Fields{
+			"prefix": prefix,
+			"count":  len(result.Objects),
+		}
+	})
+
+	return result, nil
+}
+
+// versions returns the versions of the files whose name starts with prefix, or of the file named prefix if
+// exact is set
+func (s *B2Storage) versions(ctx context.Context, prefix string, exact bool) ([]b2File, error) {
+	var versions []b2File
+	request := map[string]any{
+		"bucketId":     s.bucketID,
+		"prefix":       prefix,
+		"maxFileCount": 1000,
+	}
+	for {
+		var response struct {
+			Files        []b2File `json:"files"`
+			NextFileName *string  `json:"nextFileName"`
+			NextFileID   *string  `json:"nextFileId"`
+		}
+		if err := s.call(ctx, "b2_list_file_versions", request, &response); err != nil {
+			return nil, err
+		}
+		for _, file := range response.Files {
+			if !exact || file.FileName == prefix {
+				versions = append(versions, file)
+			}
+		}
+		if response.NextFileName == nil || (exact && *response.NextFileName != prefix) {
+			return versions, nil
+		}
+		request["startFileName"] = *response.NextFileName
+		request["startFileId"] = response.NextFileID
+	}
+}
+
+// deleteVersions deletes file versions, returning the number of files that had an uploaded version
+func (s *B2Storage) deleteVersions(ctx context.Context, versions []b2File) (int, int64, error) {
+	deleted := make(map[string]bool)
+	var size int64
+	for _, version := range versions {
+		request := map[string]string{"fileName": version.FileName, "fileId": version.FileID}
+		if err := s.call(ctx, "b2_delete_file_version", request, nil); err != nil {
+			return len(deleted), size, err
+		}
+		if version.Action == "upload" && !deleted[version.FileName] {
+			deleted[version.FileName] = true
+			size += version.ContentLength
+		}
+	}
+	return len(deleted), size, nil
+}
+
+// Delete deletes a single file with all its versions, B2 keeps the previous versions of replaced files
+func (s *B2Storage) Delete(ctx context.Context, key string) error {
+	versions, err := s.versions(ctx, s.fileName(key), true)
+	if err == nil && len(versions) == 0 {
+		return os.ErrNotExist
+	}
+	if err == nil {
+		var size int64
+		_, size, err = s.deleteVersions(ctx, versions)
+		// The versions deleted before a failure are gone too
+		s.metrics.SubSize(size)
+	}
+	if err != nil {
+		s.metrics.RecordError("delete", err)
+		return fmt.Errorf("failed to delete file %s: %w", key, err)
+	}
+
+	s.metrics.RecordDeletion(1)
+	s.logger.WithField("key", key).Info("Deleted file from B2")
+	return nil
+}
+
+// DeleteByPrefix deletes all files with the given prefix, with all their versions
+func (s *B2Storage) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
+	s.logger.WithField("prefix", prefix).Info("Deleting files by prefix")
+
+	versions, err := s.versions(ctx, s.fileName(prefix), false)
+	if err != nil {
+		s.metrics.RecordError("delete_by_prefix", err)
+		return 0, fmt.Errorf("failed to list files: %w", err)
+	}
+	deleted, size, err := s.deleteVersions(ctx, versions)
+	s.metrics.SubSize(size)
+	if err != nil {
+		s.metrics.RecordError("delete_by_prefix", err)
+		return deleted, fmt.Errorf("failed to delete files: %w", err)
+	}
+	s.metrics.RecordDeletion(deleted)
+
+	s.logger.WithFields(logrus.Fields{
+		"prefix": prefix,
+		"count":  deleted,
+		"size":   size,
+	}).Info("Finished deleting files by prefix")
+
+	return deleted, nil
+}
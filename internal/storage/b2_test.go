@@ -0,0 +1,476 @@
+package storage
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/metrics"
+)
+
+// fakeB2Version is a version of a file stored by fakeB2
+type fakeB2Version struct {
+	id   string
+	name string
+	data []byte
+}
+
+// fakeB2 serves the native B2 API for bucket cachetf from memory
+type fakeB2 struct {
+	t   *testing.T
+	url string
+	mu  sync.Mutex
+	// versions are the file versions, newest last
+	versions []fakeB2Version
+	// parts are the parts of unfinished large files by file ID
+	parts map[string]map[int][]byte
+	names map[string]string
+	// token is the current authorization token, authorizations counts b2_authorize_account calls
+	token          string
+	authorizations int
+	nextID         int
+}
+
+func (f *fakeB2) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path == "/b2api/v2/b2_authorize_account" {
+		id, key, _ := r.BasicAuth()
+		if id != "key-id" || key != "secret" {
+			f.error(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		f.authorizations++
+		f.token = "token-" + strconv.Itoa(f.authorizations)
+		f.json(w, map[string]any{
+			"accountId":          "account",
+			"apiUrl":             f.url,
+			"downloadUrl":        f.url,
+			"authorizationToken": f.token,
+		})
+		return
+	}
+	if r.Header.Get("Authorization") != f.token {
+		f.error(w, http.StatusUnauthorized, "expired_auth_token")
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/file/cachetf/"):
+		name, _ := url.PathUnescape(strings.TrimPrefix(r.URL.Path, "/file/cachetf/"))
+		version := f.latest(name)
+		if version == nil {
+			f.error(w, http.StatusNotFound, "not_found")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(version.data)))
+		w.Write(version.data)
+	case r.URL.Path == "/upload":
+		name, _ := url.PathUnescape(r.Header.Get("X-Bz-File-Name"))
+		data := f.body(r)
+		sum := sha1.Sum(data)
+		assert.Equal(f.t, hex.EncodeToString(sum[:]), r.Header.Get("X-Bz-Content-Sha1"))
+		f.add(name, data)
+		f.json(w, map[string]any{})
+	case r.URL.Path == "/upload_part":
+		number, _ := strconv.Atoi(r.Header.Get("X-Bz-Part-Number"))
+		f.parts[r.URL.Query().Get("fileId")][number] = f.body(r)
+		f.json(w, map[string]any{})
+	default:
+		var request map[string]any
+		require.NoError(f.t, json.NewDecoder(r.Body).Decode(&request))
+		f.call(w, strings.TrimPrefix(r.URL.Path, "/b2api/v2/"), request)
+	}
+}
+
+// call serves an API operation
+func (f *fakeB2) call(w http.ResponseWriter, operation string, request map[string]any) {
+	str := func(name string) string {
+		value, _ := request[name].(string)
+		return value
+	}
+	switch operation {
+	case "b2_list_buckets":
+		assert.Equal(f.t, "cachetf", str("bucketName"))
+		f.json(w, map[string]any{"buckets": []any{map[string]any{"bucketId": "bucket-id"}}})
+	case "b2_get_upload_url":
+		assert.Equal(f.t, "bucket-id", str("bucketId"))
+		f.json(w, map[string]any{"uploadUrl": f.url + "/upload", "authorizationToken": f.token})
+	case "b2_start_large_file":
+		f.nextID++
+		id := "large-" + strconv.Itoa(f.nextID)
+		f.parts[id] = make(map[int][]byte)
+		f.names[id] = str("fileName")
+		f.json(w, map[string]any{"fileId": id})
+	case "b2_get_upload_part_url":
+		f.json(w, map[string]any{"uploadUrl": f.url + "/upload_part?fileId=" + str("fileId"), "authorizationToken": f.token})
+	case "b2_finish_large_file":
+		id := str("fileId")
+		checksums, _ := request["partSha1Array"].([]any)
+		var data []byte
+		for i := range checksums {
+			data = append(data, f.parts[id][i+1]...)
+		}
+		f.add(f.names[id], data)
+		delete(f.parts, id)
+		f.json(w, map[string]any{})
+	case "b2_list_file_names", "b2_list_file_versions":
+		f.list(w, operation == "b2_list_file_versions", request)
+	case "b2_delete_file_version":
+		for i, version := range f.versions {
+			if version.id == str("fileId") && version.name == str("fileName") {
+				f.versions = append(f.versions[:i], f.versions[i+1:]...)
+				f.json(w, map[string]any{})
+				return
+			}
+		}
+		f.error(w, http.StatusBadRequest, "file_not_present")
+	default:
+		f.t.Errorf("unexpected operation %s", operation)
+		f.error(w, http.StatusBadRequest, "bad_request")
+	}
+}
+
+// list serves b2_list_file_names, or b2_list_file_versions if versions is set
+func (f *fakeB2) list(w http.ResponseWriter, versions bool, request map[string]any) {
+	prefix, _ := request["prefix"].(string)
+	start, _ := request["startFileName"].(string)
+	max := int(request["maxFileCount"].(float64))
+
+	var files []map[string]any
+	seen := make(map[string]bool)
+	// Names are listed in order, the versions of a name newest first
+	sorted := make([]fakeB2Version, 0, len(f.versions))
+	for i := len(f.versions) - 1; i >= 0; i-- {
+		sorted = append(sorted, f.versions[i])
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].name < sorted[j].name })
+	for _, version := range sorted {
+		if !strings.HasPrefix(version.name, prefix) || version.name < start || !versions && seen[version.name] {
+			continue
+		}
+		seen[version.name] = true
+		files = append(files, map[string]any{
+			"fileId":          version.id,
+			"fileName":        version.name,
+			"action":          "upload",
+			"contentLength":   len(version.data),
+			"uploadTimestamp": time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC).UnixMilli(),
+		})
+	}
+
+	response := map[string]any{"files": files, "nextFileName": nil}
+	if len(files) > max {
+		response["files"] = files[:max]
+		response["nextFileName"] = files[max]["fileName"]
+		response["nextFileId"] = files[max]["fileId"]
+	}
+	f.json(w, response)
+}
+
+// latest returns the newest version of a file
+func (f *fakeB2) latest(name string) *fakeB2Version {
+	for i := len(f.versions) - 1; i >= 0; i-- {
+		if f.versions[i].name == name {
+			return &f.versions[i]
+		}
+	}
+	return nil
+}
+
+// add stores a new version of a file
+func (f *fakeB2) add(name string, data []byte) {
+	f.nextID++
+	f.versions = append(f.versions, fakeB2Version{id: "file-" + strconv.Itoa(f.nextID), name: name, data: data})
+}
+
+func (f *fakeB2) body(r *http.Request) []byte {
+	data, err := io.ReadAll(r.Body)
+	require.NoError(f.t, err)
+	return data
+}
+
+func (f *fakeB2) json(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (f *fakeB2) error(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"status": status, "code": code, "message": code})
+}
+
+// newFakeB2 returns a storage backed by a fake B2 API accepting the application key key-id:secret
+func newFakeB2(t *testing.T) (*B2Storage, *fakeB2) {
+	service := &fakeB2{t: t, parts: make(map[string]map[int][]byte), names: make(map[string]string)}
+	server := httptest.NewServer(service)
+	t.Cleanup(server.Close)
+	service.url = server.URL
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	s, err := NewB2Storage(&B2Config{
+		KeyID:          "key-id",
+		ApplicationKey: "secret",
+		Bucket:         "cachetf",
+		KeyPrefix:      "prod",
+		AuthEndpoint:   server.URL,
+	}, logger)
+	require.NoError(t, err)
+	return s, service
+}
+
+func TestB2Storage(t *testing.T) {
+	s, service := newFakeB2(t)
+	ctx := t.Context()
+	key := "registry.terraform.io/hashicorp/random/3.7.2/terraform-provider-random_3.7.2_linux_amd64.zip"
+
+	_, err := s.Get(ctx, key)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	exists, err := s.Exists(ctx, key)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	require.NoError(t, s.Put(ctx, key, strings.NewReader("provider")))
+	assert.NotNil(t, service.latest("prod/"+key))
+
+	exists, err = s.Exists(ctx, key)
+	require.NoError(t, err)
+	assert.True(t, exists)
+	body, err := s.Get(ctx, key)
+	require.NoError(t, err)
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	body.Close()
+	assert.Equal(t, "provider", string(data))
+
+	// Deleting a replaced file deletes its previous versions too
+	require.NoError(t, s.Put(ctx, key, strings.NewReader("provider v2")))
+	require.Len(t, service.versions, 2)
+	require.NoError(t, s.Delete(ctx, key))
+	assert.Empty(t, service.versions)
+	assert.ErrorIs(t, s.Delete(ctx, key), os.ErrNotExist)
+}
+
+func TestB2Storage_SizeTracking(t *testing.T) {
+	s, _ := newFakeB2(t)
+	assertSizeTracking(t, s, true)
+}
+
+func TestB2Storage_OverwriteSize(t *testing.T) {
+	s, service := newFakeB2(t)
+	ctx := t.Context()
+	before := testutil.ToFloat64(metrics.CacheSizeBytes)
+
+	// B2 keeps the replaced versions, overwrites only add the difference with the previous size
+	require.NoError(t, s.Put(ctx, "a", strings.NewReader("module")))
+	require.NoError(t, s.Put(ctx, "a", strings.NewReader("module v2")))
+	assert.Equal(t, before+9, testutil.ToFloat64(metrics.CacheSizeBytes))
+	require.NoError(t, s.Put(ctx, "a", strings.NewReader("mod")))
+	assert.Equal(t, before+3, testutil.ToFloat64(metrics.CacheSizeBytes))
+	require.Len(t, service.versions, 3)
+
+	require.NoError(t, s.Delete(ctx, "a"))
+	assert.Equal(t, before, testutil.ToFloat64(metrics.CacheSizeBytes))
+}
+
+func TestB2Storage_LargeFile(t *testing.T) {
+	s, service := newFakeB2(t)
+	s.partSize = 4
+
+	// Ten bytes are uploaded as three parts, eight bytes as two full parts
+	require.NoError(t, s.Put(t.Context(), "a", strings.NewReader("0123456789")))
+	assert.Equal(t, "0123456789", string(service.latest("prod/a").data))
+
+	require.NoError(t, s.Put(t.Context(), "b", strings.NewReader("01234567")))
+	assert.Equal(t, "01234567", string(service.latest("prod/b").data))
+	assert.Empty(t, service.parts)
+}
+
+func TestB2Storage_List(t *testing.T) {
+	s, _ := newFakeB2(t)
+	ctx := t.Context()
+	for _, key := range []string{"a/1", "a/2", "a/3", "a/4", "a/5", "b/1"} {
+		require.NoError(t, s.Put(ctx, key, strings.NewReader(key)))
+	}
+
+	var keys []string
+	opts := ListOptions{MaxKeys: 2}
+	for {
+		page, err := s.List(ctx, "a/", opts)
+		require.NoError(t, err)
+		for _, obj := range page.Objects {
+			keys = append(keys, obj.Key)
+			assert.Equal(t, int64(3), obj.Size)
+			assert.Equal(t, time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC), obj.LastModified)
+		}
+		if !page.IsTruncated {
+			break
+		}
+		opts.StartAfter = page.NextStartAfter
+	}
+	assert.Equal(t, []string{"a/1", "a/2", "a/3", "a/4", "a/5"}, keys)
+
+	count, err := s.DeleteByPrefix(ctx, "a/")
+	require.NoError(t, err)
+	assert.Equal(t, 5, count)
+	exists, err := s.Exists(ctx, "b/1")
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestB2Storage_ExpiredToken(t *testing.T) {
+	s, service := newFakeB2(t)
+	ctx := t.Context()
+	require.NoError(t, s.Put(ctx, "a", strings.NewReader("a")))
+
+	// Tokens expire after a day, the key is authorized again once
+	service.token = "expired"
+	exists, err := s.Exists(ctx, "a")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, 2, service.authorizations)
+
+	service.token = "expired"
+	require.NoError(t, s.Put(ctx, "b", strings.NewReader("b")))
+	assert.Equal(t, 3, service.authorizations)
+}
+
+// newFakeS3API serves a fake S3-compatible API of bucket cachetf, accepting requests signed with the access key
+// keyID for region, and returns its URL and objects
+func newFakeS3API(t *testing.T, keyID, region string) (string, map[string][]byte) {
+	// The given key is used rather than the AWS credentials of the environment
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIAEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	var mu sync.Mutex
+	objects := make(map[string][]byte)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !strings.Contains(r.Header.Get("Authorization"), "Credential="+keyID+"/") ||
+			!strings.Contains(r.Header.Get("Authorization"), "/"+region+"/s3/") {
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, `<Error><Code>InvalidAccessKeyId</Code></Error>`)
+			return
+		}
+
+		// Buckets are addressed in the path
+		name, ok := strings.CutPrefix(r.URL.Path, "/cachetf/")
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			objects[name] = body
+			w.Header().Set("ETag", `"etag"`)
+		case http.MethodGet, http.MethodHead:
+			body, ok := objects[name]
+			if !ok {
+				w.Header().Set("Content-Type", "application/xml")
+				w.WriteHeader(http.StatusNotFound)
+				if r.Method == http.MethodGet {
+					io.WriteString(w, `<Error><Code>NoSuchKey</Code></Error>`)
+				}
+				return
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			if r.Method == http.MethodGet {
+				w.Write(body)
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, objects
+}
+
+func TestB2S3Storage(t *testing.T) {
+	endpoint, objects := newFakeS3API(t, "key-id", "eu-central-003")
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	// The S3-compatible API is opt-in, the registry picks the S3 storage for it
+	cfg := &B2Config{
+		API:            B2APIS3,
+		KeyID:          "key-id",
+		ApplicationKey: "secret",
+		Bucket:         "cachetf",
+		Region:         "eu-central-003",
+		KeyPrefix:      "prod",
+		Endpoint:       endpoint,
+	}
+	store, err := New("b2", Options{Config: cfg, Logger: logger})
+	require.NoError(t, err)
+	require.IsType(t, &S3Storage{}, store)
+	s := store.(*S3Storage)
+	ctx := t.Context()
+	key := "registry.terraform.io/hashicorp/random/3.7.2/terraform-provider-random_3.7.2_linux_amd64.zip"
+
+	_, err = s.Get(ctx, key)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	require.NoError(t, s.Put(ctx, key, strings.NewReader("provider")))
+	assert.Equal(t, "provider", string(objects["prod/"+key]))
+
+	exists, err := s.Exists(ctx, key)
+	require.NoError(t, err)
+	assert.True(t, exists)
+	body, err := s.Get(ctx, key)
+	require.NoError(t, err)
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	body.Close()
+	assert.Equal(t, "provider", string(data))
+}
+
+func TestB2Config_S3Config(t *testing.T) {
+	cfg := B2Config{KeyID: "key-id", ApplicationKey: "secret", Bucket: "cachetf", Region: "us-west-004", KeyPrefix: "cold/"}
+	assert.Equal(t, &S3Config{
+		Bucket:          "cachetf",
+		Region:          "us-west-004",
+		KeyPrefix:       "cold/",
+		Endpoint:        "https://s3.us-west-004.backblazeb2.com",
+		UsePathStyle:    true,
+		AccessKeyID:     "key-id",
+		SecretAccessKey: "secret",
+	}, cfg.S3Config())
+
+	cfg.Endpoint = "https://b2.example.com"
+	assert.Equal(t, "https://b2.example.com", cfg.S3Config().Endpoint)
+}
+
+func TestValidateB2Config(t *testing.T) {
+	assert.NoError(t, ValidateB2Config(&B2Config{KeyID: "id", ApplicationKey: "key", Bucket: "cachetf"}))
+	assert.NoError(t, ValidateB2Config(&B2Config{API: B2APINative, KeyID: "id", ApplicationKey: "key", Bucket: "cachetf"}))
+	assert.Error(t, ValidateB2Config(&B2Config{KeyID: "id", Bucket: "cachetf"}))
+	assert.Error(t, ValidateB2Config(&B2Config{KeyID: "id", ApplicationKey: "key"}))
+	assert.ErrorContains(t, ValidateB2Config(&B2Config{KeyID: "id", ApplicationKey: "key", Bucket: "cachetf", Region: "us-west-004"}), "only apply to the S3-compatible API")
+	assert.ErrorContains(t, ValidateB2Config(&B2Config{API: "b2", KeyID: "id", ApplicationKey: "key", Bucket: "cachetf"}), "invalid API")
+
+	// The S3-compatible API requires the region of the bucket
+	assert.NoError(t, ValidateB2Config(&B2Config{API: B2APIS3, KeyID: "id", ApplicationKey: "key", Bucket: "cachetf", Region: "us-west-004"}))
+	assert.ErrorContains(t, ValidateB2Config(&B2Config{API: B2APIS3, KeyID: "id", ApplicationKey: "key", Bucket: "cachetf"}), "invalid region")
+	assert.ErrorContains(t, ValidateB2Config(&B2Config{API: B2APIS3, KeyID: "id", ApplicationKey: "key", Bucket: "cachetf", Region: "s3.us-west-004"}), "invalid region")
+
+	_, err := NewB2Storage(&B2Config{KeyID: "key-id", ApplicationKey: "wrong", Bucket: "cachetf", AuthEndpoint: "http://127.0.0.1:1"}, logrus.New())
+	assert.Error(t, err)
+}
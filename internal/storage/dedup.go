@@ -0,0 +1,345 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/pkg/logger"
+)
+
+// Deduplicated local storage keeps the content of the files once, as blobs named by their SHA-256 under the blobs
+// directory, and the keys as hard links to their blob. Registries mirroring the same providers, e.g. through several
+// hostnames, share the disk space of the identical zips. On file systems without hard links the keys are symbolic
+// links to their blob.
+
+// blobsDir is the directory of the blobs, relative to the base directory
+const blobsDir = "blobs"
+
+// tempBlobPrefix starts the names of the files blobs are written to before their hash is known
+const tempBlobPrefix = ".tmp-"
+
+// EnableDedup stores the files written from now on by content. The files cached before are counted in the sizes
+// of the metrics, and deduplicated when they're written again. Keys under blobs/ are rejected once enabled.
+func (s *LocalStorage) EnableDedup() error {
+	root := s.blobsRoot()
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return fmt.Errorf("failed to create blobs directory: %w", err)
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return fmt.Errorf("failed to create blobs directory: %w", err)
+	}
+	if _, ok := hardLinks(info); !ok {
+		return fmt.Errorf("deduplication of local storage isn't supported on this platform")
+	}
+
+	logical, stored, err := s.scanDedupSize(s.baseDir)
+	if err != nil {
+		return fmt.Errorf("failed to scan cache directory: %w", err)
+	}
+	s.dedup = true
+	s.addDedupSize(logical, stored)
+
+	s.logger.WithFields(logrus.Fields{
+		"logical_size": logical,
+		"stored_size":  stored,
+	}).Info("Enabled deduplication of local storage")
+	return nil
+}
+
+// blobsRoot returns the directory of the blobs
+func (s *LocalStorage) blobsRoot() string {
+	return filepath.Join(s.baseDir, blobsDir)
+}
+
+// blobPath returns the path of the blob of a hash, spread over directories named by its first byte
+func (s *LocalStorage) blobPath(sum string) string {
+	return filepath.Join(s.blobsRoot(), sum[:2], sum)
+}
+
+// blobMutex returns the mutex serializing the creation and removal of a blob with the keys linked to it
+func (s *LocalStorage) blobMutex(sum string) *sync.Mutex {
+	// Keys under blobs/ are rejected, so the mutexes of blobs can't be the ones of keys
+	return s.getMutex(blobsDir + "/" + sum)
+}
+
+// isBlobPath returns true if path is the blobs directory or under it
+func (s *LocalStorage) isBlobPath(path string) bool {
+	root := s.blobsRoot()
+	return path == root || strings.HasPrefix(path, root+string(filepath.Separator))
+}
+
+// addDedupSize adds to the logical and stored sizes
+func (s *LocalStorage) addDedupSize(logical, stored int64) {
+	s.logicalSize.Add(logical)
+	s.storedSize.Add(stored)
+	s.metrics.AddDedupSize(logical, stored)
+}
+
+// putDeduplicated writes a file to its blob, and links the key to it
+func (s *LocalStorage) putDeduplicated(path string, r io.Reader) error {
+	root := s.blobsRoot()
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	temp, err := os.CreateTemp(root, tempBlobPrefix+"*")
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %w", path, err)
+	}
+	// Nothing is left behind once the file is renamed to its blob
+	defer os.Remove(temp.Name())
+
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(temp, hash), r)
+	if err != nil {
+		temp.Close()
+		s.logger.WithError(err).WithField("path", path).Error("Failed to write file content")
+		return fmt.Errorf("failed to write file content: %w", err)
+	}
+	if err := temp.Sync(); err != nil {
+		s.logger.WithError(err).WithField("path", path).Error("Failed to sync file to disk")
+	}
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("failed to write file content: %w", err)
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	blob := s.blobPath(sum)
+	mutex := s.blobMutex(sum)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	_, err = os.Stat(blob)
+	deduplicated := err == nil
+	switch {
+	case os.IsNotExist(err):
+		if err := os.MkdirAll(filepath.Dir(blob), 0755); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+		if err := os.Rename(temp.Name(), blob); err != nil {
+			return fmt.Errorf("failed to store blob %s: %w", sum, err)
+		}
+		s.addDedupSize(0, n)
+	case err != nil:
+		return fmt.Errorf("error checking blob %s: %w", sum, err)
+	}
+
+	// A symbolic link to a collected blob is replaced
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to replace file %s: %w", path, err)
+	}
+	if err := linkBlob(blob, path); err != nil {
+		return err
+	}
+	s.addDedupSize(n, 0)
+	s.addSize(n)
+
+	logger.Debug(s.logger, "Successfully stored file in cache", func() logrus.Fields {
+		return logrus.Fields{
+			"path":         path,
+			"size":         n,
+			"blob":         sum,
+			"deduplicated": deduplicated,
+		}
+	})
+	return nil
+}
+
+// linkBlob links a key to its blob, with a symbolic link if the file system doesn't support hard links
+func linkBlob(blob, path string) error {
+	err := os.Link(blob, path)
+	if err == nil {
+		return nil
+	}
+	target, relErr := filepath.Rel(filepath.Dir(path), blob)
+	if relErr != nil {
+		return fmt.Errorf("failed to link %s to its blob: %w", path, err)
+	}
+	if symlinkErr := os.Symlink(target, path); symlinkErr != nil {
+		return fmt.Errorf("failed to link %s to its blob: %w", path, err)
+	}
+	return nil
+}
+
+// removeDeduplicated removes a key, and its blob if no other key links to it. Blobs of symbolic links are left to
+// CollectBlobs, as the other links to them are unknown.
+func (s *LocalStorage) removeDeduplicated(path string, info os.FileInfo) error {
+	linkInfo, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	var stored int64
+	var sum string
+	if linkInfo.Mode()&os.ModeSymlink == 0 {
+		switch links, _ := hardLinks(linkInfo); links {
+		case 1:
+			// A file cached before deduplication was enabled
+			stored = info.Size()
+		case 2:
+			// The last key linked to its blob
+			if sum, err = hashFile(path); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	s.addDedupSize(-info.Size(), -stored)
+	if sum != "" {
+		s.removeBlob(sum, nil)
+	}
+	return nil
+}
+
+// removeBlob removes a blob if no key links to it anymore, returning true if it was removed. Blobs in linked are
+// the targets of symbolic links.
+func (s *LocalStorage) removeBlob(sum string, linked map[string]bool) bool {
+	mutex := s.blobMutex(sum)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	blob := s.blobPath(sum)
+	info, err := os.Lstat(blob)
+	if err != nil || linked[blob] {
+		return false
+	}
+	if links, _ := hardLinks(info); links != 1 {
+		return false
+	}
+	if err := os.Remove(blob); err != nil {
+		s.logger.WithError(err).WithField("blob", sum).Warn("Failed to remove unreferenced blob")
+		return false
+	}
+	s.addDedupSize(0, -info.Size())
+
+	logger.Debug(s.logger, "Removed unreferenced blob", func() logrus.Fields {
+		return logrus.Fields{"blob": sum, "size": info.Size()}
+	})
+	return true
+}
+
+// CollectBlobs removes the blobs no key links to anymore, returning their number. It's run after files are deleted
+// by prefix, and removes the blobs of deleted symbolic links.
+func (s *LocalStorage) CollectBlobs(ctx context.Context) (int, error) {
+	root := s.blobsRoot()
+
+	// The blobs symbolic links point to, which have a single hard link like the unreferenced ones
+	linked := make(map[string]bool)
+	err := filepath.Walk(s.baseDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if info.IsDir() && p == root {
+			return filepath.SkipDir
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return nil
+		}
+		target, err := os.Readlink(p)
+		if err != nil {
+			return err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(p), target)
+		}
+		linked[filepath.Clean(target)] = true
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error walking directory %s: %w", s.baseDir, err)
+	}
+
+	var removed int
+	err = filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Blobs aren't stored until the first deduplicated write
+			if os.IsNotExist(err) && p == root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// Files written to blobs are skipped, as well as anything not named by a hash
+		if info.IsDir() || len(info.Name()) != hex.EncodedLen(sha256.Size) {
+			return nil
+		}
+		if s.removeBlob(info.Name(), linked) {
+			removed++
+		}
+		return nil
+	})
+	if err != nil {
+		return removed, fmt.Errorf("error walking directory %s: %w", root, err)
+	}
+
+	if removed > 0 {
+		s.logger.WithField("count", removed).Info("Collected unreferenced blobs")
+	}
+	return removed, nil
+}
+
+// scanDedupSize returns the logical size of the files under root, and the disk space they and the blobs under it
+// use. Files with a single hard link are counted in both, as they aren't linked to a blob.
+func (s *LocalStorage) scanDedupSize(root string) (logical, stored int64, err error) {
+	err = filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if s.isBlobPath(p) {
+			if !strings.HasPrefix(info.Name(), tempBlobPrefix) {
+				stored += info.Size()
+			}
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if target, err := os.Stat(p); err == nil {
+				logical += target.Size()
+			}
+			return nil
+		}
+		logical += info.Size()
+		if links, _ := hardLinks(info); links == 1 {
+			stored += info.Size()
+		}
+		return nil
+	})
+	return logical, stored, err
+}
+
+// hashFile returns the hex encoded SHA-256 of a file
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", fmt.Errorf("failed to hash file %s: %w", path, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package storage
+
+import "os"
+
+// hardLinks returns false, the number of hard links of a file isn't known on this platform
+func hardLinks(info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
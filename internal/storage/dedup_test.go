@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupDedupStorage(t *testing.T) (*LocalStorage, string) {
+	t.Helper()
+	s, dir := setupLocalStorage(t)
+	require.NoError(t, s.EnableDedup())
+	return s, dir
+}
+
+// blobOf returns the path of the blob of content
+func blobOf(dir, content string) string {
+	sum := sha256.Sum256([]byte(content))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(dir, blobsDir, name[:2], name)
+}
+
+func TestLocalStorage_Dedup(t *testing.T) {
+	s, dir := setupDedupStorage(t)
+	ctx := context.Background()
+	keys := []string{
+		"providers/registry.terraform.io/hashicorp/random/3.7.2/terraform-provider-random_3.7.2_linux_amd64.zip",
+		"providers/mirror.example.com/hashicorp/random/3.7.2/terraform-provider-random_3.7.2_linux_amd64.zip",
+	}
+
+	for _, key := range keys {
+		require.NoError(t, s.Put(ctx, key, strings.NewReader("provider")))
+	}
+	require.NoError(t, s.Put(ctx, "providers/other", strings.NewReader("other")))
+
+	// Identical files share their blob
+	blob, err := os.Stat(blobOf(dir, "provider"))
+	require.NoError(t, err)
+	for _, key := range keys {
+		info, err := os.Stat(filepath.Join(dir, key))
+		require.NoError(t, err)
+		assert.True(t, os.SameFile(blob, info))
+
+		body, err := s.Get(ctx, key)
+		require.NoError(t, err)
+		data, err := io.ReadAll(body)
+		body.Close()
+		require.NoError(t, err)
+		assert.Equal(t, "provider", string(data))
+	}
+	assert.Equal(t, int64(2*len("provider")+len("other")), s.logicalSize.Load())
+	assert.Equal(t, int64(len("provider")+len("other")), s.storedSize.Load())
+
+	// Blobs aren't keys
+	page, err := s.List(ctx, "", ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, page.Objects, 3)
+	for _, obj := range page.Objects {
+		assert.True(t, strings.HasPrefix(obj.Key, "providers/"), obj.Key)
+	}
+	_, err = s.Get(ctx, "blobs/anything")
+	assert.Error(t, err)
+
+	// The blob is kept until the last key linked to it is deleted
+	require.NoError(t, s.Delete(ctx, keys[0]))
+	_, err = os.Stat(blobOf(dir, "provider"))
+	assert.NoError(t, err)
+	require.NoError(t, s.Delete(ctx, keys[1]))
+	_, err = os.Stat(blobOf(dir, "provider"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.Equal(t, int64(len("other")), s.logicalSize.Load())
+	assert.Equal(t, int64(len("other")), s.storedSize.Load())
+
+	// Deleting by prefix collects the blobs
+	count, err := s.DeleteByPrefix(ctx, "providers")
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	_, err = os.Stat(blobOf(dir, "other"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.Zero(t, s.logicalSize.Load())
+	assert.Zero(t, s.storedSize.Load())
+
+	// Nothing is left behind in the blobs directory but the directories of the hashes
+	err = filepath.Walk(filepath.Join(dir, blobsDir), func(p string, info os.FileInfo, err error) error {
+		require.NoError(t, err)
+		assert.True(t, info.IsDir(), p)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestLocalStorage_DedupSizeTracking(t *testing.T) {
+	s, _ := setupDedupStorage(t)
+	assertSizeTracking(t, s, true)
+}
+
+func TestLocalStorage_DedupExistingFiles(t *testing.T) {
+	s, dir := setupLocalStorage(t)
+	ctx := context.Background()
+
+	// Files cached before deduplication is enabled are counted as stored
+	require.NoError(t, s.Put(ctx, "a/1", strings.NewReader("content")))
+	require.NoError(t, s.EnableDedup())
+	assert.Equal(t, int64(len("content")), s.logicalSize.Load())
+	assert.Equal(t, int64(len("content")), s.storedSize.Load())
+
+	require.NoError(t, s.Put(ctx, "a/2", strings.NewReader("content")))
+	assert.Equal(t, int64(2*len("content")), s.logicalSize.Load())
+	assert.Equal(t, int64(2*len("content")), s.storedSize.Load())
+
+	// A storage of the same directory counts the blob and its link once
+	other := NewLocalStorage(dir, s.logger)
+	require.NoError(t, other.EnableDedup())
+	assert.Equal(t, s.logicalSize.Load(), other.logicalSize.Load())
+	assert.Equal(t, s.storedSize.Load(), other.storedSize.Load())
+
+	require.NoError(t, s.Delete(ctx, "a/1"))
+	assert.Equal(t, int64(len("content")), s.logicalSize.Load())
+	assert.Equal(t, int64(len("content")), s.storedSize.Load())
+}
+
+func TestLocalStorage_DedupSymlinks(t *testing.T) {
+	s, dir := setupDedupStorage(t)
+	ctx := context.Background()
+
+	// Keys linked to their blob with a symbolic link, as on file systems without hard links
+	require.NoError(t, s.Put(ctx, "a/1", strings.NewReader("content")))
+	link := filepath.Join(dir, "a", "2")
+	target, err := filepath.Rel(filepath.Dir(link), blobOf(dir, "content"))
+	require.NoError(t, err)
+	require.NoError(t, os.Symlink(target, link))
+
+	page, err := s.List(ctx, "a/", ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, page.Objects, 2)
+	assert.Equal(t, int64(len("content")), page.Objects[1].Size)
+
+	// The blob is kept while a symbolic link points to it
+	require.NoError(t, os.Remove(filepath.Join(dir, "a", "1")))
+	removed, err := s.CollectBlobs(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, removed)
+	body, err := s.Get(ctx, "a/2")
+	require.NoError(t, err)
+	data, err := io.ReadAll(body)
+	body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(data))
+
+	require.NoError(t, os.Remove(link))
+	removed, err = s.CollectBlobs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+
+	// A dangling link is replaced when the file is cached again
+	require.NoError(t, os.Symlink(target, link))
+	exists, err := s.Exists(ctx, "a/2")
+	require.NoError(t, err)
+	assert.False(t, exists)
+	require.NoError(t, s.Put(ctx, "a/2", strings.NewReader("content")))
+	info, err := os.Lstat(link)
+	require.NoError(t, err)
+	assert.True(t, info.Mode().IsRegular())
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/metrics"
+)
+
+// ErrInsufficientSpace is returned by the writes of local storage that would leave less free disk space than the
+// reserve. Nothing was read from the writer, callers can still write the content elsewhere or serve it.
+var ErrInsufficientSpace = errors.New("insufficient disk space")
+
+// SpaceReclaimer frees disk space, e.g. by evicting cached files
+type SpaceReclaimer interface {
+	// Reclaim deletes files until at least bytes are freed, or no more can be
+	Reclaim(ctx context.Context, bytes int64) error
+}
+
+// DiskGuarded is implemented by the backends keeping a disk space reserve, see LocalStorage.EnableDiskGuard
+type DiskGuarded interface {
+	// UseSpaceReclaimer makes the backend reclaim disk space before rejecting a write
+	UseSpaceReclaimer(reclaimer SpaceReclaimer)
+}
+
+// EnableDiskGuard makes the writes keep reserve bytes of the disk free. A write finding less free space reclaims
+// the missing space if a SpaceReclaimer is set, and fails with ErrInsufficientSpace otherwise, instead of filling
+// the disk and failing midway.
+func (s *LocalStorage) EnableDiskGuard(reserve int64) error {
+	if _, err := availableSpace(s.baseDir); err != nil {
+		return fmt.Errorf("failed to check the free disk space: %w", err)
+	}
+	s.diskReserve = reserve
+
+	s.logger.WithField("reserve", reserve).Info("Enabled disk space guard of local storage")
+	return nil
+}
+
+// UseSpaceReclaimer makes the writes reclaim disk space before failing with ErrInsufficientSpace
+func (s *LocalStorage) UseSpaceReclaimer(reclaimer SpaceReclaimer) {
+	s.reclaimer = reclaimer
+}
+
+// checkDiskSpace returns ErrInsufficientSpace if writing r would leave less free space than the reserve. The size
+// of readers knowing their length, like the downloaded files, counts towards the space needed.
+func (s *LocalStorage) checkDiskSpace(ctx context.Context, r io.Reader) error {
+	if s.diskReserve <= 0 {
+		return nil
+	}
+	var size int64
+	if sized, ok := r.(interface{ Len() int }); ok {
+		size = int64(sized.Len())
+	}
+
+	available, err := availableSpace(s.baseDir)
+	if err != nil {
+		// The write fails on its own if the disk is full
+		s.logger.WithError(err).Warn("Failed to check the free disk space")
+		return nil
+	}
+	missing := s.diskReserve + size - available
+	if missing <= 0 {
+		return nil
+	}
+
+	if s.reclaimer != nil {
+		if err := s.reclaimer.Reclaim(ctx, missing); err != nil {
+			s.logger.WithError(err).Warn("Failed to reclaim disk space")
+		}
+		if available, err = availableSpace(s.baseDir); err == nil && s.diskReserve+size <= available {
+			metrics.CacheDiskFullTotal.WithLabelValues("reclaimed").Inc()
+			return nil
+		}
+	}
+
+	metrics.CacheDiskFullTotal.WithLabelValues("rejected").Inc()
+	s.logger.WithFields(logrus.Fields{
+		"available": available,
+		"reserve":   s.diskReserve,
+		"size":      size,
+	}).Warn("Not enough free disk space to cache the file")
+	return ErrInsufficientSpace
+}
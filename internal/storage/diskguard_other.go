@@ -0,0 +1,10 @@
+//go:build !unix
+
+package storage
+
+import "errors"
+
+// availableSpace returns an error, the free disk space isn't known on this platform
+func availableSpace(path string) (int64, error) {
+	return 0, errors.New("checking the free disk space isn't supported on this platform")
+}
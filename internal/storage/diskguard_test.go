@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/metrics"
+)
+
+// reclaimFunc adapts a function to SpaceReclaimer
+type reclaimFunc func(ctx context.Context, bytes int64) error
+
+func (f reclaimFunc) Reclaim(ctx context.Context, bytes int64) error {
+	return f(ctx, bytes)
+}
+
+// noDisk is a reserve larger than any disk
+const noDisk = 1 << 62
+
+func TestLocalStorage_DiskGuard(t *testing.T) {
+	s, _ := setupLocalStorage(t)
+	ctx := context.Background()
+	require.NoError(t, s.Put(ctx, "a.zip", strings.NewReader("a")))
+
+	// Writes leaving less free space than the reserve are rejected
+	require.NoError(t, s.EnableDiskGuard(noDisk))
+	before := testutil.ToFloat64(metrics.CacheDiskFullTotal.WithLabelValues("rejected"))
+	assert.ErrorIs(t, s.Put(ctx, "b.zip", strings.NewReader("b")), ErrInsufficientSpace)
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.CacheDiskFullTotal.WithLabelValues("rejected")))
+	exists, err := s.Exists(ctx, "b.zip")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	// Files already cached don't need more space
+	require.NoError(t, s.Put(ctx, "a.zip", strings.NewReader("a")))
+
+	// A reclaimer failing to free enough space doesn't help
+	s.UseSpaceReclaimer(reclaimFunc(func(context.Context, int64) error { return errors.New("nothing to evict") }))
+	assert.ErrorIs(t, s.Put(ctx, "b.zip", strings.NewReader("b")), ErrInsufficientSpace)
+
+	// The write proceeds once the reclaimer freed enough space
+	var reclaimed int64
+	s.UseSpaceReclaimer(reclaimFunc(func(_ context.Context, bytes int64) error {
+		reclaimed = bytes
+		s.diskReserve = 0
+		return nil
+	}))
+	before = testutil.ToFloat64(metrics.CacheDiskFullTotal.WithLabelValues("reclaimed"))
+	s.diskReserve = noDisk
+	require.NoError(t, s.Put(ctx, "b.zip", strings.NewReader("b")))
+	assert.Greater(t, reclaimed, int64(1), "the size of the file counts towards the missing space")
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.CacheDiskFullTotal.WithLabelValues("reclaimed")))
+	assert.Equal(t, "b", readAll(t, s, "b.zip"))
+}
+
+func TestTieredStorage_DiskGuard(t *testing.T) {
+	s, local, remote := setupTieredStorage(t)
+	ctx := context.Background()
+	require.NoError(t, local.EnableDiskGuard(noDisk))
+
+	// Files not fitting on the local disk are only written to the remote tier
+	require.NoError(t, s.Put(ctx, "a.zip", strings.NewReader("provider")))
+	assert.Equal(t, "provider", readAll(t, remote, "a.zip"))
+	exists, err := local.Exists(ctx, "a.zip")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	// and read from there
+	assert.Equal(t, "provider", readAll(t, s, "a.zip"))
+}
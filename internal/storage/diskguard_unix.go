@@ -0,0 +1,14 @@
+//go:build unix
+
+package storage
+
+import "syscall"
+
+// availableSpace returns the disk space available to unprivileged users on the file system of path
+func availableSpace(path string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/metrics"
+)
+
+// failoverReader reads a file from a primary storage, switching to a secondary storage holding the same file if
+// reading the primary fails midway, e.g. on a disk error. The part of the file already read is skipped, so the
+// client receives the file whole instead of a truncated response.
+type failoverReader struct {
+	ctx       context.Context
+	key       string
+	r         io.ReadCloser
+	secondary Storage
+	// read is the number of bytes of the file already returned
+	read int64
+	// failedOver is set once the secondary storage is read, which isn't failed over again
+	failedOver bool
+	logger     *logrus.Logger
+}
+
+// newFailoverReader returns a reader of a file of a primary storage, failing over to the secondary storage
+func newFailoverReader(ctx context.Context, key string, r io.ReadCloser, secondary Storage, logger *logrus.Logger) *failoverReader {
+	return &failoverReader{
+		ctx:       ctx,
+		key:       key,
+		r:         r,
+		secondary: secondary,
+		logger:    logger,
+	}
+}
+
+func (f *failoverReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	f.read += int64(n)
+	if err == nil || errors.Is(err, io.EOF) || f.failedOver || f.ctx.Err() != nil {
+		return n, err
+	}
+
+	f.failedOver = true
+	if failoverErr := f.failover(); failoverErr != nil {
+		f.logger.WithError(failoverErr).WithField("key", f.key).Warn("Failed to fail over to the remote tier")
+		return n, err
+	}
+	metrics.TieredFailoversTotal.WithLabelValues("read").Inc()
+	f.logger.WithError(err).WithFields(logrus.Fields{
+		"key":    f.key,
+		"offset": f.read,
+	}).Warn("Failed to read from the local tier, reading the rest from the remote tier")
+
+	if n > 0 {
+		return n, nil
+	}
+	return f.Read(p)
+}
+
+// failover replaces the primary reader with a reader of the secondary storage at the same offset
+func (f *failoverReader) failover() error {
+	r, err := f.secondary.Get(f.ctx, f.key)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(io.Discard, r, f.read); err != nil {
+		r.Close()
+		return fmt.Errorf("failed to skip the %d bytes already read: %w", f.read, err)
+	}
+	f.r.Close()
+	f.r = r
+	return nil
+}
+
+func (f *failoverReader) Close() error {
+	return f.r.Close()
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/metrics"
+)
+
+// brokenStorage returns readers failing after a number of bytes, like a disk with bad sectors
+type brokenStorage struct {
+	Storage
+	failAfter int64
+}
+
+func (s *brokenStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	r, err := s.Storage.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return &brokenReader{r: r, remaining: s.failAfter}, nil
+}
+
+type brokenReader struct {
+	r         io.ReadCloser
+	remaining int64
+}
+
+func (r *brokenReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, errors.New("input/output error")
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.r.Read(p)
+	r.remaining -= int64(n)
+	return n, err
+}
+
+func (r *brokenReader) Close() error {
+	return r.r.Close()
+}
+
+func TestTieredStorage_FailoverMidRead(t *testing.T) {
+	local, _ := setupLocalStorage(t)
+	remote, _ := setupLocalStorage(t)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	tiered := NewTieredStorage(&brokenStorage{Storage: local, failAfter: 4}, remote, nil, logger)
+	ctx := context.Background()
+	require.NoError(t, local.Put(ctx, "a/b.zip", strings.NewReader("provider binary")))
+	require.NoError(t, remote.Put(ctx, "a/b.zip", strings.NewReader("provider binary")))
+
+	before := testutil.ToFloat64(metrics.TieredFailoversTotal.WithLabelValues("read"))
+	assert.Equal(t, "provider binary", readAll(t, tiered, "a/b.zip"))
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.TieredFailoversTotal.WithLabelValues("read")))
+
+	// Files read whole from the local tier don't fail over
+	tiered = NewTieredStorage(&brokenStorage{Storage: local, failAfter: 1 << 20}, remote, nil, logger)
+	assert.Equal(t, "provider binary", readAll(t, tiered, "a/b.zip"))
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.TieredFailoversTotal.WithLabelValues("read")))
+}
+
+func TestFailoverReader(t *testing.T) {
+	local, _ := setupLocalStorage(t)
+	remote, _ := setupLocalStorage(t)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	ctx := context.Background()
+	require.NoError(t, local.Put(ctx, "key", strings.NewReader("content")))
+
+	// Without a copy in the secondary storage, the read error is returned
+	r, err := (&brokenStorage{Storage: local, failAfter: 3}).Get(ctx, "key")
+	require.NoError(t, err)
+	f := newFailoverReader(ctx, "key", r, remote, logger)
+	data, err := io.ReadAll(f)
+	assert.EqualError(t, err, "input/output error")
+	assert.Equal(t, "con", string(data))
+	require.NoError(t, f.Close())
+
+	// A secondary copy shorter than what was read already can't be used
+	require.NoError(t, remote.Put(ctx, "key", strings.NewReader("co")))
+	r, err = (&brokenStorage{Storage: local, failAfter: 3}).Get(ctx, "key")
+	require.NoError(t, err)
+	f = newFailoverReader(ctx, "key", r, remote, logger)
+	_, err = io.ReadAll(f)
+	assert.EqualError(t, err, "input/output error")
+	require.NoError(t, f.Close())
+
+	// Reads of canceled requests don't fail over
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	require.NoError(t, remote.Delete(ctx, "key"))
+	require.NoError(t, remote.Put(ctx, "key", strings.NewReader("content")))
+	r, err = (&brokenStorage{Storage: local, failAfter: 3}).Get(ctx, "key")
+	require.NoError(t, err)
+	f = newFailoverReader(canceled, "key", r, remote, logger)
+	_, err = io.ReadAll(f)
+	assert.EqualError(t, err, "input/output error")
+	require.NoError(t, f.Close())
+}
@@ -0,0 +1,397 @@
+package storage
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/metrics"
+)
+
+// errMmapUnsupported is returned by mapPath on platforms without memory mappings
+var errMmapUnsupported = errors.New("memory mapping files isn't supported on this platform")
+
+// HotCacheOptions configures a HotCache
+type HotCacheOptions struct {
+	// Dir is the directory the hot files are copied to, it's emptied on startup
+	Dir string
+	// MaxBytes is the total size of the hot files, the least recently used ones are dropped above it
+	MaxBytes int64
+	// MinObjectBytes is the size of the smallest file copied to the hot cache, smaller files are cheap to read
+	// from the storage or the memory cache
+	MinObjectBytes int64
+	// TTL is how long a file is served from the hot cache before it's read from the storage again, so changes
+	// made by other instances sharing the storage show up. Files never expire if zero.
+	TTL time.Duration
+}
+
+// hotEntry is a file copied to the hot cache directory
+type hotEntry struct {
+	key     string
+	path    string
+	size    int64
+	expires time.Time
+	// data is the memory mapping of the file, nil where files can't be mapped
+	data []byte
+	// readers is the number of open readers, the file is only removed once they're closed
+	readers int
+	// dropped is set once the entry is no longer cached
+	dropped bool
+}
+
+// HotCache copies the large files read from a Storage to a local directory and serves the next reads from a
+// memory mapping of the copy, shared by all the readers of the file and by the processes of the pod through the
+// page cache. It's meant for a few huge zips served over and over from remote storage, without setting up a
+// tiered storage. Writes and deletions go to the storage and drop the hot copies.
+type HotCache struct {
+	s       Storage
+	options HotCacheOptions
+	logger  *logrus.Logger
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// lru holds the entries, the most recently used first
+	lru  *list.List
+	size int64
+	// generation changes on every invalidation, so reads that started before it don't cache stale content
+	generation uint64
+}
+
+// NewHotCache creates a HotCache in front of s, removing the files left in the directory by a previous run
+func NewHotCache(s Storage, options HotCacheOptions, logger *logrus.Logger) (*HotCache, error) {
+	if options.Dir == "" {
+		return nil, errors.New("hot cache requires a directory")
+	}
+	if options.MaxBytes <= 0 || options.MinObjectBytes <= 0 || options.MinObjectBytes > options.MaxBytes {
+		return nil, errors.New("hot cache requires a positive size and a minimum object size not exceeding it")
+	}
+
+	// The hot files are only known in memory, the ones of a previous run are stale
+	if err := os.RemoveAll(options.Dir); err != nil {
+		return nil, fmt.Errorf("failed to empty hot cache directory: %w", err)
+	}
+	if err := os.MkdirAll(options.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create hot cache directory: %w", err)
+	}
+
+	logger.WithFields(logrus.Fields{
+		"dir":            options.Dir,
+		"maxBytes":       options.MaxBytes,
+		"minObjectBytes": options.MinObjectBytes,
+	}).Info("Hot cache enabled")
+	return &HotCache{
+		s:       s,
+		options: options,
+		logger:  logger,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}, nil
+}
+
+// acquire returns the entry of a hot file and counts a reader of it, which must release it
+func (h *HotCache) acquire(key string) (*hotEntry, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	element, ok := h.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*hotEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		h.remove(element)
+		return nil, false
+	}
+	h.lru.MoveToFront(element)
+	entry.readers++
+	return entry, true
+}
+
+// release forgets a reader of an entry, removing the copy if it was the last reader of a dropped entry
+func (h *HotCache) release(entry *hotEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entry.readers--
+	h.discard(entry)
+}
+
+// discard unmaps and removes the copy of a dropped entry without readers, the lock must be held
+func (h *HotCache) discard(entry *hotEntry) {
+	if !entry.dropped || entry.readers > 0 {
+		return
+	}
+	if entry.data != nil {
+		if err := unmapFile(entry.data); err != nil {
+			h.logger.WithError(err).WithField("key", entry.key).Warn("Failed to unmap hot file")
+		}
+		entry.data = nil
+	}
+	if err := os.Remove(entry.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		h.logger.WithError(err).WithField("key", entry.key).Warn("Failed to remove hot file")
+	}
+}
+
+// remove drops an entry, the lock must be held
+func (h *HotCache) remove(element *list.Element) {
+	entry := h.lru.Remove(element).(*hotEntry)
+	delete(h.entries, entry.key)
+	h.size -= entry.size
+	metrics.HotCacheBytes.Sub(float64(entry.size))
+	entry.dropped = true
+	h.discard(entry)
+}
+
+// admit caches the copy of a file read at the given generation, the copy is removed if it's not cached. Every
+// copy has its own path, so the copy of a dropped entry still being read doesn't clash with a newer one.
+func (h *HotCache) admit(key, path string, size int64, generation uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Changed while it was read, or cached by a concurrent read already
+	if _, cached := h.entries[key]; cached || generation != h.generation {
+		os.Remove(path)
+		return
+	}
+
+	entry := &hotEntry{key: key, path: path, size: size}
+	if h.options.TTL > 0 {
+		entry.expires = time.Now().Add(h.options.TTL)
+	}
+	// Files which can't be mapped are opened by every reader instead
+	if data, err := mapPath(path, size); err == nil {
+		entry.data = data
+	} else if !errors.Is(err, errMmapUnsupported) {
+		h.logger.WithError(err).WithField("key", key).Warn("Failed to map hot file")
+	}
+
+	h.entries[key] = h.lru.PushFront(entry)
+	h.size += size
+	metrics.HotCacheBytes.Add(float64(size))
+
+	for h.size > h.options.MaxBytes {
+		h.remove(h.lru.Back())
+	}
+}
+
+// invalidate drops the hot files whose key starts with prefix, or the file with the key if exact
+func (h *HotCache) invalidate(prefix string, exact bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.generation++
+	if exact {
+		if element, ok := h.entries[prefix]; ok {
+			h.remove(element)
+		}
+		return
+	}
+	for key, element := range h.entries {
+		if strings.HasPrefix(key, prefix) {
+			h.remove(element)
+		}
+	}
+}
+
+// Get serves hot files from their memory mapping. Other files are read from the storage, and large ones are
+// copied to the hot cache while they're read.
+func (h *HotCache) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if entry, ok := h.acquire(key); ok {
+		r, err := h.open(entry)
+		if err == nil {
+			metrics.HotCacheRequestsTotal.WithLabelValues("hit").Inc()
+			return r, nil
+		}
+		h.release(entry)
+		h.logger.WithError(err).WithField("key", key).Warn("Failed to read hot file, reading from the storage")
+		h.invalidate(key, true)
+	}
+	metrics.HotCacheRequestsTotal.WithLabelValues("miss").Inc()
+
+	h.mu.Lock()
+	generation := h.generation
+	h.mu.Unlock()
+
+	r, err := h.s.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return &hotFill{ReadCloser: r, cache: h, key: key, generation: generation}, nil
+}
+
+// open returns a reader of a hot file, releasing the entry when closed
+func (h *HotCache) open(entry *hotEntry) (io.ReadCloser, error) {
+	if entry.data != nil {
+		return &hotReader{Reader: bytes.NewReader(entry.data), cache: h, entry: entry}, nil
+	}
+	f, err := os.Open(entry.path)
+	if err != nil {
+		return nil, err
+	}
+	return &hotReader{Reader: f, file: f, cache: h, entry: entry}, nil
+}
+
+// hotReader reads a hot file, from its memory mapping or the copy itself
+type hotReader struct {
+	io.Reader
+	// file is the copy, nil when reading the memory mapping
+	file  *os.File
+	cache *HotCache
+	entry *hotEntry
+	once  sync.Once
+}
+
+// Stat returns the file info of the copy, so the size of the file is known before it's read
+func (r *hotReader) Stat() (os.FileInfo, error) {
+	if r.file != nil {
+		return r.file.Stat()
+	}
+	return os.Stat(r.entry.path)
+}
+
+func (r *hotReader) Close() error {
+	var err error
+	r.once.Do(func() {
+		if r.file != nil {
+			err = r.file.Close()
+		}
+		r.cache.release(r.entry)
+	})
+	return err
+}
+
+// hotFill reads a file from the storage and copies it to the hot cache directory, once it's larger than the
+// minimum size. The copy is cached if the file is read to the end, and removed otherwise.
+type hotFill struct {
+	io.ReadCloser
+	cache      *HotCache
+	key        string
+	generation uint64
+
+	// head holds the start of the file until it reaches the minimum size
+	head bytes.Buffer
+	tmp  *os.File
+	size int64
+	// skipped is set once the file won't be cached, because it's too large or the copy failed
+	skipped bool
+	eof     bool
+}
+
+func (f *hotFill) Read(p []byte) (int, error) {
+	n, err := f.ReadCloser.Read(p)
+	if n > 0 && !f.skipped {
+		f.copy(p[:n])
+	}
+	if err == io.EOF {
+		f.eof = true
+	}
+	return n, err
+}
+
+// copy appends data to the head or the copy
+func (f *hotFill) copy(data []byte) {
+	f.size += int64(len(data))
+	if f.size > f.cache.options.MaxBytes {
+		f.skip()
+		return
+	}
+	if f.tmp == nil {
+		f.head.Write(data)
+		if int64(f.head.Len()) < f.cache.options.MinObjectBytes {
+			return
+		}
+		// Large enough, the copy starts with the head
+		tmp, err := os.CreateTemp(f.cache.options.Dir, "hot-*")
+		if err != nil {
+			f.cache.logger.WithError(err).WithField("key", f.key).Warn("Failed to copy file to the hot cache")
+			f.skip()
+			return
+		}
+		f.tmp = tmp
+		data = f.head.Bytes()
+		defer f.head.Reset()
+	}
+	if _, err := f.tmp.Write(data); err != nil {
+		f.cache.logger.WithError(err).WithField("key", f.key).Warn("Failed to copy file to the hot cache")
+		f.skip()
+	}
+}
+
+// skip gives up copying the file
+func (f *hotFill) skip() {
+	f.skipped = true
+	f.head = bytes.Buffer{}
+	if f.tmp != nil {
+		f.tmp.Close()
+		os.Remove(f.tmp.Name())
+		f.tmp = nil
+	}
+}
+
+func (f *hotFill) Close() error {
+	err := f.ReadCloser.Close()
+	if f.tmp == nil {
+		return err
+	}
+	// Only complete copies are cached
+	if !f.eof || f.skipped {
+		f.skip()
+		return err
+	}
+	tmp := f.tmp.Name()
+	if closeErr := f.tmp.Close(); closeErr != nil {
+		os.Remove(tmp)
+		f.tmp = nil
+		return err
+	}
+	f.tmp = nil
+	f.cache.admit(f.key, tmp, f.size, f.generation)
+	return err
+}
+
+// Put writes the file to the storage, dropping the hot copy. The copy is dropped again once the write is done,
+// in case a read during the write copied the previous content.
+func (h *HotCache) Put(ctx context.Context, key string, r io.Reader) error {
+	defer h.invalidate(key, true)
+	h.invalidate(key, true)
+	return h.s.Put(ctx, key, r)
+}
+
+// Exists returns true for hot files without checking the storage
+func (h *HotCache) Exists(ctx context.Context, key string) (bool, error) {
+	h.mu.Lock()
+	_, ok := h.entries[key]
+	h.mu.Unlock()
+	if ok {
+		return true, nil
+	}
+	return h.s.Exists(ctx, key)
+}
+
+// List lists the files of the storage
+func (h *HotCache) List(ctx context.Context, prefix string, opts ListOptions) (*ListResult, error) {
+	return h.s.List(ctx, prefix, opts)
+}
+
+// Delete deletes the file from the storage, dropping the hot copy before and after the deletion
+func (h *HotCache) Delete(ctx context.Context, key string) error {
+	defer h.invalidate(key, true)
+	h.invalidate(key, true)
+	return h.s.Delete(ctx, key)
+}
+
+// DeleteByPrefix deletes the files with the prefix from the storage, dropping the hot copies before and after
+// the deletion
+func (h *HotCache) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
+	defer h.invalidate(prefix, false)
+	h.invalidate(prefix, false)
+	return h.s.DeleteByPrefix(ctx, prefix)
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package storage
+
+// mapPath returns errMmapUnsupported, the hot files are opened by every reader on this platform
+func mapPath(path string, size int64) ([]byte, error) {
+	return nil, errMmapUnsupported
+}
+
+// unmapFile does nothing, nothing is mapped on this platform
+func unmapFile(data []byte) error {
+	return nil
+}
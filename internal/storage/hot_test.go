@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/metrics"
+)
+
+func setupHotCache(t *testing.T, options HotCacheOptions) (*HotCache, *countingStorage) {
+	t.Helper()
+	local, _ := setupLocalStorage(t)
+	backend := &countingStorage{Storage: local}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	options.Dir = t.TempDir()
+	cache, err := NewHotCache(backend, options, logger)
+	require.NoError(t, err)
+	return cache, backend
+}
+
+// hotFiles returns the number of files in the hot cache directory
+func hotFiles(t *testing.T, cache *HotCache) int {
+	t.Helper()
+	entries, err := os.ReadDir(cache.options.Dir)
+	require.NoError(t, err)
+	return len(entries)
+}
+
+func TestHotCache_ServesLargeFilesFromDisk(t *testing.T) {
+	cache, backend := setupHotCache(t, HotCacheOptions{MaxBytes: 1024, MinObjectBytes: 8})
+	ctx := context.Background()
+	require.NoError(t, backend.Put(ctx, "index.json", strings.NewReader("{}")))
+	require.NoError(t, backend.Put(ctx, "binary.zip", strings.NewReader("a large provider binary")))
+
+	hits := testutil.ToFloat64(metrics.HotCacheRequestsTotal.WithLabelValues("hit"))
+	for range 3 {
+		assert.Equal(t, "{}", readAll(t, cache, "index.json"))
+		assert.Equal(t, "a large provider binary", readAll(t, cache, "binary.zip"))
+	}
+	// Only the small file is read from the storage every time
+	assert.Equal(t, int32(4), backend.gets.Load())
+	assert.Equal(t, hits+2, testutil.ToFloat64(metrics.HotCacheRequestsTotal.WithLabelValues("hit")))
+	assert.Equal(t, 1, hotFiles(t, cache))
+
+	// The size of hot files is known before they're read
+	r, err := cache.Get(ctx, "binary.zip")
+	require.NoError(t, err)
+	info, err := r.(interface{ Stat() (os.FileInfo, error) }).Stat()
+	require.NoError(t, err)
+	assert.Equal(t, int64(23), info.Size())
+	require.NoError(t, r.Close())
+
+	exists, err := cache.Exists(ctx, "binary.zip")
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestHotCache_PartialReads(t *testing.T) {
+	cache, backend := setupHotCache(t, HotCacheOptions{MaxBytes: 1024, MinObjectBytes: 4})
+	ctx := context.Background()
+	require.NoError(t, backend.Put(ctx, "binary.zip", strings.NewReader("a large provider binary")))
+
+	// A read interrupted by the client isn't cached
+	r, err := cache.Get(ctx, "binary.zip")
+	require.NoError(t, err)
+	_, err = r.Read(make([]byte, 10))
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Zero(t, hotFiles(t, cache))
+
+	assert.Equal(t, "a large provider binary", readAll(t, cache, "binary.zip"))
+	assert.Equal(t, "a large provider binary", readAll(t, cache, "binary.zip"))
+	assert.Equal(t, int32(2), backend.gets.Load())
+}
+
+func TestHotCache_Eviction(t *testing.T) {
+	cache, backend := setupHotCache(t, HotCacheOptions{MaxBytes: 20, MinObjectBytes: 4})
+	ctx := context.Background()
+	require.NoError(t, backend.Put(ctx, "a.zip", strings.NewReader("aaaaaaaaaa")))
+	require.NoError(t, backend.Put(ctx, "b.zip", strings.NewReader("bbbbbbbbbb")))
+	require.NoError(t, backend.Put(ctx, "c.zip", strings.NewReader("cccccccccc")))
+	require.NoError(t, backend.Put(ctx, "huge.zip", strings.NewReader(strings.Repeat("h", 21))))
+
+	readAll(t, cache, "a.zip")
+	readAll(t, cache, "b.zip")
+	// A reader keeps the least recently used file until it's closed
+	r, err := cache.Get(ctx, "a.zip")
+	require.NoError(t, err)
+	readAll(t, cache, "b.zip")
+	readAll(t, cache, "c.zip")
+	assert.Equal(t, 3, hotFiles(t, cache))
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "aaaaaaaaaa", string(data))
+	require.NoError(t, r.Close())
+	assert.Equal(t, 2, hotFiles(t, cache))
+
+	// Files larger than the cache aren't copied
+	readAll(t, cache, "huge.zip")
+	assert.Equal(t, 2, hotFiles(t, cache))
+
+	gets := backend.gets.Load()
+	readAll(t, cache, "c.zip")
+	readAll(t, cache, "a.zip")
+	assert.Equal(t, gets+1, backend.gets.Load(), "only the evicted file is read from the storage")
+}
+
+func TestHotCache_Invalidation(t *testing.T) {
+	cache, backend := setupHotCache(t, HotCacheOptions{MaxBytes: 1024, MinObjectBytes: 2})
+	ctx := context.Background()
+	require.NoError(t, cache.Put(ctx, "providers/a.zip", strings.NewReader("old")))
+	assert.Equal(t, "old", readAll(t, cache, "providers/a.zip"))
+
+	// Replacing a file drops the hot copy
+	require.NoError(t, cache.Delete(ctx, "providers/a.zip"))
+	require.NoError(t, cache.Put(ctx, "providers/a.zip", strings.NewReader("new")))
+	assert.Equal(t, "new", readAll(t, cache, "providers/a.zip"))
+	assert.Equal(t, "new", readAll(t, cache, "providers/a.zip"))
+
+	_, err := cache.DeleteByPrefix(ctx, "providers/")
+	require.NoError(t, err)
+	_, err = cache.Get(ctx, "providers/a.zip")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.Zero(t, hotFiles(t, cache))
+	assert.Equal(t, int32(3), backend.gets.Load())
+}
+
+func TestNewHotCache(t *testing.T) {
+	local, _ := setupLocalStorage(t)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	// Files left by a previous run are removed
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(dir+"/hot-1", []byte("stale"), 0644))
+	_, err := NewHotCache(local, HotCacheOptions{Dir: dir, MaxBytes: 10, MinObjectBytes: 1}, logger)
+	require.NoError(t, err)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	_, err = NewHotCache(local, HotCacheOptions{MaxBytes: 10, MinObjectBytes: 1}, logger)
+	assert.Error(t, err)
+	_, err = NewHotCache(local, HotCacheOptions{Dir: dir, MaxBytes: 10, MinObjectBytes: 11}, logger)
+	assert.Error(t, err)
+}
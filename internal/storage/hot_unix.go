@@ -0,0 +1,23 @@
+//go:build unix
+
+package storage
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapPath maps the first size bytes of a file into memory, read-only. The mapping outlives the file descriptor.
+func mapPath(path string, size int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// unmapFile releases a mapping returned by mapPath
+func unmapFile(data []byte) error {
+	return syscall.Munmap(data)
+}
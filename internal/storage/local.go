@@ -6,11 +6,16 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/sirupsen/logrus"
 	"cachetf/internal/metrics"
+	"cachetf/pkg/logger"
 )
 
 // LocalStorage implements Storage interface using local filesystem
@@ -20,6 +25,37 @@ type LocalStorage struct {
 	// mutexes provides per-key locking to prevent concurrent writes to the same file
 	mutexes sync.Map
 	metrics *metrics.CacheMetrics
+	// dedup stores the content of the files as blobs, see EnableDedup
+	dedup bool
+	// logicalSize and storedSize are the sizes of the files and of the disk space they use with deduplication
+	logicalSize atomic.Int64
+	storedSize  atomic.Int64
+	// untracked excludes the files from cache_size_bytes, see DisableSizeTracking
+	untracked bool
+	// diskReserve is the free disk space writes keep, see EnableDiskGuard
+	diskReserve int64
+	// reclaimer frees disk space for the writes finding less than the reserve, if set
+	reclaimer SpaceReclaimer
+}
+
+// DisableSizeTracking excludes the files of the storage from cache_size_bytes, for local storage used as a tier
+// or staging area of another storage counting the files itself
+func (s *LocalStorage) DisableSizeTracking() {
+	s.untracked = true
+}
+
+// addSize adds the size of a file written to cache_size_bytes
+func (s *LocalStorage) addSize(n int64) {
+	if !s.untracked {
+		s.metrics.AddSize(n)
+	}
+}
+
+// subSize subtracts the size of files deleted from cache_size_bytes
+func (s *LocalStorage) subSize(n int64) {
+	if !s.untracked {
+		s.metrics.SubSize(n)
+	}
 }
 
 // getMutex returns a mutex for the given key, creating it if it doesn't exist
@@ -47,6 +83,11 @@ func (s *LocalStorage) validatePath(key string) (string, error) {
 	if err != nil || relPath == ".." || len(relPath) >= 3 && relPath[0:3] == "../" {
 		return "", fmt.Errorf("invalid path: %s", key)
 	}
+
+	// The blobs of deduplicated storage aren't keys
+	if s.dedup && s.isBlobPath(fullPath) {
+		return "", fmt.Errorf("invalid path: %s", key)
+	}
 	
 	return fullPath, nil
 }
@@ -74,10 +115,12 @@ func (s *LocalStorage) Get(ctx context.Context, key string) (io.ReadCloser, erro
 
 	if !exists {
 		s.metrics.RecordMiss()
-		s.logger.WithFields(logrus.Fields{
-			"key":  key,
-			"path": path,
-		}).Debug("Cache miss: file not found")
+		logger.Debug(s.logger, "Cache miss: file not found", func() logrus.Fields {
+			return logrus.Fields{
+				"key":  key,
+				"path": path,
+			}
+		})
 		return nil, os.ErrNotExist
 	}
 
@@ -89,16 +132,15 @@ func (s *LocalStorage) Get(ctx context.Context, key string) (io.ReadCloser, erro
 		return nil, fmt.Errorf("failed to open file: %w", err)
 	}
 
-	s.logger.WithFields(logrus.Fields{
-		"key":  key,
-		"path": path,
-	}).Debug("Cache hit: file found")
+	logger.Debug(s.logger, "Cache hit: file found", func() logrus.Fields {
+		return logrus.Fields{
+			"key":  key,
+			"path": path,
+		}
+	})
 
-	// Record the hit and update file size in metrics
+	// Record the hit, reads don't change the cache size
 	s.metrics.RecordHit()
-	if info, err := os.Stat(path); err == nil {
-		s.metrics.UpdateSize(int64(info.Size()))
-	}
 
 	return file, nil
 }
@@ -117,14 +159,13 @@ func (s *LocalStorage) Put(ctx context.Context, key string, r io.Reader) error {
 	// Check if file already exists (quick check before creating directories)
 	if _, err := os.Stat(path); err == nil {
 		// File already exists, no need to write it again
-		s.logger.WithField("path", path).Debug("File already exists, skipping write")
+		logger.Debug(s.logger, "File already exists, skipping write", func() logrus.Fields { return logrus.Fields{"path": path} })
 		return nil
 	}
 
-	// Check if file exists to update metrics
-	if info, err := os.Stat(path); err == nil {
-		// File exists, subtract its size from metrics
-		s.metrics.UpdateSize(-info.Size())
+	// Keep the disk from filling up, before anything is read
+	if err := s.checkDiskSpace(ctx, r); err != nil {
+		return err
 	}
 
 	// Create all directories in the path if they don't exist
@@ -132,6 +173,10 @@ func (s *LocalStorage) Put(ctx context.Context, key string, r io.Reader) error {
 		return fmt.Errorf("failed to create directory: %w", err)
 	}
 
+	if s.dedup {
+		return s.putDeduplicated(path, r)
+	}
+
 	// Create or truncate the file
 	f, err := os.Create(path)
 	if err != nil {
@@ -149,17 +194,19 @@ func (s *LocalStorage) Put(ctx context.Context, key string, r io.Reader) error {
 	}
 
 	// Update file size in metrics
-	s.metrics.UpdateSize(n)
+	s.addSize(n)
 
 	// Ensure the file is synced to disk
 	if err := f.Sync(); err != nil {
 		s.logger.WithError(err).WithField("path", path).Error("Failed to sync file to disk")
 	}
 
-	s.logger.WithFields(logrus.Fields{
-		"path": path,
-		"size": n,
-	}).Debug("Successfully stored file in cache")
+	logger.Debug(s.logger, "Successfully stored file in cache", func() logrus.Fields {
+		return logrus.Fields{
+			"path": path,
+			"size": n,
+		}
+	})
 
 	return nil
 }
@@ -172,12 +219,12 @@ func (s *LocalStorage) Exists(ctx context.Context, key string) (bool, error) {
 
 	_, err = os.Stat(path)
 	if err == nil {
-		s.logger.WithField("path", path).Debug("Cache hit: file exists")
+		logger.Debug(s.logger, "Cache hit: file exists", func() logrus.Fields { return logrus.Fields{"path": path} })
 		return true, nil
 	}
 
 	if os.IsNotExist(err) {
-		s.logger.WithField("path", path).Debug("Cache miss: file does not exist")
+		logger.Debug(s.logger, "Cache miss: file does not exist", func() logrus.Fields { return logrus.Fields{"path": path} })
 		return false, nil
 	}
 
@@ -189,6 +236,127 @@ func (s *LocalStorage) Exists(ctx context.Context, key string) (bool, error) {
 	return false, err
 }
 
+// List returns a page of the files whose key starts with prefix, ordered by key
+func (s *LocalStorage) List(ctx context.Context, prefix string, opts ListOptions) (*ListResult, error) {
+	// Only walk the deepest directory that can contain matching keys
+	root := s.baseDir
+	if dir := path.Dir(prefix); prefix != "" && dir != "." {
+		var err error
+		if root, err = s.validatePath(dir); err != nil {
+			return nil, fmt.Errorf("invalid prefix path: %s", prefix)
+		}
+	}
+
+	var objects []ObjectInfo
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			// The prefix directory doesn't exist, so there's nothing to list
+			if os.IsNotExist(err) && p == root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if info.IsDir() {
+			// The blobs of deduplicated storage aren't keys
+			if s.dedup && p == s.blobsRoot() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// The keys of deduplicated storage may be symbolic links to their blob
+		if info.Mode()&os.ModeSymlink != 0 {
+			if info, err = os.Stat(p); err != nil {
+				// The blob was collected, the key is cached again on the next request
+				return nil
+			}
+		}
+
+		rel, err := filepath.Rel(s.baseDir, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) || key <= opts.StartAfter {
+			return nil
+		}
+
+		objects = append(objects, ObjectInfo{
+			Key:          key,
+			Size:         info.Size(),
+			LastModified: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		s.metrics.RecordError("list", err)
+		return nil, fmt.Errorf("error listing files with prefix %s: %w", prefix, err)
+	}
+
+	// Walk orders entries per directory, which doesn't match plain key order
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+
+	result := &ListResult{Objects: objects}
+	if maxKeys := opts.maxKeys(); len(objects) > maxKeys {
+		result.Objects = objects[:maxKeys]
+		result.IsTruncated = true
+		result.NextStartAfter = result.Objects[maxKeys-1].Key
+	}
+
+	logger.Debug(s.logger, "Listed files", func() logrus.Fields {
+		return logrus.Fields{
+			"prefix": prefix,
+			"count":  len(result.Objects),
+		}
+	})
+
+	return result, nil
+}
+
+// Delete deletes a single file
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	// Serialize with writes to the same key
+	mutex := s.getMutex(key)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	path, err := s.validatePath(key)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return os.ErrNotExist
+		}
+		return fmt.Errorf("error checking file %s: %w", path, err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory, use DeleteByPrefix instead", key)
+	}
+
+	if s.dedup {
+		err = s.removeDeduplicated(path, info)
+	} else {
+		err = os.Remove(path)
+	}
+	if err != nil {
+		s.metrics.RecordError("delete", err)
+		return fmt.Errorf("error deleting file %s: %w", path, err)
+	}
+
+	s.subSize(info.Size())
+	s.metrics.RecordDeletion(1)
+
+	s.logger.WithField("path", path).Info("Deleted file")
+	return nil
+}
+
 // DeleteByPrefix deletes all files with the given prefix
 func (s *LocalStorage) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
 	s.logger.WithField("prefix", prefix).Info("Deleting files by prefix")
@@ -203,7 +371,7 @@ func (s *LocalStorage) DeleteByPrefix(ctx context.Context, prefix string) (int,
 	// Check if the path exists first
 	fileInfo, err := os.Stat(searchPath)
 	if os.IsNotExist(err) {
-		s.logger.WithField("path", searchPath).Debug("Path does not exist, nothing to delete")
+		logger.Debug(s.logger, "Path does not exist, nothing to delete", func() logrus.Fields { return logrus.Fields{"path": searchPath} })
 		return 0, nil // No files to delete
 	}
 	if err != nil {
@@ -212,11 +380,16 @@ func (s *LocalStorage) DeleteByPrefix(ctx context.Context, prefix string) (int,
 
 	// If it's a file, just delete it and return count 1
 	if !fileInfo.IsDir() {
-		if err := os.Remove(searchPath); err != nil {
+		if s.dedup {
+			err = s.removeDeduplicated(searchPath, fileInfo)
+		} else {
+			err = os.Remove(searchPath)
+		}
+		if err != nil {
 			return 0, fmt.Errorf("error deleting file %s: %w", searchPath, err)
 		}
-		s.metrics.UpdateSize(-fileInfo.Size())
-		s.logger.WithField("path", searchPath).Debug("Deleted file")
+		s.subSize(fileInfo.Size())
+		logger.Debug(s.logger, "Deleted file", func() logrus.Fields { return logrus.Fields{"path": searchPath} })
 		return 1, nil
 	}
 
@@ -238,7 +411,7 @@ func (s *LocalStorage) DeleteByPrefix(ctx context.Context, prefix string) (int,
 		if !info.IsDir() {
 			deletedCount++
 			totalSize += info.Size()
-			s.logger.WithField("path", path).Debug("Marked file for deletion")
+			logger.Debug(s.logger, "Marked file for deletion", func() logrus.Fields { return logrus.Fields{"path": path} })
 		}
 
 		return nil
@@ -248,15 +421,31 @@ func (s *LocalStorage) DeleteByPrefix(ctx context.Context, prefix string) (int,
 		return 0, fmt.Errorf("error walking directory %s: %w", searchPath, err)
 	}
 
+	// The sizes of the files of deduplicated storage depend on their links
+	var logicalSize, storedSize int64
+	if s.dedup {
+		if logicalSize, storedSize, err = s.scanDedupSize(searchPath); err != nil {
+			return 0, fmt.Errorf("error walking directory %s: %w", searchPath, err)
+		}
+	}
+
 	// Now actually delete the directory and all its contents
 	if err := os.RemoveAll(searchPath); err != nil {
 		return 0, fmt.Errorf("error deleting directory %s: %w", searchPath, err)
 	}
 
 	// Update metrics with total size and count of deleted files
-	s.metrics.UpdateSize(-totalSize)
+	s.subSize(totalSize)
 	s.metrics.RecordDeletion(deletedCount)
 
+	// Remove the blobs no other key links to
+	if s.dedup {
+		s.addDedupSize(-logicalSize, -storedSize)
+		if _, err := s.CollectBlobs(ctx); err != nil {
+			s.logger.WithError(err).Warn("Failed to collect unreferenced blobs")
+		}
+	}
+
 	s.logger.WithFields(logrus.Fields{
 		"path":  searchPath,
 		"count": deletedCount,
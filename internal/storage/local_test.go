@@ -6,11 +6,15 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/metrics"
 )
 
 func setupLocalStorage(t *testing.T) (*LocalStorage, string) {
@@ -135,6 +139,121 @@ func TestLocalStorage_DeleteByPrefix(t *testing.T) {
 	}
 }
 
+func TestLocalStorage_List(t *testing.T) {
+	storage, _ := setupLocalStorage(t)
+	ctx := context.Background()
+
+	// Create test files in nested directories
+	keys := []string{
+		"registry.terraform.io/hashicorp/aws/5.0.0/file1.zip",
+		"registry.terraform.io/hashicorp/aws/5.1.0/file2.zip",
+		"registry.terraform.io/hashicorp/awscc/1.0.0/file3.zip",
+		"registry.terraform.io/hashicorp/random/3.7.2/file4.zip",
+	}
+	for _, key := range keys {
+		require.NoError(t, storage.Put(ctx, key, bytes.NewReader([]byte(key))))
+	}
+
+	// Everything is listed in key order
+	result, err := storage.List(ctx, "", ListOptions{})
+	require.NoError(t, err, "List should not return an error")
+	require.Len(t, result.Objects, 4)
+	assert.False(t, result.IsTruncated)
+	for i, obj := range result.Objects {
+		assert.Equal(t, keys[i], obj.Key)
+		assert.Equal(t, int64(len(keys[i])), obj.Size)
+		assert.False(t, obj.LastModified.IsZero())
+	}
+
+	// Prefixes are matched on the key, not on directories
+	result, err = storage.List(ctx, "registry.terraform.io/hashicorp/aws", ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, result.Objects, 3)
+
+	result, err = storage.List(ctx, "registry.terraform.io/hashicorp/aws/", ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, result.Objects, 2)
+
+	// Missing prefixes return an empty page
+	result, err = storage.List(ctx, "registry.terraform.io/missing/", ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, result.Objects)
+
+	// Pages are chained with NextStartAfter
+	result, err = storage.List(ctx, "", ListOptions{MaxKeys: 3})
+	require.NoError(t, err)
+	assert.Len(t, result.Objects, 3)
+	assert.True(t, result.IsTruncated)
+	assert.Equal(t, keys[2], result.NextStartAfter)
+
+	result, err = storage.List(ctx, "", ListOptions{MaxKeys: 3, StartAfter: result.NextStartAfter})
+	require.NoError(t, err)
+	require.Len(t, result.Objects, 1)
+	assert.Equal(t, keys[3], result.Objects[0].Key)
+	assert.False(t, result.IsTruncated)
+
+	// Walk visits every page
+	var walked []string
+	err = Walk(ctx, &pagedStorage{storage}, "registry.terraform.io/", func(obj ObjectInfo) error {
+		walked = append(walked, obj.Key)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, keys, walked)
+
+	// Invalid prefixes are rejected
+	_, err = storage.List(ctx, "../invalid/prefix", ListOptions{})
+	assert.Error(t, err, "List should return an error for invalid path")
+}
+
+// pagedStorage forces single-object pages to exercise pagination
+type pagedStorage struct {
+	*LocalStorage
+}
+
+func (p *pagedStorage) List(ctx context.Context, prefix string, opts ListOptions) (*ListResult, error) {
+	opts.MaxKeys = 1
+	return p.LocalStorage.List(ctx, prefix, opts)
+}
+
+func TestLocalStorage_Delete(t *testing.T) {
+	storage, tempDir := setupLocalStorage(t)
+	ctx := context.Background()
+
+	// Files sharing a name prefix
+	require.NoError(t, storage.Put(ctx, "dir/file.zip", bytes.NewReader([]byte("a"))))
+	require.NoError(t, storage.Put(ctx, "dir/file.zip.sig", bytes.NewReader([]byte("b"))))
+
+	// Only the exact key is deleted
+	require.NoError(t, storage.Delete(ctx, "dir/file.zip"))
+	_, err := os.Stat(filepath.Join(tempDir, "dir/file.zip"))
+	assert.True(t, os.IsNotExist(err), "File should have been deleted")
+	_, err = os.Stat(filepath.Join(tempDir, "dir/file.zip.sig"))
+	assert.NoError(t, err, "File sharing the prefix should still exist")
+
+	// Missing files and directories are reported
+	assert.ErrorIs(t, storage.Delete(ctx, "dir/file.zip"), os.ErrNotExist)
+	assert.Error(t, storage.Delete(ctx, "dir"))
+	assert.Error(t, storage.Delete(ctx, "../invalid/path.txt"))
+}
+
+func TestLocalStorage_SizeTracking(t *testing.T) {
+	s, _ := setupLocalStorage(t)
+	assertSizeTracking(t, s, true)
+
+	// Files written again aren't added twice
+	before := testutil.ToFloat64(metrics.CacheSizeBytes)
+	require.NoError(t, s.Put(context.Background(), "a.zip", strings.NewReader("a")))
+	require.NoError(t, s.Put(context.Background(), "a.zip", strings.NewReader("a")))
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.CacheSizeBytes))
+
+	// Untracked storage isn't counted
+	s.DisableSizeTracking()
+	require.NoError(t, s.Put(context.Background(), "b.zip", strings.NewReader("b")))
+	require.NoError(t, s.Delete(context.Background(), "a.zip"))
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.CacheSizeBytes))
+}
+
 func TestLocalStorage_ConcurrentAccess(t *testing.T) {
 	storage, _ := setupLocalStorage(t)
 	ctx := context.Background()
@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"cachetf/internal/metrics"
+)
+
+// MemoryCacheOptions configures a MemoryCache
+type MemoryCacheOptions struct {
+	// MaxBytes is the total size of the cached files, the least recently used ones are dropped above it
+	MaxBytes int64
+	// MaxObjectBytes is the size of the largest file kept in memory
+	MaxObjectBytes int64
+	// TTL is how long a file is served from memory before it's read from the storage again, so changes made
+	// by other instances sharing the storage show up. Files never expire if zero.
+	TTL time.Duration
+}
+
+// memoryEntry is a file kept in memory
+type memoryEntry struct {
+	key     string
+	data    []byte
+	expires time.Time
+}
+
+// MemoryCache keeps small, frequently read files of a Storage in memory, such as provider indexes and
+// metadata documents. Writes and deletions go to the storage and invalidate the cached copies.
+type MemoryCache struct {
+	s       Storage
+	options MemoryCacheOptions
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// lru holds the entries, the most recently used first
+	lru  *list.List
+	size int64
+	// generation changes on every invalidation, so reads that started before it don't cache stale content
+	generation uint64
+}
+
+// NewMemoryCache creates a MemoryCache in front of s
+func NewMemoryCache(s Storage, options MemoryCacheOptions) *MemoryCache {
+	return &MemoryCache{
+		s:       s,
+		options: options,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// lookup returns the content of a cached file
+func (m *MemoryCache) lookup(key string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	element, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*memoryEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		m.remove(element)
+		return nil, false
+	}
+	m.lru.MoveToFront(element)
+	return entry.data, true
+}
+
+// store caches the content of a file read at the given generation
+func (m *MemoryCache) store(key string, data []byte, generation uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if generation != m.generation || int64(len(data)) > m.options.MaxBytes {
+		return
+	}
+	if element, ok := m.entries[key]; ok {
+		m.remove(element)
+	}
+
+	entry := &memoryEntry{key: key, data: data}
+	if m.options.TTL > 0 {
+		entry.expires = time.Now().Add(m.options.TTL)
+	}
+	m.entries[key] = m.lru.PushFront(entry)
+	m.size += int64(len(data))
+	metrics.MemoryCacheBytes.Add(float64(len(data)))
+
+	for m.size > m.options.MaxBytes {
+		m.remove(m.lru.Back())
+	}
+}
+
+// remove drops an entry, the lock must be held
+func (m *MemoryCache) remove(element *list.Element) {
+	entry := m.lru.Remove(element).(*memoryEntry)
+	delete(m.entries, entry.key)
+	m.size -= int64(len(entry.data))
+	metrics.MemoryCacheBytes.Sub(float64(len(entry.data)))
+}
+
+// invalidate drops the cached files whose key starts with prefix, or the file with the key if exact
+func (m *MemoryCache) invalidate(prefix string, exact bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.generation++
+	if exact {
+		if element, ok := m.entries[prefix]; ok {
+			m.remove(element)
+		}
+		return
+	}
+	for key, element := range m.entries {
+		if strings.HasPrefix(key, prefix) {
+			m.remove(element)
+		}
+	}
+}
+
+// Get serves small files from memory, reading them from the storage on the first request
+func (m *MemoryCache) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if data, ok := m.lookup(key); ok {
+		metrics.MemoryCacheRequestsTotal.WithLabelValues("hit").Inc()
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	metrics.MemoryCacheRequestsTotal.WithLabelValues("miss").Inc()
+
+	m.mu.Lock()
+	generation := m.generation
+	m.mu.Unlock()
+
+	r, err := m.s.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read one byte more than the limit to tell whether the file fits
+	head, err := io.ReadAll(io.LimitReader(r, m.options.MaxObjectBytes+1))
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	if int64(len(head)) > m.options.MaxObjectBytes {
+		// Too large, stream the rest of the file from the storage
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(head), r), r}, nil
+	}
+	r.Close()
+
+	m.store(key, head, generation)
+	return io.NopCloser(bytes.NewReader(head)), nil
+}
+
+// Put writes the file to the storage, dropping the cached copy. The copy is dropped again once the write is
+// done, in case a read during the write cached the previous content.
+func (m *MemoryCache) Put(ctx context.Context, key string, r io.Reader) error {
+	defer m.invalidate(key, true)
+	m.invalidate(key, true)
+	return m.s.Put(ctx, key, r)
+}
+
+// Exists returns true for cached files without checking the storage
+func (m *MemoryCache) Exists(ctx context.Context, key string) (bool, error) {
+	if _, ok := m.lookup(key); ok {
+		return true, nil
+	}
+	return m.s.Exists(ctx, key)
+}
+
+// List lists the files of the storage
+func (m *MemoryCache) List(ctx context.Context, prefix string, opts ListOptions) (*ListResult, error) {
+	return m.s.List(ctx, prefix, opts)
+}
+
+// Delete deletes the file from the storage, dropping the cached copy before and after the deletion
+func (m *MemoryCache) Delete(ctx context.Context, key string) error {
+	defer m.invalidate(key, true)
+	m.invalidate(key, true)
+	return m.s.Delete(ctx, key)
+}
+
+// DeleteByPrefix deletes the files with the prefix from the storage, dropping the cached copies before and
+// after the deletion
+func (m *MemoryCache) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
+	defer m.invalidate(prefix, false)
+	m.invalidate(prefix, false)
+	return m.s.DeleteByPrefix(ctx, prefix)
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingStorage counts the reads reaching a storage
+type countingStorage struct {
+	Storage
+	gets atomic.Int32
+}
+
+func (c *countingStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	c.gets.Add(1)
+	return c.Storage.Get(ctx, key)
+}
+
+func setupMemoryCache(t *testing.T, options MemoryCacheOptions) (*MemoryCache, *countingStorage) {
+	t.Helper()
+	local, _ := setupLocalStorage(t)
+	backend := &countingStorage{Storage: local}
+	return NewMemoryCache(backend, options), backend
+}
+
+func TestMemoryCache_ServesSmallFilesFromMemory(t *testing.T) {
+	cache, backend := setupMemoryCache(t, MemoryCacheOptions{MaxBytes: 1024, MaxObjectBytes: 16})
+	ctx := context.Background()
+	require.NoError(t, backend.Put(ctx, "index.json", strings.NewReader(`{"versions": {}}`)))
+	require.NoError(t, backend.Put(ctx, "binary.zip", strings.NewReader("a binary larger than the limit")))
+
+	for range 3 {
+		assert.Equal(t, `{"versions": {}}`, readAll(t, cache, "index.json"))
+		assert.Equal(t, "a binary larger than the limit", readAll(t, cache, "binary.zip"))
+	}
+	// Only the large file is read from the storage every time
+	assert.Equal(t, int32(4), backend.gets.Load())
+
+	exists, err := cache.Exists(ctx, "index.json")
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestMemoryCache_Invalidation(t *testing.T) {
+	cache, backend := setupMemoryCache(t, MemoryCacheOptions{MaxBytes: 1024, MaxObjectBytes: 64})
+	ctx := context.Background()
+	require.NoError(t, cache.Put(ctx, "metadata/index.json", strings.NewReader("old")))
+	assert.Equal(t, "old", readAll(t, cache, "metadata/index.json"))
+
+	// Replacing a file drops the cached copy
+	require.NoError(t, cache.Delete(ctx, "metadata/index.json"))
+	require.NoError(t, cache.Put(ctx, "metadata/index.json", strings.NewReader("new")))
+	assert.Equal(t, "new", readAll(t, cache, "metadata/index.json"))
+
+	deleted, err := cache.DeleteByPrefix(ctx, "metadata/")
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	_, err = cache.Get(ctx, "metadata/index.json")
+	assert.Error(t, err)
+	assert.Equal(t, int32(3), backend.gets.Load())
+}
+
+func TestMemoryCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	cache, backend := setupMemoryCache(t, MemoryCacheOptions{MaxBytes: 10, MaxObjectBytes: 5})
+	ctx := context.Background()
+	for _, key := range []string{"a", "b", "c"} {
+		require.NoError(t, backend.Put(ctx, key, strings.NewReader(strings.Repeat(key, 5))))
+	}
+
+	readAll(t, cache, "a")
+	readAll(t, cache, "b")
+	readAll(t, cache, "a")
+	// c doesn't fit with a and b, b is the least recently used
+	readAll(t, cache, "c")
+	assert.Equal(t, int32(3), backend.gets.Load())
+
+	readAll(t, cache, "a")
+	readAll(t, cache, "c")
+	assert.Equal(t, int32(3), backend.gets.Load())
+	readAll(t, cache, "b")
+	assert.Equal(t, int32(4), backend.gets.Load())
+}
+
+func TestMemoryCache_TTL(t *testing.T) {
+	cache, backend := setupMemoryCache(t, MemoryCacheOptions{MaxBytes: 1024, MaxObjectBytes: 64, TTL: 20 * time.Millisecond})
+	ctx := context.Background()
+	require.NoError(t, backend.Put(ctx, "index.json", strings.NewReader("{}")))
+
+	readAll(t, cache, "index.json")
+	readAll(t, cache, "index.json")
+	assert.Equal(t, int32(1), backend.gets.Load())
+
+	time.Sleep(30 * time.Millisecond)
+	readAll(t, cache, "index.json")
+	assert.Equal(t, int32(2), backend.gets.Load())
+}
@@ -3,38 +3,62 @@ package storage
 import (
 	"context"
 	"io"
+	"time"
+
+	"cachetf/internal/metrics"
 )
 
 // metricsWrapper wraps a Storage implementation with metrics
 type metricsWrapper struct {
-	s Storage
+	s       Storage
+	backend string
+	metrics *metrics.CacheMetrics
 }
 
-// NewMetricsWrapper creates a new metrics wrapper around a Storage implementation
-// Note: The underlying storage implementation is responsible for recording metrics
-// This wrapper only exists to maintain backward compatibility with the Storage interface
-func NewMetricsWrapper(s Storage) Storage {
+// NewMetricsWrapper creates a new metrics wrapper around a Storage implementation.
+// The duration of every operation is recorded in cache_operation_duration_seconds, labeled with
+// the backend name (e.g. local or s3) so the performance of the backends can be compared.
+// Hits, misses and the cache size are still recorded by the underlying storage implementation.
+func NewMetricsWrapper(s Storage, backend string) Storage {
 	return &metricsWrapper{
-		s: s,
+		s:       s,
+		backend: backend,
+		metrics: metrics.NewCacheMetrics(),
 	}
 }
 
+// observe records the duration of an operation started at start
+func (m *metricsWrapper) observe(operation string, start time.Time) {
+	m.metrics.RecordOperationDuration(m.backend, operation, time.Since(start).Seconds())
+}
+
 func (m *metricsWrapper) Get(ctx context.Context, key string) (io.ReadCloser, error) {
-	// Just pass through to the underlying storage, which handles metrics
+	// Only the time to open the object is recorded, reading it is up to the caller
+	defer m.observe("get", time.Now())
 	return m.s.Get(ctx, key)
 }
 
 func (m *metricsWrapper) Put(ctx context.Context, key string, r io.Reader) error {
-	// Just pass through to the underlying storage, which handles metrics
+	defer m.observe("put", time.Now())
 	return m.s.Put(ctx, key, r)
 }
 
 func (m *metricsWrapper) Exists(ctx context.Context, key string) (bool, error) {
-	// Just pass through to the underlying storage, which handles metrics
+	defer m.observe("exists", time.Now())
 	return m.s.Exists(ctx, key)
 }
 
+func (m *metricsWrapper) Delete(ctx context.Context, key string) error {
+	defer m.observe("delete", time.Now())
+	return m.s.Delete(ctx, key)
+}
+
 func (m *metricsWrapper) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
-	// Just pass through to the underlying storage, which handles metrics
+	defer m.observe("delete_by_prefix", time.Now())
 	return m.s.DeleteByPrefix(ctx, prefix)
 }
+
+func (m *metricsWrapper) List(ctx context.Context, prefix string, opts ListOptions) (*ListResult, error) {
+	defer m.observe("list", time.Now())
+	return m.s.List(ctx, prefix, opts)
+}
@@ -5,8 +5,14 @@ import (
 	"context"
 	"errors"
 	"io"
+	"strings"
 	"testing"
 
+	"cachetf/internal/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -41,6 +47,19 @@ func (m *mockStorage) Exists(ctx context.Context, key string) (bool, error) {
 	return args.Bool(0), args.Error(1)
 }
 
+func (m *mockStorage) List(ctx context.Context, prefix string, opts ListOptions) (*ListResult, error) {
+	args := m.Called(ctx, prefix, opts)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*ListResult), args.Error(1)
+}
+
+func (m *mockStorage) Delete(ctx context.Context, key string) error {
+	args := m.Called(ctx, key)
+	return args.Error(0)
+}
+
 func (m *mockStorage) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
 	args := m.Called(ctx, prefix)
 	return args.Int(0), args.Error(1)
@@ -49,7 +68,7 @@ func (m *mockStorage) DeleteByPrefix(ctx context.Context, prefix string) (int, e
 func TestMetricsWrapper_Get(t *testing.T) {
 	// Create a mock storage
 	mockStore := new(mockStorage)
-	wrapper := NewMetricsWrapper(mockStore)
+	wrapper := NewMetricsWrapper(mockStore, "mock")
 
 	// Set up expectations
 	expectedContent := "test content"
@@ -73,7 +92,7 @@ func TestMetricsWrapper_Get(t *testing.T) {
 func TestMetricsWrapper_Put(t *testing.T) {
 	// Create a mock storage
 	mockStore := new(mockStorage)
-	wrapper := NewMetricsWrapper(mockStore)
+	wrapper := NewMetricsWrapper(mockStore, "mock")
 
 	// Set up test content
 	content := "test content"
@@ -92,7 +111,7 @@ func TestMetricsWrapper_Put(t *testing.T) {
 func TestMetricsWrapper_Exists(t *testing.T) {
 	// Create a mock storage
 	mockStore := new(mockStorage)
-	wrapper := NewMetricsWrapper(mockStore)
+	wrapper := NewMetricsWrapper(mockStore, "mock")
 
 	// Set up expectations
 	mockStore.On("Exists", mock.Anything, "test-key").Return(true, nil)
@@ -111,7 +130,7 @@ func TestMetricsWrapper_Exists(t *testing.T) {
 func TestMetricsWrapper_DeleteByPrefix(t *testing.T) {
 	// Create a mock storage
 	mockStore := new(mockStorage)
-	wrapper := NewMetricsWrapper(mockStore)
+	wrapper := NewMetricsWrapper(mockStore, "mock")
 
 	// Set up expectations
 	mockStore.On("DeleteByPrefix", mock.Anything, "test-prefix").Return(2, nil)
@@ -127,10 +146,31 @@ func TestMetricsWrapper_DeleteByPrefix(t *testing.T) {
 	mockStore.AssertExpectations(t)
 }
 
+func TestMetricsWrapper_List(t *testing.T) {
+	// Create a mock storage
+	mockStore := new(mockStorage)
+	wrapper := NewMetricsWrapper(mockStore, "mock")
+
+	// Set up expectations
+	opts := ListOptions{StartAfter: "test-prefix/a", MaxKeys: 10}
+	expected := &ListResult{Objects: []ObjectInfo{{Key: "test-prefix/b", Size: 4}}}
+	mockStore.On("List", mock.Anything, "test-prefix", opts).Return(expected, nil)
+
+	// Call the method
+	result, err := wrapper.List(context.Background(), "test-prefix", opts)
+
+	// Verify the result
+	require.NoError(t, err)
+	assert.Equal(t, expected, result)
+
+	// Verify the mock was called
+	mockStore.AssertExpectations(t)
+}
+
 func TestMetricsWrapper_ErrorHandling(t *testing.T) {
 	// Create a mock storage
 	mockStore := new(mockStorage)
-	wrapper := NewMetricsWrapper(mockStore)
+	wrapper := NewMetricsWrapper(mockStore, "mock")
 
 	// Test Get error
 	expectedErr := errors.New("test error")
@@ -148,6 +188,11 @@ func TestMetricsWrapper_ErrorHandling(t *testing.T) {
 	_, err = wrapper.Exists(context.Background(), "error-key")
 	assert.ErrorIs(t, err, expectedErr)
 
+	// Test Delete error
+	mockStore.On("Delete", mock.Anything, "error-key").Return(expectedErr)
+	err = wrapper.Delete(context.Background(), "error-key")
+	assert.ErrorIs(t, err, expectedErr)
+
 	// Test DeleteByPrefix error
 	mockStore.On("DeleteByPrefix", mock.Anything, "error-prefix").Return(0, expectedErr)
 	_, err = wrapper.DeleteByPrefix(context.Background(), "error-prefix")
@@ -156,3 +201,87 @@ func TestMetricsWrapper_ErrorHandling(t *testing.T) {
 	// Verify all mocks were called
 	mockStore.AssertExpectations(t)
 }
+
+// sampleCount returns the number of durations observed for an operation on a backend
+func sampleCount(t *testing.T, backend, operation string) uint64 {
+	t.Helper()
+	observer, err := metrics.CacheOperationDuration.GetMetricWithLabelValues(backend, operation)
+	require.NoError(t, err)
+
+	var m dto.Metric
+	require.NoError(t, observer.(prometheus.Histogram).Write(&m))
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestMetricsWrapper_RecordsDurations(t *testing.T) {
+	mockStore := new(mockStorage)
+	wrapper := NewMetricsWrapper(mockStore, "durations-test")
+
+	mockStore.On("Get", mock.Anything, "key").Return(io.NopCloser(bytes.NewBufferString("content")), nil)
+	mockStore.On("Put", mock.Anything, "key", mock.Anything).Return(nil)
+	mockStore.On("Exists", mock.Anything, "key").Return(true, nil)
+	mockStore.On("Delete", mock.Anything, "key").Return(nil)
+	mockStore.On("DeleteByPrefix", mock.Anything, "prefix").Return(0, nil)
+	mockStore.On("List", mock.Anything, "prefix", ListOptions{}).Return(&ListResult{}, nil)
+
+	ctx := context.Background()
+	reader, err := wrapper.Get(ctx, "key")
+	require.NoError(t, err)
+	reader.Close()
+	require.NoError(t, wrapper.Put(ctx, "key", bytes.NewReader([]byte("content"))))
+	_, err = wrapper.Exists(ctx, "key")
+	require.NoError(t, err)
+	require.NoError(t, wrapper.Delete(ctx, "key"))
+	_, err = wrapper.DeleteByPrefix(ctx, "prefix")
+	require.NoError(t, err)
+	_, err = wrapper.List(ctx, "prefix", ListOptions{})
+	require.NoError(t, err)
+
+	for _, operation := range []string{"get", "put", "exists", "delete", "delete_by_prefix", "list"} {
+		assert.Equal(t, uint64(1), sampleCount(t, "durations-test", operation), operation)
+	}
+}
+
+func TestMetricsWrapper_RecordsFailedOperations(t *testing.T) {
+	mockStore := new(mockStorage)
+	wrapper := NewMetricsWrapper(mockStore, "failures-test")
+
+	mockStore.On("Get", mock.Anything, "key").Return(nil, errors.New("test error"))
+
+	_, err := wrapper.Get(context.Background(), "key")
+	require.Error(t, err)
+
+	// Failed operations are timed too, a slow failing backend must show up
+	assert.Equal(t, uint64(1), sampleCount(t, "failures-test", "get"))
+}
+
+// assertSizeTracking checks that a backend adds the files it writes to cache_size_bytes, and subtracts the files it
+// deletes. Backends whose tests can't delete by prefix skip it.
+func assertSizeTracking(t *testing.T, s Storage, deleteByPrefix bool) {
+	t.Helper()
+	ctx := context.Background()
+	size := func() float64 { return testutil.ToFloat64(metrics.CacheSizeBytes) }
+	before := size()
+
+	require.NoError(t, s.Put(ctx, "size/a/provider.zip", strings.NewReader("provider binary")))
+	require.NoError(t, s.Put(ctx, "size/b/module.tar.gz", strings.NewReader("module")))
+	assert.Equal(t, before+21, size(), "written files are added")
+
+	// Reads don't change the size
+	assert.Equal(t, "provider binary", readAll(t, s, "size/a/provider.zip"))
+	assert.Equal(t, before+21, size(), "read files aren't added again")
+
+	// Files written again aren't added again
+	require.NoError(t, s.Put(ctx, "size/b/module.tar.gz", strings.NewReader("module")))
+	assert.Equal(t, before+21, size(), "rewritten files aren't added again")
+
+	require.NoError(t, s.Delete(ctx, "size/a/provider.zip"))
+	assert.Equal(t, before+6, size(), "deleted files are subtracted")
+
+	if deleteByPrefix {
+		count, err := s.DeleteByPrefix(ctx, "size/")
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+		assert.Equal(t, before, size(), "files deleted by prefix are subtracted")
+	}
+}
@@ -0,0 +1,564 @@
+package storage
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/errclass"
+	"cachetf/internal/metrics"
+	"cachetf/pkg/logger"
+)
+
+// Authentication modes of OCI Object Storage
+const (
+	// OCIAuthAPIKey signs the requests with the API key of a user
+	OCIAuthAPIKey = "api_key"
+	// OCIAuthInstancePrincipal signs the requests as the compute instance, which a dynamic group grants access
+	OCIAuthInstancePrincipal = "instance_principal"
+)
+
+// ociPartSize is the size of the parts of multipart uploads, smaller files are uploaded with a single request.
+// Parts other than the last must be at least 10 MiB.
+const ociPartSize = 16 << 20
+
+// OCIConfig holds the configuration of OCI Object Storage
+type OCIConfig struct {
+	// Auth is the authentication mode, OCIAuthAPIKey if empty
+	Auth string
+	// Region of the bucket, e.g. us-ashburn-1. Instance principals default to the region of the instance.
+	Region string
+	// Namespace of the tenancy, looked up if empty
+	Namespace string
+	// Bucket is the name of the bucket the objects are stored in
+	Bucket string
+	// KeyPrefix scopes the cache to a part of the bucket, e.g. cachetf/prod/
+	KeyPrefix string
+	// TenancyOCID, UserOCID, Fingerprint and PrivateKeyFile identify the API key of a user
+	TenancyOCID    string
+	UserOCID       string
+	Fingerprint    string
+	PrivateKeyFile string
+	// Endpoint is the URL of the Object Storage API, derived from the region if empty
+	Endpoint string
+	// MetadataURL and AuthEndpoint are the instance metadata service and the auth service of instance
+	// principals, the OCI services if empty
+	MetadataURL  string
+	AuthEndpoint string
+}
+
+// ValidateOCIConfig checks that cfg names a bucket and the credentials of its authentication mode
+func ValidateOCIConfig(cfg *OCIConfig) error {
+	var errs []error
+	if cfg.Bucket == "" {
+		errs = append(errs, errors.New("a bucket is required"))
+	}
+	switch cfg.Auth {
+	case "", OCIAuthAPIKey:
+		if cfg.TenancyOCID == "" || cfg.UserOCID == "" || cfg.Fingerprint == "" || cfg.PrivateKeyFile == "" {
+			errs = append(errs, errors.New("API keys require a tenancy OCID, a user OCID, a fingerprint and a private key file"))
+		}
+		if cfg.Region == "" && cfg.Endpoint == "" {
+			errs = append(errs, errors.New("a region is required"))
+		}
+	case OCIAuthInstancePrincipal:
+	default:
+		errs = append(errs, fmt.Errorf("unsupported auth mode %q: must be %s or %s", cfg.Auth, OCIAuthAPIKey, OCIAuthInstancePrincipal))
+	}
+	if cfg.Endpoint != "" {
+		u, err := url.Parse(cfg.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid endpoint %q: must be an http or https URL", cfg.Endpoint))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// OCIStorage implements Storage interface for OCI Object Storage, with the native Object Storage API
+type OCIStorage struct {
+	client   *http.Client
+	signer   ociSigner
+	endpoint string
+	// bucketPath is /n/<namespace>/b/<bucket>
+	bucketPath string
+	// prefix is prepended to every object name, empty or ending with a slash
+	prefix   string
+	partSize int
+	logger   *logrus.Logger
+	metrics  *metrics.CacheMetrics
+}
+
+// NewOCIStorage creates a new OCI Object Storage instance
+func NewOCIStorage(cfg *OCIConfig, logger *logrus.Logger) (*OCIStorage, error) {
+	if cfg == nil {
+		return nil, errors.New("OCI config cannot be nil")
+	}
+	if err := ValidateOCIConfig(cfg); err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	client := &http.Client{}
+	region := cfg.Region
+	var signer ociSigner
+	if cfg.Auth == OCIAuthInstancePrincipal {
+		principal := newOCIInstancePrincipal(client, cfg.MetadataURL, cfg.AuthEndpoint)
+		if region == "" && cfg.Endpoint == "" {
+			var err error
+			if region, err = principal.region(ctx); err != nil {
+				return nil, err
+			}
+		}
+		signer = principal
+	} else {
+		data, err := os.ReadFile(cfg.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read API private key: %w", err)
+		}
+		key, err := parseRSAPrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid API private key: %w", err)
+		}
+		signer = &ociAPIKeySigner{keyID: cfg.TenancyOCID + "/" + cfg.UserOCID + "/" + cfg.Fingerprint, key: key}
+	}
+
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = "https://objectstorage." + region + ".oraclecloud.com"
+	}
+	s := &OCIStorage{
+		client:   client,
+		signer:   signer,
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		prefix:   NormalizeKeyPrefix(cfg.KeyPrefix),
+		partSize: ociPartSize,
+		logger:   logger,
+		metrics:  metrics.NewCacheMetrics(),
+	}
+
+	// The namespace is also a check of the credentials
+	namespace := cfg.Namespace
+	if namespace == "" {
+		var err error
+		if namespace, err = s.namespace(ctx); err != nil {
+			return nil, fmt.Errorf("failed to get the Object Storage namespace: %w", err)
+		}
+	}
+	s.bucketPath = "/n/" + url.PathEscape(namespace) + "/b/" + url.PathEscape(cfg.Bucket)
+
+	logger.WithFields(logrus.Fields{
+		"namespace": namespace,
+		"bucket":    cfg.Bucket,
+		"auth":      cmp.Or(cfg.Auth, OCIAuthAPIKey),
+	}).Info("Accessing OCI Object Storage")
+	return s, nil
+}
+
+// namespace looks up the Object Storage namespace of the tenancy
+func (s *OCIStorage) namespace(ctx context.Context) (string, error) {
+	resp, err := s.do(ctx, http.MethodGet, "/n/", nil, nil)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", ociError(resp)
+	}
+	defer resp.Body.Close()
+	var namespace string
+	if err := json.NewDecoder(resp.Body).Decode(&namespace); err != nil {
+		return "", err
+	}
+	return namespace, nil
+}
+
+// objectPath returns the path of a cache key under prefix, /o for objects and /u for multipart uploads
+func (s *OCIStorage) objectPath(prefix, key string) string {
+	// Object names are a single path segment, their slashes are escaped
+	return s.bucketPath + prefix + "/" + url.PathEscape(s.prefix+key)
+}
+
+// do sends a signed request, renewing instance principal tokens once if the request is rejected
+func (s *OCIStorage) do(ctx context.Context, method, path string, query url.Values, body []byte) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, s.endpoint+path, reader)
+		if err != nil {
+			return nil, err
+		}
+		req.URL.RawQuery = query.Encode()
+		if err := s.signer.sign(req, body); err != nil {
+			return nil, err
+		}
+
+		resp, err := s.client.Do(req)
+		if err != nil || resp.StatusCode != http.StatusUnauthorized || attempt > 0 || !s.signer.refresh() {
+			return resp, err
+		}
+		resp.Body.Close()
+	}
+}
+
+// ociError returns the error of an unexpected response, closing its body
+func ociError(resp *http.Response) error {
+	defer resp.Body.Close()
+	var apiErr struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&apiErr); err == nil && apiErr.Code != "" {
+		return fmt.Errorf("unexpected response %s: %s: %s", resp.Status, apiErr.Code, apiErr.Message)
+	}
+	return fmt.Errorf("unexpected response %s", resp.Status)
+}
+
+// Get downloads a file from OCI Object Storage
+func (s *OCIStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.objectPath("/o", key), nil, nil)
+	if err == nil && resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		s.metrics.RecordMiss()
+		logger.Debug(s.logger, "Cache miss: object not found in OCI", func() logrus.Fields { return logrus.Fields{"key": key} })
+		return nil, os.ErrNotExist
+	}
+	if err == nil && resp.StatusCode != http.StatusOK {
+		err = ociError(resp)
+	}
+	if err != nil {
+		class := s.metrics.RecordError("get", err)
+		s.logger.WithError(err).WithFields(logrus.Fields{
+			"key":             key,
+			errclass.LogField: class,
+		}).Error("Failed to get object from OCI")
+		return nil, fmt.Errorf("failed to get object %s: %w", key, err)
+	}
+
+	s.metrics.RecordHit()
+
+	logger.Debug(s.logger, "Cache hit: object found in OCI", func() logrus.Fields { return logrus.Fields{"key": key} })
+	return resp.Body, nil
+}
+
+// Put uploads a file to OCI Object Storage. Files larger than a part are uploaded part by part, so they're never
+// fully buffered in memory.
+func (s *OCIStorage) Put(ctx context.Context, key string, data io.Reader) error {
+	// An overwritten object only adds the difference to the cache size
+	previous, err := s.size(ctx, key)
+	if errors.Is(err, os.ErrNotExist) {
+		previous = 0
+	} else if err != nil {
+		s.logger.WithError(err).WithField("key", key).Warn("Failed to get the size of the overwritten object")
+	}
+
+	size, err := s.upload(ctx, key, data)
+	if err != nil {
+		class := s.metrics.RecordError("put", err)
+		s.logger.WithError(err).WithFields(logrus.Fields{
+			"key":             key,
+			errclass.LogField: class,
+		}).Error("Failed to upload object to OCI")
+		return fmt.Errorf("failed to upload object %s: %w", key, err)
+	}
+
+	s.metrics.AddSize(size - previous)
+
+	s.logger.WithField("path", key).Info("Successfully uploaded object to OCI")
+	return nil
+}
+
+// upload writes data to an object, returning its size
+func (s *OCIStorage) upload(ctx context.Context, key string, data io.Reader) (int64, error) {
+	part, err := s.readPart(data)
+	if err != nil {
+		return 0, err
+	}
+	if len(part) < s.partSize {
+		resp, err := s.do(ctx, http.MethodPut, s.objectPath("/o", key), nil, part)
+		if err != nil {
+			return 0, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return 0, ociError(resp)
+		}
+		resp.Body.Close()
+		return int64(len(part)), nil
+	}
+
+	request, _ := json.Marshal(map[string]string{"object": s.prefix + key})
+	resp, err := s.do(ctx, http.MethodPost, s.bucketPath+"/u", nil, request)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, ociError(resp)
+	}
+	var upload struct {
+		UploadID string `json:"uploadId"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&upload)
+	resp.Body.Close()
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode multipart upload: %w", err)
+	}
+
+	size, err := s.uploadParts(ctx, key, upload.UploadID, part, data)
+	if err != nil {
+		// Uncommitted parts are kept, and billed, until the upload is aborted
+		query := url.Values{"uploadId": {upload.UploadID}}
+		if resp, abortErr := s.do(context.WithoutCancel(ctx), http.MethodDelete, s.objectPath("/u", key), query, nil); abortErr == nil {
+			resp.Body.Close()
+		}
+		return 0, err
+	}
+	return size, nil
+}
+
+// uploadParts uploads the parts of a multipart upload starting with part, and commits them
+func (s *OCIStorage) uploadParts(ctx context.Context, key, uploadID string, part []byte, data io.Reader) (int64, error) {
+	type committedPart struct {
+		PartNum int    `json:"partNum"`
+		ETag    string `json:"etag"`
+	}
+	var parts []committedPart
+	var size int64
+	for len(part) > 0 {
+		number := len(parts) + 1
+		query := url.Values{"uploadId": {uploadID}, "uploadPartNum": {strconv.Itoa(number)}}
+		resp, err := s.do(ctx, http.MethodPut, s.objectPath("/u", key), query, part)
+		if err != nil {
+			return 0, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return 0, ociError(resp)
+		}
+		resp.Body.Close()
+		parts = append(parts, committedPart{PartNum: number, ETag: resp.Header.Get("ETag")})
+		size += int64(len(part))
+
+		if len(part) < s.partSize {
+			break
+		}
+		if part, err = s.readPart(data); err != nil {
+			return 0, err
+		}
+	}
+
+	commit, _ := json.Marshal(map[string]any{"partsToCommit": parts})
+	resp, err := s.do(ctx, http.MethodPost, s.objectPath("/u", key), url.Values{"uploadId": {uploadID}}, commit)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, ociError(resp)
+	}
+	resp.Body.Close()
+	return size, nil
+}
+
+// readPart reads up to a part from r
+func (s *OCIStorage) readPart(r io.Reader) ([]byte, error) {
+	var part bytes.Buffer
+	if _, err := io.Copy(&part, io.LimitReader(r, int64(s.partSize))); err != nil {
+		return nil, fmt.Errorf("failed to read data: %w", err)
+	}
+	return part.Bytes(), nil
+}
+
+// Exists checks if a file exists in OCI Object Storage
+func (s *OCIStorage) Exists(ctx context.Context, key string) (bool, error) {
+	resp, err := s.do(ctx, http.MethodHead, s.objectPath("/o", key), nil, nil)
+	if err == nil {
+		switch resp.StatusCode {
+		case http.StatusOK:
+			resp.Body.Close()
+			return true, nil
+		case http.StatusNotFound:
+			resp.Body.Close()
+			return false, nil
+		}
+		err = ociError(resp)
+	}
+	s.metrics.RecordError("exists", err)
+	return false, fmt.Errorf("failed to check if object exists: %w", err)
+}
+
+// List returns a page of the objects whose key starts with prefix, ordered by key
+func (s *OCIStorage) List(ctx context.Context, prefix string, opts ListOptions) (*ListResult, error) {
+	// The start name is included in the listing, so ask for one more object to skip it
+	query := url.Values{
+		"prefix": {s.prefix + prefix},
+		"limit":  {strconv.Itoa(opts.maxKeys() + 1)},
+		"fields": {"name,size,timeModified"},
+	}
+	if opts.StartAfter != "" {
+		query.Set("start", s.prefix+opts.StartAfter)
+	}
+	resp, err := s.do(ctx, http.MethodGet, s.bucketPath+"/o", query, nil)
+	if err == nil && resp.StatusCode != http.StatusOK {
+		err = ociError(resp)
+	}
+	if err != nil {
+		s.metrics.RecordError("list", err)
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+
+	var page struct {
+		Objects []struct {
+			Name         string    `json:"name"`
+			Size         int64     `json:"size"`
+			TimeModified time.Time `json:"timeModified"`
+		} `json:"objects"`
+		NextStartWith string `json:"nextStartWith"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&page)
+	resp.Body.Close()
+	if err != nil {
+		s.metrics.RecordError("list", err)
+		return nil, fmt.Errorf("failed to decode object listing: %w", err)
+	}
+
+	result := &ListResult{Objects: make([]ObjectInfo, 0, len(page.Objects))}
+	for _, object := range page.Objects {
+		key := strings.TrimPrefix(object.Name, s.prefix)
+		if key <= opts.StartAfter {
+			continue
+		}
+		if len(result.Objects) == opts.maxKeys() {
+			result.IsTruncated = true
+			break
+		}
+		result.Objects = append(result.Objects, ObjectInfo{
+			Key:          key,
+			Size:         object.Size,
+			LastModified: object.TimeModified,
+		})
+	}
+	if page.NextStartWith != "" {
+		result.IsTruncated = true
+	}
+	if result.IsTruncated && len(result.Objects) > 0 {
+		result.NextStartAfter = result.Objects[len(result.Objects)-1].Key
+	}
+
+	logger.Debug(s.logger, "Listed objects", func() logrus.Fields {
+		return logrus.Fields{
+			"prefix": prefix,
+			"count":  len(result.Objects),
+		}
+	})
+
+	return result, nil
+}
+
+// Delete deletes a single object
+func (s *OCIStorage) Delete(ctx context.Context, key string) error {
+	// The size is read first, the response to the deletion doesn't include it
+	size, err := s.size(ctx, key)
+	if err == nil {
+		err = s.remove(ctx, key)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return os.ErrNotExist
+	}
+	if err != nil {
+		s.metrics.RecordError("delete", err)
+		return fmt.Errorf("failed to delete object %s: %w", key, err)
+	}
+
+	s.metrics.SubSize(size)
+	s.metrics.RecordDeletion(1)
+	s.logger.WithField("key", key).Info("Deleted object from OCI")
+	return nil
+}
+
+// size returns the size of an object, os.ErrNotExist if it's missing
+func (s *OCIStorage) size(ctx context.Context, key string) (int64, error) {
+	resp, err := s.do(ctx, http.MethodHead, s.objectPath("/o", key), nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		resp.Body.Close()
+		return resp.ContentLength, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return 0, os.ErrNotExist
+	}
+	return 0, ociError(resp)
+}
+
+// remove deletes an object, os.ErrNotExist if it's missing
+func (s *OCIStorage) remove(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, s.objectPath("/o", key), nil, nil)
+	if err != nil {
+		return err
+	}
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusOK:
+		resp.Body.Close()
+		return nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return os.ErrNotExist
+	}
+	return ociError(resp)
+}
+
+// DeleteByPrefix deletes all objects with the given prefix. Object Storage deletes objects one by one.
+func (s *OCIStorage) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
+	s.logger.WithField("prefix", prefix).Info("Deleting objects by prefix")
+
+	// Collect the files first, so deletions don't shift the listing
+	var objects []ObjectInfo
+	err := Walk(ctx, s, prefix, func(obj ObjectInfo) error {
+		objects = append(objects, obj)
+		return nil
+	})
+	if err != nil {
+		s.metrics.RecordError("delete_by_prefix", err)
+		return 0, err
+	}
+
+	// The listed sizes are subtracted, without reading them again
+	deleted := 0
+	var totalSize int64
+	for _, obj := range objects {
+		err := s.remove(ctx, obj.Key)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			s.metrics.SubSize(totalSize)
+			s.metrics.RecordDeletion(deleted)
+			s.metrics.RecordError("delete_by_prefix", err)
+			return deleted, fmt.Errorf("failed to delete object %s: %w", obj.Key, err)
+		}
+		deleted++
+		totalSize += obj.Size
+	}
+	s.metrics.SubSize(totalSize)
+	s.metrics.RecordDeletion(deleted)
+
+	s.logger.WithFields(logrus.Fields{
+		"prefix": prefix,
+		"count":  deleted,
+		"size":   totalSize,
+	}).Info("Finished deleting objects by prefix")
+
+	return deleted, nil
+}
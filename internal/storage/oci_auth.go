@@ -0,0 +1,321 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ociMetadataURL is the instance metadata service of OCI compute instances
+	ociMetadataURL = "http://169.254.169.254/opc/v2"
+	// ociTokenRefresh is how long before they expire the security tokens of instance principals are renewed
+	ociTokenRefresh = 5 * time.Minute
+)
+
+// ociSigner signs the requests of the OCI APIs with an RSA key, following the HTTP signatures draft
+type ociSigner interface {
+	// sign adds the Date and Authorization headers to a request with the given body
+	sign(req *http.Request, body []byte) error
+	// refresh discards the credentials after a request was rejected, returning false if they can't be renewed
+	refresh() bool
+}
+
+// signOCIRequest signs a request with the key identified by keyID. Object storage PUT requests don't sign their
+// body, other requests with a body sign its length, type and checksum.
+func signOCIRequest(req *http.Request, body []byte, keyID string, key *rsa.PrivateKey) error {
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	lines := []string{
+		"date: " + req.Header.Get("Date"),
+		"(request-target): " + strings.ToLower(req.Method) + " " + req.URL.RequestURI(),
+		"host: " + req.URL.Host,
+	}
+	headers := "date (request-target) host"
+
+	if req.Method == http.MethodPost {
+		checksum := sha256.Sum256(body)
+		req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		req.Header.Set("x-content-sha256", base64.StdEncoding.EncodeToString(checksum[:]))
+		if req.Header.Get("Content-Type") == "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		lines = append(lines,
+			"content-length: "+req.Header.Get("Content-Length"),
+			"content-type: "+req.Header.Get("Content-Type"),
+			"x-content-sha256: "+req.Header.Get("x-content-sha256"),
+		)
+		headers += " content-length content-type x-content-sha256"
+	}
+
+	digest := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return fmt.Errorf("failed to sign request: %w", err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf(`Signature version="1",keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		keyID, headers, base64.StdEncoding.EncodeToString(signature)))
+	return nil
+}
+
+// parseRSAPrivateKey parses a PEM encoded PKCS #1 or PKCS #8 RSA private key
+func parseRSAPrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM encoded key found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("the private key isn't an RSA key")
+	}
+	return key, nil
+}
+
+// ociAPIKeySigner signs requests with the API key of a user
+type ociAPIKeySigner struct {
+	keyID string
+	key   *rsa.PrivateKey
+}
+
+func (s *ociAPIKeySigner) sign(req *http.Request, body []byte) error {
+	return signOCIRequest(req, body, s.keyID, s.key)
+}
+
+// refresh can't renew an API key
+func (s *ociAPIKeySigner) refresh() bool {
+	return false
+}
+
+// ociInstancePrincipal signs requests as the compute instance the process runs on. The certificate of the
+// instance, from the metadata service, is exchanged for a security token bound to a session key.
+type ociInstancePrincipal struct {
+	client       *http.Client
+	metadataURL  string
+	authEndpoint string
+
+	mu         sync.Mutex
+	token      string
+	expires    time.Time
+	sessionKey *rsa.PrivateKey
+}
+
+// newOCIInstancePrincipal returns the signer of the instance, the auth endpoint of its region is used if
+// authEndpoint is empty
+func newOCIInstancePrincipal(client *http.Client, metadataURL, authEndpoint string) *ociInstancePrincipal {
+	if metadataURL == "" {
+		metadataURL = ociMetadataURL
+	}
+	return &ociInstancePrincipal{
+		client:       client,
+		metadataURL:  strings.TrimSuffix(metadataURL, "/"),
+		authEndpoint: strings.TrimSuffix(authEndpoint, "/"),
+	}
+}
+
+// region returns the canonical name of the region of the instance, e.g. us-ashburn-1
+func (p *ociInstancePrincipal) region(ctx context.Context) (string, error) {
+	region, err := p.metadata(ctx, "/instance/canonicalRegionName")
+	return strings.TrimSpace(string(region)), err
+}
+
+// metadata reads a document of the instance metadata service
+func (p *ociInstancePrincipal) metadata(ctx context.Context, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.metadataURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer Oracle")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach the instance metadata service: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("instance metadata %s: unexpected response %s", path, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+func (p *ociInstancePrincipal) sign(req *http.Request, body []byte) error {
+	token, key, err := p.session(req.Context())
+	if err != nil {
+		return err
+	}
+	return signOCIRequest(req, body, "ST$"+token, key)
+}
+
+// refresh discards the security token, so the next request federates again
+func (p *ociInstancePrincipal) refresh() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.token = ""
+	return true
+}
+
+// session returns the current security token and its session key, federating the instance certificate again
+// when the token is about to expire
+func (p *ociInstancePrincipal) session(ctx context.Context) (string, *rsa.PrivateKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.token != "" && time.Until(p.expires) > ociTokenRefresh {
+		return p.token, p.sessionKey, nil
+	}
+
+	token, key, err := p.federate(ctx)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get an instance principal security token: %w", err)
+	}
+	expires, err := jwtExpiry(token)
+	if err != nil {
+		return "", nil, err
+	}
+	p.token, p.sessionKey, p.expires = token, key, expires
+	return token, key, nil
+}
+
+// federate exchanges the certificate of the instance for a security token bound to a new session key
+func (p *ociInstancePrincipal) federate(ctx context.Context) (string, *rsa.PrivateKey, error) {
+	certPEM, err := p.metadata(ctx, "/identity/cert.pem")
+	if err != nil {
+		return "", nil, err
+	}
+	intermediatePEM, err := p.metadata(ctx, "/identity/intermediate.pem")
+	if err != nil {
+		return "", nil, err
+	}
+	keyPEM, err := p.metadata(ctx, "/identity/key.pem")
+	if err != nil {
+		return "", nil, err
+	}
+
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return "", nil, errors.New("no PEM encoded instance certificate found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse instance certificate: %w", err)
+	}
+	tenancy := ""
+	for _, unit := range cert.Subject.OrganizationalUnit {
+		if id, ok := strings.CutPrefix(unit, "opc-tenant:"); ok {
+			tenancy = id
+		}
+	}
+	if tenancy == "" {
+		return "", nil, errors.New("the instance certificate doesn't name a tenancy")
+	}
+	certKey, err := parseRSAPrivateKey(keyPEM)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid instance key: %w", err)
+	}
+	var intermediates []string
+	for rest := intermediatePEM; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		intermediates = append(intermediates, base64.StdEncoding.EncodeToString(block.Bytes))
+	}
+
+	sessionKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return "", nil, err
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&sessionKey.PublicKey)
+	if err != nil {
+		return "", nil, err
+	}
+	body, err := json.Marshal(map[string]any{
+		"certificate":              base64.StdEncoding.EncodeToString(cert.Raw),
+		"publicKey":                base64.StdEncoding.EncodeToString(publicKey),
+		"intermediateCertificates": intermediates,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	endpoint := p.authEndpoint
+	if endpoint == "" {
+		region, err := p.region(ctx)
+		if err != nil {
+			return "", nil, err
+		}
+		endpoint = "https://auth." + region + ".oraclecloud.com"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/v1/x509", bytes.NewReader(body))
+	if err != nil {
+		return "", nil, err
+	}
+	fingerprint := sha1.Sum(cert.Raw)
+	keyID := tenancy + "/fed-x509/" + colonHex(fingerprint[:])
+	if err := signOCIRequest(req, body, keyID, certKey); err != nil {
+		return "", nil, err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, ociError(resp)
+	}
+	var token struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", nil, fmt.Errorf("failed to decode security token: %w", err)
+	}
+	return token.Token, sessionKey, nil
+}
+
+// colonHex formats a fingerprint as colon separated hex bytes, e.g. 0A:1B:...
+func colonHex(data []byte) string {
+	parts := make([]string, len(data))
+	for i, b := range data {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// jwtExpiry returns the expiry of a JSON web token, without verifying it
+func jwtExpiry(token string) (time.Time, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, errors.New("the security token isn't a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid security token: %w", err)
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}, errors.New("the security token has no expiry")
+	}
+	return time.Unix(claims.Exp, 0), nil
+}
@@ -0,0 +1,478 @@
+package storage
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeOCI serves the Object Storage API for namespace ns and bucket cachetf from memory, and the instance
+// metadata and auth services of instance principals
+type fakeOCI struct {
+	t  *testing.T
+	mu sync.Mutex
+	// keys are the public keys requests may be signed with, by key ID
+	keys    map[string]*rsa.PublicKey
+	objects map[string][]byte
+	parts   map[string]map[int][]byte
+	uploads int
+
+	// The instance certificate and key served by the metadata service, and the number of tokens issued
+	instanceCert []byte
+	instanceKey  *rsa.PrivateKey
+	federations  int
+}
+
+var ociAuthorizationPattern = regexp.MustCompile(`^Signature version="1",keyId="([^"]+)",algorithm="rsa-sha256",headers="([^"]+)",signature="([^"]+)"$`)
+
+// verify checks the signature of a request
+func (f *fakeOCI) verify(r *http.Request, body []byte) bool {
+	matches := ociAuthorizationPattern.FindStringSubmatch(r.Header.Get("Authorization"))
+	if matches == nil {
+		return false
+	}
+	key := f.keys[matches[1]]
+	if key == nil {
+		return false
+	}
+	var lines []string
+	for _, header := range strings.Fields(matches[2]) {
+		switch header {
+		case "(request-target)":
+			lines = append(lines, header+": "+strings.ToLower(r.Method)+" "+r.RequestURI)
+		case "host":
+			lines = append(lines, header+": "+r.Host)
+		default:
+			lines = append(lines, header+": "+r.Header.Get(header))
+		}
+	}
+	if r.Method == http.MethodPost {
+		checksum := sha256.Sum256(body)
+		if !strings.Contains(matches[2], "x-content-sha256") || r.Header.Get("x-content-sha256") != base64.StdEncoding.EncodeToString(checksum[:]) {
+			return false
+		}
+	}
+	signature, _ := base64.StdEncoding.DecodeString(matches[3])
+	digest := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+}
+
+func (f *fakeOCI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if metadata, ok := strings.CutPrefix(r.URL.Path, "/opc/v2/"); ok {
+		assert.Equal(f.t, "Bearer Oracle", r.Header.Get("Authorization"))
+		f.metadata(w, metadata)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	require.NoError(f.t, err)
+	if !f.verify(r, body) {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"code": "NotAuthenticated", "message": "invalid signature"})
+		return
+	}
+
+	if r.URL.Path == "/v1/x509" {
+		f.federate(w, body)
+		return
+	}
+	if r.URL.Path == "/n/" {
+		json.NewEncoder(w).Encode("ns")
+		return
+	}
+
+	query := r.URL.Query()
+	bucket, ok := strings.CutPrefix(r.URL.Path, "/n/ns/b/cachetf/")
+	require.True(f.t, ok, r.URL.Path)
+	kind, name, _ := strings.Cut(bucket, "/")
+	switch {
+	case kind == "o" && name == "" && r.Method == http.MethodGet:
+		f.list(w, query)
+	case kind == "o" && r.Method == http.MethodPut:
+		f.objects[name] = body
+	case kind == "o" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		data, ok := f.objects[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Write(data)
+	case kind == "o" && r.Method == http.MethodDelete:
+		if _, ok := f.objects[name]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	case kind == "u" && name == "" && r.Method == http.MethodPost:
+		f.uploads++
+		id := "upload-" + strconv.Itoa(f.uploads)
+		f.parts[id] = make(map[int][]byte)
+		json.NewEncoder(w).Encode(map[string]string{"uploadId": id})
+	case kind == "u" && r.Method == http.MethodPut:
+		number, _ := strconv.Atoi(query.Get("uploadPartNum"))
+		f.parts[query.Get("uploadId")][number] = body
+		w.Header().Set("ETag", "etag-"+strconv.Itoa(number))
+	case kind == "u" && r.Method == http.MethodPost:
+		var commit struct {
+			PartsToCommit []struct {
+				PartNum int    `json:"partNum"`
+				ETag    string `json:"etag"`
+			} `json:"partsToCommit"`
+		}
+		require.NoError(f.t, json.Unmarshal(body, &commit))
+		var data []byte
+		for _, part := range commit.PartsToCommit {
+			assert.Equal(f.t, "etag-"+strconv.Itoa(part.PartNum), part.ETag)
+			data = append(data, f.parts[query.Get("uploadId")][part.PartNum]...)
+		}
+		f.objects[name] = data
+		delete(f.parts, query.Get("uploadId"))
+	default:
+		f.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// list serves ListObjects
+func (f *fakeOCI) list(w http.ResponseWriter, query map[string][]string) {
+	get := func(name string) string {
+		if values := query[name]; len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	limit, _ := strconv.Atoi(get("limit"))
+	names := make([]string, 0, len(f.objects))
+	for name := range f.objects {
+		if strings.HasPrefix(name, get("prefix")) && name >= get("start") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	type object struct {
+		Name         string    `json:"name"`
+		Size         int       `json:"size"`
+		TimeModified time.Time `json:"timeModified"`
+	}
+	page := struct {
+		Objects       []object `json:"objects"`
+		NextStartWith string   `json:"nextStartWith,omitempty"`
+	}{Objects: []object{}}
+	for i, name := range names {
+		if i == limit {
+			page.NextStartWith = name
+			break
+		}
+		page.Objects = append(page.Objects, object{name, len(f.objects[name]), time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)})
+	}
+	json.NewEncoder(w).Encode(page)
+}
+
+// metadata serves the instance metadata service
+func (f *fakeOCI) metadata(w http.ResponseWriter, path string) {
+	switch path {
+	case "instance/canonicalRegionName":
+		io.WriteString(w, "us-ashburn-1")
+	case "identity/cert.pem":
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: f.instanceCert})
+	case "identity/intermediate.pem":
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: f.instanceCert})
+	case "identity/key.pem":
+		pem.Encode(w, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(f.instanceKey)})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// federate issues a security token for the session key of a federation request, valid for an hour
+func (f *fakeOCI) federate(w http.ResponseWriter, body []byte) {
+	var request struct {
+		Certificate              string   `json:"certificate"`
+		PublicKey                string   `json:"publicKey"`
+		IntermediateCertificates []string `json:"intermediateCertificates"`
+	}
+	require.NoError(f.t, json.Unmarshal(body, &request))
+	assert.Equal(f.t, base64.StdEncoding.EncodeToString(f.instanceCert), request.Certificate)
+	assert.Len(f.t, request.IntermediateCertificates, 1)
+	der, err := base64.StdEncoding.DecodeString(request.PublicKey)
+	require.NoError(f.t, err)
+	publicKey, err := x509.ParsePKIXPublicKey(der)
+	require.NoError(f.t, err)
+
+	f.federations++
+	claims, _ := json.Marshal(map[string]int64{"exp": time.Now().Add(time.Hour).Unix()})
+	token := "header." + base64.RawURLEncoding.EncodeToString(claims) + ".token-" + strconv.Itoa(f.federations)
+	f.keys["ST$"+token] = publicKey.(*rsa.PublicKey)
+	json.NewEncoder(w).Encode(map[string]string{"token": token})
+}
+
+// newFakeOCI returns a fake Object Storage API accepting the API key written to the returned config
+func newFakeOCI(t *testing.T) (*fakeOCI, *httptest.Server, *OCIConfig) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyFile := filepath.Join(t.TempDir(), "oci_api_key.pem")
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+
+	service := &fakeOCI{
+		t:       t,
+		keys:    map[string]*rsa.PublicKey{"ocid1.tenancy.test/ocid1.user.test/aa:bb": &key.PublicKey},
+		objects: make(map[string][]byte),
+		parts:   make(map[string]map[int][]byte),
+	}
+	server := httptest.NewServer(service)
+	t.Cleanup(server.Close)
+
+	return service, server, &OCIConfig{
+		Bucket:         "cachetf",
+		KeyPrefix:      "prod",
+		TenancyOCID:    "ocid1.tenancy.test",
+		UserOCID:       "ocid1.user.test",
+		Fingerprint:    "aa:bb",
+		PrivateKeyFile: keyFile,
+		Endpoint:       server.URL,
+	}
+}
+
+func newTestOCIStorage(t *testing.T, cfg *OCIConfig) *OCIStorage {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	s, err := NewOCIStorage(cfg, logger)
+	require.NoError(t, err)
+	return s
+}
+
+func TestOCIStorage(t *testing.T) {
+	service, _, cfg := newFakeOCI(t)
+	s := newTestOCIStorage(t, cfg)
+	ctx := t.Context()
+	key := "registry.terraform.io/hashicorp/random/3.7.2/terraform-provider-random_3.7.2_linux_amd64.zip"
+
+	_, err := s.Get(ctx, key)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	exists, err := s.Exists(ctx, key)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	require.NoError(t, s.Put(ctx, key, strings.NewReader("provider")))
+	assert.Contains(t, service.objects, "prod/"+key)
+
+	exists, err = s.Exists(ctx, key)
+	require.NoError(t, err)
+	assert.True(t, exists)
+	body, err := s.Get(ctx, key)
+	require.NoError(t, err)
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	body.Close()
+	assert.Equal(t, "provider", string(data))
+
+	require.NoError(t, s.Delete(ctx, key))
+	assert.ErrorIs(t, s.Delete(ctx, key), os.ErrNotExist)
+}
+
+func TestOCIStorage_SizeTracking(t *testing.T) {
+	_, _, cfg := newFakeOCI(t)
+	assertSizeTracking(t, newTestOCIStorage(t, cfg), true)
+}
+
+func TestOCIStorage_MultipartUpload(t *testing.T) {
+	service, _, cfg := newFakeOCI(t)
+	s := newTestOCIStorage(t, cfg)
+	s.partSize = 4
+
+	// Ten bytes are uploaded as three parts, eight bytes as two full parts
+	require.NoError(t, s.Put(t.Context(), "a", strings.NewReader("0123456789")))
+	assert.Equal(t, "0123456789", string(service.objects["prod/a"]))
+	require.NoError(t, s.Put(t.Context(), "b", strings.NewReader("01234567")))
+	assert.Equal(t, "01234567", string(service.objects["prod/b"]))
+	assert.Equal(t, 2, service.uploads)
+	assert.Empty(t, service.parts)
+}
+
+func TestOCIStorage_List(t *testing.T) {
+	_, _, cfg := newFakeOCI(t)
+	s := newTestOCIStorage(t, cfg)
+	ctx := t.Context()
+	for _, key := range []string{"a/1", "a/2", "a/3", "a/4", "a/5", "b/1"} {
+		require.NoError(t, s.Put(ctx, key, strings.NewReader(key)))
+	}
+
+	var keys []string
+	opts := ListOptions{MaxKeys: 2}
+	for {
+		page, err := s.List(ctx, "a/", opts)
+		require.NoError(t, err)
+		for _, obj := range page.Objects {
+			keys = append(keys, obj.Key)
+			assert.Equal(t, int64(3), obj.Size)
+			assert.Equal(t, time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC), obj.LastModified)
+		}
+		if !page.IsTruncated {
+			break
+		}
+		opts.StartAfter = page.NextStartAfter
+	}
+	assert.Equal(t, []string{"a/1", "a/2", "a/3", "a/4", "a/5"}, keys)
+
+	count, err := s.DeleteByPrefix(ctx, "a/")
+	require.NoError(t, err)
+	assert.Equal(t, 5, count)
+	exists, err := s.Exists(ctx, "b/1")
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+// withInstanceCertificate makes the metadata service of service serve an instance certificate, and returns the
+// key ID of federation requests. The certificate names the tenancy, and its fingerprint identifies the key
+// federation requests are signed with.
+func withInstanceCertificate(t *testing.T, service *fakeOCI) string {
+	instanceKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "instance", OrganizationalUnit: []string{"opc-instance:ocid1.instance.test", "opc-tenant:ocid1.tenancy.test"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &instanceKey.PublicKey, instanceKey)
+	require.NoError(t, err)
+	service.instanceCert, service.instanceKey = cert, instanceKey
+	fingerprint := sha1.Sum(cert)
+	certKeyID := "ocid1.tenancy.test/fed-x509/" + colonHex(fingerprint[:])
+	service.keys[certKeyID] = &instanceKey.PublicKey
+	return certKeyID
+}
+
+func TestOCIStorage_InstancePrincipal(t *testing.T) {
+	service, server, _ := newFakeOCI(t)
+	certKeyID := withInstanceCertificate(t, service)
+	instanceKey := service.instanceKey
+
+	s := newTestOCIStorage(t, &OCIConfig{
+		Auth:         OCIAuthInstancePrincipal,
+		Bucket:       "cachetf",
+		Endpoint:     server.URL,
+		MetadataURL:  server.URL + "/opc/v2",
+		AuthEndpoint: server.URL,
+	})
+	ctx := t.Context()
+	require.NoError(t, s.Put(ctx, "a", strings.NewReader("a")))
+	exists, err := s.Exists(ctx, "a")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, 1, service.federations)
+
+	// Rejected tokens are renewed once
+	clear(service.keys)
+	service.keys[certKeyID] = &instanceKey.PublicKey
+	exists, err = s.Exists(ctx, "a")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, 2, service.federations)
+}
+
+func TestNewOCIStorage_AuthModes(t *testing.T) {
+	service, server, apiKey := newFakeOCI(t)
+	withInstanceCertificate(t, service)
+	instancePrincipal := &OCIConfig{
+		Auth:         OCIAuthInstancePrincipal,
+		Bucket:       "cachetf",
+		Endpoint:     server.URL,
+		MetadataURL:  server.URL + "/opc/v2",
+		AuthEndpoint: server.URL,
+	}
+	explicitAPIKey := *apiKey
+	explicitAPIKey.Auth = OCIAuthAPIKey
+
+	tests := []struct {
+		name        string
+		cfg         *OCIConfig
+		signer      ociSigner
+		federations int
+	}{
+		{"default", apiKey, &ociAPIKeySigner{}, 0},
+		{"api key", &explicitAPIKey, &ociAPIKeySigner{}, 0},
+		{"instance principal", instancePrincipal, &ociInstancePrincipal{}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service.federations = 0
+			s := newTestOCIStorage(t, tt.cfg)
+			assert.IsType(t, tt.signer, s.signer)
+
+			// The requests are signed by the key of the mode, the fake accepts both
+			require.NoError(t, s.Put(t.Context(), "mode", strings.NewReader(tt.name)))
+			assert.Equal(t, tt.federations, service.federations)
+		})
+	}
+
+	// API keys don't fall back to instance principals
+	missingKey := *apiKey
+	missingKey.PrivateKeyFile = filepath.Join(t.TempDir(), "missing.pem")
+	_, err := NewOCIStorage(&missingKey, logrus.New())
+	assert.ErrorContains(t, err, "failed to read API private key")
+}
+
+func TestValidateOCIConfig(t *testing.T) {
+	apiKey := OCIConfig{
+		Bucket:         "cachetf",
+		Region:         "us-ashburn-1",
+		TenancyOCID:    "ocid1.tenancy.test",
+		UserOCID:       "ocid1.user.test",
+		Fingerprint:    "aa:bb",
+		PrivateKeyFile: "/etc/oci/key.pem",
+	}
+	assert.NoError(t, ValidateOCIConfig(&apiKey))
+	assert.NoError(t, ValidateOCIConfig(&OCIConfig{Auth: OCIAuthInstancePrincipal, Bucket: "cachetf"}))
+
+	invalid := apiKey
+	invalid.Fingerprint = ""
+	assert.ErrorContains(t, ValidateOCIConfig(&invalid), "fingerprint")
+	invalid = apiKey
+	invalid.Region = ""
+	assert.ErrorContains(t, ValidateOCIConfig(&invalid), "region")
+	assert.ErrorContains(t, ValidateOCIConfig(&OCIConfig{Auth: "resource_principal", Bucket: "cachetf"}), "unsupported auth mode")
+	assert.ErrorContains(t, ValidateOCIConfig(&OCIConfig{Auth: OCIAuthInstancePrincipal}), "bucket")
+}
+
+func TestJWTExpiry(t *testing.T) {
+	claims := base64.RawURLEncoding.EncodeToString([]byte(`{"exp":1750000000}`))
+	expires, err := jwtExpiry("e30." + claims + ".sig")
+	require.NoError(t, err)
+	assert.Equal(t, time.Unix(1750000000, 0), expires)
+
+	_, err = jwtExpiry("not-a-token")
+	assert.Error(t, err)
+}
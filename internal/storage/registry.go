@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Options are passed to the factory of a backend
+type Options struct {
+	// CacheDir is the local cache directory, used by local and tiered storage
+	CacheDir string
+	// Dedup stores the files of the cache directory by content, see LocalStorage.EnableDedup
+	Dedup bool
+	// DiskReserve is the free disk space the writes to the cache directory keep, see LocalStorage.EnableDiskGuard
+	DiskReserve int64
+	// Config is the configuration of the backend, e.g. *S3Config for s3 and tiered storage. Backends registered
+	// outside of this package can read their configuration from the environment instead.
+	Config any
+	// Uncached are the key prefixes of mutable files, which tiered storage always reads from the remote tier
+	Uncached []string
+	Logger   *logrus.Logger
+}
+
+// Factory creates a storage backend
+type Factory func(opts Options) (Storage, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes a backend available under a name, the STORAGE_TYPE selecting it. It's meant to be called from
+// init functions, so forks can add backends without changing the commands. Register panics if the name is empty
+// or already registered.
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if name == "" || factory == nil {
+		panic("storage: Register requires a name and a factory")
+	}
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("storage: backend %q registered twice", name))
+	}
+	factories[name] = factory
+}
+
+// IsRegistered returns true if a backend is registered under name
+func IsRegistered(name string) bool {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	_, ok := factories[name]
+	return ok
+}
+
+// Registered returns the names of the registered backends, sorted
+func Registered() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates the backend registered under name
+func New(name string, opts Options) (Storage, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[name]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown storage type %q", name)
+	}
+	if opts.Logger == nil {
+		opts.Logger = logrus.StandardLogger()
+	}
+	return factory(opts)
+}
+
+// backendConfig returns the configuration of a backend, which must be set
+func backendConfig[T any](name string, opts Options) (*T, error) {
+	cfg, ok := opts.Config.(*T)
+	if !ok || cfg == nil {
+		return nil, fmt.Errorf("%s storage requires a %T configuration, got %T", name, cfg, opts.Config)
+	}
+	return cfg, nil
+}
+
+// backend returns a created backend as a Storage, nil if it failed rather than a typed nil pointer
+func backend[T Storage](s T, err error) (Storage, error) {
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// newLocalStorage creates the local storage of the local and tiered backends
+func newLocalStorage(opts Options) (*LocalStorage, error) {
+	s := NewLocalStorage(opts.CacheDir, opts.Logger)
+	if opts.Dedup {
+		if err := s.EnableDedup(); err != nil {
+			return nil, err
+		}
+	}
+	if opts.DiskReserve > 0 {
+		if err := s.EnableDiskGuard(opts.DiskReserve); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// The built-in backends
+func init() {
+	Register("local", func(opts Options) (Storage, error) {
+		return backend(newLocalStorage(opts))
+	})
+	Register("s3", func(opts Options) (Storage, error) {
+		cfg, err := backendConfig[S3Config]("s3", opts)
+		if err != nil {
+			return nil, err
+		}
+		s3, err := NewS3Storage(cfg, opts.Logger)
+		if err != nil || cfg.WriteBehind == nil {
+			return backend(s3, err)
+		}
+		return backend(NewWriteBehindStorage(s3, *cfg.WriteBehind, opts.Uncached, opts.Logger))
+	})
+	// Tiered storage keeps a local copy of the files of an S3 bucket
+	Register("tiered", func(opts Options) (Storage, error) {
+		cfg, err := backendConfig[S3Config]("tiered", opts)
+		if err != nil {
+			return nil, err
+		}
+		remote, err := NewS3Storage(cfg, opts.Logger)
+		if err != nil {
+			return nil, err
+		}
+		local, err := newLocalStorage(opts)
+		if err != nil {
+			return nil, err
+		}
+		// The cache size is the size of the remote tier, the local copies aren't counted twice
+		local.DisableSizeTracking()
+		return NewTieredStorage(local, remote, opts.Uncached, opts.Logger), nil
+	})
+	Register("azure", func(opts Options) (Storage, error) {
+		cfg, err := backendConfig[AzureConfig]("azure", opts)
+		if err != nil {
+			return nil, err
+		}
+		return backend(NewAzureStorage(cfg, opts.Logger))
+	})
+	Register("b2", func(opts Options) (Storage, error) {
+		cfg, err := backendConfig[B2Config]("b2", opts)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.API == B2APIS3 {
+			return backend(NewB2S3Storage(cfg, opts.Logger))
+		}
+		return backend(NewB2Storage(cfg, opts.Logger))
+	})
+	Register("oci", func(opts Options) (Storage, error) {
+		cfg, err := backendConfig[OCIConfig]("oci", opts)
+		if err != nil {
+			return nil, err
+		}
+		return backend(NewOCIStorage(cfg, opts.Logger))
+	})
+	Register("sftp", func(opts Options) (Storage, error) {
+		cfg, err := backendConfig[SFTPConfig]("sftp", opts)
+		if err != nil {
+			return nil, err
+		}
+		return backend(NewSFTPStorage(cfg, opts.Logger))
+	})
+	Register("webdav", func(opts Options) (Storage, error) {
+		cfg, err := backendConfig[WebDAVConfig]("webdav", opts)
+		if err != nil {
+			return nil, err
+		}
+		return backend(NewWebDAVStorage(cfg, opts.Logger))
+	})
+}
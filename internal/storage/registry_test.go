@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"io"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegister(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	var received Options
+	Register("registry-test", func(opts Options) (Storage, error) {
+		received = opts
+		return NewLocalStorage(opts.CacheDir, opts.Logger), nil
+	})
+	assert.True(t, IsRegistered("registry-test"))
+	assert.Contains(t, Registered(), "registry-test")
+
+	dir := t.TempDir()
+	store, err := New("registry-test", Options{CacheDir: dir, Config: "custom", Logger: logger})
+	require.NoError(t, err)
+	assert.IsType(t, &LocalStorage{}, store)
+	assert.Equal(t, Options{CacheDir: dir, Config: "custom", Logger: logger}, received)
+
+	// Names are unique
+	assert.Panics(t, func() {
+		Register("registry-test", func(Options) (Storage, error) { return nil, nil })
+	})
+	assert.Panics(t, func() {
+		Register("", func(Options) (Storage, error) { return nil, nil })
+	})
+}
+
+func TestNew(t *testing.T) {
+	assert.Equal(t, []string{"azure", "b2", "local", "oci", "s3", "sftp", "tiered", "webdav"}, builtins(Registered()))
+
+	store, err := New("local", Options{CacheDir: t.TempDir()})
+	require.NoError(t, err)
+	assert.IsType(t, &LocalStorage{}, store)
+
+	_, err = New("missing", Options{})
+	assert.ErrorContains(t, err, `unknown storage type "missing"`)
+
+	// The built-in backends require their configuration
+	_, err = New("s3", Options{})
+	assert.ErrorContains(t, err, "requires a *storage.S3Config configuration")
+	_, err = New("webdav", Options{Config: &S3Config{}})
+	assert.ErrorContains(t, err, "requires a *storage.WebDAVConfig configuration, got *storage.S3Config")
+
+	// Failures don't return typed nil pointers
+	store, err = New("webdav", Options{Config: &WebDAVConfig{}})
+	assert.Error(t, err)
+	assert.Nil(t, store)
+}
+
+// builtins filters out the backends registered by tests
+func builtins(names []string) []string {
+	var filtered []string
+	for _, name := range names {
+		if name != "registry-test" {
+			filtered = append(filtered, name)
+		}
+	}
+	return filtered
+}
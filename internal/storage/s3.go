@@ -5,7 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/aws/retry"
@@ -14,23 +18,121 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/sirupsen/logrus"
+	"cachetf/internal/errclass"
 	"cachetf/internal/metrics"
+	"cachetf/pkg/logger"
 )
 
 // S3Storage implements Storage interface for S3
 type S3Storage struct {
 	client     *s3.Client
 	bucket     string
+	// prefix is prepended to every object key, empty or ending with a slash
+	prefix     string
 	logger     *logrus.Logger
 	uploader   *manager.Uploader
 	downloader *manager.Downloader
+	presigner  *s3.PresignClient
 	metrics    *metrics.CacheMetrics
+	// sse is the server-side encryption requested for uploads, the bucket default if empty
+	sse        types.ServerSideEncryption
+	// kmsKeyID is the KMS key of aws:kms encryption, the AWS managed key if empty
+	kmsKeyID   string
+	// requestPayer acknowledges the charges of requester pays buckets, empty if the owner pays
+	requestPayer types.RequestPayer
+	// storageClass is the storage class of uploaded objects, the bucket default if empty
+	storageClass types.StorageClass
+	// credentials are reloaded when S3 rejects them as expired
+	credentials *reloadingCredentials
 }
 
 // S3Config holds the configuration for S3 storage
 type S3Config struct {
 	Bucket string
 	Region string
+	// KeyPrefix scopes the cache to a part of the bucket, e.g. cachetf/prod/
+	KeyPrefix string
+	// Endpoint targets an S3-compatible service such as MinIO or Ceph RGW, as a URL or host[:port].
+	// AWS endpoints are used if empty.
+	Endpoint string
+	// UsePathStyle addresses buckets in the path (host/bucket/key) instead of the host name
+	// (bucket.host/key), which most S3-compatible services require
+	UsePathStyle bool
+	// DisableSSL connects to an Endpoint given without scheme over plain HTTP
+	DisableSSL bool
+	// AccessKeyID and SecretAccessKey are static credentials the bucket is accessed with, e.g. the keys of an
+	// S3-compatible service. The default credentials are used if empty.
+	AccessKeyID     string
+	SecretAccessKey string
+	// RoleARN is assumed with STS to access the bucket, e.g. in another account. The default credentials are
+	// used directly if empty.
+	RoleARN string
+	// ExternalID is passed to STS when assuming RoleARN, if the role's trust policy requires one
+	ExternalID string
+	// RoleSessionName identifies the sessions of the assumed role in CloudTrail
+	RoleSessionName string
+	// ServerSideEncryption is requested for uploaded objects, AES256 (SSE-S3) or aws:kms (SSE-KMS). The
+	// default encryption of the bucket applies if empty.
+	ServerSideEncryption string
+	// SSEKMSKeyID is the ID or ARN of the KMS key of aws:kms encryption, the AWS managed key if empty
+	SSEKMSKeyID string
+	// RequesterPays acknowledges that the requests are charged to the cache, for buckets configured with
+	// requester pays. Requests to such buckets are denied otherwise.
+	RequesterPays bool
+	// StorageClass of the uploaded objects, e.g. INTELLIGENT_TIERING, STANDARD if empty
+	StorageClass string
+	// WriteBehind acknowledges writes once staged on the local disk and uploads them in the background, see
+	// WriteBehindStorage. Writes are synchronous if nil.
+	WriteBehind *WriteBehindConfig
+}
+
+// ValidateStorageClass checks that class is empty or an S3 storage class objects can be read from directly
+func ValidateStorageClass(class string) error {
+	switch types.StorageClass(class) {
+	case "":
+		return nil
+	case types.StorageClassGlacier, types.StorageClassDeepArchive:
+		return fmt.Errorf("storage class %s requires restoring objects before they can be served", class)
+	}
+	if !slices.Contains(types.StorageClass("").Values(), types.StorageClass(class)) {
+		return fmt.Errorf("unsupported storage class %q", class)
+	}
+	return nil
+}
+
+// ValidateServerSideEncryption checks the server-side encryption settings of uploads
+func ValidateServerSideEncryption(sse, kmsKeyID string) error {
+	switch types.ServerSideEncryption(sse) {
+	case "", types.ServerSideEncryptionAes256:
+		if kmsKeyID != "" {
+			return errors.New("a KMS key requires aws:kms encryption")
+		}
+	case types.ServerSideEncryptionAwsKms:
+	default:
+		return fmt.Errorf("unsupported server-side encryption %q: must be AES256 or aws:kms", sse)
+	}
+	return nil
+}
+
+// EndpointURL returns the URL of an S3-compatible endpoint given as a URL or host[:port], using HTTP for
+// hosts if disableSSL is set. Endpoints with a scheme must use HTTP when disableSSL is set.
+func EndpointURL(endpoint string, disableSSL bool) (string, error) {
+	if !strings.Contains(endpoint, "://") {
+		scheme := "https://"
+		if disableSSL {
+			scheme = "http://"
+		}
+		endpoint = scheme + endpoint
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", fmt.Errorf("invalid S3 endpoint %q: must be an http or https URL or a host", endpoint)
+	}
+	if disableSSL && u.Scheme == "https" {
+		return "", fmt.Errorf("invalid S3 endpoint %q: SSL is disabled but the endpoint uses https", endpoint)
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
 }
 
 // NewS3Storage creates a new S3 storage instance
@@ -47,8 +149,42 @@ func NewS3Storage(cfg *S3Config, logger *logrus.Logger) (*S3Storage, error) {
 		return nil, fmt.Errorf("failed to load AWS config: %w", err)
 	}
 
+	// Assume the role with the default credentials, and resolve them again if they're rejected as expired.
+	// Requests are anonymous without credentials.
+	var credentials *reloadingCredentials
+	if provider := s3Credentials(awsCfg, cfg); provider != nil {
+		credentials = newReloadingCredentials(provider, loadS3Credentials(cfg), logger)
+		awsCfg.Credentials = credentials.cache
+	}
+	if cfg.RoleARN != "" {
+		logger.WithField("roleARN", cfg.RoleARN).Info("Accessing S3 with an assumed role")
+	}
+
+	if err := ValidateServerSideEncryption(cfg.ServerSideEncryption, cfg.SSEKMSKeyID); err != nil {
+		return nil, err
+	}
+	if err := ValidateStorageClass(cfg.StorageClass); err != nil {
+		return nil, err
+	}
+	var requestPayer types.RequestPayer
+	if cfg.RequesterPays {
+		requestPayer = types.RequestPayerRequester
+	}
+
+	var endpoint string
+	if cfg.Endpoint != "" {
+		if endpoint, err = EndpointURL(cfg.Endpoint, cfg.DisableSSL); err != nil {
+			return nil, err
+		}
+	}
+
 	// Create an S3 client with the configuration
 	s3Client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+		o.UsePathStyle = cfg.UsePathStyle
+
 		o.Retryer = retry.NewStandard(func(so *retry.StandardOptions) {
 			so.MaxAttempts = 3
 		})
@@ -63,115 +199,292 @@ func NewS3Storage(cfg *S3Config, logger *logrus.Logger) (*S3Storage, error) {
 	return &S3Storage{
 		client:     s3Client,
 		bucket:     cfg.Bucket,
+		prefix:     NormalizeKeyPrefix(cfg.KeyPrefix),
 		logger:     logger,
 		uploader:   uploader,
 		downloader: downloader,
+		presigner:  s3.NewPresignClient(s3Client),
 		metrics:    metrics.NewCacheMetrics(),
+		sse:        types.ServerSideEncryption(cfg.ServerSideEncryption),
+		kmsKeyID:   cfg.SSEKMSKeyID,
+		requestPayer: requestPayer,
+		storageClass: types.StorageClass(cfg.StorageClass),
+		credentials:  credentials,
 	}, nil
 }
 
-// Get downloads a file from S3
-func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
-	// Check if file exists first
-	exists, err := s.Exists(ctx, key)
-	if err != nil {
-		return nil, err
+// NormalizeKeyPrefix returns the prefix without leading slash and with a trailing one, empty stays empty
+func NormalizeKeyPrefix(prefix string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return ""
 	}
+	return prefix + "/"
+}
 
-	if !exists {
-		s.metrics.RecordMiss()
-		s.logger.WithField("key", key).Debug("Cache miss: file not found in S3")
-		return nil, os.ErrNotExist
-	}
+// objectKey returns the object key of a cache key
+func (s *S3Storage) objectKey(key string) string {
+	return s.prefix + key
+}
 
-	// File exists, get it
+// cacheKey returns the cache key of an object key listed from the bucket
+func (s *S3Storage) cacheKey(objectKey string) string {
+	return strings.TrimPrefix(objectKey, s.prefix)
+}
+
+// Get downloads a file from S3. Missing keys are reported by GetObject, so there's no HeadObject request first.
+func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
 	input := &s3.GetObjectInput{
-		Bucket: aws.String(s.bucket),
-		Key:    aws.String(key),
+		Bucket:       aws.String(s.bucket),
+		RequestPayer: s.requestPayer,
+		Key:          aws.String(s.objectKey(key)),
 	}
 
-	result, err := s.client.GetObject(ctx, input)
+	var result *s3.GetObjectOutput
+	err := s.retryExpired(ctx, func() (err error) {
+		result, err = s.client.GetObject(ctx, input)
+		return err
+	})
 	if err != nil {
-		s.logger.WithError(err).WithField("key", key).Error("Failed to get object from S3")
-		return nil, fmt.Errorf("failed to get object %s: %v", key, err)
+		if isNotFound(err) {
+			s.metrics.RecordMiss()
+			logger.Debug(s.logger, "Cache miss: file not found in S3", func() logrus.Fields { return logrus.Fields{"key": key} })
+			return nil, os.ErrNotExist
+		}
+		class := s.metrics.RecordError("get", err)
+		s.logger.WithError(err).WithFields(logrus.Fields{
+			"key":             key,
+			errclass.LogField: class,
+		}).Error("Failed to get object from S3")
+		return nil, fmt.Errorf("failed to get object %s: %w", key, err)
 	}
 
-	// Record the hit and update metrics
+	// Record the hit, reads don't change the cache size
 	s.metrics.RecordHit()
-	if result.ContentLength != nil {
-		s.metrics.UpdateSize(*result.ContentLength)
-	}
 
-	s.logger.WithField("key", key).Debug("Cache hit: file found in S3")
+	logger.Debug(s.logger, "Cache hit: file found in S3", func() logrus.Fields { return logrus.Fields{"key": key} })
 	return result.Body, nil
 }
 
-// Put uploads a file to S3
+// isNotFound returns true for the errors of missing keys, GetObject reports NoSuchKey and HeadObject NotFound
+func isNotFound(err error) bool {
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+	return errors.As(err, &noSuchKey) || errors.As(err, &notFound)
+}
+
+// Put uploads a file to S3. The size is counted while uploading, an overwritten object only adds the difference
+// with its previous size. Uploads rejected because the credentials expired are retried if the content can be read
+// again.
 func (s *S3Storage) Put(ctx context.Context, key string, data io.Reader) error {
-	// Check if file already exists to update size metrics
-	exists, err := s.Exists(ctx, key)
+	// HeadObject errors have no body, expired credentials can't be told apart from other failures. The upload
+	// goes on, the size is recounted by the Sizer.
+	previous, err := s.objectSize(ctx, key)
 	if err != nil {
-		s.logger.WithError(err).WithField("key", key).Error("Failed to check if object exists")
-		return fmt.Errorf("failed to check if object exists: %w", err)
+		s.logger.WithError(err).WithField("key", key).Warn("Failed to get the size of the overwritten object")
 	}
 
-	if exists {
-		// Get the current size to update metrics
-		head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
-			Bucket: aws.String(s.bucket),
-			Key:    aws.String(key),
-		})
-		if err == nil && head.ContentLength != nil {
-			s.metrics.UpdateSize(-*head.ContentLength)
+	body := &countingReader{r: data}
+	_, err = s.uploader.Upload(ctx, s.putObjectInput(key, body))
+	if seeker, ok := data.(io.Seeker); ok && s.credentials != nil && isExpiredCredentials(err) {
+		if _, seekErr := seeker.Seek(0, io.SeekStart); seekErr == nil {
+			s.credentials.reload(ctx)
+			body = &countingReader{r: data}
+			_, err = s.uploader.Upload(ctx, s.putObjectInput(key, body))
 		}
 	}
-
-	// Upload the file
-	result, err := s.uploader.Upload(ctx, &s3.PutObjectInput{
-		Bucket: aws.String(s.bucket),
-		Key:    aws.String(key),
-		Body:   data,
-	})
-
 	if err != nil {
-		s.metrics.RecordError("put")
-		return fmt.Errorf("failed to upload object %s: %v", key, err)
+		class := s.metrics.RecordError("put", err)
+		s.logger.WithError(err).WithFields(logrus.Fields{
+			"key":             key,
+			errclass.LogField: class,
+		}).Error("Failed to upload object to S3")
+		return fmt.Errorf("failed to upload object %s: %w", key, err)
 	}
 
-	// Update metrics with new size if available
-	if result != nil && result.UploadID != "" {
-		head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
-			Bucket: aws.String(s.bucket),
-			Key:    aws.String(key),
+	s.metrics.AddSize(body.n - previous)
+
+	s.logger.WithField("path", key).Info("Successfully uploaded object to S3")
+	return nil
+}
+
+// objectSize returns the size of an object, 0 if it doesn't exist
+func (s *S3Storage) objectSize(ctx context.Context, key string) (int64, error) {
+	var head *s3.HeadObjectOutput
+	err := s.retryExpired(ctx, func() (err error) {
+		head, err = s.client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket:       aws.String(s.bucket),
+			RequestPayer: s.requestPayer,
+			Key:          aws.String(s.objectKey(key)),
 		})
-		if err == nil && head.ContentLength != nil {
-			s.metrics.UpdateSize(*head.ContentLength)
+		return err
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return 0, nil
 		}
+		return 0, err
 	}
+	return aws.ToInt64(head.ContentLength), nil
+}
 
-	s.logger.WithField("path", key).Info("Successfully uploaded object to S3")
-	return nil
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// putObjectInput returns the upload request of a file, with the configured storage class and server-side
+// encryption
+func (s *S3Storage) putObjectInput(key string, data io.Reader) *s3.PutObjectInput {
+	input := &s3.PutObjectInput{
+		Bucket:       aws.String(s.bucket),
+		RequestPayer: s.requestPayer,
+		Key:          aws.String(s.objectKey(key)),
+		Body:         data,
+		StorageClass: s.storageClass,
+	}
+	if s.sse != "" {
+		input.ServerSideEncryption = s.sse
+	}
+	if s.kmsKeyID != "" {
+		input.SSEKMSKeyId = aws.String(s.kmsKeyID)
+	}
+	return input
+}
+
+// PresignGet returns a presigned GET URL of a file, valid for ttl. The object is downloaded as filename.
+func (s *S3Storage) PresignGet(ctx context.Context, key, filename string, ttl time.Duration) (string, error) {
+	request, err := s.presigner.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket:                     aws.String(s.bucket),
+		RequestPayer:               s.requestPayer,
+		Key:                        aws.String(s.objectKey(key)),
+		ResponseContentDisposition: aws.String(fmt.Sprintf("attachment; filename=%s", filename)),
+	}, s3.WithPresignExpires(ttl))
+	if err != nil {
+		s.metrics.RecordError("presign", err)
+		return "", fmt.Errorf("failed to presign object %s: %w", key, err)
+	}
+	return request.URL, nil
 }
 
 // Exists checks if a file exists in S3
 func (s *S3Storage) Exists(ctx context.Context, key string) (bool, error) {
-	_, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
-		Bucket: aws.String(s.bucket),
-		Key:    aws.String(key),
+	err := s.retryExpired(ctx, func() error {
+		_, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket:       aws.String(s.bucket),
+			RequestPayer: s.requestPayer,
+			Key:          aws.String(s.objectKey(key)),
+		})
+		return err
 	})
 
 	if err != nil {
-		var notFound *types.NotFound
-		if errors.As(err, &notFound) {
+		if isNotFound(err) {
 			return false, nil
 		}
-		s.metrics.RecordError("exists")
+		s.metrics.RecordError("exists", err)
 		return false, fmt.Errorf("failed to check if object exists: %w", err)
 	}
 
 	return true, nil
 }
 
+// List returns a page of the objects whose key starts with prefix, ordered by key
+func (s *S3Storage) List(ctx context.Context, prefix string, opts ListOptions) (*ListResult, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket:       aws.String(s.bucket),
+		RequestPayer: s.requestPayer,
+		Prefix:       aws.String(s.objectKey(prefix)),
+		MaxKeys:      aws.Int32(int32(opts.maxKeys())),
+	}
+	if opts.StartAfter != "" {
+		input.StartAfter = aws.String(s.objectKey(opts.StartAfter))
+	}
+
+	var output *s3.ListObjectsV2Output
+	err := s.retryExpired(ctx, func() (err error) {
+		output, err = s.client.ListObjectsV2(ctx, input)
+		return err
+	})
+	if err != nil {
+		s.metrics.RecordError("list", err)
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+
+	result := &ListResult{
+		Objects:     make([]ObjectInfo, 0, len(output.Contents)),
+		IsTruncated: aws.ToBool(output.IsTruncated),
+	}
+	for _, obj := range output.Contents {
+		result.Objects = append(result.Objects, ObjectInfo{
+			Key:          s.cacheKey(aws.ToString(obj.Key)),
+			Size:         aws.ToInt64(obj.Size),
+			LastModified: aws.ToTime(obj.LastModified),
+		})
+	}
+	if result.IsTruncated && len(result.Objects) > 0 {
+		result.NextStartAfter = result.Objects[len(result.Objects)-1].Key
+	}
+
+	logger.Debug(s.logger, "Listed objects", func() logrus.Fields {
+		return logrus.Fields{
+			"prefix": prefix,
+			"count":  len(result.Objects),
+		}
+	})
+
+	return result, nil
+}
+
+// Delete deletes a single object
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	// S3 doesn't report missing keys on delete, so check first
+	var head *s3.HeadObjectOutput
+	err := s.retryExpired(ctx, func() (err error) {
+		head, err = s.client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket:       aws.String(s.bucket),
+			RequestPayer: s.requestPayer,
+			Key:          aws.String(s.objectKey(key)),
+		})
+		return err
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return os.ErrNotExist
+		}
+		s.metrics.RecordError("delete", err)
+		return fmt.Errorf("failed to check if object exists: %w", err)
+	}
+
+	err = s.retryExpired(ctx, func() error {
+		_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket:       aws.String(s.bucket),
+			RequestPayer: s.requestPayer,
+			Key:          aws.String(s.objectKey(key)),
+		})
+		return err
+	})
+	if err != nil {
+		s.metrics.RecordError("delete", err)
+		return fmt.Errorf("failed to delete object %s: %w", key, err)
+	}
+
+	if head.ContentLength != nil {
+		s.metrics.SubSize(*head.ContentLength)
+	}
+	s.metrics.RecordDeletion(1)
+
+	s.logger.WithField("key", key).Info("Deleted object from S3")
+	return nil
+}
+
 // DeleteByPrefix deletes all objects with the given prefix
 func (s *S3Storage) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
 	s.logger.WithField("prefix", prefix).Info("Deleting objects by prefix")
@@ -187,13 +500,18 @@ func (s *S3Storage) DeleteByPrefix(ctx context.Context, prefix string) (int, err
 		// List objects with pagination
 		listInput := &s3.ListObjectsV2Input{
 			Bucket:            aws.String(s.bucket),
-			Prefix:            aws.String(prefix),
+			RequestPayer:      s.requestPayer,
+			Prefix:            aws.String(s.objectKey(prefix)),
 			ContinuationToken: continuationToken,
 		}
 
-		listOutput, err := s.client.ListObjectsV2(ctx, listInput)
+		var listOutput *s3.ListObjectsV2Output
+		err := s.retryExpired(ctx, func() (err error) {
+			listOutput, err = s.client.ListObjectsV2(ctx, listInput)
+			return err
+		})
 		if err != nil {
-			s.metrics.RecordError("delete_by_prefix")
+			s.metrics.RecordError("delete_by_prefix", err)
 			return deletedCount, fmt.Errorf("failed to list objects: %w", err)
 		}
 
@@ -226,16 +544,20 @@ func (s *S3Storage) DeleteByPrefix(ctx context.Context, prefix string) (int, err
 		}
 
 		batch := objectIds[i:end]
-		_, err := s.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
-			Bucket: aws.String(s.bucket),
-			Delete: &types.Delete{
-				Objects: batch,
-				Quiet:   aws.Bool(true),
-			},
+		err := s.retryExpired(ctx, func() error {
+			_, err := s.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+				Bucket:       aws.String(s.bucket),
+				RequestPayer: s.requestPayer,
+				Delete: &types.Delete{
+					Objects: batch,
+					Quiet:   aws.Bool(true),
+				},
+			})
+			return err
 		})
 
 		if err != nil {
-			s.metrics.RecordError("delete_by_prefix")
+			s.metrics.RecordError("delete_by_prefix", err)
 			return deletedCount, fmt.Errorf("failed to delete objects: %w", err)
 		}
 
@@ -244,7 +566,7 @@ func (s *S3Storage) DeleteByPrefix(ctx context.Context, prefix string) (int, err
 
 	// Update metrics
 	if totalSize > 0 {
-		s.metrics.UpdateSize(-totalSize)
+		s.metrics.SubSize(totalSize)
 	}
 	s.metrics.RecordDeletion(deletedCount)
 
@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/metrics"
+)
+
+// credentialReloadInterval is the minimum time between two reloads of the credentials, so the requests failing
+// together because of expired credentials reload them once
+const credentialReloadInterval = 10 * time.Second
+
+// expiredCredentialCodes are the error codes of AWS APIs rejecting credentials that expired or were rotated
+var expiredCredentialCodes = map[string]bool{
+	"ExpiredToken":          true,
+	"ExpiredTokenException": true,
+	"TokenRefreshRequired":  true,
+	"InvalidToken":          true,
+	"InvalidAccessKeyId":    true,
+	"InvalidClientTokenId":  true,
+}
+
+// isExpiredCredentials returns true if a request was rejected because its credentials expired or were rotated
+func isExpiredCredentials(err error) bool {
+	var api interface{ ErrorCode() string }
+	return errors.As(err, &api) && expiredCredentialCodes[api.ErrorCode()]
+}
+
+// s3Credentials returns the credentials the bucket is accessed with: the static credentials of the configuration,
+// those of the default chain, or the role assumed with them
+func s3Credentials(awsCfg aws.Config, cfg *S3Config) aws.CredentialsProvider {
+	if cfg.AccessKeyID != "" {
+		return credentials.NewStaticCredentialsProvider(cfg.AccessKeyID, cfg.SecretAccessKey, "")
+	}
+	if cfg.RoleARN == "" {
+		return awsCfg.Credentials
+	}
+	// The temporary credentials are refreshed before they expire
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(awsCfg), cfg.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+		if cfg.ExternalID != "" {
+			o.ExternalID = aws.String(cfg.ExternalID)
+		}
+		o.RoleSessionName = cfg.RoleSessionName
+	})
+	return aws.NewCredentialsCache(provider)
+}
+
+// reloadingCredentials retrieves the credentials of a provider which can be replaced. The credentials cache of the
+// SDK refreshes credentials before they expire, but keeps serving them when they're revoked early or the
+// refresh hiccups, e.g. while an IRSA token or an instance profile is rotated. Reloading resolves the whole
+// credential chain again instead of restarting the process.
+type reloadingCredentials struct {
+	// load resolves the credential chain again
+	load   func(ctx context.Context) (aws.CredentialsProvider, error)
+	logger *logrus.Logger
+	// cache is the credentials cache of the client, invalidated once the provider is replaced
+	cache *aws.CredentialsCache
+
+	mu       sync.Mutex
+	provider aws.CredentialsProvider
+	reloaded time.Time
+	now      func() time.Time
+}
+
+// newReloadingCredentials returns the credentials cache of a client retrieving its credentials from provider,
+// until load replaces it
+func newReloadingCredentials(provider aws.CredentialsProvider, load func(ctx context.Context) (aws.CredentialsProvider, error), logger *logrus.Logger) *reloadingCredentials {
+	c := &reloadingCredentials{
+		load:     load,
+		logger:   logger,
+		provider: provider,
+		now:      time.Now,
+	}
+	c.cache = aws.NewCredentialsCache(c)
+	return c
+}
+
+// Retrieve retrieves the credentials of the current provider
+func (c *reloadingCredentials) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	c.mu.Lock()
+	provider := c.provider
+	c.mu.Unlock()
+	return provider.Retrieve(ctx)
+}
+
+// IsCredentialsProvider returns true if the current provider is of the type of target
+func (c *reloadingCredentials) IsCredentialsProvider(target aws.CredentialsProvider) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return aws.IsCredentialsProvider(c.provider, target)
+}
+
+// reload resolves the credential chain again and drops the cached credentials. Reloads within
+// credentialReloadInterval of the previous one are skipped, the requests retry with the reloaded credentials.
+func (c *reloadingCredentials) reload(ctx context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if now.Sub(c.reloaded) < credentialReloadInterval {
+		return
+	}
+	c.reloaded = now
+
+	provider, err := c.load(ctx)
+	if err != nil {
+		metrics.StorageCredentialReloadsTotal.WithLabelValues("failed").Inc()
+		c.logger.WithError(err).Warn("Failed to reload the S3 credentials, retrieving them again from the same chain")
+		// Retrieving the credentials of the same chain again may be enough
+		if cache, ok := c.provider.(*aws.CredentialsCache); ok {
+			cache.Invalidate()
+		}
+	} else {
+		metrics.StorageCredentialReloadsTotal.WithLabelValues("reloaded").Inc()
+		c.logger.Warn("S3 rejected the credentials as expired, reloaded them")
+		c.provider = provider
+	}
+	c.cache.Invalidate()
+}
+
+// loadS3Credentials resolves the credentials of the bucket again, from the environment, the web identity token
+// (IRSA), the shared files or the instance profile
+func loadS3Credentials(cfg *S3Config) func(ctx context.Context) (aws.CredentialsProvider, error) {
+	return func(ctx context.Context) (aws.CredentialsProvider, error) {
+		awsCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(cfg.Region))
+		if err != nil {
+			return nil, err
+		}
+		provider := s3Credentials(awsCfg, cfg)
+		if provider == nil {
+			return nil, errors.New("no AWS credentials found")
+		}
+		return provider, nil
+	}
+}
+
+// retryExpired runs fn, and once more with reloaded credentials if the request was rejected because they expired
+func (s *S3Storage) retryExpired(ctx context.Context, fn func() error) error {
+	err := fn()
+	if err == nil || s.credentials == nil || !isExpiredCredentials(err) {
+		return err
+	}
+	s.credentials.reload(ctx)
+	return fn()
+}
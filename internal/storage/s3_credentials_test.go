@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/metrics"
+)
+
+// codeError mimics the errors of AWS APIs
+type codeError string
+
+func (e codeError) Error() string     { return string(e) }
+func (e codeError) ErrorCode() string { return string(e) }
+
+func TestIsExpiredCredentials(t *testing.T) {
+	assert.True(t, isExpiredCredentials(fmt.Errorf("operation error S3: GetObject: %w", codeError("ExpiredToken"))))
+	assert.True(t, isExpiredCredentials(codeError("InvalidAccessKeyId")))
+	assert.False(t, isExpiredCredentials(codeError("AccessDenied")))
+	assert.False(t, isExpiredCredentials(errors.New("connection reset by peer")))
+	assert.False(t, isExpiredCredentials(nil))
+}
+
+// accessKey extracts the access key ID of a signed request
+var accessKey = regexp.MustCompile(`Credential=([^/]+)/`)
+
+func TestS3Storage_ReloadsExpiredCredentials(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIAOLD")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	// S3 rejects the requests signed with rotated keys
+	var mu sync.Mutex
+	var keys []string
+	expired := map[string]bool{"AKIAOLD": true}
+	objects := make(map[string][]byte)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		key := accessKey.FindStringSubmatch(r.Header.Get("Authorization"))[1]
+		keys = append(keys, key)
+		if expired[key] {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `<Error><Code>ExpiredToken</Code><Message>The provided token has expired.</Message></Error>`)
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			objects[r.URL.Path], _ = io.ReadAll(r.Body)
+			w.Header().Set("ETag", `"etag"`)
+		case http.MethodGet:
+			w.Write(objects[r.URL.Path])
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	s, err := NewS3Storage(&S3Config{Bucket: "cachetf", Region: "us-east-1", Endpoint: server.URL, UsePathStyle: true}, logger)
+	require.NoError(t, err)
+	now := time.Now()
+	s.credentials.now = func() time.Time { return now }
+
+	// The credentials are resolved again and the upload retried once, the HeadObject of the previous size
+	// can't tell expired credentials apart
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIANEW")
+	before := testutil.ToFloat64(metrics.StorageCredentialReloadsTotal.WithLabelValues("reloaded"))
+	require.NoError(t, s.Put(t.Context(), "a.zip", strings.NewReader("provider")))
+	assert.Equal(t, []string{"AKIAOLD", "AKIAOLD", "AKIANEW"}, keys)
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.StorageCredentialReloadsTotal.WithLabelValues("reloaded")))
+
+	// and the other requests too
+	mu.Lock()
+	keys = nil
+	expired["AKIANEW"] = true
+	mu.Unlock()
+	now = now.Add(time.Minute)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIANEWER")
+	assert.Equal(t, "provider", readAll(t, s, "a.zip"))
+	assert.Equal(t, []string{"AKIANEW", "AKIANEWER"}, keys)
+
+	// Credentials rejected right after a reload aren't reloaded again, the request is only retried once
+	mu.Lock()
+	keys = nil
+	expired["AKIANEWER"] = true
+	mu.Unlock()
+	_, err = s.Get(t.Context(), "a.zip")
+	assert.True(t, isExpiredCredentials(err))
+	assert.Equal(t, []string{"AKIANEWER", "AKIANEWER"}, keys)
+	assert.Equal(t, before+2, testutil.ToFloat64(metrics.StorageCredentialReloadsTotal.WithLabelValues("reloaded")))
+}
@@ -0,0 +1,224 @@
+//go:build integration
+
+package storage
+
+// Integration tests of the S3 backend against a real S3-compatible service, such as MinIO or LocalStack, run with
+// go test -tags integration. The service is configured with the S3_INTEGRATION_* variables below and the usual
+// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, the tests are skipped without an endpoint.
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// integrationConfig returns the configuration of the S3 integration tests, skipping the test if no endpoint is
+// configured:
+//   - S3_INTEGRATION_ENDPOINT is the URL of the service, e.g. http://localhost:9000
+//   - S3_INTEGRATION_BUCKET is the bucket the tests write to, created if missing, cachetf-integration by default
+//   - S3_INTEGRATION_REGION is the region of the bucket, us-east-1 by default
+//   - S3_INTEGRATION_PATH_STYLE addresses the bucket in the path, true by default as most services require it
+//
+// Every test writes under its own key prefix, which is deleted once the test is done.
+func integrationConfig(t *testing.T) S3Config {
+	t.Helper()
+	endpoint := os.Getenv("S3_INTEGRATION_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("S3_INTEGRATION_ENDPOINT isn't set")
+	}
+	pathStyle, err := strconv.ParseBool(integrationEnv("S3_INTEGRATION_PATH_STYLE", "true"))
+	require.NoError(t, err, "invalid S3_INTEGRATION_PATH_STYLE")
+
+	return S3Config{
+		Endpoint:     endpoint,
+		Bucket:       integrationEnv("S3_INTEGRATION_BUCKET", "cachetf-integration"),
+		Region:       integrationEnv("S3_INTEGRATION_REGION", "us-east-1"),
+		UsePathStyle: pathStyle,
+		KeyPrefix:    fmt.Sprintf("cachetf-integration/%s-%d/", t.Name(), time.Now().UnixNano()),
+	}
+}
+
+// integrationEnv returns an environment variable, or defaultValue if it's unset or empty
+func integrationEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// newIntegrationStorage creates the S3 storage of a test, creating the bucket if needed. The objects written
+// under the prefix of the test are deleted on cleanup.
+func newIntegrationStorage(t *testing.T, cfg S3Config) *S3Storage {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	s, err := NewS3Storage(&cfg, logger)
+	require.NoError(t, err)
+
+	ctx := t.Context()
+	if _, err := s.client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(cfg.Bucket)}); err != nil {
+		input := &s3.CreateBucketInput{Bucket: aws.String(cfg.Bucket)}
+		if cfg.Region != "us-east-1" {
+			input.CreateBucketConfiguration = &types.CreateBucketConfiguration{
+				LocationConstraint: types.BucketLocationConstraint(cfg.Region),
+			}
+		}
+		_, err := s.client.CreateBucket(ctx, input)
+		var owned *types.BucketAlreadyOwnedByYou
+		if err != nil && !errors.As(err, &owned) {
+			require.NoError(t, err, "failed to create bucket %s", cfg.Bucket)
+		}
+	}
+
+	t.Cleanup(func() {
+		// The test context is cancelled already
+		if _, err := s.DeleteByPrefix(context.Background(), ""); err != nil {
+			t.Logf("failed to delete the objects of the test: %v", err)
+		}
+	})
+	return s
+}
+
+func TestS3Integration_Storage(t *testing.T) {
+	s := newIntegrationStorage(t, integrationConfig(t))
+	ctx := t.Context()
+
+	require.NoError(t, s.Check(ctx))
+	require.NoError(t, SelfTest(ctx, s))
+
+	for _, key := range []string{"providers/a.zip", "providers/b.zip", "providers/c.zip", "modules/d.tar.gz"} {
+		require.NoError(t, s.Put(ctx, key, strings.NewReader("content of "+key)))
+	}
+
+	r, err := s.Get(ctx, "providers/b.zip")
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	r.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "content of providers/b.zip", string(data))
+
+	_, err = s.Get(ctx, "providers/missing.zip")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	exists, err := s.Exists(ctx, "providers/a.zip")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	exists, err = s.Exists(ctx, "providers/missing.zip")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	// Pages are continued after the last key, keys are relative to the prefix of the storage
+	page, err := s.List(ctx, "providers/", ListOptions{MaxKeys: 2})
+	require.NoError(t, err)
+	require.Len(t, page.Objects, 2)
+	assert.Equal(t, "providers/a.zip", page.Objects[0].Key)
+	assert.Equal(t, int64(len("content of providers/a.zip")), page.Objects[0].Size)
+	assert.True(t, page.IsTruncated)
+	page, err = s.List(ctx, "providers/", ListOptions{MaxKeys: 2, StartAfter: page.NextStartAfter})
+	require.NoError(t, err)
+	require.Len(t, page.Objects, 1)
+	assert.Equal(t, "providers/c.zip", page.Objects[0].Key)
+	assert.False(t, page.IsTruncated)
+
+	require.NoError(t, s.Delete(ctx, "providers/a.zip"))
+	assert.ErrorIs(t, s.Delete(ctx, "providers/a.zip"), os.ErrNotExist)
+
+	deleted, err := s.DeleteByPrefix(ctx, "providers/")
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	exists, err = s.Exists(ctx, "modules/d.tar.gz")
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestS3Integration_MultipartUpload(t *testing.T) {
+	s := newIntegrationStorage(t, integrationConfig(t))
+	ctx := t.Context()
+
+	// Larger than a part of the uploader, and not seekable like a response body, so it's uploaded in parts
+	content := make([]byte, 12<<20)
+	_, err := rand.Read(content)
+	require.NoError(t, err)
+	require.NoError(t, s.Put(ctx, "providers/large.zip", io.MultiReader(bytes.NewReader(content))))
+
+	head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.objectKey("providers/large.zip")),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), aws.ToInt64(head.ContentLength))
+	// The ETag of multipart uploads ends with the number of parts
+	assert.Contains(t, aws.ToString(head.ETag), "-", "object wasn't uploaded in parts")
+
+	r, err := s.Get(ctx, "providers/large.zip")
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	r.Close()
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(content, data), "downloaded content differs")
+}
+
+func TestS3Integration_PresignGet(t *testing.T) {
+	s := newIntegrationStorage(t, integrationConfig(t))
+	ctx := t.Context()
+
+	require.NoError(t, s.Put(ctx, "providers/a.zip", strings.NewReader("presigned content")))
+	url, err := s.PresignGet(ctx, "providers/a.zip", "terraform-provider-a.zip", time.Minute)
+	require.NoError(t, err)
+
+	// The URL is usable without credentials
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(data))
+	assert.Equal(t, "presigned content", string(data))
+	assert.Equal(t, "attachment; filename=terraform-provider-a.zip", resp.Header.Get("Content-Disposition"))
+}
+
+// TestS3Integration_ServerSideEncryption requests the encryption of S3_INTEGRATION_SSE, AES256 or aws:kms with the
+// key of S3_INTEGRATION_SSE_KMS_KEY_ID. It's skipped if unset, as the service must be set up for encryption, e.g.
+// MinIO needs a KMS.
+func TestS3Integration_ServerSideEncryption(t *testing.T) {
+	cfg := integrationConfig(t)
+	cfg.ServerSideEncryption = os.Getenv("S3_INTEGRATION_SSE")
+	if cfg.ServerSideEncryption == "" {
+		t.Skip("S3_INTEGRATION_SSE isn't set")
+	}
+	cfg.SSEKMSKeyID = os.Getenv("S3_INTEGRATION_SSE_KMS_KEY_ID")
+	s := newIntegrationStorage(t, cfg)
+	ctx := t.Context()
+
+	// Large enough to be uploaded in parts, the encryption is requested when the upload is created
+	for key, size := range map[string]int{"providers/small.zip": 1 << 10, "providers/large.zip": 12 << 20} {
+		require.NoError(t, s.Put(ctx, key, bytes.NewReader(make([]byte, size))))
+
+		head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(s.objectKey(key)),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, types.ServerSideEncryption(cfg.ServerSideEncryption), head.ServerSideEncryption, key)
+		if cfg.SSEKMSKeyID != "" {
+			assert.Contains(t, aws.ToString(head.SSEKMSKeyId), cfg.SSEKMSKeyID, key)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package storage
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/metrics"
+)
+
+func TestNormalizeKeyPrefix(t *testing.T) {
+	tests := map[string]string{
+		"":              "",
+		"/":             "",
+		"cachetf":       "cachetf/",
+		"cachetf/prod/": "cachetf/prod/",
+		"/cachetf/prod": "cachetf/prod/",
+	}
+	for prefix, expected := range tests {
+		assert.Equal(t, expected, NormalizeKeyPrefix(prefix), prefix)
+	}
+}
+
+func TestS3Storage_KeyPrefix(t *testing.T) {
+	s := &S3Storage{prefix: NormalizeKeyPrefix("cachetf/prod")}
+	key := "providers/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip"
+
+	assert.Equal(t, "cachetf/prod/"+key, s.objectKey(key))
+	assert.Equal(t, key, s.cacheKey(s.objectKey(key)))
+
+	// Without prefix, keys are used as is
+	s = &S3Storage{}
+	assert.Equal(t, key, s.objectKey(key))
+	assert.Equal(t, key, s.cacheKey(key))
+}
+
+func TestEndpointURL(t *testing.T) {
+	tests := []struct {
+		endpoint   string
+		disableSSL bool
+		want       string
+		wantErr    string
+	}{
+		{endpoint: "minio.internal:9000", want: "https://minio.internal:9000"},
+		{endpoint: "minio.internal:9000", disableSSL: true, want: "http://minio.internal:9000"},
+		{endpoint: "https://rgw.example.com/", want: "https://rgw.example.com"},
+		{endpoint: "http://rgw.example.com", disableSSL: true, want: "http://rgw.example.com"},
+		{endpoint: "https://rgw.example.com", disableSSL: true, wantErr: "SSL is disabled"},
+		{endpoint: "ftp://rgw.example.com", wantErr: "must be an http or https URL"},
+		{endpoint: "http://", wantErr: "must be an http or https URL"},
+	}
+	for _, tt := range tests {
+		got, err := EndpointURL(tt.endpoint, tt.disableSSL)
+		if tt.wantErr != "" {
+			assert.ErrorContains(t, err, tt.wantErr, tt.endpoint)
+			continue
+		}
+		assert.NoError(t, err, tt.endpoint)
+		assert.Equal(t, tt.want, got, tt.endpoint)
+	}
+}
+
+func TestNewS3Storage_Endpoint(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	s, err := NewS3Storage(&S3Config{
+		Bucket:       "cachetf",
+		Region:       "us-east-1",
+		Endpoint:     "minio.internal:9000",
+		UsePathStyle: true,
+		DisableSSL:   true,
+	}, logger)
+	require.NoError(t, err)
+	options := s.client.Options()
+	require.NotNil(t, options.BaseEndpoint)
+	assert.Equal(t, "http://minio.internal:9000", *options.BaseEndpoint)
+	assert.True(t, options.UsePathStyle)
+
+	// AWS endpoints are resolved by the SDK
+	s, err = NewS3Storage(&S3Config{Bucket: "cachetf", Region: "us-east-1"}, logger)
+	require.NoError(t, err)
+	assert.Nil(t, s.client.Options().BaseEndpoint)
+	assert.False(t, s.client.Options().UsePathStyle)
+
+	_, err = NewS3Storage(&S3Config{Bucket: "cachetf", Region: "us-east-1", Endpoint: "ftp://minio"}, logger)
+	assert.Error(t, err)
+}
+
+func TestNewS3Storage_AssumeRole(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	s, err := NewS3Storage(&S3Config{
+		Bucket:          "cachetf",
+		Region:          "us-east-1",
+		RoleARN:         "arn:aws:iam::123456789012:role/cachetf",
+		ExternalID:      "mirror",
+		RoleSessionName: "cachetf",
+	}, logger)
+	require.NoError(t, err)
+
+	// The credentials of the client are those of the assumed role
+	credentials, ok := s.client.Options().Credentials.(*aws.CredentialsCache)
+	require.True(t, ok)
+	assert.True(t, credentials.IsCredentialsProvider(&stscreds.AssumeRoleProvider{}))
+
+	s, err = NewS3Storage(&S3Config{Bucket: "cachetf", Region: "us-east-1"}, logger)
+	require.NoError(t, err)
+	credentials, ok = s.client.Options().Credentials.(*aws.CredentialsCache)
+	assert.False(t, ok && credentials.IsCredentialsProvider(&stscreds.AssumeRoleProvider{}))
+}
+
+func TestS3Storage_ServerSideEncryption(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	s, err := NewS3Storage(&S3Config{
+		Bucket:               "cachetf",
+		Region:               "us-east-1",
+		ServerSideEncryption: "aws:kms",
+		SSEKMSKeyID:          "arn:aws:kms:us-east-1:123456789012:key/cachetf",
+	}, logger)
+	require.NoError(t, err)
+	input := s.putObjectInput("modules/a/b/c/1.0.0/archive.tar.gz", strings.NewReader("module"))
+	assert.Equal(t, types.ServerSideEncryptionAwsKms, input.ServerSideEncryption)
+	assert.Equal(t, "arn:aws:kms:us-east-1:123456789012:key/cachetf", aws.ToString(input.SSEKMSKeyId))
+
+	s, err = NewS3Storage(&S3Config{Bucket: "cachetf", Region: "us-east-1", ServerSideEncryption: "AES256"}, logger)
+	require.NoError(t, err)
+	input = s.putObjectInput("modules/a/b/c/1.0.0/archive.tar.gz", strings.NewReader("module"))
+	assert.Equal(t, types.ServerSideEncryptionAes256, input.ServerSideEncryption)
+	assert.Nil(t, input.SSEKMSKeyId)
+
+	// The bucket default applies
+	s, err = NewS3Storage(&S3Config{Bucket: "cachetf", Region: "us-east-1"}, logger)
+	require.NoError(t, err)
+	input = s.putObjectInput("modules/a/b/c/1.0.0/archive.tar.gz", strings.NewReader("module"))
+	assert.Empty(t, input.ServerSideEncryption)
+
+	_, err = NewS3Storage(&S3Config{Bucket: "cachetf", Region: "us-east-1", ServerSideEncryption: "AES128"}, logger)
+	assert.ErrorContains(t, err, "unsupported server-side encryption")
+	_, err = NewS3Storage(&S3Config{Bucket: "cachetf", Region: "us-east-1", SSEKMSKeyID: "alias/cachetf"}, logger)
+	assert.ErrorContains(t, err, "a KMS key requires aws:kms encryption")
+}
+
+func TestS3Storage_PresignGet(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIAEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	s, err := NewS3Storage(&S3Config{Bucket: "cachetf", Region: "us-east-1", KeyPrefix: "prod"}, logger)
+	require.NoError(t, err)
+
+	key := "providers/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip"
+	presigned, err := s.PresignGet(t.Context(), key, "terraform-provider-aws_5.0.0_linux_amd64.zip", 5*time.Minute)
+	require.NoError(t, err)
+
+	u, err := url.Parse(presigned)
+	require.NoError(t, err)
+	assert.Equal(t, "/prod/"+key, u.Path)
+	assert.Equal(t, "300", u.Query().Get("X-Amz-Expires"))
+	assert.Equal(t, "attachment; filename=terraform-provider-aws_5.0.0_linux_amd64.zip", u.Query().Get("response-content-disposition"))
+	assert.NotEmpty(t, u.Query().Get("X-Amz-Signature"))
+}
+
+func TestS3Storage_RequesterPaysAndStorageClass(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	s, err := NewS3Storage(&S3Config{
+		Bucket:        "cachetf",
+		Region:        "us-east-1",
+		RequesterPays: true,
+		StorageClass:  "INTELLIGENT_TIERING",
+	}, logger)
+	require.NoError(t, err)
+	input := s.putObjectInput("modules/a/b/c/1.0.0/archive.tar.gz", strings.NewReader("module"))
+	assert.Equal(t, types.RequestPayerRequester, input.RequestPayer)
+	assert.Equal(t, types.StorageClassIntelligentTiering, input.StorageClass)
+
+	// The bucket owner pays and the default storage class applies
+	s, err = NewS3Storage(&S3Config{Bucket: "cachetf", Region: "us-east-1"}, logger)
+	require.NoError(t, err)
+	input = s.putObjectInput("modules/a/b/c/1.0.0/archive.tar.gz", strings.NewReader("module"))
+	assert.Empty(t, input.RequestPayer)
+	assert.Empty(t, input.StorageClass)
+
+	_, err = NewS3Storage(&S3Config{Bucket: "cachetf", Region: "us-east-1", StorageClass: "COLD"}, logger)
+	assert.ErrorContains(t, err, `unsupported storage class "COLD"`)
+	_, err = NewS3Storage(&S3Config{Bucket: "cachetf", Region: "us-east-1", StorageClass: "DEEP_ARCHIVE"}, logger)
+	assert.ErrorContains(t, err, "requires restoring objects")
+}
+
+// newFakeS3 serves the objects of a bucket from memory, recording the method of every request
+func newFakeS3(t *testing.T, requests *[]string) *S3Storage {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIAEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	var mu sync.Mutex
+	objects := make(map[string][]byte)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		*requests = append(*requests, r.Method)
+
+		switch r.Method {
+		case http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			objects[r.URL.Path] = body
+			w.Header().Set("ETag", `"etag"`)
+		case http.MethodGet, http.MethodHead:
+			body, ok := objects[r.URL.Path]
+			if !ok {
+				w.Header().Set("Content-Type", "application/xml")
+				w.WriteHeader(http.StatusNotFound)
+				if r.Method == http.MethodGet {
+					io.WriteString(w, `<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`)
+				}
+				return
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			if r.Method == http.MethodGet {
+				w.Write(body)
+			}
+		case http.MethodDelete:
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	s, err := NewS3Storage(&S3Config{
+		Bucket:       "cachetf",
+		Region:       "us-east-1",
+		Endpoint:     server.URL,
+		UsePathStyle: true,
+	}, logger)
+	require.NoError(t, err)
+	return s
+}
+
+func TestS3Storage_GetPutRequests(t *testing.T) {
+	var requests []string
+	s := newFakeS3(t, &requests)
+	key := "modules/a/b/c/1.0.0/archive.tar.gz"
+
+	// A miss is a single GetObject
+	_, err := s.Get(t.Context(), key)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.Equal(t, []string{http.MethodGet}, requests)
+
+	// An upload is a PutObject after a HeadObject for the previous size, and its size is counted from the body
+	requests = nil
+	before := testutil.ToFloat64(metrics.CacheSizeBytes)
+	require.NoError(t, s.Put(t.Context(), key, strings.NewReader("module")))
+	assert.Equal(t, []string{http.MethodHead, http.MethodPut}, requests)
+	assert.Equal(t, before+6, testutil.ToFloat64(metrics.CacheSizeBytes))
+
+	// A hit is a single GetObject
+	requests = nil
+	body, err := s.Get(t.Context(), key)
+	require.NoError(t, err)
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	body.Close()
+	assert.Equal(t, "module", string(data))
+	assert.Equal(t, []string{http.MethodGet}, requests)
+
+	exists, err := s.Exists(t.Context(), "modules/a/b/c/2.0.0/archive.tar.gz")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestS3Storage_SizeTracking(t *testing.T) {
+	var requests []string
+	assertSizeTracking(t, newFakeS3(t, &requests), false)
+}
+
+func TestS3Storage_OverwriteSize(t *testing.T) {
+	var requests []string
+	s := newFakeS3(t, &requests)
+	key := "modules/a/b/c/1.0.0/archive.tar.gz"
+	before := testutil.ToFloat64(metrics.CacheSizeBytes)
+
+	// Overwrites only add the difference with the previous size
+	require.NoError(t, s.Put(t.Context(), key, strings.NewReader("module")))
+	require.NoError(t, s.Put(t.Context(), key, strings.NewReader("module v2")))
+	assert.Equal(t, before+9, testutil.ToFloat64(metrics.CacheSizeBytes))
+	require.NoError(t, s.Put(t.Context(), key, strings.NewReader("mod")))
+	assert.Equal(t, before+3, testutil.ToFloat64(metrics.CacheSizeBytes))
+
+	require.NoError(t, s.Delete(t.Context(), key))
+	assert.Equal(t, before, testutil.ToFloat64(metrics.CacheSizeBytes))
+}
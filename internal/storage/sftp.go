@@ -0,0 +1,619 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"cachetf/internal/errclass"
+	"cachetf/internal/metrics"
+	"cachetf/pkg/logger"
+)
+
+const (
+	// sftpDefaultPort is the port of servers given without one
+	sftpDefaultPort = "22"
+	// sftpDialTimeout bounds the TCP connection and the SSH handshake
+	sftpDialTimeout = 30 * time.Second
+	// sftpTempPrefix starts the names of the files being uploaded, which are renamed once complete and are
+	// never listed
+	sftpTempPrefix = ".cachetf-tmp-"
+	// sftpPosixRename is the OpenSSH extension renaming over existing files
+	sftpPosixRename = "posix-rename@openssh.com"
+)
+
+// SFTPConfig holds the configuration of SFTP storage, accessed with a private key
+type SFTPConfig struct {
+	// Host is the address of the server, host or host:port, port 22 if not set
+	Host string
+	// User is the user the private key authenticates
+	User string
+	// PrivateKeyFile is the PEM or OpenSSH encoded private key of the user
+	PrivateKeyFile string
+	// PrivateKeyPassphrase decrypts the private key, if it's encrypted
+	PrivateKeyPassphrase string
+	// KnownHostsFile lists the host keys the server is verified with, in the OpenSSH known_hosts format
+	KnownHostsFile string
+	// BasePath is the directory the files are stored in, relative to the home directory of the user unless it's
+	// absolute, the home directory if empty
+	BasePath string
+}
+
+// ValidateSFTPConfig checks that cfg names a server, the credentials of a user and the keys of the server
+func ValidateSFTPConfig(cfg *SFTPConfig) error {
+	var errs []error
+	if cfg.Host == "" {
+		errs = append(errs, errors.New("a host is required"))
+	}
+	if cfg.User == "" || cfg.PrivateKeyFile == "" {
+		errs = append(errs, errors.New("a user and a private key file are required"))
+	}
+	// Host keys are never trusted on first use
+	if cfg.KnownHostsFile == "" {
+		errs = append(errs, errors.New("a known hosts file is required"))
+	}
+	for _, segment := range strings.Split(cfg.BasePath, "/") {
+		if segment == ".." {
+			errs = append(errs, fmt.Errorf("invalid base path %q", cfg.BasePath))
+			break
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SFTPStorage implements Storage interface on an SFTP server, e.g. an existing artifact server of a restricted
+// network. Files are uploaded under a temporary name and renamed once complete, so they're never read partially
+// written.
+type SFTPStorage struct {
+	addr      string
+	sshConfig *ssh.ClientConfig
+	// basePath is the directory keys are relative to, cleaned
+	basePath string
+	logger   *logrus.Logger
+	metrics  *metrics.CacheMetrics
+
+	// The connection is opened again when it's lost, e.g. when a firewall drops it while idle
+	mu     sync.Mutex
+	client *sftpConn
+}
+
+// sftpConn is an SSH connection running the sftp subsystem
+type sftpConn struct {
+	*sftp.Client
+	conn *ssh.Client
+	// closed is closed once the connection ends
+	closed chan struct{}
+}
+
+// lost returns true once the connection ended
+func (c *sftpConn) lost() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close closes the sftp session and the SSH connection
+func (c *sftpConn) Close() error {
+	err := c.Client.Close()
+	if connErr := c.conn.Close(); err == nil && !errors.Is(connErr, net.ErrClosed) {
+		err = connErr
+	}
+	return err
+}
+
+// NewSFTPStorage creates a new SFTP storage instance, connecting to the server
+func NewSFTPStorage(cfg *SFTPConfig, logger *logrus.Logger) (*SFTPStorage, error) {
+	if cfg == nil {
+		return nil, errors.New("SFTP config cannot be nil")
+	}
+	if err := ValidateSFTPConfig(cfg); err != nil {
+		return nil, err
+	}
+
+	signer, err := loadSFTPKey(cfg.PrivateKeyFile, cfg.PrivateKeyPassphrase)
+	if err != nil {
+		return nil, err
+	}
+	hostKeyCallback, err := knownhosts.New(cfg.KnownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known hosts file: %w", err)
+	}
+
+	addr := cfg.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, sftpDefaultPort)
+	}
+	s := &SFTPStorage{
+		addr: addr,
+		sshConfig: &ssh.ClientConfig{
+			User:            cfg.User,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         sftpDialTimeout,
+		},
+		basePath: path.Clean(cfg.BasePath),
+		logger:   logger,
+		metrics:  metrics.NewCacheMetrics(),
+	}
+	if cfg.BasePath == "" {
+		s.basePath = "."
+	}
+
+	// Fail early on unreachable servers and rejected keys
+	if _, err := s.connect(); err != nil {
+		return nil, err
+	}
+	logger.WithFields(logrus.Fields{
+		"host":     addr,
+		"basePath": s.basePath,
+	}).Info("Accessing SFTP server")
+	return s, nil
+}
+
+// loadSFTPKey reads a private key, decrypting it with passphrase if set
+func loadSFTPKey(file, passphrase string) (ssh.Signer, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+	var signer ssh.Signer
+	if passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	return signer, nil
+}
+
+// Close closes the connection to the server
+func (s *SFTPStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == nil {
+		return nil
+	}
+	err := s.client.Close()
+	s.client = nil
+	return err
+}
+
+// connect returns the open connection, connecting again if it was lost
+func (s *SFTPStorage) connect() (*sftpConn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil && !s.client.lost() {
+		return s.client, nil
+	}
+	if s.client != nil {
+		s.client.Close()
+		s.client = nil
+	}
+
+	client, err := s.dial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", s.addr, err)
+	}
+	s.client = client
+	return client, nil
+}
+
+// dial opens an SSH connection and starts the sftp subsystem
+func (s *SFTPStorage) dial() (*sftpConn, error) {
+	conn, err := ssh.Dial("tcp", s.addr, s.sshConfig)
+	if err != nil {
+		return nil, err
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to start sftp subsystem: %w", err)
+	}
+
+	c := &sftpConn{Client: client, conn: conn, closed: make(chan struct{})}
+	go func() {
+		client.Wait()
+		close(c.closed)
+	}()
+	return c, nil
+}
+
+// drop closes a connection that was lost, unless it was replaced already
+func (s *SFTPStorage) drop(client *sftpConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == client {
+		s.client.Close()
+		s.client = nil
+	}
+}
+
+// connectionLost returns true if err means the connection of client was lost rather than the request failed. A
+// request sent while the connection is being closed fails with io.EOF, which none of the requests return otherwise.
+func connectionLost(client *sftpConn, err error) bool {
+	return errors.Is(err, sftp.ErrSSHFxConnectionLost) || errors.Is(err, io.EOF) || client.lost()
+}
+
+// do calls fn with the connection, once more with a new connection if it was lost. fn must be safe to repeat.
+func (s *SFTPStorage) do(fn func(client *sftpConn) error) error {
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	err = fn(client)
+	if err == nil || !connectionLost(client, err) {
+		return err
+	}
+
+	s.logger.WithError(err).WithField("host", s.addr).Warn("SFTP connection lost, reconnecting")
+	s.drop(client)
+	if client, err = s.connect(); err != nil {
+		return err
+	}
+	return fn(client)
+}
+
+// remotePath returns the path of a key on the server
+func (s *SFTPStorage) remotePath(key string) (string, error) {
+	for _, segment := range strings.Split(key, "/") {
+		if segment == ".." {
+			return "", fmt.Errorf("invalid path: %s", key)
+		}
+	}
+	return path.Join(s.basePath, key), nil
+}
+
+// Get downloads a file from the server
+func (s *SFTPStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := s.remotePath(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var file *sftp.File
+	err = s.do(func(client *sftpConn) error {
+		file, err = client.Open(p)
+		return err
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		s.metrics.RecordMiss()
+		logger.Debug(s.logger, "Cache miss: file not found on SFTP server", func() logrus.Fields { return logrus.Fields{"key": key} })
+		return nil, os.ErrNotExist
+	}
+	if err != nil {
+		class := s.metrics.RecordError("get", err)
+		s.logger.WithError(err).WithFields(logrus.Fields{
+			"key":             key,
+			errclass.LogField: class,
+		}).Error("Failed to get file from SFTP server")
+		return nil, fmt.Errorf("failed to get file %s: %w", key, err)
+	}
+
+	s.metrics.RecordHit()
+
+	logger.Debug(s.logger, "Cache hit: file found on SFTP server", func() logrus.Fields { return logrus.Fields{"key": key} })
+	return file, nil
+}
+
+// Put uploads a file to the server, replacing the file of the key once the upload is complete
+func (s *SFTPStorage) Put(ctx context.Context, key string, data io.Reader) error {
+	// An overwritten file only adds the difference with its previous size
+	previous, err := s.fileSize(key)
+	if err != nil {
+		s.logger.WithError(err).WithField("key", key).Warn("Failed to get the size of the overwritten file")
+	}
+
+	size, err := s.upload(ctx, key, data)
+	if err != nil {
+		class := s.metrics.RecordError("put", err)
+		s.logger.WithError(err).WithFields(logrus.Fields{
+			"key":             key,
+			errclass.LogField: class,
+		}).Error("Failed to upload file to SFTP server")
+		return fmt.Errorf("failed to upload file %s: %w", key, err)
+	}
+
+	s.metrics.AddSize(size - previous)
+
+	s.logger.WithField("path", key).Info("Successfully uploaded file to SFTP server")
+	return nil
+}
+
+// fileSize returns the size of a file, 0 if it doesn't exist
+func (s *SFTPStorage) fileSize(key string) (int64, error) {
+	p, err := s.remotePath(key)
+	if err != nil {
+		return 0, err
+	}
+
+	var info os.FileInfo
+	err = s.do(func(client *sftpConn) error {
+		info, err = client.Stat(p)
+		return err
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// upload writes data to a temporary file next to the file of the key and renames it, returning its size
+func (s *SFTPStorage) upload(ctx context.Context, key string, data io.Reader) (int64, error) {
+	p, err := s.remotePath(key)
+	if err != nil {
+		return 0, err
+	}
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return 0, err
+	}
+	tmp := path.Join(path.Dir(p), sftpTempPrefix+path.Base(p)+"-"+hex.EncodeToString(suffix))
+
+	// The data can only be read once, only creating the directories is repeated on a lost connection
+	var client *sftpConn
+	err = s.do(func(c *sftpConn) error {
+		client = c
+		return c.MkdirAll(path.Dir(p))
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	size, err := write(ctx, client, tmp, data)
+	if err == nil {
+		err = rename(client, tmp, p)
+	}
+	if err != nil {
+		client.Remove(tmp)
+		return 0, err
+	}
+	return size, nil
+}
+
+// write uploads data to the file at p, sending several writes at once
+func write(ctx context.Context, client *sftpConn, p string, data io.Reader) (int64, error) {
+	file, err := client.Create(p)
+	if err != nil {
+		return 0, err
+	}
+	// The library doesn't take a context, the upload stops at the next read once ctx is done
+	size, err := file.ReadFromWithConcurrency(contextReader{ctx: ctx, r: data}, 0)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return size, err
+}
+
+// rename moves the file at from to to, replacing it. Servers without the OpenSSH extension refuse to rename over an
+// existing file, it's removed first then.
+func rename(client *sftpConn, from, to string) error {
+	if _, ok := client.HasExtension(sftpPosixRename); ok {
+		return client.PosixRename(from, to)
+	}
+	if err := client.Remove(to); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return client.Rename(from, to)
+}
+
+// contextReader fails reads once its context is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// Exists checks if a file exists on the server
+func (s *SFTPStorage) Exists(ctx context.Context, key string) (bool, error) {
+	p, err := s.remotePath(key)
+	if err != nil {
+		return false, err
+	}
+
+	err = s.do(func(client *sftpConn) error {
+		_, err := client.Stat(p)
+		return err
+	})
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	s.metrics.RecordError("exists", err)
+	return false, fmt.Errorf("failed to check if file exists: %w", err)
+}
+
+// walk calls fn for the files and directories whose key starts with prefix, directories after their content.
+// Only the directories that can contain matching keys are read.
+func (s *SFTPStorage) walk(ctx context.Context, client *sftpConn, prefix string, fn func(key string, info os.FileInfo) error) error {
+	root := ""
+	if dir := path.Dir(prefix); prefix != "" && dir != "." {
+		root = dir
+	}
+	if _, err := s.remotePath(root); err != nil {
+		return fmt.Errorf("invalid prefix path: %s", prefix)
+	}
+	return s.walkDir(ctx, client, root, prefix, fn)
+}
+
+func (s *SFTPStorage) walkDir(ctx context.Context, client *sftpConn, dir, prefix string, fn func(key string, info os.FileInfo) error) error {
+	p, _ := s.remotePath(dir)
+	entries, err := client.ReadDirContext(ctx, p)
+	if err != nil {
+		// The directory doesn't exist, or was deleted meanwhile
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if strings.HasPrefix(entry.Name(), sftpTempPrefix) {
+			continue
+		}
+		key := entry.Name()
+		if dir != "" {
+			key = dir + "/" + entry.Name()
+		}
+
+		if entry.IsDir() {
+			if !strings.HasPrefix(key+"/", prefix) && !strings.HasPrefix(prefix, key+"/") {
+				continue
+			}
+			if err := s.walkDir(ctx, client, key, prefix, fn); err != nil {
+				return err
+			}
+		}
+		if strings.HasPrefix(key, prefix) {
+			if err := fn(key, entry); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// List returns a page of the files whose key starts with prefix, ordered by key
+func (s *SFTPStorage) List(ctx context.Context, prefix string, opts ListOptions) (*ListResult, error) {
+	var objects []ObjectInfo
+	err := s.do(func(client *sftpConn) error {
+		objects = nil
+		return s.walk(ctx, client, prefix, func(key string, info os.FileInfo) error {
+			if !info.IsDir() && key > opts.StartAfter {
+				objects = append(objects, ObjectInfo{
+					Key:          key,
+					Size:         info.Size(),
+					LastModified: info.ModTime(),
+				})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		s.metrics.RecordError("list", err)
+		return nil, fmt.Errorf("error listing files with prefix %s: %w", prefix, err)
+	}
+
+	// Directories are listed in the order of the server, which doesn't match plain key order
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+
+	result := &ListResult{Objects: objects}
+	if maxKeys := opts.maxKeys(); len(objects) > maxKeys {
+		result.Objects = objects[:maxKeys]
+		result.IsTruncated = true
+		result.NextStartAfter = result.Objects[maxKeys-1].Key
+	}
+
+	logger.Debug(s.logger, "Listed files", func() logrus.Fields {
+		return logrus.Fields{
+			"prefix": prefix,
+			"count":  len(result.Objects),
+		}
+	})
+
+	return result, nil
+}
+
+// Delete deletes a single file
+func (s *SFTPStorage) Delete(ctx context.Context, key string) error {
+	p, err := s.remotePath(key)
+	if err != nil {
+		return err
+	}
+
+	var info os.FileInfo
+	err = s.do(func(client *sftpConn) error {
+		if info, err = client.Stat(p); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return fmt.Errorf("%s is a directory, use DeleteByPrefix instead", key)
+		}
+		return client.Remove(p)
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return os.ErrNotExist
+	}
+	if err != nil {
+		s.metrics.RecordError("delete", err)
+		return fmt.Errorf("failed to delete file %s: %w", key, err)
+	}
+
+	s.metrics.SubSize(info.Size())
+	s.metrics.RecordDeletion(1)
+	s.logger.WithField("key", key).Info("Deleted file from SFTP server")
+	return nil
+}
+
+// DeleteByPrefix deletes all files with the given prefix, and the directories they leave empty
+func (s *SFTPStorage) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
+	s.logger.WithField("prefix", prefix).Info("Deleting files by prefix")
+
+	var deleted int
+	var size int64
+	err := s.do(func(client *sftpConn) error {
+		return s.walk(ctx, client, prefix, func(key string, info os.FileInfo) error {
+			p, _ := s.remotePath(key)
+			if info.IsDir() {
+				// Directories still holding files of other keys, or uploads, are kept
+				client.RemoveDirectory(p)
+				return nil
+			}
+			if err := client.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			deleted++
+			size += info.Size()
+			return nil
+		})
+	})
+	s.metrics.SubSize(size)
+	s.metrics.RecordDeletion(deleted)
+	if err != nil {
+		s.metrics.RecordError("delete_by_prefix", err)
+		return deleted, fmt.Errorf("failed to delete files: %w", err)
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"prefix": prefix,
+		"count":  deleted,
+		"size":   size,
+	}).Info("Finished deleting files by prefix")
+
+	return deleted, nil
+}
@@ -0,0 +1,366 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// fakeSFTP serves the sftp subsystem of an SSH server from a directory, authenticating a single user key
+type fakeSFTP struct {
+	t        *testing.T
+	root     string
+	listener net.Listener
+	config   *ssh.ServerConfig
+	// posixRename advertises the OpenSSH rename extension, the other extensions are hidden too without it
+	posixRename bool
+
+	mu    sync.Mutex
+	conns []net.Conn
+	dials int
+}
+
+// newFakeSFTP starts a server and returns it with the configuration of a storage connecting to it
+func newFakeSFTP(t *testing.T, posixRename bool) (*fakeSFTP, *SFTPConfig) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	require.NoError(t, os.Mkdir(root, 0o755))
+
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostKey, err := ssh.NewSignerFromKey(hostPriv)
+	require.NoError(t, err)
+	userPub, userPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	authorized, err := ssh.NewPublicKey(userPub)
+	require.NoError(t, err)
+
+	f := &fakeSFTP{t: t, root: root, posixRename: posixRename}
+	f.config = &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "cachetf" && bytes.Equal(key.Marshal(), authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unauthorized")
+		},
+	}
+	f.config.AddHostKey(hostKey)
+
+	f.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { f.listener.Close() })
+	go f.serve()
+
+	block, err := ssh.MarshalPrivateKey(userPriv, "")
+	require.NoError(t, err)
+	keyFile := filepath.Join(dir, "id_ed25519")
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(block), 0o600))
+	knownHostsFile := filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{f.listener.Addr().String()}, hostKey.PublicKey())
+	require.NoError(t, os.WriteFile(knownHostsFile, []byte(line+"\n"), 0o600))
+
+	return f, &SFTPConfig{
+		Host:           f.listener.Addr().String(),
+		User:           "cachetf",
+		PrivateKeyFile: keyFile,
+		KnownHostsFile: knownHostsFile,
+		BasePath:       "cache",
+	}
+}
+
+func (f *fakeSFTP) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.conns = append(f.conns, conn)
+		f.dials++
+		f.mu.Unlock()
+		go f.handle(conn)
+	}
+}
+
+// disconnect closes the open connections
+func (f *fakeSFTP) disconnect() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, conn := range f.conns {
+		conn.Close()
+	}
+	f.conns = nil
+}
+
+func (f *fakeSFTP) handle(conn net.Conn) {
+	_, channels, requests, err := ssh.NewServerConn(conn, f.config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					go func() {
+						f.serveSFTP(channel)
+						channel.Close()
+					}()
+				}
+			}
+		}()
+	}
+}
+
+// serveSFTP answers the requests of a client until it disconnects
+func (f *fakeSFTP) serveSFTP(channel ssh.Channel) {
+	var rw io.ReadWriteCloser = channel
+	if !f.posixRename {
+		rw = &hideExtensions{ReadWriteCloser: channel}
+	}
+	server, err := sftp.NewServer(rw, sftp.WithServerWorkingDirectory(f.root))
+	if err != nil {
+		return
+	}
+	server.Serve()
+	server.Close()
+}
+
+// hideExtensions answers the first packet of the server, its version, without the extensions it supports
+type hideExtensions struct {
+	io.ReadWriteCloser
+	sent bool
+}
+
+func (h *hideExtensions) Write(p []byte) (int, error) {
+	if h.sent {
+		return h.ReadWriteCloser.Write(p)
+	}
+	// length, type and version
+	h.sent = true
+	version := slices.Clone(p[:9])
+	binary.BigEndian.PutUint32(version, 5)
+	if _, err := h.ReadWriteCloser.Write(version); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func newTestSFTPStorage(t *testing.T, posixRename bool) (*SFTPStorage, *fakeSFTP) {
+	server, cfg := newFakeSFTP(t, posixRename)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	s, err := NewSFTPStorage(cfg, logger)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s, server
+}
+
+func TestSFTPStorage(t *testing.T) {
+	for _, posixRename := range []bool{true, false} {
+		s, server := newTestSFTPStorage(t, posixRename)
+		ctx := t.Context()
+		key := "registry.terraform.io/hashicorp/random/3.7.2/terraform-provider-random_3.7.2_linux_amd64.zip"
+
+		_, err := s.Get(ctx, key)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		exists, err := s.Exists(ctx, key)
+		require.NoError(t, err)
+		assert.False(t, exists)
+
+		require.NoError(t, s.Put(ctx, key, strings.NewReader("provider")))
+		data, err := os.ReadFile(filepath.Join(server.root, "cache", key))
+		require.NoError(t, err)
+		assert.Equal(t, "provider", string(data))
+
+		exists, err = s.Exists(ctx, key)
+		require.NoError(t, err)
+		assert.True(t, exists)
+		body, err := s.Get(ctx, key)
+		require.NoError(t, err)
+		data, err = io.ReadAll(body)
+		require.NoError(t, err)
+		body.Close()
+		assert.Equal(t, "provider", string(data))
+
+		// Files are replaced, with or without the rename extension
+		require.NoError(t, s.Put(ctx, key, strings.NewReader("provider v2")))
+		data, err = os.ReadFile(filepath.Join(server.root, "cache", key))
+		require.NoError(t, err)
+		assert.Equal(t, "provider v2", string(data))
+		entries, err := os.ReadDir(filepath.Dir(filepath.Join(server.root, "cache", key)))
+		require.NoError(t, err)
+		assert.Len(t, entries, 1, "temporary files are renamed")
+
+		require.NoError(t, s.Delete(ctx, key))
+		assert.ErrorIs(t, s.Delete(ctx, key), os.ErrNotExist)
+
+		_, err = s.Get(ctx, "../outside")
+		assert.Error(t, err)
+	}
+}
+
+func TestSFTPStorage_SizeTracking(t *testing.T) {
+	s, _ := newTestSFTPStorage(t, true)
+	assertSizeTracking(t, s, true)
+}
+
+func TestSFTPStorage_LargeFile(t *testing.T) {
+	s, _ := newTestSFTPStorage(t, true)
+	ctx := t.Context()
+
+	// Larger than the reads and writes sent ahead, and not a multiple of a packet
+	data := make([]byte, 3<<20+123)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	require.NoError(t, s.Put(ctx, "large", bytes.NewReader(data)))
+
+	body, err := s.Get(ctx, "large")
+	require.NoError(t, err)
+	defer body.Close()
+	read, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, data, read)
+
+	// Empty files are stored too
+	require.NoError(t, s.Put(ctx, "empty", strings.NewReader("")))
+	body, err = s.Get(ctx, "empty")
+	require.NoError(t, err)
+	defer body.Close()
+	read, err = io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Empty(t, read)
+}
+
+func TestSFTPStorage_List(t *testing.T) {
+	s, server := newTestSFTPStorage(t, true)
+	ctx := t.Context()
+	for _, key := range []string{"a/1", "a/2", "a/3", "a/x/4", "a/x/5", "ab/1", "b/1"} {
+		require.NoError(t, s.Put(ctx, key, strings.NewReader(key)))
+	}
+	// Unfinished uploads aren't listed
+	require.NoError(t, os.WriteFile(filepath.Join(server.root, "cache", "a", sftpTempPrefix+"6-0"), nil, 0o644))
+
+	var keys []string
+	opts := ListOptions{MaxKeys: 2}
+	for {
+		page, err := s.List(ctx, "a/", opts)
+		require.NoError(t, err)
+		for _, obj := range page.Objects {
+			keys = append(keys, obj.Key)
+			assert.Equal(t, int64(len(obj.Key)), obj.Size)
+			assert.WithinDuration(t, time.Now(), obj.LastModified, time.Minute)
+		}
+		if !page.IsTruncated {
+			break
+		}
+		opts.StartAfter = page.NextStartAfter
+	}
+	assert.Equal(t, []string{"a/1", "a/2", "a/3", "a/x/4", "a/x/5"}, keys)
+
+	page, err := s.List(ctx, "a", ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, page.Objects, 6)
+	page, err = s.List(ctx, "missing/", ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, page.Objects)
+
+	count, err := s.DeleteByPrefix(ctx, "a/")
+	require.NoError(t, err)
+	assert.Equal(t, 5, count)
+	_, err = os.Stat(filepath.Join(server.root, "cache", "a", "x"))
+	assert.ErrorIs(t, err, os.ErrNotExist, "emptied directories are removed")
+	for _, key := range []string{"ab/1", "b/1"} {
+		exists, err := s.Exists(ctx, key)
+		require.NoError(t, err)
+		assert.True(t, exists)
+	}
+}
+
+func TestSFTPStorage_Reconnect(t *testing.T) {
+	s, server := newTestSFTPStorage(t, true)
+	ctx := t.Context()
+	require.NoError(t, s.Put(ctx, "a", strings.NewReader("a")))
+
+	// Lost connections are opened again
+	server.disconnect()
+	exists, err := s.Exists(ctx, "a")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	server.disconnect()
+	require.NoError(t, s.Put(ctx, "b", strings.NewReader("b")))
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	assert.Equal(t, 3, server.dials)
+}
+
+func TestNewSFTPStorage(t *testing.T) {
+	server, cfg := newFakeSFTP(t, true)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	// Servers whose key isn't known are rejected
+	other, _ := newFakeSFTP(t, true)
+	unknown := *cfg
+	unknown.Host = other.listener.Addr().String()
+	_, err := NewSFTPStorage(&unknown, logger)
+	assert.Error(t, err)
+
+	// So are unknown users
+	wrongUser := *cfg
+	wrongUser.User = "other"
+	_, err = NewSFTPStorage(&wrongUser, logger)
+	assert.Error(t, err)
+
+	_, err = NewSFTPStorage(&SFTPConfig{Host: server.listener.Addr().String()}, logger)
+	assert.Error(t, err)
+}
+
+func TestValidateSFTPConfig(t *testing.T) {
+	cfg := SFTPConfig{Host: "sftp.example.com", User: "cachetf", PrivateKeyFile: "id_ed25519", KnownHostsFile: "known_hosts"}
+	assert.NoError(t, ValidateSFTPConfig(&cfg))
+
+	invalid := cfg
+	invalid.Host = ""
+	assert.Error(t, ValidateSFTPConfig(&invalid))
+	invalid = cfg
+	invalid.PrivateKeyFile = ""
+	assert.Error(t, ValidateSFTPConfig(&invalid))
+	invalid = cfg
+	invalid.KnownHostsFile = ""
+	assert.Error(t, ValidateSFTPConfig(&invalid))
+	invalid = cfg
+	invalid.BasePath = "../cache"
+	assert.Error(t, ValidateSFTPConfig(&invalid))
+}
@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/sirupsen/logrus"
+)
+
+// Checker is implemented by the backends that can check they're available, e.g. that their bucket is reachable
+type Checker interface {
+	// Check returns an error if the backend can't serve requests
+	Check(ctx context.Context) error
+}
+
+// startupMaxBackoff bounds the time between two attempts of Open
+const startupMaxBackoff = 30 * time.Second
+
+// startupBackoff is the time before the second attempt of Open, doubled after every failure
+var startupBackoff = time.Second
+
+// Open creates the backend registered under name like New and checks that it's available, if it's a Checker.
+// Failures are retried with exponential backoff until timeout elapses, so instances survive the IAM or DNS delays
+// of a fresh node. Open fails on the first error if timeout is zero, or if no backend is registered under name.
+func Open(ctx context.Context, name string, opts Options, timeout time.Duration) (Storage, error) {
+	if opts.Logger == nil {
+		opts.Logger = logrus.StandardLogger()
+	}
+
+	deadline := time.Now().Add(timeout)
+	backoff := startupBackoff
+	for attempt := 1; ; attempt++ {
+		s, err := open(ctx, name, opts)
+		if err == nil {
+			if attempt > 1 {
+				opts.Logger.WithFields(logrus.Fields{
+					"storage":  name,
+					"attempts": attempt,
+				}).Info("Storage is available")
+			}
+			return s, nil
+		}
+		if !IsRegistered(name) || time.Now().Add(backoff).After(deadline) {
+			return nil, err
+		}
+
+		opts.Logger.WithError(err).WithFields(logrus.Fields{
+			"storage": name,
+			"attempt": attempt,
+			"retryIn": backoff,
+		}).Warn("Storage isn't available yet, retrying")
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w, gave up waiting: %w", err, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, startupMaxBackoff)
+	}
+}
+
+// open creates a backend and checks it, releasing the backend if the check fails
+func open(ctx context.Context, name string, opts Options) (Storage, error) {
+	s, err := New(name, opts)
+	if err != nil {
+		return nil, err
+	}
+	checker, ok := s.(Checker)
+	if !ok {
+		return s, nil
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	if err := checker.Check(checkCtx); err != nil {
+		switch c := s.(type) {
+		case io.Closer:
+			c.Close()
+		case interface{ Close() }:
+			c.Close()
+		}
+		return nil, fmt.Errorf("%s storage isn't available: %w", name, err)
+	}
+	return s, nil
+}
+
+// selfTestPrefix is where SelfTest writes its canary objects, under the metadata documents so they're never listed
+// as cached files
+const selfTestPrefix = "metadata/selftest/"
+
+// SelfTest writes, reads back and deletes a canary object, so missing credentials or permissions fail the startup
+// instead of the first request that needs them. The error names the operation that failed.
+func SelfTest(ctx context.Context, s Storage) error {
+	var suffix [8]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return err
+	}
+	key := selfTestPrefix + "canary-" + hex.EncodeToString(suffix[:])
+	content := []byte("cachetf storage self-test " + time.Now().UTC().Format(time.RFC3339))
+
+	if err := s.Put(ctx, key, bytes.NewReader(content)); err != nil {
+		return fmt.Errorf("failed to write canary %s: %w", key, err)
+	}
+	r, err := s.Get(ctx, key)
+	if err != nil {
+		s.Delete(ctx, key)
+		return fmt.Errorf("failed to read canary %s: %w", key, err)
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err == nil && !bytes.Equal(data, content) {
+		err = fmt.Errorf("read %d bytes that differ from the %d written", len(data), len(content))
+	}
+	if err != nil {
+		s.Delete(ctx, key)
+		return fmt.Errorf("failed to read canary %s: %w", key, err)
+	}
+	if err := s.Delete(ctx, key); err != nil {
+		return fmt.Errorf("failed to delete canary %s: %w", key, err)
+	}
+	return nil
+}
+
+// Check creates the cache directory if needed and checks it's writable, e.g. that the disk isn't remounted read-only
+func (s *LocalStorage) Check(ctx context.Context) error {
+	if err := os.MkdirAll(s.baseDir, 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	f, err := os.CreateTemp(s.baseDir, ".check-*")
+	if err != nil {
+		return fmt.Errorf("cache directory isn't writable: %w", err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// Check lists the bucket, which fails if it's unreachable or the credentials can't access it
+func (s *S3Storage) Check(ctx context.Context) error {
+	return s.retryExpired(ctx, func() error {
+		_, err := s.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+			Bucket:       aws.String(s.bucket),
+			Prefix:       aws.String(s.prefix),
+			MaxKeys:      aws.Int32(1),
+			RequestPayer: s.requestPayer,
+		})
+		return err
+	})
+}
+
+// Check checks both tiers
+func (s *TieredStorage) Check(ctx context.Context) error {
+	return checkAll(ctx, s.local, s.remote)
+}
+
+// Check checks the remote storage, the staging directory exists already
+func (s *WriteBehindStorage) Check(ctx context.Context) error {
+	return checkAll(ctx, s.remote)
+}
+
+// checkAll checks the backends that are Checkers
+func checkAll(ctx context.Context, backends ...Storage) error {
+	for _, backend := range backends {
+		if checker, ok := backend.(Checker); ok {
+			if err := checker.Check(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// unavailableStorage fails its checks until available is set
+type unavailableStorage struct {
+	*LocalStorage
+	checks    *int
+	available func() bool
+	closed    *int
+}
+
+func (s *unavailableStorage) Check(ctx context.Context) error {
+	*s.checks++
+	if !s.available() {
+		return errors.New("bucket unreachable")
+	}
+	return nil
+}
+
+func (s *unavailableStorage) Close() {
+	*s.closed++
+}
+
+func TestOpen(t *testing.T) {
+	backoff := startupBackoff
+	startupBackoff = 10 * time.Millisecond
+	t.Cleanup(func() { startupBackoff = backoff })
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	var checks, closed int
+	failures := 2
+	Register("startup-test", func(opts Options) (Storage, error) {
+		return &unavailableStorage{
+			LocalStorage: NewLocalStorage(opts.CacheDir, opts.Logger),
+			checks:       &checks,
+			available:    func() bool { return checks > failures },
+			closed:       &closed,
+		}, nil
+	})
+	opts := Options{CacheDir: t.TempDir(), Logger: logger}
+
+	// Without a timeout, the first failure is returned
+	_, err := Open(t.Context(), "startup-test", opts, 0)
+	assert.ErrorContains(t, err, "startup-test storage isn't available: bucket unreachable")
+	assert.Equal(t, 1, checks)
+	assert.Equal(t, 1, closed, "backends failing their check are released")
+
+	// The check is retried until the backend is available
+	checks, closed = 0, 0
+	store, err := Open(t.Context(), "startup-test", opts, time.Minute)
+	require.NoError(t, err)
+	assert.NotNil(t, store)
+	assert.Equal(t, 3, checks)
+	assert.Equal(t, 2, closed)
+
+	// Until the timeout elapses
+	checks, failures = 0, 1000
+	start := time.Now()
+	_, err = Open(t.Context(), "startup-test", opts, 50*time.Millisecond)
+	assert.ErrorContains(t, err, "bucket unreachable")
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Greater(t, checks, 1)
+
+	// Or the context is cancelled
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	_, err = Open(ctx, "startup-test", opts, time.Minute)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// Unknown backends aren't retried
+	_, err = Open(t.Context(), "missing", opts, time.Minute)
+	assert.ErrorContains(t, err, `unknown storage type "missing"`)
+}
+
+func TestLocalStorage_Check(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	dir := t.TempDir()
+
+	// The cache directory is created
+	s := NewLocalStorage(filepath.Join(dir, "cache"), logger)
+	require.NoError(t, s.Check(t.Context()))
+	info, err := os.Stat(filepath.Join(dir, "cache"))
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+
+	// A file in the way can't be replaced
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file"), nil, 0644))
+	s = NewLocalStorage(filepath.Join(dir, "file", "cache"), logger)
+	assert.ErrorContains(t, s.Check(t.Context()), "failed to create cache directory")
+
+	// Tiered storage checks its local tier
+	tiered := NewTieredStorage(s, NewLocalStorage(dir, logger), nil, logger)
+	assert.ErrorContains(t, tiered.Check(t.Context()), "failed to create cache directory")
+}
+
+// deniedStorage fails the operation named by denied, like a backend whose credentials lack a permission
+type deniedStorage struct {
+	*LocalStorage
+	denied string
+}
+
+func (s *deniedStorage) Put(ctx context.Context, key string, r io.Reader) error {
+	if s.denied == "put" {
+		return errors.New("access denied")
+	}
+	return s.LocalStorage.Put(ctx, key, r)
+}
+
+func (s *deniedStorage) Delete(ctx context.Context, key string) error {
+	if s.denied == "delete" {
+		return errors.New("access denied")
+	}
+	return s.LocalStorage.Delete(ctx, key)
+}
+
+func TestSelfTest(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	s := NewLocalStorage(t.TempDir(), logger)
+
+	// The canary is deleted once checked
+	require.NoError(t, SelfTest(t.Context(), s))
+	result, err := s.List(t.Context(), selfTestPrefix, ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, result.Objects)
+
+	err = SelfTest(t.Context(), &deniedStorage{LocalStorage: s, denied: "put"})
+	assert.ErrorContains(t, err, "failed to write canary "+selfTestPrefix)
+	assert.ErrorContains(t, err, "access denied")
+
+	err = SelfTest(t.Context(), &deniedStorage{LocalStorage: s, denied: "delete"})
+	assert.ErrorContains(t, err, "failed to delete canary")
+}
@@ -3,8 +3,12 @@ package storage
 import (
 	"context"
 	"io"
+	"time"
 )
 
+// DefaultListMaxKeys is the page size used by List when ListOptions.MaxKeys isn't set
+const DefaultListMaxKeys = 1000
+
 // Storage defines the interface for storage backends
 type Storage interface {
 	// Get retrieves a file by key
@@ -13,6 +17,71 @@ type Storage interface {
 	Put(ctx context.Context, key string, r io.Reader) error
 	// Exists checks if a file exists
 	Exists(ctx context.Context, key string) (bool, error)
+	// List returns a page of the files whose key starts with prefix, ordered by key
+	List(ctx context.Context, prefix string, opts ListOptions) (*ListResult, error)
+	// Delete deletes a single file, returning os.ErrNotExist if it doesn't exist
+	Delete(ctx context.Context, key string) error
 	// DeleteByPrefix deletes all items with the given prefix
 	DeleteByPrefix(ctx context.Context, prefix string) (int, error)
 }
+
+// Presigner is implemented by backends clients can download files from directly
+type Presigner interface {
+	// PresignGet returns a URL the file can be downloaded from as filename until ttl elapses
+	PresignGet(ctx context.Context, key, filename string, ttl time.Duration) (string, error)
+}
+
+// ObjectInfo describes a stored file
+type ObjectInfo struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// ListOptions controls the pagination of List
+type ListOptions struct {
+	// StartAfter only returns keys that sort after it, typically the NextStartAfter of the previous page
+	StartAfter string
+	// MaxKeys is the maximum number of objects returned, DefaultListMaxKeys if not set
+	MaxKeys int
+}
+
+// ListResult is a page of objects returned by List
+type ListResult struct {
+	Objects []ObjectInfo
+	// IsTruncated is true if there are more objects after this page
+	IsTruncated bool
+	// NextStartAfter is the StartAfter value for the next page
+	NextStartAfter string
+}
+
+// maxKeys returns the page size to use for the options
+func (o ListOptions) maxKeys() int {
+	if o.MaxKeys <= 0 {
+		return DefaultListMaxKeys
+	}
+	return o.MaxKeys
+}
+
+// Walk calls fn for every file whose key starts with prefix, fetching the listing page by page.
+// It stops at the first error returned by fn.
+func Walk(ctx context.Context, s Storage, prefix string, fn func(ObjectInfo) error) error {
+	opts := ListOptions{}
+	for {
+		page, err := s.List(ctx, prefix, opts)
+		if err != nil {
+			return err
+		}
+
+		for _, obj := range page.Objects {
+			if err := fn(obj); err != nil {
+				return err
+			}
+		}
+
+		if !page.IsTruncated {
+			return nil
+		}
+		opts.StartAfter = page.NextStartAfter
+	}
+}
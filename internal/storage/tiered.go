@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/metrics"
+)
+
+// TieredStorage keeps a local copy of the files of a remote storage. Reads are served from the local tier,
+// files missing there are read from the remote tier and copied to the local tier. Writes go through to both
+// tiers, the remote tier being the source of truth for listings and deletions. Reads of the local tier that
+// fail, when the file is opened or midway, are served by the remote tier.
+type TieredStorage struct {
+	local  Storage
+	remote Storage
+	// uncached are the key prefixes of mutable files, which are always read from the remote tier
+	uncached []string
+	logger   *logrus.Logger
+}
+
+// NewTieredStorage creates a TieredStorage. Files whose key starts with one of the uncached prefixes are
+// never kept in the local tier, so instances sharing the remote tier don't serve stale copies of them.
+func NewTieredStorage(local, remote Storage, uncached []string, logger *logrus.Logger) *TieredStorage {
+	return &TieredStorage{
+		local:    local,
+		remote:   remote,
+		uncached: uncached,
+		logger:   logger,
+	}
+}
+
+// UseSpaceReclaimer makes the local tier reclaim disk space before skipping writes, if it keeps a reserve
+func (s *TieredStorage) UseSpaceReclaimer(reclaimer SpaceReclaimer) {
+	if guarded, ok := s.local.(DiskGuarded); ok {
+		guarded.UseSpaceReclaimer(reclaimer)
+	}
+}
+
+// cached returns true if the file is kept in the local tier
+func (s *TieredStorage) cached(key string) bool {
+	for _, prefix := range s.uncached {
+		if strings.HasPrefix(key, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// Get reads a file from the local tier, copying it from the remote tier first if it's missing. The remote tier
+// serves the rest of the file if reading the local copy fails.
+func (s *TieredStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if !s.cached(key) {
+		return s.remote.Get(ctx, key)
+	}
+
+	if r, err := s.local.Get(ctx, key); err == nil {
+		metrics.TieredReadsTotal.WithLabelValues("local").Inc()
+		return newFailoverReader(ctx, key, r, s.remote, s.logger), nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		metrics.TieredFailoversTotal.WithLabelValues("open").Inc()
+		s.logger.WithError(err).WithField("key", key).Warn("Failed to read from the local tier, reading from the remote tier")
+	}
+
+	r, err := s.remote.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	metrics.TieredReadsTotal.WithLabelValues("remote").Inc()
+
+	// Populate the local tier, the next reads don't reach the remote tier
+	err = s.local.Put(ctx, key, r)
+	r.Close()
+	if err == nil {
+		var local io.ReadCloser
+		if local, err = s.local.Get(ctx, key); err == nil {
+			return newFailoverReader(ctx, key, local, s.remote, s.logger), nil
+		}
+	}
+	s.logger.WithError(err).WithField("key", key).Warn("Failed to populate the local tier")
+
+	// The remote reader was consumed, read the file again
+	return s.remote.Get(ctx, key)
+}
+
+// Put writes a file to both tiers. The content is written to the local tier first and copied from there to
+// the remote tier, the local copy is removed again if the remote write fails.
+func (s *TieredStorage) Put(ctx context.Context, key string, r io.Reader) error {
+	if !s.cached(key) {
+		return s.remote.Put(ctx, key, r)
+	}
+
+	if err := s.local.Put(ctx, key, r); err != nil {
+		// Nothing was read, the file is only kept in the remote tier until the local tier has room again
+		if errors.Is(err, ErrInsufficientSpace) {
+			return s.remote.Put(ctx, key, r)
+		}
+		return fmt.Errorf("failed to write to the local tier: %w", err)
+	}
+	local, err := s.local.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to read from the local tier: %w", err)
+	}
+	defer local.Close()
+
+	if err := s.remote.Put(ctx, key, local); err != nil {
+		if err := s.local.Delete(ctx, key); err != nil {
+			s.logger.WithError(err).WithField("key", key).Warn("Failed to remove file from the local tier")
+		}
+		return err
+	}
+	return nil
+}
+
+// Exists checks the local tier first, then the remote tier
+func (s *TieredStorage) Exists(ctx context.Context, key string) (bool, error) {
+	if s.cached(key) {
+		if exists, err := s.local.Exists(ctx, key); err == nil && exists {
+			return true, nil
+		}
+	}
+	return s.remote.Exists(ctx, key)
+}
+
+// List lists the files of the remote tier, the local tier only holds a subset of them
+func (s *TieredStorage) List(ctx context.Context, prefix string, opts ListOptions) (*ListResult, error) {
+	return s.remote.List(ctx, prefix, opts)
+}
+
+// Delete deletes a file from both tiers, returning os.ErrNotExist if it isn't in the remote tier
+func (s *TieredStorage) Delete(ctx context.Context, key string) error {
+	if s.cached(key) {
+		if err := s.local.Delete(ctx, key); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to delete from the local tier: %w", err)
+		}
+	}
+	return s.remote.Delete(ctx, key)
+}
+
+// DeleteByPrefix deletes the files with the prefix from both tiers, returning the number of files deleted
+// from the remote tier
+func (s *TieredStorage) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
+	if _, err := s.local.DeleteByPrefix(ctx, prefix); err != nil {
+		return 0, fmt.Errorf("failed to delete from the local tier: %w", err)
+	}
+	return s.remote.DeleteByPrefix(ctx, prefix)
+}
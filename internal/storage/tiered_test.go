@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/metrics"
+)
+
+// setupTieredStorage creates a tiered storage with local storages as both tiers
+func setupTieredStorage(t *testing.T) (*TieredStorage, *LocalStorage, *LocalStorage) {
+	t.Helper()
+	local, _ := setupLocalStorage(t)
+	remote, _ := setupLocalStorage(t)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return NewTieredStorage(local, remote, []string{"metadata/"}, logger), local, remote
+}
+
+func readAll(t *testing.T, s Storage, key string) string {
+	t.Helper()
+	r, err := s.Get(context.Background(), key)
+	require.NoError(t, err)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestTieredStorage_GetPopulatesLocalTier(t *testing.T) {
+	tiered, local, remote := setupTieredStorage(t)
+	ctx := context.Background()
+	key := "registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip"
+	require.NoError(t, remote.Put(ctx, key, strings.NewReader("aws binary")))
+
+	beforeLocal := testutil.ToFloat64(metrics.TieredReadsTotal.WithLabelValues("local"))
+	beforeRemote := testutil.ToFloat64(metrics.TieredReadsTotal.WithLabelValues("remote"))
+
+	assert.Equal(t, "aws binary", readAll(t, tiered, key))
+	assert.Equal(t, "aws binary", readAll(t, local, key))
+
+	// The second read is served by the local tier
+	assert.Equal(t, "aws binary", readAll(t, tiered, key))
+	assert.Equal(t, beforeRemote+1, testutil.ToFloat64(metrics.TieredReadsTotal.WithLabelValues("remote")))
+	assert.Equal(t, beforeLocal+1, testutil.ToFloat64(metrics.TieredReadsTotal.WithLabelValues("local")))
+
+	_, err := tiered.Get(ctx, "missing/file.zip")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestTieredStorage_UncachedPrefixes(t *testing.T) {
+	tiered, local, remote := setupTieredStorage(t)
+	ctx := context.Background()
+	key := "metadata/indexes/providers/registry.terraform.io/hashicorp/aws.json"
+
+	require.NoError(t, tiered.Put(ctx, key, strings.NewReader(`{"old": true}`)))
+	assert.Equal(t, `{"old": true}`, readAll(t, tiered, key))
+
+	exists, err := local.Exists(ctx, key)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	// Another instance sharing the remote tier replaces the document
+	require.NoError(t, remote.Delete(ctx, key))
+	require.NoError(t, remote.Put(ctx, key, strings.NewReader(`{"new": true}`)))
+	assert.Equal(t, `{"new": true}`, readAll(t, tiered, key))
+}
+
+func TestTieredStorage_PutWritesThrough(t *testing.T) {
+	tiered, local, remote := setupTieredStorage(t)
+	ctx := context.Background()
+	key := "modules/hashicorp/consul/aws/0.1.0/archive.tar.gz"
+
+	require.NoError(t, tiered.Put(ctx, key, bytes.NewReader([]byte("module"))))
+	assert.Equal(t, "module", readAll(t, local, key))
+	assert.Equal(t, "module", readAll(t, remote, key))
+
+	exists, err := tiered.Exists(ctx, key)
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	page, err := tiered.List(ctx, "modules/", ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, page.Objects, 1)
+	assert.Equal(t, key, page.Objects[0].Key)
+
+	// Deletions remove both copies
+	require.NoError(t, tiered.Delete(ctx, key))
+	for _, s := range []Storage{local, remote, tiered} {
+		exists, err := s.Exists(ctx, key)
+		require.NoError(t, err)
+		assert.False(t, exists)
+	}
+	assert.ErrorIs(t, tiered.Delete(ctx, key), os.ErrNotExist)
+}
+
+func TestTieredStorage_SizeTracking(t *testing.T) {
+	tiered, local, _ := setupTieredStorage(t)
+	// Like the tiered backend, the files are counted once, in the remote tier
+	local.DisableSizeTracking()
+	assertSizeTracking(t, tiered, true)
+}
+
+func TestTieredStorage_PutRemoteFailure(t *testing.T) {
+	local, _ := setupLocalStorage(t)
+	remote := new(mockStorage)
+	remote.On("Put", mock.Anything, "a/b.zip", mock.Anything).Return(errors.New("access denied"))
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	tiered := NewTieredStorage(local, remote, nil, logger)
+
+	err := tiered.Put(context.Background(), "a/b.zip", strings.NewReader("content"))
+	assert.EqualError(t, err, "access denied")
+
+	// The local copy of the failed write was removed
+	exists, err := local.Exists(context.Background(), "a/b.zip")
+	require.NoError(t, err)
+	assert.False(t, exists)
+	remote.AssertExpectations(t)
+}
+
+func TestTieredStorage_PopulateFailure(t *testing.T) {
+	remote, _ := setupLocalStorage(t)
+	require.NoError(t, remote.Put(context.Background(), "a/b.zip", strings.NewReader("content")))
+	local := new(mockStorage)
+	local.On("Get", mock.Anything, "a/b.zip").Return(nil, os.ErrNotExist).Once()
+	local.On("Put", mock.Anything, "a/b.zip", mock.Anything).Return(nil)
+	local.On("Get", mock.Anything, "a/b.zip").Return(nil, errors.New("disk read error")).Once()
+	logger, hook := test.NewNullLogger()
+	tiered := NewTieredStorage(local, remote, nil, logger)
+
+	// The file is read from the remote tier again, and the failure of the local tier is logged
+	assert.Equal(t, "content", readAll(t, tiered, "a/b.zip"))
+	entry := hook.LastEntry()
+	require.NotNil(t, entry)
+	assert.Equal(t, "Failed to populate the local tier", entry.Message)
+	assert.EqualError(t, entry.Data[logrus.ErrorKey].(error), "disk read error")
+	local.AssertExpectations(t)
+}
+
+func TestTieredStorage_DeleteByPrefix(t *testing.T) {
+	tiered, local, remote := setupTieredStorage(t)
+	ctx := context.Background()
+	require.NoError(t, tiered.Put(ctx, "providers/a/1.zip", strings.NewReader("1")))
+	require.NoError(t, remote.Put(ctx, "providers/a/2.zip", strings.NewReader("2")))
+
+	deleted, err := tiered.DeleteByPrefix(ctx, "providers/a/")
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+
+	exists, err := local.Exists(ctx, "providers/a/1.zip")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
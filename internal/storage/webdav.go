@@ -0,0 +1,573 @@
+package storage
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/errclass"
+	"cachetf/internal/metrics"
+	"cachetf/pkg/logger"
+)
+
+// webdavPropfind asks for the properties List needs
+const webdavPropfind = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:"><D:prop><D:resourcetype/><D:getcontentlength/><D:getlastmodified/></D:prop></D:propfind>`
+
+// WebDAVConfig holds the configuration of WebDAV storage, e.g. an Artifactory generic repository
+type WebDAVConfig struct {
+	// URL is the collection the files are stored in, e.g. https://artifactory.example.com/artifactory/terraform/
+	URL string
+	// Username and Password authenticate with basic authentication
+	Username string
+	Password string
+	// Token authenticates with a bearer token instead, e.g. an Artifactory access token
+	Token string
+}
+
+// ValidateWebDAVConfig checks that cfg names an HTTP(S) collection and at most one kind of credentials
+func ValidateWebDAVConfig(cfg *WebDAVConfig) error {
+	var errs []error
+	if cfg.URL == "" {
+		errs = append(errs, errors.New("a URL is required"))
+	} else if u, err := url.Parse(cfg.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("invalid URL %q: must be an http or https URL", cfg.URL))
+	}
+	if cfg.Token != "" && (cfg.Username != "" || cfg.Password != "") {
+		errs = append(errs, errors.New("a token can't be combined with a username and password"))
+	}
+	if cfg.Password != "" && cfg.Username == "" {
+		errs = append(errs, errors.New("a password requires a username"))
+	}
+	return errors.Join(errs...)
+}
+
+// webdavMultistatus is the response of PROPFIND
+type webdavMultistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Status string `xml:"status"`
+			Prop   struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+				ContentLength string `xml:"getcontentlength"`
+				LastModified  string `xml:"getlastmodified"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+// webdavEntry is a file or collection listed by PROPFIND
+type webdavEntry struct {
+	// Key is the key of the file or collection, relative to the base collection
+	Key          string
+	Dir          bool
+	Size         int64
+	LastModified time.Time
+}
+
+// WebDAVStorage implements Storage interface on a WebDAV server, e.g. an Artifactory generic repository that is
+// scanned and backed up with the other artifacts of an organization. Servers must implement GET, PUT, DELETE,
+// MKCOL and PROPFIND with a depth of 1.
+//
+// The requests are made with net/http rather than a WebDAV client library: github.com/studio-b12/gowebdav doesn't
+// take a context, so downloads for clients that went away and uploads past the storage timeout couldn't be
+// aborted, and it reports deleting a missing file as a success, which Delete must tell apart.
+type WebDAVStorage struct {
+	client *http.Client
+	// base is the URL of the base collection, ending with a slash
+	base     *url.URL
+	username string
+	password string
+	token    string
+	logger   *logrus.Logger
+	metrics  *metrics.CacheMetrics
+
+	// dirs are the collections known to exist, so uploads don't create them again
+	dirs sync.Map
+}
+
+// NewWebDAVStorage creates a new WebDAV storage instance, checking that the base collection can be listed
+func NewWebDAVStorage(cfg *WebDAVConfig, logger *logrus.Logger) (*WebDAVStorage, error) {
+	if cfg == nil {
+		return nil, errors.New("WebDAV config cannot be nil")
+	}
+	if err := ValidateWebDAVConfig(cfg); err != nil {
+		return nil, err
+	}
+
+	base, _ := url.Parse(cfg.URL)
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+		base.RawPath = ""
+	}
+	s := &WebDAVStorage{
+		client:   &http.Client{},
+		base:     base,
+		username: cfg.Username,
+		password: cfg.Password,
+		token:    cfg.Token,
+		logger:   logger,
+		metrics:  metrics.NewCacheMetrics(),
+	}
+
+	// Fail early on unreachable servers, rejected credentials and missing collections
+	if _, err := s.propfind(context.Background(), "", "0"); err != nil {
+		return nil, fmt.Errorf("failed to access WebDAV collection %s: %w", base.Redacted(), err)
+	}
+	logger.WithField("url", base.Redacted()).Info("Accessing WebDAV server")
+	return s, nil
+}
+
+// url returns the URL of a key, a collection if the key ends with a slash
+func (s *WebDAVStorage) url(key string) (string, error) {
+	for _, segment := range strings.Split(key, "/") {
+		if segment == ".." || segment == "." {
+			return "", fmt.Errorf("invalid path: %s", key)
+		}
+	}
+	return s.base.String() + escapeFileName(key), nil
+}
+
+// escapeFileName percent-encodes a file name for URLs, keeping the slashes
+func escapeFileName(name string) string {
+	segments := strings.Split(name, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// do sends a request for a key with the credentials
+func (s *WebDAVStorage) do(ctx context.Context, method, key string, body io.Reader, header http.Header) (*http.Response, error) {
+	u, err := s.url(key)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	} else if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+	return s.client.Do(req)
+}
+
+// webdavError returns the error of an unexpected response, closing its body
+func webdavError(resp *http.Response) error {
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if message := strings.TrimSpace(string(body)); message != "" && !strings.HasPrefix(message, "<") {
+		return fmt.Errorf("unexpected response %s: %s", resp.Status, message)
+	}
+	return fmt.Errorf("unexpected response %s", resp.Status)
+}
+
+// Get downloads a file from the server
+func (s *WebDAVStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil)
+	if err == nil && resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		s.metrics.RecordMiss()
+		logger.Debug(s.logger, "Cache miss: file not found on WebDAV server", func() logrus.Fields { return logrus.Fields{"key": key} })
+		return nil, os.ErrNotExist
+	}
+	if err == nil && resp.StatusCode != http.StatusOK {
+		err = webdavError(resp)
+	}
+	if err != nil {
+		class := s.metrics.RecordError("get", err)
+		s.logger.WithError(err).WithFields(logrus.Fields{
+			"key":             key,
+			errclass.LogField: class,
+		}).Error("Failed to get file from WebDAV server")
+		return nil, fmt.Errorf("failed to get file %s: %w", key, err)
+	}
+
+	s.metrics.RecordHit()
+
+	logger.Debug(s.logger, "Cache hit: file found on WebDAV server", func() logrus.Fields { return logrus.Fields{"key": key} })
+	return resp.Body, nil
+}
+
+// Put uploads a file to the server, creating its parent collections. The data is streamed, servers commit the
+// file once the upload is complete.
+func (s *WebDAVStorage) Put(ctx context.Context, key string, data io.Reader) error {
+	// An overwritten file only adds the difference with its previous size
+	previous, err := s.fileSize(ctx, key)
+	if err != nil {
+		s.logger.WithError(err).WithField("key", key).Warn("Failed to get the size of the overwritten file")
+	}
+
+	size, err := s.upload(ctx, key, data)
+	if err != nil {
+		class := s.metrics.RecordError("put", err)
+		s.logger.WithError(err).WithFields(logrus.Fields{
+			"key":             key,
+			errclass.LogField: class,
+		}).Error("Failed to upload file to WebDAV server")
+		return fmt.Errorf("failed to upload file %s: %w", key, err)
+	}
+
+	s.metrics.AddSize(size - previous)
+
+	s.logger.WithField("path", key).Info("Successfully uploaded file to WebDAV server")
+	return nil
+}
+
+// fileSize returns the size of a file, 0 if it doesn't exist
+func (s *WebDAVStorage) fileSize(ctx context.Context, key string) (int64, error) {
+	entries, err := s.propfind(ctx, key, "0")
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+	return entries[0].Size, nil
+}
+
+// upload creates the parent collections of a key and writes data to it, returning its size
+func (s *WebDAVStorage) upload(ctx context.Context, key string, data io.Reader) (int64, error) {
+	if err := s.mkcolAll(ctx, path.Dir(key)); err != nil {
+		return 0, fmt.Errorf("failed to create collection: %w", err)
+	}
+
+	// The length is unknown, the body is sent chunked as it's read
+	counter := &countingReader{r: data}
+	resp, err := s.do(ctx, http.MethodPut, key, counter, nil)
+	if err != nil {
+		return 0, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		resp.Body.Close()
+		return counter.n, nil
+	case http.StatusConflict:
+		// The collections were deleted meanwhile, the next upload creates them again
+		s.forgetDirs(key)
+	}
+	return 0, webdavError(resp)
+}
+
+// mkcolAll creates a collection with its parents, skipping the collections known to exist
+func (s *WebDAVStorage) mkcolAll(ctx context.Context, dir string) error {
+	if dir == "." || dir == "" {
+		return nil
+	}
+	if _, ok := s.dirs.Load(dir); ok {
+		return nil
+	}
+	if err := s.mkcolAll(ctx, path.Dir(dir)); err != nil {
+		return err
+	}
+
+	resp, err := s.do(ctx, "MKCOL", dir+"/", nil, nil)
+	if err != nil {
+		return err
+	}
+	switch resp.StatusCode {
+	// 405 Method Not Allowed is the response of existing collections
+	case http.StatusCreated, http.StatusOK, http.StatusMethodNotAllowed:
+		resp.Body.Close()
+		s.dirs.Store(dir, true)
+		return nil
+	}
+	return webdavError(resp)
+}
+
+// forgetDirs forgets the collections of a key and their descendants
+func (s *WebDAVStorage) forgetDirs(key string) {
+	s.dirs.Range(func(dir, _ any) bool {
+		if d := dir.(string); d == key || strings.HasPrefix(d, key+"/") || strings.HasPrefix(key, d+"/") {
+			s.dirs.Delete(dir)
+		}
+		return true
+	})
+}
+
+// Exists checks if a file exists on the server
+func (s *WebDAVStorage) Exists(ctx context.Context, key string) (bool, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil, nil)
+	if err == nil {
+		resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusOK:
+			return true, nil
+		case http.StatusNotFound:
+			return false, nil
+		}
+		err = fmt.Errorf("unexpected response %s", resp.Status)
+	}
+	s.metrics.RecordError("exists", err)
+	return false, fmt.Errorf("failed to check if file exists: %w", err)
+}
+
+// propfind lists a collection, or a file or collection with a depth of 0. Collections are given with a trailing
+// slash, their own entry isn't returned for a depth of 1.
+func (s *WebDAVStorage) propfind(ctx context.Context, key, depth string) ([]webdavEntry, error) {
+	header := http.Header{
+		"Depth":        {depth},
+		"Content-Type": {"application/xml; charset=utf-8"},
+	}
+	resp, err := s.do(ctx, "PROPFIND", key, strings.NewReader(webdavPropfind), header)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, os.ErrNotExist
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, webdavError(resp)
+	}
+	defer resp.Body.Close()
+
+	var multistatus webdavMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&multistatus); err != nil {
+		return nil, fmt.Errorf("failed to decode PROPFIND response: %w", err)
+	}
+
+	self := strings.TrimSuffix(key, "/")
+	entries := make([]webdavEntry, 0, len(multistatus.Responses))
+	for _, response := range multistatus.Responses {
+		entryKey, ok := s.hrefKey(response.Href)
+		if !ok || (depth != "0" && entryKey == self) {
+			continue
+		}
+		entry := webdavEntry{Key: entryKey}
+		for _, propstat := range response.Propstat {
+			if !strings.Contains(propstat.Status, " 200 ") {
+				continue
+			}
+			prop := propstat.Prop
+			if prop.ResourceType.Collection != nil {
+				entry.Dir = true
+			}
+			if size, err := strconv.ParseInt(strings.TrimSpace(prop.ContentLength), 10, 64); err == nil {
+				entry.Size = size
+			}
+			if modified, err := http.ParseTime(strings.TrimSpace(prop.LastModified)); err == nil {
+				entry.LastModified = modified.UTC()
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// hrefKey returns the key of an href of a PROPFIND response, an absolute URL or path, false if it's outside of
+// the base collection
+func (s *WebDAVStorage) hrefKey(href string) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return "", false
+	}
+	p := strings.TrimSuffix(u.Path, "/") + "/"
+	if !strings.HasPrefix(p, s.base.Path) {
+		return "", false
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(p, s.base.Path), "/"), true
+}
+
+// walk calls fn for the files and collections whose key starts with prefix, collections after their content.
+// Only the collections that can contain matching keys are listed.
+func (s *WebDAVStorage) walk(ctx context.Context, prefix string, fn func(entry webdavEntry) error) error {
+	root := ""
+	if dir := path.Dir(prefix); prefix != "" && dir != "." {
+		root = dir
+	}
+	if _, err := s.url(root); err != nil {
+		return fmt.Errorf("invalid prefix path: %s", prefix)
+	}
+	return s.walkDir(ctx, root, prefix, fn)
+}
+
+func (s *WebDAVStorage) walkDir(ctx context.Context, dir, prefix string, fn func(entry webdavEntry) error) error {
+	key := ""
+	if dir != "" {
+		key = dir + "/"
+	}
+	entries, err := s.propfind(ctx, key, "1")
+	if err != nil {
+		// The collection doesn't exist, or was deleted meanwhile
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// Servers only list the direct members of the collection, anything else is ignored so a misbehaving
+		// server can't make the walk loop
+		if path.Dir(entry.Key) != path.Clean("./"+dir) {
+			continue
+		}
+
+		if entry.Dir {
+			if !strings.HasPrefix(entry.Key+"/", prefix) && !strings.HasPrefix(prefix, entry.Key+"/") {
+				continue
+			}
+			if err := s.walkDir(ctx, entry.Key, prefix, fn); err != nil {
+				return err
+			}
+		}
+		if strings.HasPrefix(entry.Key, prefix) {
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// List returns a page of the files whose key starts with prefix, ordered by key
+func (s *WebDAVStorage) List(ctx context.Context, prefix string, opts ListOptions) (*ListResult, error) {
+	var objects []ObjectInfo
+	err := s.walk(ctx, prefix, func(entry webdavEntry) error {
+		if !entry.Dir && entry.Key > opts.StartAfter {
+			objects = append(objects, ObjectInfo{
+				Key:          entry.Key,
+				Size:         entry.Size,
+				LastModified: entry.LastModified,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		s.metrics.RecordError("list", err)
+		return nil, fmt.Errorf("error listing files with prefix %s: %w", prefix, err)
+	}
+
+	// Collections are listed in the order of the server, which doesn't match plain key order
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+
+	result := &ListResult{Objects: objects}
+	if maxKeys := opts.maxKeys(); len(objects) > maxKeys {
+		result.Objects = objects[:maxKeys]
+		result.IsTruncated = true
+		result.NextStartAfter = result.Objects[maxKeys-1].Key
+	}
+
+	logger.Debug(s.logger, "Listed files", func() logrus.Fields {
+		return logrus.Fields{
+			"prefix": prefix,
+			"count":  len(result.Objects),
+		}
+	})
+
+	return result, nil
+}
+
+// remove deletes a file or a collection with its content, true if it existed
+func (s *WebDAVStorage) remove(ctx context.Context, key string) (bool, error) {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusAccepted:
+		resp.Body.Close()
+		return true, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return false, nil
+	}
+	return false, webdavError(resp)
+}
+
+// Delete deletes a single file
+func (s *WebDAVStorage) Delete(ctx context.Context, key string) error {
+	entries, err := s.propfind(ctx, key, "0")
+	if err == nil && len(entries) > 0 && entries[0].Dir {
+		err = fmt.Errorf("%s is a collection, use DeleteByPrefix instead", key)
+	}
+	var existed bool
+	if err == nil {
+		existed, err = s.remove(ctx, key)
+	}
+	if errors.Is(err, os.ErrNotExist) || (err == nil && !existed) {
+		return os.ErrNotExist
+	}
+	if err != nil {
+		s.metrics.RecordError("delete", err)
+		return fmt.Errorf("failed to delete file %s: %w", key, err)
+	}
+
+	if len(entries) > 0 {
+		s.metrics.SubSize(entries[0].Size)
+	}
+	s.metrics.RecordDeletion(1)
+	s.logger.WithField("key", key).Info("Deleted file from WebDAV server")
+	return nil
+}
+
+// DeleteByPrefix deletes all files with the given prefix, and the collections whose content all has the prefix
+func (s *WebDAVStorage) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
+	s.logger.WithField("prefix", prefix).Info("Deleting files by prefix")
+
+	var deleted int
+	var size int64
+	err := s.walk(ctx, prefix, func(entry webdavEntry) error {
+		if entry.Dir {
+			// The files of the collection were deleted, files uploaded meanwhile have the prefix too
+			if _, err := s.remove(ctx, entry.Key+"/"); err != nil {
+				return err
+			}
+			s.forgetDirs(entry.Key)
+			return nil
+		}
+		existed, err := s.remove(ctx, entry.Key)
+		if err != nil {
+			return err
+		}
+		if existed {
+			deleted++
+			size += entry.Size
+		}
+		return nil
+	})
+	s.metrics.SubSize(size)
+	s.metrics.RecordDeletion(deleted)
+	if err != nil {
+		s.metrics.RecordError("delete_by_prefix", err)
+		return deleted, fmt.Errorf("failed to delete files: %w", err)
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"prefix": prefix,
+		"count":  deleted,
+		"size":   size,
+	}).Info("Finished deleting files by prefix")
+
+	return deleted, nil
+}
@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/webdav"
+)
+
+// fakeWebDAV serves a directory over WebDAV under /artifactory/, with basic authentication
+type fakeWebDAV struct {
+	root   string
+	server *httptest.Server
+
+	mu      sync.Mutex
+	methods map[string]int
+}
+
+// newFakeWebDAV starts a server and returns it with the configuration of a storage of its cache collection
+func newFakeWebDAV(t *testing.T) (*fakeWebDAV, *WebDAVConfig) {
+	root := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(root, "cache"), 0o755))
+	f := &fakeWebDAV{root: root, methods: make(map[string]int)}
+	handler := &webdav.Handler{
+		Prefix:     "/artifactory",
+		FileSystem: webdav.Dir(root),
+		LockSystem: webdav.NewMemLS(),
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "cachetf" || password != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		f.mu.Lock()
+		f.methods[r.Method]++
+		f.mu.Unlock()
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(f.server.Close)
+	return f, &WebDAVConfig{URL: f.server.URL + "/artifactory/cache", Username: "cachetf", Password: "secret"}
+}
+
+// count returns the number of requests of a method
+func (f *fakeWebDAV) count(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.methods[method]
+}
+
+func newTestWebDAVStorage(t *testing.T) (*WebDAVStorage, *fakeWebDAV) {
+	server, cfg := newFakeWebDAV(t)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	s, err := NewWebDAVStorage(cfg, logger)
+	require.NoError(t, err)
+	return s, server
+}
+
+func TestWebDAVStorage(t *testing.T) {
+	s, server := newTestWebDAVStorage(t)
+	ctx := t.Context()
+	key := "registry.terraform.io/hashicorp/random/3.7.2/terraform-provider-random_3.7.2_linux_amd64.zip"
+
+	_, err := s.Get(ctx, key)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	exists, err := s.Exists(ctx, key)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	require.NoError(t, s.Put(ctx, key, strings.NewReader("provider")))
+	data, err := os.ReadFile(filepath.Join(server.root, "cache", key))
+	require.NoError(t, err)
+	assert.Equal(t, "provider", string(data))
+	assert.Equal(t, 4, server.count("MKCOL"))
+
+	exists, err = s.Exists(ctx, key)
+	require.NoError(t, err)
+	assert.True(t, exists)
+	body, err := s.Get(ctx, key)
+	require.NoError(t, err)
+	data, err = io.ReadAll(body)
+	require.NoError(t, err)
+	body.Close()
+	assert.Equal(t, "provider", string(data))
+
+	// Files are replaced, and the collections known to exist aren't created again
+	require.NoError(t, s.Put(ctx, key, strings.NewReader("provider v2")))
+	data, err = os.ReadFile(filepath.Join(server.root, "cache", key))
+	require.NoError(t, err)
+	assert.Equal(t, "provider v2", string(data))
+	assert.Equal(t, 4, server.count("MKCOL"))
+
+	// Keys are escaped
+	require.NoError(t, s.Put(ctx, "a b/c%d", strings.NewReader("escaped")))
+	data, err = os.ReadFile(filepath.Join(server.root, "cache", "a b", "c%d"))
+	require.NoError(t, err)
+	assert.Equal(t, "escaped", string(data))
+
+	require.NoError(t, s.Delete(ctx, key))
+	assert.ErrorIs(t, s.Delete(ctx, key), os.ErrNotExist)
+	assert.Error(t, s.Delete(ctx, "registry.terraform.io"), "collections are deleted by prefix")
+
+	_, err = s.Get(ctx, "../outside")
+	assert.Error(t, err)
+}
+
+func TestWebDAVStorage_SizeTracking(t *testing.T) {
+	s, _ := newTestWebDAVStorage(t)
+	assertSizeTracking(t, s, true)
+}
+
+func TestWebDAVStorage_LargeFile(t *testing.T) {
+	s, _ := newTestWebDAVStorage(t)
+	ctx := t.Context()
+
+	data := make([]byte, 5<<20+123)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	require.NoError(t, s.Put(ctx, "large", bytes.NewReader(data)))
+
+	body, err := s.Get(ctx, "large")
+	require.NoError(t, err)
+	defer body.Close()
+	read, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, data, read)
+}
+
+func TestWebDAVStorage_List(t *testing.T) {
+	s, server := newTestWebDAVStorage(t)
+	ctx := t.Context()
+	for _, key := range []string{"a/1", "a/2", "a/3", "a/x/4", "a/x/5", "ab/1", "b/1"} {
+		require.NoError(t, s.Put(ctx, key, strings.NewReader(key)))
+	}
+
+	var keys []string
+	opts := ListOptions{MaxKeys: 2}
+	for {
+		page, err := s.List(ctx, "a/", opts)
+		require.NoError(t, err)
+		for _, obj := range page.Objects {
+			keys = append(keys, obj.Key)
+			assert.Equal(t, int64(len(obj.Key)), obj.Size)
+			assert.WithinDuration(t, time.Now(), obj.LastModified, time.Minute)
+		}
+		if !page.IsTruncated {
+			break
+		}
+		opts.StartAfter = page.NextStartAfter
+	}
+	assert.Equal(t, []string{"a/1", "a/2", "a/3", "a/x/4", "a/x/5"}, keys)
+
+	page, err := s.List(ctx, "a", ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, page.Objects, 6)
+	page, err = s.List(ctx, "", ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, page.Objects, 7)
+	page, err = s.List(ctx, "missing/", ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, page.Objects)
+
+	count, err := s.DeleteByPrefix(ctx, "a/")
+	require.NoError(t, err)
+	assert.Equal(t, 5, count)
+	_, err = os.Stat(filepath.Join(server.root, "cache", "a", "x"))
+	assert.ErrorIs(t, err, os.ErrNotExist, "emptied collections are deleted")
+	for _, key := range []string{"ab/1", "b/1"} {
+		exists, err := s.Exists(ctx, key)
+		require.NoError(t, err)
+		assert.True(t, exists)
+	}
+
+	// Deleted collections are created again
+	require.NoError(t, s.Put(ctx, "a/x/6", strings.NewReader("6")))
+	exists, err := s.Exists(ctx, "a/x/6")
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+// slowReader returns a byte every few milliseconds, forever
+type slowReader struct{}
+
+func (slowReader) Read(p []byte) (int, error) {
+	time.Sleep(5 * time.Millisecond)
+	p[0] = 'x'
+	return 1, nil
+}
+
+func TestWebDAVStorage_Canceled(t *testing.T) {
+	s, server := newTestWebDAVStorage(t)
+	ctx, cancel := context.WithCancel(t.Context())
+
+	// An upload of a slow download is aborted once its context is canceled
+	done := make(chan error, 1)
+	go func() { done <- s.Put(ctx, "a/b", slowReader{}) }()
+	require.Eventually(t, func() bool { return server.count(http.MethodPut) == 1 }, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("upload wasn't aborted")
+	}
+
+	_, err := s.List(ctx, "", ListOptions{})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestNewWebDAVStorage(t *testing.T) {
+	_, cfg := newFakeWebDAV(t)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	// Rejected credentials and missing collections fail early
+	wrongPassword := *cfg
+	wrongPassword.Password = "wrong"
+	_, err := NewWebDAVStorage(&wrongPassword, logger)
+	assert.Error(t, err)
+
+	missing := *cfg
+	missing.URL += "/missing"
+	_, err = NewWebDAVStorage(&missing, logger)
+	assert.Error(t, err)
+
+	_, err = NewWebDAVStorage(&WebDAVConfig{}, logger)
+	assert.Error(t, err)
+}
+
+func TestValidateWebDAVConfig(t *testing.T) {
+	assert.NoError(t, ValidateWebDAVConfig(&WebDAVConfig{URL: "https://artifactory.example.com/artifactory/terraform"}))
+	assert.NoError(t, ValidateWebDAVConfig(&WebDAVConfig{URL: "https://dav.example.com/", Username: "cachetf", Password: "secret"}))
+	assert.NoError(t, ValidateWebDAVConfig(&WebDAVConfig{URL: "https://dav.example.com/", Token: "token"}))
+
+	assert.Error(t, ValidateWebDAVConfig(&WebDAVConfig{}))
+	assert.Error(t, ValidateWebDAVConfig(&WebDAVConfig{URL: "ftp://dav.example.com/"}))
+	assert.Error(t, ValidateWebDAVConfig(&WebDAVConfig{URL: "dav.example.com/cache"}))
+	assert.Error(t, ValidateWebDAVConfig(&WebDAVConfig{URL: "https://dav.example.com/", Username: "cachetf", Token: "token"}))
+	assert.Error(t, ValidateWebDAVConfig(&WebDAVConfig{URL: "https://dav.example.com/", Password: "secret"}))
+}
@@ -0,0 +1,331 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/metrics"
+	"cachetf/pkg/logger"
+)
+
+// WriteBehindConfig configures the write-behind uploads of a storage, see WriteBehindStorage
+type WriteBehindConfig struct {
+	// Dir is the staging directory. The files staged there survive restarts, and are uploaded on startup.
+	Dir string
+	// MaxPending bounds the files acknowledged but not uploaded yet, the files lost if the staging directory is.
+	// Writes are uploaded synchronously while it's reached.
+	MaxPending int
+	// RetryInterval is the delay before retrying a failed upload, doubled after every failure up to 5 minutes.
+	// It's a second if zero.
+	RetryInterval time.Duration
+}
+
+const (
+	// writeBehindWorkers is the number of concurrent uploads
+	writeBehindWorkers = 4
+	// writeBehindRetryInterval is the default delay before retrying a failed upload
+	writeBehindRetryInterval = time.Second
+	// writeBehindMaxRetryInterval bounds the delay between the retries of an upload
+	writeBehindMaxRetryInterval = 5 * time.Minute
+)
+
+// WriteBehindStorage acknowledges writes once the file is staged on the local disk, and uploads it to a remote
+// storage in the background, retrying until it succeeds. Cache fills don't wait for slow links to the remote
+// storage, at the price of the files not uploaded yet being only on the local disk. Staged files are served until
+// they're uploaded, listings only include them once they are.
+type WriteBehindStorage struct {
+	remote  Storage
+	staging *LocalStorage
+	// maxPending is the number of pending files above which writes are synchronous
+	maxPending int
+	// uncached are the key prefixes of mutable files, which are always written synchronously
+	uncached []string
+	logger   *logrus.Logger
+
+	queue   chan string
+	mu      sync.Mutex
+	pending map[string]bool
+
+	retryInterval    time.Duration
+	maxRetryInterval time.Duration
+	cancel           context.CancelFunc
+	wg               sync.WaitGroup
+}
+
+// NewWriteBehindStorage creates a WriteBehindStorage uploading to remote, and starts uploading the files staged
+// before a restart. Files whose key starts with one of the uncached prefixes are written synchronously, so
+// instances sharing the remote storage see the changes right away.
+func NewWriteBehindStorage(remote Storage, cfg WriteBehindConfig, uncached []string, logger *logrus.Logger) (*WriteBehindStorage, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("write-behind storage requires a staging directory")
+	}
+	if cfg.MaxPending <= 0 {
+		return nil, errors.New("write-behind storage requires a positive number of pending files")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = writeBehindRetryInterval
+	}
+
+	// Staged files are counted in the cache size once uploaded
+	staging := NewLocalStorage(cfg.Dir, logger)
+	staging.DisableSizeTracking()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &WriteBehindStorage{
+		remote:           remote,
+		staging:          staging,
+		maxPending:       cfg.MaxPending,
+		uncached:         uncached,
+		logger:           logger,
+		queue:            make(chan string, cfg.MaxPending),
+		pending:          make(map[string]bool),
+		retryInterval:    cfg.RetryInterval,
+		maxRetryInterval: writeBehindMaxRetryInterval,
+		cancel:           cancel,
+	}
+
+	// Files acknowledged before a restart are uploaded first
+	var staged []string
+	err := Walk(ctx, s.staging, "", func(obj ObjectInfo) error {
+		staged = append(staged, obj.Key)
+		return nil
+	})
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to list staged files: %w", err)
+	}
+	for _, key := range staged {
+		s.pending[key] = true
+	}
+	metrics.WriteBehindPending.Add(float64(len(staged)))
+
+	for range writeBehindWorkers {
+		s.wg.Add(1)
+		go s.work(ctx)
+	}
+	// The queue may be shorter than the staged files, which are counted as pending, so writes are synchronous
+	// until they're uploaded
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for _, key := range staged {
+			select {
+			case s.queue <- key:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	logger.WithFields(logrus.Fields{
+		"dir":        cfg.Dir,
+		"maxPending": cfg.MaxPending,
+		"staged":     len(staged),
+	}).Info("Write-behind uploads enabled")
+	return s, nil
+}
+
+// Close stops the uploads, the files not uploaded yet are uploaded on the next start
+func (s *WriteBehindStorage) Close() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// deferred returns true if the writes of the file are uploaded in the background
+func (s *WriteBehindStorage) deferred(key string) bool {
+	for _, prefix := range s.uncached {
+		if strings.HasPrefix(key, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// isPending returns true if the file is staged and not uploaded yet
+func (s *WriteBehindStorage) isPending(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pending[key]
+}
+
+// done releases the pending slot of a file
+func (s *WriteBehindStorage) done(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending[key] {
+		delete(s.pending, key)
+		metrics.WriteBehindPending.Dec()
+	}
+}
+
+// work uploads the queued files until ctx is cancelled
+func (s *WriteBehindStorage) work(ctx context.Context) {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case key := <-s.queue:
+			s.upload(ctx, key)
+		}
+	}
+}
+
+// upload uploads a staged file, retrying until it succeeds or the storage is closed
+func (s *WriteBehindStorage) upload(ctx context.Context, key string) {
+	delay := s.retryInterval
+	for attempt := 1; ; attempt++ {
+		err := s.uploadStaged(ctx, key)
+		if err == nil {
+			metrics.WriteBehindUploadsTotal.WithLabelValues("uploaded").Inc()
+			break
+		}
+		// Deleted before it was uploaded
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		// Stopped, the file stays staged
+		if ctx.Err() != nil {
+			return
+		}
+
+		metrics.WriteBehindUploadsTotal.WithLabelValues("failed").Inc()
+		s.logger.WithError(err).WithFields(logrus.Fields{
+			"key":     key,
+			"attempt": attempt,
+			"retryIn": delay,
+		}).Warn("Failed to upload staged file")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, s.maxRetryInterval)
+	}
+	s.done(key)
+}
+
+// uploadStaged uploads a staged file and removes it from the staging directory
+func (s *WriteBehindStorage) uploadStaged(ctx context.Context, key string) error {
+	r, err := s.staging.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if err := s.remote.Put(ctx, key, r); err != nil {
+		return err
+	}
+	// The remote copy is served from now on
+	if err := s.staging.Delete(ctx, key); err != nil && !errors.Is(err, os.ErrNotExist) {
+		s.logger.WithError(err).WithField("key", key).Warn("Failed to remove uploaded file from the staging directory")
+	}
+
+	logger.Debug(s.logger, "Uploaded staged file", func() logrus.Fields { return logrus.Fields{"key": key} })
+	return nil
+}
+
+// Get reads a staged file if it's not uploaded yet, from the remote storage otherwise
+func (s *WriteBehindStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if s.isPending(key) {
+		r, err := s.staging.Get(ctx, key)
+		if err == nil {
+			return r, nil
+		}
+		// Uploaded in the meantime
+		if !errors.Is(err, os.ErrNotExist) {
+			s.logger.WithError(err).WithField("key", key).Warn("Failed to read staged file")
+		}
+	}
+	return s.remote.Get(ctx, key)
+}
+
+// Put stages a file and queues its upload. The file is uploaded synchronously if too many files are pending
+// already, or the file itself is.
+func (s *WriteBehindStorage) Put(ctx context.Context, key string, r io.Reader) error {
+	if !s.deferred(key) {
+		return s.remote.Put(ctx, key, r)
+	}
+
+	s.mu.Lock()
+	if len(s.pending) >= s.maxPending || s.pending[key] {
+		s.mu.Unlock()
+		metrics.WriteBehindUploadsTotal.WithLabelValues("sync").Inc()
+		return s.remote.Put(ctx, key, r)
+	}
+	s.pending[key] = true
+	metrics.WriteBehindPending.Inc()
+	s.mu.Unlock()
+
+	if err := s.staging.Put(ctx, key, r); err != nil {
+		s.done(key)
+		return fmt.Errorf("failed to stage file: %w", err)
+	}
+	// There are fewer queued files than pending ones, the queue has room
+	s.queue <- key
+	return nil
+}
+
+// Exists returns true for staged files, and checks the remote storage for the others
+func (s *WriteBehindStorage) Exists(ctx context.Context, key string) (bool, error) {
+	if s.isPending(key) {
+		return true, nil
+	}
+	return s.remote.Exists(ctx, key)
+}
+
+// List lists the files of the remote storage, staged files are listed once uploaded
+func (s *WriteBehindStorage) List(ctx context.Context, prefix string, opts ListOptions) (*ListResult, error) {
+	return s.remote.List(ctx, prefix, opts)
+}
+
+// Delete deletes a file, staged or uploaded
+func (s *WriteBehindStorage) Delete(ctx context.Context, key string) error {
+	stagedErr := os.ErrNotExist
+	if s.isPending(key) {
+		if stagedErr = s.staging.Delete(ctx, key); stagedErr != nil && !errors.Is(stagedErr, os.ErrNotExist) {
+			return fmt.Errorf("failed to delete staged file: %w", stagedErr)
+		}
+	}
+
+	err := s.remote.Delete(ctx, key)
+	if errors.Is(err, os.ErrNotExist) && stagedErr == nil {
+		return nil
+	}
+	return err
+}
+
+// DeleteByPrefix deletes the staged and uploaded files with the prefix, returning the number of files deleted
+func (s *WriteBehindStorage) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
+	staged, err := s.staging.DeleteByPrefix(ctx, prefix)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete staged files: %w", err)
+	}
+	count, err := s.remote.DeleteByPrefix(ctx, prefix)
+	return staged + count, err
+}
+
+// PresignGet presigns the URL of an uploaded file with the remote storage. Staged files must be served.
+func (s *WriteBehindStorage) PresignGet(ctx context.Context, key, filename string, ttl time.Duration) (string, error) {
+	presigner, ok := s.remote.(Presigner)
+	if !ok {
+		return "", errors.New("the remote storage doesn't support presigned URLs")
+	}
+	if s.isPending(key) {
+		return "", fmt.Errorf("file %s isn't uploaded yet", key)
+	}
+	return presigner.PresignGet(ctx, key, filename, ttl)
+}
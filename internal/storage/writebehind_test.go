@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/metrics"
+)
+
+// flakyStorage fails the first writes, like a remote storage behind an unreliable link
+type flakyStorage struct {
+	Storage
+	failures atomic.Int32
+	// release blocks the writes until closed, if set
+	release chan struct{}
+}
+
+func (s *flakyStorage) Put(ctx context.Context, key string, r io.Reader) error {
+	if s.release != nil {
+		<-s.release
+	}
+	if s.failures.Add(-1) >= 0 {
+		return errors.New("connection reset by peer")
+	}
+	return s.Storage.Put(ctx, key, r)
+}
+
+func newTestWriteBehindStorage(t *testing.T, remote Storage, dir string, maxPending int) *WriteBehindStorage {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg := WriteBehindConfig{Dir: dir, MaxPending: maxPending, RetryInterval: time.Millisecond}
+	s, err := NewWriteBehindStorage(remote, cfg, []string{"metadata/"}, logger)
+	require.NoError(t, err)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// uploaded waits until a file is uploaded
+func uploaded(t *testing.T, s *WriteBehindStorage, key string) {
+	t.Helper()
+	assert.Eventually(t, func() bool { return !s.isPending(key) }, 5*time.Second, time.Millisecond)
+}
+
+func TestWriteBehindStorage(t *testing.T) {
+	local, _ := setupLocalStorage(t)
+	remote := &flakyStorage{Storage: local, release: make(chan struct{})}
+	remote.failures.Store(2)
+	dir := t.TempDir()
+	s := newTestWriteBehindStorage(t, remote, dir, 10)
+	ctx := context.Background()
+	key := "providers/registry.terraform.io/hashicorp/aws/5.0.0/terraform-provider-aws_5.0.0_linux_amd64.zip"
+
+	// The write is acknowledged before it's uploaded, and served from the staging directory meanwhile
+	require.NoError(t, s.Put(ctx, key, strings.NewReader("aws binary")))
+	assert.Equal(t, "aws binary", readAll(t, s, key))
+	exists, err := s.Exists(ctx, key)
+	require.NoError(t, err)
+	assert.True(t, exists)
+	_, err = s.PresignGet(ctx, key, "aws.zip", time.Minute)
+	assert.Error(t, err, "staged files can't be presigned")
+
+	// Failed uploads are retried
+	beforeFailed := testutil.ToFloat64(metrics.WriteBehindUploadsTotal.WithLabelValues("failed"))
+	beforeUploaded := testutil.ToFloat64(metrics.WriteBehindUploadsTotal.WithLabelValues("uploaded"))
+	close(remote.release)
+	uploaded(t, s, key)
+	assert.Equal(t, beforeFailed+2, testutil.ToFloat64(metrics.WriteBehindUploadsTotal.WithLabelValues("failed")))
+	assert.Equal(t, beforeUploaded+1, testutil.ToFloat64(metrics.WriteBehindUploadsTotal.WithLabelValues("uploaded")))
+	assert.Equal(t, "aws binary", readAll(t, local, key))
+	_, err = os.Stat(filepath.Join(dir, key))
+	assert.ErrorIs(t, err, os.ErrNotExist, "uploaded files are removed from the staging directory")
+	assert.Equal(t, "aws binary", readAll(t, s, key))
+
+	// Mutable files are written synchronously
+	require.NoError(t, s.Put(ctx, "metadata/index.json", strings.NewReader("{}")))
+	assert.Equal(t, "{}", readAll(t, local, "metadata/index.json"))
+
+	require.NoError(t, s.Delete(ctx, key))
+	assert.ErrorIs(t, s.Delete(ctx, key), os.ErrNotExist)
+}
+
+func TestWriteBehindStorage_SizeTracking(t *testing.T) {
+	local, _ := setupLocalStorage(t)
+	remote := &flakyStorage{Storage: local, release: make(chan struct{})}
+	s := newTestWriteBehindStorage(t, remote, t.TempDir(), 10)
+	ctx := context.Background()
+	before := testutil.ToFloat64(metrics.CacheSizeBytes)
+
+	// Staged files are counted once uploaded
+	require.NoError(t, s.Put(ctx, "a.zip", strings.NewReader("provider")))
+	assert.Equal(t, before, testutil.ToFloat64(metrics.CacheSizeBytes))
+	close(remote.release)
+	uploaded(t, s, "a.zip")
+	assert.Equal(t, before+8, testutil.ToFloat64(metrics.CacheSizeBytes))
+
+	require.NoError(t, s.Delete(ctx, "a.zip"))
+	assert.Equal(t, before, testutil.ToFloat64(metrics.CacheSizeBytes))
+}
+
+func TestWriteBehindStorage_MaxPending(t *testing.T) {
+	local, _ := setupLocalStorage(t)
+	remote := &flakyStorage{Storage: local}
+	remote.failures.Store(1 << 30)
+	s := newTestWriteBehindStorage(t, remote, t.TempDir(), 1)
+	ctx := context.Background()
+
+	require.NoError(t, s.Put(ctx, "a.zip", strings.NewReader("a")))
+
+	// Once too many files are pending, writes are synchronous and fail like the remote storage
+	before := testutil.ToFloat64(metrics.WriteBehindUploadsTotal.WithLabelValues("sync"))
+	assert.EqualError(t, s.Put(ctx, "b.zip", strings.NewReader("b")), "connection reset by peer")
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.WriteBehindUploadsTotal.WithLabelValues("sync")))
+
+	// Deleted staged files aren't uploaded
+	require.NoError(t, s.Delete(ctx, "a.zip"))
+	uploaded(t, s, "a.zip")
+	exists, err := local.Exists(ctx, "a.zip")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestWriteBehindStorage_Restart(t *testing.T) {
+	local, _ := setupLocalStorage(t)
+	remote := &flakyStorage{Storage: local}
+	remote.failures.Store(1 << 30)
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	// Files acknowledged before the restart are kept in the staging directory
+	s := newTestWriteBehindStorage(t, remote, dir, 10)
+	require.NoError(t, s.Put(ctx, "a/1.zip", strings.NewReader("1")))
+	require.NoError(t, s.Put(ctx, "a/2.zip", strings.NewReader("2")))
+	s.Close()
+
+	// and uploaded on startup
+	remote.failures.Store(0)
+	s = newTestWriteBehindStorage(t, remote, dir, 10)
+	assert.True(t, s.isPending("a/1.zip"))
+	uploaded(t, s, "a/1.zip")
+	uploaded(t, s, "a/2.zip")
+	assert.Equal(t, "1", readAll(t, local, "a/1.zip"))
+	assert.Equal(t, "2", readAll(t, local, "a/2.zip"))
+}
+
+func TestNewWriteBehindStorage(t *testing.T) {
+	local, _ := setupLocalStorage(t)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	_, err := NewWriteBehindStorage(local, WriteBehindConfig{MaxPending: 1}, nil, logger)
+	assert.Error(t, err)
+	_, err = NewWriteBehindStorage(local, WriteBehindConfig{Dir: t.TempDir()}, nil, logger)
+	assert.Error(t, err)
+}
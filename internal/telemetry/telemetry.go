@@ -0,0 +1,157 @@
+// Package telemetry reports anonymous usage statistics when explicitly enabled: the version, the storage type and
+// a coarse bucket of the cache size. Nothing identifying the instance, its users or the cached providers is sent.
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/storage"
+)
+
+// requestTimeout bounds a report, the endpoint being unreachable must not hold anything up
+const requestTimeout = 10 * time.Second
+
+// Options configures a Reporter
+type Options struct {
+	// Endpoint is the URL the reports are POSTed to
+	Endpoint string
+	// Interval is the time between reports
+	Interval time.Duration
+	// Version is the version of the server
+	Version string
+	// StorageType is the storage backend of the primary cache
+	StorageType string
+}
+
+// Report is the content of a report. It only holds aggregate values, fields must never identify the instance.
+type Report struct {
+	Version     string `json:"version"`
+	StorageType string `json:"storageType"`
+	// CacheSize is a bucket of the cache size, e.g. 10-100GiB
+	CacheSize string `json:"cacheSize"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+}
+
+// sizeBuckets are the upper bounds of the cache size buckets
+var sizeBuckets = []struct {
+	limit int64
+	label string
+}{
+	{1 << 30, "<1GiB"},
+	{10 << 30, "1-10GiB"},
+	{100 << 30, "10-100GiB"},
+	{1 << 40, "100GiB-1TiB"},
+}
+
+// SizeBucket returns the bucket of a cache size
+func SizeBucket(size int64) string {
+	for _, bucket := range sizeBuckets {
+		if size < bucket.limit {
+			return bucket.label
+		}
+	}
+	return ">1TiB"
+}
+
+// Reporter periodically sends usage statistics to the configured endpoint
+type Reporter struct {
+	storage storage.Storage
+	options Options
+	client  *http.Client
+	logger  *logrus.Logger
+}
+
+// NewReporter creates a new Reporter measuring the size of storage
+func NewReporter(storage storage.Storage, options Options, logger *logrus.Logger) *Reporter {
+	return &Reporter{
+		storage: storage,
+		options: options,
+		client:  &http.Client{Timeout: requestTimeout},
+		logger:  logger,
+	}
+}
+
+// Run sends a report every interval until ctx is cancelled
+func (r *Reporter) Run(ctx context.Context) {
+	r.logger.WithFields(logrus.Fields{
+		"endpoint": r.options.Endpoint,
+		"interval": r.options.Interval,
+	}).Info("Anonymous usage statistics enabled")
+
+	ticker := time.NewTicker(r.options.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.Send(ctx); err != nil && ctx.Err() == nil {
+			// Telemetry is best effort, failures aren't worth more than a debug message
+			r.logger.WithError(err).Debug("Failed to send usage statistics")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Collect builds the current report
+func (r *Reporter) Collect(ctx context.Context) (*Report, error) {
+	var size int64
+	err := storage.Walk(ctx, r.storage, "", func(obj storage.ObjectInfo) error {
+		size += obj.Size
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to measure the cache size: %w", err)
+	}
+
+	return &Report{
+		Version:     r.options.Version,
+		StorageType: r.options.StorageType,
+		CacheSize:   SizeBucket(size),
+		OS:          runtime.GOOS,
+		Arch:        runtime.GOARCH,
+	}, nil
+}
+
+// Send collects and sends a report
+func (r *Reporter) Send(ctx context.Context) error {
+	report, err := r.Collect(ctx)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.options.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "cachetf/"+strings.TrimPrefix(r.options.Version, "v"))
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	r.logger.WithField("report", string(body)).Debug("Sent usage statistics")
+	return nil
+}
@@ -0,0 +1,80 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/storage"
+)
+
+func TestSizeBucket(t *testing.T) {
+	tests := []struct {
+		size int64
+		want string
+	}{
+		{0, "<1GiB"},
+		{1<<30 - 1, "<1GiB"},
+		{1 << 30, "1-10GiB"},
+		{50 << 30, "10-100GiB"},
+		{500 << 30, "100GiB-1TiB"},
+		{1 << 40, ">1TiB"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, SizeBucket(tt.size), tt.size)
+	}
+}
+
+func TestReporter_Send(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	store := storage.NewLocalStorage(t.TempDir(), logger)
+	require.NoError(t, store.Put(t.Context(), "providers/registry.terraform.io/hashicorp/aws/5.0.0/aws.zip", strings.NewReader("aws binary")))
+
+	var received map[string]any
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "cachetf/1.2.3", r.UserAgent())
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer endpoint.Close()
+
+	reporter := NewReporter(store, Options{
+		Endpoint:    endpoint.URL,
+		Interval:    time.Hour,
+		Version:     "v1.2.3",
+		StorageType: "local",
+	}, logger)
+	require.NoError(t, reporter.Send(t.Context()))
+
+	// Only the aggregate values are sent, no key, host or address
+	assert.Equal(t, map[string]any{
+		"version":     "v1.2.3",
+		"storageType": "local",
+		"cacheSize":   "<1GiB",
+		"os":          runtime.GOOS,
+		"arch":        runtime.GOARCH,
+	}, received)
+}
+
+func TestReporter_SendFailure(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer endpoint.Close()
+
+	reporter := NewReporter(storage.NewLocalStorage(t.TempDir(), logger), Options{Endpoint: endpoint.URL}, logger)
+	assert.EqualError(t, reporter.Send(t.Context()), "unexpected status code: 503")
+}
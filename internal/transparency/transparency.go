@@ -0,0 +1,312 @@
+// Package transparency keeps an append-only, hash-chained log of every provider binary checksum the cache
+// has verified, to detect upstream re-publishing different bytes under the same version.
+package transparency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"cachetf/internal/metadata"
+	"cachetf/internal/metrics"
+)
+
+// documentPrefix is the metadata prefix entries are stored under, one document per entry
+const documentPrefix = "transparency/"
+
+var (
+	// ErrBrokenChain is returned when the stored log was altered
+	ErrBrokenChain = errors.New("transparency log chain is broken")
+	// ErrNoChange is returned when approving a checksum change that wasn't detected
+	ErrNoChange = errors.New("no pending checksum change")
+)
+
+// Artifact identifies a provider binary
+type Artifact struct {
+	Registry  string `json:"registry"`
+	Namespace string `json:"namespace"`
+	Provider  string `json:"provider"`
+	Version   string `json:"version"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+}
+
+// String returns the artifact as registry/namespace/provider/version/os_arch
+func (a Artifact) String() string {
+	return a.Registry + "/" + a.Namespace + "/" + a.Provider + "/" + a.Version + "/" + a.OS + "_" + a.Arch
+}
+
+// Matches returns true if every non-empty field of the filter equals the field of the artifact
+func (a Artifact) Matches(filter Artifact) bool {
+	match := func(value, want string) bool {
+		return want == "" || value == want
+	}
+	return match(a.Registry, filter.Registry) && match(a.Namespace, filter.Namespace) &&
+		match(a.Provider, filter.Provider) && match(a.Version, filter.Version) &&
+		match(a.OS, filter.OS) && match(a.Arch, filter.Arch)
+}
+
+// Entry is a checksum recorded in the log
+type Entry struct {
+	// Seq is the position of the entry in the log, starting at 1
+	Seq uint64 `json:"seq"`
+	Artifact
+	SHA256     string    `json:"sha256"`
+	RecordedAt time.Time `json:"recordedAt"`
+	// PrevHash is the hash of the previous entry, empty for the first one
+	PrevHash string `json:"prevHash"`
+	// ApprovedBy is the admin who approved a checksum change, empty for checksums recorded on download
+	ApprovedBy string `json:"approvedBy,omitempty"`
+	// Hash covers the fields of the entry and PrevHash
+	Hash string `json:"hash"`
+}
+
+// Change is a checksum differing from the ones recorded for an artifact, waiting for an admin approval
+type Change struct {
+	Artifact
+	SHA256 string `json:"sha256"`
+	// Previous holds the checksums recorded for the artifact
+	Previous   []string  `json:"previous"`
+	DetectedAt time.Time `json:"detectedAt"`
+}
+
+// computeHash returns the hash of the entry
+func (e *Entry) computeHash() string {
+	fields := []string{
+		fmt.Sprint(e.Seq),
+		e.Artifact.String(),
+		e.SHA256,
+		e.RecordedAt.UTC().Format(time.RFC3339Nano),
+		e.PrevHash,
+	}
+	if e.ApprovedBy != "" {
+		fields = append(fields, e.ApprovedBy)
+	}
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// document returns the name of the metadata document holding the entry
+func document(seq uint64) string {
+	return fmt.Sprintf("%s%012d.json", documentPrefix, seq)
+}
+
+// Log is the transparency log. Entries are kept in memory and persisted in the metadata store.
+type Log struct {
+	metadata *metadata.Store
+	logger   *logrus.Logger
+	now      func() time.Time
+
+	mu      sync.RWMutex
+	entries []Entry
+	// sums holds the checksums recorded for each artifact
+	sums map[Artifact][]string
+	// pending holds the detected checksum changes, until they are approved
+	pending map[Artifact]*Change
+}
+
+// NewLog creates an empty Log, Load reads the persisted entries
+func NewLog(metadata *metadata.Store, logger *logrus.Logger) *Log {
+	return &Log{
+		metadata: metadata,
+		logger:   logger,
+		now:      time.Now,
+		sums:     make(map[Artifact][]string),
+		pending:  make(map[Artifact]*Change),
+	}
+}
+
+// Load reads the persisted entries and verifies the hash chain, returning ErrBrokenChain if it was altered
+func (l *Log) Load(ctx context.Context) error {
+	names, err := l.metadata.List(ctx, documentPrefix)
+	if err != nil {
+		return err
+	}
+
+	entries := make([]Entry, 0, len(names))
+	sums := make(map[Artifact][]string)
+	prevHash := ""
+	for i, name := range names {
+		var entry Entry
+		if err := l.metadata.Load(ctx, name, &entry); err != nil {
+			return err
+		}
+		seq := uint64(i + 1)
+		if entry.Seq != seq || name != document(seq) || entry.PrevHash != prevHash || entry.Hash != entry.computeHash() {
+			return fmt.Errorf("%w at entry %d", ErrBrokenChain, seq)
+		}
+		entries = append(entries, entry)
+		if !slices.Contains(sums[entry.Artifact], entry.SHA256) {
+			sums[entry.Artifact] = append(sums[entry.Artifact], entry.SHA256)
+		}
+		prevHash = entry.Hash
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = entries
+	l.sums = sums
+
+	l.logger.WithField("entries", len(entries)).Info("Loaded transparency log")
+	return nil
+}
+
+// Append records the checksum of an artifact. Checksums recorded already aren't appended again.
+// A checksum differing from the ones recorded for the artifact means upstream re-published it,
+// which is logged and counted.
+func (l *Log) Append(ctx context.Context, artifact Artifact, sha256 string) (*Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.appendEntry(ctx, artifact, strings.ToLower(sha256), "")
+}
+
+// appendEntry records the checksum of an artifact, l.mu must be held
+func (l *Log) appendEntry(ctx context.Context, artifact Artifact, sha256, approvedBy string) (*Entry, error) {
+	known := l.sums[artifact]
+	if slices.Contains(known, sha256) {
+		return nil, nil
+	}
+
+	entry := Entry{
+		Seq:        uint64(len(l.entries) + 1),
+		Artifact:   artifact,
+		SHA256:     sha256,
+		RecordedAt: l.now().UTC(),
+		ApprovedBy: approvedBy,
+	}
+	if len(l.entries) > 0 {
+		entry.PrevHash = l.entries[len(l.entries)-1].Hash
+	}
+	entry.Hash = entry.computeHash()
+
+	if err := l.metadata.Save(ctx, document(entry.Seq), &entry); err != nil {
+		return nil, err
+	}
+	l.entries = append(l.entries, entry)
+	l.sums[artifact] = append(known, sha256)
+
+	if len(known) > 0 && approvedBy == "" {
+		metrics.TransparencyConflictsTotal.Inc()
+		l.logger.WithFields(logrus.Fields{
+			"artifact": artifact.String(),
+			"sha256":   sha256,
+			"previous": known,
+		}).Error("Upstream published different bytes for an artifact already recorded in the transparency log")
+	}
+	return &entry, nil
+}
+
+// Check compares the upstream checksum of an artifact to the recorded ones. It returns the change if the
+// checksum differs from all of them, and whether the change was just detected. Changes are kept pending
+// until an admin approves them.
+func (l *Log) Check(artifact Artifact, sha256 string) (*Change, bool) {
+	sha256 = strings.ToLower(sha256)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	known := l.sums[artifact]
+	if len(known) == 0 || slices.Contains(known, sha256) {
+		return nil, false
+	}
+
+	if change, ok := l.pending[artifact]; ok && change.SHA256 == sha256 {
+		return change, false
+	}
+	change := &Change{
+		Artifact:   artifact,
+		SHA256:     sha256,
+		Previous:   slices.Clone(known),
+		DetectedAt: l.now().UTC(),
+	}
+	l.pending[artifact] = change
+	metrics.ChecksumChangesTotal.Inc()
+	l.logger.WithFields(logrus.Fields{
+		"artifact": artifact.String(),
+		"sha256":   sha256,
+		"previous": known,
+	}).Error("Upstream checksum changed for a recorded artifact, refusing to cache it until approved")
+	return change, true
+}
+
+// Pending returns the checksum changes waiting for an approval
+func (l *Log) Pending() []Change {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	changes := make([]Change, 0, len(l.pending))
+	for _, change := range l.pending {
+		changes = append(changes, *change)
+	}
+	slices.SortFunc(changes, func(a, b Change) int {
+		return strings.Compare(a.Artifact.String(), b.Artifact.String())
+	})
+	return changes
+}
+
+// Approve records a pending checksum change in the log, so the artifact can be cached again.
+// It returns ErrNoChange if no change to the checksum is pending for the artifact.
+func (l *Log) Approve(ctx context.Context, artifact Artifact, sha256, principal string) (*Entry, error) {
+	sha256 = strings.ToLower(sha256)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	change, ok := l.pending[artifact]
+	if !ok || change.SHA256 != sha256 {
+		return nil, ErrNoChange
+	}
+
+	entry, err := l.appendEntry(ctx, artifact, sha256, principal)
+	if err != nil {
+		return nil, err
+	}
+	delete(l.pending, artifact)
+
+	l.logger.WithFields(logrus.Fields{
+		"artifact":  artifact.String(),
+		"sha256":    sha256,
+		"principal": principal,
+	}).Warn("Approved checksum change")
+	return entry, nil
+}
+
+// Query returns the entries whose artifact matches the filter.
+// With conflicts set, only entries of artifacts recorded with more than one checksum are returned.
+func (l *Log) Query(filter Artifact, conflicts bool) []Entry {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	entries := []Entry{}
+	for _, entry := range l.entries {
+		if !entry.Matches(filter) {
+			continue
+		}
+		if conflicts && len(l.sums[entry.Artifact]) < 2 {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// Head returns the number of entries and the hash of the last one
+func (l *Log) Head() (uint64, string) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if len(l.entries) == 0 {
+		return 0, ""
+	}
+	last := l.entries[len(l.entries)-1]
+	return last.Seq, last.Hash
+}
@@ -0,0 +1,186 @@
+package transparency
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/metadata"
+	"cachetf/internal/metrics"
+	"cachetf/internal/storage"
+)
+
+var (
+	awsLinux  = Artifact{"registry.terraform.io", "hashicorp", "aws", "5.31.0", "linux", "amd64"}
+	awsDarwin = Artifact{"registry.terraform.io", "hashicorp", "aws", "5.31.0", "darwin", "arm64"}
+)
+
+func newTestLog(t *testing.T) (*Log, storage.Storage) {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	store := storage.NewLocalStorage(t.TempDir(), logger)
+	return NewLog(metadata.NewStore(store, logger), logger), store
+}
+
+func TestLog_Append(t *testing.T) {
+	log, _ := newTestLog(t)
+	ctx := context.Background()
+
+	first, err := log.Append(ctx, awsLinux, "AAAA")
+	require.NoError(t, err)
+	require.NotNil(t, first)
+	assert.Equal(t, uint64(1), first.Seq)
+	assert.Equal(t, "aaaa", first.SHA256)
+	assert.Empty(t, first.PrevHash)
+
+	// Known checksums aren't recorded twice
+	again, err := log.Append(ctx, awsLinux, "aaaa")
+	require.NoError(t, err)
+	assert.Nil(t, again)
+
+	second, err := log.Append(ctx, awsDarwin, "bbbb")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), second.Seq)
+	assert.Equal(t, first.Hash, second.PrevHash)
+
+	seq, head := log.Head()
+	assert.Equal(t, uint64(2), seq)
+	assert.Equal(t, second.Hash, head)
+}
+
+func TestLog_Conflict(t *testing.T) {
+	log, _ := newTestLog(t)
+	logger, hook := test.NewNullLogger()
+	log.logger = logger
+	ctx := context.Background()
+
+	_, err := log.Append(ctx, awsLinux, "aaaa")
+	require.NoError(t, err)
+	_, err = log.Append(ctx, awsDarwin, "bbbb")
+	require.NoError(t, err)
+	assert.Empty(t, hook.AllEntries())
+
+	// Upstream re-published the binary with different bytes
+	before := testutil.ToFloat64(metrics.TransparencyConflictsTotal)
+	entry, err := log.Append(ctx, awsLinux, "cccc")
+	require.NoError(t, err)
+	require.NotNil(t, entry)
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.TransparencyConflictsTotal))
+	assert.Equal(t, logrus.ErrorLevel, hook.LastEntry().Level)
+	assert.Equal(t, []string{"aaaa"}, hook.LastEntry().Data["previous"])
+
+	conflicts := log.Query(Artifact{}, true)
+	require.Len(t, conflicts, 2)
+	assert.Equal(t, "aaaa", conflicts[0].SHA256)
+	assert.Equal(t, "cccc", conflicts[1].SHA256)
+}
+
+func TestLog_Query(t *testing.T) {
+	log, _ := newTestLog(t)
+	ctx := context.Background()
+
+	_, err := log.Append(ctx, awsLinux, "aaaa")
+	require.NoError(t, err)
+	_, err = log.Append(ctx, awsDarwin, "bbbb")
+	require.NoError(t, err)
+
+	assert.Len(t, log.Query(Artifact{}, false), 2)
+	assert.Len(t, log.Query(Artifact{Provider: "aws", Version: "5.31.0"}, false), 2)
+	assert.Len(t, log.Query(Artifact{OS: "darwin"}, false), 1)
+	assert.Empty(t, log.Query(Artifact{Provider: "google"}, false))
+	assert.Empty(t, log.Query(Artifact{}, true))
+}
+
+func TestLog_Load(t *testing.T) {
+	log, store := newTestLog(t)
+	ctx := context.Background()
+	log.now = func() time.Time { return time.Date(2025, 1, 2, 3, 4, 5, 6, time.UTC) }
+
+	_, err := log.Append(ctx, awsLinux, "aaaa")
+	require.NoError(t, err)
+	_, err = log.Append(ctx, awsDarwin, "bbbb")
+	require.NoError(t, err)
+
+	// The log survives a restart
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	reloaded := NewLog(metadata.NewStore(store, logger), logger)
+	require.NoError(t, reloaded.Load(ctx))
+	assert.Equal(t, log.Query(Artifact{}, false), reloaded.Query(Artifact{}, false))
+
+	entry, err := reloaded.Append(ctx, awsLinux, "aaaa")
+	require.NoError(t, err)
+	assert.Nil(t, entry, "Checksums recorded before the restart are known")
+
+	// Altering an entry breaks the chain
+	key := metadata.KeyPrefix + document(1)
+	r, err := store.Get(ctx, key)
+	require.NoError(t, err)
+	data, _ := io.ReadAll(r)
+	r.Close()
+	require.NoError(t, store.Delete(ctx, key))
+	require.NoError(t, store.Put(ctx, key, bytes.NewReader(bytes.Replace(data, []byte("aaaa"), []byte("dddd"), 1))))
+
+	tampered := NewLog(metadata.NewStore(store, logger), logger)
+	assert.ErrorIs(t, tampered.Load(ctx), ErrBrokenChain)
+
+	// So does removing one
+	require.NoError(t, store.Delete(ctx, key))
+	assert.ErrorIs(t, tampered.Load(ctx), ErrBrokenChain)
+}
+
+func TestLog_CheckAndApprove(t *testing.T) {
+	log, store := newTestLog(t)
+	ctx := context.Background()
+
+	// Unknown artifacts and recorded checksums pass
+	change, _ := log.Check(awsLinux, "aaaa")
+	assert.Nil(t, change)
+	_, err := log.Append(ctx, awsLinux, "aaaa")
+	require.NoError(t, err)
+	change, _ = log.Check(awsLinux, "AAAA")
+	assert.Nil(t, change)
+
+	// A different checksum is detected once and kept pending
+	before := testutil.ToFloat64(metrics.ChecksumChangesTotal)
+	change, detected := log.Check(awsLinux, "bbbb")
+	require.NotNil(t, change)
+	assert.True(t, detected)
+	assert.Equal(t, []string{"aaaa"}, change.Previous)
+	_, detected = log.Check(awsLinux, "bbbb")
+	assert.False(t, detected)
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.ChecksumChangesTotal))
+	assert.Equal(t, []Change{*change}, log.Pending())
+
+	// Only the pending checksum can be approved
+	_, err = log.Approve(ctx, awsLinux, "cccc", "admin")
+	assert.ErrorIs(t, err, ErrNoChange)
+	_, err = log.Approve(ctx, awsDarwin, "bbbb", "admin")
+	assert.ErrorIs(t, err, ErrNoChange)
+
+	entry, err := log.Approve(ctx, awsLinux, "bbbb", "admin")
+	require.NoError(t, err)
+	assert.Equal(t, "admin", entry.ApprovedBy)
+	assert.Empty(t, log.Pending())
+	change, _ = log.Check(awsLinux, "bbbb")
+	assert.Nil(t, change)
+
+	// Approvals are part of the chain
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	reloaded := NewLog(metadata.NewStore(store, logger), logger)
+	require.NoError(t, reloaded.Load(ctx))
+	entries := reloaded.Query(Artifact{}, true)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "admin", entries[1].ApprovedBy)
+}
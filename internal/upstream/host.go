@@ -0,0 +1,80 @@
+package upstream
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// ErrInvalidHost is returned for registry hosts that can't be contacted safely
+var ErrInvalidHost = errors.New("invalid registry host")
+
+// hostnamePattern matches DNS names made of letters, digits and hyphens
+var hostnamePattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)*[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// ValidateHost checks that a registry host, optionally with a port, is a DNS name under a
+// public suffix. IP addresses and names like localhost or internal TLDs are rejected, so
+// registry path segments can't be used to reach internal services.
+func ValidateHost(host string) error {
+	name := strings.ToLower(host)
+	if h, port, err := net.SplitHostPort(name); err == nil {
+		if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+			return fmt.Errorf("%w: invalid port in %q", ErrInvalidHost, host)
+		}
+		name = h
+	}
+
+	if net.ParseIP(name) != nil {
+		return fmt.Errorf("%w: IP addresses aren't allowed: %q", ErrInvalidHost, host)
+	}
+	if len(name) > 253 || !hostnamePattern.MatchString(name) {
+		return fmt.Errorf("%w: %q isn't a valid hostname", ErrInvalidHost, host)
+	}
+
+	if _, icann := publicsuffix.PublicSuffix(name); !icann {
+		return fmt.Errorf("%w: %q isn't under a public suffix", ErrInvalidHost, host)
+	}
+	if _, err := publicsuffix.EffectiveTLDPlusOne(name); err != nil {
+		return fmt.Errorf("%w: %q is a public suffix", ErrInvalidHost, host)
+	}
+
+	return nil
+}
+
+// isPrivateIP returns true for addresses that aren't routable on the internet
+func isPrivateIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// reservedNetworks lists the non-routable ranges not covered by the net.IP helpers
+var reservedNetworks = func() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",      // "this" network
+		"100.64.0.0/10",  // carrier-grade NAT
+		"192.0.0.0/24",   // IETF protocol assignments
+		"198.18.0.0/15",  // benchmarking
+		"240.0.0.0/4",    // reserved
+		"64:ff9b::/96",   // NAT64, embeds IPv4 addresses including private ones
+		"64:ff9b:1::/48", // local-use NAT64
+	} {
+		_, network, _ := net.ParseCIDR(cidr)
+		networks = append(networks, network)
+	}
+	return networks
+}()
@@ -0,0 +1,77 @@
+package upstream
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateHost(t *testing.T) {
+	tests := []struct {
+		host  string
+		valid bool
+	}{
+		{"registry.terraform.io", true},
+		{"registry.opentofu.org", true},
+		{"Registry.Terraform.IO", true},
+		{"registry.example.com:8443", true},
+		{"registry.example.co.uk", true},
+		{"localhost", false},
+		{"localhost:8080", false},
+		{"127.0.0.1", false},
+		{"169.254.169.254", false},
+		{"[::1]:443", false},
+		{"10.0.0.1:443", false},
+		{"metadata.google.internal", false},
+		{"registry.corp", false},
+		{"co.uk", false},
+		{"io", false},
+		{"registry.example.com:0", false},
+		{"registry.example.com:http", false},
+		{"-registry.example.com", false},
+		{"registry_example.com", false},
+		{"registry.example.com.", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			err := ValidateHost(tt.host)
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrInvalidHost)
+			}
+		})
+	}
+}
+
+func TestIsPrivateIP(t *testing.T) {
+	tests := []struct {
+		ip      string
+		private bool
+	}{
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"100.64.0.1", true},
+		{"0.0.0.0", true},
+		{"::1", true},
+		{"fd00::1", true},
+		{"fe80::1", true},
+		{"::ffff:10.0.0.1", true},
+		{"64:ff9b::a00:1", true},
+		{"64:ff9b::808:808", true},
+		{"8.8.8.8", false},
+		{"2606:4700:4700::1111", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			assert.Equal(t, tt.private, isPrivateIP(net.ParseIP(tt.ip)))
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package upstream
+
+import (
+	"io"
+	"net/http"
+	"sync"
+
+	"cachetf/internal/metrics"
+)
+
+// FetchLimiter is an http.RoundTripper limiting the number of upstream requests in flight. A request holds its
+// slot until its response body is closed, so a slow download counts until it's done. Requests over the limit
+// wait in order until a slot is free or their context is done. The limit can be changed while requests are
+// served, 0 lets every request through.
+type FetchLimiter struct {
+	next http.RoundTripper
+
+	mu     sync.Mutex
+	limit  int
+	active int
+	// waiting holds the channels of the waiting requests, closed when they're granted a slot
+	waiting []chan struct{}
+}
+
+// NewFetchLimiter returns a limiter allowing limit requests of next in flight at once
+func NewFetchLimiter(next http.RoundTripper, limit int) *FetchLimiter {
+	return &FetchLimiter{next: next, limit: max(limit, 0)}
+}
+
+// Limit returns the number of requests allowed in flight, 0 if unlimited
+func (l *FetchLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// SetLimit changes the number of requests allowed in flight. Requests over a lowered limit finish, the waiting
+// requests are let through as they do.
+func (l *FetchLimiter) SetLimit(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = max(limit, 0)
+	l.grant()
+}
+
+// RoundTrip sends the request once a slot is free
+func (l *FetchLimiter) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := l.acquire(req); err != nil {
+		return nil, err
+	}
+	resp, err := l.next.RoundTrip(req)
+	if err != nil {
+		l.release()
+		return nil, err
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, release: sync.OnceFunc(l.release)}
+	return resp, nil
+}
+
+// acquire takes a slot, waiting for one until the context of req is done
+func (l *FetchLimiter) acquire(req *http.Request) error {
+	l.mu.Lock()
+	if len(l.waiting) == 0 && (l.limit == 0 || l.active < l.limit) {
+		l.active++
+		l.mu.Unlock()
+		metrics.UpstreamFetchesInFlight.Inc()
+		return nil
+	}
+	ready := make(chan struct{})
+	l.waiting = append(l.waiting, ready)
+	l.mu.Unlock()
+
+	metrics.UpstreamFetchesWaiting.Inc()
+	defer metrics.UpstreamFetchesWaiting.Dec()
+	select {
+	case <-ready:
+		metrics.UpstreamFetchesInFlight.Inc()
+		return nil
+	case <-req.Context().Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		select {
+		case <-ready:
+			// The slot was granted meanwhile, it goes to the next request
+			l.active--
+			l.grant()
+		default:
+			for i, ch := range l.waiting {
+				if ch == ready {
+					l.waiting = append(l.waiting[:i], l.waiting[i+1:]...)
+					break
+				}
+			}
+		}
+		return req.Context().Err()
+	}
+}
+
+// release frees a slot of a finished request
+func (l *FetchLimiter) release() {
+	metrics.UpstreamFetchesInFlight.Dec()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	l.grant()
+}
+
+// grant gives the free slots to the waiting requests, the caller holds the lock
+func (l *FetchLimiter) grant() {
+	for len(l.waiting) > 0 && (l.limit == 0 || l.active < l.limit) {
+		close(l.waiting[0])
+		l.waiting = l.waiting[1:]
+		l.active++
+	}
+}
+
+// limitedBody releases the slot of its request when it's closed
+type limitedBody struct {
+	io.ReadCloser
+	release func()
+}
+
+// Close closes the body and releases the slot
+func (b *limitedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
@@ -0,0 +1,136 @@
+package upstream
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// roundTripFunc answers requests with a function
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// okTransport answers every request with an empty 200 response
+var okTransport = roundTripFunc(func(*http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+})
+
+// fetch sends a request through l in the background, the response is sent to the returned channel
+func fetch(ctx context.Context, l *FetchLimiter) <-chan *http.Response {
+	done := make(chan *http.Response, 1)
+	go func() {
+		req := httptest.NewRequestWithContext(ctx, http.MethodGet, "https://registry.terraform.io/", nil)
+		resp, err := l.RoundTrip(req)
+		if err != nil {
+			close(done)
+			return
+		}
+		done <- resp
+	}()
+	return done
+}
+
+// received returns the response sent to done, nil if there's none within a short wait
+func received(done <-chan *http.Response) *http.Response {
+	select {
+	case resp := <-done:
+		return resp
+	case <-time.After(50 * time.Millisecond):
+		return nil
+	}
+}
+
+func TestFetchLimiter(t *testing.T) {
+	l := NewFetchLimiter(okTransport, 1)
+	ctx := t.Context()
+
+	first := received(fetch(ctx, l))
+	require.NotNil(t, first)
+
+	// The slot is held until the body is closed
+	second := fetch(ctx, l)
+	assert.Nil(t, received(second))
+	require.NoError(t, first.Body.Close())
+	resp := received(second)
+	require.NotNil(t, resp)
+
+	// Closing a body twice releases one slot
+	require.NoError(t, resp.Body.Close())
+	require.NoError(t, resp.Body.Close())
+	l.mu.Lock()
+	assert.Equal(t, 0, l.active)
+	l.mu.Unlock()
+}
+
+func TestFetchLimiter_SetLimit(t *testing.T) {
+	l := NewFetchLimiter(okTransport, 1)
+	ctx := t.Context()
+
+	first := received(fetch(ctx, l))
+	require.NotNil(t, first)
+	waiting := fetch(ctx, l)
+	assert.Nil(t, received(waiting))
+
+	// Raising the limit lets the waiting requests through
+	l.SetLimit(2)
+	assert.Equal(t, 2, l.Limit())
+	second := received(waiting)
+	require.NotNil(t, second)
+
+	// Over a lowered limit, requests wait until enough requests finished
+	l.SetLimit(1)
+	waiting = fetch(ctx, l)
+	require.NoError(t, first.Body.Close())
+	assert.Nil(t, received(waiting))
+	require.NoError(t, second.Body.Close())
+	third := received(waiting)
+	require.NotNil(t, third)
+
+	// 0 removes the limit
+	l.SetLimit(0)
+	assert.NotNil(t, received(fetch(ctx, l)))
+	assert.NotNil(t, received(fetch(ctx, l)))
+}
+
+func TestFetchLimiter_Canceled(t *testing.T) {
+	l := NewFetchLimiter(okTransport, 1)
+	first := received(fetch(t.Context(), l))
+	require.NotNil(t, first)
+
+	// A request canceled while waiting gives up its place
+	ctx, cancel := context.WithCancel(t.Context())
+	canceled := fetch(ctx, l)
+	next := fetch(t.Context(), l)
+	cancel()
+	_, ok := <-canceled
+	assert.False(t, ok)
+	require.NoError(t, first.Body.Close())
+	assert.NotNil(t, received(next))
+}
+
+func TestFetchLimiter_Error(t *testing.T) {
+	failing := roundTripFunc(func(*http.Request) (*http.Response, error) {
+		return nil, context.DeadlineExceeded
+	})
+	l := NewFetchLimiter(failing, 1)
+
+	// Failed requests release their slot
+	for range 2 {
+		req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "https://registry.terraform.io/", nil)
+		_, err := l.RoundTrip(req)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	}
+	l.mu.Lock()
+	assert.Equal(t, 0, l.active)
+	l.mu.Unlock()
+}
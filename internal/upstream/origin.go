@@ -0,0 +1,58 @@
+package upstream
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// Origin describes the upstream server that answered a request, after following redirects
+type Origin struct {
+	// URL is the final URL, without its query string as it may hold signed credentials
+	URL string
+	// Host is the final host
+	Host string
+	// IP is the address of the server the response was received from, or of the proxy if one is used
+	IP string
+	// Status is the status code of the response
+	Status int
+	// Header holds the response headers, except cookies
+	Header http.Header
+}
+
+type originKey struct{}
+
+// WithOrigin returns a context recording the origin of the requests made with it into the returned Origin.
+// Each redirect overwrites it, so after the response is received it describes the server that sent it.
+func WithOrigin(ctx context.Context) (context.Context, *Origin) {
+	origin := &Origin{}
+	return context.WithValue(ctx, originKey{}, origin), origin
+}
+
+// originFromContext returns the Origin to record into, nil if the context doesn't have one
+func originFromContext(ctx context.Context) *Origin {
+	origin, _ := ctx.Value(originKey{}).(*Origin)
+	return origin
+}
+
+// stripQuery returns u without its query string and fragment
+func stripQuery(u *url.URL) string {
+	stripped := *u
+	stripped.User = nil
+	stripped.RawQuery = ""
+	stripped.Fragment = ""
+	return stripped.String()
+}
+
+// remoteIP returns the IP of a remote address in host:port form
+func remoteIP(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
@@ -0,0 +1,166 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Registry configures how a registry host is reached
+type Registry struct {
+	// Host is the registry host of the provider addresses, e.g. app.terraform.io
+	Host string
+	// BaseURL is the URL the /v1/providers/... paths of the registry API are appended to, https://<Host> if empty
+	BaseURL string
+	// Token is sent as a bearer token with the requests to the host of BaseURL, no credentials are sent if empty
+	Token string
+	// ForwardAuthorization sends the bearer token of the caller to the host of BaseURL, see WithAuthorization.
+	// Token is still sent with the requests made without a caller, e.g. prewarming.
+	ForwardAuthorization bool
+}
+
+// Registries holds the configured registry hosts and their credentials
+type Registries struct {
+	// baseURLs holds the base URL of every configured registry by lower-cased host
+	baseURLs map[string]string
+	// tokens holds the token sent to every lower-cased API host
+	tokens map[string]string
+	// forwarding holds the lower-cased registry hosts the caller's token is forwarded to
+	forwarding map[string]bool
+	// forwardingHosts holds the lower-cased API hosts of the registries forwarding the caller's token
+	forwardingHosts map[string]bool
+}
+
+// NewRegistries checks the registries and indexes them by host. The API hosts of the registries that share one,
+// e.g. repositories of the same Artifactory instance, must share the token too, as requests are authorized by host.
+func NewRegistries(registries []Registry) (*Registries, error) {
+	r := &Registries{
+		baseURLs:        make(map[string]string, len(registries)),
+		tokens:          make(map[string]string),
+		forwarding:      make(map[string]bool),
+		forwardingHosts: make(map[string]bool),
+	}
+	// apiHosts holds the registries served by every API host, which must agree on the credentials
+	apiHosts := make(map[string]Registry)
+	for _, registry := range registries {
+		host := strings.ToLower(registry.Host)
+		if host == "" || strings.Contains(host, "/") {
+			return nil, fmt.Errorf("invalid registry host %q", registry.Host)
+		}
+		if _, ok := r.baseURLs[host]; ok {
+			return nil, fmt.Errorf("duplicate registry host %q", registry.Host)
+		}
+
+		baseURL := strings.TrimSuffix(registry.BaseURL, "/")
+		if baseURL == "" {
+			baseURL = "https://" + host
+		}
+		u, err := url.Parse(baseURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("invalid base URL %q of registry %s: must be an http or https URL", registry.BaseURL, host)
+		}
+		if u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+			return nil, fmt.Errorf("invalid base URL %q of registry %s: must not have credentials, a query or a fragment", registry.BaseURL, host)
+		}
+		r.baseURLs[host] = baseURL
+
+		apiHost := strings.ToLower(u.Host)
+		if other, ok := apiHosts[apiHost]; ok && (other.Token != registry.Token || other.ForwardAuthorization != registry.ForwardAuthorization) {
+			return nil, fmt.Errorf("registry %s sends other credentials than the other registries served by %s", host, apiHost)
+		}
+		apiHosts[apiHost] = registry
+		if registry.Token == "" && !registry.ForwardAuthorization {
+			continue
+		}
+		if u.Scheme != "https" {
+			return nil, fmt.Errorf("registry %s sends credentials, its base URL must be an https URL", host)
+		}
+		if registry.Token != "" {
+			r.tokens[apiHost] = registry.Token
+		}
+		if registry.ForwardAuthorization {
+			r.forwarding[host] = true
+			r.forwardingHosts[apiHost] = true
+		}
+	}
+	return r, nil
+}
+
+// ForwardsAuthorization returns true if the bearer token of the caller is forwarded to a registry host
+func (r *Registries) ForwardsAuthorization(host string) bool {
+	if r == nil {
+		return false
+	}
+	return r.forwarding[strings.ToLower(host)]
+}
+
+type authorizationKey struct{}
+
+// WithAuthorization returns a context forwarding the Authorization header of a caller, a bearer token, with the
+// requests made with it to the registries configured with ForwardAuthorization
+func WithAuthorization(ctx context.Context, header string) context.Context {
+	return context.WithValue(ctx, authorizationKey{}, header)
+}
+
+// authorizationFromContext returns the forwarded Authorization header, empty if the context doesn't have one
+func authorizationFromContext(ctx context.Context) string {
+	header, _ := ctx.Value(authorizationKey{}).(string)
+	return header
+}
+
+// BaseURL returns the base URL of a configured registry host
+func (r *Registries) BaseURL(host string) (string, bool) {
+	if r == nil {
+		return "", false
+	}
+	baseURL, ok := r.baseURLs[strings.ToLower(host)]
+	return baseURL, ok
+}
+
+// Transport returns a transport adding the token of the request host as an Authorization header to the requests
+// sent through next, http.DefaultTransport if nil. The forwarded token of the caller, if any, is sent to the hosts
+// forwarding it instead. Requests with an Authorization header already are left alone. Redirects are sent through
+// the transport as well, so tokens are only sent to their own host over https.
+func (r *Registries) Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if r == nil || (len(r.tokens) == 0 && len(r.forwarding) == 0) {
+		return next
+	}
+	return &credentialsTransport{next: next, tokens: r.tokens, forwarding: r.forwardingHosts}
+}
+
+// credentialsTransport authorizes the requests to the hosts with a token
+type credentialsTransport struct {
+	next       http.RoundTripper
+	tokens     map[string]string
+	forwarding map[string]bool
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *credentialsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Tokens are never sent in clear text, e.g. after a redirect to http
+	if req.URL.Scheme != "https" || req.Header.Get("Authorization") != "" {
+		return t.next.RoundTrip(req)
+	}
+
+	host := strings.ToLower(req.URL.Host)
+	var header string
+	if t.forwarding[host] {
+		header = authorizationFromContext(req.Context())
+	}
+	if token, ok := t.tokens[host]; ok && header == "" {
+		header = "Bearer " + token
+	}
+	if header == "" {
+		return t.next.RoundTrip(req)
+	}
+
+	// A RoundTripper must not modify the request
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", header)
+	return t.next.RoundTrip(req)
+}
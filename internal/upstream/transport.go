@@ -0,0 +1,347 @@
+package upstream
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/http/httpproxy"
+
+	"cachetf/internal/metrics"
+)
+
+// Options configures the transport used for requests to upstream registries
+type Options struct {
+	// InsecureSkipVerify lists the hosts whose TLS certificates aren't verified.
+	// Only meant for lab registries with self-signed certificates.
+	InsecureSkipVerify []string
+	// AllowedHosts lists the hosts requests may be sent to. Entries starting with "*." match
+	// any subdomain. All hosts are allowed when it is empty.
+	AllowedHosts []string
+	// AllowPrivateNetworks allows connections to loopback, private and link-local addresses
+	AllowPrivateNetworks bool
+}
+
+var (
+	// ErrEgressDenied is returned for requests to hosts that aren't in the egress allowlist
+	ErrEgressDenied = errors.New("egress to host is not allowed")
+	// ErrPrivateAddress is returned for connections to non-routable addresses
+	ErrPrivateAddress = errors.New("connections to private addresses are not allowed")
+)
+
+// Transport is an http.RoundTripper applying per-host settings to upstream requests
+type Transport struct {
+	secure   http.RoundTripper
+	insecure http.RoundTripper
+	// insecureHosts holds the lower-cased hosts TLS verification is disabled for
+	insecureHosts map[string]bool
+	// allowedHosts holds the lower-cased egress allowlist, nil if egress isn't restricted
+	allowedHosts []string
+	// proxy returns the proxy of a request URL, from the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment
+	proxy func(*url.URL) (*url.URL, error)
+	// proxyAddrs holds the lower-cased host:port of the configured proxies, which may be private addresses
+	proxyAddrs map[string]bool
+	// lookup resolves the hosts of proxied requests, replaceable for tests
+	lookup func(ctx context.Context, host string) ([]net.IPAddr, error)
+	logger *logrus.Logger
+
+	// labelsMu guards hostLabels, the hosts that have their own metric label
+	labelsMu   sync.Mutex
+	hostLabels map[string]bool
+}
+
+// maxHostLabels bounds the number of distinct host labels of the per-upstream metrics.
+// Registry hosts come from request paths, so further hosts are reported as "other".
+const maxHostLabels = 100
+
+// NewTransport creates a new Transport. A warning is logged and the
+// upstream_tls_verification_disabled metric set for every insecure host.
+func NewTransport(opts Options, logger *logrus.Logger) *Transport {
+	t := &Transport{
+		insecureHosts: make(map[string]bool),
+		hostLabels:    make(map[string]bool),
+		proxyAddrs:    make(map[string]bool),
+		lookup:        net.DefaultResolver.LookupIPAddr,
+		logger:        logger,
+	}
+	proxyConfig := httpproxy.FromEnvironment()
+	t.proxy = proxyConfig.ProxyFunc()
+	for _, proxy := range []string{proxyConfig.HTTPProxy, proxyConfig.HTTPSProxy} {
+		if addr := proxyAddress(proxy); addr != "" {
+			t.proxyAddrs[addr] = true
+		}
+	}
+	t.secure = t.newTransport(opts)
+
+	for _, host := range opts.AllowedHosts {
+		if host = normalizeHost(host); host != "" {
+			t.allowedHosts = append(t.allowedHosts, host)
+		}
+	}
+	if len(t.allowedHosts) > 0 {
+		logger.WithField("hosts", t.allowedHosts).Info("Egress restricted to allowed upstream hosts")
+	}
+
+	for _, host := range opts.InsecureSkipVerify {
+		host = normalizeHost(host)
+		if host == "" {
+			continue
+		}
+		t.insecureHosts[host] = true
+		metrics.UpstreamTLSVerificationDisabled.WithLabelValues(host).Set(1)
+		logger.WithField("host", host).Warn("TLS certificate verification is DISABLED for upstream host, connections to it can be intercepted")
+	}
+
+	if len(t.insecureHosts) > 0 {
+		insecure := t.newTransport(opts)
+		insecure.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		t.insecure = insecure
+	}
+
+	return t
+}
+
+// newTransport returns a copy of the default transport that refuses to connect to
+// private addresses unless they're allowed. The check runs on the resolved address,
+// so DNS names pointing at internal services are caught as well. The configured proxies
+// are exempt, they're commonly on the private network, and the check applies to the
+// hosts of the proxied requests instead.
+func (t *Transport) newTransport(opts Options) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		return t.proxy(req.URL)
+	}
+	if opts.AllowPrivateNetworks {
+		return transport
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	checked := &net.Dialer{
+		Timeout:   dialer.Timeout,
+		KeepAlive: dialer.KeepAlive,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isPrivateIP(ip) {
+				return t.denyPrivate(address)
+			}
+			return nil
+		},
+	}
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		if t.proxyAddrs[strings.ToLower(address)] {
+			return dialer.DialContext(ctx, network, address)
+		}
+		return checked.DialContext(ctx, network, address)
+	}
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		proxy, err := t.proxy(req.URL)
+		if err != nil || proxy == nil {
+			return proxy, err
+		}
+		// The proxy connects to the host, so its addresses are checked before handing the request over
+		if err := t.checkProxiedHost(req.Context(), req.URL.Hostname()); err != nil {
+			return nil, err
+		}
+		return proxy, nil
+	}
+	return transport
+}
+
+// checkProxiedHost refuses requests through the proxy to hosts resolving to private addresses. Hosts that can't be
+// resolved are left to the proxy, which may have a resolver the server hasn't; registry hosts are public DNS names
+// already, see CheckRegistry.
+func (t *Transport) checkProxiedHost(ctx context.Context, host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if isPrivateIP(ip) {
+			return t.denyPrivate(host)
+		}
+		return nil
+	}
+	addrs, err := t.lookup(ctx, host)
+	if err != nil {
+		t.logger.WithError(err).WithField("host", host).Debug("Couldn't resolve proxied host, leaving it to the proxy")
+		return nil
+	}
+	for _, addr := range addrs {
+		if isPrivateIP(addr.IP) {
+			return t.denyPrivate(net.JoinHostPort(host, addr.IP.String()))
+		}
+	}
+	return nil
+}
+
+// denyPrivate records a refused connection to a private address and returns the error to fail it with
+func (t *Transport) denyPrivate(address string) error {
+	metrics.UpstreamPrivateAddressDeniedTotal.Inc()
+	t.logger.WithField("address", address).Warn("Refused outbound connection to private address")
+	return fmt.Errorf("%w: %s", ErrPrivateAddress, address)
+}
+
+// proxyAddress returns the lower-cased host:port a proxy URL is dialed at, the way net/http does, or an empty string
+// if it isn't set or can't be parsed
+func proxyAddress(proxy string) string {
+	if proxy == "" {
+		return ""
+	}
+	u, err := url.Parse(proxy)
+	if err != nil || u.Host == "" {
+		// net/http accepts proxies without a scheme, e.g. proxy.corp:3128
+		if u, err = url.Parse("http://" + proxy); err != nil || u.Host == "" {
+			return ""
+		}
+	}
+	port := u.Port()
+	if port == "" {
+		switch u.Scheme {
+		case "https":
+			port = "443"
+		case "socks5", "socks5h":
+			port = "1080"
+		default:
+			port = "80"
+		}
+	}
+	return strings.ToLower(net.JoinHostPort(u.Hostname(), port))
+}
+
+// CheckRegistry returns an error if requests to the registry host must not be made.
+// Hosts in the egress allowlist are trusted, other hosts must be public DNS names.
+func (t *Transport) CheckRegistry(host string) error {
+	if len(t.allowedHosts) > 0 {
+		if !t.Allowed(host) {
+			return fmt.Errorf("%w: %s", ErrEgressDenied, host)
+		}
+		return nil
+	}
+	return ValidateHost(host)
+}
+
+// normalizeHost lower-cases a host and strips its port, as hosts are matched regardless of the port
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return host
+}
+
+// Allowed returns true if requests may be sent to host
+func (t *Transport) Allowed(host string) bool {
+	if len(t.allowedHosts) == 0 {
+		return true
+	}
+
+	host = normalizeHost(host)
+	for _, pattern := range t.allowedHosts {
+		if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+			if strings.HasSuffix(host, suffix) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// RoundTrip implements http.RoundTripper. It is also called for every redirect,
+// so the egress allowlist applies to redirect targets as well.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := strings.ToLower(req.URL.Hostname())
+	if !t.Allowed(host) {
+		metrics.UpstreamEgressDeniedTotal.Inc()
+		t.logger.WithFields(logrus.Fields{
+			"host": host,
+			"url":  req.URL.Redacted(),
+		}).Warn("Refused outbound request to host outside of the egress allowlist")
+		return nil, fmt.Errorf("%w: %s", ErrEgressDenied, host)
+	}
+
+	transport := t.secure
+	if t.insecureHosts[host] {
+		metrics.UpstreamInsecureRequestsTotal.WithLabelValues(host).Inc()
+		transport = t.insecure
+	}
+
+	// Capture the address of the connection the request is sent on
+	var ip string
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			ip = remoteIP(info.Conn.RemoteAddr())
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	start := time.Now()
+	resp, err := transport.RoundTrip(req)
+	duration := time.Since(start)
+
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	label := t.hostLabel(host)
+	metrics.UpstreamRequestsTotal.WithLabelValues(label, status).Inc()
+	metrics.UpstreamRequestDuration.WithLabelValues(label).Observe(duration.Seconds())
+
+	// Every upstream request goes through here, only build the entry if it isn't discarded
+	if t.logger.IsLevelEnabled(logrus.DebugLevel) {
+		entry := t.logger.WithFields(logrus.Fields{
+			"host":     host,
+			"ip":       ip,
+			"url":      stripQuery(req.URL),
+			"status":   status,
+			"duration": duration,
+		})
+		if err != nil {
+			entry.WithError(err).Debug("Upstream request failed")
+		} else {
+			entry.Debug("Upstream request completed")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if origin := originFromContext(req.Context()); origin != nil {
+		origin.URL = stripQuery(req.URL)
+		origin.Host = host
+		origin.IP = ip
+		origin.Status = resp.StatusCode
+		origin.Header = resp.Header.Clone()
+		origin.Header.Del("Set-Cookie")
+	}
+	return resp, nil
+}
+
+// hostLabel returns the metric label for host, "other" once maxHostLabels hosts have been seen
+func (t *Transport) hostLabel(host string) string {
+	t.labelsMu.Lock()
+	defer t.labelsMu.Unlock()
+
+	if t.hostLabels[host] {
+		return host
+	}
+	if len(t.hostLabels) >= maxHostLabels {
+		return "other"
+	}
+	t.hostLabels[host] = true
+	return host
+}
@@ -0,0 +1,270 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cachetf/internal/metrics"
+)
+
+func TestTransport_InsecureSkipVerify(t *testing.T) {
+	// The test server uses a self-signed certificate
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+
+	t.Run("verification enabled by default", func(t *testing.T) {
+		logger, hook := test.NewNullLogger()
+		client := &http.Client{Transport: NewTransport(Options{AllowPrivateNetworks: true}, logger)}
+
+		_, err := client.Get(server.URL)
+		assert.Error(t, err, "Self-signed certificates should be rejected")
+		assert.Empty(t, hook.AllEntries())
+	})
+
+	t.Run("verification disabled for listed host", func(t *testing.T) {
+		logger, hook := test.NewNullLogger()
+		client := &http.Client{Transport: NewTransport(Options{InsecureSkipVerify: []string{"127.0.0.1:443"}, AllowPrivateNetworks: true}, logger)}
+
+		// A loud warning is logged at startup
+		require.Len(t, hook.AllEntries(), 1)
+		assert.Equal(t, logrus.WarnLevel, hook.LastEntry().Level)
+		assert.Equal(t, "127.0.0.1", hook.LastEntry().Data["host"])
+		assert.Equal(t, float64(1), testutil.ToFloat64(metrics.UpstreamTLSVerificationDisabled.WithLabelValues("127.0.0.1")))
+
+		before := testutil.ToFloat64(metrics.UpstreamInsecureRequestsTotal.WithLabelValues("127.0.0.1"))
+		resp, err := client.Get(server.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, "ok", string(body))
+		assert.Equal(t, before+1, testutil.ToFloat64(metrics.UpstreamInsecureRequestsTotal.WithLabelValues("127.0.0.1")))
+	})
+
+	t.Run("other hosts are still verified", func(t *testing.T) {
+		logger, _ := test.NewNullLogger()
+		client := &http.Client{Transport: NewTransport(Options{InsecureSkipVerify: []string{"registry.lab.internal"}, AllowPrivateNetworks: true}, logger)}
+
+		_, err := client.Get(server.URL)
+		assert.Error(t, err)
+	})
+}
+
+func TestTransport_AllowedHosts(t *testing.T) {
+	logger, _ := test.NewNullLogger()
+
+	t.Run("unrestricted by default", func(t *testing.T) {
+		transport := NewTransport(Options{}, logger)
+		assert.True(t, transport.Allowed("registry.terraform.io"))
+		assert.True(t, transport.Allowed("169.254.169.254"))
+	})
+
+	t.Run("patterns", func(t *testing.T) {
+		transport := NewTransport(Options{AllowedHosts: []string{"registry.terraform.io", "*.GitHubUserContent.com", "mirror.lab:8443"}}, logger)
+
+		tests := []struct {
+			host    string
+			allowed bool
+		}{
+			{"registry.terraform.io", true},
+			{"REGISTRY.terraform.io:443", true},
+			{"objects.githubusercontent.com", true},
+			{"githubusercontent.com", false},
+			{"evilgithubusercontent.com", false},
+			{"mirror.lab", true},
+			{"registry.terraform.io.evil.com", false},
+			{"169.254.169.254", false},
+		}
+		for _, tt := range tests {
+			assert.Equal(t, tt.allowed, transport.Allowed(tt.host), tt.host)
+		}
+	})
+}
+
+func TestTransport_EgressDenied(t *testing.T) {
+	redirected := false
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redirected = true
+	}))
+	defer internal.Close()
+
+	// The allowed server redirects to a host outside of the allowlist
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, strings.Replace(internal.URL, "127.0.0.1", "localhost", 1), http.StatusFound)
+	}))
+	defer server.Close()
+
+	logger, hook := test.NewNullLogger()
+	client := &http.Client{Transport: NewTransport(Options{AllowedHosts: []string{"127.0.0.1"}, AllowPrivateNetworks: true}, logger)}
+
+	before := testutil.ToFloat64(metrics.UpstreamEgressDeniedTotal)
+	_, err := client.Get(server.URL)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrEgressDenied)
+	assert.False(t, redirected, "The redirect target should not be contacted")
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.UpstreamEgressDeniedTotal))
+	assert.Equal(t, "localhost", hook.LastEntry().Data["host"])
+}
+
+func TestTransport_PrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+
+	logger, _ := test.NewNullLogger()
+
+	t.Run("blocked by default", func(t *testing.T) {
+		client := &http.Client{Transport: NewTransport(Options{}, logger)}
+
+		before := testutil.ToFloat64(metrics.UpstreamPrivateAddressDeniedTotal)
+		_, err := client.Get(server.URL)
+		require.Error(t, err)
+		assert.ErrorIs(t, err, ErrPrivateAddress)
+		assert.Equal(t, before+1, testutil.ToFloat64(metrics.UpstreamPrivateAddressDeniedTotal))
+
+		// Names resolving to loopback are caught after resolution
+		_, err = client.Get(strings.Replace(server.URL, "127.0.0.1", "localhost", 1))
+		assert.ErrorIs(t, err, ErrPrivateAddress)
+	})
+
+	t.Run("allowed when configured", func(t *testing.T) {
+		client := &http.Client{Transport: NewTransport(Options{AllowPrivateNetworks: true}, logger)}
+
+		resp, err := client.Get(server.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+	})
+}
+
+func TestTransport_PrivateProxy(t *testing.T) {
+	// The proxy is on a private address, it answers every proxied request itself
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		io.WriteString(w, "ok")
+	}))
+	defer proxy.Close()
+	t.Setenv("HTTP_PROXY", proxy.URL)
+	t.Setenv("NO_PROXY", "")
+
+	logger, _ := test.NewNullLogger()
+	transport := NewTransport(Options{}, logger)
+	client := &http.Client{Transport: transport}
+
+	// Requests to public hosts go through the proxy
+	transport.lookup = func(context.Context, string) ([]net.IPAddr, error) {
+		return []net.IPAddr{{IP: net.ParseIP("203.0.113.10")}}, nil
+	}
+	resp, err := client.Get("http://registry.example.com/v1/providers/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, []string{"http://registry.example.com/v1/providers/"}, proxied)
+
+	// The private address check applies to the target host instead of the proxy
+	transport.lookup = func(context.Context, string) ([]net.IPAddr, error) {
+		return []net.IPAddr{{IP: net.ParseIP("10.0.0.1")}}, nil
+	}
+	before := testutil.ToFloat64(metrics.UpstreamPrivateAddressDeniedTotal)
+	_, err = client.Get("http://internal.example.com/")
+	assert.ErrorIs(t, err, ErrPrivateAddress)
+	_, err = client.Get("http://169.254.169.254/latest/meta-data/")
+	assert.ErrorIs(t, err, ErrPrivateAddress)
+	assert.Equal(t, before+2, testutil.ToFloat64(metrics.UpstreamPrivateAddressDeniedTotal))
+	assert.Len(t, proxied, 1)
+}
+
+func TestProxyAddress(t *testing.T) {
+	assert.Equal(t, "10.0.0.5:3128", proxyAddress("http://10.0.0.5:3128"))
+	assert.Equal(t, "proxy.corp:80", proxyAddress("http://Proxy.Corp"))
+	assert.Equal(t, "proxy.corp:443", proxyAddress("https://proxy.corp"))
+	assert.Equal(t, "proxy.corp:3128", proxyAddress("proxy.corp:3128"))
+	assert.Empty(t, proxyAddress(""))
+}
+
+func TestTransport_CheckRegistry(t *testing.T) {
+	logger, _ := test.NewNullLogger()
+
+	// Without an allowlist, registries must be public DNS names
+	transport := NewTransport(Options{}, logger)
+	assert.NoError(t, transport.CheckRegistry("registry.terraform.io"))
+	assert.ErrorIs(t, transport.CheckRegistry("registry.lab"), ErrInvalidHost)
+
+	// Allowlisted hosts are trusted, everything else is denied
+	transport = NewTransport(Options{AllowedHosts: []string{"registry.lab"}}, logger)
+	assert.NoError(t, transport.CheckRegistry("registry.lab"))
+	assert.ErrorIs(t, transport.CheckRegistry("registry.terraform.io"), ErrEgressDenied)
+}
+
+func TestTransport_Origin(t *testing.T) {
+	// The download is redirected to another server, like a registry pointing at its CDN
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "binary")
+	}))
+	defer cdn.Close()
+	cdnURL := strings.Replace(cdn.URL, "127.0.0.1", "localhost", 1)
+
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, cdnURL+"/files/provider.zip?signature=secret", http.StatusFound)
+	}))
+	defer registry.Close()
+
+	logger, hook := test.NewNullLogger()
+	logger.SetLevel(logrus.DebugLevel)
+	client := &http.Client{Transport: NewTransport(Options{AllowPrivateNetworks: true}, logger)}
+
+	before := testutil.ToFloat64(metrics.UpstreamRequestsTotal.WithLabelValues("localhost", "200"))
+
+	ctx, origin := WithOrigin(context.Background())
+	req, err := http.NewRequestWithContext(ctx, "GET", registry.URL+"/download", nil)
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	// The origin describes the server that sent the file, without the signed query
+	assert.Equal(t, cdnURL+"/files/provider.zip", origin.URL)
+	assert.Equal(t, "localhost", origin.Host)
+	assert.Contains(t, []string{"127.0.0.1", "::1"}, origin.IP)
+	assert.Equal(t, http.StatusOK, origin.Status)
+	assert.Equal(t, "text/plain; charset=utf-8", origin.Header.Get("Content-Type"))
+
+	// Both hops are counted and logged
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.UpstreamRequestsTotal.WithLabelValues("localhost", "200")))
+	assert.GreaterOrEqual(t, testutil.ToFloat64(metrics.UpstreamRequestsTotal.WithLabelValues("127.0.0.1", "302")), float64(1))
+
+	entries := hook.AllEntries()
+	require.Len(t, entries, 2)
+	assert.Equal(t, "127.0.0.1", entries[0].Data["host"])
+	assert.Equal(t, "302", entries[0].Data["status"])
+	assert.Equal(t, "localhost", entries[1].Data["host"])
+	assert.Equal(t, origin.IP, entries[1].Data["ip"])
+	assert.NotContains(t, entries[1].Data["url"], "secret")
+}
+
+func TestTransport_HostLabel(t *testing.T) {
+	logger, _ := test.NewNullLogger()
+	transport := NewTransport(Options{}, logger)
+
+	for i := 0; i < maxHostLabels; i++ {
+		host := fmt.Sprintf("registry%d.example.com", i)
+		assert.Equal(t, host, transport.hostLabel(host))
+	}
+
+	// Known hosts keep their label, new ones are grouped
+	assert.Equal(t, "registry0.example.com", transport.hostLabel("registry0.example.com"))
+	assert.Equal(t, "other", transport.hostLabel("registry.attacker.example"))
+}
@@ -0,0 +1,95 @@
+package verify
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+
+	"cachetf/internal/errclass"
+)
+
+// ErrSignatureMissing is returned when verification is required but no signature or keys are available
+var ErrSignatureMissing = errors.New("signature verification required but signature or signing keys are missing")
+
+// GPGVerifier verifies SHA256SUMS files against their detached OpenPGP signature
+type GPGVerifier struct {
+	// trusted holds the keys loaded from the configured keyring, if any
+	trusted openpgp.EntityList
+	// required rejects artifacts that can't be verified at all
+	required bool
+}
+
+// NewGPGVerifier creates a new GPGVerifier. keyringFile is an optional path to an
+// ASCII-armored keyring whose keys are trusted in addition to the upstream signing keys.
+func NewGPGVerifier(keyringFile string, required bool) (*GPGVerifier, error) {
+	v := &GPGVerifier{required: required}
+
+	if keyringFile != "" {
+		data, err := os.ReadFile(keyringFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read keyring: %w", err)
+		}
+		keys, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse keyring: %w", err)
+		}
+		v.trusted = keys
+	}
+
+	return v, nil
+}
+
+// Required returns true if artifacts without a verifiable signature must be rejected
+func (v *GPGVerifier) Required() bool {
+	return v.required
+}
+
+// Verify checks the detached signature of the SHA256SUMS content against the trusted
+// keyring and the ASCII-armored upstream keys, and that the checksum file lists the
+// expected sha256 for filename.
+func (v *GPGVerifier) Verify(sums, signature []byte, upstreamKeys []string, filename, sha256sum string) error {
+	keyring := append(openpgp.EntityList{}, v.trusted...)
+	for _, armored := range upstreamKeys {
+		keys, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armored))
+		if err != nil {
+			return fmt.Errorf("failed to parse upstream signing key: %w", err)
+		}
+		keyring = append(keyring, keys...)
+	}
+
+	if len(keyring) == 0 || len(signature) == 0 {
+		if v.required {
+			return ErrSignatureMissing
+		}
+		// Nothing to verify against
+		return nil
+	}
+
+	if _, err := openpgp.CheckDetachedSignature(keyring, bytes.NewReader(sums), bytes.NewReader(signature), nil); err != nil {
+		return fmt.Errorf("signature verification failed: %w", err)
+	}
+
+	return VerifyChecksumListed(sums, filename, sha256sum)
+}
+
+// VerifyChecksumListed checks that a SHA256SUMS file lists sha256sum for filename
+func VerifyChecksumListed(sums []byte, filename, sha256sum string) error {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || fields[1] != filename {
+			continue
+		}
+		if !strings.EqualFold(fields[0], sha256sum) {
+			return fmt.Errorf("%w for %s: SHA256SUMS lists %s, registry reported %s", errclass.ErrChecksum, filename, fields[0], sha256sum)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("%s is not listed in SHA256SUMS", filename)
+}
@@ -0,0 +1,107 @@
+package verify
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestKey creates a signing key and returns it together with its armored public key
+func newTestKey(t *testing.T) (*openpgp.Entity, string) {
+	t.Helper()
+
+	entity, err := openpgp.NewEntity("cachetf test", "", "test@example.com", nil)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, entity.Serialize(w))
+	require.NoError(t, w.Close())
+
+	return entity, buf.String()
+}
+
+// sign creates a detached signature of data
+func sign(t *testing.T, entity *openpgp.Entity, data []byte) []byte {
+	t.Helper()
+
+	var sig bytes.Buffer
+	require.NoError(t, openpgp.DetachSign(&sig, entity, bytes.NewReader(data), nil))
+	return sig.Bytes()
+}
+
+func TestGPGVerifier_Verify(t *testing.T) {
+	entity, publicKey := newTestKey(t)
+	_, otherKey := newTestKey(t)
+
+	sums := []byte("abc123  terraform-provider-random_3.7.2_linux_amd64.zip\n")
+	signature := sign(t, entity, sums)
+
+	verifier, err := NewGPGVerifier("", false)
+	require.NoError(t, err)
+
+	t.Run("valid signature", func(t *testing.T) {
+		err := verifier.Verify(sums, signature, []string{publicKey}, "terraform-provider-random_3.7.2_linux_amd64.zip", "abc123")
+		assert.NoError(t, err)
+	})
+
+	t.Run("signed by unknown key", func(t *testing.T) {
+		err := verifier.Verify(sums, signature, []string{otherKey}, "terraform-provider-random_3.7.2_linux_amd64.zip", "abc123")
+		assert.ErrorContains(t, err, "signature verification failed")
+	})
+
+	t.Run("tampered checksums", func(t *testing.T) {
+		tampered := []byte("def456  terraform-provider-random_3.7.2_linux_amd64.zip\n")
+		err := verifier.Verify(tampered, signature, []string{publicKey}, "terraform-provider-random_3.7.2_linux_amd64.zip", "def456")
+		assert.ErrorContains(t, err, "signature verification failed")
+	})
+
+	t.Run("checksum mismatch", func(t *testing.T) {
+		err := verifier.Verify(sums, signature, []string{publicKey}, "terraform-provider-random_3.7.2_linux_amd64.zip", "other")
+		assert.ErrorContains(t, err, "checksum mismatch")
+	})
+
+	t.Run("file not listed", func(t *testing.T) {
+		err := verifier.Verify(sums, signature, []string{publicKey}, "terraform-provider-random_3.7.2_darwin_arm64.zip", "abc123")
+		assert.ErrorContains(t, err, "is not listed")
+	})
+
+	t.Run("no keys and not required", func(t *testing.T) {
+		err := verifier.Verify(sums, signature, nil, "terraform-provider-random_3.7.2_linux_amd64.zip", "abc123")
+		assert.NoError(t, err)
+	})
+}
+
+func TestGPGVerifier_Required(t *testing.T) {
+	verifier, err := NewGPGVerifier("", true)
+	require.NoError(t, err)
+	assert.True(t, verifier.Required())
+
+	err = verifier.Verify([]byte("sums"), nil, nil, "file.zip", "abc")
+	assert.ErrorIs(t, err, ErrSignatureMissing)
+}
+
+func TestGPGVerifier_TrustedKeyring(t *testing.T) {
+	entity, publicKey := newTestKey(t)
+
+	// Write the public key to a keyring file
+	keyringFile := filepath.Join(t.TempDir(), "keyring.asc")
+	require.NoError(t, os.WriteFile(keyringFile, []byte(publicKey), 0644))
+
+	verifier, err := NewGPGVerifier(keyringFile, true)
+	require.NoError(t, err)
+
+	sums := []byte("abc123  file.zip\n")
+	err = verifier.Verify(sums, sign(t, entity, sums), nil, "file.zip", "abc123")
+	assert.NoError(t, err)
+
+	_, err = NewGPGVerifier(filepath.Join(t.TempDir(), "missing.asc"), false)
+	assert.Error(t, err)
+}
@@ -0,0 +1,228 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/sirupsen/logrus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Supported logging backends, selected with LOG_BACKEND
+const (
+	BackendLogrus = "logrus"
+	BackendSlog   = "slog"
+	BackendZap    = "zap"
+)
+
+// Level is the severity of a log entry
+type Level int8
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+// Field is a key-value pair of a log entry
+type Field struct {
+	Key   string
+	Value any
+}
+
+// Logger writes structured log entries. Implementations adapt logrus, slog and zap.
+type Logger interface {
+	// Enabled returns true if entries of the level are written, callers check it before building fields
+	Enabled(level Level) bool
+	// Log writes an entry with the given fields
+	Log(level Level, msg string, fields ...Field)
+}
+
+// current is the logger of the process, logrus until SetBackend selects another backend
+var current Logger = NewLogrus(logrus.StandardLogger())
+
+// Default returns the logger of the process
+func Default() Logger {
+	return current
+}
+
+// SetBackend selects the backend of the process logs, writing JSON to w at the level set by InitLogger.
+// With slog or zap, the entries of the logrus loggers used throughout the code base are forwarded to the
+// backend, which encodes them instead of logrus.
+func SetBackend(backend string, w io.Writer) error {
+	l, err := newBackend(backend, w, fromLogrusLevel(logrus.GetLevel()))
+	if err != nil {
+		return err
+	}
+	current = l
+	if backend == BackendSlog || backend == BackendZap {
+		forwardLogrus(logrus.StandardLogger(), l)
+	}
+	return nil
+}
+
+// ValidateBackend checks that backend is a supported logging backend, an empty backend is logrus
+func ValidateBackend(backend string) error {
+	switch backend {
+	case "", BackendLogrus, BackendSlog, BackendZap:
+		return nil
+	}
+	return fmt.Errorf("unsupported logging backend %q: must be logrus, slog or zap", backend)
+}
+
+// newBackend creates a logger of the backend writing JSON to w
+func newBackend(backend string, w io.Writer, level Level) (Logger, error) {
+	if err := ValidateBackend(backend); err != nil {
+		return nil, err
+	}
+	switch backend {
+	case BackendSlog:
+		return NewSlog(slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slogLevels[level]}))), nil
+	case BackendZap:
+		encoder := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+		return NewZap(zap.New(zapcore.NewCore(encoder, zapcore.AddSync(w), zapLevels[level]))), nil
+	}
+	return NewLogrus(logrus.StandardLogger()), nil
+}
+
+// logrusLogger adapts a logrus logger
+type logrusLogger struct {
+	l *logrus.Logger
+}
+
+// NewLogrus returns a Logger writing to a logrus logger
+func NewLogrus(l *logrus.Logger) Logger {
+	return logrusLogger{l: l}
+}
+
+var logrusLevels = [...]logrus.Level{
+	DebugLevel: logrus.DebugLevel,
+	InfoLevel:  logrus.InfoLevel,
+	WarnLevel:  logrus.WarnLevel,
+	ErrorLevel: logrus.ErrorLevel,
+}
+
+func (a logrusLogger) Enabled(level Level) bool {
+	return a.l.IsLevelEnabled(logrusLevels[level])
+}
+
+func (a logrusLogger) Log(level Level, msg string, fields ...Field) {
+	data := make(logrus.Fields, len(fields))
+	for _, f := range fields {
+		data[f.Key] = f.Value
+	}
+	a.l.WithFields(data).Log(logrusLevels[level], msg)
+}
+
+// slogLogger adapts a slog logger
+type slogLogger struct {
+	l *slog.Logger
+}
+
+// NewSlog returns a Logger writing to a slog logger
+func NewSlog(l *slog.Logger) Logger {
+	return slogLogger{l: l}
+}
+
+var slogLevels = [...]slog.Level{
+	DebugLevel: slog.LevelDebug,
+	InfoLevel:  slog.LevelInfo,
+	WarnLevel:  slog.LevelWarn,
+	ErrorLevel: slog.LevelError,
+}
+
+func (a slogLogger) Enabled(level Level) bool {
+	return a.l.Enabled(context.Background(), slogLevels[level])
+}
+
+func (a slogLogger) Log(level Level, msg string, fields ...Field) {
+	attrs := make([]slog.Attr, len(fields))
+	for i, f := range fields {
+		attrs[i] = slog.Any(f.Key, f.Value)
+	}
+	a.l.LogAttrs(context.Background(), slogLevels[level], msg, attrs...)
+}
+
+// zapLogger adapts a zap logger
+type zapLogger struct {
+	l *zap.Logger
+}
+
+// NewZap returns a Logger writing to a zap logger
+func NewZap(l *zap.Logger) Logger {
+	return zapLogger{l: l}
+}
+
+var zapLevels = [...]zapcore.Level{
+	DebugLevel: zapcore.DebugLevel,
+	InfoLevel:  zapcore.InfoLevel,
+	WarnLevel:  zapcore.WarnLevel,
+	ErrorLevel: zapcore.ErrorLevel,
+}
+
+func (a zapLogger) Enabled(level Level) bool {
+	return a.l.Core().Enabled(zapLevels[level])
+}
+
+func (a zapLogger) Log(level Level, msg string, fields ...Field) {
+	entry := a.l.Check(zapLevels[level], msg)
+	if entry == nil {
+		return
+	}
+	zapFields := make([]zap.Field, len(fields))
+	for i, f := range fields {
+		zapFields[i] = zap.Any(f.Key, f.Value)
+	}
+	entry.Write(zapFields...)
+}
+
+// fromLogrusLevel maps a logrus level to the closest Level, trace to debug and fatal and panic to error
+func fromLogrusLevel(level logrus.Level) Level {
+	switch {
+	case level >= logrus.DebugLevel:
+		return DebugLevel
+	case level == logrus.InfoLevel:
+		return InfoLevel
+	case level == logrus.WarnLevel:
+		return WarnLevel
+	}
+	return ErrorLevel
+}
+
+// forwardHook passes the entries of a logrus logger on to another backend
+type forwardHook struct {
+	to Logger
+}
+
+func (h forwardHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h forwardHook) Fire(entry *logrus.Entry) error {
+	fields := make([]Field, 0, len(entry.Data))
+	for key, value := range entry.Data {
+		fields = append(fields, Field{Key: key, Value: value})
+	}
+	h.to.Log(fromLogrusLevel(entry.Level), entry.Message, fields...)
+	return nil
+}
+
+// discardFormatter skips the encoding of logrus entries, they are written by the hook
+type discardFormatter struct{}
+
+func (discardFormatter) Format(*logrus.Entry) ([]byte, error) {
+	return nil, nil
+}
+
+// forwardLogrus makes l only create entries, which are encoded and written by to
+func forwardLogrus(l *logrus.Logger, to Logger) {
+	// The hook of a previously selected backend is replaced
+	l.ReplaceHooks(logrus.LevelHooks{})
+	l.AddHook(forwardHook{to: to})
+	l.SetFormatter(discardFormatter{})
+	l.SetOutput(io.Discard)
+}
@@ -0,0 +1,85 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackends(t *testing.T) {
+	for _, backend := range []string{BackendSlog, BackendZap} {
+		t.Run(backend, func(t *testing.T) {
+			var buf bytes.Buffer
+			l, err := newBackend(backend, &buf, InfoLevel)
+			require.NoError(t, err)
+
+			assert.False(t, l.Enabled(DebugLevel))
+			assert.True(t, l.Enabled(InfoLevel))
+			assert.True(t, l.Enabled(ErrorLevel))
+
+			l.Log(DebugLevel, "skipped")
+			assert.Zero(t, buf.Len())
+
+			l.Log(WarnLevel, "Client error", Field{Key: "status", Value: 404}, Field{Key: "path", Value: "/x"})
+			var entry map[string]any
+			require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+			assert.Equal(t, "Client error", entry["msg"])
+			assert.Equal(t, float64(404), entry["status"])
+			assert.Equal(t, "/x", entry["path"])
+		})
+	}
+}
+
+func TestLogrusBackend(t *testing.T) {
+	var buf bytes.Buffer
+	l := logrus.New()
+	l.SetOutput(&buf)
+	l.SetFormatter(&logrus.JSONFormatter{})
+	l.SetLevel(logrus.WarnLevel)
+
+	adapter := NewLogrus(l)
+	assert.False(t, adapter.Enabled(InfoLevel))
+	assert.True(t, adapter.Enabled(WarnLevel))
+
+	adapter.Log(ErrorLevel, "Server error", Field{Key: "status", Value: 500})
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "Server error", entry["msg"])
+	assert.Equal(t, "error", entry["level"])
+	assert.Equal(t, float64(500), entry["status"])
+}
+
+func TestForwardLogrus(t *testing.T) {
+	var buf bytes.Buffer
+	to, err := newBackend(BackendZap, &buf, DebugLevel)
+	require.NoError(t, err)
+
+	l := logrus.New()
+	l.SetLevel(logrus.DebugLevel)
+	forwardLogrus(l, to)
+
+	l.WithField("key", "providers/x").Debug("Cache hit")
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "Cache hit", entry["msg"])
+	assert.Equal(t, "debug", entry["level"])
+	assert.Equal(t, "providers/x", entry["key"])
+
+	// logrus doesn't write the entries itself
+	assert.Equal(t, io.Discard, l.Out)
+}
+
+func TestValidateBackend(t *testing.T) {
+	for _, backend := range []string{"", BackendLogrus, BackendSlog, BackendZap} {
+		assert.NoError(t, ValidateBackend(backend))
+	}
+	assert.ErrorContains(t, ValidateBackend("zerolog"), `unsupported logging backend "zerolog"`)
+
+	_, err := newBackend("zerolog", io.Discard, InfoLevel)
+	assert.Error(t, err)
+}
@@ -48,3 +48,11 @@ func GetLogger(context string) *logrus.Entry {
 		"context": context,
 	})
 }
+
+// Debug logs msg at debug level with the fields returned by fields. fields is only called when debug logs are
+// enabled, so hot paths don't build the fields of entries that are discarded.
+func Debug(l *logrus.Logger, msg string, fields func() logrus.Fields) {
+	if l.IsLevelEnabled(logrus.DebugLevel) {
+		l.WithFields(fields()).Debug(msg)
+	}
+}
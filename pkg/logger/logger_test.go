@@ -8,6 +8,7 @@ import (
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // TestInitLogger tests the InitLogger function with various log levels
@@ -99,6 +100,41 @@ func TestLoggerInitialization(t *testing.T) {
 	assert.Equal(t, logrus.InfoLevel, logrus.GetLevel(), "Log level should be set to info")
 }
 
+// TestDebug tests that the fields of debug entries are only built when debug logs are enabled
+func TestDebug(t *testing.T) {
+	var buf bytes.Buffer
+	l := logrus.New()
+	l.SetOutput(&buf)
+	l.SetFormatter(&logrus.JSONFormatter{})
+
+	calls := 0
+	fields := func() logrus.Fields {
+		calls++
+		return logrus.Fields{"key": "value"}
+	}
+
+	// Discarded entries cost nothing but the level check
+	l.SetLevel(logrus.InfoLevel)
+	Debug(l, "discarded", fields)
+	assert.Equal(t, 0, calls)
+	assert.Empty(t, buf.String())
+	key := "value"
+	allocs := testing.AllocsPerRun(100, func() {
+		Debug(l, "discarded", func() logrus.Fields { return logrus.Fields{"key": key} })
+	})
+	assert.Zero(t, allocs, "Discarded entries should not allocate")
+
+	l.SetLevel(logrus.DebugLevel)
+	Debug(l, "logged", fields)
+	assert.Equal(t, 1, calls)
+
+	var logEntry map[string]interface{}
+	require.NoError(t, json.Unmarshal(bytes.TrimSpace(buf.Bytes()), &logEntry))
+	assert.Equal(t, "logged", logEntry["msg"])
+	assert.Equal(t, "value", logEntry["key"])
+	assert.Equal(t, "debug", logEntry["level"])
+}
+
 // TestMain provides setup and teardown for all tests
 func TestMain(m *testing.M) {
 	// Run tests
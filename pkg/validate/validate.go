@@ -0,0 +1,184 @@
+// Package validate checks the registry, namespace, provider, version and platform segments of the cached
+// artifacts. The routes, the handlers and the admin API all validate through it, so a value accepted by one code
+// path is accepted by the others.
+package validate
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	// namespacePattern matches namespaces, DNS labels of letters, digits and inner hyphens
+	namespacePattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?$`)
+	// providerPattern matches provider types, lowercase letters, digits and inner hyphens
+	providerPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+	// versionPattern matches semantic versions, with optional pre-release and build suffixes
+	versionPattern = regexp.MustCompile(`^\d+\.\d+\.\d+(-[a-zA-Z0-9.+-]+)?(\+[a-zA-Z0-9.+-]+)?$`)
+	// moduleNamePattern matches module names and target systems as accepted by the module registry
+	moduleNamePattern = regexp.MustCompile(`^[0-9A-Za-z](?:[0-9A-Za-z_-]{0,62}[0-9A-Za-z])?$`)
+	// platformPattern matches the operating systems and architectures rules can list
+	platformPattern = regexp.MustCompile(`^[a-z0-9]+$`)
+)
+
+// DefaultOS are the operating systems Terraform providers are commonly released for
+var DefaultOS = []string{"darwin", "freebsd", "linux", "openbsd", "solaris", "windows"}
+
+// DefaultArch are the architectures Terraform providers are commonly released for
+var DefaultArch = []string{"386", "amd64", "arm", "arm64", "ppc64le"}
+
+// Rules configures the values that are accepted where they differ between deployments
+type Rules struct {
+	// OS lists the accepted operating systems, DefaultOS if empty
+	OS []string
+	// Arch lists the accepted architectures, DefaultArch if empty
+	Arch []string
+}
+
+// Validate checks that the listed platforms are lowercase letters and digits, as they're part of file names
+// split on underscores
+func (r Rules) Validate() error {
+	for _, osName := range r.OS {
+		if !platformPattern.MatchString(osName) {
+			return fmt.Errorf("invalid operating system %q: must be lowercase letters and digits", osName)
+		}
+	}
+	for _, arch := range r.Arch {
+		if !platformPattern.MatchString(arch) {
+			return fmt.Errorf("invalid architecture %q: must be lowercase letters and digits", arch)
+		}
+	}
+	return nil
+}
+
+// Validator validates the segments with a set of rules
+type Validator struct {
+	os   map[string]bool
+	arch map[string]bool
+}
+
+// New returns a validator applying rules, which must be valid
+func New(rules Rules) *Validator {
+	if len(rules.OS) == 0 {
+		rules.OS = DefaultOS
+	}
+	if len(rules.Arch) == 0 {
+		rules.Arch = DefaultArch
+	}
+	v := &Validator{os: make(map[string]bool), arch: make(map[string]bool)}
+	for _, osName := range rules.OS {
+		v.os[osName] = true
+	}
+	for _, arch := range rules.Arch {
+		v.arch[arch] = true
+	}
+	return v
+}
+
+// Registry returns true for registry hosts, which aren't empty and can't contain spaces, slashes or backslashes.
+// Hosts can't start with an underscore either, the key segments of the layouts do, e.g. the _tenants of shared
+// buckets. Whether the host may be reached is up to the host policy.
+func (v *Validator) Registry(registry string) bool {
+	return registry != "" && registry != "." && registry != ".." && !strings.HasPrefix(registry, "_") &&
+		!strings.ContainsAny(registry, " /\\")
+}
+
+// Namespace returns true for provider and module namespaces
+func (v *Validator) Namespace(namespace string) bool {
+	return namespacePattern.MatchString(namespace)
+}
+
+// Provider returns true for provider types
+func (v *Validator) Provider(provider string) bool {
+	return providerPattern.MatchString(provider)
+}
+
+// Version returns true for provider and module versions
+func (v *Validator) Version(version string) bool {
+	return versionPattern.MatchString(version)
+}
+
+// ModuleName returns true for module names and target systems
+func (v *Validator) ModuleName(name string) bool {
+	return moduleNamePattern.MatchString(name)
+}
+
+// OS returns true for the accepted operating systems
+func (v *Validator) OS(osName string) bool {
+	return v.os[osName]
+}
+
+// Arch returns true for the accepted architectures
+func (v *Validator) Arch(arch string) bool {
+	return v.arch[arch]
+}
+
+// ProviderPath checks the leading segments of registry/namespace/provider/version, returning an error naming the
+// first invalid one
+func (v *Validator) ProviderPath(segments ...string) error {
+	checks := []struct {
+		name  string
+		valid func(string) bool
+	}{
+		{"registry", v.Registry},
+		{"namespace", v.Namespace},
+		{"provider", v.Provider},
+		{"version", v.Version},
+	}
+	if len(segments) > len(checks) {
+		return fmt.Errorf("expected at most %d segments, got %d", len(checks), len(segments))
+	}
+	for i, segment := range segments {
+		if !checks[i].valid(segment) {
+			return fmt.Errorf("invalid %s %q", checks[i].name, segment)
+		}
+	}
+	return nil
+}
+
+// defaultValidator is used by the package functions
+var defaultValidator atomic.Pointer[Validator]
+
+func init() {
+	defaultValidator.Store(New(Rules{}))
+}
+
+// Configure makes the package functions apply rules. It's called once at startup, before serving requests.
+func Configure(rules Rules) error {
+	if err := rules.Validate(); err != nil {
+		return err
+	}
+	defaultValidator.Store(New(rules))
+	return nil
+}
+
+// Default returns the validator of the package functions
+func Default() *Validator {
+	return defaultValidator.Load()
+}
+
+// Registry validates a registry host with the configured rules
+func Registry(registry string) bool { return Default().Registry(registry) }
+
+// Namespace validates a namespace with the configured rules
+func Namespace(namespace string) bool { return Default().Namespace(namespace) }
+
+// Provider validates a provider type with the configured rules
+func Provider(provider string) bool { return Default().Provider(provider) }
+
+// Version validates a version with the configured rules
+func Version(version string) bool { return Default().Version(version) }
+
+// ModuleName validates a module name or target system with the configured rules
+func ModuleName(name string) bool { return Default().ModuleName(name) }
+
+// OS validates an operating system with the configured rules
+func OS(osName string) bool { return Default().OS(osName) }
+
+// Arch validates an architecture with the configured rules
+func Arch(arch string) bool { return Default().Arch(arch) }
+
+// ProviderPath validates the leading segments of registry/namespace/provider/version with the configured rules
+func ProviderPath(segments ...string) error { return Default().ProviderPath(segments...) }
@@ -0,0 +1,131 @@
+package validate
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidator(t *testing.T) {
+	v := New(Rules{})
+
+	tests := []struct {
+		name   string
+		valid  func(string) bool
+		accept []string
+		refuse []string
+	}{
+		{
+			name:   "registry",
+			valid:  v.Registry,
+			accept: []string{"registry.terraform.io", "registry.opentofu.org", "localhost:8443", "10.0.0.1", "Registry.Example.COM"},
+			refuse: []string{"", ".", "..", "registry terraform.io", "registry/terraform.io", "registry\\terraform.io", "_tenants", "_registry.example.com"},
+		},
+		{
+			name:   "namespace",
+			valid:  v.Namespace,
+			accept: []string{"hashicorp", "HashiCorp", "a", "0", "my-org", "org-2"},
+			refuse: []string{"", "-org", "org-", "my_org", "my.org", "my org", "..", "org/name"},
+		},
+		{
+			name:   "provider",
+			valid:  v.Provider,
+			accept: []string{"aws", "random", "a", "google-beta", "k8s"},
+			refuse: []string{"", "AWS", "-aws", "aws-", "aws_v2", "aws.v2", "..", "aws/v2"},
+		},
+		{
+			name:  "version",
+			valid: v.Version,
+			accept: []string{
+				"1.2.3", "0.0.0", "10.20.30", "1.0.0-beta1", "1.0.0-rc.1", "1.0.0+build.5", "1.0.0-alpha+001",
+				"1.0.0-x-y-z",
+			},
+			refuse: []string{"", "1", "1.2", "v1.2.3", "1.2.3.4", "1.2.3-", "1.2.3-beta_1", "1.2.3/..", "latest"},
+		},
+		{
+			name:   "module name",
+			valid:  v.ModuleName,
+			accept: []string{"vpc", "consul", "aws", "my_module", "my-module", "A1", strings.Repeat("a", 64)},
+			refuse: []string{"", "_vpc", "vpc_", "-vpc", "my.module", "my module", "..", strings.Repeat("a", 65)},
+		},
+		{
+			name:   "os",
+			valid:  v.OS,
+			accept: DefaultOS,
+			refuse: []string{"", "Linux", "netbsd", "linux_amd64", "plan9"},
+		},
+		{
+			name:   "arch",
+			valid:  v.Arch,
+			accept: DefaultArch,
+			refuse: []string{"", "AMD64", "riscv64", "s390x", "x86_64"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, value := range tt.accept {
+				assert.True(t, tt.valid(value), "%q should be valid", value)
+			}
+			for _, value := range tt.refuse {
+				assert.False(t, tt.valid(value), "%q should be invalid", value)
+			}
+		})
+	}
+}
+
+func TestValidator_Rules(t *testing.T) {
+	// Configured platforms replace the defaults
+	v := New(Rules{OS: []string{"linux", "netbsd"}, Arch: []string{"riscv64"}})
+	assert.True(t, v.OS("netbsd"))
+	assert.False(t, v.OS("darwin"))
+	assert.True(t, v.Arch("riscv64"))
+	assert.False(t, v.Arch("amd64"))
+
+	// An empty list keeps the defaults
+	v = New(Rules{OS: []string{"netbsd"}})
+	assert.True(t, v.Arch("amd64"))
+}
+
+func TestRules_Validate(t *testing.T) {
+	assert.NoError(t, Rules{}.Validate())
+	assert.NoError(t, Rules{OS: []string{"linux", "netbsd"}, Arch: []string{"amd64", "riscv64"}}.Validate())
+	assert.ErrorContains(t, Rules{OS: []string{"Linux"}}.Validate(), "invalid operating system")
+	assert.ErrorContains(t, Rules{Arch: []string{"x86_64"}}.Validate(), "invalid architecture")
+	assert.ErrorContains(t, Rules{Arch: []string{""}}.Validate(), "invalid architecture")
+}
+
+func TestValidator_ProviderPath(t *testing.T) {
+	v := New(Rules{})
+	assert.NoError(t, v.ProviderPath())
+	assert.NoError(t, v.ProviderPath("registry.terraform.io"))
+	assert.NoError(t, v.ProviderPath("registry.terraform.io", "hashicorp", "aws", "5.0.0"))
+
+	assert.EqualError(t, v.ProviderPath(".."), `invalid registry ".."`)
+	assert.EqualError(t, v.ProviderPath("registry.terraform.io", "-x"), `invalid namespace "-x"`)
+	assert.EqualError(t, v.ProviderPath("registry.terraform.io", "hashicorp", "AWS"), `invalid provider "AWS"`)
+	assert.EqualError(t, v.ProviderPath("registry.terraform.io", "hashicorp", "aws", "latest"), `invalid version "latest"`)
+	assert.Error(t, v.ProviderPath("registry.terraform.io", "hashicorp", "aws", "5.0.0", "file"))
+}
+
+func TestConfigure(t *testing.T) {
+	t.Cleanup(func() { require.NoError(t, Configure(Rules{})) })
+
+	assert.False(t, OS("netbsd"))
+	require.NoError(t, Configure(Rules{OS: []string{"linux", "netbsd"}}))
+	assert.True(t, OS("netbsd"))
+	assert.True(t, Arch("amd64"))
+
+	// Invalid rules leave the configured ones in place
+	assert.Error(t, Configure(Rules{OS: []string{"net_bsd"}}))
+	assert.True(t, OS("netbsd"))
+
+	assert.True(t, Registry("registry.terraform.io"))
+	assert.True(t, Namespace("hashicorp"))
+	assert.True(t, Provider("aws"))
+	assert.True(t, Version("1.2.3"))
+	assert.True(t, ModuleName("vpc"))
+	assert.NoError(t, ProviderPath("registry.terraform.io", "hashicorp"))
+}